@@ -4,7 +4,10 @@
 // Package apitype contains types for the Tailscale LocalAPI and control plane API.
 package apitype
 
-import "tailscale.com/tailcfg"
+import (
+	"tailscale.com/tailcfg"
+	"tailscale.com/version"
+)
 
 // LocalAPIHost is the Host header value used by the LocalAPI.
 const LocalAPIHost = "local-tailscaled.sock"
@@ -57,3 +60,36 @@ type ExitNodeSuggestionResponse struct {
 	Name     string
 	Location tailcfg.LocationView `json:",omitempty"`
 }
+
+// VersionResponse is the response to a LocalAPI version GET request.
+type VersionResponse struct {
+	version.Meta
+
+	// Features reports which optional features the daemon was built
+	// with or is running with, keyed by name (e.g. "ssh", "netstack").
+	Features map[string]bool `json:"features"`
+}
+
+// DoctorSeverity is how urgently a DoctorFinding needs attention.
+type DoctorSeverity string
+
+const (
+	DoctorError   DoctorSeverity = "error"   // Tailscale is broken, or about to be
+	DoctorWarning DoctorSeverity = "warning" // Tailscale works, but not as well as it could
+	DoctorInfo    DoctorSeverity = "info"
+)
+
+// DoctorFinding is a problem found by one of the checks behind the LocalAPI
+// doctor endpoint.
+type DoctorFinding struct {
+	Check    string // name of the check, in lower-kebab-case
+	Severity DoctorSeverity
+	Summary  string // what's wrong
+	Fix      string `json:",omitempty"` // what the user can do about it, if anything
+}
+
+// DoctorResponse is the response to a LocalAPI doctor GET request.
+type DoctorResponse struct {
+	Checks   []string        // names of the checks that ran
+	Findings []DoctorFinding // most severe first
+}
@@ -33,12 +33,14 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/sockstats"
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
 	"tailscale.com/types/key"
 	"tailscale.com/types/tkatype"
+	"tailscale.com/util/crashlog"
 )
 
 // defaultLocalClient is the default LocalClient when using the legacy
@@ -291,6 +293,28 @@ func (lc *LocalClient) Goroutines(ctx context.Context) ([]byte, error) {
 	return lc.get200(ctx, "/localapi/v0/goroutines")
 }
 
+// Crashes returns the crash reports the Tailscale daemon saved from its
+// previous runs, oldest first. The capturing result reports whether the
+// daemon captures the crash output of its current run, which needs it to
+// be built with Go 1.23 or later.
+func (lc *LocalClient) Crashes(ctx context.Context) (crashes []crashlog.Crash, capturing bool, err error) {
+	body, h, err := lc.sendWithHeaders(ctx, "GET", "/localapi/v0/crashes", 200, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	crashes, err = decodeJSON[[]crashlog.Crash](body)
+	if err != nil {
+		return nil, false, err
+	}
+	return crashes, h.Get("Tailscale-Crash-Capture") != "unsupported", nil
+}
+
+// CrashReport returns the contents of the named crash report, as returned
+// by Crashes.
+func (lc *LocalClient) CrashReport(ctx context.Context, name string) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/crashes?name="+url.QueryEscape(name))
+}
+
 // DaemonMetrics returns the Tailscale daemon's metrics in
 // the Prometheus text exposition format.
 func (lc *LocalClient) DaemonMetrics(ctx context.Context) ([]byte, error) {
@@ -699,6 +723,38 @@ func (lc *LocalClient) CheckUDPGROForwarding(ctx context.Context) error {
 	return nil
 }
 
+// FixIPForwarding asks the local Tailscale daemon to set and persist the
+// sysctls that CheckIPForwarding and the reverse path filtering checks
+// would warn about, for the routes that its current prefs advertise, and
+// returns the changes that it made. It's only supported on Linux.
+func (lc *LocalClient) FixIPForwarding(ctx context.Context) ([]netutil.SysctlFix, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/fix-ip-forwarding", 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]netutil.SysctlFix](body)
+}
+
+// Version returns the daemon's version and the optional features it
+// supports.
+func (lc *LocalClient) Version(ctx context.Context) (*apitype.VersionResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/version")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.VersionResponse](body)
+}
+
+// Doctor runs tailscaled's checks for common problems and returns what they
+// found, most severe first.
+func (lc *LocalClient) Doctor(ctx context.Context) (*apitype.DoctorResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/doctor")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.DoctorResponse](body)
+}
+
 // CheckPrefs validates the provided preferences, without making any changes.
 //
 // The CLI uses this before a Start call to fail fast if the preferences won't
@@ -1161,6 +1217,34 @@ func (lc *LocalClient) SetServeConfig(ctx context.Context, config *ipn.ServeConf
 	return nil
 }
 
+// FilterAlertRules returns the rules for inbound connection attempts that
+// tailscaled drops and reports.
+func (lc *LocalClient) FilterAlertRules(ctx context.Context) ([]ipn.FilterAlertRule, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/filter-alert-rules")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipn.FilterAlertRule](body)
+}
+
+// SetFilterAlertRules replaces the rules for inbound connection attempts
+// that tailscaled drops and reports.
+func (lc *LocalClient) SetFilterAlertRules(ctx context.Context, rules []ipn.FilterAlertRule) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/filter-alert-rules", 200, jsonBody(rules))
+	return err
+}
+
+// FilterAlerts returns the most recent inbound connection attempts that
+// matched a filter alert rule, oldest first. New ones are also sent on
+// the IPN bus as Notify.FilterAlert.
+func (lc *LocalClient) FilterAlerts(ctx context.Context) ([]ipn.FilterAlert, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/filter-alerts")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipn.FilterAlert](body)
+}
+
 // NetworkLockDisable shuts down network-lock across the tailnet.
 func (lc *LocalClient) NetworkLockDisable(ctx context.Context, secret []byte) error {
 	if _, err := lc.send(ctx, "POST", "/localapi/v0/tka/disable", 200, bytes.NewReader(secret)); err != nil {
@@ -1332,6 +1416,17 @@ func (lc *LocalClient) DebugDERPRegion(ctx context.Context, regionIDOrCode strin
 	return decodeJSON[*ipnstate.DebugDERPRegionReport](body)
 }
 
+// DebugSockStats returns the per-subsystem socket statistics collected by
+// tailscaled. It returns an error if the daemon was not built with socket
+// statistics support.
+func (lc *LocalClient) DebugSockStats(ctx context.Context) (*sockstats.Report, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-sockstats")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*sockstats.Report](body)
+}
+
 // DebugPacketFilterRules returns the packet filter rules for the current device.
 func (lc *LocalClient) DebugPacketFilterRules(ctx context.Context) ([]tailcfg.FilterRule, error) {
 	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-packet-filter-rules", 200, nil)
@@ -52,6 +52,8 @@
 	runSTUN     = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
 	runDERP     = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
 
+	healthzInterval = flag.Duration("healthz-probe-interval", 30*time.Second, "how often derper probes its own STUN and DERP listeners and TLS certificate for /healthz; 0 disables self-probing")
+
 	meshPSKFile     = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It should contain some hex string; whitespace is trimmed.")
 	meshWith        = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list")
 	bootstrapDNS    = flag.String("bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns")
@@ -145,7 +147,7 @@ func main() {
 		tsweb.DevMode = true
 	}
 
-	listenHost, _, err := net.SplitHostPort(*addr)
+	listenHost, listenPort, err := net.SplitHostPort(*addr)
 	if err != nil {
 		log.Fatalf("invalid server address: %v", err)
 	}
@@ -197,6 +199,24 @@ func main() {
 	mux.HandleFunc("/derp/probe", derphttp.ProbeHandler)
 	mux.HandleFunc("/derp/latency-check", derphttp.ProbeHandler)
 
+	prober := &selfProber{
+		interval:      *healthzInterval,
+		serveTLS:      serveTLS,
+		serverName:    *hostname,
+		fullHandshake: !*verifyClients && *verifyClientURL == "",
+		logf:          log.Printf,
+	}
+	if *runSTUN {
+		prober.stunAddr = loopbackAddr(listenHost, fmt.Sprint(*stunPort))
+	}
+	if *runDERP {
+		prober.derpAddr = loopbackAddr(listenHost, cmp.Or(listenPort, "443"))
+	}
+	if *healthzInterval > 0 {
+		go prober.run(ctx)
+	}
+	mux.Handle("/healthz", prober)
+
 	go refreshBootstrapDNSLoop()
 	mux.HandleFunc("/bootstrap-dns", tsweb.BrowserHeaderHandlerFunc(handleBootstrapDNS))
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,336 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/net/stun"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+const (
+	// selfProbeTimeout bounds each individual self-probe.
+	selfProbeTimeout = 5 * time.Second
+
+	// certExpiryMin is how far in the future the served TLS certificate
+	// must expire for the cert check to pass. It's well under Let's
+	// Encrypt's 30-day renewal window, so failing it means renewal is
+	// broken.
+	certExpiryMin = 7 * 24 * time.Hour
+)
+
+// selfCheck is the most recent result of one of derper's self-probes.
+type selfCheck struct {
+	Name    string        `json:"name"`
+	OK      bool          `json:"ok"`
+	Error   string        `json:"error,omitempty"`
+	Detail  string        `json:"detail,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Checked time.Time     `json:"checked"`
+}
+
+// selfProber periodically checks that this derper's own STUN and DERP
+// listeners answer, and that its TLS certificate isn't about to expire.
+// Results are served at /healthz so that load balancers and monitoring
+// can take a broken relay out of rotation.
+type selfProber struct {
+	interval   time.Duration
+	stunAddr   string // or empty to not probe STUN
+	derpAddr   string // or empty to not probe DERP
+	serveTLS   bool
+	serverName string // TLS SNI for derpAddr
+	// fullHandshake is whether the DERP probe waits for the server's
+	// ServerInfo frame. It can't when the server verifies clients, as
+	// the prober's throwaway key would be rejected.
+	fullHandshake bool
+	logf          logger.Logf
+
+	mu     sync.Mutex
+	checks map[string]selfCheck // keyed by selfCheck.Name
+}
+
+// run probes every p.interval until ctx is done.
+func (p *selfProber) run(ctx context.Context) {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		p.probeOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (p *selfProber) probeOnce(ctx context.Context) {
+	if p.stunAddr != "" {
+		p.record(ctx, "stun", p.probeSTUN)
+	}
+	if p.derpAddr != "" {
+		var certExpiry time.Time
+		p.record(ctx, "derp", func(ctx context.Context) (string, error) {
+			detail, notAfter, err := p.probeDERP(ctx)
+			certExpiry = notAfter
+			return detail, err
+		})
+		if p.serveTLS && !certExpiry.IsZero() {
+			p.record(ctx, "cert", func(context.Context) (string, error) {
+				return checkCertExpiry(certExpiry, time.Now())
+			})
+		}
+	}
+}
+
+func (p *selfProber) record(ctx context.Context, name string, probe func(context.Context) (string, error)) {
+	ctx, cancel := context.WithTimeout(ctx, selfProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	detail, err := probe(ctx)
+	c := selfCheck{
+		Name:    name,
+		OK:      err == nil,
+		Detail:  detail,
+		Latency: time.Since(start).Round(time.Microsecond),
+		Checked: start,
+	}
+	if err != nil {
+		c.Error = err.Error()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if prev, ok := p.checks[name]; ok && prev.OK != c.OK {
+		p.logf("healthz: %s check ok=%v: %s", name, c.OK, c.Error)
+	}
+	if p.checks == nil {
+		p.checks = map[string]selfCheck{}
+	}
+	p.checks[name] = c
+}
+
+// probeSTUN sends a binding request to the local STUN server and waits
+// for the matching response.
+func (p *selfProber) probeSTUN(ctx context.Context) (string, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "udp", p.stunAddr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		c.SetDeadline(dl)
+	}
+	txID := stun.NewTxID()
+	if _, err := c.Write(stun.Request(txID)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 1024)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		gotTxID, addr, err := stun.ParseResponse(buf[:n])
+		if err != nil || gotTxID != txID {
+			continue // not ours; keep waiting
+		}
+		return "saw us as " + addr.String(), nil
+	}
+}
+
+// probeDERP connects to the local DERP listener the way a client would,
+// over TCP, TLS (if enabled) and the HTTP Upgrade, and then does the DERP
+// handshake. It returns the expiry time of the served leaf certificate,
+// if any.
+func (p *selfProber) probeDERP(ctx context.Context) (detail string, certNotAfter time.Time, err error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", p.derpAddr)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer nc.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(dl)
+	}
+	if p.serveTLS {
+		tc := tls.Client(nc, &tls.Config{
+			ServerName: p.serverName,
+			// We're checking that our own listener works, not
+			// authenticating it; the cert's validity period is
+			// reported separately.
+			InsecureSkipVerify: true,
+		})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return "", time.Time{}, fmt.Errorf("TLS handshake: %w", err)
+		}
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+			certNotAfter = certs[0].NotAfter
+		}
+		nc = tc
+	}
+
+	brw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+p.serverName+"/derp", nil)
+	if err != nil {
+		return "", certNotAfter, err
+	}
+	req.Header.Set("Upgrade", "DERP")
+	req.Header.Set("Connection", "Upgrade")
+	if err := req.Write(brw); err != nil {
+		return "", certNotAfter, err
+	}
+	if err := brw.Flush(); err != nil {
+		return "", certNotAfter, err
+	}
+	res, err := http.ReadResponse(brw.Reader, req)
+	if err != nil {
+		return "", certNotAfter, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return "", certNotAfter, fmt.Errorf("HTTP upgrade: unexpected status %v", res.Status)
+	}
+
+	dc, err := derp.NewClient(key.NewNode(), nc, brw, logger.Discard, derp.IsProber(true))
+	if err != nil {
+		return "", certNotAfter, err
+	}
+	if !p.fullHandshake {
+		return "got server key (client verification on; skipped ServerInfo)", certNotAfter, nil
+	}
+	m, err := dc.Recv()
+	if err != nil {
+		return "", certNotAfter, err
+	}
+	if _, ok := m.(derp.ServerInfoMessage); !ok {
+		return "", certNotAfter, fmt.Errorf("got %T; want ServerInfoMessage", m)
+	}
+	return "", certNotAfter, nil
+}
+
+// checkCertExpiry returns an error if notAfter is within certExpiryMin of
+// now.
+func checkCertExpiry(notAfter, now time.Time) (detail string, err error) {
+	left := notAfter.Sub(now).Truncate(time.Hour)
+	detail = fmt.Sprintf("expires %v (in %v)", notAfter.UTC().Format(time.RFC3339), left)
+	if left < certExpiryMin {
+		return detail, errors.New("certificate expires too soon")
+	}
+	return detail, nil
+}
+
+// results returns the latest checks, sorted by name, and whether they're
+// all passing and fresh.
+func (p *selfProber) results(now time.Time) (checks []selfCheck, healthy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	healthy = true
+	for _, c := range p.checks {
+		// If the prober itself has wedged, don't keep serving
+		// its last good answer forever.
+		if now.Sub(c.Checked) > 3*p.interval+selfProbeTimeout {
+			c.OK = false
+			c.Error = "stale result"
+		}
+		if !c.OK {
+			healthy = false
+		}
+		checks = append(checks, c)
+	}
+	slices.SortFunc(checks, func(a, b selfCheck) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return checks, healthy
+}
+
+// ServeHTTP serves /healthz. The "depth" query parameter selects how much
+// is reported:
+//
+//   - 0 (the default) writes just "ok" or "unhealthy", for load balancers.
+//   - 1 adds one line per check.
+//   - 2 writes the full results as JSON.
+//
+// The status code is 200 if all checks pass and 503 otherwise, at every
+// depth.
+func (p *selfProber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	depth := 0
+	if v := r.FormValue("depth"); v != "" {
+		var err error
+		depth, err = strconv.Atoi(v)
+		if err != nil || depth < 0 || depth > 2 {
+			http.Error(w, "invalid depth; want 0, 1 or 2", http.StatusBadRequest)
+			return
+		}
+	}
+	checks, healthy := p.results(time.Now())
+	code := http.StatusOK
+	if !healthy {
+		code = http.StatusServiceUnavailable
+	}
+
+	if depth == 2 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(struct {
+			Healthy bool        `json:"healthy"`
+			Checks  []selfCheck `json:"checks"`
+		}{healthy, checks})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	if healthy {
+		fmt.Fprintln(w, "ok")
+	} else {
+		fmt.Fprintln(w, "unhealthy")
+	}
+	if depth == 0 {
+		return
+	}
+	for _, c := range checks {
+		status := "ok"
+		if !c.OK {
+			status = "FAIL: " + c.Error
+		}
+		fmt.Fprintf(w, "%s: %s (%v)", c.Name, status, c.Latency)
+		if c.Detail != "" {
+			fmt.Fprintf(w, "; %s", c.Detail)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// loopbackAddr returns the address to dial to reach a local listener
+// bound to listenHost and port. Wildcard binds are probed via loopback.
+func loopbackAddr(listenHost, port string) string {
+	host := "127.0.0.1"
+	if ip, err := netip.ParseAddr(listenHost); err == nil {
+		switch {
+		case ip.Is6() && ip.IsUnspecified():
+			host = "::1"
+		case !ip.IsUnspecified():
+			host = ip.String()
+		}
+	} else if listenHost != "" {
+		host = listenHost
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/net/stunserver"
+	"tailscale.com/types/key"
+)
+
+func TestSelfProber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ss := stunserver.New(ctx)
+	if err := ss.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go ss.Serve()
+
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+	hs := httptest.NewServer(derphttp.Handler(s))
+	defer hs.Close()
+
+	p := &selfProber{
+		interval:      time.Minute,
+		stunAddr:      ss.LocalAddr().String(),
+		derpAddr:      hs.Listener.Addr().String(),
+		serverName:    "derp.example.com",
+		fullHandshake: true,
+		logf:          t.Logf,
+	}
+	p.probeOnce(ctx)
+
+	checks, healthy := p.results(time.Now())
+	if !healthy {
+		t.Fatalf("unhealthy: %+v", checks)
+	}
+	var names []string
+	for _, c := range checks {
+		names = append(names, c.Name)
+	}
+	if got, want := strings.Join(names, ","), "derp,stun"; got != want {
+		t.Errorf("checks = %q; want %q", got, want)
+	}
+
+	// Results that haven't been refreshed in a long while mean the
+	// prober is stuck, which is unhealthy.
+	if _, healthy := p.results(time.Now().Add(time.Hour)); healthy {
+		t.Error("stale results reported healthy")
+	}
+
+	// A DERP server that's gone is a failure.
+	hs.Close()
+	p.probeOnce(ctx)
+	if checks, healthy := p.results(time.Now()); healthy {
+		t.Errorf("healthy after DERP listener closed: %+v", checks)
+	}
+}
+
+func TestSelfProberServeHTTP(t *testing.T) {
+	now := time.Now()
+	p := &selfProber{
+		interval: time.Minute,
+		checks: map[string]selfCheck{
+			"stun": {Name: "stun", OK: true, Checked: now},
+			"derp": {Name: "derp", OK: false, Error: "connection refused", Checked: now},
+		},
+	}
+	tests := []struct {
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{"", http.StatusServiceUnavailable, "unhealthy\n"},
+		{"?depth=1", http.StatusServiceUnavailable, "unhealthy\nderp: FAIL: connection refused (0s)\nstun: ok (0s)\n"},
+		{"?depth=3", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz"+tt.query, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%q: code = %v; want %v", tt.query, rec.Code, tt.wantCode)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%q: body = %q; want %q", tt.query, rec.Body.String(), tt.wantBody)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz?depth=2", nil))
+	var res struct {
+		Healthy bool
+		Checks  []selfCheck
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Healthy || len(res.Checks) != 2 || res.Checks[0].Error != "connection refused" {
+		t.Errorf("unexpected JSON result: %+v", res)
+	}
+
+	delete(p.checks, "derp")
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok\n" {
+		t.Errorf("got %v %q; want 200 %q", rec.Code, rec.Body.String(), "ok\n")
+	}
+}
+
+func TestCheckCertExpiry(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := checkCertExpiry(now.Add(60*24*time.Hour), now); err != nil {
+		t.Errorf("60 days out: %v", err)
+	}
+	if _, err := checkCertExpiry(now.Add(2*24*time.Hour), now); err == nil {
+		t.Error("2 days out: got no error")
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	tests := []struct {
+		host, port, want string
+	}{
+		{"", "443", "127.0.0.1:443"},
+		{"0.0.0.0", "443", "127.0.0.1:443"},
+		{"::", "3478", "[::1]:3478"},
+		{"10.0.0.5", "443", "10.0.0.5:443"},
+		{"derp.example.com", "443", "derp.example.com:443"},
+	}
+	for _, tt := range tests {
+		if got := loopbackAddr(tt.host, tt.port); got != tt.want {
+			t.Errorf("loopbackAddr(%q, %q) = %q; want %q", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
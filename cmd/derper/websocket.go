@@ -5,10 +5,12 @@
 
 import (
 	"bufio"
+	"context"
 	"expvar"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"nhooyr.io/websocket"
 	"tailscale.com/derp"
@@ -17,6 +19,24 @@
 
 var counterWebSocketAccepts = expvar.NewInt("derp_websocket_accepts")
 
+const (
+	// wsReadLimit is the largest WebSocket message we accept from clients.
+	// Each Write to a wsconn is sent as its own message, and DERP clients
+	// write packets of up to derp.MaxPacketSize bytes in one Write, which
+	// is more than the websocket package's default limit of 32KiB.
+	wsReadLimit = 2 * derp.MaxPacketSize
+
+	// wsPingInterval is how often we ping WebSocket clients. The DERP
+	// keepalive frames alone (every minute or so) aren't frequent enough
+	// to stop reverse proxies with a default 60 second idle timeout, such
+	// as nginx, from closing the connection.
+	wsPingInterval = 30 * time.Second
+
+	// wsPingTimeout is how long we wait for a pong before closing the
+	// connection.
+	wsPingTimeout = 30 * time.Second
+)
+
 // addWebSocketSupport returns a Handle wrapping base that adds WebSocket server support.
 func addWebSocketSupport(s *derp.Server, base http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -50,8 +70,34 @@ func addWebSocketSupport(s *derp.Server, base http.Handler) http.Handler {
 			return
 		}
 		counterWebSocketAccepts.Add(1)
-		wc := wsconn.NetConn(r.Context(), c, websocket.MessageBinary, r.RemoteAddr)
+		c.SetReadLimit(wsReadLimit)
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go pingWebSocket(ctx, c)
+		wc := wsconn.NetConn(ctx, c, websocket.MessageBinary, r.RemoteAddr)
 		brw := bufio.NewReadWriter(bufio.NewReader(wc), bufio.NewWriter(wc))
-		s.Accept(r.Context(), wc, brw, r.RemoteAddr)
+		s.Accept(ctx, wc, brw, r.RemoteAddr)
 	})
 }
+
+// pingWebSocket pings c every wsPingInterval until ctx is done, so that
+// proxies between us and the client see traffic on an otherwise idle
+// connection. The websocket package closes c if a pong doesn't arrive in
+// time. Pongs are read by the DERP server's reads from c.
+func pingWebSocket(ctx context.Context, c *websocket.Conn) {
+	t := time.NewTicker(wsPingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		pctx, cancel := context.WithTimeout(ctx, wsPingTimeout)
+		err := c.Ping(pctx)
+		cancel()
+		if err != nil {
+			return
+		}
+	}
+}
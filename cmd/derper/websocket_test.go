@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"runtime"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/envknob"
+	"tailscale.com/net/netmon"
+	"tailscale.com/types/key"
+)
+
+// TestWebSocketBehindReverseProxy tests that DERP clients can exchange
+// packets of all sizes over WebSockets with a server behind a standard
+// reverse proxy.
+func TestWebSocketBehindReverseProxy(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("derphttp only supports WebSocket clients on linux and js")
+	}
+	envknob.Setenv("TS_DEBUG_DERP_WS_CLIENT", "true")
+	t.Cleanup(func() { envknob.Setenv("TS_DEBUG_DERP_WS_CLIENT", "") })
+
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	t.Cleanup(func() { s.Close() })
+	backend := httptest.NewServer(addWebSocketSupport(s, http.NotFoundHandler()))
+	t.Cleanup(backend.Close)
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(httputil.NewSingleHostReverseProxy(backendURL))
+	t.Cleanup(proxy.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	newClient := func() (*derphttp.Client, key.NodePublic) {
+		t.Helper()
+		priv := key.NewNode()
+		c, err := derphttp.NewClient(priv, proxy.URL+"/derp", t.Logf, netmon.NewStatic())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		if err := c.Connect(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return c, priv.Public()
+	}
+	c1, _ := newClient()
+	c2, pub2 := newClient()
+
+	recv := make(chan []byte)
+	go func() {
+		for {
+			m, err := c2.Recv()
+			if err != nil {
+				return
+			}
+			if rp, ok := m.(derp.ReceivedPacket); ok {
+				select {
+				case recv <- bytes.Clone(rp.Data):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	for _, size := range []int{1, 1 << 10, 32 << 10, derp.MaxPacketSize} {
+		pkt := bytes.Repeat([]byte{byte(size)}, size)
+		if err := c1.Send(pub2, pkt); err != nil {
+			t.Fatalf("Send(%d bytes): %v", size, err)
+		}
+		select {
+		case got := <-recv:
+			if !bytes.Equal(got, pkt) {
+				t.Fatalf("received %d bytes; want %d", len(got), size)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for %d byte packet", size)
+		}
+	}
+}
@@ -7,12 +7,17 @@
 
 // Example usage for client command: go run cmd/speedtest -host 127.0.0.1:20333 -t 5s
 // This will connect to the server on 127.0.0.1:20333 and start a 5 second download speedtest.
+// Pass -r to run an upload test instead, in which the client sends and the server receives.
 // Example usage for server command: go run cmd/speedtest -s -host :20333
 // This will start a speedtest server on port 20333.
+// Pass -tls to both commands to run the test over TLS, in which case the server
+// uses its node's certificate from tailscaled and the client should connect using
+// the server's MagicDNS name, e.g. -host mynode.tailnet-name.ts.net.
 package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
@@ -23,6 +28,7 @@
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale"
 	"tailscale.com/net/speedtest"
 )
 
@@ -49,14 +55,17 @@ func main() {
 // flags passed to it.
 var speedtestCmd = &ffcli.Command{
 	Name:       "speedtest",
-	ShortUsage: "speedtest [-host <host:port>] [-s] [-r] [-t <test duration>]",
+	ShortUsage: "speedtest [-host <host:port>] [-s] [-r] [-t <test duration>] [-warmup <warmup duration>] [-tls] [-zerocopy]",
 	ShortHelp:  "Run a speed test",
 	FlagSet: (func() *flag.FlagSet {
 		fs := flag.NewFlagSet("speedtest", flag.ExitOnError)
 		fs.StringVar(&speedtestArgs.host, "host", ":20333", "host:port pair to connect to or listen on")
 		fs.DurationVar(&speedtestArgs.testDuration, "t", speedtest.DefaultDuration, "duration of the speed test")
+		fs.DurationVar(&speedtestArgs.warmup, "warmup", speedtest.DefaultWarmup, "duration to send data before the speed test, excluded from its results")
 		fs.BoolVar(&speedtestArgs.runServer, "s", false, "run a speedtest server")
-		fs.BoolVar(&speedtestArgs.reverse, "r", false, "run in reverse mode (server sends, client receives)")
+		fs.BoolVar(&speedtestArgs.reverse, "r", false, "run in reverse mode to measure upload speed (client sends, server receives)")
+		fs.BoolVar(&speedtestArgs.tls, "tls", false, "use TLS; the server uses this node's certificate from tailscaled")
+		fs.BoolVar(&speedtestArgs.zeroCopy, "zerocopy", false, "in server mode, send with sendfile(2) from memory rather than copying through user space (Linux only; not with -tls)")
 		return fs
 	})(),
 	Exec: runSpeedtest,
@@ -65,8 +74,11 @@ func main() {
 var speedtestArgs struct {
 	host         string
 	testDuration time.Duration
+	warmup       time.Duration
 	runServer    bool
 	reverse      bool
+	tls          bool
+	zeroCopy     bool
 }
 
 func runSpeedtest(ctx context.Context, args []string) error {
@@ -87,13 +99,23 @@ func runSpeedtest(ctx context.Context, args []string) error {
 
 		fmt.Printf("listening on %v\n", listener.Addr())
 
-		return speedtest.Serve(listener)
+		if speedtestArgs.tls {
+			var lc tailscale.LocalClient
+			return speedtest.ServeTLS(listener, &tls.Config{
+				GetCertificate: lc.GetCertificate,
+			})
+		}
+		s := &speedtest.Server{ZeroCopy: speedtestArgs.zeroCopy}
+		return s.Serve(listener)
 	}
 
 	// Ensure the duration is within the allowed range
 	if speedtestArgs.testDuration < speedtest.MinDuration || speedtestArgs.testDuration > speedtest.MaxDuration {
 		return fmt.Errorf("test duration must be within %v and %v", speedtest.MinDuration, speedtest.MaxDuration)
 	}
+	if speedtestArgs.warmup < 0 || speedtestArgs.warmup > speedtest.MaxWarmup {
+		return fmt.Errorf("warmup must be within 0 and %v", speedtest.MaxWarmup)
+	}
 
 	dir := speedtest.Download
 	if speedtestArgs.reverse {
@@ -101,11 +123,26 @@ func runSpeedtest(ctx context.Context, args []string) error {
 	}
 
 	fmt.Printf("Starting a %s test with %s\n", dir, speedtestArgs.host)
-	results, err := speedtest.RunClient(dir, speedtestArgs.testDuration, speedtestArgs.host)
+	var results []speedtest.Result
+	var err error
+	if speedtestArgs.tls {
+		results, err = speedtest.RunClientTLS(dir, speedtestArgs.testDuration, speedtestArgs.warmup, speedtestArgs.host, &tls.Config{})
+	} else {
+		results, err = speedtest.RunClient(dir, speedtestArgs.testDuration, speedtestArgs.warmup, speedtestArgs.host)
+	}
 	if err != nil {
 		return err
 	}
 
+	if len(results) > 0 && results[0].Warmup {
+		r := results[0]
+		results = results[1:]
+		fmt.Printf("Warmup (excluded from results): %.2f sec, %.4f MBits, %.4f Mbits/sec\n", r.Interval().Seconds(), r.MegaBits(), r.MBitsPerSecond())
+	}
+	if len(results) == 0 {
+		return errors.New("no results")
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 12, 0, 0, ' ', tabwriter.TabIndent)
 	fmt.Println("Results:")
 	fmt.Fprintln(w, "Interval\t\tTransfer\t\tBandwidth\t\t")
@@ -117,5 +154,12 @@ func runSpeedtest(ctx context.Context, args []string) error {
 		fmt.Fprintf(w, "%.2f-%.2f\tsec\t%.4f\tMBits\t%.4f\tMbits/sec\t\n", r.IntervalStart.Sub(startTime).Seconds(), r.IntervalEnd.Sub(startTime).Seconds(), r.MegaBits(), r.MBitsPerSecond())
 	}
 	w.Flush()
+
+	if sum := speedtest.Summarize(results); sum.Intervals > 1 {
+		fmt.Printf("Interval bandwidth (Mbits/sec) over %d intervals:\n", sum.Intervals)
+		fmt.Fprintln(w, "Min\tP50\tP90\tP99\tMax\tStdDev\t")
+		fmt.Fprintf(w, "%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t\n", sum.Min, sum.P50, sum.P90, sum.P99, sum.Max, sum.StdDev)
+		w.Flush()
+	}
 	return nil
 }
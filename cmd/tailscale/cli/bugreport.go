@@ -8,6 +8,7 @@
 	"errors"
 	"flag"
 	"fmt"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/tailscale"
@@ -22,6 +23,7 @@
 		fs := newFlagSet("bugreport")
 		fs.BoolVar(&bugReportArgs.diagnose, "diagnose", false, "run additional in-depth checks")
 		fs.BoolVar(&bugReportArgs.record, "record", false, "if true, pause and then write another bugreport")
+		fs.BoolVar(&bugReportArgs.crashes, "crashes", false, "print the crash reports tailscaled saved from previous runs instead of writing a bugreport")
 		return fs
 	})(),
 }
@@ -29,6 +31,7 @@
 var bugReportArgs struct {
 	diagnose bool
 	record   bool
+	crashes  bool
 }
 
 func runBugReport(ctx context.Context, args []string) error {
@@ -40,6 +43,12 @@ func runBugReport(ctx context.Context, args []string) error {
 	default:
 		return errors.New("unknown arguments")
 	}
+	if bugReportArgs.crashes {
+		if note != "" || bugReportArgs.diagnose || bugReportArgs.record {
+			return errors.New("--crashes can't be combined with a note, --diagnose or --record")
+		}
+		return runBugReportCrashes(ctx)
+	}
 	opts := tailscale.BugReportOpts{
 		Note:     note,
 		Diagnose: bugReportArgs.diagnose,
@@ -81,3 +90,29 @@ type bugReportResp struct {
 	outln("Please provide both bugreport markers above to the support team or GitHub issue.")
 	return nil
 }
+
+// runBugReportCrashes prints the crash reports tailscaled saved, oldest
+// first.
+func runBugReportCrashes(ctx context.Context) error {
+	crashes, capturing, err := localClient.Crashes(ctx)
+	if err != nil {
+		return err
+	}
+	if !capturing {
+		outln("Note: tailscaled was built with a Go older than 1.23, so it can't capture crash reports.")
+	}
+	if len(crashes) == 0 {
+		outln("No crash reports.")
+		return nil
+	}
+	for _, c := range crashes {
+		data, err := localClient.CrashReport(ctx, c.Name)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", c.Name, err)
+		}
+		printf("=== %s: crashed at %v (%d bytes) ===\n", c.Name, c.Time.Format(time.RFC3339), c.Size)
+		Stdout.Write(data)
+		outln()
+	}
+	return nil
+}
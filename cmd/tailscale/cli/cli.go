@@ -197,6 +197,7 @@ func newRootCmd() *ffcli.Command {
 			updateCmd,
 			whoisCmd,
 			debugCmd,
+			doctorCmd,
 			driveCmd,
 			idTokenCmd,
 		},
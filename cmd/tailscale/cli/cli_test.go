@@ -10,6 +10,8 @@
 	"flag"
 	"fmt"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -732,6 +734,13 @@ func TestPrefsFromUpArgs(t *testing.T) {
 			},
 			wantErr: `--exit-node-allow-lan-access can only be used with --exit-node`,
 		},
+		{
+			name: "error_fix_sysctls_without_routes",
+			args: upArgsT{
+				fixSysctls: true,
+			},
+			wantErr: `--fix-sysctls can only be used with --advertise-routes or --advertise-exit-node`,
+		},
 		{
 			name: "error_tag_prefix",
 			args: upArgsT{
@@ -788,6 +797,7 @@ func TestPrefsFromUpArgs(t *testing.T) {
 				WantRunning:         true,
 				NetfilterMode:       preftype.NetfilterNoDivert,
 				NoSNAT:              true,
+				NoDoHUpgrade:        true,
 				NoStatefulFiltering: "true",
 				AutoUpdate: ipn.AutoUpdatePrefs{
 					Check: true,
@@ -805,6 +815,7 @@ func TestPrefsFromUpArgs(t *testing.T) {
 				WantRunning:         true,
 				NetfilterMode:       preftype.NetfilterOff,
 				NoSNAT:              true,
+				NoDoHUpgrade:        true,
 				NoStatefulFiltering: "true",
 				AutoUpdate: ipn.AutoUpdatePrefs{
 					Check: true,
@@ -821,6 +832,7 @@ func TestPrefsFromUpArgs(t *testing.T) {
 			want: &ipn.Prefs{
 				WantRunning:         true,
 				NoSNAT:              true,
+				NoDoHUpgrade:        true,
 				NoStatefulFiltering: "true",
 				AdvertiseRoutes: []netip.Prefix{
 					netip.MustParsePrefix("fd7a:115c:a1e0:b1a::bb:10.0.0.0/112"),
@@ -840,6 +852,7 @@ func TestPrefsFromUpArgs(t *testing.T) {
 			want: &ipn.Prefs{
 				WantRunning:         true,
 				NoSNAT:              true,
+				NoDoHUpgrade:        true,
 				NoStatefulFiltering: "true",
 				AdvertiseRoutes: []netip.Prefix{
 					netip.MustParsePrefix("fd7a:115c:a1e0:b1a::aabb:10.0.0.0/112"),
@@ -1033,6 +1046,8 @@ func TestUpdatePrefs(t *testing.T) {
 				AppConnectorSet:           true,
 				ControlURLSet:             true,
 				CorpDNSSet:                true,
+				DNSOverrideSet:            true,
+				NoDoHUpgradeSet:           true,
 				ExitNodeAllowLANAccessSet: true,
 				ExitNodeIDSet:             true,
 				ExitNodeIPSet:             true,
@@ -1477,3 +1492,29 @@ func TestParseNLArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteDisablementSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disablements")
+	secrets := [][]byte{bytes.Repeat([]byte{0xa5}, 32), bytes.Repeat([]byte{0x5a}, 32)}
+	if err := writeDisablementSecrets(path, secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each line must be usable with 'tailscale lock disable'.
+	_, got, err := parseNLArgs(strings.Fields(string(b)), false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, secrets) {
+		t.Errorf("read back %x; want %x", got, secrets)
+	}
+
+	// Existing secrets must not be overwritten.
+	if err := writeDisablementSecrets(path, secrets[:1]); err == nil {
+		t.Error("writeDisablementSecrets to existing file succeeded")
+	}
+}
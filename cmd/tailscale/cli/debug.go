@@ -6,6 +6,7 @@
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/binary"
 	"encoding/json"
@@ -22,8 +23,10 @@
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -33,6 +36,8 @@
 	"tailscale.com/control/controlhttp"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/conffile"
+	"tailscale.com/net/sockstats"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tshttpproxy"
 	"tailscale.com/paths"
@@ -141,6 +146,12 @@
 			Exec:       localAPIAction("rebind"),
 			ShortHelp:  "Force a magicsock rebind",
 		},
+		{
+			Name:       "restart-engine",
+			ShortUsage: "tailscale debug restart-engine",
+			Exec:       localAPIAction("restart-engine"),
+			ShortHelp:  "Re-create the TUN device and WireGuard state without restarting tailscaled",
+		},
 		{
 			Name:       "derp-set-on-demand",
 			ShortUsage: "tailscale debug derp-set-on-demand",
@@ -192,12 +203,22 @@
 		},
 		{
 			Name:       "prefs",
-			ShortUsage: "tailscale debug prefs",
+			ShortUsage: "tailscale debug prefs [--pretty | --schema | --validate=<config-file>]",
 			Exec:       runPrefs,
 			ShortHelp:  "Print prefs",
+			LongHelp: strings.TrimSpace(`
+Print the current prefs from tailscaled.
+
+With --schema, print the fields of the tailscaled --config file format
+instead, with their types and defaults. With --validate, check a config
+file (JSON or HuJSON) against that format, including constraints between
+fields, without applying it.
+`),
 			FlagSet: (func() *flag.FlagSet {
 				fs := newFlagSet("prefs")
 				fs.BoolVar(&prefsArgs.pretty, "pretty", false, "If true, pretty-print output")
+				fs.BoolVar(&prefsArgs.schema, "schema", false, "print the config file schema instead of the current prefs")
+				fs.StringVar(&prefsArgs.validate, "validate", "", "if non-empty, path of a config file to validate instead of printing prefs")
 				return fs
 			})(),
 		},
@@ -290,6 +311,13 @@
 			ShortUsage: "tailscale debug portmap",
 			Exec:       debugPortmap,
 			ShortHelp:  "Run portmap debugging",
+			LongHelp: strings.TrimSpace(`
+Probe the local network's gateway for port mapping services (PCP, NAT-PMP
+and UPnP), then try a throwaway port mapping with each service found,
+releasing it afterwards. The daemon's portmapper logs are printed as it
+runs, followed by a summary of the gateway, the services found, and the
+external address obtained or the error from each service.
+`),
 			FlagSet: (func() *flag.FlagSet {
 				fs := newFlagSet("portmap")
 				fs.DurationVar(&debugPortmapArgs.duration, "duration", 5*time.Second, "timeout for port mapping")
@@ -317,6 +345,37 @@
 				return fs
 			})(),
 		},
+		{
+			Name:       "sockstats",
+			ShortUsage: "tailscale debug sockstats",
+			Exec:       runDebugSockStats,
+			ShortHelp:  "Print per-subsystem socket statistics",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("sockstats")
+				fs.BoolVar(&debugSockStatsArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "nat-test",
+			ShortUsage: "tailscale debug nat-test",
+			Exec:       runDebugNATTest,
+			ShortHelp:  "Run simulated NAT traversal tests against this source tree's magicsock",
+			LongHelp: strings.TrimSpace(`
+Runs two magicsocks in-process behind every pair of simulated NAT types
+and prints, for each pair, whether they found a direct path, how long it
+took and the resulting latency.
+
+It must be run from within a tailscale.com source checkout and needs Go
+installed, as it tests the magicsock code in that checkout.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("nat-test")
+				fs.DurationVar(&debugNATTestArgs.timeout, "timeout", 30*time.Second, "how long to wait for each pair to find a direct path")
+				fs.BoolVar(&debugNATTestArgs.verbose, "verbose", false, "print the test's logs")
+				return fs
+			})(),
+		},
 	},
 }
 
@@ -449,10 +508,20 @@ func runLocalAPIProxy() {
 }
 
 var prefsArgs struct {
-	pretty bool
+	pretty   bool
+	schema   bool
+	validate string
 }
 
 func runPrefs(ctx context.Context, args []string) error {
+	switch {
+	case prefsArgs.schema && prefsArgs.validate != "":
+		return errors.New("--schema and --validate are mutually exclusive")
+	case prefsArgs.schema:
+		return runPrefsSchema()
+	case prefsArgs.validate != "":
+		return runPrefsValidate(prefsArgs.validate)
+	}
 	prefs, err := localClient.GetPrefs(ctx)
 	if err != nil {
 		return err
@@ -466,6 +535,33 @@ func runPrefs(ctx context.Context, args []string) error {
 	return nil
 }
 
+func runPrefsSchema() error {
+	fields := conffile.Schema()
+	if !prefsArgs.pretty {
+		j, _ := json.MarshalIndent(fields, "", "\t")
+		outln(string(j))
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FIELD\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, f := range fields {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Name, f.Type, cmp.Or(f.Default, "-"), f.Doc)
+	}
+	return w.Flush()
+}
+
+func runPrefsValidate(path string) error {
+	c, err := conffile.Load(path)
+	if err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("%s is invalid:\n%w", path, err)
+	}
+	printf("%s is valid\n", path)
+	return nil
+}
+
 var watchIPNArgs struct {
 	netmap         bool
 	initial        bool
@@ -1116,3 +1212,94 @@ func runDebugDialTypes(ctx context.Context, args []string) error {
 	fmt.Printf("%s", body)
 	return nil
 }
+
+var debugSockStatsArgs struct {
+	json bool
+}
+
+func runDebugSockStats(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	rep, err := localClient.DebugSockStats(ctx)
+	if err != nil {
+		return err
+	}
+	if debugSockStatsArgs.json {
+		enc := json.NewEncoder(Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(rep)
+	}
+	if rep.Stats == nil {
+		outln("No socket stats available")
+		return nil
+	}
+
+	labels := make([]sockstats.Label, 0, len(rep.Stats.Stats))
+	for label := range rep.Stats.Stats {
+		labels = append(labels, label)
+	}
+	slices.SortFunc(labels, func(a, b sockstats.Label) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	w := tabwriter.NewWriter(Stdout, 10, 5, 5, ' ', 0)
+	fmt.Fprintf(w, "Label\tTx bytes\tRx bytes\tTx calls\tRx calls\n")
+	for _, label := range labels {
+		stat := rep.Stats.Stats[label]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", label, stat.TxBytes, stat.RxBytes, stat.TxCalls, stat.RxCalls)
+	}
+	w.Flush()
+	if rep.Stats.CurrentInterfaceCellular {
+		outln("\nCurrent interface is cellular.")
+	}
+	return nil
+}
+
+var debugNATTestArgs struct {
+	timeout time.Duration
+	verbose bool
+}
+
+func runDebugNATTest(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	out, err := exec.CommandContext(ctx, "go", "list", "-m", "-f", "{{.Path}} {{.Dir}}").Output()
+	mod, dir, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	if err != nil || mod != "tailscale.com" {
+		return errors.New("nat-test must be run from within a tailscale.com source checkout, with Go installed")
+	}
+
+	f, err := os.CreateTemp("", "tailscale-nat-test-*.txt")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	testArgs := []string{"test", "-count=1", "-timeout=0", "-run=^TestNATMatrix$"}
+	if debugNATTestArgs.verbose {
+		testArgs = append(testArgs, "-v")
+	}
+	testArgs = append(testArgs, "./wgengine/magicsock", "-args",
+		"-nat-matrix",
+		"-nat-matrix-out="+f.Name(),
+		"-nat-matrix-timeout="+debugNATTestArgs.timeout.String())
+	cmd := exec.CommandContext(ctx, "go", testArgs...)
+	cmd.Dir = dir
+	cmd.Stderr = Stderr
+	if debugNATTestArgs.verbose {
+		cmd.Stdout = Stdout
+	}
+	errf("Running NAT traversal tests in %s; this takes a few minutes...\n", dir)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running tests: %w", err)
+	}
+
+	results, err := os.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	_, err = Stdout.Write(results)
+	return err
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+var doctorCmd = &ffcli.Command{
+	Name:       "doctor",
+	ShortUsage: "tailscale doctor [--json]",
+	ShortHelp:  "Check for common problems and suggest fixes",
+	LongHelp: strings.TrimSpace(`
+'tailscale doctor' asks tailscaled to check for common problems, such as an
+expired node key, unreachable DERP relays, blocked UDP, MagicDNS failures,
+another VPN taking over the default route, or IP forwarding being disabled
+on a subnet router, and prints what it finds, most severe first, along
+with suggested fixes.
+
+It exits non-zero if any check found an error.
+`),
+	Exec: runDoctor,
+	FlagSet: func() *flag.FlagSet {
+		fs := newFlagSet("doctor")
+		fs.BoolVar(&doctorArgs.json, "json", false, "output in JSON format")
+		return fs
+	}(),
+}
+
+var doctorArgs struct {
+	json bool // output in JSON format
+}
+
+func runDoctor(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale doctor'")
+	}
+	res, err := localClient.Doctor(ctx)
+	if err != nil {
+		return err
+	}
+	if doctorArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(res); err != nil {
+			return err
+		}
+	} else {
+		printDoctorFindings(res)
+	}
+	for _, f := range res.Findings {
+		if f.Severity == apitype.DoctorError {
+			return errors.New("found problems")
+		}
+	}
+	return nil
+}
+
+func printDoctorFindings(res *apitype.DoctorResponse) {
+	if len(res.Findings) == 0 {
+		outln(fmt.Sprintf("No problems found (%d checks).", len(res.Checks)))
+		return
+	}
+	for _, f := range res.Findings {
+		printf("%s: %s: %s\n", strings.ToUpper(string(f.Severity)), f.Check, f.Summary)
+		if f.Fix != "" {
+			printf("    fix: %s\n", f.Fix)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"strings"
 
@@ -13,7 +14,7 @@
 )
 
 const (
-	driveShareUsage   = "tailscale drive share <name> <path>"
+	driveShareUsage   = "tailscale drive share [--read-only] <name> <path>"
 	driveRenameUsage  = "tailscale drive rename <oldname> <newname>"
 	driveUnshareUsage = "tailscale drive unshare <name>"
 	driveListUsage    = "tailscale drive list"
@@ -36,6 +37,11 @@
 			ShortUsage: driveShareUsage,
 			Exec:       runDriveShare,
 			ShortHelp:  "[ALPHA] Create or modify a share",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("share")
+				fs.BoolVar(&driveShareArgs.readOnly, "read-only", false, "prevent remote nodes from modifying the share, regardless of ACLs")
+				return fs
+			})(),
 		},
 		{
 			Name:       "rename",
@@ -58,6 +64,10 @@
 	},
 }
 
+var driveShareArgs struct {
+	readOnly bool
+}
+
 // runDriveShare is the entry point for the "tailscale drive share" command.
 func runDriveShare(ctx context.Context, args []string) error {
 	if len(args) != 2 {
@@ -67,11 +77,16 @@ func runDriveShare(ctx context.Context, args []string) error {
 	name, path := args[0], args[1]
 
 	err := localClient.DriveShareSet(ctx, &drive.Share{
-		Name: name,
-		Path: path,
+		Name:     name,
+		Path:     path,
+		ReadOnly: driveShareArgs.readOnly,
 	})
 	if err == nil {
-		fmt.Printf("Sharing %q as %q\n", path, name)
+		if driveShareArgs.readOnly {
+			fmt.Printf("Sharing %q as %q (read-only)\n", path, name)
+		} else {
+			fmt.Printf("Sharing %q as %q\n", path, name)
+		}
 	}
 	return err
 }
@@ -130,11 +145,15 @@ func runDriveList(ctx context.Context, args []string) error {
 			longestAs = len(share.As)
 		}
 	}
-	formatString := fmt.Sprintf("%%-%ds    %%-%ds    %%s\n", longestName, longestPath)
-	fmt.Printf(formatString, "name", "path", "as")
-	fmt.Printf(formatString, strings.Repeat("-", longestName), strings.Repeat("-", longestPath), strings.Repeat("-", longestAs))
+	formatString := fmt.Sprintf("%%-%ds    %%-%ds    %%-%ds    %%s\n", longestName, longestPath, longestAs)
+	fmt.Printf(formatString, "name", "path", "as", "mode")
+	fmt.Printf(formatString, strings.Repeat("-", longestName), strings.Repeat("-", longestPath), strings.Repeat("-", longestAs), "----")
 	for _, share := range shares {
-		fmt.Printf(formatString, share.Name, share.Path, share.As)
+		mode := "rw"
+		if share.ReadOnly {
+			mode = "ro"
+		}
+		fmt.Printf(formatString, share.Name, share.Path, share.As, mode)
 	}
 
 	return nil
@@ -211,6 +230,10 @@ func buildShareLongHelp() string {
 	  }
 	}]
 
+To prevent remote nodes from modifying a share's contents even if ACLs grant them read-write access, create the share with the --read-only flag:
+
+  $ tailscale drive share --read-only docs /Users/me/Documents
+
 You can rename shares, for example you could rename the above share by running:
 
   $ tailscale drive rename docs newdocs
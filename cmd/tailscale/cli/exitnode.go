@@ -9,9 +9,11 @@
 	"errors"
 	"flag"
 	"fmt"
+	"net/netip"
 	"slices"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	xmaps "golang.org/x/exp/maps"
@@ -88,6 +90,63 @@ func exitNodeSetUse(wantOn bool) func(ctx context.Context, args []string) error
 	}
 }
 
+// exitNodePingTimeout is how long 'tailscale set --exit-node' waits for
+// the new exit node to answer a disco ping before warning that it may be
+// unreachable.
+const exitNodePingTimeout = 3 * time.Second
+
+// pingExitNode sends a disco ping to the exit node candidate at ip and
+// returns an error if it doesn't answer within exitNodePingTimeout.
+func pingExitNode(ctx context.Context, ip netip.Addr) error {
+	ctx, cancel := context.WithTimeout(ctx, exitNodePingTimeout)
+	defer cancel()
+	pr, err := localClient.Ping(ctx, ip, tailcfg.PingDisco)
+	if ctx.Err() != nil {
+		return fmt.Errorf("no reply in %v", exitNodePingTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	if pr.Err != "" {
+		return errors.New(pr.Err)
+	}
+	return nil
+}
+
+// exitNodeUnreachableReason returns why the peer with Tailscale IP ip
+// looks unusable as an exit node, or the empty string if it looks fine
+// or there's nothing to check. Routing all traffic through an exit node
+// that can't be reached cuts the device off from the internet, so
+// callers warn before switching to it.
+//
+// The peer must be online according to control and answer ping, which
+// is only called for online peers that aren't already the exit node.
+func exitNodeUnreachableReason(ctx context.Context, st *ipnstate.Status, ip netip.Addr, ping func(context.Context, netip.Addr) error) string {
+	if st.BackendState != "Running" {
+		return ""
+	}
+	for _, ps := range st.Peer {
+		if !slices.Contains(ps.TailscaleIPs, ip) {
+			continue
+		}
+		if ps.ExitNode {
+			return ""
+		}
+		name := strings.TrimSuffix(ps.DNSName, ".")
+		if name == "" {
+			name = ip.String()
+		}
+		if !ps.Online {
+			return fmt.Sprintf("Exit node %s is offline.", name)
+		}
+		if err := ping(ctx, ip); err != nil {
+			return fmt.Sprintf("Exit node %s did not answer a ping: %v.", name, err)
+		}
+		return ""
+	}
+	return ""
+}
+
 // runExitNodeList returns a formatted list of exit nodes for a tailnet.
 // If the exit node has location and priority data, only the highest
 // priority node for each city location is shown to the user.
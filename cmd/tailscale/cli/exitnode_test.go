@@ -4,6 +4,10 @@
 package cli
 
 import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -306,3 +310,55 @@ func TestSortByCityName(t *testing.T) {
 		t.Fatalf("sortByCityName did not order cities by alphabetical order, got %v, want %v", fc[0].Name, noLocationData)
 	}
 }
+
+func TestExitNodeUnreachableReason(t *testing.T) {
+	exitIP := netip.MustParseAddr("100.64.0.2")
+	newStatus := func(backendState string, online, current bool) *ipnstate.Status {
+		return &ipnstate.Status{
+			BackendState: backendState,
+			Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+				key.NewNode().Public(): {
+					DNSName:        "exit.tail-scale.ts.net.",
+					TailscaleIPs:   []netip.Addr{exitIP},
+					ExitNodeOption: true,
+					ExitNode:       current,
+					Online:         online,
+				},
+			},
+		}
+	}
+	pingOK := func(context.Context, netip.Addr) error { return nil }
+	pingFail := func(context.Context, netip.Addr) error { return errors.New("no reply in 3s") }
+
+	tests := []struct {
+		name string
+		st   *ipnstate.Status
+		ping func(context.Context, netip.Addr) error
+		want string // substring; empty means no reason
+	}{
+		{"reachable", newStatus("Running", true, false), pingOK, ""},
+		{"offline", newStatus("Running", false, false), pingOK, "exit.tail-scale.ts.net is offline"},
+		{"no-ping-reply", newStatus("Running", true, false), pingFail, "did not answer a ping: no reply in 3s"},
+		{"already-exit-node", newStatus("Running", false, true), pingFail, ""},
+		{"not-running", newStatus("Stopped", false, false), pingFail, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pinged bool
+			ping := func(ctx context.Context, ip netip.Addr) error {
+				pinged = true
+				if ip != exitIP {
+					t.Errorf("pinged %v; want %v", ip, exitIP)
+				}
+				return tt.ping(ctx, ip)
+			}
+			got := exitNodeUnreachableReason(context.Background(), tt.st, exitIP, ping)
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+			if tt.name == "offline" && pinged {
+				t.Error("pinged offline exit node")
+			}
+		})
+	}
+}
@@ -59,12 +59,13 @@ func runNetworkLockNoSubcommand(ctx context.Context, args []string) error {
 var nlInitArgs struct {
 	numDisablements       int
 	disablementForSupport bool
+	disablementsFile      string
 	confirm               bool
 }
 
 var nlInitCmd = &ffcli.Command{
 	Name:       "init",
-	ShortUsage: "tailscale lock init [--gen-disablement-for-support] --gen-disablements N <trusted-key>...",
+	ShortUsage: "tailscale lock init [--gen-disablement-for-support] [--disablements-file=<path>] --gen-disablements N <trusted-key>...",
 	ShortHelp:  "Initialize tailnet lock",
 	LongHelp: strings.TrimSpace(`
 
@@ -82,6 +83,11 @@ func runNetworkLockNoSubcommand(ctx context.Context, args []string) error {
 --gen-disablements flag. Initializing tailnet lock requires at least
 one disablement.
 
+If --disablements-file is specified, the generated disablement secrets
+are also written to that file, which must not already exist, before
+tailnet lock is initialized. Store it somewhere safe, away from the
+tailnet's nodes.
+
 If --gen-disablement-for-support is specified, an additional disablement secret
 will be generated and transmitted to Tailscale, which support can use to disable
 tailnet lock. We recommend setting this flag.
@@ -92,6 +98,7 @@ func runNetworkLockNoSubcommand(ctx context.Context, args []string) error {
 		fs := newFlagSet("lock init")
 		fs.IntVar(&nlInitArgs.numDisablements, "gen-disablements", 1, "number of disablement secrets to generate")
 		fs.BoolVar(&nlInitArgs.disablementForSupport, "gen-disablement-for-support", false, "generates and transmits a disablement secret for Tailscale support")
+		fs.StringVar(&nlInitArgs.disablementsFile, "disablements-file", "", "if non-empty, a new file to also write the generated disablement secrets to")
 		fs.BoolVar(&nlInitArgs.confirm, "confirm", false, "do not prompt for confirmation")
 		return fs
 	})(),
@@ -144,20 +151,35 @@ func runNetworkLockInit(ctx context.Context, args []string) error {
 		if nlInitArgs.disablementForSupport {
 			genSupportFlag = "--gen-disablement-for-support "
 		}
+		if nlInitArgs.disablementsFile != "" {
+			genSupportFlag += fmt.Sprintf("--disablements-file=%q ", nlInitArgs.disablementsFile)
+		}
 		fmt.Println("\nIf this is correct, please re-run this command with the --confirm flag:")
 		fmt.Printf("\t%s lock init --confirm --gen-disablements %d %s%s", os.Args[0], nlInitArgs.numDisablements, genSupportFlag, strings.Join(args, " "))
 		fmt.Println()
 		return nil
 	}
 
-	fmt.Printf("%d disablement secrets have been generated and are printed below. Take note of them now, they WILL NOT be shown again.\n", nlInitArgs.numDisablements)
+	var secrets [][]byte
 	for range nlInitArgs.numDisablements {
-		var secret [32]byte
-		if _, err := rand.Read(secret[:]); err != nil {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
 			return err
 		}
-		fmt.Printf("\tdisablement-secret:%X\n", secret[:])
-		disablementValues = append(disablementValues, tka.DisablementKDF(secret[:]))
+		secrets = append(secrets, secret)
+		disablementValues = append(disablementValues, tka.DisablementKDF(secret))
+	}
+	// Save the secrets before initializing, so that if we can't, tailnet
+	// lock isn't left enabled with secrets that only went to the terminal.
+	if nlInitArgs.disablementsFile != "" {
+		if err := writeDisablementSecrets(nlInitArgs.disablementsFile, secrets); err != nil {
+			return fmt.Errorf("saving disablement secrets: %w", err)
+		}
+		fmt.Printf("The disablement secrets have been written to %s.\n", nlInitArgs.disablementsFile)
+	}
+	fmt.Printf("%d disablement secrets have been generated and are printed below. Take note of them now, they WILL NOT be shown again.\n", nlInitArgs.numDisablements)
+	for _, secret := range secrets {
+		fmt.Printf("\tdisablement-secret:%X\n", secret)
 	}
 
 	var supportDisablement []byte
@@ -369,6 +391,24 @@ func runNetworkLockRemove(ctx context.Context, args []string) error {
 	return localClient.NetworkLockModify(ctx, nil, removeKeys)
 }
 
+// writeDisablementSecrets writes secrets to a new file at path, one per
+// line, in the form accepted by 'tailscale lock disable'. It fails if the
+// file already exists, rather than overwrite other secrets.
+func writeDisablementSecrets(path string, secrets [][]byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		fmt.Fprintf(f, "disablement-secret:%X\n", secret)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 // parseNLArgs parses a slice of strings into slices of tka.Key & disablement
 // values/secrets.
 // The keys encoded in args should be specified using their key.NLPublic.MarshalText
@@ -17,9 +17,10 @@
 )
 
 var (
-	riskTypes   []string
-	riskLoseSSH = registerRiskType("lose-ssh")
-	riskAll     = registerRiskType("all")
+	riskTypes               []string
+	riskLoseSSH             = registerRiskType("lose-ssh")
+	riskUnreachableExitNode = registerRiskType("unreachable-exit-node")
+	riskAll                 = registerRiskType("all")
 )
 
 func registerRiskType(riskType string) string {
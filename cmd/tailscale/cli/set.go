@@ -21,6 +21,7 @@
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/safesocket"
 	"tailscale.com/types/opt"
+	"tailscale.com/types/preftype"
 	"tailscale.com/types/views"
 	"tailscale.com/version"
 )
@@ -42,6 +43,8 @@
 type setArgsT struct {
 	acceptRoutes           bool
 	acceptDNS              bool
+	dnsOverride            string
+	dohUpgrade             bool
 	exitNodeIP             string
 	exitNodeAllowLANAccess bool
 	shieldsUp              bool
@@ -58,6 +61,7 @@ type setArgsT struct {
 	updateCheck            bool
 	updateApply            bool
 	postureChecking        bool
+	hideExperimental       bool
 	snat                   bool
 	statefulFiltering      bool
 	netfilterMode          string
@@ -69,6 +73,8 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.StringVar(&setArgs.profileName, "nickname", "", "nickname for the current account")
 	setf.BoolVar(&setArgs.acceptRoutes, "accept-routes", false, "accept routes advertised by other Tailscale nodes")
 	setf.BoolVar(&setArgs.acceptDNS, "accept-dns", false, "accept DNS configuration from the admin panel")
+	setf.StringVar(&setArgs.dnsOverride, "dns-override", "off", `override the admin panel's DNS configuration on this device ("off", "local-resolvers" to only use it for MagicDNS names, or "ignore")`)
+	setf.BoolVar(&setArgs.dohUpgrade, "doh-upgrade", true, "query well-known public DNS resolvers over DNS-over-HTTPS where they support it")
 	setf.StringVar(&setArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
 	setf.BoolVar(&setArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
 	setf.BoolVar(&setArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
@@ -80,6 +86,7 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.updateCheck, "update-check", true, "notify about available Tailscale updates")
 	setf.BoolVar(&setArgs.updateApply, "auto-update", false, "automatically update to the latest available version")
 	setf.BoolVar(&setArgs.postureChecking, "posture-checking", false, hidden+"allow management plane to gather device posture information")
+	setf.BoolVar(&setArgs.hideExperimental, "hide-experimental-features", false, hidden+"don't report enabled experimental datapath features to the management plane or in bug reports")
 	setf.BoolVar(&setArgs.runWebClient, "webclient", false, "expose the web interface for managing this node over Tailscale at port 5252")
 
 	ffcomplete.Flag(setf, "exit-node", func(args []string) ([]string, ffcomplete.ShellCompDirective, error) {
@@ -143,6 +150,7 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			Hostname:               setArgs.hostname,
 			OperatorUser:           setArgs.opUser,
 			NoSNAT:                 !setArgs.snat,
+			NoDoHUpgrade:           !setArgs.dohUpgrade,
 			ForceDaemon:            setArgs.forceDaemon,
 			AutoUpdate: ipn.AutoUpdatePrefs{
 				Check: setArgs.updateCheck,
@@ -151,11 +159,18 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			AppConnector: ipn.AppConnectorPrefs{
 				Advertise: setArgs.advertiseConnector,
 			},
-			PostureChecking:     setArgs.postureChecking,
-			NoStatefulFiltering: opt.NewBool(!setArgs.statefulFiltering),
+			PostureChecking:          setArgs.postureChecking,
+			HideExperimentalFeatures: setArgs.hideExperimental,
+			NoStatefulFiltering:      opt.NewBool(!setArgs.statefulFiltering),
 		},
 	}
 
+	dnsOverride, err := preftype.ParseDNSOverride(setArgs.dnsOverride)
+	if err != nil {
+		return err
+	}
+	maskedPrefs.Prefs.DNSOverride = dnsOverride
+
 	if effectiveGOOS() == "linux" {
 		nfMode, warning, err := netfilterModeFromFlag(setArgs.netfilterMode)
 		if err != nil {
@@ -175,6 +190,12 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			}
 			return err
 		}
+		if reason := exitNodeUnreachableReason(ctx, st, maskedPrefs.Prefs.ExitNodeIP, pingExitNode); reason != "" {
+			msg := reason + " Using it will cut this device off from the internet until it is reachable again or you stop using it."
+			if err := presentRiskToUser(riskUnreachableExitNode, msg, setArgs.acceptedRisks); err != nil {
+				return err
+			}
+		}
 	}
 
 	warnOnAdvertiseRouts(ctx, &maskedPrefs.Prefs)
@@ -15,8 +15,10 @@
 	"net/http"
 	"net/netip"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"github.com/toqueteos/webbrowser"
@@ -24,12 +26,13 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netmon"
+	"tailscale.com/tailcfg"
 	"tailscale.com/util/dnsname"
 )
 
 var statusCmd = &ffcli.Command{
 	Name:       "status",
-	ShortUsage: "tailscale status [--active] [--web] [--json]",
+	ShortUsage: "tailscale status [--active] [--web] [--json] [--sort=name|latency|traffic]",
 	ShortHelp:  "Show state of tailscaled and its connections",
 	LongHelp: strings.TrimSpace(`
 
@@ -56,6 +59,7 @@
 		fs.BoolVar(&statusArgs.peers, "peers", true, "show status of peers")
 		fs.StringVar(&statusArgs.listen, "listen", "127.0.0.1:8384", "listen address for web mode; use port 0 for automatic")
 		fs.BoolVar(&statusArgs.browser, "browser", true, "Open a browser in web mode")
+		fs.StringVar(&statusArgs.sort, "sort", "name", "order of peers in CLI mode: name, latency (lowest first), or traffic (most first)")
 		return fs
 	})(),
 }
@@ -68,12 +72,18 @@
 	active  bool   // in CLI mode, filter output to only peers with active sessions
 	self    bool   // in CLI mode, show status of local machine
 	peers   bool   // in CLI mode, show status of peer machines
+	sort    string // in CLI mode, order of peers: "name", "latency", or "traffic"
 }
 
 func runStatus(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unexpected non-flag arguments to 'tailscale status'")
 	}
+	switch statusArgs.sort {
+	case "name", "latency", "traffic":
+	default:
+		return fmt.Errorf("invalid --sort value %q; must be one of name, latency, or traffic", statusArgs.sort)
+	}
 	getStatus := localClient.Status
 	if !statusArgs.peers {
 		getStatus = localClient.StatusWithoutPeers
@@ -152,11 +162,12 @@ func runStatus(ctx context.Context, args []string) error {
 	var buf bytes.Buffer
 	f := func(format string, a ...any) { fmt.Fprintf(&buf, format, a...) }
 	printPS := func(ps *ipnstate.PeerStatus) {
-		f("%-15s %-20s %-12s %-7s ",
+		f("%-15s %-20s %-12s %-7s %-7s ",
 			firstIPString(ps.TailscaleIPs),
 			dnsOrQuoteHostname(st, ps),
 			ownerLogin(st, ps),
 			ps.OS,
+			latencyString(ps.LatencySeconds),
 		)
 		relay := ps.Relay
 		anyTraffic := ps.TxBytes != 0 || ps.RxBytes != 0
@@ -218,7 +229,7 @@ func runStatus(ctx context.Context, args []string) error {
 			}
 			peers = append(peers, ps)
 		}
-		ipnstate.SortPeers(peers)
+		sortStatusPeers(peers, statusArgs.sort)
 		for _, ps := range peers {
 			if statusArgs.active && !ps.Active {
 				continue
@@ -231,6 +242,10 @@ func runStatus(ctx context.Context, args []string) error {
 		outln()
 		printf("# To see the full list of exit nodes, including location-based exit nodes, run `tailscale exit-node list`  \n")
 	}
+	if statusArgs.peers && len(st.RouteConflicts) > 0 {
+		outln()
+		printRouteConflicts(st)
+	}
 	if len(st.Health) > 0 {
 		outln()
 		printHealth()
@@ -239,6 +254,60 @@ func runStatus(ctx context.Context, args []string) error {
 	return nil
 }
 
+// latencyString returns secs, a PeerStatus.LatencySeconds, formatted for the
+// status table.
+func latencyString(secs float64) string {
+	if secs <= 0 {
+		return "-"
+	}
+	d := time.Duration(secs * float64(time.Second))
+	if d < time.Millisecond {
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// sortStatusPeers sorts peers for display by the given --sort value. Ties,
+// and peers without a latency when sorting by latency, are ordered by name.
+func sortStatusPeers(peers []*ipnstate.PeerStatus, by string) {
+	ipnstate.SortPeers(peers)
+	switch by {
+	case "latency":
+		slices.SortStableFunc(peers, func(a, b *ipnstate.PeerStatus) int {
+			switch {
+			case a.LatencySeconds == b.LatencySeconds:
+				return 0
+			case a.LatencySeconds == 0:
+				return 1
+			case b.LatencySeconds == 0:
+				return -1
+			}
+			return cmp.Compare(a.LatencySeconds, b.LatencySeconds)
+		})
+	case "traffic":
+		slices.SortStableFunc(peers, func(a, b *ipnstate.PeerStatus) int {
+			return cmp.Compare(b.TxBytes+b.RxBytes, a.TxBytes+a.RxBytes)
+		})
+	}
+}
+
+// printRouteConflicts prints the subnet routes of different peers that
+// overlap, with the primary router of each.
+func printRouteConflicts(st *ipnstate.Status) {
+	name := func(id tailcfg.StableNodeID) string {
+		for _, ps := range st.Peer {
+			if ps.ID == id {
+				return dnsOrQuoteHostname(st, ps)
+			}
+		}
+		return string(id)
+	}
+	printf("# Overlapping subnet routes (traffic goes to the most specific one):\n")
+	for _, rc := range st.RouteConflicts {
+		printf("#     - %v via %s overlaps %v\n", rc.Prefix, name(rc.Primary), rc.Overlaps)
+	}
+}
+
 // printFunnelStatus prints the status of the funnel, if it's running.
 // It prints nothing if the funnel is not running.
 func printFunnelStatus(ctx context.Context) {
@@ -0,0 +1,64 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"slices"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+func TestSortStatusPeers(t *testing.T) {
+	peers := func() []*ipnstate.PeerStatus {
+		return []*ipnstate.PeerStatus{
+			{DNSName: "a.", LatencySeconds: 0.030, TxBytes: 10},
+			{DNSName: "b.", RxBytes: 500},
+			{DNSName: "c.", LatencySeconds: 0.002, TxBytes: 100, RxBytes: 100},
+			{DNSName: "d.", LatencySeconds: 0.030},
+		}
+	}
+	names := func(ps []*ipnstate.PeerStatus) []string {
+		var ret []string
+		for _, p := range ps {
+			ret = append(ret, p.DNSName)
+		}
+		return ret
+	}
+	tests := []struct {
+		by   string
+		want []string
+	}{
+		{"name", []string{"a.", "b.", "c.", "d."}},
+		{"latency", []string{"c.", "a.", "d.", "b."}},
+		{"traffic", []string{"b.", "c.", "a.", "d."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			ps := peers()
+			slices.Reverse(ps)
+			sortStatusPeers(ps, tt.by)
+			if got := names(ps); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatencyString(t *testing.T) {
+	tests := []struct {
+		secs float64
+		want string
+	}{
+		{0, "-"},
+		{0.000123, "120µs"},
+		{0.0123, "12ms"},
+		{1.5, "1.5s"},
+	}
+	for _, tt := range tests {
+		if got := latencyString(tt.secs); got != tt.want {
+			t.Errorf("latencyString(%v) = %q; want %q", tt.secs, got, tt.want)
+		}
+	}
+}
@@ -57,9 +57,12 @@
 If flags are specified, the flags must be the complete set of desired
 settings. An error is returned if any setting would be changed as a
 result of an unspecified flag's default value, unless the --reset flag
-is also used. (The flags --auth-key, --force-reauth, and --qr are not
-considered settings that need to be re-specified when modifying
-settings.)
+is also used. (The flags --auth-key, --force-reauth, --fix-sysctls and
+--qr are not considered settings that need to be re-specified when
+modifying settings.)
+
+To be walked through logging in and configuring an exit node, subnet
+routes and Tailscale SSH with prompts, use --interactive.
 `),
 	FlagSet: upFlagSet,
 	Exec: func(ctx context.Context, args []string) error {
@@ -99,11 +102,13 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 	// When adding new flags, prefer to put them under "tailscale set" instead
 	// of here. Setting preferences via "tailscale up" is deprecated.
 	upf.BoolVar(&upArgs.qr, "qr", false, "show QR code for login URLs")
-	upf.StringVar(&upArgs.authKeyOrFile, "auth-key", "", `node authorization key; if it begins with "file:", then it's a path to a file containing the authkey`)
+	upf.StringVar(&upArgs.authKeyOrFile, "auth-key", "", `node authorization key; if it begins with "file:", then it's a path to a file containing the authkey, read once by this command (for tailscaled to pick up a rotated key from a file, set AuthKey to "file:<path>" in its config file instead)`)
 
 	upf.StringVar(&upArgs.server, "login-server", ipn.DefaultControlURL, "base URL of control server")
 	upf.BoolVar(&upArgs.acceptRoutes, "accept-routes", acceptRouteDefault(goos), "accept routes advertised by other Tailscale nodes")
 	upf.BoolVar(&upArgs.acceptDNS, "accept-dns", true, "accept DNS configuration from the admin panel")
+	upf.StringVar(&upArgs.dnsOverride, "dns-override", "off", `override the admin panel's DNS configuration on this device ("off", "local-resolvers" to only use it for MagicDNS names, or "ignore")`)
+	upf.BoolVar(&upArgs.dohUpgrade, "doh-upgrade", true, "query well-known public DNS resolvers over DNS-over-HTTPS where they support it")
 	upf.BoolVar(&upArgs.singleRoutes, "host-routes", true, hidden+"install host routes to other Tailscale nodes")
 	upf.StringVar(&upArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
 	upf.BoolVar(&upArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
@@ -137,6 +142,10 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 		upf.BoolVar(&upArgs.json, "json", false, "output in JSON format (WARNING: format subject to change)")
 		upf.BoolVar(&upArgs.reset, "reset", false, "reset unspecified settings to their default values")
 		upf.BoolVar(&upArgs.forceReauth, "force-reauth", false, "force reauthentication")
+		upf.BoolVar(&upArgs.interactive, "interactive", false, "walk through logging in and choosing an exit node, subnet routes and Tailscale SSH with prompts")
+		if goos == "linux" {
+			upf.BoolVar(&upArgs.fixSysctls, "fix-sysctls", false, "with --advertise-routes or --advertise-exit-node, enable IP forwarding and loose reverse path filtering, and persist them in "+netutil.SysctlConfPath)
+		}
 		registerAcceptRiskFlag(upf, &upArgs.acceptedRisks)
 	}
 
@@ -156,6 +165,8 @@ type upArgsT struct {
 	server                 string
 	acceptRoutes           bool
 	acceptDNS              bool
+	dnsOverride            string
+	dohUpgrade             bool
 	singleRoutes           bool
 	exitNodeIP             string
 	exitNodeAllowLANAccess bool
@@ -178,8 +189,15 @@ type upArgsT struct {
 	timeout                time.Duration
 	acceptedRisks          string
 	profileName            string
+	interactive            bool
+	fixSysctls             bool
 }
 
+// getAuthKey returns the auth key given with --auth-key, reading it from
+// the named file if it's given as "file:<path>". The file is read once, by
+// the CLI, as tailscaled may not be able to read the user's files; unlike
+// with the config file's AuthKey, tailscaled doesn't watch it for a
+// rotated key.
 func (a upArgsT) getAuthKey() (string, error) {
 	v := a.authKeyOrFile
 	if file, ok := strings.CutPrefix(v, "file:"); ok {
@@ -241,6 +259,9 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 	if upArgs.exitNodeIP == "" && upArgs.exitNodeAllowLANAccess {
 		return nil, fmt.Errorf("--exit-node-allow-lan-access can only be used with --exit-node")
 	}
+	if upArgs.fixSysctls && len(routes) == 0 {
+		return nil, fmt.Errorf("--fix-sysctls can only be used with --advertise-routes or --advertise-exit-node")
+	}
 
 	var tags []string
 	if upArgs.advertiseTags != "" {
@@ -278,6 +299,11 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 
 	prefs.ExitNodeAllowLANAccess = upArgs.exitNodeAllowLANAccess
 	prefs.CorpDNS = upArgs.acceptDNS
+	prefs.DNSOverride, err = preftype.ParseDNSOverride(upArgs.dnsOverride)
+	if err != nil {
+		return nil, err
+	}
+	prefs.NoDoHUpgrade = !upArgs.dohUpgrade
 	prefs.AllowSingleHosts = upArgs.singleRoutes
 	prefs.ShieldsUp = upArgs.shieldsUp
 	prefs.RunSSH = upArgs.runSSH
@@ -410,6 +436,9 @@ func presentSSHToggleRisk(wantSSH, haveSSH bool, acceptedRisks string) error {
 }
 
 func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retErr error) {
+	if upArgs.interactive {
+		return runUpInteractive(ctx, args, upArgs)
+	}
 	var egg bool
 	if len(args) > 0 {
 		egg = fmt.Sprint(args) == "[up down down left right left right b a]"
@@ -463,7 +492,10 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 		fatalf("%s", err)
 	}
 
-	warnOnAdvertiseRouts(ctx, prefs)
+	if !upArgs.fixSysctls {
+		// Otherwise, fixSysctls warns once it's done.
+		warnOnAdvertiseRouts(ctx, prefs)
+	}
 
 	curPrefs, err := localClient.GetPrefs(ctx)
 	if err != nil {
@@ -497,8 +529,13 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 	}
 	if justEditMP != nil {
 		justEditMP.EggSet = egg
-		_, err := localClient.EditPrefs(ctx, justEditMP)
-		return err
+		if _, err := localClient.EditPrefs(ctx, justEditMP); err != nil {
+			return err
+		}
+		if upArgs.fixSysctls {
+			return fixSysctls(ctx, prefs)
+		}
+		return nil
 	}
 
 	watchCtx, cancelWatch := context.WithCancel(ctx)
@@ -556,6 +593,11 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 			}
 		}
 	}
+	if upArgs.fixSysctls {
+		if err := fixSysctls(ctx, prefs); err != nil {
+			return err
+		}
+	}
 
 	watcher, err := localClient.WatchIPNBus(watchCtx, ipn.NotifyInitialState)
 	if err != nil {
@@ -738,6 +780,8 @@ func init() {
 
 	// The rest are 1:1:
 	addPrefFlagMapping("accept-dns", "CorpDNS")
+	addPrefFlagMapping("dns-override", "DNSOverride")
+	addPrefFlagMapping("doh-upgrade", "NoDoHUpgrade")
 	addPrefFlagMapping("accept-routes", "RouteAll")
 	addPrefFlagMapping("advertise-tags", "AdvertiseTags")
 	addPrefFlagMapping("host-routes", "AllowSingleHosts")
@@ -757,6 +801,7 @@ func init() {
 	addPrefFlagMapping("auto-update", "AutoUpdate.Apply")
 	addPrefFlagMapping("advertise-connector", "AppConnector")
 	addPrefFlagMapping("posture-checking", "PostureChecking")
+	addPrefFlagMapping("hide-experimental-features", "HideExperimentalFeatures")
 }
 
 func addPrefFlagMapping(flagName string, prefNames ...string) {
@@ -779,7 +824,7 @@ func addPrefFlagMapping(flagName string, prefNames ...string) {
 // correspond to an ipn.Pref.
 func preflessFlag(flagName string) bool {
 	switch flagName {
-	case "auth-key", "force-reauth", "reset", "qr", "json", "timeout", "accept-risk":
+	case "auth-key", "force-reauth", "reset", "qr", "json", "timeout", "accept-risk", "interactive", "fix-sysctls":
 		return true
 	}
 	return false
@@ -979,6 +1024,10 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 			set(prefs.AllowSingleHosts)
 		case "accept-dns":
 			set(prefs.CorpDNS)
+		case "dns-override":
+			set(prefs.DNSOverride.String())
+		case "doh-upgrade":
+			set(!prefs.NoDoHUpgrade)
 		case "shields-up":
 			set(prefs.ShieldsUp)
 		case "exit-node":
@@ -1179,6 +1228,22 @@ func resolveAuthKey(ctx context.Context, v, tags string) (string, error) {
 	return authkey, nil
 }
 
+// fixSysctls has tailscaled set the sysctls that forwarding for the routes
+// it advertises needs, and then warns about anything still amiss, like
+// warnOnAdvertiseRouts. It must be called once prefs have been applied, as
+// tailscaled uses its current prefs' routes.
+func fixSysctls(ctx context.Context, prefs *ipn.Prefs) error {
+	fixes, err := localClient.FixIPForwarding(ctx)
+	if err != nil {
+		return fmt.Errorf("fixing sysctls: %w", err)
+	}
+	for _, f := range fixes {
+		fmt.Fprintf(Stderr, "Set %v, persisted in %s\n", f, netutil.SysctlConfPath)
+	}
+	warnOnAdvertiseRouts(ctx, prefs)
+	return nil
+}
+
 func warnOnAdvertiseRouts(ctx context.Context, prefs *ipn.Prefs) {
 	if len(prefs.AdvertiseRoutes) > 0 || prefs.AppConnector.Advertise {
 		// TODO(jwhited): compress CheckIPForwarding and CheckUDPGROForwarding
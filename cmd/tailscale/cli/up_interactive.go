@@ -0,0 +1,334 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netutil"
+	"tailscale.com/tailcfg"
+)
+
+// runUpInteractive implements "tailscale up --interactive": it walks the
+// user through logging in, choosing an exit node, advertising routes and
+// enabling Tailscale SSH, for first-time setup of machines (such as
+// headless servers over SSH) where working out the right flags is a chore.
+func runUpInteractive(ctx context.Context, args []string, upArgs upArgsT) error {
+	if len(args) > 0 {
+		fatalf("too many non-flag arguments: %q", args)
+	}
+	if upArgs.json {
+		return errors.New("--interactive can't be used with --json")
+	}
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return errors.New("--interactive requires a terminal; use flags to configure Tailscale non-interactively")
+	}
+	w := &upWizard{in: bufio.NewReader(os.Stdin), out: Stdout}
+
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if st.BackendState == ipn.NeedsLogin.String() || !st.HaveNodeKey || upArgs.forceReauth {
+		if upArgs.authKeyOrFile == "" {
+			if upArgs.authKeyOrFile, err = w.askAuthKey(); err != nil {
+				return err
+			}
+		}
+		// Log in using the other flags, as "tailscale up" would.
+		upArgs.interactive = false
+		if err := runUp(ctx, "up", nil, upArgs); err != nil {
+			return err
+		}
+		if st, err = localClient.Status(ctx); err != nil {
+			return err
+		}
+	}
+	if st.Self != nil {
+		if u, ok := st.User[st.Self.UserID]; ok {
+			fmt.Fprintf(w.out, "\nLogged in as %s.\n", u.LoginName)
+		}
+	}
+
+	curPrefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	mp, err := w.askSettings(st, curPrefs)
+	if err != nil {
+		return err
+	}
+	prefs := curPrefs.Clone()
+	prefs.ApplyEdits(mp)
+	if err := localClient.CheckPrefs(ctx, prefs); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w.out, "\nSettings:\n%s\n", w.summary(st, prefs))
+	if ok, err := w.askYesNo("Apply these settings?", true); err != nil || !ok {
+		if err == nil {
+			err = errors.New("settings not applied")
+		}
+		return err
+	}
+	if err := presentSSHToggleRisk(prefs.RunSSH, curPrefs.RunSSH, upArgs.acceptedRisks); err != nil {
+		return err
+	}
+	warnOnAdvertiseRouts(ctx, prefs)
+	if _, err := localClient.EditPrefs(ctx, mp); err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "\nDone. To change these settings later, run \"tailscale set\" or \"tailscale up --interactive\" again.\n")
+	checkUpWarnings(ctx)
+	return nil
+}
+
+// upWizard asks the questions of "tailscale up --interactive".
+type upWizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints question and returns the user's answer, or def if the answer
+// is empty. If check is non-nil and returns an error for the answer, the
+// error is printed and the question asked again.
+func (w *upWizard) ask(question, def string, check func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(w.out, "%s: ", question)
+		}
+		line, err := w.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			fmt.Fprintln(w.out)
+			return "", fmt.Errorf("reading answer: %w", err)
+		}
+		ans := strings.TrimSpace(line)
+		if ans == "" {
+			ans = def
+		}
+		if check != nil {
+			if err := check(ans); err != nil {
+				fmt.Fprintf(w.out, "  %v\n", err)
+				continue
+			}
+		}
+		return ans, nil
+	}
+}
+
+// askYesNo asks a yes or no question, with def as the answer if the user
+// just presses enter.
+func (w *upWizard) askYesNo(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	ans, err := w.ask(question+" ["+hint+"]", "", func(s string) error {
+		switch strings.ToLower(s) {
+		case "", "y", "yes", "n", "no":
+			return nil
+		}
+		return errors.New(`please answer "y" or "n"`)
+	})
+	if err != nil {
+		return false, err
+	}
+	if ans == "" {
+		return def, nil
+	}
+	return strings.HasPrefix(strings.ToLower(ans), "y"), nil
+}
+
+// askAuthKey asks how to log in, returning an auth key (or "file:" path
+// to one) or the empty string to log in with a URL.
+func (w *upWizard) askAuthKey() (string, error) {
+	fmt.Fprintf(w.out, "This machine isn't logged in to Tailscale.\n")
+	fmt.Fprintf(w.out, "Paste an auth key to log in with it, or leave this empty to log in by visiting a URL\n")
+	fmt.Fprintf(w.out, "in a browser on any device. To keep the key out of your terminal, enter file:/path/to/key.\n")
+	return w.ask("Auth key", "", func(s string) error {
+		if file, ok := strings.CutPrefix(s, "file:"); ok {
+			_, err := os.Stat(file)
+			return err
+		}
+		if s != "" && !strings.HasPrefix(s, "tskey-") {
+			return errors.New(`auth keys start with "tskey-"`)
+		}
+		return nil
+	})
+}
+
+// askSettings asks for the exit node to use, the routes to advertise and
+// whether to run Tailscale SSH, with the current settings in cur as the
+// defaults, and returns the edits to make.
+func (w *upWizard) askSettings(st *ipnstate.Status, cur *ipn.Prefs) (*ipn.MaskedPrefs, error) {
+	mp := &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			WantRunning: true,
+		},
+		WantRunningSet: true,
+	}
+
+	exitNode, err := w.askExitNode(st, cur.ExitNodeID)
+	if err != nil {
+		return nil, err
+	}
+	mp.ExitNodeID = exitNode
+	mp.ExitNodeIDSet = true
+	mp.ExitNodeIPSet = true // clear any exit node chosen by IP
+
+	var curRoutes []string
+	curExit := false
+	for _, r := range cur.AdvertiseRoutes {
+		if r.Bits() == 0 {
+			curExit = true
+		} else {
+			curRoutes = append(curRoutes, r.String())
+		}
+	}
+	def := "none"
+	if len(curRoutes) > 0 {
+		def = strings.Join(curRoutes, ",")
+	}
+	fmt.Fprintf(w.out, "\nThis machine can act as a subnet router, giving other devices in your tailnet\n")
+	fmt.Fprintf(w.out, "access to networks it's connected to.\n")
+	routes, err := w.ask(`Subnet routes to advertise, comma-separated (e.g. "192.168.1.0/24"), or "none"`, def, func(s string) error {
+		_, err := calcRoutesAnswer(s, false)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Using and offering an exit node are mutually exclusive, so only
+	// ask about offering one if this machine isn't going to use one.
+	advertiseExit := false
+	if exitNode == "" {
+		if advertiseExit, err = w.askYesNo("\nOffer this machine as an exit node for other devices' internet traffic?", curExit); err != nil {
+			return nil, err
+		}
+	}
+	if mp.AdvertiseRoutes, err = calcRoutesAnswer(routes, advertiseExit); err != nil {
+		return nil, err
+	}
+	mp.AdvertiseRoutesSet = true
+
+	fmt.Fprintf(w.out, "\nTailscale SSH lets devices in your tailnet SSH to this machine, as permitted by\n")
+	fmt.Fprintf(w.out, "your tailnet's access policy, without managing SSH keys.\n")
+	if mp.RunSSH, err = w.askYesNo("Run the Tailscale SSH server?", cur.RunSSH); err != nil {
+		return nil, err
+	}
+	mp.RunSSHSet = true
+	return mp, nil
+}
+
+// askExitNode lists the exit nodes in st and asks which to use, if any,
+// with cur as the default. It returns the empty string for none.
+func (w *upWizard) askExitNode(st *ipnstate.Status, cur tailcfg.StableNodeID) (tailcfg.StableNodeID, error) {
+	var peers []*ipnstate.PeerStatus
+	for _, ps := range st.Peer {
+		if ps.ExitNodeOption {
+			peers = append(peers, ps)
+		}
+	}
+	if len(peers) == 0 {
+		fmt.Fprintf(w.out, "\nThere are no exit nodes in your tailnet to route this machine's internet traffic through.\n")
+		return "", nil
+	}
+	slices.SortFunc(peers, func(a, b *ipnstate.PeerStatus) int {
+		return strings.Compare(a.DNSName, b.DNSName)
+	})
+
+	fmt.Fprintf(w.out, "\nExit nodes route this machine's internet traffic through another device:\n")
+	fmt.Fprintf(w.out, "  0) none\n")
+	def := "0"
+	for i, ps := range peers {
+		var ip string
+		if len(ps.TailscaleIPs) > 0 {
+			ip = " (" + ps.TailscaleIPs[0].String() + ")"
+		}
+		var offline string
+		if !ps.Online {
+			offline = " [offline]"
+		}
+		fmt.Fprintf(w.out, "  %d) %s%s%s\n", i+1, dnsOrQuoteHostname(st, ps), ip, offline)
+		if ps.ID == cur {
+			def = strconv.Itoa(i + 1)
+		}
+	}
+	ans, err := w.ask("Exit node to use", def, func(s string) error {
+		if n, err := strconv.Atoi(s); err != nil || n < 0 || n > len(peers) {
+			return fmt.Errorf("please enter a number from 0 to %d", len(peers))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	n, _ := strconv.Atoi(ans)
+	if n == 0 {
+		return "", nil
+	}
+	return peers[n-1].ID, nil
+}
+
+// calcRoutesAnswer returns the routes to advertise for an answer to the
+// subnet routes question.
+func calcRoutesAnswer(ans string, advertiseExit bool) ([]netip.Prefix, error) {
+	if strings.EqualFold(ans, "none") {
+		ans = ""
+	}
+	return netutil.CalcAdvertiseRoutes(strings.ReplaceAll(ans, " ", ""), advertiseExit)
+}
+
+// summary returns a description of the settings asked about in prefs.
+func (w *upWizard) summary(st *ipnstate.Status, prefs *ipn.Prefs) string {
+	var sb strings.Builder
+	exitNode := "none"
+	if ps, ok := peerForID(st, prefs.ExitNodeID); ok {
+		exitNode = dnsOrQuoteHostname(st, ps)
+	}
+	var routes []string
+	offerExit := false
+	for _, r := range prefs.AdvertiseRoutes {
+		if r.Bits() == 0 {
+			offerExit = true
+		} else {
+			routes = append(routes, r.String())
+		}
+	}
+	if len(routes) == 0 {
+		routes = []string{"none"}
+	}
+	fmt.Fprintf(&sb, "  exit node:         %s\n", exitNode)
+	fmt.Fprintf(&sb, "  subnet routes:     %s\n", strings.Join(routes, ", "))
+	fmt.Fprintf(&sb, "  offer exit node:   %v\n", offerExit)
+	fmt.Fprintf(&sb, "  Tailscale SSH:     %v", prefs.RunSSH)
+	return sb.String()
+}
+
+// peerForID returns the peer in st with the given stable ID.
+func peerForID(st *ipnstate.Status, id tailcfg.StableNodeID) (*ipnstate.PeerStatus, bool) {
+	if id == "" {
+		return nil, false
+	}
+	for _, ps := range st.Peer {
+		if ps.ID == id {
+			return ps, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bufio"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestUpWizardSettings(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	st := &ipnstate.Status{
+		MagicDNSSuffix: "example.ts.net",
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {ID: "exit-b", DNSName: "exit-b.example.ts.net.", ExitNodeOption: true, Online: true},
+			key.NewNode().Public(): {ID: "exit-a", DNSName: "exit-a.example.ts.net.", ExitNodeOption: true},
+			key.NewNode().Public(): {ID: "laptop", DNSName: "laptop.example.ts.net."},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		cur   *ipn.Prefs
+		input string
+		want  ipn.Prefs
+	}{
+		{
+			name:  "defaults",
+			cur:   &ipn.Prefs{},
+			input: "\n\n\n\n",
+		},
+		{
+			name: "keep-current",
+			cur: &ipn.Prefs{
+				ExitNodeID:      "exit-b",
+				AdvertiseRoutes: []netip.Prefix{pfx("10.0.0.0/24")},
+				RunSSH:          true,
+			},
+			input: "\n\n\n",
+			want: ipn.Prefs{
+				ExitNodeID:      "exit-b",
+				AdvertiseRoutes: []netip.Prefix{pfx("10.0.0.0/24")},
+				RunSSH:          true,
+			},
+		},
+		{
+			name: "exit-node-and-routes",
+			cur:  &ipn.Prefs{},
+			// Invalid answers are asked again. There's no question
+			// about offering an exit node when using one.
+			input: "9\n1\nbogus\n192.168.1.0/24, 10.0.0.0/8\nmaybe\ny\n",
+			want: ipn.Prefs{
+				ExitNodeID:      "exit-a",
+				AdvertiseRoutes: []netip.Prefix{pfx("10.0.0.0/8"), pfx("192.168.1.0/24")},
+				RunSSH:          true,
+			},
+		},
+		{
+			name:  "offer-exit-node",
+			cur:   &ipn.Prefs{ExitNodeID: "exit-a", AdvertiseRoutes: []netip.Prefix{pfx("10.0.0.0/24")}},
+			input: "0\nnone\nyes\nn\n",
+			want: ipn.Prefs{
+				AdvertiseRoutes: []netip.Prefix{pfx("0.0.0.0/0"), pfx("::/0")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			w := &upWizard{in: bufio.NewReader(strings.NewReader(tt.input)), out: &out}
+			mp, err := w.askSettings(st, tt.cur)
+			if err != nil {
+				t.Fatalf("askSettings: %v\noutput:\n%s", err, out.String())
+			}
+			if !mp.WantRunningSet || !mp.ExitNodeIDSet || !mp.ExitNodeIPSet || !mp.AdvertiseRoutesSet || !mp.RunSSHSet {
+				t.Errorf("missing Set fields: %v", mp.Pretty())
+			}
+			got := mp.Prefs
+			got.WantRunning = false
+			if !reflect.DeepEqual(got.ExitNodeID, tt.want.ExitNodeID) ||
+				!reflect.DeepEqual(got.AdvertiseRoutes, tt.want.AdvertiseRoutes) ||
+				got.RunSSH != tt.want.RunSSH {
+				t.Errorf("got %v\nwant %v\noutput:\n%s", got.Pretty(), tt.want.Pretty(), out.String())
+			}
+		})
+	}
+
+	t.Run("eof", func(t *testing.T) {
+		w := &upWizard{in: bufio.NewReader(strings.NewReader("1\n")), out: new(strings.Builder)}
+		if _, err := w.askSettings(st, &ipn.Prefs{}); err == nil {
+			t.Error("askSettings succeeded with input ending early")
+		}
+	})
+
+	t.Run("exit-node-list", func(t *testing.T) {
+		var out strings.Builder
+		w := &upWizard{in: bufio.NewReader(strings.NewReader("\n")), out: &out}
+		id, err := w.askExitNode(st, "exit-b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != tailcfg.StableNodeID("exit-b") {
+			t.Errorf("got %q; want current exit node as the default", id)
+		}
+		want := "  0) none\n  1) exit-a [offline]\n  2) exit-b\nExit node to use [2]: "
+		if !strings.HasSuffix(out.String(), want) {
+			t.Errorf("output:\n%s\nwant suffix:\n%s", out.String(), want)
+		}
+	})
+}
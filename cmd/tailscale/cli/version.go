@@ -10,8 +10,8 @@
 	"fmt"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/clientupdate"
-	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/version"
 )
 
@@ -40,12 +40,18 @@ func runVersion(ctx context.Context, args []string) error {
 		return fmt.Errorf("too many non-flag arguments: %q", args)
 	}
 	var err error
-	var st *ipnstate.Status
+	var daemon *apitype.VersionResponse
 
 	if versionArgs.daemon {
-		st, err = localClient.StatusWithoutPeers(ctx)
+		daemon, err = localClient.Version(ctx)
 		if err != nil {
-			return err
+			// Older daemons don't have the version endpoint; fall
+			// back to the version in their status.
+			st, err := localClient.StatusWithoutPeers(ctx)
+			if err != nil {
+				return err
+			}
+			daemon = &apitype.VersionResponse{Meta: version.Meta{Long: st.Version}}
 		}
 	}
 
@@ -59,22 +65,24 @@ func runVersion(ctx context.Context, args []string) error {
 
 	if versionArgs.json {
 		m := version.GetMeta()
-		if st != nil {
-			m.DaemonLong = st.Version
+		if daemon != nil {
+			m.DaemonLong = daemon.Long
 		}
 		out := struct {
 			version.Meta
-			Upstream string `json:"upstream,omitempty"`
+			Upstream string                   `json:"upstream,omitempty"`
+			Daemon   *apitype.VersionResponse `json:"daemon,omitempty"`
 		}{
 			Meta:     m,
 			Upstream: upstreamVer,
+			Daemon:   daemon,
 		}
 		e := json.NewEncoder(Stdout)
 		e.SetIndent("", "\t")
 		return e.Encode(out)
 	}
 
-	if st == nil {
+	if daemon == nil {
 		outln(version.String())
 		if versionArgs.upstream {
 			printf("  upstream: %s\n", upstreamVer)
@@ -82,7 +90,7 @@ func runVersion(ctx context.Context, args []string) error {
 		return nil
 	}
 	printf("Client: %s\n", version.String())
-	printf("Daemon: %s\n", st.Version)
+	printf("Daemon: %s\n", daemon.Long)
 	if versionArgs.upstream {
 		printf("Upstream: %s\n", upstreamVer)
 	}
@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// NetworkManager's NMMetered values that mean the network is metered.
+const (
+	nmMeteredYes      = 1
+	nmMeteredGuessYes = 3
+)
+
+// meteredNetworkFunc returns a func that reports whether NetworkManager
+// considers the primary connection metered, for logtail's metered upload
+// limits. It only connects to the system bus when first called, and
+// reports networks as unmetered if NetworkManager can't be asked.
+func meteredNetworkFunc() func() bool {
+	var (
+		once sync.Once
+		nm   dbus.BusObject
+	)
+	return func() bool {
+		once.Do(func() {
+			if conn, err := dbus.SystemBus(); err == nil {
+				nm = conn.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager")
+			}
+		})
+		if nm == nil {
+			return false
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		var v dbus.Variant
+		if err := nm.CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, "org.freedesktop.NetworkManager", "Metered").Store(&v); err != nil {
+			return false
+		}
+		m, _ := v.Value().(uint32)
+		return m == nmMeteredYes || m == nmMeteredGuessYes
+	}
+}
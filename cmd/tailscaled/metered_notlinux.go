@@ -0,0 +1,10 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package main
+
+// meteredNetworkFunc returns nil, as tailscaled can't yet detect metered
+// networks on this platform.
+func meteredNetworkFunc() func() bool { return nil }
@@ -0,0 +1,77 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/logpolicy"
+	"tailscale.com/types/logger"
+)
+
+// sandboxFeatures are the parts of tailscaled's configuration that decide
+// what the sandbox must allow.
+type sandboxFeatures struct {
+	ssh        bool     // Tailscale SSH is on
+	sockBypass bool     // the socket bypass BPF programs are attached
+	varRoot    string   // state directory, or empty
+	statePath  string   // state file, or empty if not a file
+	socketPath string   // LocalAPI unix socket
+	logsDir    string   // where logs are buffered
+	fileRoot   string   // Taildrop directory outside varRoot, or empty
+	shares     []string // Taildrive shares' directories
+}
+
+// applySandbox restricts the process per f. It's non-nil on platforms
+// that support sandboxing.
+var applySandbox func(logf logger.Logf, f sandboxFeatures) error
+
+// maybeSandbox applies the sandbox if --sandbox was given and TS_NO_SANDBOX
+// isn't set. Everything tailscaled runs inherits the sandbox, so it's not
+// applied while Tailscale SSH is on, and once applied, it turns Tailscale
+// SSH off. It also restricts new Taildrive shares to the directories
+// already shared, the only ones the sandbox lets tailscaled read.
+func maybeSandbox(logf logger.Logf, lb *ipnlocal.LocalBackend, sockBypass bool, fileRoot string) {
+	if !args.sandbox {
+		return
+	}
+	logf = logger.WithPrefix(logf, "sandbox: ")
+	if envknob.Bool("TS_NO_SANDBOX") {
+		logf("not applied: TS_NO_SANDBOX is set")
+		return
+	}
+	if applySandbox == nil {
+		logf("not supported on %s", runtime.GOOS)
+		return
+	}
+	f := sandboxFeatures{
+		ssh:        lb.Prefs().RunSSH() && envknob.CanSSHD(),
+		sockBypass: sockBypass,
+		varRoot:    lb.TailscaleVarRoot(),
+		socketPath: args.socketpath,
+		logsDir:    logpolicy.LogsDir(logger.Discard),
+		fileRoot:   fileRoot,
+	}
+	shares := lb.DriveGetShares()
+	for i := 0; i < shares.Len(); i++ {
+		f.shares = append(f.shares, shares.At(i).Path())
+	}
+	if p := statePathOrDefault(); filepath.IsAbs(p) {
+		f.statePath = p
+	}
+	if f.ssh {
+		logf("not applied: Tailscale SSH is on, and its sessions would inherit it")
+		return
+	}
+	if err := applySandbox(logf, f); err != nil {
+		logf("not applied: %v; set TS_NO_SANDBOX=1 or remove --sandbox if it breaks your setup", err)
+		return
+	}
+	envknob.Setenv("TS_DISABLE_SSH_SERVER", "1")
+	lb.DriveRestrictShares(f.shares)
+	logf("Tailscale SSH is disabled and new Taildrive shares are limited to %q while sandboxed; set TS_NO_SANDBOX=1 or remove --sandbox if it breaks your setup", f.shares)
+}
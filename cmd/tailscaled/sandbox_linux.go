@@ -0,0 +1,279 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	applySandbox = applySandboxLinux
+}
+
+// sandboxPlan is what the sandbox allows, for some sandboxFeatures.
+type sandboxPlan struct {
+	deny  []uint32       // syscalls that fail with EPERM, if seccompSupported
+	rules []landlockRule // paths that may be accessed
+}
+
+// landlockRule allows access, a set of LANDLOCK_ACCESS_FS_* rights, to the
+// file or directory tree at path.
+type landlockRule struct {
+	path   string
+	access uint64
+}
+
+const (
+	landlockRead = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	landlockExec = landlockRead | unix.LANDLOCK_ACCESS_FS_EXECUTE
+	landlockAll  = ^uint64(0) // masked by what the kernel handles
+
+	// landlockFileAccess are the rights that apply to files, as opposed to
+	// directories, which are the only ones a rule for a file may have.
+	landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE
+)
+
+// planSandbox returns what tailscaled needs, given the features in f.
+func planSandbox(f sandboxFeatures) sandboxPlan {
+	var p sandboxPlan
+	p.deny = seccompDenied(f)
+
+	rule := func(access uint64, paths ...string) {
+		for _, path := range paths {
+			if path != "" {
+				p.rules = append(p.rules, landlockRule{path, access})
+			}
+		}
+	}
+	// Binaries and libraries, for the commands we run, like ip and
+	// iptables.
+	rule(landlockExec, "/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/nix")
+	if exe, err := os.Executable(); err == nil {
+		rule(landlockExec, filepath.Dir(exe))
+	}
+	rule(landlockRead, "/proc", "/sys", "/dev")
+	rule(landlockAll, "/proc/sys", "/dev/null", "/dev/net/tun")
+	// /etc for resolv.conf and hosts, /run for resolvconf and xtables.lock.
+	rule(landlockAll, "/etc", "/run", "/var/run", os.TempDir())
+	rule(landlockAll, f.varRoot, f.logsDir, f.fileRoot)
+	if f.socketPath != "" {
+		rule(landlockAll, filepath.Dir(f.socketPath))
+	}
+	if f.statePath != "" {
+		rule(landlockAll, filepath.Dir(f.statePath))
+	}
+	rule(landlockAll, f.shares...)
+	return p
+}
+
+// applySandboxLinux applies whichever of seccomp and Landlock the kernel
+// supports, and returns an error if it can't apply either.
+func applySandboxLinux(logf logger.Logf, f sandboxFeatures) error {
+	p := planSandbox(f)
+	var applied []string
+	if !seccompSupported {
+		logf("seccomp: not supported on %s", runtime.GOARCH)
+	} else if err := installSeccomp(seccompFilter(p.deny)); err != nil {
+		logf("seccomp: %v", err)
+	} else {
+		applied = append(applied, fmt.Sprintf("seccomp, denying %d syscalls", len(p.deny)))
+	}
+	if abi := landlockABI(); abi == 0 {
+		logf("landlock: not supported by kernel")
+	} else if err := applyLandlock(logf, abi, p.rules); err != nil {
+		logf("landlock: %v", err)
+	} else {
+		applied = append(applied, fmt.Sprintf("Landlock ABI v%d, with %d path rules", abi, len(p.rules)))
+	}
+	if len(applied) == 0 {
+		return errors.New("neither seccomp nor Landlock could be applied")
+	}
+	logf("applied %s", strings.Join(applied, "; "))
+	return nil
+}
+
+// x32SyscallBit is set in the numbers of x32 syscalls, which share amd64's
+// audit arch.
+const x32SyscallBit = 0x40000000
+
+// seccompFilter returns a seccomp program that makes the syscalls in deny,
+// and all syscalls made with a foreign calling convention, fail with
+// EPERM.
+func seccompFilter(deny []uint32) []bpf.Instruction {
+	n := 5 + len(deny)
+	if seccompX32 {
+		n++
+	}
+	// Each check jumps to the final instruction, returning EPERM, when a
+	// syscall is denied.
+	denyAt := n - 1
+	prog := make([]bpf.Instruction, 0, n)
+	toDeny := func() uint8 { return uint8(denyAt - len(prog) - 1) }
+
+	prog = append(prog, bpf.LoadAbsolute{Off: 4, Size: 4}) // seccomp_data.arch
+	prog = append(prog, bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: seccompAuditArch, SkipTrue: toDeny()})
+	prog = append(prog, bpf.LoadAbsolute{Off: 0, Size: 4}) // seccomp_data.nr
+	if seccompX32 {
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpGreaterOrEqual, Val: x32SyscallBit, SkipTrue: toDeny()})
+	}
+	for _, nr := range deny {
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpEqual, Val: nr, SkipTrue: toDeny()})
+	}
+	prog = append(prog,
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ALLOW},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+	)
+	return prog
+}
+
+// installSeccomp installs prog as a seccomp filter on all of the process's
+// threads.
+func installSeccomp(prog []bpf.Instruction) error {
+	raw, err := bpf.Assemble(prog)
+	if err != nil {
+		return err
+	}
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	fprog := &unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	// Stay on this thread, in case no_new_privs needs setting on it.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	install := func() error {
+		_, _, e := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(fprog)))
+		if e != 0 {
+			return e
+		}
+		return nil
+	}
+	err = install()
+	if err == unix.EACCES {
+		// Without CAP_SYS_ADMIN, we have to give up gaining privileges
+		// first. TSYNC gives up the other threads' too.
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("setting no_new_privs: %w", err)
+		}
+		err = install()
+	}
+	runtime.KeepAlive(filter)
+	return err
+}
+
+// landlockABI returns the version of the Landlock ABI that the kernel
+// supports, or 0 if it doesn't support Landlock.
+func landlockABI() int {
+	v, _, e := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if e != 0 {
+		return 0
+	}
+	return int(v)
+}
+
+// landlockHandled returns the filesystem rights that we restrict with
+// version abi of the Landlock ABI.
+func landlockHandled(abi int) uint64 {
+	var a uint64 = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+	if abi >= 2 {
+		a |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		a |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	return a
+}
+
+// applyLandlock restricts all of the process's threads to the paths in
+// rules. Paths that don't exist are skipped.
+func applyLandlock(logf logger.Logf, abi int, rules []landlockRule) error {
+	handled := landlockHandled(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	r, _, e := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if e != 0 {
+		return fmt.Errorf("creating ruleset: %w", e)
+	}
+	ruleset := int(r)
+	defer unix.Close(ruleset)
+
+	for _, rule := range rules {
+		if err := addLandlockRule(ruleset, handled, rule); err != nil {
+			if errors.Is(err, unix.ENOENT) {
+				continue
+			}
+			logf("landlock: not allowing %s: %v", rule.path, err)
+		}
+	}
+
+	restrict := func() error {
+		_, _, e := syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0)
+		if e != 0 {
+			return e
+		}
+		return nil
+	}
+	err := restrict()
+	if err == unix.EPERM {
+		// Without CAP_SYS_ADMIN, we have to give up gaining privileges
+		// first.
+		if _, _, e := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); e != 0 {
+			return fmt.Errorf("setting no_new_privs: %w", e)
+		}
+		err = restrict()
+	}
+	if err == syscall.ENOTSUP {
+		return errors.New("can't restrict all threads of a binary built with cgo")
+	}
+	return err
+}
+
+// addLandlockRule adds rule to ruleset, for the rights that it handles.
+func addLandlockRule(ruleset int, handled uint64, rule landlockRule) error {
+	fd, err := unix.Open(rule.path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return err
+	}
+	access := rule.access & handled
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+	pb := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, e := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&pb)), 0, 0, 0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import "golang.org/x/sys/unix"
+
+const (
+	seccompAuditArch = unix.AUDIT_ARCH_X86_64
+	seccompX32       = true // deny x32 syscalls
+)
+
+// seccompDeniedArch are the syscalls that tailscaled never needs that are
+// specific to amd64.
+var seccompDeniedArch = []uint32{
+	unix.SYS_IOPL,
+	unix.SYS_IOPERM,
+}
@@ -0,0 +1,15 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import "golang.org/x/sys/unix"
+
+const (
+	seccompAuditArch = unix.AUDIT_ARCH_AARCH64
+	seccompX32       = false
+)
+
+// seccompDeniedArch are the syscalls that tailscaled never needs that are
+// specific to arm64.
+var seccompDeniedArch []uint32
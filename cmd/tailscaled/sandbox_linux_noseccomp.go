@@ -0,0 +1,16 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux && !amd64 && !arm64
+
+package main
+
+// Syscalls aren't filtered on other architectures; only Landlock is
+// applied.
+const (
+	seccompSupported = false
+	seccompAuditArch = 0
+	seccompX32       = false
+)
+
+func seccompDenied(sandboxFeatures) []uint32 { return nil }
@@ -0,0 +1,75 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import "golang.org/x/sys/unix"
+
+const seccompSupported = true
+
+// seccompDeniedCommon are the syscalls that tailscaled never needs, on all
+// architectures that we filter syscalls on. Most could only be used to
+// take over the rest of the system.
+var seccompDeniedCommon = []uint32{
+	// Replacing the kernel, or changing what it runs.
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_REBOOT,
+	unix.SYS_SWAPON,
+	unix.SYS_SWAPOFF,
+	unix.SYS_ACCT,
+	unix.SYS_QUOTACTL,
+	unix.SYS_SYSLOG,
+	unix.SYS_LOOKUP_DCOOKIE,
+	unix.SYS_PERF_EVENT_OPEN,
+
+	// Escaping the mount namespace or creating new ones. Child processes
+	// like ip and iptables don't need them either.
+	unix.SYS_MOUNT,
+	unix.SYS_UMOUNT2,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_CHROOT,
+	unix.SYS_UNSHARE,
+	unix.SYS_SETNS,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+
+	// Changing the system clock.
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_CLOCK_SETTIME,
+	unix.SYS_CLOCK_ADJTIME,
+	unix.SYS_ADJTIMEX,
+
+	// Reaching into other processes.
+	unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_KCMP,
+
+	// The kernel keyring.
+	unix.SYS_ADD_KEY,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_KEYCTL,
+
+	// Common exploit primitives.
+	unix.SYS_USERFAULTFD,
+	unix.SYS_PERSONALITY,
+	unix.SYS_IO_URING_SETUP,
+	unix.SYS_IO_URING_ENTER,
+	unix.SYS_IO_URING_REGISTER,
+}
+
+// seccompDenied returns the syscalls that tailscaled doesn't need with the
+// features in f.
+func seccompDenied(f sandboxFeatures) []uint32 {
+	deny := append(append([]uint32(nil), seccompDeniedCommon...), seccompDeniedArch...)
+	if !f.sockBypass {
+		// Only needed to attach the socket bypass programs.
+		deny = append(deny, unix.SYS_BPF)
+	}
+	return deny
+}
@@ -0,0 +1,158 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+	"tailscale.com/types/logger"
+)
+
+// seccompData returns the seccomp_data for a syscall, in the byte order
+// that bpf.VM loads it in.
+func seccompData(nr, arch uint32) []byte {
+	b := make([]byte, 64)
+	binary.BigEndian.PutUint32(b[0:], nr)
+	binary.BigEndian.PutUint32(b[4:], arch)
+	return b
+}
+
+func TestSeccompFilter(t *testing.T) {
+	if !seccompSupported {
+		t.Skip("seccomp not supported on this architecture")
+	}
+	const (
+		allow = unix.SECCOMP_RET_ALLOW
+		eperm = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	)
+	x32Want := uint32(allow)
+	if seccompX32 {
+		x32Want = eperm
+	}
+	tests := []struct {
+		name string
+		f    sandboxFeatures
+		nr   uint32
+		arch uint32
+		want uint32
+	}{
+		{"read", sandboxFeatures{}, unix.SYS_READ, seccompAuditArch, allow},
+		{"kexec", sandboxFeatures{}, unix.SYS_KEXEC_LOAD, seccompAuditArch, eperm},
+		{"ptrace", sandboxFeatures{}, unix.SYS_PTRACE, seccompAuditArch, eperm},
+		{"last_denied", sandboxFeatures{}, unix.SYS_BPF, seccompAuditArch, eperm},
+		{"bpf_sockbypass", sandboxFeatures{sockBypass: true}, unix.SYS_BPF, seccompAuditArch, allow},
+		{"foreign_arch", sandboxFeatures{}, unix.SYS_READ, unix.AUDIT_ARCH_I386, eperm},
+		{"x32", sandboxFeatures{}, x32SyscallBit | unix.SYS_READ, seccompAuditArch, x32Want},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm, err := bpf.NewVM(seccompFilter(seccompDenied(tt.f)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := vm.Run(seccompData(tt.nr, tt.arch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint32(got) != tt.want {
+				t.Errorf("filter returned %#x; want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanSandbox(t *testing.T) {
+	p := planSandbox(sandboxFeatures{
+		varRoot:    "/var/lib/tailscale",
+		statePath:  "/state/tailscaled.state",
+		socketPath: "/run/tailscale/tailscaled.sock",
+		shares:     []string{"/srv/share"},
+	})
+	access := func(path string) (a uint64) {
+		for _, r := range p.rules {
+			if r.path == path {
+				a |= r.access
+			}
+		}
+		return a
+	}
+	for _, path := range []string{"/var/lib/tailscale", "/state", "/run/tailscale", "/srv/share", "/etc", "/dev/net/tun"} {
+		if access(path)&unix.LANDLOCK_ACCESS_FS_WRITE_FILE == 0 {
+			t.Errorf("%s not writable", path)
+		}
+	}
+	if a := access("/usr"); a&unix.LANDLOCK_ACCESS_FS_EXECUTE == 0 || a&unix.LANDLOCK_ACCESS_FS_WRITE_FILE != 0 {
+		t.Errorf("/usr access = %#x; want executable and not writable", a)
+	}
+	if a := access("/proc"); a&unix.LANDLOCK_ACCESS_FS_WRITE_FILE != 0 {
+		t.Errorf("/proc writable")
+	}
+	if slices.ContainsFunc(p.rules, func(r landlockRule) bool { return r.path == "" }) {
+		t.Errorf("rule for empty path")
+	}
+	if seccompSupported && slices.Contains(seccompDenied(sandboxFeatures{sockBypass: true}), unix.SYS_BPF) {
+		t.Errorf("bpf denied with socket bypass on")
+	}
+}
+
+// TestApplySandbox applies the sandbox in a child process and checks that
+// it's enforced.
+func TestApplySandbox(t *testing.T) {
+	if dir := os.Getenv("TS_TEST_SANDBOX_CHILD"); dir != "" {
+		sandboxChild(t, dir)
+		return
+	}
+
+	dir := t.TempDir()
+	for _, d := range []string{"allowed", "denied"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(os.Args[0], "-test.v", "-test.run", "^"+regexp.QuoteMeta(t.Name())+"$")
+	// TMPDIR is writable in the sandbox, so it mustn't contain dir.
+	cmd.Env = append(os.Environ(), "TS_TEST_SANDBOX_CHILD="+dir, "TMPDIR="+filepath.Join(dir, "allowed"))
+	cmd.Stdout = logger.FuncWriter(logger.WithPrefix(t.Logf, "child: "))
+	cmd.Stderr = logger.FuncWriter(logger.WithPrefix(t.Logf, "child: "))
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sandboxChild(t *testing.T, dir string) {
+	allowed := filepath.Join(dir, "allowed")
+	denied := filepath.Join(dir, "denied")
+	err := applySandboxLinux(t.Logf, sandboxFeatures{varRoot: allowed})
+	if err != nil {
+		t.Skipf("sandbox not supported here: %v", err)
+	}
+
+	if seccompSupported {
+		// personality(0xffffffff) only queries the persona, so would
+		// otherwise succeed.
+		_, _, e := unix.Syscall(unix.SYS_PERSONALITY, 0xffffffff, 0, 0)
+		if e != unix.EPERM {
+			t.Errorf("personality: got errno %v; want EPERM", e)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(allowed, "f"), nil, 0600); err != nil {
+		t.Errorf("writing to varRoot: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(denied, "f"), nil, 0600)
+	switch {
+	case err == nil:
+		t.Logf("Landlock not applied; writing elsewhere succeeded")
+	case !errors.Is(err, unix.EACCES):
+		t.Errorf("writing elsewhere: %v; want EACCES", err)
+	}
+}
@@ -15,7 +15,9 @@
 	"tailscale.com/version/distro"
 )
 
-func configureTaildrop(logf logger.Logf, lb *ipnlocal.LocalBackend) {
+// configureTaildrop sets up Taildrop on NAS distros that have a shared
+// folder for it, and returns that folder, if any.
+func configureTaildrop(logf logger.Logf, lb *ipnlocal.LocalBackend) (fileRoot string) {
 	dg := distro.Get()
 	switch dg {
 	case distro.Synology, distro.TrueNAS, distro.QNAP, distro.Unraid:
@@ -27,9 +29,10 @@ func configureTaildrop(logf logger.Logf, lb *ipnlocal.LocalBackend) {
 		} else {
 			logf("%s Taildrop: using %v", dg, path)
 			lb.SetDirectFileRoot(path)
+			return path
 		}
 	}
-
+	return ""
 }
 
 func findTaildropDir(dg distro.Distro) (string, error) {
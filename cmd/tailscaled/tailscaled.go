@@ -30,11 +30,13 @@
 	"syscall"
 	"time"
 
+	"github.com/tailscale/wireguard-go/tun"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/cmd/tailscaled/childproc"
 	"tailscale.com/control/controlclient"
 	"tailscale.com/drive/driveimpl"
 	"tailscale.com/envknob"
+	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/conffile"
 	"tailscale.com/ipn/ipnlocal"
@@ -43,11 +45,16 @@
 	"tailscale.com/logpolicy"
 	"tailscale.com/logtail"
 	"tailscale.com/net/dns"
+	"tailscale.com/net/dnscache"
 	"tailscale.com/net/dnsfallback"
+	"tailscale.com/net/netknob/sockopts"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
+	"tailscale.com/net/portmapper"
 	"tailscale.com/net/proxymux"
+	"tailscale.com/net/sockbypass"
 	"tailscale.com/net/socks5"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/net/tshttpproxy"
 	"tailscale.com/net/tstun"
@@ -60,6 +67,7 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/crashlog"
 	"tailscale.com/util/multierr"
 	"tailscale.com/util/osshare"
 	"tailscale.com/version"
@@ -129,6 +137,21 @@ func defaultPort() uint16 {
 	socksAddr      string // listen address for SOCKS5 server
 	httpProxyAddr  string // listen address for HTTP proxy server
 	disableLogs    bool
+
+	// sockOpts are the socket options for WireGuard and DERP traffic.
+	sockOpts sockopts.Options
+
+	// portmapDisable are the port mapping services not to use.
+	portmapDisable portmapper.Services
+
+	// netstackUDPIdleTimeout and netstackUDPMaxFlows configure the UDP
+	// flows forwarded by netstack; see netstack.Impl.
+	netstackUDPIdleTimeout time.Duration
+	netstackUDPMaxFlows    int
+
+	// sandbox is whether to restrict tailscaled once it's started; see
+	// maybeSandbox.
+	sandbox bool
 }
 
 var (
@@ -161,7 +184,7 @@ func main() {
 	flag.StringVar(&args.debug, "debug", "", "listen address ([ip]:port) of optional debug server")
 	flag.StringVar(&args.socksAddr, "socks5-server", "", `optional [ip]:port to run a SOCK5 server (e.g. "localhost:1080")`)
 	flag.StringVar(&args.httpProxyAddr, "outbound-http-proxy-listen", "", `optional [ip]:port to run an outbound HTTP proxy (e.g. "localhost:8080")`)
-	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
+	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN, or "tap:NAME[:BRIDGE]" (experimental, Linux only, requires TS_EXPERIMENTAL_TAP=true) for a TAP device`)
 	flag.Var(flagtype.PortValue(&args.port, defaultPort()), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
 	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
 	flag.StringVar(&args.statedir, "statedir", "", "path to directory for storage of config state, TLS certs, temporary incoming Taildrop files, etc. If empty, it's derived from --state when possible.")
@@ -170,6 +193,14 @@ func main() {
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 	flag.BoolVar(&args.disableLogs, "no-logs-no-support", false, "disable log uploads; this also disables any technical support")
 	flag.StringVar(&args.confFile, "config", "", "path to config file")
+	flag.IntVar(&args.sockOpts.RecvBuffer, "socket-rcvbuf", sockopts.DefaultBufferSize, "receive buffer size in bytes of the UDP sockets for WireGuard and peer-to-peer traffic; 0 means the OS default")
+	flag.IntVar(&args.sockOpts.SendBuffer, "socket-sndbuf", sockopts.DefaultBufferSize, "send buffer size in bytes of the UDP sockets for WireGuard and peer-to-peer traffic; 0 means the OS default")
+	flag.Var(&args.sockOpts.DSCP, "dscp", `DSCP class to mark WireGuard, peer-to-peer and DERP packets with, such as "cs1" or "af41", or a number from 0 to 63; empty means unmarked`)
+	flag.DurationVar(&args.sockOpts.BusyPoll, "busy-poll", 0, "how long to busy-poll for packets on WireGuard, peer-to-peer and DERP sockets before sleeping (Linux only); trades CPU for latency; 0 disables")
+	flag.DurationVar(&args.netstackUDPIdleTimeout, "netstack-udp-idle-timeout", 0, "how long a UDP flow forwarded by userspace networking may be idle before it's closed; 0 means the default of 2m")
+	flag.IntVar(&args.netstackUDPMaxFlows, "netstack-udp-max-flows-per-peer", 0, "maximum number of UDP flows forwarded by userspace networking for each peer, beyond which the peer's least recently used flow is closed; 0 means a platform-dependent default, -1 means no limit")
+	flag.BoolVar(&args.sandbox, "sandbox", false, "once started, restrict tailscaled with seccomp and Landlock to the syscalls and files it needs (Linux only); disables Tailscale SSH and sharing new directories with Taildrive; set TS_NO_SANDBOX=1 to override if it breaks your setup")
+	flag.Var(&args.portmapDisable, "portmap-disable", `comma-separated port mapping services not to use: "upnp", "pmp" (NAT-PMP) and/or "pcp"; disabled services aren't probed for`)
 
 	if len(os.Args) > 0 && filepath.Base(os.Args[0]) == "tailscale" && beCLI != nil {
 		beCLI()
@@ -223,6 +254,25 @@ func main() {
 		log.Fatalf("--bird-socket is not supported on %s", runtime.GOOS)
 	}
 
+	if args.sockOpts.RecvBuffer < 0 || args.sockOpts.SendBuffer < 0 {
+		log.SetFlags(0)
+		log.Fatalf("--socket-rcvbuf and --socket-sndbuf must not be negative")
+	}
+	if args.sockOpts.DSCP != 0 && runtime.GOOS == "windows" {
+		log.SetFlags(0)
+		log.Fatalf("--dscp is not supported on %s", runtime.GOOS)
+	}
+	if args.sockOpts.BusyPoll != 0 && runtime.GOOS != "linux" {
+		log.SetFlags(0)
+		log.Fatalf("--busy-poll is not supported on %s", runtime.GOOS)
+	}
+	sockopts.Set(args.sockOpts)
+
+	if args.netstackUDPIdleTimeout < 0 {
+		log.SetFlags(0)
+		log.Fatalf("--netstack-udp-idle-timeout must not be negative")
+	}
+
 	// Only apply a default statepath when neither have been provided, so that a
 	// user may specify only --statedir if they wish.
 	if args.statepath == "" && args.statedir == "" {
@@ -361,6 +411,7 @@ func run() (err error) {
 
 	pol := logpolicy.New(logtail.CollectionNode, netMon, sys.HealthTracker(), nil /* use log.Printf */)
 	pol.SetVerbosityLevel(args.verbose)
+	pol.Logtail.SetIsMetered(meteredNetworkFunc())
 	logPol = pol
 	defer func() {
 		// Finish uploading logs after closing everything else.
@@ -407,6 +458,11 @@ func run() (err error) {
 	if err := trySynologyMigration(statePathOrDefault()); err != nil {
 		log.Printf("error in synology migration: %v", err)
 	}
+	if dir := crashlog.Dir(ipnServerOpts().VarRoot); dir != "" {
+		if err := crashlog.Start(logf, dir); err != nil {
+			log.Printf("crashlog: %v", err)
+		}
+	}
 
 	if args.debug != "" {
 		debugMux = newDebugMux()
@@ -526,6 +582,17 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 	if err != nil {
 		return nil, fmt.Errorf("createEngine: %w", err)
 	}
+	sockBypass := false
+	if envknob.Bool("TS_EXPERIMENTAL_SOCKET_BYPASS") {
+		// Left attached until the process exits.
+		if _, err := sockbypass.New(tsaddr.CGNATRange()); err != nil {
+			logf("sockbypass: %v", err)
+		} else {
+			logf("sockbypass: bypassing WireGuard for TCP between tailnet nodes on this host")
+			sockBypass = true
+			hostinfo.SetExperimentalFeature("socket-bypass", true)
+		}
+	}
 	if debugMux != nil {
 		if ms, ok := sys.MagicSock.GetOK(); ok {
 			debugMux.HandleFunc("/debug/magicsock", ms.ServeHTTPDebug)
@@ -540,6 +607,8 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 	sys.Set(ns)
 	ns.ProcessLocalIPs = onlyNetstack
 	ns.ProcessSubnets = onlyNetstack || handleSubnetsInNetstack()
+	ns.UDPIdleTimeout = args.netstackUDPIdleTimeout
+	ns.MaxUDPFlowsPerClient = args.netstackUDPMaxFlows
 
 	if onlyNetstack {
 		e := sys.Engine.Get()
@@ -601,15 +670,17 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 	}
 	if root := lb.TailscaleVarRoot(); root != "" {
 		dnsfallback.SetCachePath(filepath.Join(root, "derpmap.cached.json"), logf)
+		dnscache.SetCachePath(filepath.Join(root, "dnscache.cached.json"), logf)
 	}
 	lb.ConfigureWebClient(&tailscale.LocalClient{
 		Socket:        args.socketpath,
 		UseSocketOnly: args.socketpath != paths.DefaultTailscaledSocket(),
 	})
-	configureTaildrop(logf, lb)
+	fileRoot := configureTaildrop(logf, lb)
 	if err := ns.Start(lb); err != nil {
 		log.Fatalf("failed to start netstack: %v", err)
 	}
+	maybeSandbox(logf, lb, sockBypass, fileRoot)
 	return lb, nil
 }
 
@@ -666,6 +737,8 @@ func tryEngine(logf logger.Logf, sys *tsd.System, name string) (onlyNetstack boo
 		SetSubsystem:  sys.Set,
 		ControlKnobs:  sys.ControlKnobs(),
 		DriveForLocal: driveimpl.NewFileSystemForLocal(logf),
+
+		DisabledPortMapServices: args.portmapDisable,
 	}
 
 	onlyNetstack = name == "userspace-networking"
@@ -723,6 +796,19 @@ func tryEngine(logf logger.Logf, sys *tsd.System, name string) (onlyNetstack boo
 		}
 		conf.DNS = d
 		conf.Router = r
+		conf.ReopenTUN = func() (tun.Device, router.Router, error) {
+			dev, _, err := tstunNew(logf, name)
+			if err != nil {
+				tstun.Diagnose(logf, name, err)
+				return nil, nil, fmt.Errorf("tstun.New(%q): %w", name, err)
+			}
+			r, err := router.New(logf, dev, sys.NetMon.Get(), sys.HealthTracker())
+			if err != nil {
+				dev.Close()
+				return nil, nil, fmt.Errorf("creating router: %w", err)
+			}
+			return dev, r, nil
+		}
 		if handleSubnetsInNetstack() {
 			netstackSubnetRouter = true
 		}
@@ -93,6 +93,7 @@ type Direct struct {
 
 	persist      persist.PersistView
 	authKey      string
+	authKeyFunc  func() string // or nil
 	tryingNewKey key.NodePrivate
 	expiry       time.Time         // or zero value if none/unknown
 	hostinfo     *tailcfg.Hostinfo // always non-nil
@@ -117,6 +118,7 @@ type Options struct {
 	GetMachinePrivateKey       func() (key.MachinePrivate, error) // returns the machine key to use
 	ServerURL                  string                             // URL of the tailcontrol server
 	AuthKey                    string                             // optional node auth key for auto registration
+	AuthKeyFunc                func() string                      // optional; if non-nil and it returns non-empty, used instead of AuthKey at each login
 	Clock                      tstime.Clock
 	Hostinfo                   *tailcfg.Hostinfo // non-nil passes ownership, nil means to use default using os.Hostname, etc
 	DiscoPublicKey             key.DiscoPublic
@@ -282,6 +284,7 @@ func NewDirect(opts Options) (*Direct, error) {
 		logf:                       opts.Logf,
 		persist:                    opts.Persist.View(),
 		authKey:                    opts.AuthKey,
+		authKeyFunc:                opts.AuthKeyFunc,
 		discoPubKey:                opts.DiscoPublicKey,
 		debugFlags:                 opts.DebugFlags,
 		netMon:                     netMon,
@@ -469,12 +472,19 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 	if c.panicOnUse {
 		panic("tainted client")
 	}
+	var latestAuthKey string
+	if c.authKeyFunc != nil {
+		latestAuthKey = c.authKeyFunc()
+	}
 	c.mu.Lock()
 	persist := c.persist.AsStruct()
 	tryingNewKey := c.tryingNewKey
 	serverKey := c.serverLegacyKey
 	serverNoiseKey := c.serverNoiseKey
-	authKey, isWrapped, wrappedSig, wrappedKey := decodeWrappedAuthkey(c.authKey, c.logf)
+	if latestAuthKey == "" {
+		latestAuthKey = c.authKey
+	}
+	authKey, isWrapped, wrappedSig, wrappedKey := decodeWrappedAuthkey(latestAuthKey, c.logf)
 	hi := c.hostInfoLocked()
 	backendLogID := hi.BackendLogID
 	expired := !c.expiry.IsZero() && c.expiry.Before(c.clock.Now())
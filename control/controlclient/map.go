@@ -283,6 +283,9 @@ func (ms *mapSession) updateStateFromResponse(resp *tailcfg.MapResponse) {
 				if hh.RegionScore == nil {
 					hh.RegionScore = oldhh.RegionScore
 				}
+				if hh.ClientLocation == nil {
+					hh.ClientLocation = oldhh.ClientLocation
+				}
 			}
 		}
 
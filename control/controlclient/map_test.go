@@ -714,6 +714,21 @@ type step struct {
 						RegionScore: map[int]float64{},
 					}},
 				},
+				// A client location is kept along with the region scores.
+				{
+					&tailcfg.DERPMap{HomeParams: &tailcfg.DERPHomeParams{ClientLocation: &tailcfg.Location{Latitude: 49.7, Longitude: -123.2}}},
+					&tailcfg.DERPMap{Regions: regions1, HomeParams: &tailcfg.DERPHomeParams{
+						RegionScore:    map[int]float64{},
+						ClientLocation: &tailcfg.Location{Latitude: 49.7, Longitude: -123.2},
+					}},
+				},
+				{
+					&tailcfg.DERPMap{HomeParams: &tailcfg.DERPHomeParams{RegionScore: map[int]float64{1: 2}}},
+					&tailcfg.DERPMap{Regions: regions1, HomeParams: &tailcfg.DERPHomeParams{
+						RegionScore:    map[int]float64{1: 2},
+						ClientLocation: &tailcfg.Location{Latitude: 49.7, Longitude: -123.2},
+					}},
+				},
 			},
 		},
 	}
@@ -0,0 +1,85 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derp
+
+import (
+	"io"
+	"time"
+
+	"tailscale.com/tstime"
+)
+
+const (
+	// sendRateBurstGap is the longest gap between writes to a client
+	// for them to count as part of the same burst when estimating the
+	// rate at which the server can send to it.
+	sendRateBurstGap = 2 * time.Millisecond
+
+	// sendRateMinBurst is the fewest bytes that a burst of writes must
+	// have to be used as a sample of the send rate. Smaller bursts
+	// mostly land in kernel socket buffers and say little about the
+	// path to the client.
+	sendRateMinBurst = 64 << 10
+
+	// sendRateAlpha is the weight of each new sample in the send rate
+	// estimate's exponential moving average.
+	sendRateAlpha = 0.25
+)
+
+// sendRateMeter is an io.Writer that wraps a client's connection to
+// estimate the rate at which the server is able to send to the client.
+//
+// It times bursts of back-to-back writes: while the server has data
+// queued for a client faster than the network path can carry it, writes
+// block on TCP flow control and a burst's throughput approximates the
+// path's. If the server never has that much to send, the estimate is
+// instead a lower bound.
+//
+// It's owned by the sclient's sender; it's not safe for concurrent use.
+type sendRateMeter struct {
+	w     io.Writer // underlying
+	clock tstime.Clock
+
+	burstStart time.Time // when the current burst's first write started
+	burstEnd   time.Time // when the current burst's last write ended
+	burstBytes int64     // bytes written in the current burst
+
+	estimate float64 // bytes per second, or 0 if not yet measured
+}
+
+func (m *sendRateMeter) Write(p []byte) (int, error) {
+	start := m.clock.Now()
+	n, err := m.w.Write(p)
+	end := m.clock.Now()
+	if m.burstBytes == 0 || start.Sub(m.burstEnd) > sendRateBurstGap {
+		m.endBurst()
+		m.burstStart = start
+	}
+	m.burstEnd = end
+	m.burstBytes += int64(n)
+	return n, err
+}
+
+// endBurst folds the current burst, if it's big enough, into the
+// estimate and starts a new one.
+func (m *sendRateMeter) endBurst() {
+	if d := m.burstEnd.Sub(m.burstStart); m.burstBytes >= sendRateMinBurst && d > 0 {
+		rate := float64(m.burstBytes) / d.Seconds()
+		if m.estimate == 0 {
+			m.estimate = rate
+		} else {
+			m.estimate = expMovingAverage(m.estimate, rate, sendRateAlpha)
+		}
+	}
+	m.burstBytes = 0
+}
+
+// BytesPerSecond returns the estimated rate at which the server can send
+// to the client, or 0 if there's no estimate yet.
+func (m *sendRateMeter) BytesPerSecond() uint64 {
+	if m.burstBytes >= sendRateMinBurst && m.clock.Since(m.burstEnd) > sendRateBurstGap {
+		m.endBurst()
+	}
+	return uint64(m.estimate)
+}
@@ -117,6 +117,13 @@
 	// and how long to try total. See ServerRestartingMessage docs for
 	// more details on how the client should interpret them.
 	frameRestarting = frameType(0x15)
+
+	// frameBandwidthEstimate is sent from server to client, alongside
+	// keep-alives, to tell clients that declared CanBandwidthEstimate
+	// in their clientInfo the rate at which the server estimates it can
+	// send to them. Payload is a big endian uint64 of bytes per second.
+	// See BandwidthEstimateMessage.
+	frameBandwidthEstimate = frameType(0x16)
 )
 
 // PeerGoneReasonType is a one byte reason code explaining why a
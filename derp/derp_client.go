@@ -11,6 +11,7 @@
 	"fmt"
 	"io"
 	"net/netip"
+	"os"
 	"sync"
 	"time"
 
@@ -34,9 +35,10 @@ type Client struct {
 	canAckPings bool
 	isProber    bool
 
-	wmu  sync.Mutex // hold while writing to bw
-	bw   *bufio.Writer
-	rate *rate.Limiter // if non-nil, rate limiter to use
+	wmu          sync.Mutex // hold while writing to bw
+	bw           *bufio.Writer
+	rate         *rate.Limiter // if non-nil, rate limiter to use
+	writeTimeout time.Duration // if non-zero, max time a packet write may block
 
 	// Owned by Recv:
 	peeked  int                      // bytes to discard on next Recv
@@ -97,16 +99,17 @@ func NewClient(privateKey key.NodePrivate, nc Conn, brw *bufio.ReadWriter, logf
 
 func newClient(privateKey key.NodePrivate, nc Conn, brw *bufio.ReadWriter, logf logger.Logf, opt clientOpt) (*Client, error) {
 	c := &Client{
-		privateKey:  privateKey,
-		publicKey:   privateKey.Public(),
-		logf:        logf,
-		nc:          nc,
-		br:          brw.Reader,
-		bw:          brw.Writer,
-		meshKey:     opt.MeshKey,
-		canAckPings: opt.CanAckPings,
-		isProber:    opt.IsProber,
-		clock:       tstime.StdClock{},
+		privateKey:   privateKey,
+		publicKey:    privateKey.Public(),
+		logf:         logf,
+		nc:           nc,
+		br:           brw.Reader,
+		bw:           brw.Writer,
+		meshKey:      opt.MeshKey,
+		canAckPings:  opt.CanAckPings,
+		isProber:     opt.IsProber,
+		clock:        tstime.StdClock{},
+		writeTimeout: clientWriteTimeout,
 	}
 	if opt.ServerPub.IsZero() {
 		if err := c.recvServerKey(); err != nil {
@@ -175,6 +178,10 @@ type clientInfo struct {
 
 	// IsProber is whether this client is a prober.
 	IsProber bool `json:",omitempty"`
+
+	// CanBandwidthEstimate is whether the client declares it's able
+	// to receive frameBandwidthEstimate.
+	CanBandwidthEstimate bool `json:",omitempty"`
 }
 
 func (c *Client) sendClientKey() error {
@@ -183,6 +190,8 @@ func (c *Client) sendClientKey() error {
 		MeshKey:     c.meshKey,
 		CanAckPings: c.canAckPings,
 		IsProber:    c.isProber,
+
+		CanBandwidthEstimate: true,
 	})
 	if err != nil {
 		return err
@@ -198,6 +207,26 @@ func (c *Client) sendClientKey() error {
 // ServerPublicKey returns the server's public key.
 func (c *Client) ServerPublicKey() key.NodePublic { return c.serverKey }
 
+// clientWriteTimeout is how long a write of a packet, ping or pong to the
+// server may block before the connection is considered stalled. A
+// half-open connection otherwise accepts writes until the kernel's send
+// buffer fills and then blocks until TCP gives up, which takes minutes.
+const clientWriteTimeout = 5 * time.Second
+
+// ErrWriteStalled is returned, wrapped, by Send when a write to the
+// server didn't complete in time. The connection is unusable afterwards
+// and the caller should reconnect.
+var ErrWriteStalled = errors.New("write to DERP server stalled")
+
+// stallErr returns err, wrapped with ErrWriteStalled if it's the result
+// of the write timeout expiring.
+func stallErr(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrWriteStalled, err)
+	}
+	return err
+}
+
 // Send sends a packet to the Tailscale node identified by dstKey.
 //
 // It is an error if the packet is larger than 64KB.
@@ -222,16 +251,20 @@ func (c *Client) send(dstKey key.NodePublic, pkt []byte) (ret error) {
 			return nil // drop
 		}
 	}
+	if c.writeTimeout > 0 {
+		c.nc.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+		defer c.nc.SetWriteDeadline(time.Time{})
+	}
 	if err := writeFrameHeader(c.bw, frameSendPacket, uint32(key.NodePublicRawLen+len(pkt))); err != nil {
-		return err
+		return stallErr(err)
 	}
 	if _, err := c.bw.Write(dstKey.AppendTo(nil)); err != nil {
-		return err
+		return stallErr(err)
 	}
 	if _, err := c.bw.Write(pkt); err != nil {
-		return err
+		return stallErr(err)
 	}
-	return c.bw.Flush()
+	return stallErr(c.bw.Flush())
 }
 
 func (c *Client) ForwardPacket(srcKey, dstKey key.NodePublic, pkt []byte) (err error) {
@@ -279,13 +312,17 @@ func (c *Client) SendPong(data [8]byte) error {
 func (c *Client) sendPingOrPong(typ frameType, data [8]byte) error {
 	c.wmu.Lock()
 	defer c.wmu.Unlock()
+	if c.writeTimeout > 0 {
+		c.nc.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+		defer c.nc.SetWriteDeadline(time.Time{})
+	}
 	if err := writeFrameHeader(c.bw, typ, 8); err != nil {
-		return err
+		return stallErr(err)
 	}
 	if _, err := c.bw.Write(data[:]); err != nil {
-		return err
+		return stallErr(err)
 	}
-	return c.bw.Flush()
+	return stallErr(c.bw.Flush())
 }
 
 // NotePreferred sends a packet that tells the server whether this
@@ -445,6 +482,20 @@ type ServerRestartingMessage struct {
 
 func (ServerRestartingMessage) msg() {}
 
+// BandwidthEstimateMessage is a one-way message from server to client,
+// sent periodically alongside keep-alives, with the rate at which the
+// server estimates it's able to send to the client.
+//
+// The server measures this from how quickly bursts of data it sends the
+// client drain, so when it hasn't sent the client much, the estimate
+// is a lower bound.
+type BandwidthEstimateMessage struct {
+	// BytesPerSecond is the estimated send rate. It's never zero.
+	BytesPerSecond uint64
+}
+
+func (BandwidthEstimateMessage) msg() {}
+
 // Recv reads a message from the DERP server.
 //
 // The returned message may alias memory owned by the Client; it
@@ -601,6 +652,17 @@ func (c *Client) recvTimeout(timeout time.Duration) (m ReceivedMessage, err erro
 			m.ReconnectIn = time.Duration(binary.BigEndian.Uint32(b[0:4])) * time.Millisecond
 			m.TryFor = time.Duration(binary.BigEndian.Uint32(b[4:8])) * time.Millisecond
 			return m, nil
+
+		case frameBandwidthEstimate:
+			if n < 8 {
+				c.logf("[unexpected] dropping short bandwidth estimate frame")
+				continue
+			}
+			m := BandwidthEstimateMessage{BytesPerSecond: binary.BigEndian.Uint64(b[:8])}
+			if m.BytesPerSecond == 0 {
+				continue
+			}
+			return m, nil
 		}
 	}
 }
@@ -127,6 +127,7 @@ type Server struct {
 	peerGoneNotHereFrames        expvar.Int // number of peer not here frames sent
 	gotPing                      expvar.Int // number of ping frames from client
 	sentPong                     expvar.Int // number of pong frames enqueued to client
+	sentBandwidthEstimate        expvar.Int // number of bandwidth estimate frames sent to clients
 	accepts                      expvar.Int
 	curClients                   expvar.Int
 	curHomeClients               expvar.Int // ones with preferred
@@ -697,7 +698,8 @@ func (s *Server) addWatcher(c *sclient) {
 func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, remoteAddr string, connNum int64) error {
 	br := brw.Reader
 	nc.SetDeadline(time.Now().Add(10 * time.Second))
-	bw := &lazyBufioWriter{w: nc, lbw: brw.Writer}
+	sendRate := &sendRateMeter{w: nc, clock: s.clock}
+	bw := &lazyBufioWriter{w: sendRate, lbw: brw.Writer}
 	if err := s.sendServerKey(bw); err != nil {
 		return fmt.Errorf("send server key: %v", err)
 	}
@@ -727,6 +729,7 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 		nc:             nc,
 		br:             br,
 		bw:             bw,
+		sendRate:       sendRate,
 		logf:           logger.WithPrefix(s.logf, fmt.Sprintf("derp client %v%s: ", remoteAddr, clientKey.ShortString())),
 		done:           ctx.Done(),
 		remoteIPPort:   remoteIPPort,
@@ -1389,7 +1392,8 @@ type sclient struct {
 	preferred   bool
 
 	// Owned by sender, not thread-safe.
-	bw *lazyBufioWriter
+	bw       *lazyBufioWriter
+	sendRate *sendRateMeter // wraps nc, under bw
 
 	// Guarded by s.mu
 	//
@@ -1566,10 +1570,29 @@ func (c *sclient) setWriteDeadline() {
 	c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
 }
 
-// sendKeepAlive sends a keep-alive frame, without flushing.
+// sendKeepAlive sends a keep-alive frame, followed by a bandwidth
+// estimate frame if the client supports them and there's an estimate,
+// without flushing.
 func (c *sclient) sendKeepAlive() error {
 	c.setWriteDeadline()
-	return writeFrameHeader(c.bw.bw(), frameKeepAlive, 0)
+	if err := writeFrameHeader(c.bw.bw(), frameKeepAlive, 0); err != nil {
+		return err
+	}
+	if !c.info.CanBandwidthEstimate {
+		return nil
+	}
+	bps := c.sendRate.BytesPerSecond()
+	if bps == 0 {
+		return nil
+	}
+	c.s.sentBandwidthEstimate.Add(1)
+	if err := writeFrameHeader(c.bw.bw(), frameBandwidthEstimate, 8); err != nil {
+		return err
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], bps)
+	_, err := c.bw.Write(b[:])
+	return err
 }
 
 // sendPong sends a pong reply, without flushing.
@@ -1888,6 +1911,7 @@ func (s *Server) ExpVar() expvar.Var {
 	m.Set("home_moves_out", &s.homeMovesOut)
 	m.Set("got_ping", &s.gotPing)
 	m.Set("sent_pong", &s.sentPong)
+	m.Set("sent_bandwidth_estimate", &s.sentBandwidthEstimate)
 	m.Set("peer_gone_disconnected_frames", &s.peerGoneDisconnectedFrames)
 	m.Set("peer_gone_not_here_frames", &s.peerGoneNotHereFrames)
 	m.Set("packets_forwarded_out", &s.packetsForwardedOut)
@@ -988,6 +988,14 @@ func TestClientRecv(t *testing.T) {
 				TryFor:      2 * time.Millisecond,
 			},
 		},
+		{
+			name: "bandwidth_estimate",
+			input: []byte{
+				byte(frameBandwidthEstimate), 0, 0, 0, 8,
+				0, 0, 0, 0, 0, 0x10, 0, 0,
+			},
+			want: BandwidthEstimateMessage{BytesPerSecond: 1 << 20},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1008,6 +1016,55 @@ func TestClientRecv(t *testing.T) {
 	}
 }
 
+// slowWriter is an io.Writer that advances clock by delay for each write.
+type slowWriter struct {
+	clock *tstest.Clock
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	w.clock.Advance(w.delay)
+	return len(p), nil
+}
+
+func TestSendRateMeter(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	w := &slowWriter{clock: clock, delay: 5 * time.Millisecond}
+	m := &sendRateMeter{w: w, clock: clock}
+	chunk := make([]byte, 16<<10)
+
+	// A burst too small to say anything about the path.
+	m.Write(chunk)
+	clock.Advance(2 * sendRateBurstGap)
+	if got := m.BytesPerSecond(); got != 0 {
+		t.Fatalf("estimate after small burst = %d; want 0", got)
+	}
+
+	// A big enough burst, at 16KiB per 5ms.
+	for range 8 {
+		m.Write(chunk)
+	}
+	if got := m.BytesPerSecond(); got != 0 {
+		t.Fatalf("estimate during burst = %d; want 0", got)
+	}
+	clock.Advance(2 * sendRateBurstGap)
+	const rate = (16 << 10) * 200
+	if got := m.BytesPerSecond(); got != rate {
+		t.Fatalf("estimate = %d; want %d", got, rate)
+	}
+
+	// A burst at half the rate moves the estimate by sendRateAlpha of
+	// the difference.
+	w.delay *= 2
+	for range 8 {
+		m.Write(chunk)
+	}
+	clock.Advance(2 * sendRateBurstGap)
+	if got, want := m.BytesPerSecond(), uint64(rate-sendRateAlpha*rate/2); got != want {
+		t.Fatalf("estimate after slower burst = %d; want %d", got, want)
+	}
+}
+
 func TestClientSendPing(t *testing.T) {
 	var buf bytes.Buffer
 	c := &Client{
@@ -1042,6 +1099,23 @@ func TestClientSendPong(t *testing.T) {
 	}
 }
 
+func TestClientSendStall(t *testing.T) {
+	// Nothing reads from the other end of the pipe, so writes block
+	// as they would on a half-open TCP connection.
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	c := &Client{
+		nc:           c1,
+		bw:           bufio.NewWriter(c1),
+		writeTimeout: 50 * time.Millisecond,
+	}
+	err := c.Send(key.NodePublic{}, []byte("hello"))
+	if !errors.Is(err, ErrWriteStalled) {
+		t.Fatalf("Send error = %v; want ErrWriteStalled", err)
+	}
+}
+
 func TestServerDupClients(t *testing.T) {
 	serverPriv := key.NewNode()
 	var s *Server
@@ -33,6 +33,7 @@
 	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/net/dnscache"
+	"tailscale.com/net/netknob/sockopts"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/sockstats"
@@ -425,6 +426,7 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 	if err != nil {
 		return nil, 0, err
 	}
+	sockopts.ApplyConn(tcpConn, c.logf)
 
 	// Now that we have a TCP connection, force close it if the
 	// TLS handshake + DERP setup takes too long.
@@ -11,9 +11,16 @@
 	"net"
 
 	"nhooyr.io/websocket"
+	"tailscale.com/derp"
 	"tailscale.com/net/wsconn"
 )
 
+// wsReadLimit is the largest WebSocket message we accept from the server.
+// Each Write to a wsconn is sent as its own message, and the server writes
+// packets of up to derp.MaxPacketSize bytes in one Write, which is more
+// than the websocket package's default limit of 32KiB.
+const wsReadLimit = 2 * derp.MaxPacketSize
+
 func init() {
 	dialWebsocketFunc = dialWebsocket
 }
@@ -27,6 +34,7 @@ func dialWebsocket(ctx context.Context, urlStr string) (net.Conn, error) {
 		return nil, err
 	}
 	log.Printf("websocket: connected to %v", urlStr)
+	c.SetReadLimit(wsReadLimit)
 	netConn := wsconn.NetConn(context.Background(), c, websocket.MessageBinary, urlStr)
 	return netConn, nil
 }
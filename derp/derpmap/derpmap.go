@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package derpmap contains helpers for choosing among the regions of a
+// tailcfg.DERPMap without measuring them.
+package derpmap
+
+import (
+	"math"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// earthRadiusKm is the mean radius of the Earth.
+	earthRadiusKm = 6371
+
+	// fiberKmPerMs is roughly how far light travels in optical fiber
+	// in a millisecond: about two thirds of its speed in a vacuum.
+	fiberKmPerMs = 200
+
+	// routeStretch is how much longer than the great-circle distance
+	// we assume that the path to a DERP region is, as real paths follow
+	// cables and detour through exchange points.
+	routeStretch = 1.5
+
+	// minEstimatedLatency is the least latency that we estimate for any
+	// region, for the last mile and the DERP server itself.
+	minEstimatedLatency = 5 * time.Millisecond
+)
+
+// DistanceKm returns the great-circle distance, in kilometers, between
+// two points given by their latitude and longitude in degrees.
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(lat2 - lat1)
+	dLon := rad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(min(a, 1)))
+}
+
+// EstimatedLatency returns a rough estimate of the round-trip latency to
+// region r from a client at the given latitude and longitude, based only
+// on the distance between them. It reports false if r has no
+// coordinates.
+func EstimatedLatency(r *tailcfg.DERPRegion, lat, lon float64) (time.Duration, bool) {
+	if r == nil || r.Latitude == 0 && r.Longitude == 0 {
+		return 0, false
+	}
+	km := DistanceKm(lat, lon, r.Latitude, r.Longitude)
+	ms := 2 * km * routeStretch / fiberKmPerMs
+	return max(time.Duration(ms*float64(time.Millisecond)), minEstimatedLatency), true
+}
+
+// ProvisionalHome returns the region of dm with the lowest estimated
+// latency from a client at the given latitude and longitude, scaled by
+// dm's home region scores like measured latencies are, for use as the
+// client's home until it can measure them. It skips regions that should
+// be avoided, that have no coordinates, or that only run STUN.
+//
+// It returns 0 if there's no such region.
+func ProvisionalHome(dm *tailcfg.DERPMap, lat, lon float64) (regionID int) {
+	if dm == nil {
+		return 0
+	}
+	var best time.Duration
+	for _, rid := range dm.RegionIDs() {
+		r := dm.Regions[rid]
+		if r == nil || r.Avoid || !hasDERPNode(r) {
+			continue
+		}
+		d, ok := EstimatedLatency(r, lat, lon)
+		if !ok {
+			continue
+		}
+		if hp := dm.HomeParams; hp != nil {
+			if score := hp.RegionScore[rid]; score > 0 {
+				d = time.Duration(float64(d) * score)
+			}
+		}
+		if regionID == 0 || d < best {
+			regionID, best = rid, d
+		}
+	}
+	return regionID
+}
+
+// hasDERPNode reports whether r has any node that serves DERP, rather
+// than only STUN.
+func hasDERPNode(r *tailcfg.DERPRegion) bool {
+	for _, n := range r.Nodes {
+		if !n.STUNOnly {
+			return true
+		}
+	}
+	return false
+}
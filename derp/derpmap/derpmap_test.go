@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpmap
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestDistanceKm(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want                   float64 // within 1%
+	}{
+		{"same", 40.7, -74.0, 40.7, -74.0, 0},
+		{"nyc-london", 40.7128, -74.0060, 51.5074, -0.1278, 5570},
+		{"sf-sydney", 37.7749, -122.4194, -33.8688, 151.2093, 11940},
+		{"antipodes", 0, 0, 0, 180, math.Pi * earthRadiusKm},
+	}
+	for _, tt := range tests {
+		got := DistanceKm(tt.lat1, tt.lon1, tt.lat2, tt.lon2)
+		if math.Abs(got-tt.want) > tt.want/100+1 {
+			t.Errorf("%s: DistanceKm = %.0f; want about %.0f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEstimatedLatency(t *testing.T) {
+	if _, ok := EstimatedLatency(&tailcfg.DERPRegion{RegionID: 1}, 40.7, -74.0); ok {
+		t.Error("got estimate for region without coordinates")
+	}
+	nyc := &tailcfg.DERPRegion{RegionID: 1, Latitude: 40.7128, Longitude: -74.0060}
+	if d, ok := EstimatedLatency(nyc, 40.7128, -74.0060); !ok || d != minEstimatedLatency {
+		t.Errorf("same city: got %v, %v; want %v, true", d, ok, minEstimatedLatency)
+	}
+	// About 5570km to London, so 2*5570*1.5/200 ≈ 84ms.
+	if d, ok := EstimatedLatency(nyc, 51.5074, -0.1278); !ok || d < 80*time.Millisecond || d > 88*time.Millisecond {
+		t.Errorf("London to NYC: got %v, %v; want about 84ms", d, ok)
+	}
+}
+
+func TestProvisionalHome(t *testing.T) {
+	region := func(id int, code string, lat, lon float64) *tailcfg.DERPRegion {
+		return &tailcfg.DERPRegion{
+			RegionID:   id,
+			RegionCode: code,
+			Latitude:   lat,
+			Longitude:  lon,
+			Nodes:      []*tailcfg.DERPNode{{Name: code, RegionID: id}},
+		}
+	}
+	newMap := func() *tailcfg.DERPMap {
+		return &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+			1: region(1, "nyc", 40.7128, -74.0060),
+			2: region(2, "sfo", 37.7749, -122.4194),
+			3: region(3, "lhr", 51.5074, -0.1278),
+			4: region(4, "syd", -33.8688, 151.2093),
+		}}
+	}
+	const (
+		parisLat, parisLon     = 48.8566, 2.3522
+		seattleLat, seattleLon = 47.6062, -122.3321
+	)
+
+	tests := []struct {
+		name     string
+		modify   func(*tailcfg.DERPMap)
+		lat, lon float64
+		want     int
+	}{
+		{
+			name: "paris",
+			lat:  parisLat, lon: parisLon,
+			want: 3,
+		},
+		{
+			name: "seattle",
+			lat:  seattleLat, lon: seattleLon,
+			want: 2,
+		},
+		{
+			name: "avoid",
+			modify: func(dm *tailcfg.DERPMap) {
+				dm.Regions[3].Avoid = true
+			},
+			lat: parisLat, lon: parisLon,
+			want: 1,
+		},
+		{
+			name: "stun-only",
+			modify: func(dm *tailcfg.DERPMap) {
+				dm.Regions[3].Nodes[0].STUNOnly = true
+			},
+			lat: parisLat, lon: parisLon,
+			want: 1,
+		},
+		{
+			name: "no-coordinates",
+			modify: func(dm *tailcfg.DERPMap) {
+				dm.Regions[3].Latitude = 0
+				dm.Regions[3].Longitude = 0
+			},
+			lat: parisLat, lon: parisLon,
+			want: 1,
+		},
+		{
+			name: "region-score",
+			modify: func(dm *tailcfg.DERPMap) {
+				dm.HomeParams = &tailcfg.DERPHomeParams{
+					RegionScore: map[int]float64{3: 20},
+				}
+			},
+			lat: parisLat, lon: parisLon,
+			want: 1,
+		},
+		{
+			name: "none",
+			modify: func(dm *tailcfg.DERPMap) {
+				for _, r := range dm.Regions {
+					r.Avoid = true
+				}
+			},
+			lat: parisLat, lon: parisLon,
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := newMap()
+			if tt.modify != nil {
+				tt.modify(dm)
+			}
+			if got := ProvisionalHome(dm, tt.lat, tt.lon); got != tt.want {
+				t.Errorf("ProvisionalHome = %d; want %d", got, tt.want)
+			}
+		})
+	}
+	if got := ProvisionalHome(nil, parisLat, parisLon); got != 0 {
+		t.Errorf("ProvisionalHome(nil) = %d; want 0", got)
+	}
+}
@@ -6,7 +6,10 @@
 package doctor
 
 import (
+	"cmp"
 	"context"
+	"slices"
+	"strings"
 	"sync"
 
 	"tailscale.com/types/logger"
@@ -77,3 +80,98 @@ type checkFunc struct {
 
 func (c checkFunc) Name() string                                   { return c.name }
 func (c checkFunc) Run(ctx context.Context, log logger.Logf) error { return c.run(ctx, log) }
+
+// Severity is how urgently a Finding needs attention.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // Tailscale is broken, or about to be
+	SeverityWarning Severity = "warning" // Tailscale works, but not as well as it could
+	SeverityInfo    Severity = "info"
+)
+
+func (s Severity) rank() int {
+	switch s {
+	case SeverityError:
+		return 0
+	case SeverityWarning:
+		return 1
+	}
+	return 2
+}
+
+// Finding is a problem found by a Diagnoser, described for the user rather
+// than for support.
+type Finding struct {
+	Check    string // name of the Check that found it; set by Diagnose
+	Severity Severity
+	Summary  string // what's wrong
+	Fix      string // what the user can do about it, if anything
+}
+
+// Diagnoser is a Check that can also report what it finds as Findings.
+//
+// Its Run method should log the same findings, so that running it with
+// RunChecks tells support what Diagnose would tell the user.
+type Diagnoser interface {
+	Check
+	// Diagnose executes the check, returning what it found.
+	Diagnose(context.Context) []Finding
+}
+
+// DiagnoserFunc creates a Diagnoser from a name and a function returning
+// findings. Its Run method logs each finding.
+func DiagnoserFunc(name string, diagnose func(context.Context) []Finding) Diagnoser {
+	return diagnoserFunc{name, diagnose}
+}
+
+type diagnoserFunc struct {
+	name     string
+	diagnose func(context.Context) []Finding
+}
+
+func (d diagnoserFunc) Name() string                           { return d.name }
+func (d diagnoserFunc) Diagnose(ctx context.Context) []Finding { return d.diagnose(ctx) }
+
+func (d diagnoserFunc) Run(ctx context.Context, log logger.Logf) error {
+	for _, f := range d.diagnose(ctx) {
+		log("%s: %s", f.Severity, f.Summary)
+	}
+	return nil
+}
+
+// Diagnose runs the Diagnosers among checks in parallel, skipping the other
+// checks, and returns the names of the checks it ran and what they found,
+// most severe first.
+func Diagnose(ctx context.Context, checks ...Check) (names []string, findings []Finding) {
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, check := range checks {
+		d, ok := check.(Diagnoser)
+		if !ok {
+			continue
+		}
+		names = append(names, d.Name())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fs := d.Diagnose(ctx)
+			for i := range fs {
+				fs[i].Check = d.Name()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			findings = append(findings, fs...)
+		}()
+	}
+	wg.Wait()
+
+	// Sort by check name within a severity, so that the order doesn't
+	// depend on which check finished first.
+	slices.SortStableFunc(findings, func(a, b Finding) int {
+		return cmp.Or(cmp.Compare(a.Severity.rank(), b.Severity.rank()), strings.Compare(a.Check, b.Check))
+	})
+	return names, findings
+}
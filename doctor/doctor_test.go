@@ -47,3 +47,40 @@ func (t testCheck1) Run(_ context.Context, log logger.Logf) error {
 	log("check 1")
 	return nil
 }
+
+func TestDiagnose(t *testing.T) {
+	c := qt.New(t)
+	names, fs := Diagnose(context.Background(),
+		testCheck1{},
+		DiagnoserFunc("b", func(context.Context) []Finding {
+			return []Finding{
+				{Severity: SeverityInfo},
+				{Severity: SeverityWarning},
+				{Severity: SeverityError},
+			}
+		}),
+		DiagnoserFunc("a", func(context.Context) []Finding {
+			return []Finding{{Severity: SeverityWarning}}
+		}),
+	)
+	c.Assert(names, qt.DeepEquals, []string{"b", "a"})
+
+	var got []string
+	for _, f := range fs {
+		got = append(got, f.Check+":"+string(f.Severity))
+	}
+	c.Assert(got, qt.DeepEquals, []string{"b:error", "a:warning", "b:warning", "b:info"})
+}
+
+func TestDiagnoserFuncRun(t *testing.T) {
+	c := qt.New(t)
+	var lines []string
+	logf := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	d := DiagnoserFunc("d", func(context.Context) []Finding {
+		return []Finding{{Severity: SeverityWarning, Summary: "something's off", Fix: "fix it"}}
+	})
+	RunChecks(context.Background(), logf, d)
+	c.Assert(lines, qt.DeepEquals, []string{"d: warning: something's off"})
+}
@@ -22,6 +22,7 @@ func (src *Share) Clone() *Share {
 	Name         string
 	Path         string
 	As           string
+	ReadOnly     bool
 	BookmarkData []byte
 }{})
 
@@ -59,9 +59,10 @@ func (v *ShareView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v ShareView) Name() string { return v.ж.Name }
-func (v ShareView) Path() string { return v.ж.Path }
-func (v ShareView) As() string   { return v.ж.As }
+func (v ShareView) Name() string   { return v.ж.Name }
+func (v ShareView) Path() string   { return v.ж.Path }
+func (v ShareView) As() string     { return v.ж.As }
+func (v ShareView) ReadOnly() bool { return v.ж.ReadOnly }
 func (v ShareView) BookmarkData() views.ByteSlice[[]byte] {
 	return views.ByteSliceOf(v.ж.BookmarkData)
 }
@@ -71,5 +72,6 @@ func (v ShareView) BookmarkData() views.ByteSlice[[]byte] {
 	Name         string
 	Path         string
 	As           string
+	ReadOnly     bool
 	BookmarkData []byte
 }{})
@@ -116,6 +116,24 @@ func TestPermissions(t *testing.T) {
 	}
 }
 
+func TestReadOnlyShare(t *testing.T) {
+	s := newSystem(t)
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, drive.PermissionReadWrite)
+	s.setShareReadOnly(remote1, share11)
+
+	s.writeFile("writing file to read-only share should fail despite read/write permission", remote1, share11, file111, "hello world", false)
+
+	// Now, write file directly to file system so that we can test permissions
+	// on other operations.
+	s.write(remote1, share11, file111, "hello world")
+	s.checkFileContents(remote1, share11, file111)
+	if err := s.client.Remove(pathTo(remote1, share11, file111)); err == nil {
+		t.Error("deleting file from read-only share should fail")
+	}
+}
+
 // TestSecretTokenAuth verifies that the fileserver running at localhost cannot
 // be accessed directly without the correct secret token. This matters because
 // if a victim can be induced to visit the localhost URL and access a malicious
@@ -278,6 +296,24 @@ func (s *system) addShare(remoteName, shareName string, permission drive.Permiss
 	r.fileServer.SetShares(r.shares)
 }
 
+func (s *system) setShareReadOnly(remoteName, shareName string) {
+	r, ok := s.remotes[remoteName]
+	if !ok {
+		s.t.Fatalf("unknown remote %q", remoteName)
+	}
+
+	shares := make([]*drive.Share, 0, len(r.shares))
+	for name, folder := range r.shares {
+		shares = append(shares, &drive.Share{
+			Name:     name,
+			Path:     folder,
+			ReadOnly: name == shareName,
+		})
+	}
+	slices.SortFunc(shares, drive.CompareShares)
+	r.fs.SetShares(shares)
+}
+
 func (s *system) freezeRemote(remoteName string) {
 	r, ok := s.remotes[remoteName]
 	if !ok {
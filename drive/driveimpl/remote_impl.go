@@ -212,6 +212,10 @@ func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions drive.Permissions,
 			http.Error(w, "permission denied", http.StatusForbidden)
 			return
 		}
+		if s.shareIsReadOnly(share) {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
 	}
 
 	s.mu.RLock()
@@ -235,6 +239,17 @@ func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions drive.Permissions,
 	h.ServeHTTP(w, r)
 }
 
+// shareIsReadOnly reports whether the share with the given name has been
+// configured as read-only by the local user.
+func (s *FileSystemForRemote) shareIsReadOnly(shareName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, shareFound := slices.BinarySearchFunc(s.shares, shareName, func(share *drive.Share, name string) int {
+		return strings.Compare(share.Name, name)
+	})
+	return shareFound && s.shares[i].ReadOnly
+}
+
 func (s *FileSystemForRemote) stopUserServers(userServers map[string]*userServer) {
 	for _, server := range userServers {
 		if err := server.Close(); err != nil {
@@ -44,6 +44,10 @@ type Share struct {
 	// Tailscale GUI".
 	As string `json:"who,omitempty"`
 
+	// ReadOnly, if true, prevents remote nodes from modifying the share's
+	// contents regardless of the access granted to them by ACLs.
+	ReadOnly bool `json:"readOnly,omitempty"`
+
 	// BookmarkData contains security-scoped bookmark data for the Sandboxed
 	// Mac application. The Sandboxed Mac application gains permission to
 	// access the Share's folder as a result of a user selecting it in a file
@@ -60,7 +64,7 @@ func ShareViewsEqual(a, b ShareView) bool {
 	if !a.Valid() || !b.Valid() {
 		return false
 	}
-	return a.Name() == b.Name() && a.Path() == b.Path() && a.As() == b.As() && a.BookmarkData().Equal(b.ж.BookmarkData)
+	return a.Name() == b.Name() && a.Path() == b.Path() && a.As() == b.As() && a.ReadOnly() == b.ReadOnly() && a.BookmarkData().Equal(b.ж.BookmarkData)
 }
 
 func SharesEqual(a, b *Share) bool {
@@ -70,7 +74,7 @@ func SharesEqual(a, b *Share) bool {
 	if a == nil || b == nil {
 		return false
 	}
-	return a.Name == b.Name && a.Path == b.Path && a.As == b.As && bytes.Equal(a.BookmarkData, b.BookmarkData)
+	return a.Name == b.Name && a.Path == b.Path && a.As == b.As && a.ReadOnly == b.ReadOnly && bytes.Equal(a.BookmarkData, b.BookmarkData)
 }
 
 func CompareShares(a, b *Share) int {
@@ -13,6 +13,7 @@
 	"os/exec"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -57,6 +58,8 @@ func New() *tailcfg.Hostinfo {
 		NoLogsNoSupport: envknob.NoLogsNoSupport(),
 		AllowsUpdate:    envknob.AllowsRemoteUpdate(),
 		WoLMACs:         getWoLMACs(),
+
+		ExperimentalFeatures: ExperimentalFeatures(),
 	}
 }
 
@@ -208,6 +211,43 @@ func SetPackage(v string) { packagingType.Store(v) }
 // and "k8s-operator".
 func SetApp(v string) { appType.Store(v) }
 
+var (
+	experimentalMu       sync.Mutex
+	experimentalFeatures map[string]bool // enabled ones only
+)
+
+// SetExperimentalFeature records whether the named experimental datapath
+// feature, like "socket-bypass", is enabled, for reporting to the control
+// plane and in bug reports.
+func SetExperimentalFeature(name string, enabled bool) {
+	experimentalMu.Lock()
+	defer experimentalMu.Unlock()
+	if !enabled {
+		delete(experimentalFeatures, name)
+		return
+	}
+	if experimentalFeatures == nil {
+		experimentalFeatures = map[string]bool{}
+	}
+	experimentalFeatures[name] = true
+}
+
+// ExperimentalFeatures returns the sorted names of the enabled
+// experimental datapath features, or nil if there are none.
+func ExperimentalFeatures() []string {
+	experimentalMu.Lock()
+	defer experimentalMu.Unlock()
+	if len(experimentalFeatures) == 0 {
+		return nil
+	}
+	ret := make([]string, 0, len(experimentalFeatures))
+	for name := range experimentalFeatures {
+		ret = append(ret, name)
+	}
+	slices.Sort(ret)
+	return ret
+}
+
 // FirewallMode returns the firewall mode for the app.
 // It is empty if unset.
 func FirewallMode() string {
@@ -5,6 +5,7 @@
 
 import (
 	"encoding/json"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -49,3 +50,25 @@ func TestEtcAptSourceFileIsDisabled(t *testing.T) {
 		})
 	}
 }
+
+func TestExperimentalFeatures(t *testing.T) {
+	defer func() {
+		experimentalMu.Lock()
+		experimentalFeatures = nil
+		experimentalMu.Unlock()
+	}()
+	if got := ExperimentalFeatures(); got != nil {
+		t.Fatalf("initially %q; want nil", got)
+	}
+	SetExperimentalFeature("zz", true)
+	SetExperimentalFeature("aa", true)
+	SetExperimentalFeature("mm", true)
+	SetExperimentalFeature("mm", false)
+	SetExperimentalFeature("never", false)
+	if got, want := ExperimentalFeatures(), []string{"aa", "zz"}; !slices.Equal(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, want := New().ExperimentalFeatures, []string{"aa", "zz"}; !slices.Equal(got, want) {
+		t.Errorf("New().ExperimentalFeatures = %q; want %q", got, want)
+	}
+}
@@ -5,6 +5,7 @@
 
 import (
 	"fmt"
+	"net/netip"
 	"strings"
 	"time"
 
@@ -138,6 +139,10 @@ type Notify struct {
 	// empty value means that there are no shares.
 	DriveShares views.SliceView[*drive.Share, drive.ShareView]
 
+	// FilterAlert, if non-nil, is an inbound connection attempt that
+	// matched one of the user's FilterAlertRules and was dropped.
+	FilterAlert *FilterAlert `json:",omitempty"`
+
 	// type is mirrored in xcode/Shared/IPN.swift
 }
 
@@ -177,6 +182,9 @@ func (n Notify) String() string {
 	if n.LocalTCPPort != nil {
 		fmt.Fprintf(&sb, "tcpport=%v ", n.LocalTCPPort)
 	}
+	if n.FilterAlert != nil {
+		fmt.Fprintf(&sb, "filteralert=%v ", n.FilterAlert.Rule)
+	}
 	s := sb.String()
 	return s[0:len(s)-1] + "}"
 }
@@ -212,6 +220,44 @@ type OutgoingFile struct {
 	Succeeded    bool                 // for a finished transfer, indicates whether or not it was successful
 }
 
+// FilterAlertRule describes inbound connection attempts that should never
+// happen inside the tailnet, such as RDP from non-admin machines. Matching
+// packets are dropped, even if the tailnet's policy allows them, and
+// reported as FilterAlerts.
+//
+// Only attempts to start a connection match: TCP SYNs, and UDP and SCTP
+// packets that aren't replies to traffic that this node sent.
+type FilterAlertRule struct {
+	// Name identifies the rule in FilterAlerts.
+	Name string
+
+	// SrcIPs are the sources to match, in the same form as
+	// tailcfg.FilterRule.SrcIPs. Additionally, "tag:foo" matches the
+	// addresses of peers with that tag.
+	SrcIPs []string
+
+	// DstPorts are the destinations to match, as in
+	// tailcfg.FilterRule.DstPorts.
+	DstPorts []tailcfg.NetPortRange
+
+	// IPProto optionally restricts the rule to the given IP protocol
+	// numbers, as in tailcfg.FilterRule.IPProto.
+	IPProto []int `json:",omitempty"`
+}
+
+// FilterAlert is an inbound connection attempt that matched a
+// FilterAlertRule.
+type FilterAlert struct {
+	Rule  string         // the FilterAlertRule's Name
+	Time  time.Time      // when the attempt was seen
+	Proto string         // IP protocol, e.g. "TCP"
+	Src   netip.AddrPort // source of the attempt
+	Dst   netip.AddrPort // destination of the attempt on this node
+
+	// SrcNode is the name of the peer that sent the attempt, if known.
+	SrcNode string `json:",omitempty"`
+}
+
 // StateKey is an opaque identifier for a set of LocalBackend state
 // (preferences, private keys, etc.). It is also used as a key for
 // the various LoginProfiles that the instance may be signed into.
@@ -24,6 +24,7 @@ type ConfigVAlpha struct {
 	Hostname     *string `json:",omitempty"`
 
 	AcceptDNS    opt.Bool `json:"acceptDNS,omitempty"`    // --accept-dns
+	DNSOverride  *string  `json:"dnsOverride,omitempty"`  // --dns-override: "", "local-resolvers", "ignore"
 	AcceptRoutes opt.Bool `json:"acceptRoutes,omitempty"` // --accept-routes defaults to true
 
 	ExitNode                   *string  `json:"exitNode,omitempty"` // IP, StableID, or MagicDNS base name
@@ -35,12 +36,13 @@ type ConfigVAlpha struct {
 	NetfilterMode       *string  `json:",omitempty"` // "on", "off", "nodivert"
 	NoStatefulFiltering opt.Bool `json:",omitempty"`
 
-	PostureChecking opt.Bool         `json:",omitempty"`
-	RunSSHServer    opt.Bool         `json:",omitempty"` // Tailscale SSH
-	RunWebClient    opt.Bool         `json:",omitempty"`
-	ShieldsUp       opt.Bool         `json:",omitempty"`
-	AutoUpdate      *AutoUpdatePrefs `json:",omitempty"`
-	ServeConfigTemp *ServeConfig     `json:",omitempty"` // TODO(bradfitz,maisem): make separate stable type for this
+	PostureChecking          opt.Bool         `json:",omitempty"`
+	HideExperimentalFeatures opt.Bool         `json:",omitempty"`
+	RunSSHServer             opt.Bool         `json:",omitempty"` // Tailscale SSH
+	RunWebClient             opt.Bool         `json:",omitempty"`
+	ShieldsUp                opt.Bool         `json:",omitempty"`
+	AutoUpdate               *AutoUpdatePrefs `json:",omitempty"`
+	ServeConfigTemp          *ServeConfig     `json:",omitempty"` // TODO(bradfitz,maisem): make separate stable type for this
 
 	// TODO(bradfitz,maisem): future something like:
 	// Profile map[string]*Config // keyed by alice@gmail.com, corp.com (TailnetSID)
@@ -74,6 +76,14 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.CorpDNS = c.AcceptDNS.EqualBool(true)
 		mp.CorpDNSSet = true
 	}
+	if c.DNSOverride != nil {
+		o, err := preftype.ParseDNSOverride(*c.DNSOverride)
+		if err != nil {
+			return mp, err
+		}
+		mp.DNSOverride = o
+		mp.DNSOverrideSet = true
+	}
 	if c.AcceptRoutes != "" {
 		mp.RouteAll = c.AcceptRoutes.EqualBool(true)
 		mp.RouteAllSet = true
@@ -117,6 +127,10 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.PostureChecking = c.PostureChecking.EqualBool(true)
 		mp.PostureCheckingSet = true
 	}
+	if c.HideExperimentalFeatures != "" {
+		mp.HideExperimentalFeatures = c.HideExperimentalFeatures.EqualBool(true)
+		mp.HideExperimentalFeaturesSet = true
+	}
 	if c.RunSSHServer != "" {
 		mp.RunSSH = c.RunSSHServer.EqualBool(true)
 		mp.RunSSHSet = true
@@ -0,0 +1,161 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package conffile
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+
+	"tailscale.com/ipn"
+	"tailscale.com/net/netutil"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/opt"
+	"tailscale.com/types/views"
+	"tailscale.com/util/dnsname"
+)
+
+// Field describes one top-level field of the config file.
+type Field struct {
+	Name    string `json:"name"`              // JSON key
+	Type    string `json:"type"`              // "string", "bool", "[]prefix" or "object"
+	Default string `json:"default,omitempty"` // what an absent value means, if not the zero value
+	Doc     string `json:"doc"`
+}
+
+// fieldDocs documents each field of ipn.ConfigVAlpha, keyed by Go field
+// name. TestSchemaComplete ensures it stays in sync with the struct.
+var fieldDocs = map[string]struct{ def, doc string }{
+	"Version":                    {"", `config file format version; must be "alpha0"`},
+	"Locked":                     {"true", "whether 'tailscale set' is prevented from changing settings"},
+	"ServerURL":                  {ipn.DefaultControlURL, "control server URL"},
+	"AuthKey":                    {"", `auth key, or "file:" followed by the path of a file containing one`},
+	"Enabled":                    {"true", "whether Tailscale should be running"},
+	"OperatorUser":               {"", "local user allowed to operate tailscaled without root"},
+	"Hostname":                   {"", "hostname to use instead of the OS hostname"},
+	"AcceptDNS":                  {"true", "whether to use the tailnet's DNS configuration (--accept-dns)"},
+	"DNSOverride":                {"", `override of the tailnet's DNS configuration: "", "local-resolvers" or "ignore" (--dns-override)`},
+	"AcceptRoutes":               {"", "whether to accept subnet routes advertised by peers (--accept-routes)"},
+	"ExitNode":                   {"", "exit node to use: IP, stable node ID or MagicDNS base name"},
+	"AllowLANWhileUsingExitNode": {"false", "whether the local LAN stays reachable while using an exit node"},
+	"AdvertiseRoutes":            {"", "subnet routes to advertise; 0.0.0.0/0 and ::/0 together make this an exit node"},
+	"DisableSNAT":                {"false", "whether to disable source NAT of traffic to advertised routes"},
+	"NetfilterMode":              {"on", `Linux netfilter mode: "on", "off" or "nodivert"`},
+	"NoStatefulFiltering":        {"", "whether to disable stateful filtering of inbound traffic"},
+	"PostureChecking":            {"false", "whether to collect device posture data"},
+	"HideExperimentalFeatures":   {"false", "whether to keep enabled experimental datapath features out of reports to control and bug reports"},
+	"RunSSHServer":               {"false", "whether to run the Tailscale SSH server"},
+	"RunWebClient":               {"false", "whether to run the web client on port 5252"},
+	"ShieldsUp":                  {"false", "whether to block all incoming connections"},
+	"AutoUpdate":                 {"", `auto-update settings: {"Check": bool, "Apply": bool}`},
+	"ServeConfigTemp":            {"", "serve and funnel configuration; format subject to change"},
+}
+
+// Schema returns the fields of the current config file format, in the
+// order they're declared.
+func Schema() []Field {
+	rt := reflect.TypeFor[ipn.ConfigVAlpha]()
+	fields := make([]Field, 0, rt.NumField())
+	for i := range rt.NumField() {
+		sf := rt.Field(i)
+		d := fieldDocs[sf.Name]
+		fields = append(fields, Field{
+			Name:    jsonName(sf),
+			Type:    schemaType(sf.Type),
+			Default: d.def,
+			Doc:     d.doc,
+		})
+	}
+	return fields
+}
+
+// jsonName returns the JSON key encoding/json uses for sf.
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" {
+		return sf.Name
+	}
+	return name
+}
+
+func schemaType(t reflect.Type) string {
+	switch t {
+	case reflect.TypeFor[opt.Bool]():
+		return "bool"
+	case reflect.TypeFor[[]netip.Prefix]():
+		return "[]prefix"
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Struct:
+		return "object"
+	}
+	return t.String()
+}
+
+// Validate checks c for values that parse but that tailscaled would
+// reject or that contradict each other. It returns all problems found,
+// joined, or nil if there are none.
+func (c *Config) Validate() error {
+	p := &c.Parsed
+	var errs []error
+	errf := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, err := p.ToPrefs(); err != nil {
+		errs = append(errs, err)
+	}
+	if p.ServerURL != nil {
+		if u, err := url.Parse(*p.ServerURL); err != nil {
+			errf("ServerURL: %v", err)
+		} else if u.Scheme != "https" && u.Scheme != "http" {
+			errf("ServerURL: %q is not an http or https URL", *p.ServerURL)
+		}
+	}
+	if p.AuthKey != nil {
+		if path, ok := strings.CutPrefix(*p.AuthKey, "file:"); ok {
+			if _, err := os.Stat(path); err != nil {
+				errf("AuthKey: %v", err)
+			}
+		}
+	}
+	if p.Hostname != nil && *p.Hostname != "" {
+		if err := dnsname.ValidHostname(*p.Hostname); err != nil {
+			errf("Hostname: %v", err)
+		}
+	}
+	if len(p.AdvertiseRoutes) > 0 {
+		ss := make([]string, len(p.AdvertiseRoutes))
+		for i, r := range p.AdvertiseRoutes {
+			ss[i] = r.String()
+		}
+		if _, err := netutil.CalcAdvertiseRoutes(strings.Join(ss, ","), false); err != nil {
+			errf("AdvertiseRoutes: %v", err)
+		}
+	}
+
+	// Cross-field constraints.
+	usesExitNode := p.ExitNode != nil && *p.ExitNode != ""
+	if p.AllowLANWhileUsingExitNode.EqualBool(true) && !usesExitNode {
+		errf("allowLANWhileUsingExitNode can only be used with exitNode")
+	}
+	if usesExitNode && tsaddr.ContainsExitRoutes(views.SliceOf(p.AdvertiseRoutes)) {
+		errf("exitNode can't be used while also advertising exit node routes in AdvertiseRoutes")
+	}
+	if au := p.AutoUpdate; au != nil && au.Apply.EqualBool(true) && !au.Check {
+		errf("AutoUpdate.Apply requires AutoUpdate.Check")
+	}
+	return errors.Join(errs...)
+}
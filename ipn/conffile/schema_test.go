@@ -0,0 +1,125 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package conffile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+func TestSchemaComplete(t *testing.T) {
+	rt := reflect.TypeFor[ipn.ConfigVAlpha]()
+	for i := range rt.NumField() {
+		if name := rt.Field(i).Name; fieldDocs[name].doc == "" {
+			t.Errorf("ipn.ConfigVAlpha field %q is missing from fieldDocs", name)
+		}
+	}
+	if got, want := len(fieldDocs), rt.NumField(); got != want {
+		t.Errorf("fieldDocs has %d entries; ipn.ConfigVAlpha has %d fields", got, want)
+	}
+
+	byName := map[string]Field{}
+	for _, f := range Schema() {
+		byName[f.Name] = f
+	}
+	for name, wantType := range map[string]string{
+		"Version":         "string",
+		"acceptDNS":       "bool",
+		"AdvertiseRoutes": "[]prefix",
+		"AutoUpdate":      "object",
+	} {
+		if got := byName[name].Type; got != wantType {
+			t.Errorf("field %q type = %q; want %q", name, got, wantType)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		conf    string
+		wantErr string // substring; empty means valid
+	}{
+		{
+			name: "minimal",
+			conf: `{"version": "alpha0"}`,
+		},
+		{
+			name: "hujson",
+			conf: `{
+				// comments are fine
+				"version": "alpha0",
+				"Hostname": "web-1",
+				"AdvertiseRoutes": ["10.0.0.0/8", "0.0.0.0/0", "::/0"],
+			}`,
+		},
+		{
+			name:    "bad_hostname",
+			conf:    `{"version": "alpha0", "Hostname": "not_valid!"}`,
+			wantErr: "Hostname:",
+		},
+		{
+			name:    "bad_netfilter_mode",
+			conf:    `{"version": "alpha0", "NetfilterMode": "sometimes"}`,
+			wantErr: "sometimes",
+		},
+		{
+			name:    "bad_server_url",
+			conf:    `{"version": "alpha0", "ServerURL": "controlplane.example.com"}`,
+			wantErr: "not an http or https URL",
+		},
+		{
+			name:    "exit_route_without_ipv6",
+			conf:    `{"version": "alpha0", "AdvertiseRoutes": ["0.0.0.0/0"]}`,
+			wantErr: "without its IPv6 counterpart",
+		},
+		{
+			name:    "lan_access_without_exit_node",
+			conf:    `{"version": "alpha0", "allowLANWhileUsingExitNode": true}`,
+			wantErr: "can only be used with exitNode",
+		},
+		{
+			name:    "use_and_advertise_exit_node",
+			conf:    `{"version": "alpha0", "exitNode": "100.64.0.1", "AdvertiseRoutes": ["0.0.0.0/0", "::/0"]}`,
+			wantErr: "advertising exit node routes",
+		},
+		{
+			name:    "auto_apply_without_check",
+			conf:    `{"version": "alpha0", "AutoUpdate": {"Apply": true}}`,
+			wantErr: "requires AutoUpdate.Check",
+		},
+		{
+			name:    "missing_auth_key_file",
+			conf:    `{"version": "alpha0", "AuthKey": "file:/nonexistent/authkey"}`,
+			wantErr: "AuthKey:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".hujson")
+			if err := os.WriteFile(path, []byte(tt.conf), 0600); err != nil {
+				t.Fatal(err)
+			}
+			c, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("got no error; want one containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("error %q doesn't contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
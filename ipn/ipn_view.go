@@ -73,6 +73,8 @@ func (v PrefsView) ExitNodeIP() netip.Addr                      { return v.ж.Ex
 func (v PrefsView) InternalExitNodePrior() tailcfg.StableNodeID { return v.ж.InternalExitNodePrior }
 func (v PrefsView) ExitNodeAllowLANAccess() bool                { return v.ж.ExitNodeAllowLANAccess }
 func (v PrefsView) CorpDNS() bool                               { return v.ж.CorpDNS }
+func (v PrefsView) DNSOverride() preftype.DNSOverride           { return v.ж.DNSOverride }
+func (v PrefsView) NoDoHUpgrade() bool                          { return v.ж.NoDoHUpgrade }
 func (v PrefsView) RunSSH() bool                                { return v.ж.RunSSH }
 func (v PrefsView) RunWebClient() bool                          { return v.ж.RunWebClient }
 func (v PrefsView) WantRunning() bool                           { return v.ж.WantRunning }
@@ -94,6 +96,7 @@ func (v PrefsView) ProfileName() string                   { return v.ж.ProfileN
 func (v PrefsView) AutoUpdate() AutoUpdatePrefs           { return v.ж.AutoUpdate }
 func (v PrefsView) AppConnector() AppConnectorPrefs       { return v.ж.AppConnector }
 func (v PrefsView) PostureChecking() bool                 { return v.ж.PostureChecking }
+func (v PrefsView) HideExperimentalFeatures() bool        { return v.ж.HideExperimentalFeatures }
 func (v PrefsView) NetfilterKind() string                 { return v.ж.NetfilterKind }
 func (v PrefsView) DriveShares() views.SliceView[*drive.Share, drive.ShareView] {
 	return views.SliceOfViews[*drive.Share, drive.ShareView](v.ж.DriveShares)
@@ -102,36 +105,39 @@ func (v PrefsView) Persist() persist.PersistView { return v.ж.Persist.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PrefsViewNeedsRegeneration = Prefs(struct {
-	ControlURL             string
-	RouteAll               bool
-	AllowSingleHosts       bool
-	ExitNodeID             tailcfg.StableNodeID
-	ExitNodeIP             netip.Addr
-	InternalExitNodePrior  tailcfg.StableNodeID
-	ExitNodeAllowLANAccess bool
-	CorpDNS                bool
-	RunSSH                 bool
-	RunWebClient           bool
-	WantRunning            bool
-	LoggedOut              bool
-	ShieldsUp              bool
-	AdvertiseTags          []string
-	Hostname               string
-	NotepadURLs            bool
-	ForceDaemon            bool
-	Egg                    bool
-	AdvertiseRoutes        []netip.Prefix
-	NoSNAT                 bool
-	NoStatefulFiltering    opt.Bool
-	NetfilterMode          preftype.NetfilterMode
-	OperatorUser           string
-	ProfileName            string
-	AutoUpdate             AutoUpdatePrefs
-	AppConnector           AppConnectorPrefs
-	PostureChecking        bool
-	NetfilterKind          string
-	DriveShares            []*drive.Share
-	Persist                *persist.Persist
+	ControlURL               string
+	RouteAll                 bool
+	AllowSingleHosts         bool
+	ExitNodeID               tailcfg.StableNodeID
+	ExitNodeIP               netip.Addr
+	InternalExitNodePrior    tailcfg.StableNodeID
+	ExitNodeAllowLANAccess   bool
+	CorpDNS                  bool
+	DNSOverride              preftype.DNSOverride
+	NoDoHUpgrade             bool
+	RunSSH                   bool
+	RunWebClient             bool
+	WantRunning              bool
+	LoggedOut                bool
+	ShieldsUp                bool
+	AdvertiseTags            []string
+	Hostname                 string
+	NotepadURLs              bool
+	ForceDaemon              bool
+	Egg                      bool
+	AdvertiseRoutes          []netip.Prefix
+	NoSNAT                   bool
+	NoStatefulFiltering      opt.Bool
+	NetfilterMode            preftype.NetfilterMode
+	OperatorUser             string
+	ProfileName              string
+	AutoUpdate               AutoUpdatePrefs
+	AppConnector             AppConnectorPrefs
+	PostureChecking          bool
+	HideExperimentalFeatures bool
+	NetfilterKind            string
+	DriveShares              []*drive.Share
+	Persist                  *persist.Persist
 }{})
 
 // View returns a readonly view of ServeConfig.
@@ -180,7 +180,7 @@ func (ci *ConnIdentity) IsReadonlyConn(operatorUID string, logf logger.Logf) boo
 		logf("connection from userid %v; is configured operator", uid)
 		return rw
 	}
-	if yes, err := isLocalAdmin(uid); err != nil {
+	if yes, err := IsLocalAdmin(uid); err != nil {
 		logf("connection from userid %v; read-only; %v", uid, err)
 		return ro
 	} else if yes {
@@ -191,7 +191,9 @@ func (ci *ConnIdentity) IsReadonlyConn(operatorUID string, logf logger.Logf) boo
 	return ro
 }
 
-func isLocalAdmin(uid string) (bool, error) {
+// IsLocalAdmin reports whether the user with the given uid is a member of
+// the system's admin group. It returns an error on OSes that have none.
+func IsLocalAdmin(uid string) (bool, error) {
 	u, err := user.LookupId(uid)
 	if err != nil {
 		return false, err
@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"tailscale.com/control/controlclient"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
+)
+
+// authKeyFilePollInterval is how often watchAuthKeyFile checks the auth
+// key file for changes. Zero means defaultAuthKeyFilePollInterval.
+var authKeyFilePollInterval = envknob.RegisterDuration("TS_AUTHKEY_FILE_POLL_INTERVAL")
+
+const defaultAuthKeyFilePollInterval = 10 * time.Second
+
+// authKeyFileLocked returns the path of the file containing the auth key,
+// if the config file specifies its AuthKey as "file:<path>". Only that form
+// is watched: the CLI reads 'tailscale up --auth-key=file:<path>' itself,
+// once, and sends tailscaled the key.
+//
+// b.mu must be held.
+func (b *LocalBackend) authKeyFileLocked() (path string, ok bool) {
+	if b.conf == nil || b.conf.Parsed.AuthKey == nil {
+		return "", false
+	}
+	return strings.CutPrefix(*b.conf.Parsed.AuthKey, "file:")
+}
+
+// readAuthKeyFile returns the auth key in the named file.
+func readAuthKeyFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// authKeyFromFile returns a func that reads the auth key from path, for
+// use as controlclient.Options.AuthKeyFunc. The file is read at each
+// login, so a key that's rotated by rewriting the file, or by updating a
+// Kubernetes secret mounted there, is used from the next login on. The
+// func returns the empty string, meaning to use the key read at startup,
+// if the file can't be read or is empty.
+func authKeyFromFile(path string) func() string {
+	return func() string {
+		key, _ := readAuthKeyFile(path)
+		return key
+	}
+}
+
+// watchAuthKeyFile polls the config file's auth key file and, when its
+// contents change while the backend needs to log in, starts a new login so
+// that a replacement for a rejected or expired key is picked up without
+// restarting tailscaled. It runs until b.ctx is done.
+func (b *LocalBackend) watchAuthKeyFile() {
+	interval := authKeyFilePollInterval()
+	if interval <= 0 {
+		interval = defaultAuthKeyFilePollInterval
+	}
+	ticker, tickerChannel := b.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastPath, lastKey string
+	for {
+		b.mu.Lock()
+		path, ok := b.authKeyFileLocked()
+		b.mu.Unlock()
+		if ok {
+			if key, err := readAuthKeyFile(path); err == nil && key != "" {
+				if path == lastPath && key != lastKey {
+					b.logf("auth key file %q changed", path)
+					b.authKeyFileChanged()
+				}
+				lastPath, lastKey = path, key
+			}
+		}
+
+		select {
+		case <-tickerChannel:
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// authKeyFileChanged starts a login with the new auth key if the backend
+// is waiting for one.
+func (b *LocalBackend) authKeyFileChanged() {
+	b.mu.Lock()
+	cc := b.cc
+	needsLogin := b.state == ipn.NeedsLogin
+	b.mu.Unlock()
+	if cc == nil || !needsLogin {
+		return
+	}
+	b.logf("logging in with new auth key")
+	cc.Login(nil, controlclient.LoginDefault)
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthKeyFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authkey")
+	get := authKeyFromFile(path)
+
+	if got := get(); got != "" {
+		t.Errorf("missing file: got %q; want empty", got)
+	}
+	for _, key := range []string{"tskey-auth-one", "tskey-auth-two"} {
+		if err := os.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got := get(); got != key {
+			t.Errorf("got %q; want %q", got, key)
+		}
+	}
+}
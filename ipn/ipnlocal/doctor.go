@@ -0,0 +1,359 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/doctor"
+	"tailscale.com/doctor/ethtool"
+	"tailscale.com/doctor/permissions"
+	"tailscale.com/doctor/routetable"
+	"tailscale.com/ipn"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/types/netmap"
+)
+
+// diagnoseTimeout bounds how long Diagnose's checks may take in total.
+const diagnoseTimeout = 15 * time.Second
+
+// keyExpiryWarning is how long before the node key expires that the
+// key-expiry check starts warning about it.
+const keyExpiryWarning = 7 * 24 * time.Hour
+
+// Diagnose runs the checks behind "tailscale doctor" and returns what they
+// found, most severe first. Unlike Doctor, which logs what it learns for
+// support to read, each finding is meant for the user and suggests a fix.
+func (b *LocalBackend) Diagnose(ctx context.Context) *apitype.DoctorResponse {
+	ctx, cancel := context.WithTimeout(ctx, diagnoseTimeout)
+	defer cancel()
+
+	names, fs := doctor.Diagnose(ctx, b.doctorChecks()...)
+	res := &apitype.DoctorResponse{Checks: names}
+	for _, f := range fs {
+		res.Findings = append(res.Findings, apitype.DoctorFinding{
+			Check:    f.Check,
+			Severity: apitype.DoctorSeverity(f.Severity),
+			Summary:  f.Summary,
+			Fix:      f.Fix,
+		})
+	}
+	return res
+}
+
+// doctorChecks returns the checks run by both Doctor and Diagnose.
+func (b *LocalBackend) doctorChecks() []doctor.Check {
+	// current returns the state the checks below look at.
+	current := func() (ipn.State, *netmap.NetworkMap, ipn.PrefsView) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.state, b.netMap, b.pm.CurrentPrefs()
+	}
+	return []doctor.Check{
+		permissions.Check{},
+		routetable.Check{},
+		ethtool.Check{},
+		doctor.DiagnoserFunc("backend-state", func(context.Context) []doctor.Finding {
+			state, _, _ := current()
+			return backendStateFindings(state)
+		}),
+		doctor.DiagnoserFunc("key-expiry", func(context.Context) []doctor.Finding {
+			_, nm, _ := current()
+			if nm == nil {
+				return nil
+			}
+			return keyExpiryFindings(nm.SelfNode, b.clock.Now())
+		}),
+		// Any of the global DNS resolvers being Tailscale IPs can
+		// interfere with our ability to connect to the control plane.
+		doctor.DiagnoserFunc("dns-resolvers", func(context.Context) []doctor.Finding {
+			_, nm, _ := current()
+			return dnsResolverFindings(nm)
+		}),
+		doctor.DiagnoserFunc("magicdns", func(ctx context.Context) []doctor.Finding {
+			state, nm, prefs := current()
+			if state != ipn.Running || nm == nil || !prefs.Valid() || !prefs.CorpDNS() {
+				return nil
+			}
+			return b.magicDNSFindings(ctx, nm)
+		}),
+		doctor.DiagnoserFunc("derp", func(ctx context.Context) []doctor.Finding {
+			if state, _, _ := current(); state != ipn.Running {
+				return nil
+			}
+			return netcheckFindings(b.MagicConn().GetLastNetcheckReport(ctx))
+		}),
+		doctor.DiagnoserFunc("conflicting-vpn", func(context.Context) []doctor.Finding {
+			return conflictingVPNFindings(b.sys.NetMon.Get().InterfaceState())
+		}),
+		doctor.DiagnoserFunc("ip-forwarding", func(context.Context) []doctor.Finding {
+			_, _, prefs := current()
+			if !prefs.Valid() || prefs.AdvertiseRoutes().Len() == 0 {
+				return nil
+			}
+			if err := b.CheckIPForwarding(); err != nil {
+				return []doctor.Finding{{
+					Severity: doctor.SeverityError,
+					Summary:  fmt.Sprintf("this node advertises routes, but won't forward traffic for them: %v", err),
+					Fix:      "enable IP forwarding for IPv4 and IPv6",
+				}}
+			}
+			return nil
+		}),
+	}
+}
+
+func backendStateFindings(state ipn.State) []doctor.Finding {
+	switch state {
+	case ipn.Running:
+		return nil
+	case ipn.NeedsLogin:
+		return []doctor.Finding{{
+			Severity: doctor.SeverityError,
+			Summary:  "Tailscale is logged out",
+			Fix:      `run "tailscale up" to log in`,
+		}}
+	case ipn.NeedsMachineAuth:
+		return []doctor.Finding{{
+			Severity: doctor.SeverityError,
+			Summary:  "this machine is waiting to be approved by a tailnet admin",
+			Fix:      "ask an admin of your tailnet to approve it in the admin console",
+		}}
+	case ipn.Stopped:
+		return []doctor.Finding{{
+			Severity: doctor.SeverityError,
+			Summary:  "Tailscale is stopped",
+			Fix:      `run "tailscale up" to connect`,
+		}}
+	}
+	return []doctor.Finding{{
+		Severity: doctor.SeverityWarning,
+		Summary:  fmt.Sprintf("Tailscale isn't running yet (state %v); other checks may be incomplete", state),
+	}}
+}
+
+// keyExpiryFindings reports whether self's node key has expired or is
+// about to, as of now.
+func keyExpiryFindings(self tailcfg.NodeView, now time.Time) []doctor.Finding {
+	if !self.Valid() {
+		return nil
+	}
+	exp := self.KeyExpiry()
+	if exp.IsZero() {
+		return nil // key expiry disabled
+	}
+	const fix = `run "tailscale up --force-reauth" to log in again, or ask an admin to disable key expiry for this machine`
+	if d := exp.Sub(now); d <= 0 {
+		return []doctor.Finding{{
+			Severity: doctor.SeverityError,
+			Summary:  fmt.Sprintf("this node's key expired %v ago", (-d).Round(time.Minute)),
+			Fix:      fix,
+		}}
+	} else if d < keyExpiryWarning {
+		return []doctor.Finding{{
+			Severity: doctor.SeverityWarning,
+			Summary:  fmt.Sprintf("this node's key expires in %v, at %v", d.Round(time.Minute), exp.Format(time.RFC3339)),
+			Fix:      fix,
+		}}
+	}
+	return nil
+}
+
+// dnsResolverFindings reports the DNS resolvers in nm that are Tailscale
+// addresses, which can keep us from reaching the control plane.
+func dnsResolverFindings(nm *netmap.NetworkMap) []doctor.Finding {
+	if nm == nil {
+		return nil
+	}
+	var fs []doctor.Finding
+	for _, resolvers := range [][]*dnstype.Resolver{nm.DNS.Resolvers, nm.DNS.FallbackResolvers} {
+		for _, r := range resolvers {
+			if ipp, ok := r.IPPort(); ok && tsaddr.IsTailscaleIP(ipp.Addr()) {
+				fs = append(fs, doctor.Finding{
+					Severity: doctor.SeverityWarning,
+					Summary:  fmt.Sprintf("DNS resolver %v is a Tailscale address; if it's unreachable, so may be the coordination server", r.Addr),
+					Fix:      "use a resolver outside the tailnet, or add one as a fallback, in the admin console's DNS settings",
+				})
+			}
+		}
+	}
+	return fs
+}
+
+// magicDNSFindings resolves our own name, and that of the coordination
+// server, through the MagicDNS resolver.
+func (b *LocalBackend) magicDNSFindings(ctx context.Context, nm *netmap.NetworkMap) []doctor.Finding {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok || !nm.SelfNode.Valid() || nm.SelfNode.Addresses().Len() == 0 {
+		return nil
+	}
+	from := netip.AddrPortFrom(nm.SelfNode.Addresses().At(0).Addr(), 0)
+	resolve := func(name string) error {
+		q, err := dnsQueryA(name)
+		if err != nil {
+			return err
+		}
+		resp, err := dm.Query(ctx, q, "udp", from)
+		if err != nil {
+			return err
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(resp)
+		if err != nil {
+			return err
+		}
+		if h.RCode != dnsmessage.RCodeSuccess {
+			return fmt.Errorf("%v", h.RCode)
+		}
+		if err := p.SkipAllQuestions(); err != nil {
+			return err
+		}
+		if as, err := p.AllAnswers(); err != nil {
+			return err
+		} else if len(as) == 0 {
+			return fmt.Errorf("no answers")
+		}
+		return nil
+	}
+
+	var fs []doctor.Finding
+	if self := nm.SelfNode.Name(); self != "" && nm.DNS.Proxied {
+		if err := resolve(self); err != nil {
+			fs = append(fs, doctor.Finding{
+				Severity: doctor.SeverityError,
+				Summary:  fmt.Sprintf("MagicDNS can't resolve this node's own name %q: %v", self, err),
+				Fix:      `run "tailscale bugreport" and contact support`,
+			})
+		}
+	}
+	if len(nm.DNS.Resolvers) > 0 {
+		// Queries for other names are forwarded to the tailnet's
+		// resolvers; check that they answer.
+		if err := resolve("controlplane.tailscale.com."); err != nil {
+			fs = append(fs, doctor.Finding{
+				Severity: doctor.SeverityError,
+				Summary:  fmt.Sprintf("the tailnet's DNS resolvers don't answer through MagicDNS: %v", err),
+				Fix:      "check that the resolvers in the admin console's DNS settings are reachable from this node",
+			})
+		}
+	}
+	return fs
+}
+
+// dnsQueryA returns a wire-encoded DNS query for the A record of name.
+func dnsQueryA(name string) ([]byte, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	bld := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+	if err := bld.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := bld.Question(dnsmessage.Question{Name: n, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return bld.Finish()
+}
+
+// netcheckFindings reports what netcheck report r says about our
+// connectivity to DERP and over UDP.
+func netcheckFindings(r *netcheck.Report) []doctor.Finding {
+	if r == nil {
+		return []doctor.Finding{{
+			Severity: doctor.SeverityWarning,
+			Summary:  "couldn't check connectivity to DERP relays",
+			Fix:      `run "tailscale netcheck" for details`,
+		}}
+	}
+	var fs []doctor.Finding
+	if len(r.RegionLatency) == 0 {
+		fs = append(fs, doctor.Finding{
+			Severity: doctor.SeverityError,
+			Summary:  "no DERP relay is reachable, so peers that can't be reached directly can't be reached at all",
+			Fix:      "allow outbound HTTPS (TCP port 443) and STUN (UDP port 3478) through your firewall",
+		})
+	} else if r.PreferredDERP == 0 {
+		fs = append(fs, doctor.Finding{
+			Severity: doctor.SeverityWarning,
+			Summary:  "no home DERP relay has been chosen",
+			Fix:      `run "tailscale netcheck" for details`,
+		})
+	}
+	if !r.UDP {
+		fs = append(fs, doctor.Finding{
+			Severity: doctor.SeverityWarning,
+			Summary:  "UDP appears to be blocked, so all traffic goes through DERP relays, which is slower",
+			Fix:      "allow outbound UDP through your firewall; see https://tailscale.com/kb/1082/firewall-ports",
+		})
+	}
+	if n := r.NAT; n.Mapping == netcheck.NATMappingEndpointDependent && (n.PortMapping == "" || n.DoubleNAT) {
+		f := doctor.Finding{
+			Severity: doctor.SeverityInfo,
+			Summary:  "this device is behind a hard (endpoint-dependent) NAT, so connections to peers behind hard NATs too are relayed through DERP",
+			Fix:      "enable UPnP, NAT-PMP or PCP on your router so that peers can connect directly",
+		}
+		switch {
+		case n.CGNAT:
+			f.Summary += ", and your ISP's carrier-grade NAT rules out port mapping"
+			f.Fix = "ask your ISP for a public IPv4 address, or use IPv6"
+		case n.DoubleNAT:
+			f.Summary += ", and a second NAT beyond your router rules out port mapping"
+			f.Fix = "put your router in bridge mode behind the other NAT, or forward a UDP port to this device on both"
+		}
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+// vpnInterfacePrefixes are prefixes of the names of network interfaces
+// that belong to other VPNs.
+var vpnInterfacePrefixes = []string{"tun", "tap", "utun", "wg", "ppp", "ipsec", "gpd", "cscotun", "nordlynx", "proton"}
+
+// vpnInterfaceDescs are substrings, in lower case, of the descriptions of
+// network interfaces that belong to other VPNs, for OSes like Windows
+// whose interface names say little.
+var vpnInterfaceDescs = []string{"wireguard", "tap-windows", "openvpn", "anyconnect", "fortinet", "pangp", "globalprotect", "wintun"}
+
+// conflictingVPNFindings reports whether the default route in st goes
+// through another VPN, which usually takes over routing and DNS in ways
+// that break Tailscale.
+func conflictingVPNFindings(st *netmon.State) []doctor.Finding {
+	if st == nil || st.DefaultRouteInterface == "" {
+		return nil
+	}
+	name := st.DefaultRouteInterface
+	if strings.HasPrefix(name, "tailscale") || name == "Tailscale" {
+		return nil
+	}
+	for _, pfx := range st.InterfaceIPs[name] {
+		if tsaddr.IsTailscaleIP(pfx.Addr()) {
+			return nil // using an exit node
+		}
+	}
+	isVPN := slices.ContainsFunc(vpnInterfacePrefixes, func(p string) bool { return strings.HasPrefix(name, p) })
+	if iface, ok := st.Interface[name]; ok && !isVPN {
+		desc := strings.ToLower(iface.Desc)
+		isVPN = slices.ContainsFunc(vpnInterfaceDescs, func(s string) bool { return strings.Contains(desc, s) })
+	}
+	if !isVPN {
+		return nil
+	}
+	return []doctor.Finding{{
+		Severity: doctor.SeverityWarning,
+		Summary:  fmt.Sprintf("the default route is through %q, which looks like another VPN; it may conflict with Tailscale's routes and DNS", name),
+		Fix:      "disconnect the other VPN, or configure it to exclude 100.64.0.0/10 and 100.100.100.100",
+	}}
+}
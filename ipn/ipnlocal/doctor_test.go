@@ -0,0 +1,124 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/net/netmon"
+	"tailscale.com/tailcfg"
+)
+
+// severities returns the severities of fs, in order.
+func severities(fs []doctor.Finding) []doctor.Severity {
+	var ret []doctor.Severity
+	for _, f := range fs {
+		ret = append(ret, f.Severity)
+	}
+	return ret
+}
+
+func TestKeyExpiryFindings(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		expiry time.Time
+		want   []doctor.Severity
+	}{
+		{"disabled", time.Time{}, nil},
+		{"far-off", now.Add(90 * 24 * time.Hour), nil},
+		{"soon", now.Add(2 * 24 * time.Hour), []doctor.Severity{doctor.SeverityWarning}},
+		{"expired", now.Add(-time.Hour), []doctor.Severity{doctor.SeverityError}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			self := (&tailcfg.Node{KeyExpiry: tt.expiry}).View()
+			if got := severities(keyExpiryFindings(self, now)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetcheckFindings(t *testing.T) {
+	tests := []struct {
+		name   string
+		report *netcheck.Report
+		want   []doctor.Severity
+	}{
+		{"no-report", nil, []doctor.Severity{doctor.SeverityWarning}},
+		{
+			name:   "healthy",
+			report: &netcheck.Report{UDP: true, PreferredDERP: 1, RegionLatency: map[int]time.Duration{1: time.Millisecond}},
+		},
+		{
+			name:   "udp-blocked",
+			report: &netcheck.Report{PreferredDERP: 1, RegionLatency: map[int]time.Duration{1: time.Millisecond}},
+			want:   []doctor.Severity{doctor.SeverityWarning},
+		},
+		{
+			name:   "derp-unreachable",
+			report: &netcheck.Report{},
+			want:   []doctor.Severity{doctor.SeverityError, doctor.SeverityWarning},
+		},
+		{
+			name: "hard-nat",
+			report: &netcheck.Report{UDP: true, PreferredDERP: 1, RegionLatency: map[int]time.Duration{1: time.Millisecond},
+				NAT: netcheck.NATInfo{Mapping: netcheck.NATMappingEndpointDependent}},
+			want: []doctor.Severity{doctor.SeverityInfo},
+		},
+		{
+			name: "hard-nat-with-port-mapping",
+			report: &netcheck.Report{UDP: true, PreferredDERP: 1, RegionLatency: map[int]time.Duration{1: time.Millisecond},
+				NAT: netcheck.NATInfo{Mapping: netcheck.NATMappingEndpointDependent, PortMapping: "pcp"}},
+		},
+		{
+			name: "hard-nat-cgnat",
+			report: &netcheck.Report{UDP: true, PreferredDERP: 1, RegionLatency: map[int]time.Duration{1: time.Millisecond},
+				NAT: netcheck.NATInfo{Mapping: netcheck.NATMappingEndpointDependent, PortMapping: "pcp", DoubleNAT: true, CGNAT: true}},
+			want: []doctor.Severity{doctor.SeverityInfo},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := severities(netcheckFindings(tt.report)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConflictingVPNFindings(t *testing.T) {
+	tests := []struct {
+		name  string
+		iface string
+		desc  string
+		ips   []netip.Prefix
+		want  bool
+	}{
+		{name: "ethernet", iface: "eth0"},
+		{name: "tailscale", iface: "tailscale0"},
+		{name: "wireguard", iface: "wg0", want: true},
+		{name: "openvpn", iface: "tun0", want: true},
+		{name: "windows-desc", iface: "Ethernet 3", desc: "TAP-Windows Adapter V9", want: true},
+		{name: "exit-node", iface: "utun4", ips: []netip.Prefix{netip.MustParsePrefix("100.101.102.103/32")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &netmon.State{
+				DefaultRouteInterface: tt.iface,
+				Interface:             map[string]netmon.Interface{tt.iface: {Desc: tt.desc}},
+				InterfaceIPs:          map[string][]netip.Prefix{tt.iface: tt.ips},
+			}
+			if got := len(conflictingVPNFindings(st)) > 0; got != tt.want {
+				t.Errorf("found conflicting VPN = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,9 +5,12 @@
 
 import (
 	"cmp"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"slices"
+	"strings"
 
 	"tailscale.com/drive"
 	"tailscale.com/ipn"
@@ -22,6 +25,10 @@
 	DriveLocalPort = 8080
 )
 
+// ErrDriveShareRestricted is returned when adding a Taildrive share outside
+// the directories that shares are restricted to by DriveRestrictShares.
+var ErrDriveShareRestricted = errors.New("tailscaled is sandboxed and can only share directories that were shared when it started; restart tailscaled to share others")
+
 // DriveSharingEnabled reports whether sharing to remote nodes via Taildrive is
 // enabled. This is currently based on checking for the drive:share node
 // attribute.
@@ -61,6 +68,35 @@ func (b *LocalBackend) DriveSetServerAddr(addr string) error {
 	return nil
 }
 
+// DriveRestrictShares restricts new Taildrive shares to dirs and the
+// directories below them. tailscaled calls it once it has sandboxed itself,
+// as it can't read other directories anymore. Renaming and removing shares
+// is still allowed.
+func (b *LocalBackend) DriveRestrictShares(dirs []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.driveSharesRestricted = true
+	b.driveShareDirs = slices.Clone(dirs)
+}
+
+// driveShareAllowedLocked reports whether a share of path is allowed by
+// DriveRestrictShares.
+//
+// b.mu must be held.
+func (b *LocalBackend) driveShareAllowedLocked(path string) bool {
+	if !b.driveSharesRestricted {
+		return true
+	}
+	path = filepath.Clean(path)
+	for _, dir := range b.driveShareDirs {
+		dir = filepath.Clean(dir)
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 // DriveSetShare adds the given share if no share with that name exists, or
 // replaces the existing share if one with the same name already exists. To
 // avoid potential incompatibilities across file systems, share names are
@@ -90,6 +126,9 @@ func (b *LocalBackend) driveSetShareLocked(share *drive.Share) (views.SliceView[
 	if !ok {
 		return existingShares, drive.ErrDriveNotEnabled
 	}
+	if !b.driveShareAllowedLocked(share.Path) {
+		return existingShares, fmt.Errorf("sharing %s: %w", share.Path, ErrDriveShareRestricted)
+	}
 
 	addedShare := false
 	var shares []*drive.Share
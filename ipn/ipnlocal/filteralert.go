@@ -0,0 +1,200 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/rate"
+	"tailscale.com/types/views"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/wgengine/filter"
+)
+
+// filterAlertRulesStateKey is the state key of the user's
+// FilterAlertRules. They're per machine, not per profile.
+const filterAlertRulesStateKey ipn.StateKey = "_filter-alert-rules"
+
+// maxRecentFilterAlerts is the number of FilterAlerts that we keep for
+// RecentFilterAlerts.
+const maxRecentFilterAlerts = 100
+
+var (
+	// metricFilterAlerts counts inbound connection attempts that
+	// matched a FilterAlertRule.
+	metricFilterAlerts = clientmetric.NewCounter("filter_alerts")
+
+	// metricFilterAlertsRateLimited counts the subset of
+	// metricFilterAlerts that weren't logged or reported because
+	// of rate limiting.
+	metricFilterAlertsRateLimited = clientmetric.NewCounter("filter_alerts_rate_limited")
+)
+
+// newFilterAlertLimiter returns the limiter for how often a LocalBackend
+// reports FilterAlerts, so a peer hammering on a port can't flood the log
+// and the IPN bus.
+func newFilterAlertLimiter() *rate.Limiter {
+	return rate.NewLimiter(rate.Every(time.Second), 10)
+}
+
+// restoreFilterAlertRules loads the FilterAlertRules saved by
+// SetFilterAlertRules, if any.
+func (b *LocalBackend) restoreFilterAlertRules() {
+	bs, err := b.store.ReadState(filterAlertRulesStateKey)
+	if err != nil {
+		if err != ipn.ErrStateNotExist {
+			b.logf("reading filter alert rules: %v", err)
+		}
+		return
+	}
+	var rules []ipn.FilterAlertRule
+	if err := json.Unmarshal(bs, &rules); err != nil {
+		b.logf("ignoring invalid filter alert rules: %v", err)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.filterAlertRules = rules
+}
+
+// FilterAlertRules returns the user's FilterAlertRules.
+func (b *LocalBackend) FilterAlertRules() []ipn.FilterAlertRule {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.filterAlertRules)
+}
+
+// SetFilterAlertRules replaces the user's FilterAlertRules and saves them
+// for future runs.
+func (b *LocalBackend) SetFilterAlertRules(rules []ipn.FilterAlertRule) error {
+	for _, r := range rules {
+		if err := validateFilterAlertRule(r); err != nil {
+			return err
+		}
+	}
+	bs, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	if err := ipn.WriteState(b.store, filterAlertRulesStateKey, bs); err != nil {
+		return fmt.Errorf("saving filter alert rules: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.filterAlertRules = slices.Clone(rules)
+	b.updateFilterLocked(b.netMap, b.pm.CurrentPrefs())
+	return nil
+}
+
+// validateFilterAlertRule reports whether r is well formed.
+func validateFilterAlertRule(r ipn.FilterAlertRule) error {
+	if r.Name == "" {
+		return errors.New("filter alert rule has no name")
+	}
+	if len(r.SrcIPs) == 0 || len(r.DstPorts) == 0 {
+		return fmt.Errorf("filter alert rule %q: SrcIPs and DstPorts must be non-empty", r.Name)
+	}
+	fr := tailcfg.FilterRule{DstPorts: r.DstPorts, IPProto: r.IPProto}
+	for _, src := range r.SrcIPs {
+		if !strings.HasPrefix(src, "tag:") {
+			fr.SrcIPs = append(fr.SrcIPs, src)
+		}
+	}
+	if _, err := filter.MatchesFromFilterRules([]tailcfg.FilterRule{fr}); err != nil {
+		return fmt.Errorf("filter alert rule %q: %w", r.Name, err)
+	}
+	return nil
+}
+
+// filterAlertRulesLocked returns the user's FilterAlertRules for the
+// packet filter, with tags resolved to the addresses of the peers that
+// have them.
+//
+// b.mu must be held.
+func (b *LocalBackend) filterAlertRulesLocked() []filter.AlertRule {
+	var ret []filter.AlertRule
+	for _, r := range b.filterAlertRules {
+		fr := tailcfg.FilterRule{DstPorts: r.DstPorts, IPProto: r.IPProto}
+		for _, src := range r.SrcIPs {
+			if !strings.HasPrefix(src, "tag:") {
+				fr.SrcIPs = append(fr.SrcIPs, src)
+				continue
+			}
+			for _, p := range b.peers {
+				if !views.SliceContains(p.Tags(), src) {
+					continue
+				}
+				for i := range p.Addresses().Len() {
+					fr.SrcIPs = append(fr.SrcIPs, p.Addresses().At(i).String())
+				}
+			}
+		}
+		if len(fr.SrcIPs) == 0 {
+			continue
+		}
+		// Sort so that the filter isn't rebuilt just because of
+		// the order that b.peers iterates in.
+		slices.Sort(fr.SrcIPs)
+		ms, err := filter.MatchesFromFilterRules([]tailcfg.FilterRule{fr})
+		if err != nil {
+			b.logf("filter alert rule %q: %v", r.Name, err)
+			continue
+		}
+		ret = append(ret, filter.AlertRule{Name: r.Name, Matches: ms})
+	}
+	return ret
+}
+
+// onFilterAlert is called by the packet filter when an inbound connection
+// attempt matches a FilterAlertRule. It's on the packet processing path,
+// so it hands off the rest of the work.
+func (b *LocalBackend) onFilterAlert(a filter.Alert) {
+	metricFilterAlerts.Add(1)
+	if !b.filterAlertLimiter.Allow() {
+		metricFilterAlertsRateLimited.Add(1)
+		return
+	}
+	go b.noteFilterAlert(ipn.FilterAlert{
+		Rule:  a.Rule,
+		Time:  b.clock.Now(),
+		Proto: a.Proto.String(),
+		Src:   a.Src,
+		Dst:   a.Dst,
+	})
+}
+
+// noteFilterAlert logs fa, records it for RecentFilterAlerts, and sends
+// it to IPN bus watchers.
+func (b *LocalBackend) noteFilterAlert(fa ipn.FilterAlert) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if nid, ok := b.nodeByAddr[fa.Src.Addr()]; ok {
+		if p, ok := b.peers[nid]; ok {
+			fa.SrcNode = p.Name()
+		}
+	}
+	b.logf("filter alert %q: %s %v (%s) -> %v", fa.Rule, fa.Proto, fa.Src, fa.SrcNode, fa.Dst)
+	b.recentFilterAlerts = append(b.recentFilterAlerts, fa)
+	if n := len(b.recentFilterAlerts) - maxRecentFilterAlerts; n > 0 {
+		b.recentFilterAlerts = slices.Delete(b.recentFilterAlerts, 0, n)
+	}
+	b.sendLocked(ipn.Notify{FilterAlert: &fa})
+}
+
+// RecentFilterAlerts returns the most recent inbound connection attempts
+// that matched a FilterAlertRule, oldest first. Attempts that were rate
+// limited aren't included.
+func (b *LocalBackend) RecentFilterAlerts() []ipn.FilterAlert {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.recentFilterAlerts)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+func TestSetFilterAlertRules(t *testing.T) {
+	b := newTestLocalBackend(t)
+
+	for _, bad := range []ipn.FilterAlertRule{
+		{SrcIPs: []string{"*"}, DstPorts: []tailcfg.NetPortRange{{IP: "*", Ports: tailcfg.PortRangeAny}}},
+		{Name: "no-src", DstPorts: []tailcfg.NetPortRange{{IP: "*", Ports: tailcfg.PortRangeAny}}},
+		{Name: "bad-src", SrcIPs: []string{"bogus"}, DstPorts: []tailcfg.NetPortRange{{IP: "*", Ports: tailcfg.PortRangeAny}}},
+	} {
+		if err := b.SetFilterAlertRules([]ipn.FilterAlertRule{bad}); err == nil {
+			t.Errorf("SetFilterAlertRules(%+v) succeeded; want error", bad)
+		}
+	}
+
+	b.mu.Lock()
+	b.peers = map[tailcfg.NodeID]tailcfg.NodeView{
+		1: (&tailcfg.Node{
+			ID:        1,
+			Tags:      []string{"tag:kiosk"},
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		}).View(),
+		2: (&tailcfg.Node{
+			ID:        2,
+			Tags:      []string{"tag:admin"},
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+		}).View(),
+	}
+	b.mu.Unlock()
+
+	rdp := []tailcfg.NetPortRange{{IP: "*", Ports: tailcfg.PortRange{First: 3389, Last: 3389}}}
+	rules := []ipn.FilterAlertRule{
+		{Name: "rdp", SrcIPs: []string{"tag:kiosk", "10.0.0.0/8"}, DstPorts: rdp},
+		{Name: "no-such-tag", SrcIPs: []string{"tag:nope"}, DstPorts: rdp},
+	}
+	if err := b.SetFilterAlertRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.FilterAlertRules(); !reflect.DeepEqual(got, rules) {
+		t.Errorf("FilterAlertRules = %+v; want %+v", got, rules)
+	}
+
+	bs, err := b.store.ReadState(filterAlertRulesStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []ipn.FilterAlertRule
+	if err := json.Unmarshal(bs, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, rules) {
+		t.Errorf("saved rules = %+v; want %+v", saved, rules)
+	}
+
+	b.mu.Lock()
+	got := b.filterAlertRulesLocked()
+	b.mu.Unlock()
+	if len(got) != 1 || got[0].Name != "rdp" {
+		t.Fatalf("filterAlertRulesLocked = %+v; want just rdp", got)
+	}
+	wantSrcs := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("100.64.0.1/32"),
+	}
+	if srcs := got[0].Matches[0].Srcs; !reflect.DeepEqual(srcs, wantSrcs) {
+		t.Errorf("Srcs = %v; want %v", srcs, wantSrcs)
+	}
+}
+
+func TestNoteFilterAlert(t *testing.T) {
+	b := newTestLocalBackend(t)
+	src := netip.MustParseAddr("100.64.0.1")
+	b.mu.Lock()
+	b.peers = map[tailcfg.NodeID]tailcfg.NodeView{
+		1: (&tailcfg.Node{ID: 1, Name: "kiosk.example.ts.net."}).View(),
+	}
+	b.nodeByAddr = map[netip.Addr]tailcfg.NodeID{src: 1}
+	b.mu.Unlock()
+
+	for i := range maxRecentFilterAlerts + 5 {
+		b.noteFilterAlert(ipn.FilterAlert{
+			Rule: "rdp",
+			Src:  netip.AddrPortFrom(src, uint16(i)),
+		})
+	}
+	got := b.RecentFilterAlerts()
+	if len(got) != maxRecentFilterAlerts {
+		t.Fatalf("got %d recent alerts; want %d", len(got), maxRecentFilterAlerts)
+	}
+	if port := got[0].Src.Port(); port != 5 {
+		t.Errorf("oldest alert has port %d; want 5", port)
+	}
+	if got[0].SrcNode != "kiosk.example.ts.net." {
+		t.Errorf("SrcNode = %q; want kiosk.example.ts.net.", got[0].SrcNode)
+	}
+}
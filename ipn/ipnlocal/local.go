@@ -41,9 +41,6 @@
 	"tailscale.com/control/controlclient"
 	"tailscale.com/control/controlknobs"
 	"tailscale.com/doctor"
-	"tailscale.com/doctor/ethtool"
-	"tailscale.com/doctor/permissions"
-	"tailscale.com/doctor/routetable"
 	"tailscale.com/drive"
 	"tailscale.com/envknob"
 	"tailscale.com/health"
@@ -64,16 +61,19 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/portmapper"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/paths"
 	"tailscale.com/portlist"
+	"tailscale.com/posture"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
 	"tailscale.com/taildrop"
 	"tailscale.com/tka"
 	"tailscale.com/tsd"
 	"tailscale.com/tstime"
+	"tailscale.com/tstime/rate"
 	"tailscale.com/types/appctype"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/empty"
@@ -185,6 +185,8 @@ type LocalBackend struct {
 	unregisterHealthWatch func()
 	portpoll              *portlist.Poller // may be nil
 	portpollOnce          sync.Once        // guards starting readPoller
+	peerWatchOnce         sync.Once        // guards starting watchImportantPeers
+	authKeyWatchOnce      sync.Once        // guards starting watchAuthKeyFile
 	gotPortPollRes        chan struct{}    // closed upon first readPoller result
 	varRoot               string           // or empty if SetVarRoot never called
 	logFlushFunc          func()           // or nil if SetLogFlusher wasn't called
@@ -230,6 +232,9 @@ type LocalBackend struct {
 	ccGen          clientGen          // function for producing controlclient; lazily populated
 	sshServer      SSHServer          // or nil, initialized lazily.
 	appConnector   *appc.AppConnector // or nil, initialized when configured.
+	// postureRechecker keeps the device posture attestation fresh while
+	// posture checking is on, or is nil.
+	postureRechecker *posture.Rechecker
 	// notifyCancel cancels notifications to the current SetNotifyCallback.
 	notifyCancel   context.CancelFunc
 	cc             controlclient.Client
@@ -253,8 +258,9 @@ type LocalBackend struct {
 	// be given out to callers, but the map itself must not escape the LocalBackend.
 	peers            map[tailcfg.NodeID]tailcfg.NodeView
 	nodeByAddr       map[netip.Addr]tailcfg.NodeID
-	nmExpiryTimer    tstime.TimerController // for updating netMap on node expiry; can be nil
-	activeLogin      string                 // last logged LoginName from netMap
+	primaryRouters   map[netip.Prefix]tailcfg.StableNodeID // primary router of each peer subnet route
+	nmExpiryTimer    tstime.TimerController                // for updating netMap on node expiry; can be nil
+	activeLogin      string                                // last logged LoginName from netMap
 	engineStatus     ipn.EngineStatus
 	endpoints        []tailcfg.Endpoint
 	blocked          bool
@@ -325,10 +331,23 @@ type LocalBackend struct {
 	// Last ClientVersion received in MapResponse, guarded by mu.
 	lastClientVersion *tailcfg.ClientVersion
 
+	// filterAlertRules are the user's rules for inbound connection
+	// attempts to drop and report, and recentFilterAlerts are the
+	// attempts most recently reported, oldest first. Both are guarded
+	// by mu. filterAlertLimiter limits how often they're reported.
+	filterAlertRules   []ipn.FilterAlertRule
+	recentFilterAlerts []ipn.FilterAlert
+	filterAlertLimiter *rate.Limiter
+
 	// lastNotifiedDriveShares keeps track of the last set of shares that we
 	// notified about.
 	lastNotifiedDriveShares atomic.Pointer[views.SliceView[*drive.Share, drive.ShareView]]
 
+	// driveShareDirs, if driveSharesRestricted, are the only directories
+	// that new Taildrive shares may be in. Both are guarded by mu.
+	driveSharesRestricted bool
+	driveShareDirs        []string
+
 	// outgoingFiles keeps track of Taildrop outgoing files keyed to their OutgoingFile.ID
 	outgoingFiles map[string]*ipn.OutgoingFile
 
@@ -427,6 +446,7 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 		clock:               clock,
 		selfUpdateProgress:  make([]ipnstate.UpdateProgress, 0),
 		lastSelfUpdateState: ipnstate.UpdateFinished,
+		filterAlertLimiter:  newFilterAlertLimiter(),
 	}
 	mConn.SetNetInfoCallback(b.setNetInfo)
 
@@ -474,6 +494,9 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 		}
 	}
 
+	b.restorePortMapping()
+	b.restoreFilterAlertRules()
+
 	// initialize Taildrive shares from saved state
 	fs, ok := b.sys.DriveForRemote.GetOK()
 	if ok {
@@ -705,6 +728,8 @@ func (b *LocalBackend) Shutdown() {
 		b.sshServer.Shutdown()
 		b.sshServer = nil
 	}
+	postureRechecker := b.postureRechecker
+	b.postureRechecker = nil
 	b.closePeerAPIListenersLocked()
 	if b.debugSink != nil {
 		b.e.InstallCaptureHook(nil)
@@ -717,6 +742,10 @@ func (b *LocalBackend) Shutdown() {
 	b.mu.Unlock()
 	b.webClientShutdown()
 
+	if postureRechecker != nil {
+		postureRechecker.Close()
+	}
+
 	if b.sockstatLogger != nil {
 		b.sockstatLogger.Shutdown()
 	}
@@ -810,6 +839,9 @@ func (b *LocalBackend) UpdateStatus(sb *ipnstate.StatusBuilder) {
 				if !prefs.RouteAll() && b.netMap.AnyPeersAdvertiseRoutes() {
 					s.Health = append(s.Health, healthmsg.WarnAcceptRoutesOff)
 				}
+				for _, rc := range b.routeConflictsLocked() {
+					s.RouteConflicts = append(s.RouteConflicts, rc.status())
+				}
 				if !prefs.ExitNodeID().IsZero() {
 					if exitPeer, ok := b.netMap.PeerWithStableID(prefs.ExitNodeID()); ok {
 						online := false
@@ -1621,6 +1653,14 @@ func (b *LocalBackend) DisablePortMapperForTest() {
 	b.portpoll = nil
 }
 
+// SetNetMapForTest sets the current network map, for tests in other
+// packages.
+func (b *LocalBackend) SetNetMapForTest(nm *netmap.NetworkMap) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.setNetMapLocked(nm)
+}
+
 // PeersForTest returns all the current peers, sorted by Node.ID,
 // for integration tests in another repo.
 func (b *LocalBackend) PeersForTest() []tailcfg.NodeView {
@@ -1672,14 +1712,19 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 			return err
 		}
 	}
+	var authKeyFunc func() string
 	if b.state != ipn.Running && b.conf != nil && b.conf.Parsed.AuthKey != nil && opts.AuthKey == "" {
 		v := *b.conf.Parsed.AuthKey
-		if filename, ok := strings.CutPrefix(v, "file:"); ok {
-			b, err := os.ReadFile(filename)
+		if filename, ok := b.authKeyFileLocked(); ok {
+			key, err := readAuthKeyFile(filename)
 			if err != nil {
 				return fmt.Errorf("error reading config file authKey: %w", err)
 			}
-			v = strings.TrimSpace(string(b))
+			v = key
+			authKeyFunc = authKeyFromFile(filename)
+			b.authKeyWatchOnce.Do(func() {
+				go b.watchAuthKeyFile()
+			})
 		}
 		opts.AuthKey = v
 	}
@@ -1748,6 +1793,12 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	}
 	b.updateFilterLocked(nil, ipn.PrefsView{})
 
+	if specs := parseImportantPeers(importantPeers()); len(specs) > 0 {
+		b.peerWatchOnce.Do(func() {
+			go b.watchImportantPeers(specs)
+		})
+	}
+
 	if b.portpoll != nil {
 		b.portpollOnce.Do(func() {
 			go b.readPoller()
@@ -1788,6 +1839,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 		Persist:                    *persistv,
 		ServerURL:                  serverURL,
 		AuthKey:                    opts.AuthKey,
+		AuthKeyFunc:                authKeyFunc,
 		Hostinfo:                   hostinfo,
 		HTTPTestClient:             httpTestClient,
 		DiscoPublicKey:             discoPublic,
@@ -1943,6 +1995,7 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 	if haveNetmap && netMap.SSHPolicy != nil {
 		sshPol = *netMap.SSHPolicy
 	}
+	alertRules := b.filterAlertRulesLocked()
 
 	changed := deephash.Update(&b.filterHash, &struct {
 		HaveNetmap  bool
@@ -1952,7 +2005,8 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 		LogNets     []netipx.IPRange
 		ShieldsUp   bool
 		SSHPolicy   tailcfg.SSHPolicy
-	}{haveNetmap, addrs, packetFilter, localNets.Ranges(), logNets.Ranges(), shieldsUp, sshPol})
+		AlertRules  []filter.AlertRule
+	}{haveNetmap, addrs, packetFilter, localNets.Ranges(), logNets.Ranges(), shieldsUp, sshPol, alertRules})
 	if !changed {
 		return
 	}
@@ -1970,8 +2024,10 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 		b.logf("[v1] netmap packet filter: (shields up)")
 		b.setFilter(filter.NewShieldsUpFilter(localNets, logNets, oldFilter, b.logf))
 	} else {
-		b.logf("[v1] netmap packet filter: %v filters", len(packetFilter))
-		b.setFilter(filter.New(packetFilter, localNets, logNets, oldFilter, b.logf))
+		b.logf("[v1] netmap packet filter: %v filters, %v alert rules", len(packetFilter), len(alertRules))
+		f := filter.New(packetFilter, localNets, logNets, oldFilter, b.logf)
+		f.SetAlertRules(alertRules, b.onFilterAlert)
+		b.setFilter(f)
 	}
 	// The filter for a jailed node is the exact same as a ShieldsUp filter.
 	oldJailedFilter := b.e.GetJailedFilter()
@@ -3513,6 +3569,17 @@ func (b *LocalBackend) doSetHostinfoFilterServices() {
 	cc.SetHostinfo(&hi)
 }
 
+// Features reports which optional features this build of tailscaled
+// supports or is running with, for version reports.
+func (b *LocalBackend) Features() map[string]bool {
+	return map[string]bool{
+		"ssh":              newSSHServer != nil,
+		"webclient":        webClientSupported,
+		"netstack":         b.sys.IsNetstack(),
+		"netstack-subnets": b.sys.IsNetstackRouter(),
+	}
+}
+
 // NetMap returns the latest cached network map received from
 // controlclient, or nil if no network map was received yet.
 func (b *LocalBackend) NetMap() *netmap.NetworkMap {
@@ -3623,6 +3690,9 @@ func (b *LocalBackend) authReconfig() {
 	dcfg := dnsConfigForNetmap(nm, b.peers, prefs, b.logf, version.OS())
 	// If the current node is an app connector, ensure the app connector machine is started
 	b.reconfigAppConnectorLocked(nm, prefs)
+	if nm != nil {
+		b.notePrimaryRoutersLocked(primaryRouters(b.peersLocked()))
+	}
 	b.mu.Unlock()
 
 	if blocked {
@@ -3808,6 +3878,8 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 	if !prefs.CorpDNS() {
 		return dcfg
 	}
+	dcfg.Override = prefs.DNSOverride()
+	dcfg.NoDoHUpgrade = prefs.NoDoHUpgrade()
 
 	for _, dom := range nm.DNS.Domains {
 		fqdn, err := dnsname.ToFQDN(dom)
@@ -4313,6 +4385,13 @@ func (b *LocalBackend) applyPrefsToHostinfoLocked(hi *tailcfg.Hostinfo, prefs ip
 	// records that have ingress enabled but are not actually being used.
 	hi.WireIngress = b.wantIngressLocked()
 	hi.AppConnector.Set(prefs.AppConnector().Advertise)
+
+	if prefs.HideExperimentalFeatures() {
+		hi.ExperimentalFeatures = nil
+	} else {
+		hi.ExperimentalFeatures = hostinfo.ExperimentalFeatures()
+	}
+	hi.PostureDigest = b.updatePostureRecheckerLocked(prefs)
 }
 
 // enterState transitions the backend into newState, updating internal
@@ -5487,6 +5566,27 @@ func (b *LocalBackend) CheckIPForwarding() error {
 	return warn
 }
 
+// FixIPForwarding sets and persists the sysctls that forwarding traffic for
+// the routes that the current prefs advertise needs, like
+// CheckIPForwarding checks for, and returns the changes that it made.
+func (b *LocalBackend) FixIPForwarding() ([]netutil.SysctlFix, error) {
+	if b.sys.IsNetstackRouter() {
+		return nil, nil
+	}
+	routes := b.Prefs().AdvertiseRoutes().AsSlice()
+	fixes, err := netutil.ForwardingSysctlFixes(routes, b.sys.NetMon.Get().InterfaceState())
+	if err != nil {
+		return nil, err
+	}
+	if err := netutil.ApplySysctlFixes(fixes); err != nil {
+		return nil, err
+	}
+	for _, f := range fixes {
+		b.logf("FixIPForwarding: set %v", f)
+	}
+	return fixes, nil
+}
+
 // CheckUDPGROForwarding checks if the machine is optimally configured to
 // forward UDP packets between the default route and Tailscale TUN interfaces.
 // It returns an error if the check fails or if suboptimal configuration is
@@ -5684,6 +5784,17 @@ func (b *LocalBackend) DebugReSTUN() error {
 	return nil
 }
 
+// RestartEngine restarts the WireGuard engine, re-creating its TUN device
+// where supported, and re-applies the current configuration to it, without
+// restarting tailscaled or dropping LocalAPI clients.
+func (b *LocalBackend) RestartEngine() error {
+	if err := b.e.Restart(); err != nil {
+		return err
+	}
+	b.authReconfig()
+	return nil
+}
+
 // ControlKnobs returns the node's control knobs.
 func (b *LocalBackend) ControlKnobs() *controlknobs.Knobs {
 	return b.sys.ControlKnobs()
@@ -5855,40 +5966,7 @@ func (b *LocalBackend) Doctor(ctx context.Context, logf logger.Logf) {
 	// not block for too long but slow enough that we can upload all lines.
 	logf = logger.SlowLoggerWithClock(ctx, logf, 20*time.Millisecond, 60, b.clock.Now)
 
-	var checks []doctor.Check
-	checks = append(checks,
-		permissions.Check{},
-		routetable.Check{},
-		ethtool.Check{},
-	)
-
-	// Print a log message if any of the global DNS resolvers are Tailscale
-	// IPs; this can interfere with our ability to connect to the Tailscale
-	// controlplane.
-	checks = append(checks, doctor.CheckFunc("dns-resolvers", func(_ context.Context, logf logger.Logf) error {
-		b.mu.Lock()
-		nm := b.netMap
-		b.mu.Unlock()
-		if nm == nil {
-			return nil
-		}
-
-		for i, resolver := range nm.DNS.Resolvers {
-			ipp, ok := resolver.IPPort()
-			if ok && tsaddr.IsTailscaleIP(ipp.Addr()) {
-				logf("resolver %d is a Tailscale address: %v", i, resolver)
-			}
-		}
-		for i, resolver := range nm.DNS.FallbackResolvers {
-			ipp, ok := resolver.IPPort()
-			if ok && tsaddr.IsTailscaleIP(ipp.Addr()) {
-				logf("fallback resolver %d is a Tailscale address: %v", i, resolver)
-			}
-		}
-		return nil
-	}))
-
-	// TODO(andrew): more
+	checks := b.doctorChecks()
 
 	numChecks := len(checks)
 	checks = append(checks, doctor.CheckFunc("numchecks", func(_ context.Context, log logger.Logf) error {
@@ -6360,6 +6438,39 @@ func (b *LocalBackend) readRouteInfoLocked() (*appc.RouteInfo, error) {
 	return ri, nil
 }
 
+// portMappingStateKey is the state key of the port mapping most recently
+// obtained from the local network's gateway. It's per machine, not per
+// profile, like the mapping itself.
+const portMappingStateKey ipn.StateKey = "_portmap"
+
+// restorePortMapping gives magicsock's portmapper the port mapping saved
+// by the previous run, if any, so that it asks the gateway for the same
+// external port again and peers' cached endpoints for this node stay
+// valid, and saves new mappings as they're obtained.
+func (b *LocalBackend) restorePortMapping() {
+	var saved portmapper.SavedMapping
+	bs, err := b.store.ReadState(portMappingStateKey)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(bs, &saved); err != nil {
+			b.logf("ignoring invalid saved port mapping: %v", err)
+			saved = portmapper.SavedMapping{}
+		}
+	case err != ipn.ErrStateNotExist:
+		b.logf("reading saved port mapping: %v", err)
+	}
+	b.MagicConn().SetPortMappingStore(saved, func(sm portmapper.SavedMapping) {
+		bs, err := json.Marshal(sm)
+		if err != nil {
+			b.logf("saving port mapping: %v", err)
+			return
+		}
+		if err := b.store.WriteState(portMappingStateKey, bs); err != nil {
+			b.logf("saving port mapping: %v", err)
+		}
+	})
+}
+
 // seamlessRenewalEnabled reports whether seamless key renewals are enabled
 // (i.e. we saw our self node with the SeamlessKeyRenewal attr in a netmap).
 // This enables beta functionality of renewing node keys without breaking
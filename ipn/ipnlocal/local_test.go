@@ -31,6 +31,7 @@
 	"tailscale.com/drive"
 	"tailscale.com/drive/driveimpl"
 	"tailscale.com/health"
+	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/netcheck"
@@ -2413,13 +2414,14 @@ func TestTCPHandlerForDst(t *testing.T) {
 
 func TestDriveManageShares(t *testing.T) {
 	tests := []struct {
-		name     string
-		disabled bool
-		existing []*drive.Share
-		add      *drive.Share
-		remove   string
-		rename   [2]string
-		expect   any
+		name       string
+		disabled   bool
+		restrictTo []string // if non-nil, passed to DriveRestrictShares
+		existing   []*drive.Share
+		add        *drive.Share
+		remove     string
+		rename     [2]string
+		expect     any
 	}{
 		{
 			name: "append",
@@ -2483,6 +2485,31 @@ func TestDriveManageShares(t *testing.T) {
 			add:      &drive.Share{Name: "a"},
 			expect:   drive.ErrDriveNotEnabled,
 		},
+		{
+			name:       "add_restricted",
+			restrictTo: []string{"/srv/a"},
+			add:        &drive.Share{Name: "b", Path: "/srv/ab"},
+			expect:     ErrDriveShareRestricted,
+		},
+		{
+			name:       "add_restricted_subdir",
+			restrictTo: []string{"/srv/a"},
+			add:        &drive.Share{Name: "b", Path: "/srv/a/b"},
+			expect: []*drive.Share{
+				{Name: "b", Path: "/srv/a/b"},
+			},
+		},
+		{
+			name:       "rename_restricted",
+			restrictTo: []string{},
+			existing: []*drive.Share{
+				{Name: "a", Path: "/srv/a"},
+			},
+			rename: [2]string{"a", "b"},
+			expect: []*drive.Share{
+				{Name: "b", Path: "/srv/a"},
+			},
+		},
 		{
 			name: "remove",
 			existing: []*drive.Share{
@@ -2574,6 +2601,9 @@ func TestDriveManageShares(t *testing.T) {
 				b.sys.Set(driveimpl.NewFileSystemForRemote(b.logf))
 			}
 			b.mu.Unlock()
+			if tt.restrictTo != nil {
+				b.DriveRestrictShares(tt.restrictTo)
+			}
 
 			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 			t.Cleanup(cancel)
@@ -3887,3 +3917,25 @@ func TestReadWriteRouteInfo(t *testing.T) {
 		t.Fatalf("read prof2 routeInfo wildcards:  want %v, got %v", ri2.Wildcards, readRi.Wildcards)
 	}
 }
+
+func TestApplyPrefsToHostinfoExperimentalFeatures(t *testing.T) {
+	hostinfo.SetExperimentalFeature("test-feature", true)
+	defer hostinfo.SetExperimentalFeature("test-feature", false)
+
+	b := newTestLocalBackend(t)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hi := new(tailcfg.Hostinfo)
+	b.applyPrefsToHostinfoLocked(hi, ipn.NewPrefs().View())
+	if !slices.Contains(hi.ExperimentalFeatures, "test-feature") {
+		t.Errorf("ExperimentalFeatures = %q; want test-feature", hi.ExperimentalFeatures)
+	}
+
+	p := ipn.NewPrefs()
+	p.HideExperimentalFeatures = true
+	b.applyPrefsToHostinfoLocked(hi, p.View())
+	if hi.ExperimentalFeatures != nil {
+		t.Errorf("ExperimentalFeatures = %q with HideExperimentalFeatures; want nil", hi.ExperimentalFeatures)
+	}
+}
@@ -525,6 +525,8 @@ func (h *peerAPIHandler) handleServeSockStats(w http.ResponseWriter, r *http.Req
 	fmt.Fprintln(w, "<th>Label</th>")
 	fmt.Fprintln(w, "<th>Tx</th>")
 	fmt.Fprintln(w, "<th>Rx</th>")
+	fmt.Fprintln(w, "<th>Tx calls</th>")
+	fmt.Fprintln(w, "<th>Rx calls</th>")
 	for _, iface := range interfaceStats.Interfaces {
 		fmt.Fprintf(w, "<th>Tx (%s)</th>", html.EscapeString(iface))
 		fmt.Fprintf(w, "<th>Rx (%s)</th>", html.EscapeString(iface))
@@ -543,6 +545,8 @@ func (h *peerAPIHandler) handleServeSockStats(w http.ResponseWriter, r *http.Req
 
 	txTotal := uint64(0)
 	rxTotal := uint64(0)
+	txCallsTotal := uint64(0)
+	rxCallsTotal := uint64(0)
 	txTotalByInterface := map[string]uint64{}
 	rxTotalByInterface := map[string]uint64{}
 
@@ -552,9 +556,13 @@ func (h *peerAPIHandler) handleServeSockStats(w http.ResponseWriter, r *http.Req
 		fmt.Fprintf(w, "<td>%s</td>", html.EscapeString(label.String()))
 		fmt.Fprintf(w, "<td align=right>%d</td>", stat.TxBytes)
 		fmt.Fprintf(w, "<td align=right>%d</td>", stat.RxBytes)
+		fmt.Fprintf(w, "<td align=right>%d</td>", stat.TxCalls)
+		fmt.Fprintf(w, "<td align=right>%d</td>", stat.RxCalls)
 
 		txTotal += stat.TxBytes
 		rxTotal += stat.RxBytes
+		txCallsTotal += stat.TxCalls
+		rxCallsTotal += stat.RxCalls
 
 		if interfaceStat, ok := interfaceStats.Stats[label]; ok {
 			for _, iface := range interfaceStats.Interfaces {
@@ -583,6 +591,8 @@ func (h *peerAPIHandler) handleServeSockStats(w http.ResponseWriter, r *http.Req
 	fmt.Fprintln(w, "<th>Total</th>")
 	fmt.Fprintf(w, "<th>%d</th>", txTotal)
 	fmt.Fprintf(w, "<th>%d</th>", rxTotal)
+	fmt.Fprintf(w, "<th>%d</th>", txCallsTotal)
+	fmt.Fprintf(w, "<th>%d</th>", rxCallsTotal)
 	for _, iface := range interfaceStats.Interfaces {
 		fmt.Fprintf(w, "<th>%d</th>", txTotalByInterface[iface])
 		fmt.Fprintf(w, "<th>%d</th>", rxTotalByInterface[iface])
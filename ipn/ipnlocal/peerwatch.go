@@ -0,0 +1,157 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/health"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+var (
+	// importantPeers is a comma-separated list of peers, by MagicDNS
+	// name (with or without the tailnet suffix), hostname or Tailscale
+	// IP, whose reachability tailscaled should watch.
+	importantPeers = envknob.RegisterString("TS_IMPORTANT_PEERS")
+
+	// importantPeerTimeout is how long an important peer must be
+	// unreachable before a health warning is raised. Zero means
+	// defaultImportantPeerTimeout.
+	importantPeerTimeout = envknob.RegisterDuration("TS_IMPORTANT_PEER_TIMEOUT")
+)
+
+const (
+	defaultImportantPeerTimeout = 5 * time.Minute
+	importantPeerPingInterval   = 30 * time.Second
+)
+
+var warnImportantPeerUnreachable = health.NewWarnable()
+
+// watchImportantPeers disco-pings each peer listed in TS_IMPORTANT_PEERS
+// every importantPeerPingInterval and raises a health warning while any of
+// them hasn't answered for longer than the configured timeout. It runs
+// until b.ctx is done.
+func (b *LocalBackend) watchImportantPeers(specs []string) {
+	timeout := importantPeerTimeout()
+	if timeout <= 0 {
+		timeout = defaultImportantPeerTimeout
+	}
+	b.logf("watching reachability of important peers %q (timeout %v)", specs, timeout)
+
+	// lastPong is the last time each peer answered a ping. Peers start
+	// out as having answered when the watch began, so a warning isn't
+	// raised before they've had a chance to.
+	lastPong := map[key.NodePublic]time.Time{}
+	start := b.clock.Now()
+
+	ticker, tickerChannel := b.clock.NewTicker(importantPeerPingInterval)
+	defer ticker.Stop()
+	for {
+		st := b.Status()
+		for _, ps := range matchImportantPeers(specs, st) {
+			if len(ps.TailscaleIPs) == 0 {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(b.ctx, 10*time.Second)
+			pr, err := b.Ping(ctx, ps.TailscaleIPs[0], tailcfg.PingDisco, 0)
+			cancel()
+			if err == nil && pr.Err == "" {
+				lastPong[ps.PublicKey] = b.clock.Now()
+			}
+		}
+		b.health.SetWarnable(warnImportantPeerUnreachable, unreachableImportantPeers(specs, st, lastPong, start, b.clock.Now(), timeout))
+
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-tickerChannel:
+		}
+	}
+}
+
+// parseImportantPeers splits the TS_IMPORTANT_PEERS value v into its
+// non-empty elements.
+func parseImportantPeers(v string) []string {
+	var specs []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			specs = append(specs, s)
+		}
+	}
+	return specs
+}
+
+// importantPeerMatches reports whether spec (a MagicDNS name, hostname
+// or Tailscale IP) refers to ps.
+func importantPeerMatches(spec string, ps *ipnstate.PeerStatus) bool {
+	if ip, err := netip.ParseAddr(spec); err == nil {
+		return slices.Contains(ps.TailscaleIPs, ip)
+	}
+	spec = strings.TrimSuffix(spec, ".")
+	dnsName := strings.TrimSuffix(ps.DNSName, ".")
+	if strings.EqualFold(spec, dnsName) || strings.EqualFold(spec, ps.HostName) {
+		return true
+	}
+	base, _, _ := strings.Cut(dnsName, ".")
+	return strings.EqualFold(spec, base)
+}
+
+// matchImportantPeers returns the peers in st named by specs.
+func matchImportantPeers(specs []string, st *ipnstate.Status) []*ipnstate.PeerStatus {
+	var ret []*ipnstate.PeerStatus
+	for _, pk := range st.Peers() {
+		ps := st.Peer[pk]
+		for _, spec := range specs {
+			if importantPeerMatches(spec, ps) {
+				ret = append(ret, ps)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// unreachableImportantPeers returns an error naming each important peer
+// that hasn't answered a ping since timeout before now, or nil if there
+// are none. Specs that match no peer in st are reported too. The watch
+// started at start; peers are given until start+timeout to answer.
+func unreachableImportantPeers(specs []string, st *ipnstate.Status, lastPong map[key.NodePublic]time.Time, start, now time.Time, timeout time.Duration) error {
+	if now.Sub(start) < timeout {
+		return nil
+	}
+	var bad []string
+	for _, spec := range specs {
+		var found bool
+		for _, pk := range st.Peers() {
+			ps := st.Peer[pk]
+			if !importantPeerMatches(spec, ps) {
+				continue
+			}
+			found = true
+			if t := lastPong[pk]; now.Sub(t) > timeout {
+				if t.IsZero() {
+					bad = append(bad, fmt.Sprintf("%s (never reached)", spec))
+				} else {
+					bad = append(bad, fmt.Sprintf("%s (last reached %v ago)", spec, now.Sub(t).Round(time.Second)))
+				}
+			}
+		}
+		if !found {
+			bad = append(bad, fmt.Sprintf("%s (not in network map)", spec))
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	return fmt.Errorf("important peers unreachable for over %v: %s", timeout, strings.Join(bad, ", "))
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestParseImportantPeers(t *testing.T) {
+	got := parseImportantPeers(" db1, 100.64.0.2,,web.example.ts.net ")
+	want := []string{"db1", "100.64.0.2", "web.example.ts.net"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestImportantPeers(t *testing.T) {
+	db, web := key.NewNode().Public(), key.NewNode().Public()
+	st := &ipnstate.Status{
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			db: {
+				PublicKey:    db,
+				HostName:     "DB-Server",
+				DNSName:      "db1.example.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+			},
+			web: {
+				PublicKey:    web,
+				HostName:     "web",
+				DNSName:      "web.example.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+			},
+		},
+	}
+
+	for _, spec := range []string{"db1", "DB1.example.ts.net", "db-server", "100.64.0.1"} {
+		if !importantPeerMatches(spec, st.Peer[db]) {
+			t.Errorf("%q doesn't match db", spec)
+		}
+		if importantPeerMatches(spec, st.Peer[web]) {
+			t.Errorf("%q matches web", spec)
+		}
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeout := 5 * time.Minute
+	specs := []string{"db1", "web", "gone"}
+	lastPong := map[key.NodePublic]time.Time{
+		db: start.Add(9 * time.Minute),
+	}
+
+	// Within the grace period after start, nothing is reported.
+	if err := unreachableImportantPeers(specs, st, lastPong, start, start.Add(time.Minute), timeout); err != nil {
+		t.Errorf("during grace period: %v", err)
+	}
+
+	err := unreachableImportantPeers(specs, st, lastPong, start, start.Add(10*time.Minute), timeout)
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	msg := err.Error()
+	for _, want := range []string{"web (never reached)", "gone (not in network map)"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q doesn't mention %q", msg, want)
+		}
+	}
+	if strings.Contains(msg, "db1") {
+		t.Errorf("error %q mentions reachable peer db1", msg)
+	}
+
+	lastPong[web] = start.Add(10 * time.Minute)
+	if err := unreachableImportantPeers(specs[:2], st, lastPong, start, start.Add(10*time.Minute), timeout); err != nil {
+		t.Errorf("all reachable: %v", err)
+	}
+}
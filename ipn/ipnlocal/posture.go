@@ -0,0 +1,145 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/posture"
+	"tailscale.com/util/syspolicy"
+)
+
+// postureCheckingEnabled reports whether device posture checking is on,
+// per syspolicy or, if that's not set, prefs.
+func (b *LocalBackend) postureCheckingEnabled(prefs ipn.PrefsView) bool {
+	choice, err := syspolicy.GetPreferenceOption(syspolicy.PostureChecking)
+	if err != nil {
+		b.logf(
+			"failed to read PostureChecking from syspolicy, returning default from CLI: %s; got error: %s",
+			prefs.PostureChecking(),
+			err,
+		)
+	}
+	return choice.ShouldEnable(prefs.PostureChecking())
+}
+
+// updatePostureRecheckerLocked starts or stops b.postureRechecker as
+// posture checking is turned on or off by prefs, and returns the digest
+// of the latest posture attestation, for Hostinfo.PostureDigest.
+//
+// b.mu must be held.
+func (b *LocalBackend) updatePostureRecheckerLocked(prefs ipn.PrefsView) (digest string) {
+	if !b.postureCheckingEnabled(prefs) {
+		if r := b.postureRechecker; r != nil {
+			b.postureRechecker = nil
+			go r.Close() // it might be waiting on b.mu
+		}
+		return ""
+	}
+	if b.postureRechecker == nil {
+		var r *posture.Rechecker
+		r = posture.NewRechecker(b.logf, b.loadPostureAttestationLocked(), func(a posture.Attestation, changed bool) {
+			b.onPostureCollected(r, a, changed)
+		})
+		b.postureRechecker = r
+		r.Start()
+	}
+	return b.postureRechecker.Last().Digest()
+}
+
+// onPostureCollected caches a, a new attestation from r, and if its
+// attributes changed, tells control.
+func (b *LocalBackend) onPostureCollected(r *posture.Rechecker, a posture.Attestation, changed bool) {
+	b.mu.Lock()
+	if b.postureRechecker != r {
+		// Stopped or replaced meanwhile.
+		b.mu.Unlock()
+		return
+	}
+	b.savePostureAttestationLocked(a)
+	if !changed || b.hostinfo == nil {
+		b.mu.Unlock()
+		return
+	}
+	b.hostinfo.PostureDigest = a.Digest()
+	b.mu.Unlock()
+
+	b.doSetHostinfoFilterServices()
+}
+
+// postureSigningKeyLocked returns the key with which posture attestations
+// are signed, or nil if there's no machine key yet.
+//
+// b.mu must be held.
+func (b *LocalBackend) postureSigningKeyLocked() []byte {
+	if b.machinePrivKey.IsZero() {
+		return nil
+	}
+	h := sha256.New()
+	h.Write([]byte("tailscale posture attestation\x00"))
+	h.Write(b.machinePrivKey.UntypedBytes())
+	return h.Sum(nil)
+}
+
+// loadPostureAttestationLocked returns the cached posture attestation, or
+// the zero value if there's none that's properly signed.
+//
+// b.mu must be held.
+func (b *LocalBackend) loadPostureAttestationLocked() posture.Attestation {
+	key := b.postureSigningKeyLocked()
+	if key == nil {
+		return posture.Attestation{}
+	}
+	j, err := b.store.ReadState(ipn.PostureAttestationStateKey)
+	if err != nil {
+		if !errors.Is(err, ipn.ErrStateNotExist) {
+			b.logf("posture: reading cached attestation: %v", err)
+		}
+		return posture.Attestation{}
+	}
+	var a posture.Attestation
+	if err := json.Unmarshal(j, &a); err != nil || !a.Verify(key) {
+		b.logf("posture: ignoring invalid cached attestation")
+		return posture.Attestation{}
+	}
+	return a
+}
+
+// savePostureAttestationLocked signs a and caches it in the state store,
+// so that a restart needn't collect the attributes again.
+//
+// b.mu must be held.
+func (b *LocalBackend) savePostureAttestationLocked(a posture.Attestation) {
+	key := b.postureSigningKeyLocked()
+	if key == nil {
+		return
+	}
+	a.Sign(key)
+	j, err := json.Marshal(a)
+	if err != nil {
+		b.logf("posture: %v", err)
+		return
+	}
+	if err := ipn.WriteState(b.store, ipn.PostureAttestationStateKey, j); err != nil {
+		b.logf("posture: caching attestation: %v", err)
+	}
+}
+
+// postureSerialNumbers returns the device's serial numbers from the latest
+// posture attestation, if it's fresh, or else reads them.
+func (b *LocalBackend) postureSerialNumbers() ([]string, error) {
+	b.mu.Lock()
+	r := b.postureRechecker
+	b.mu.Unlock()
+	if r != nil {
+		if a := r.Last(); !a.IsZero() && time.Since(a.Collected) < posture.RecheckInterval {
+			return a.SerialNumbers, nil
+		}
+	}
+	return posture.GetSerialNumbers(b.logf)
+}
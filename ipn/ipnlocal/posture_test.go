@@ -0,0 +1,62 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/posture"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestPostureAttestationCache(t *testing.T) {
+	b := newTestLocalBackend(t)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	att := posture.Attestation{
+		Attributes: posture.Attributes{SerialNumbers: []string{"abc"}, OSVersion: "1.0"},
+		Collected:  time.Now().Add(-time.Minute),
+	}
+
+	// Without a machine key, nothing is cached.
+	b.savePostureAttestationLocked(att)
+	if _, err := b.store.ReadState(ipn.PostureAttestationStateKey); err == nil {
+		t.Fatal("attestation cached without a machine key")
+	}
+
+	b.machinePrivKey = key.NewMachine()
+	b.savePostureAttestationLocked(att)
+	if got := b.loadPostureAttestationLocked(); got.Digest() != att.Digest() {
+		t.Fatalf("loaded attestation digest %q; want %q", got.Digest(), att.Digest())
+	}
+
+	// The cached attestation's digest is reported in Hostinfo while
+	// posture checking is on, without collecting the attributes again.
+	p := ipn.NewPrefs()
+	p.PostureChecking = true
+	hi := new(tailcfg.Hostinfo)
+	b.applyPrefsToHostinfoLocked(hi, p.View())
+	if hi.PostureDigest != att.Digest() {
+		t.Errorf("PostureDigest = %q; want %q", hi.PostureDigest, att.Digest())
+	}
+	if b.postureRechecker == nil {
+		t.Fatal("posture rechecker not started")
+	}
+
+	p.PostureChecking = false
+	b.applyPrefsToHostinfoLocked(hi, p.View())
+	if hi.PostureDigest != "" || b.postureRechecker != nil {
+		t.Errorf("PostureDigest = %q, rechecker = %v with posture checking off; want none", hi.PostureDigest, b.postureRechecker)
+	}
+
+	// A cached attestation signed with another machine key isn't trusted.
+	b.machinePrivKey = key.NewMachine()
+	if got := b.loadPostureAttestationLocked(); !got.IsZero() {
+		t.Errorf("loaded attestation %+v signed with another key", got)
+	}
+}
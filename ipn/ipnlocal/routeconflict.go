@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/clientmetric"
+)
+
+// routeConflict is a peer's primary subnet route that overlaps a primary
+// route of another peer.
+type routeConflict struct {
+	prefix netip.Prefix
+
+	// primary is the subnet router that the control plane chose for
+	// prefix.
+	primary tailcfg.NodeView
+
+	// overlaps are other peers' primary routes that overlap prefix
+	// without being equal to it. Traffic goes to the most specific one.
+	overlaps []netip.Prefix
+}
+
+// primaryRouters returns the subnet router the control plane chose for
+// each subnet route of peers. Expired peers and exit node routes are
+// skipped.
+//
+// Only a peer's primary routes are considered: the control plane doesn't
+// tell us which other peers are approved to route the same prefix, as
+// their AllowedIPs only contain their primary routes.
+func primaryRouters(peers []tailcfg.NodeView) map[netip.Prefix]tailcfg.NodeView {
+	primaries := map[netip.Prefix]tailcfg.NodeView{}
+	for _, p := range peers {
+		if p.Expired() {
+			continue
+		}
+		for i := range p.PrimaryRoutes().Len() {
+			pfx := p.PrimaryRoutes().At(i)
+			if pfx.Bits() == 0 {
+				continue // exit node routes are selected separately
+			}
+			primaries[pfx] = p
+		}
+	}
+	return primaries
+}
+
+// findRouteConflicts returns the primary subnet routes of peers that overlap
+// a primary route of a different peer, sorted by prefix.
+func findRouteConflicts(peers []tailcfg.NodeView) []*routeConflict {
+	primaries := primaryRouters(peers)
+	routes := make([]netip.Prefix, 0, len(primaries))
+	for pfx := range primaries {
+		routes = append(routes, pfx)
+	}
+	slices.SortFunc(routes, comparePrefix)
+
+	conflicts := map[netip.Prefix]*routeConflict{}
+	get := func(pfx netip.Prefix) *routeConflict {
+		rc := conflicts[pfx]
+		if rc == nil {
+			rc = &routeConflict{prefix: pfx, primary: primaries[pfx]}
+			conflicts[pfx] = rc
+		}
+		return rc
+	}
+	for i, a := range routes {
+		for _, b := range routes[i+1:] {
+			if a.Overlaps(b) && primaries[a].ID() != primaries[b].ID() {
+				get(a).overlaps = append(get(a).overlaps, b)
+				get(b).overlaps = append(get(b).overlaps, a)
+			}
+		}
+	}
+
+	ret := make([]*routeConflict, 0, len(conflicts))
+	for _, rc := range conflicts {
+		ret = append(ret, rc)
+	}
+	slices.SortFunc(ret, func(a, b *routeConflict) int {
+		return comparePrefix(a.prefix, b.prefix)
+	})
+	return ret
+}
+
+// routeConflictsLocked returns the conflicting subnet routes of the current
+// peers.
+//
+// b.mu must be held.
+func (b *LocalBackend) routeConflictsLocked() []*routeConflict {
+	return findRouteConflicts(b.peersLocked())
+}
+
+// peersLocked returns the current peers.
+//
+// b.mu must be held.
+func (b *LocalBackend) peersLocked() []tailcfg.NodeView {
+	peers := make([]tailcfg.NodeView, 0, len(b.peers))
+	for _, p := range b.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// comparePrefix orders prefixes by address, then by length.
+func comparePrefix(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Bits(), b.Bits())
+}
+
+// status returns the ipnstate form of rc.
+func (rc *routeConflict) status() *ipnstate.RouteConflict {
+	st := &ipnstate.RouteConflict{
+		Prefix:   rc.prefix,
+		Overlaps: rc.overlaps,
+	}
+	if rc.primary.Valid() {
+		st.Primary = rc.primary.StableID()
+	}
+	return st
+}
+
+// notePrimaryRoutersLocked logs the subnet routes whose primary router
+// changed since the last call, which is how the control plane fails a
+// route over to another router approved for it.
+//
+// b.mu must be held.
+func (b *LocalBackend) notePrimaryRoutersLocked(primaries map[netip.Prefix]tailcfg.NodeView) {
+	cur := make(map[netip.Prefix]tailcfg.StableNodeID, len(primaries))
+	for pfx, p := range primaries {
+		id := p.StableID()
+		cur[pfx] = id
+		if old, ok := b.primaryRouters[pfx]; ok && old != id {
+			metricRouteFailover.Add(1)
+			b.logf("subnet route %v: primary router changed from %q to %q", pfx, old, id)
+		}
+	}
+	b.primaryRouters = cur
+}
+
+var (
+	// metricRouteFailover counts the times that the control plane moved
+	// a subnet route to a different primary router.
+	metricRouteFailover = clientmetric.NewCounter("subnet_route_failover")
+)
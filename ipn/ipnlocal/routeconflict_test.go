@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// testRouter returns a peer as the control plane sends it: it advertises
+// routes in its Hostinfo, but only its primary routes are in its
+// AllowedIPs.
+func testRouter(id tailcfg.NodeID, advertised []netip.Prefix, primary ...netip.Prefix) *tailcfg.Node {
+	self := netip.PrefixFrom(netip.AddrFrom4([4]byte{100, 64, 0, byte(id)}), 32)
+	return &tailcfg.Node{
+		ID:            id,
+		StableID:      tailcfg.StableNodeID("n" + string(rune('0'+id))),
+		Key:           key.NewNode().Public(),
+		Addresses:     []netip.Prefix{self},
+		Hostinfo:      (&tailcfg.Hostinfo{RoutableIPs: advertised}).View(),
+		PrimaryRoutes: primary,
+		AllowedIPs:    append([]netip.Prefix{self}, primary...),
+	}
+}
+
+func TestRouteConflicts(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	lan := pfx("10.0.0.0/24")
+	lanHalf := pfx("10.0.0.0/25")
+	wide := pfx("10.0.0.0/16")
+	other := pfx("192.168.1.0/24")
+	expiredRoute := pfx("10.0.1.0/24")
+
+	// a is the primary for lan and other, and also routes part of lan,
+	// which isn't a conflict as it's the same router.
+	a := testRouter(1, []netip.Prefix{lan, lanHalf, other}, lan, lanHalf, other)
+	// b is an HA backup for lan: approved, but not primary, so control
+	// only tells us it advertises lan.
+	b := testRouter(2, []netip.Prefix{lan})
+	d := testRouter(4, []netip.Prefix{wide}, wide)
+	e := testRouter(5, []netip.Prefix{expiredRoute}, expiredRoute)
+	e.Expired = true
+	peers := []tailcfg.NodeView{a.View(), b.View(), d.View(), e.View()}
+
+	var got []*ipnstate.RouteConflict
+	for _, rc := range findRouteConflicts(peers) {
+		got = append(got, rc.status())
+	}
+	want := []*ipnstate.RouteConflict{
+		{Prefix: wide, Primary: "n4", Overlaps: []netip.Prefix{lan, lanHalf}},
+		{Prefix: lan, Primary: "n1", Overlaps: []netip.Prefix{wide}},
+		{Prefix: lanHalf, Primary: "n1", Overlaps: []netip.Prefix{wide}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:")
+		for _, rc := range got {
+			t.Errorf("  %+v", rc)
+		}
+		t.Errorf("want:")
+		for _, rc := range want {
+			t.Errorf("  %+v", rc)
+		}
+	}
+
+	if got := findRouteConflicts([]tailcfg.NodeView{a.View(), b.View()}); len(got) != 0 {
+		t.Errorf("HA pair without overlaps: got %d conflicts; want 0", len(got))
+	}
+}
+
+func TestNotePrimaryRouters(t *testing.T) {
+	lan := netip.MustParsePrefix("10.0.0.0/24")
+	a := testRouter(1, []netip.Prefix{lan}, lan)
+	b := testRouter(2, []netip.Prefix{lan})
+	lb := &LocalBackend{logf: t.Logf}
+
+	note := func(peers ...*tailcfg.Node) int64 {
+		before := metricRouteFailover.Value()
+		var views []tailcfg.NodeView
+		for _, p := range peers {
+			views = append(views, p.View())
+		}
+		lb.notePrimaryRoutersLocked(primaryRouters(views))
+		return metricRouteFailover.Value() - before
+	}
+	if n := note(a, b); n != 0 {
+		t.Errorf("first netmap: %d failovers; want 0", n)
+	}
+	if n := note(a, b); n != 0 {
+		t.Errorf("unchanged netmap: %d failovers; want 0", n)
+	}
+
+	// Control fails lan over to b.
+	a.PrimaryRoutes, a.AllowedIPs = nil, a.Addresses
+	b.PrimaryRoutes, b.AllowedIPs = []netip.Prefix{lan}, append(b.Addresses, lan)
+	if n := note(a, b); n != 1 {
+		t.Errorf("failover: %d failovers; want 1", n)
+	}
+}
@@ -28,6 +28,8 @@
 
 const webClientPort = web.ListenPort
 
+const webClientSupported = true
+
 // webClient holds state for the web interface for managing this
 // tailscale instance. The web interface is not used by default,
 // but initialized by calling LocalBackend.WebClientGetOrInit.
@@ -14,6 +14,8 @@
 
 const webClientPort = 5252
 
+const webClientSupported = false
+
 type webClient struct{}
 
 func (b *LocalBackend) ConfigureWebClient(lc *tailscale.LocalClient) {}
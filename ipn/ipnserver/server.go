@@ -199,7 +199,9 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		lah := localapi.NewHandler(lb, s.logf, s.backendLogID)
 		lah.PermitRead, lah.PermitWrite = s.localAPIPermissions(ci)
 		lah.PermitCert = s.connCanFetchCerts(ci)
+		lah.PermitSystemConfig = s.connCanChangeSystemConfig(ci)
 		lah.ConnIdentity = ci
+		lah.WebSocketOrigins = localAPIWebSocketOrigins()
 		lah.ServeHTTP(w, r)
 		return
 	}
@@ -367,6 +369,35 @@ func (s *Server) connCanFetchCerts(ci *ipnauth.ConnIdentity) bool {
 	return false
 }
 
+// connCanChangeSystemConfig reports whether ci is allowed to change
+// system-wide settings outside of Tailscale, such as sysctls.
+//
+// Unlike write access, this isn't granted to the operator user: only to
+// root or a member of the system's admin group on Unix, and to elevated
+// processes on Windows.
+func (s *Server) connCanChangeSystemConfig(ci *ipnauth.ConnIdentity) bool {
+	if envknob.GOOS() == "windows" {
+		tok, err := ci.WindowsToken()
+		if err != nil {
+			return false
+		}
+		defer tok.Close()
+		return tok.IsElevated()
+	}
+	if !ci.IsUnixSock() || ci.Creds() == nil {
+		return false
+	}
+	uid, ok := ci.Creds().UserID()
+	if !ok {
+		return false
+	}
+	if uid == "0" {
+		return true
+	}
+	admin, err := ipnauth.IsLocalAdmin(uid)
+	return err == nil && admin
+}
+
 // addActiveHTTPRequest adds c to the server's list of active HTTP requests.
 //
 // If the returned error may be of type inUseOtherUserError.
@@ -517,7 +548,11 @@ func (s *Server) Run(ctx context.Context, ln net.Listener) error {
 		ln.Close()
 	}()
 
-	systemd.Ready()
+	if d := systemdReadyTimeout(); d > 0 {
+		go s.notifySystemdWhenRunning(ctx, d)
+	} else {
+		systemd.Ready()
+	}
 
 	hs := &http.Server{
 		Handler:     http.HandlerFunc(s.serveHTTP),
@@ -548,6 +583,35 @@ func (s *Server) Run(ctx context.Context, ln net.Listener) error {
 	return nil
 }
 
+// systemdReadyTimeout, if non-zero, defers signaling readiness to systemd until
+// the LocalBackend reaches the Running state (that is, its state has been
+// loaded, it has connected to the control plane, and it has applied the
+// resulting network configuration) or until the timeout elapses, whichever
+// happens first. If zero, readiness is signaled as soon as the LocalAPI is
+// being served.
+var systemdReadyTimeout = envknob.RegisterDuration("TS_SYSTEMD_READY_TIMEOUT")
+
+// notifySystemdWhenRunning waits for the LocalBackend to reach the Running
+// state, or for timeout to elapse, and then signals readiness to systemd.
+// It returns without signaling if ctx is done first.
+func (s *Server) notifySystemdWhenRunning(ctx context.Context, timeout time.Duration) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if lb, ok := s.awaitBackend(waitCtx); ok {
+		lb.WatchNotifications(waitCtx, ipn.NotifyInitialState, nil, func(n *ipn.Notify) (keepGoing bool) {
+			return n.State == nil || *n.State != ipn.Running
+		})
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if waitCtx.Err() != nil {
+		s.logf("ipnserver: not Running after %v; signaling systemd readiness anyway", timeout)
+	}
+	systemd.Ready()
+}
+
 // ServeHTMLStatus serves an HTML status page at http://localhost:41112/ for
 // Windows and via $DEBUG_LISTENER/debug/ipn when tailscaled's --debug flag
 // is used to run a debug server.
@@ -573,3 +637,22 @@ func (s *Server) ServeHTMLStatus(w http.ResponseWriter, r *http.Request) {
 	// TODO(bradfitz): add LogID and opts to st?
 	st.WriteHTML(w)
 }
+
+// localAPIWebSocketOriginsEnv is the comma-separated host patterns of the
+// browser origins that may watch the IPN bus over a WebSocket, for local web
+// dashboards. Patterns use path.Match syntax, so "localhost:*" allows any
+// port on localhost, and are matched case-insensitively against the
+// Origin's host and port. See localapi.Handler.WebSocketOrigins.
+var localAPIWebSocketOriginsEnv = envknob.RegisterString("TS_LOCALAPI_WEBSOCKET_ORIGINS")
+
+// localAPIWebSocketOrigins returns the origins in
+// TS_LOCALAPI_WEBSOCKET_ORIGINS, if any.
+func localAPIWebSocketOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(localAPIWebSocketOriginsEnv(), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
@@ -52,6 +52,10 @@ type Status struct {
 	// If nil, an exit node is not in use.
 	ExitNodeStatus *ExitNodeStatus `json:"ExitNodeStatus,omitempty"`
 
+	// RouteConflicts are the peers' primary subnet routes that overlap
+	// a primary route of another peer, sorted by prefix.
+	RouteConflicts []*RouteConflict `json:",omitempty"`
+
 	// Health contains health check problems.
 	// Empty means everything is good. (or at least that no known
 	// problems are detected)
@@ -181,6 +185,21 @@ type ExitNodeStatus struct {
 	TailscaleIPs []netip.Prefix
 }
 
+// RouteConflict describes a peer's primary subnet route that overlaps a
+// primary route of another peer.
+type RouteConflict struct {
+	// Prefix is the route.
+	Prefix netip.Prefix
+
+	// Primary is the subnet router that the control plane chose for
+	// Prefix.
+	Primary tailcfg.StableNodeID `json:",omitempty"`
+
+	// Overlaps are other peers' routes that overlap Prefix without
+	// being equal to it. Traffic goes to the most specific route.
+	Overlaps []netip.Prefix `json:",omitempty"`
+}
+
 func (s *Status) Peers() []key.NodePublic {
 	kk := make([]key.NodePublic, 0, len(s.Peer))
 	for k := range s.Peer {
@@ -249,6 +268,8 @@ type PeerStatus struct {
 	LastWrite      time.Time // time last packet sent
 	LastSeen       time.Time // last seen to tailcontrol; only present if offline
 	LastHandshake  time.Time // with local wireguard
+	LastDirect     time.Time // last packet of any kind received directly over UDP
+	LastDERP       time.Time // last data packet received via DERP
 	Online         bool      // whether node is connected to the control plane
 	ExitNode       bool      // true if this is the currently selected exit node.
 	ExitNodeOption bool      // true if this node can be an exit node (offered && approved)
@@ -260,6 +281,11 @@ type PeerStatus struct {
 	// change.
 	Active bool
 
+	// LatencySeconds is the round-trip time of the most recent disco
+	// ping to the peer over its current direct path or, if there's no
+	// direct path, over DERP. It's zero if not known.
+	LatencySeconds float64 `json:",omitempty"`
+
 	// PeerAPIURL are the URLs of the node's PeerAPI servers.
 	PeerAPIURL []string
 
@@ -466,6 +492,12 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if v := st.LastWrite; !v.IsZero() {
 		e.LastWrite = v
 	}
+	if v := st.LastDirect; !v.IsZero() {
+		e.LastDirect = v
+	}
+	if v := st.LastDERP; !v.IsZero() {
+		e.LastDERP = v
+	}
 	if st.Online {
 		e.Online = true
 	}
@@ -490,6 +522,9 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if st.Active {
 		e.Active = true
 	}
+	if v := st.LatencySeconds; v != 0 {
+		e.LatencySeconds = v
+	}
 	if st.PeerAPIURL != nil {
 		e.PeerAPIURL = st.PeerAPIURL
 	}
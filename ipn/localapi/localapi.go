@@ -32,6 +32,7 @@
 	"time"
 
 	"github.com/google/uuid"
+	"nhooyr.io/websocket"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/clientupdate"
 	"tailscale.com/drive"
@@ -45,6 +46,7 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/portmapper"
+	"tailscale.com/net/sockstats"
 	"tailscale.com/tailcfg"
 	"tailscale.com/taildrop"
 	"tailscale.com/tka"
@@ -55,6 +57,7 @@
 	"tailscale.com/types/ptr"
 	"tailscale.com/types/tkatype"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/crashlog"
 	"tailscale.com/util/httphdr"
 	"tailscale.com/util/httpm"
 	"tailscale.com/util/mak"
@@ -85,6 +88,7 @@
 	"check-prefs":                 (*Handler).serveCheckPrefs,
 	"check-udp-gro-forwarding":    (*Handler).serveCheckUDPGROForwarding,
 	"component-debug-logging":     (*Handler).serveComponentDebugLogging,
+	"crashes":                     (*Handler).serveCrashes,
 	"debug":                       (*Handler).serveDebug,
 	"debug-capture":               (*Handler).serveDebugCapture,
 	"debug-derp-region":           (*Handler).serveDebugDERPRegion,
@@ -94,12 +98,17 @@
 	"debug-packet-filter-rules":   (*Handler).serveDebugPacketFilterRules,
 	"debug-peer-endpoint-changes": (*Handler).serveDebugPeerEndpointChanges,
 	"debug-portmap":               (*Handler).serveDebugPortmap,
+	"debug-sockstats":             (*Handler).serveDebugSockStats,
 	"derpmap":                     (*Handler).serveDERPMap,
 	"dev-set-state-store":         (*Handler).serveDevSetStateStore,
 	"dial":                        (*Handler).serveDial,
+	"doctor":                      (*Handler).serveDoctor,
 	"drive/fileserver-address":    (*Handler).serveDriveServerAddr,
 	"drive/shares":                (*Handler).serveShares,
 	"file-targets":                (*Handler).serveFileTargets,
+	"filter-alert-rules":          (*Handler).serveFilterAlertRules,
+	"filter-alerts":               (*Handler).serveFilterAlerts,
+	"fix-ip-forwarding":           (*Handler).serveFixIPForwarding,
 	"goroutines":                  (*Handler).serveGoroutines,
 	"handle-push-message":         (*Handler).serveHandlePushMessage,
 	"id-token":                    (*Handler).serveIDToken,
@@ -111,6 +120,7 @@
 	"pprof":                       (*Handler).servePprof,
 	"prefs":                       (*Handler).servePrefs,
 	"query-feature":               (*Handler).serveQueryFeature,
+	"ready":                       (*Handler).serveReady,
 	"reload-config":               (*Handler).reloadConfig,
 	"reset-auth":                  (*Handler).serveResetAuth,
 	"serve-config":                (*Handler).serveServeConfig,
@@ -139,7 +149,9 @@
 	"update/install":              (*Handler).serveUpdateInstall,
 	"update/progress":             (*Handler).serveUpdateProgress,
 	"upload-client-metrics":       (*Handler).serveUploadClientMetrics,
+	"version":                     (*Handler).serveVersion,
 	"watch-ipn-bus":               (*Handler).serveWatchIPNBus,
+	"watch-ipn-bus-ws":            (*Handler).serveWatchIPNBusWebSocket,
 	"whois":                       (*Handler).serveWhoIs,
 }
 
@@ -178,6 +190,18 @@ type Handler struct {
 	// cert fetching access.
 	PermitCert bool
 
+	// PermitSystemConfig is whether the client may change system-wide
+	// settings outside of Tailscale, such as sysctls. Unlike PermitWrite,
+	// it's not granted to the operator user.
+	PermitSystemConfig bool
+
+	// WebSocketOrigins are the host patterns, in path.Match syntax, of the
+	// browser origins, such as "localhost:8080" or "localhost:*", that may
+	// use the watch-ipn-bus-ws WebSocket handler. Requests from browsers
+	// are otherwise rejected, as are requests from any origin to all other
+	// handlers.
+	WebSocketOrigins []string
+
 	// ConnIdentity is the identity of the client connected to the Handler.
 	ConnIdentity *ipnauth.ConnIdentity
 
@@ -196,7 +220,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "server has no local backend", http.StatusInternalServerError)
 		return
 	}
-	if r.Referer() != "" || r.Header.Get("Origin") != "" || !h.validHost(r.Host) {
+	if !h.validHost(r.Host) || (r.Referer() != "" || r.Header.Get("Origin") != "") && !h.isAllowedWebSocketOrigin(r) {
 		metricInvalidRequests.Add(1)
 		http.Error(w, "invalid localapi request", http.StatusForbidden)
 		return
@@ -354,8 +378,12 @@ func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
 	if note := r.URL.Query().Get("note"); len(note) > 0 {
 		h.logf("user bugreport note: %s", note)
 	}
-	hi, _ := json.Marshal(hostinfo.New())
-	h.logf("user bugreport hostinfo: %s", hi)
+	hi := hostinfo.New()
+	if h.b.Prefs().HideExperimentalFeatures() {
+		hi.ExperimentalFeatures = nil
+	}
+	hij, _ := json.Marshal(hi)
+	h.logf("user bugreport hostinfo: %s", hij)
 	if err := h.b.HealthTracker().OverallError(); err != nil {
 		h.logf("user bugreport health: %s", err.Error())
 	} else {
@@ -372,6 +400,12 @@ func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
 		h.logf("user bugreport netmap: no active netmap")
 	}
 
+	// The portmapper's recent decisions, which it otherwise only logs
+	// verbosely.
+	for _, e := range h.b.MagicConn().PortMapperEvents() {
+		h.logf("user bugreport portmapper: %v", e)
+	}
+
 	// Print all envknobs; we otherwise only print these on startup, and
 	// printing them here ensures we don't have to go spelunking through
 	// logs for them.
@@ -504,6 +538,49 @@ func (h *Handler) serveGoroutines(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf)
 }
 
+// serveCrashes serves the crash reports tailscaled saved in its state
+// directory. With a "name" query parameter, it serves that report's
+// contents; otherwise it serves the JSON list of reports, oldest first,
+// with a Tailscale-Crash-Capture header of "unsupported" if this
+// tailscaled was built with a Go too old to capture new ones.
+func (h *Handler) serveCrashes(w http.ResponseWriter, r *http.Request) {
+	// Require write access, like goroutine dumps, as crash reports
+	// contain them.
+	if !h.PermitWrite {
+		http.Error(w, "crash report access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	dir := crashlog.Dir(h.b.TailscaleVarRoot())
+	if dir == "" {
+		http.Error(w, "no state directory for crash reports", http.StatusNotFound)
+		return
+	}
+	if name := r.FormValue("name"); name != "" {
+		data, err := crashlog.Read(dir, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(data)
+		return
+	}
+	crashes, err := crashlog.List(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !crashlog.CaptureSupported {
+		w.Header().Set("Tailscale-Crash-Capture", "unsupported")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(crashes)
+}
+
 // serveLogTap taps into the tailscaled/logtail server output and streams
 // it to the client.
 func (h *Handler) serveLogTap(w http.ResponseWriter, r *http.Request) {
@@ -583,6 +660,8 @@ func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 		err = h.b.DebugRebind()
 	case "restun":
 		err = h.b.DebugReSTUN()
+	case "restart-engine":
+		err = h.b.RestartEngine()
 	case "notify":
 		var n ipn.Notify
 		err = json.NewDecoder(r.Body).Decode(&n)
@@ -743,23 +822,7 @@ func (h *Handler) serveDebugPortmap(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), dur)
 	defer cancel()
 
-	done := make(chan bool, 1)
-
-	var c *portmapper.Client
-	c = portmapper.NewClient(logger.WithPrefix(logf, "portmapper: "), h.b.NetMon(), debugKnobs, h.b.ControlKnobs(), func() {
-		logf("portmapping changed.")
-		logf("have mapping: %v", c.HaveMapping())
-
-		if ext, ok := c.GetCachedMappingOrStartCreatingOne(); ok {
-			logf("cb: mapping: %v", ext)
-			select {
-			case done <- true:
-			default:
-			}
-			return
-		}
-		logf("cb: no mapping")
-	})
+	c := portmapper.NewClient(logger.WithPrefix(logf, "portmapper: "), h.b.NetMon(), debugKnobs, h.b.ControlKnobs(), nil)
 	defer c.Close()
 
 	netMon, err := netmon.New(logger.WithPrefix(logf, "monitor: "))
@@ -791,35 +854,17 @@ func (h *Handler) serveDebugPortmap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer uc.Close()
-	c.SetLocalPort(uint16(uc.LocalAddr().(*net.UDPAddr).Port))
-
-	res, err := c.Probe(ctx)
-	if err != nil {
-		logf("error in Probe: %v", err)
-		return
-	}
-	logf("Probe: %+v", res)
 
-	if !res.PCP && !res.PMP && !res.UPnP {
-		logf("no portmapping services available")
+	// Probe, then try a throwaway mapping of uc's port with each
+	// service, and summarize the results after the logs.
+	rep := c.Diagnose(ctx, uint16(uc.LocalAddr().(*net.UDPAddr).Port))
+	if r.Context().Err() != nil {
+		h.logf("serveDebugPortmap: context done: %v", r.Context().Err())
 		return
 	}
-
-	if ext, ok := c.GetCachedMappingOrStartCreatingOne(); ok {
-		logf("mapping: %v", ext)
-	} else {
-		logf("no mapping")
-	}
-
-	select {
-	case <-done:
-	case <-ctx.Done():
-		if r.Context().Err() == nil {
-			logf("serveDebugPortmap: context done: %v", ctx.Err())
-		} else {
-			h.logf("serveDebugPortmap: context done: %v", ctx.Err())
-		}
-	}
+	var buf bytes.Buffer
+	rep.Write(&buf)
+	logf("\n%s", buf.Bytes())
 }
 
 func (h *Handler) serveComponentDebugLogging(w http.ResponseWriter, r *http.Request) {
@@ -840,6 +885,48 @@ func (h *Handler) serveComponentDebugLogging(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(res)
 }
 
+// serveReady reports whether the LocalBackend is ready to carry traffic:
+// its state has been loaded, it has connected to the control plane and it
+// has applied the resulting network configuration. It responds with 200 OK
+// if so, and with 503 Service Unavailable otherwise, for use as a health
+// check by container orchestrators.
+func (h *Handler) serveReady(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "ready access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	if st := h.b.State(); st != ipn.Running {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "not ready; state %v\n", st)
+		return
+	}
+	io.WriteString(w, "ready\n")
+}
+
+// serveDebugSockStats returns a JSON-encoded sockstats.Report of the
+// per-subsystem socket statistics collected by this node.
+func (h *Handler) serveDebugSockStats(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "debug-sockstats access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sockstats.IsAvailable {
+		http.Error(w, "socket stats are not available for this client", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sockstats.GetReport())
+}
+
 func (h *Handler) serveDebugDialTypes(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug-dial-types access denied", http.StatusForbidden)
@@ -1017,6 +1104,53 @@ func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveFilterAlertRules gets or, with a POST of a JSON array of
+// ipn.FilterAlertRule, replaces the rules for inbound connection attempts
+// to drop and report.
+func (h *Handler) serveFilterAlertRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "filter alert rules access denied", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.b.FilterAlertRules())
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "filter alert rules access denied", http.StatusForbidden)
+			return
+		}
+		var rules []ipn.FilterAlertRule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.SetFilterAlertRules(rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveFilterAlerts returns the most recent inbound connection attempts
+// that matched a filter alert rule. New ones are also sent on the IPN bus.
+func (h *Handler) serveFilterAlerts(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "filter alerts access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.RecentFilterAlerts())
+}
+
 func authorizeServeConfigForGOOSAndUserContext(goos string, configIn *ipn.ServeConfig, h *Handler) error {
 	switch goos {
 	case "windows", "linux", "darwin":
@@ -1164,6 +1298,53 @@ func (h *Handler) serveCheckIPForwarding(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+func (h *Handler) serveDoctor(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "doctor access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.Diagnose(r.Context()))
+}
+
+func (h *Handler) serveVersion(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "version access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apitype.VersionResponse{
+		Meta:     version.GetMeta(),
+		Features: h.b.Features(),
+	})
+}
+
+func (h *Handler) serveFixIPForwarding(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.PermitSystemConfig {
+		http.Error(w, "IP forwarding fix access denied", http.StatusForbidden)
+		return
+	}
+	fixes, err := h.b.FixIPForwarding()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fixes)
+}
+
 func (h *Handler) serveCheckUDPGROForwarding(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "UDP GRO forwarding check access denied", http.StatusForbidden)
@@ -1253,22 +1434,43 @@ func InUseOtherUserIPNStream(w http.ResponseWriter, r *http.Request, err error)
 }
 
 func (h *Handler) serveWatchIPNBus(w http.ResponseWriter, r *http.Request) {
-	if !h.PermitRead {
-		http.Error(w, "watch ipn bus access denied", http.StatusForbidden)
-		return
-	}
 	f, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "not a flusher", http.StatusInternalServerError)
 		return
 	}
+	mask, ok := h.watchIPNBusMask(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+	enc := json.NewEncoder(w)
+	h.b.WatchNotifications(ctx, mask, f.Flush, func(roNotify *ipn.Notify) (keepGoing bool) {
+		err := enc.Encode(roNotify)
+		if err != nil {
+			h.logf("json.Encode: %v", err)
+			return false
+		}
+		f.Flush()
+		return true
+	})
+}
 
-	var mask ipn.NotifyWatchOpt
+// watchIPNBusMask returns the ipn.NotifyWatchOpt requested by r, a request
+// to watch the IPN bus, if the client is allowed to watch with it. If not,
+// it writes an error response to w and returns ok=false.
+func (h *Handler) watchIPNBusMask(w http.ResponseWriter, r *http.Request) (mask ipn.NotifyWatchOpt, ok bool) {
+	if !h.PermitRead {
+		http.Error(w, "watch ipn bus access denied", http.StatusForbidden)
+		return 0, false
+	}
 	if s := r.FormValue("mask"); s != "" {
 		v, err := strconv.ParseUint(s, 10, 64)
 		if err != nil {
 			http.Error(w, "bad mask", http.StatusBadRequest)
-			return
+			return 0, false
 		}
 		mask = ipn.NotifyWatchOpt(v)
 	}
@@ -1277,22 +1479,69 @@ func (h *Handler) serveWatchIPNBus(w http.ResponseWriter, r *http.Request) {
 	if (mask & ipn.NotifyNoPrivateKeys) == 0 {
 		if !h.PermitWrite {
 			http.Error(w, "watch IPN bus access denied, must set ipn.NotifyNoPrivateKeys when not running as admin/root or operator", http.StatusForbidden)
-			return
+			return 0, false
 		}
 	}
+	return mask, true
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	ctx := r.Context()
-	enc := json.NewEncoder(w)
-	h.b.WatchNotifications(ctx, mask, f.Flush, func(roNotify *ipn.Notify) (keepGoing bool) {
-		err := enc.Encode(roNotify)
+// watchIPNBusWriteTimeout is how long serveWatchIPNBusWebSocket waits for a
+// notification to be written before giving up on the client.
+const watchIPNBusWriteTimeout = 10 * time.Second
+
+// serveWatchIPNBusWebSocket is like serveWatchIPNBus, but for browser-based
+// clients: it sends each ipn.Notify as a JSON text message over a WebSocket.
+// Browsers may only use it from one of h.WebSocketOrigins. Private keys are
+// always filtered out, even for admins, as the page's origin is all that
+// vouches for it.
+func (h *Handler) serveWatchIPNBusWebSocket(w http.ResponseWriter, r *http.Request) {
+	mask, ok := h.watchIPNBusMask(w, r)
+	if !ok {
+		return
+	}
+	mask |= ipn.NotifyNoPrivateKeys
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		OriginPatterns: h.WebSocketOrigins,
+	})
+	if err != nil {
+		// Accept has already written an error response.
+		h.logf("watch-ipn-bus-ws: %v", err)
+		return
+	}
+	defer c.Close(websocket.StatusInternalError, "closing")
+
+	// We don't expect any messages from the client, but we need to read
+	// to see control frames, including when it goes away.
+	ctx := c.CloseRead(r.Context())
+	h.b.WatchNotifications(ctx, mask, nil, func(roNotify *ipn.Notify) (keepGoing bool) {
+		j, err := json.Marshal(roNotify)
 		if err != nil {
-			h.logf("json.Encode: %v", err)
+			h.logf("json.Marshal: %v", err)
 			return false
 		}
-		f.Flush()
-		return true
+		wctx, cancel := context.WithTimeout(ctx, watchIPNBusWriteTimeout)
+		defer cancel()
+		return c.Write(wctx, websocket.MessageText, j) == nil
 	})
+	c.Close(websocket.StatusNormalClosure, "")
+}
+
+// isAllowedWebSocketOrigin reports whether r is a request to the
+// watch-ipn-bus-ws handler from a browser origin in h.WebSocketOrigins.
+func (h *Handler) isAllowedWebSocketOrigin(r *http.Request) bool {
+	if r.URL.Path != "/localapi/v0/watch-ipn-bus-ws" || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	u, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, pat := range h.WebSocketOrigins {
+		if ok, _ := path.Match(strings.ToLower(pat), strings.ToLower(u.Host)); ok {
+			return true
+		}
+	}
+	return false
 }
 
 func (h *Handler) serveLoginInteractive(w http.ResponseWriter, r *http.Request) {
@@ -2814,6 +3063,10 @@ func (h *Handler) serveShares(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "invalid share name", http.StatusBadRequest)
 				return
 			}
+			if errors.Is(err, ipnlocal.ErrDriveShareRestricted) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -19,11 +19,14 @@
 	"net/netip"
 	"net/url"
 	"os"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"nhooyr.io/websocket"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
@@ -31,9 +34,12 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
+	"tailscale.com/types/netmap"
 	"tailscale.com/util/slicesx"
+	"tailscale.com/version"
 	"tailscale.com/wgengine"
 )
 
@@ -98,6 +104,33 @@ func TestSetPushDeviceToken(t *testing.T) {
 	}
 }
 
+func TestServeReady(t *testing.T) {
+	tstest.Replace(t, &validLocalHostForTesting, true)
+
+	h := &Handler{
+		PermitRead: true,
+		b:          &ipnlocal.LocalBackend{},
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	res, err := s.Client().Get(s.URL + "/localapi/v0/ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("res.StatusCode=%d, want %d. body: %s", res.StatusCode, http.StatusServiceUnavailable, body)
+	}
+	if got, want := string(body), "not ready; state NoState\n"; got != want {
+		t.Errorf("body=%q, want %q", got, want)
+	}
+}
+
 type whoIsBackend struct {
 	whoIs    func(ipp netip.AddrPort) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool)
 	peerCaps map[netip.Addr]tailcfg.PeerCapMap
@@ -309,6 +342,160 @@ func TestServeWatchIPNBus(t *testing.T) {
 	}
 }
 
+func TestServeWatchIPNBusWebSocket(t *testing.T) {
+	tstest.Replace(t, &validLocalHostForTesting, true)
+
+	tests := []struct {
+		desc       string
+		origin     string // or empty for a non-browser client
+		mask       ipn.NotifyWatchOpt
+		admin      bool // whether the client has write access
+		wantStatus int  // or 0 for success
+	}{
+		{
+			desc: "no-origin",
+			mask: ipn.NotifyNoPrivateKeys,
+		},
+		{
+			desc:   "allowed-origin",
+			origin: "http://localhost:8080",
+			mask:   ipn.NotifyNoPrivateKeys,
+		},
+		{
+			desc:       "other-origin",
+			origin:     "http://evil.example",
+			mask:       ipn.NotifyNoPrivateKeys,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			desc:       "private-keys-read-only",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			// Allowed, but private keys are filtered out anyway.
+			desc:   "private-keys-admin",
+			origin: "http://localhost:8080",
+			admin:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			priv := key.NewNode()
+			lb := newTestLocalBackend(t)
+			lb.SetNetMapForTest(&netmap.NetworkMap{PrivateKey: priv})
+			h := &Handler{
+				PermitRead:       true,
+				PermitWrite:      tt.admin,
+				WebSocketOrigins: []string{"localhost:*"},
+				b:                lb,
+			}
+			s := httptest.NewServer(h)
+			defer s.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			hdr := make(http.Header)
+			if tt.origin != "" {
+				hdr.Set("Origin", tt.origin)
+			}
+			mask := ipn.NotifyInitialState | ipn.NotifyInitialNetMap | tt.mask
+			u := fmt.Sprintf("%s/localapi/v0/watch-ipn-bus-ws?mask=%d", s.URL, mask)
+			c, res, err := websocket.Dial(ctx, u, &websocket.DialOptions{HTTPHeader: hdr})
+			if tt.wantStatus != 0 {
+				if err == nil {
+					c.Close(websocket.StatusNormalClosure, "")
+					t.Fatal("Dial succeeded; want error")
+				}
+				if res == nil || res.StatusCode != tt.wantStatus {
+					t.Fatalf("Dial = %v, %v; want status %d", res, err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close(websocket.StatusNormalClosure, "")
+
+			typ, msg, err := c.Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != websocket.MessageText {
+				t.Errorf("message type = %v; want text", typ)
+			}
+			var n ipn.Notify
+			if err := json.Unmarshal(msg, &n); err != nil {
+				t.Fatal(err)
+			}
+			if n.State == nil {
+				t.Errorf("first notification %s has no initial state", msg)
+			}
+			if n.NetMap == nil {
+				t.Fatalf("first notification %s has no initial netmap", msg)
+			}
+			if !n.NetMap.PrivateKey.IsZero() {
+				t.Errorf("netmap sent over WebSocket has private key %v", n.NetMap.PrivateKey)
+			}
+			privText, err := priv.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(msg, privText) {
+				t.Errorf("notification contains the private key: %s", msg)
+			}
+		})
+	}
+}
+
+func TestServeVersion(t *testing.T) {
+	tstest.Replace(t, &validLocalHostForTesting, true)
+	h := &Handler{
+		PermitRead: true,
+		b:          newTestLocalBackend(t),
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	res, err := s.Client().Get(s.URL + "/localapi/v0/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %v", res.Status)
+	}
+	var got apitype.VersionResponse
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Long != version.Long() || got.GoVersion != runtime.Version() {
+		t.Errorf("got version %q built with %q; want %q built with %q", got.Long, got.GoVersion, version.Long(), runtime.Version())
+	}
+	if _, ok := got.Features["ssh"]; !ok {
+		t.Errorf("features %v don't mention ssh", got.Features)
+	}
+}
+
+func TestServeFixIPForwardingPermission(t *testing.T) {
+	tests := []struct {
+		name string
+		h    *Handler
+	}{
+		{"operator", &Handler{PermitRead: true, PermitWrite: true}},
+		{"cert-uid", &Handler{PermitRead: true, PermitCert: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.h.serveFixIPForwarding(rec, httptest.NewRequest("POST", "/localapi/v0/fix-ip-forwarding", nil))
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status = %v; want %v", rec.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
 func newTestLocalBackend(t testing.TB) *ipnlocal.LocalBackend {
 	var logf logger.Logf = logger.Discard
 	sys := new(tsd.System)
@@ -121,6 +121,18 @@ type Prefs struct {
 	// DNS configuration, if it exists.
 	CorpDNS bool
 
+	// DNSOverride optionally overrides parts of the Tailscale network's
+	// DNS configuration when CorpDNS is true, such as to keep using the
+	// OS's own resolvers while still resolving MagicDNS names.
+	DNSOverride preftype.DNSOverride
+
+	// NoDoHUpgrade, if true, stops Tailscale's DNS forwarder from
+	// upgrading queries to well-known public resolvers, such as Quad9,
+	// Cloudflare and Google, to their DNS-over-HTTPS endpoints. Resolvers
+	// that are only reachable over DoH, such as NextDNS with a profile
+	// ID, still use it.
+	NoDoHUpgrade bool
+
 	// RunSSH bool is whether this node should run an SSH
 	// server, permitting access to peers according to the
 	// policies as configured by the Tailnet's admin(s).
@@ -243,6 +255,12 @@ type Prefs struct {
 	// posture checks.
 	PostureChecking bool
 
+	// HideExperimentalFeatures keeps the list of experimental datapath
+	// features enabled on this node (see hostinfo.SetExperimentalFeature)
+	// out of the Hostinfo sent to the control plane and out of bug
+	// reports.
+	HideExperimentalFeatures bool
+
 	// NetfilterKind specifies what netfilter implementation to use.
 	//
 	// Linux-only.
@@ -297,35 +315,38 @@ type AppConnectorPrefs struct {
 type MaskedPrefs struct {
 	Prefs
 
-	ControlURLSet             bool                `json:",omitempty"`
-	RouteAllSet               bool                `json:",omitempty"`
-	AllowSingleHostsSet       bool                `json:",omitempty"`
-	ExitNodeIDSet             bool                `json:",omitempty"`
-	ExitNodeIPSet             bool                `json:",omitempty"`
-	InternalExitNodePriorSet  bool                `json:",omitempty"` // Internal; can't be set by LocalAPI clients
-	ExitNodeAllowLANAccessSet bool                `json:",omitempty"`
-	CorpDNSSet                bool                `json:",omitempty"`
-	RunSSHSet                 bool                `json:",omitempty"`
-	RunWebClientSet           bool                `json:",omitempty"`
-	WantRunningSet            bool                `json:",omitempty"`
-	LoggedOutSet              bool                `json:",omitempty"`
-	ShieldsUpSet              bool                `json:",omitempty"`
-	AdvertiseTagsSet          bool                `json:",omitempty"`
-	HostnameSet               bool                `json:",omitempty"`
-	NotepadURLsSet            bool                `json:",omitempty"`
-	ForceDaemonSet            bool                `json:",omitempty"`
-	EggSet                    bool                `json:",omitempty"`
-	AdvertiseRoutesSet        bool                `json:",omitempty"`
-	NoSNATSet                 bool                `json:",omitempty"`
-	NoStatefulFilteringSet    bool                `json:",omitempty"`
-	NetfilterModeSet          bool                `json:",omitempty"`
-	OperatorUserSet           bool                `json:",omitempty"`
-	ProfileNameSet            bool                `json:",omitempty"`
-	AutoUpdateSet             AutoUpdatePrefsMask `json:",omitempty"`
-	AppConnectorSet           bool                `json:",omitempty"`
-	PostureCheckingSet        bool                `json:",omitempty"`
-	NetfilterKindSet          bool                `json:",omitempty"`
-	DriveSharesSet            bool                `json:",omitempty"`
+	ControlURLSet               bool                `json:",omitempty"`
+	RouteAllSet                 bool                `json:",omitempty"`
+	AllowSingleHostsSet         bool                `json:",omitempty"`
+	ExitNodeIDSet               bool                `json:",omitempty"`
+	ExitNodeIPSet               bool                `json:",omitempty"`
+	InternalExitNodePriorSet    bool                `json:",omitempty"` // Internal; can't be set by LocalAPI clients
+	ExitNodeAllowLANAccessSet   bool                `json:",omitempty"`
+	CorpDNSSet                  bool                `json:",omitempty"`
+	DNSOverrideSet              bool                `json:",omitempty"`
+	NoDoHUpgradeSet             bool                `json:",omitempty"`
+	RunSSHSet                   bool                `json:",omitempty"`
+	RunWebClientSet             bool                `json:",omitempty"`
+	WantRunningSet              bool                `json:",omitempty"`
+	LoggedOutSet                bool                `json:",omitempty"`
+	ShieldsUpSet                bool                `json:",omitempty"`
+	AdvertiseTagsSet            bool                `json:",omitempty"`
+	HostnameSet                 bool                `json:",omitempty"`
+	NotepadURLsSet              bool                `json:",omitempty"`
+	ForceDaemonSet              bool                `json:",omitempty"`
+	EggSet                      bool                `json:",omitempty"`
+	AdvertiseRoutesSet          bool                `json:",omitempty"`
+	NoSNATSet                   bool                `json:",omitempty"`
+	NoStatefulFilteringSet      bool                `json:",omitempty"`
+	NetfilterModeSet            bool                `json:",omitempty"`
+	OperatorUserSet             bool                `json:",omitempty"`
+	ProfileNameSet              bool                `json:",omitempty"`
+	AutoUpdateSet               AutoUpdatePrefsMask `json:",omitempty"`
+	AppConnectorSet             bool                `json:",omitempty"`
+	PostureCheckingSet          bool                `json:",omitempty"`
+	HideExperimentalFeaturesSet bool                `json:",omitempty"`
+	NetfilterKindSet            bool                `json:",omitempty"`
+	DriveSharesSet              bool                `json:",omitempty"`
 }
 
 // SetsInternal reports whether mp has any of the Internal*Set field bools set
@@ -488,6 +509,12 @@ func (p *Prefs) pretty(goos string) string {
 		sb.WriteString("mesh=false ")
 	}
 	fmt.Fprintf(&sb, "dns=%v want=%v ", p.CorpDNS, p.WantRunning)
+	if p.DNSOverride != preftype.DNSOverrideNone {
+		fmt.Fprintf(&sb, "dnsoverride=%v ", p.DNSOverride)
+	}
+	if p.NoDoHUpgrade {
+		sb.WriteString("dohupgrade=false ")
+	}
 	if p.RunSSH {
 		sb.WriteString("ssh=true ")
 	}
@@ -585,6 +612,8 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.InternalExitNodePrior == p2.InternalExitNodePrior &&
 		p.ExitNodeAllowLANAccess == p2.ExitNodeAllowLANAccess &&
 		p.CorpDNS == p2.CorpDNS &&
+		p.DNSOverride == p2.DNSOverride &&
+		p.NoDoHUpgrade == p2.NoDoHUpgrade &&
 		p.RunSSH == p2.RunSSH &&
 		p.RunWebClient == p2.RunWebClient &&
 		p.WantRunning == p2.WantRunning &&
@@ -604,6 +633,7 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.AutoUpdate.Equals(p2.AutoUpdate) &&
 		p.AppConnector == p2.AppConnector &&
 		p.PostureChecking == p2.PostureChecking &&
+		p.HideExperimentalFeatures == p2.HideExperimentalFeatures &&
 		slices.EqualFunc(p.DriveShares, p2.DriveShares, drive.SharesEqual) &&
 		p.NetfilterKind == p2.NetfilterKind
 }
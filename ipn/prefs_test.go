@@ -44,6 +44,8 @@ func TestPrefsEqual(t *testing.T) {
 		"InternalExitNodePrior",
 		"ExitNodeAllowLANAccess",
 		"CorpDNS",
+		"DNSOverride",
+		"NoDoHUpgrade",
 		"RunSSH",
 		"RunWebClient",
 		"WantRunning",
@@ -63,6 +65,7 @@ func TestPrefsEqual(t *testing.T) {
 		"AutoUpdate",
 		"AppConnector",
 		"PostureChecking",
+		"HideExperimentalFeatures",
 		"NetfilterKind",
 		"DriveShares",
 		"Persist",
@@ -178,6 +181,21 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{CorpDNS: true},
 			true,
 		},
+		{
+			&Prefs{DNSOverride: preftype.DNSOverrideLocalResolvers},
+			&Prefs{DNSOverride: preftype.DNSOverrideLocalResolvers},
+			true,
+		},
+		{
+			&Prefs{DNSOverride: preftype.DNSOverrideLocalResolvers},
+			&Prefs{DNSOverride: preftype.DNSOverrideIgnore},
+			false,
+		},
+		{
+			&Prefs{NoDoHUpgrade: true},
+			&Prefs{NoDoHUpgrade: false},
+			false,
+		},
 
 		{
 			&Prefs{WantRunning: true},
@@ -332,6 +350,16 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{PostureChecking: false},
 			false,
 		},
+		{
+			&Prefs{HideExperimentalFeatures: true},
+			&Prefs{HideExperimentalFeatures: true},
+			true,
+		},
+		{
+			&Prefs{HideExperimentalFeatures: true},
+			&Prefs{HideExperimentalFeatures: false},
+			false,
+		},
 		{
 			&Prefs{NetfilterKind: "iptables"},
 			&Prefs{NetfilterKind: "iptables"},
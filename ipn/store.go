@@ -58,6 +58,11 @@
 	// has ever been received (even if partially).
 	// Any non-empty value indicates that at least one file has been received.
 	TaildropReceivedKey = StateKey("_taildrop-received")
+
+	// PostureAttestationStateKey is the key under which we cache the
+	// latest device posture attestation, as a JSON-encoded
+	// posture.Attestation signed with a key derived from the machine key.
+	PostureAttestationStateKey = StateKey("_posture-attestation")
 )
 
 // CurrentProfileID returns the StateKey that stores the
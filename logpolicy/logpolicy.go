@@ -566,6 +566,14 @@ func NewWithConfigPath(collection, dir, cmdName string, netMon *netmon.Monitor,
 		conf.IncludeProcSequence = true
 	}
 
+	// Let users keep verbose logs from using up the data allowance of
+	// metered connections, such as phone hotspots. These only take effect
+	// if the caller can detect such networks; see Logger.SetIsMetered.
+	conf.DeferUploadsWhenMetered = envknob.Bool("TS_LOGTAIL_DEFER_WHEN_METERED")
+	if kb, ok := envknob.LookupInt("TS_LOGTAIL_METERED_MAX_KB_PER_MIN"); ok && kb > 0 {
+		conf.MeteredMaxBytesPerMinute = kb << 10
+	}
+
 	if envknob.NoLogsNoSupport() || testenv.InTest() {
 		logf("You have disabled logging. Tailscale will not be able to provide support.")
 		conf.HTTPC = &http.Client{Transport: noopPretendSuccessTransport{}}
@@ -29,6 +29,7 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/sockstats"
 	"tailscale.com/net/tsaddr"
+	"tailscale.com/syncs"
 	"tailscale.com/tstime"
 	tslogger "tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -92,6 +93,25 @@ type Config struct {
 	// If nil, a default value is used. (currently 2 seconds)
 	FlushDelayFn func() time.Duration
 
+	// DeferUploadsWhenMetered, if true, holds log uploads while the
+	// network is metered (see IsMetered) until it no longer is. Logs
+	// accumulate in Buffer meanwhile, so a persistent Buffer such as
+	// filch should be used to avoid losing them.
+	DeferUploadsWhenMetered bool
+
+	// MeteredMaxBytesPerMinute, if positive, limits log uploads while the
+	// network is metered to about this many bytes (as sent, after any
+	// compression) per minute. A single upload larger than the limit is
+	// still sent, at most once a minute.
+	MeteredMaxBytesPerMinute int
+
+	// IsMetered reports whether the network is currently metered. It's
+	// only consulted if DeferUploadsWhenMetered or MeteredMaxBytesPerMinute
+	// is set, and neither has any effect without it, as nothing else
+	// detects metered networks. It may also be set later, with
+	// Logger.SetIsMetered.
+	IsMetered func() bool
+
 	// IncludeProcID, if true, results in an ephemeral process identifier being
 	// included in logs. The ID is random and not guaranteed to be globally
 	// unique, but it can be used to distinguish between different instances
@@ -164,6 +184,9 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 		clock:          cfg.Clock,
 		metricsDelta:   cfg.MetricsDelta,
 
+		deferWhenMetered: cfg.DeferUploadsWhenMetered,
+		meteredBudget:    cfg.MeteredMaxBytesPerMinute,
+
 		procID:              procID,
 		includeProcSequence: cfg.IncludeProcSequence,
 
@@ -171,6 +194,7 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 		shutdownDone:  make(chan struct{}),
 	}
 	l.SetSockstatsLabel(sockstats.LabelLogtailLogger)
+	l.SetIsMetered(cfg.IsMetered)
 	l.compressLogs = cfg.CompressLogs
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -206,6 +230,13 @@ type Logger struct {
 	httpDoCalls    atomic.Int32
 	sockstatsLabel atomicSocktatsLabel
 
+	deferWhenMetered   bool
+	meteredBudget      int                            // max bytes per minute when metered, or 0 for no limit
+	isMeteredFn        syncs.AtomicValue[func() bool] // or nil if metered networks aren't detected
+	meteredWindowStart time.Time                      // owned by uploading
+	meteredWindowBytes int                            // owned by uploading
+	explainedMetered   bool                           // owned by uploading
+
 	procID              uint32
 	includeProcSequence bool
 
@@ -240,6 +271,13 @@ func (l *Logger) SetNetMon(lm *netmon.Monitor) {
 	l.netMonitor = lm
 }
 
+// SetIsMetered sets the func that reports whether the network is metered,
+// replacing Config.IsMetered, for callers that can only detect metered
+// networks once the logger is running.
+func (l *Logger) SetIsMetered(f func() bool) {
+	l.isMeteredFn.Store(f)
+}
+
 // SetSockstatsLabel sets the label used in sockstat logs to identify network traffic from this logger.
 func (l *Logger) SetSockstatsLabel(label sockstats.Label) {
 	l.sockstatsLabel.Store(label)
@@ -415,6 +453,9 @@ func (l *Logger) uploading(ctx context.Context) {
 		var numFailures int
 		var firstFailure time.Time
 		for len(body) > 0 && ctx.Err() == nil {
+			if !l.awaitMeteredUpload(ctx, len(body)) {
+				break
+			}
 			retryAfter, err := l.upload(ctx, body, origlen)
 			if err != nil {
 				numFailures++
@@ -439,6 +480,7 @@ func (l *Logger) uploading(ctx context.Context) {
 				}
 				tstime.Sleep(ctx, retryAfter)
 			} else {
+				l.noteUploaded(len(body))
 				// Only print a success message after recovery.
 				if numFailures > 0 {
 					fmt.Fprintf(l.stderr, "logtail: upload succeeded after %d failures and %s\n", numFailures, l.clock.Since(firstFailure).Round(time.Second))
@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tailscale.com/net/netmon"
+)
+
+// meteredRecheckInterval is how often uploads deferred on a metered
+// network check whether it's still metered, in case nothing notifies them
+// of the change.
+var meteredRecheckInterval = 30 * time.Second
+
+// meteredBudgetWindow is the period over which Config.MeteredMaxBytesPerMinute
+// is enforced.
+const meteredBudgetWindow = time.Minute
+
+// isMetered reports whether the network is metered, for the purposes of
+// the metered upload policy. Without an IsMetered func, it never is.
+func (l *Logger) isMetered() bool {
+	f := l.isMeteredFn.Load()
+	if f == nil {
+		if !l.explainedMetered {
+			fmt.Fprintf(l.stderr, "logtail: metered upload limits are set, but metered networks aren't detected here; ignoring them\n")
+			l.explainedMetered = true
+		}
+		return false
+	}
+	return f()
+}
+
+// meteredWait returns how long to wait before uploading n bytes, per l's
+// metered upload policy, or 0 to upload them now. It reports deferred if
+// uploads are being held until the network is no longer metered.
+func (l *Logger) meteredWait(n int) (wait time.Duration, deferred bool) {
+	if !l.deferWhenMetered && l.meteredBudget <= 0 {
+		return 0, false
+	}
+	if !l.isMetered() {
+		return 0, false
+	}
+	if l.deferWhenMetered {
+		return meteredRecheckInterval, true
+	}
+	now := l.clock.Now()
+	if now.Sub(l.meteredWindowStart) >= meteredBudgetWindow {
+		l.meteredWindowStart = now
+		l.meteredWindowBytes = 0
+	}
+	if l.meteredWindowBytes == 0 || l.meteredWindowBytes+n <= l.meteredBudget {
+		return 0, false
+	}
+	return l.meteredWindowStart.Add(meteredBudgetWindow).Sub(now), false
+}
+
+// awaitMeteredUpload blocks until uploading n bytes is allowed by l's
+// metered upload policy. It reports whether to go ahead with the upload,
+// which is false if ctx is done. If the logger starts shutting down while
+// waiting, the upload goes ahead regardless, as its logs have already been
+// taken from the Buffer and would otherwise be lost.
+func (l *Logger) awaitMeteredUpload(ctx context.Context, n int) bool {
+	var notedWait bool
+	for {
+		wait, deferred := l.meteredWait(n)
+		if wait <= 0 {
+			if notedWait {
+				fmt.Fprintf(l.stderr, "logtail: network no longer metered; resuming uploads\n")
+			}
+			return true
+		}
+		if deferred && !notedWait {
+			fmt.Fprintf(l.stderr, "logtail: network is metered; deferring uploads\n")
+			notedWait = true
+		}
+
+		changed := make(chan struct{}, 1)
+		unregister := func() {}
+		if l.netMonitor != nil {
+			// Recheck on link changes, which are likely to be when
+			// the network stops being metered.
+			unregister = l.netMonitor.RegisterChangeCallback(func(*netmon.ChangeDelta) {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			})
+		}
+		timer, timerC := l.clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+		case <-l.shutdownStart:
+		case <-changed:
+		case <-timerC:
+		}
+		timer.Stop()
+		unregister()
+
+		if ctx.Err() != nil {
+			return false
+		}
+		select {
+		case <-l.shutdownStart:
+			// Don't hold up shutdown, even while uploads are
+			// deferred: flush what's been drained.
+			return true
+		default:
+		}
+	}
+}
+
+// noteUploaded records that n bytes were uploaded, counting them against
+// the metered upload budget if the network is metered.
+func (l *Logger) noteUploaded(n int) {
+	if l.meteredBudget <= 0 || !l.isMetered() {
+		return
+	}
+	now := l.clock.Now()
+	if now.Sub(l.meteredWindowStart) >= meteredBudgetWindow {
+		l.meteredWindowStart = now
+		l.meteredWindowBytes = 0
+	}
+	l.meteredWindowBytes += n
+}
@@ -0,0 +1,132 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest"
+)
+
+// newMeteredTestLogger returns a Logger using cfg (with BaseURL, Clock and
+// FlushDelayFn filled in) and a channel of the bodies it uploads.
+func newMeteredTestLogger(t *testing.T, cfg Config) (*Logger, *tstest.Clock, <-chan string) {
+	uploaded := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		uploaded <- string(body)
+	}))
+	t.Cleanup(srv.Close)
+
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	cfg.BaseURL = srv.URL
+	cfg.Clock = clock
+	cfg.FlushDelayFn = func() time.Duration { return 0 }
+	cfg.Stderr = io.Discard
+	l := NewLogger(cfg, t.Logf)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		l.Shutdown(ctx)
+	})
+	return l, clock, uploaded
+}
+
+func expectUpload(t *testing.T, uploaded <-chan string, want string) {
+	t.Helper()
+	select {
+	case body := <-uploaded:
+		if !strings.Contains(body, want) {
+			t.Fatalf("uploaded %q; want it to contain %q", body, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for upload of %q", want)
+	}
+}
+
+func expectNoUpload(t *testing.T, uploaded <-chan string) {
+	t.Helper()
+	select {
+	case body := <-uploaded:
+		t.Fatalf("unexpected upload %q", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMeteredUploadBudget(t *testing.T) {
+	l, clock, uploaded := newMeteredTestLogger(t, Config{
+		MeteredMaxBytesPerMinute: 1,
+		IsMetered:                func() bool { return true },
+	})
+
+	// The first upload in a window is always allowed.
+	expectUpload(t, uploaded, "logtail started")
+
+	l.Write([]byte("over budget"))
+	expectNoUpload(t, uploaded)
+	clock.Advance(time.Minute)
+	expectUpload(t, uploaded, "over budget")
+}
+
+func TestMeteredUploadBudgetUnmetered(t *testing.T) {
+	l, _, uploaded := newMeteredTestLogger(t, Config{
+		MeteredMaxBytesPerMinute: 1,
+		IsMetered:                func() bool { return false },
+	})
+	expectUpload(t, uploaded, "logtail started")
+	l.Write([]byte("not metered"))
+	expectUpload(t, uploaded, "not metered")
+}
+
+func TestDeferUploadsWhenMetered(t *testing.T) {
+	var metered atomic.Bool
+	metered.Store(true)
+	_, clock, uploaded := newMeteredTestLogger(t, Config{
+		DeferUploadsWhenMetered: true,
+		IsMetered:               metered.Load,
+	})
+
+	expectNoUpload(t, uploaded)
+	clock.Advance(meteredRecheckInterval)
+	expectNoUpload(t, uploaded)
+
+	metered.Store(false)
+	clock.Advance(meteredRecheckInterval)
+	expectUpload(t, uploaded, "logtail started")
+}
+
+func TestDeferredUploadFlushedOnShutdown(t *testing.T) {
+	l, _, uploaded := newMeteredTestLogger(t, Config{
+		DeferUploadsWhenMetered: true,
+		IsMetered:               func() bool { return true },
+	})
+	expectNoUpload(t, uploaded)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go l.Shutdown(ctx)
+	expectUpload(t, uploaded, "logtail started")
+}
+
+func TestSetIsMetered(t *testing.T) {
+	l, clock, uploaded := newMeteredTestLogger(t, Config{
+		DeferUploadsWhenMetered: true,
+	})
+	// Without a way to detect metered networks, nothing is deferred.
+	expectUpload(t, uploaded, "logtail started")
+
+	l.SetIsMetered(func() bool { return true })
+	l.Write([]byte("metered"))
+	expectNoUpload(t, uploaded)
+	l.SetIsMetered(func() bool { return false })
+	clock.Advance(meteredRecheckInterval)
+	expectUpload(t, uploaded, "metered")
+}
@@ -13,6 +13,7 @@
 	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/types/dnstype"
+	"tailscale.com/types/preftype"
 	"tailscale.com/util/dnsname"
 )
 
@@ -44,6 +45,13 @@ type Config struct {
 	// OnlyIPv6, if true, uses the IPv6 service IP (for MagicDNS)
 	// instead of the IPv4 version (100.100.100.100).
 	OnlyIPv6 bool
+	// Override is the user's local override of the rest of this
+	// config, which comes from the tailnet. The Manager applies it.
+	Override preftype.DNSOverride
+	// NoDoHUpgrade, if true, stops the quad-100 forwarder from
+	// upgrading well-known public resolvers to their DoH endpoints.
+	// Resolvers that only speak DoH are still queried over it.
+	NoDoHUpgrade bool
 }
 
 func (c *Config) serviceIP() netip.Addr {
@@ -64,6 +72,12 @@ func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 
 	fmt.Fprintf(w, " SearchDomains:%v", c.SearchDomains)
 	fmt.Fprintf(w, " Hosts:%v", len(c.Hosts))
+	if c.Override != preftype.DNSOverrideNone {
+		fmt.Fprintf(w, " Override:%v", c.Override)
+	}
+	if c.NoDoHUpgrade {
+		w.WriteString(" NoDoHUpgrade")
+	}
 	w.WriteString("}")
 }
 
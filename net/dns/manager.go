@@ -8,6 +8,7 @@
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/netip"
@@ -24,6 +25,7 @@
 	"tailscale.com/net/tsdial"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/preftype"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/dnsname"
 )
@@ -81,6 +83,7 @@ func (m *Manager) Set(cfg Config) error {
 	m.logf("Set: %v", logger.ArgWriter(func(w *bufio.Writer) {
 		cfg.WriteToBufioWriter(w)
 	}))
+	cfg = m.applyOverride(cfg)
 
 	rcfg, ocfg, err := m.compileConfig(cfg)
 	if err != nil {
@@ -106,6 +109,50 @@ func (m *Manager) Set(cfg Config) error {
 	return nil
 }
 
+var warnDNSOverride = health.NewWarnable()
+
+// applyOverride returns cfg without the parts of the tailnet's DNS
+// configuration that cfg.Override says to ignore, and reports the
+// override as a health warning so that it's visible in status output.
+func (m *Manager) applyOverride(cfg Config) Config {
+	var ignored int
+	switch cfg.Override {
+	case preftype.DNSOverrideNone:
+		m.health.SetWarnable(warnDNSOverride, nil)
+		return cfg
+	case preftype.DNSOverrideLocalResolvers:
+		// Keep the routes without resolvers, which are the MagicDNS
+		// and other domains that 100.100.100.100 answers itself.
+		ignored = len(cfg.DefaultResolvers)
+		routes := make(map[dnsname.FQDN][]*dnstype.Resolver)
+		for suffix, resolvers := range cfg.Routes {
+			if len(resolvers) == 0 {
+				routes[suffix] = resolvers
+			} else {
+				ignored += len(resolvers)
+			}
+		}
+		cfg.DefaultResolvers = nil
+		cfg.Routes = routes
+	case preftype.DNSOverrideIgnore:
+		ignored = len(cfg.DefaultResolvers)
+		for _, resolvers := range cfg.Routes {
+			ignored += len(resolvers)
+		}
+		cfg = Config{
+			Hosts:    cfg.Hosts,
+			OnlyIPv6: cfg.OnlyIPv6,
+			Override: cfg.Override,
+		}
+	default:
+		m.logf("unknown DNS override %q; ignoring", cfg.Override)
+		m.health.SetWarnable(warnDNSOverride, nil)
+		return cfg
+	}
+	m.health.SetWarnable(warnDNSOverride, fmt.Errorf("DNS override %q is set; ignoring %d DNS resolvers from the tailnet and using this device's own. Use 'tailscale set --dns-override=off' to undo.", cfg.Override, ignored))
+	return cfg
+}
+
 // compileHostEntries creates a list of single-label resolutions possible
 // from the configured hosts and search domains.
 // The entries are compiled in the order of the search domains, then the hosts.
@@ -155,6 +202,7 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// authoritative suffixes, even if we don't propagate MagicDNS to
 	// the OS.
 	rcfg.Hosts = cfg.Hosts
+	rcfg.NoDoHUpgrade = cfg.NoDoHUpgrade
 	routes := map[dnsname.FQDN][]*dnstype.Resolver{} // assigned conditionally to rcfg.Routes below.
 	for suffix, resolvers := range cfg.Routes {
 		if len(resolvers) == 0 {
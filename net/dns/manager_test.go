@@ -11,10 +11,12 @@
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"tailscale.com/health"
 	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/types/dnstype"
+	"tailscale.com/types/preftype"
 	"tailscale.com/util/dnsname"
 )
 
@@ -154,6 +156,56 @@ func TestCompileHostEntries(t *testing.T) {
 	}
 }
 
+func TestApplyOverride(t *testing.T) {
+	base := Config{
+		DefaultResolvers: mustRes("1.1.1.1", "9.9.9.9"),
+		Routes:           upstreams("ts.com", "", "corp.com", "2.2.2.2"),
+		Hosts:            hosts("dave.ts.com.", "1.2.3.4"),
+		SearchDomains:    fqdns("ts.com", "corp.com"),
+	}
+	tests := []struct {
+		name     string
+		override preftype.DNSOverride
+		want     Config
+	}{
+		{
+			name:     "none",
+			override: preftype.DNSOverrideNone,
+			want:     base,
+		},
+		{
+			name:     "local-resolvers",
+			override: preftype.DNSOverrideLocalResolvers,
+			want: Config{
+				Routes:        upstreams("ts.com", ""),
+				Hosts:         hosts("dave.ts.com.", "1.2.3.4"),
+				SearchDomains: fqdns("ts.com", "corp.com"),
+				Override:      preftype.DNSOverrideLocalResolvers,
+			},
+		},
+		{
+			name:     "ignore",
+			override: preftype.DNSOverrideIgnore,
+			want: Config{
+				Hosts:    hosts("dave.ts.com.", "1.2.3.4"),
+				Override: preftype.DNSOverrideIgnore,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manager{logf: t.Logf, health: new(health.Tracker)}
+			cfg := base
+			cfg.Override = tt.override
+			got := m.applyOverride(cfg)
+			trIP := cmp.Transformer("ipStr", func(ip netip.Addr) string { return ip.String() })
+			if diff := cmp.Diff(got, tt.want, trIP, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("wrong config (-got+want):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestManager(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		t.Skipf("test's assumptions break because of https://github.com/tailscale/corp/issues/1662")
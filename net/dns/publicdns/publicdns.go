@@ -24,6 +24,7 @@
 var dohOfIP = map[netip.Addr]string{} // 8.8.8.8 => "https://..."
 
 var dohIPsOfBase = map[string][]netip.Addr{}
+var providerOfBase = map[string]string{} // DoH base URL => provider name
 var populateOnce sync.Once
 
 const (
@@ -31,14 +32,40 @@
 	controlDBase = "https://dns.controld.com/"
 )
 
-// DoHEndpointFromIP returns the DNS-over-HTTPS base URL for a given IP
-// and whether it's DoH-only (not speaking DNS on port 53).
+// Provider is a public DNS provider that can be reached over DoH.
+type Provider struct {
+	Name string // like "Cloudflare" or "NextDNS"
+
+	// DoHTemplate is the DoH URL of the provider's service. For providers
+	// that give each customer their own configuration, like NextDNS, it
+	// contains "{id}", which DoHURL replaces with the configuration's ID.
+	DoHTemplate string
+}
+
+var (
+	nextDNS  = Provider{Name: "NextDNS", DoHTemplate: nextDNSBase + "{id}"}
+	controlD = Provider{Name: "Control D", DoHTemplate: controlDBase + "{id}"}
+)
+
+// HasID reports whether p gives each customer their own configuration,
+// identified by an ID in its DoH URL.
+func (p Provider) HasID() bool { return strings.Contains(p.DoHTemplate, "{id}") }
+
+// DoHURL returns the DoH URL of p's configuration with the given ID, or
+// of its only service if !p.HasID.
+func (p Provider) DoHURL(id string) string {
+	return strings.Replace(p.DoHTemplate, "{id}", id, 1)
+}
+
+// ProviderOfIP returns the public DNS provider that runs the DNS server
+// ip, and for providers with per-customer configurations, the ID of the
+// configuration that ip serves.
 //
 // The ok result is whether the IP is a known DNS server.
-func DoHEndpointFromIP(ip netip.Addr) (dohBase string, dohOnly bool, ok bool) {
+func ProviderOfIP(ip netip.Addr) (p Provider, id string, ok bool) {
 	populateOnce.Do(populate)
 	if b, ok := dohOfIP[ip]; ok {
-		return b, false, true
+		return Provider{Name: providerOfBase[b], DoHTemplate: b}, "", true
 	}
 
 	// NextDNS DoH URLs are of the form "https://dns.nextdns.io/c3a884"
@@ -47,22 +74,31 @@ func DoHEndpointFromIP(ip netip.Addr) (dohBase string, dohOnly bool, ok bool) {
 	if nextDNSv6RangeA.Contains(ip) || nextDNSv6RangeB.Contains(ip) {
 		a := ip.As16()
 		var sb strings.Builder
-		sb.Grow(len(nextDNSBase) + 12)
-		sb.WriteString(nextDNSBase)
 		for _, b := range bytes.TrimLeft(a[4:], "\x00") {
 			fmt.Fprintf(&sb, "%02x", b)
 		}
-		return sb.String(), true, true
+		return nextDNS, sb.String(), true
 	}
 
 	// Control D DoH URLs are of the form "https://dns.controld.com/8yezwenugs"
 	// where the path component is represented by 8 bytes (7-14) of the IPv6 address in base36
 	if controlDv6RangeA.Contains(ip) || controlDv6RangeB.Contains(ip) {
-		path := big.NewInt(0).SetBytes(ip.AsSlice()[6:14]).Text(36)
-		return controlDBase + path, true, true
+		return controlD, big.NewInt(0).SetBytes(ip.AsSlice()[6:14]).Text(36), true
 	}
 
-	return "", false, false
+	return Provider{}, "", false
+}
+
+// DoHEndpointFromIP returns the DNS-over-HTTPS base URL for a given IP
+// and whether it's DoH-only (not speaking DNS on port 53).
+//
+// The ok result is whether the IP is a known DNS server.
+func DoHEndpointFromIP(ip netip.Addr) (dohBase string, dohOnly bool, ok bool) {
+	p, id, ok := ProviderOfIP(ip)
+	if !ok {
+		return "", false, false
+	}
+	return p.DoHURL(id), p.HasID(), true
 }
 
 // KnownDoHPrefixes returns the list of DoH base URLs.
@@ -144,12 +180,16 @@ func DoHV6(base string) (ip netip.Addr, ok bool) {
 	return ip, false
 }
 
-// addDoH parses a given well-formed ip string into a netip.Addr type and
-// adds it to both knownDoH and dohIPsOFBase maps.
-func addDoH(ipStr, base string) {
-	ip := netip.MustParseAddr(ipStr)
-	dohOfIP[ip] = base
-	dohIPsOfBase[base] = append(dohIPsOfBase[base], ip)
+// addDoH adds the DoH base URL of one of the services of the provider
+// named name, and the well-formed IP strings of its plain DNS servers, to
+// the dohOfIP, dohIPsOfBase and providerOfBase maps.
+func addDoH(name, base string, ipStrs ...string) {
+	providerOfBase[base] = name
+	for _, ipStr := range ipStrs {
+		ip := netip.MustParseAddr(ipStr)
+		dohOfIP[ip] = base
+		dohIPsOfBase[base] = append(dohIPsOfBase[base], ip)
+	}
 }
 
 const (
@@ -157,115 +197,95 @@ func addDoH(ipStr, base string) {
 	wikimediaDNSv6 = "2001:67c:930::1"
 )
 
-// populate is called once to initialize the knownDoH and dohIPsOfBase maps.
+// populate is called once to initialize the dohOfIP, dohIPsOfBase and
+// providerOfBase maps.
 func populate() {
 	// Cloudflare
 	// https://developers.cloudflare.com/1.1.1.1/ip-addresses/
-	addDoH("1.1.1.1", "https://cloudflare-dns.com/dns-query")
-	addDoH("1.0.0.1", "https://cloudflare-dns.com/dns-query")
-	addDoH("2606:4700:4700::1111", "https://cloudflare-dns.com/dns-query")
-	addDoH("2606:4700:4700::1001", "https://cloudflare-dns.com/dns-query")
+	addDoH("Cloudflare", "https://cloudflare-dns.com/dns-query",
+		"1.1.1.1", "1.0.0.1", "2606:4700:4700::1111", "2606:4700:4700::1001")
 
 	// Cloudflare -Malware
-	addDoH("1.1.1.2", "https://security.cloudflare-dns.com/dns-query")
-	addDoH("1.0.0.2", "https://security.cloudflare-dns.com/dns-query")
-	addDoH("2606:4700:4700::1112", "https://security.cloudflare-dns.com/dns-query")
-	addDoH("2606:4700:4700::1002", "https://security.cloudflare-dns.com/dns-query")
+	addDoH("Cloudflare", "https://security.cloudflare-dns.com/dns-query",
+		"1.1.1.2", "1.0.0.2", "2606:4700:4700::1112", "2606:4700:4700::1002")
 
 	// Cloudflare -Malware -Adult
-	addDoH("1.1.1.3", "https://family.cloudflare-dns.com/dns-query")
-	addDoH("1.0.0.3", "https://family.cloudflare-dns.com/dns-query")
-	addDoH("2606:4700:4700::1113", "https://family.cloudflare-dns.com/dns-query")
-	addDoH("2606:4700:4700::1003", "https://family.cloudflare-dns.com/dns-query")
+	addDoH("Cloudflare", "https://family.cloudflare-dns.com/dns-query",
+		"1.1.1.3", "1.0.0.3", "2606:4700:4700::1113", "2606:4700:4700::1003")
 
 	// Google
-	addDoH("8.8.8.8", "https://dns.google/dns-query")
-	addDoH("8.8.4.4", "https://dns.google/dns-query")
-	addDoH("2001:4860:4860::8888", "https://dns.google/dns-query")
-	addDoH("2001:4860:4860::8844", "https://dns.google/dns-query")
+	addDoH("Google", "https://dns.google/dns-query",
+		"8.8.8.8", "8.8.4.4", "2001:4860:4860::8888", "2001:4860:4860::8844")
 
 	// OpenDNS
 	// TODO(bradfitz): OpenDNS is unique amongst this current set in that
 	// its DoH DNS names resolve to different IPs than its normal DNS
 	// IPs. Support that later. For now we assume that they're the same.
-	// addDoH("208.67.222.222", "https://doh.opendns.com/dns-query")
-	// addDoH("208.67.220.220", "https://doh.opendns.com/dns-query")
-	// addDoH("208.67.222.123", "https://doh.familyshield.opendns.com/dns-query")
-	// addDoH("208.67.220.123", "https://doh.familyshield.opendns.com/dns-query")
+	// addDoH("OpenDNS", "https://doh.opendns.com/dns-query", "208.67.222.222", "208.67.220.220")
+	// addDoH("OpenDNS", "https://doh.familyshield.opendns.com/dns-query", "208.67.222.123", "208.67.220.123")
 
 	// Quad9
 	// https://www.quad9.net/service/service-addresses-and-features
-	addDoH("9.9.9.9", "https://dns.quad9.net/dns-query")
-	addDoH("149.112.112.112", "https://dns.quad9.net/dns-query")
-	addDoH("2620:fe::fe", "https://dns.quad9.net/dns-query")
-	addDoH("2620:fe::9", "https://dns.quad9.net/dns-query")
+	addDoH("Quad9", "https://dns.quad9.net/dns-query",
+		"9.9.9.9", "149.112.112.112", "2620:fe::fe", "2620:fe::9")
 
 	// Quad9 +ECS +DNSSEC
-	addDoH("9.9.9.11", "https://dns11.quad9.net/dns-query")
-	addDoH("149.112.112.11", "https://dns11.quad9.net/dns-query")
-	addDoH("2620:fe::11", "https://dns11.quad9.net/dns-query")
-	addDoH("2620:fe::fe:11", "https://dns11.quad9.net/dns-query")
+	addDoH("Quad9", "https://dns11.quad9.net/dns-query",
+		"9.9.9.11", "149.112.112.11", "2620:fe::11", "2620:fe::fe:11")
 
 	// Quad9 -DNSSEC
-	addDoH("9.9.9.10", "https://dns10.quad9.net/dns-query")
-	addDoH("149.112.112.10", "https://dns10.quad9.net/dns-query")
-	addDoH("2620:fe::10", "https://dns10.quad9.net/dns-query")
-	addDoH("2620:fe::fe:10", "https://dns10.quad9.net/dns-query")
+	addDoH("Quad9", "https://dns10.quad9.net/dns-query",
+		"9.9.9.10", "149.112.112.10", "2620:fe::10", "2620:fe::fe:10")
 
 	// Mullvad
 	// See https://mullvad.net/en/help/dns-over-https-and-dns-over-tls/
 	// Mullvad (default)
-	addDoH("194.242.2.2", "https://dns.mullvad.net/dns-query")
-	addDoH("2a07:e340::2", "https://dns.mullvad.net/dns-query")
+	addDoH("Mullvad", "https://dns.mullvad.net/dns-query", "194.242.2.2", "2a07:e340::2")
 	// Mullvad (adblock)
-	addDoH("194.242.2.3", "https://adblock.dns.mullvad.net/dns-query")
-	addDoH("2a07:e340::3", "https://adblock.dns.mullvad.net/dns-query")
+	addDoH("Mullvad", "https://adblock.dns.mullvad.net/dns-query", "194.242.2.3", "2a07:e340::3")
 	// Mullvad (base)
-	addDoH("194.242.2.4", "https://base.dns.mullvad.net/dns-query")
-	addDoH("2a07:e340::4", "https://base.dns.mullvad.net/dns-query")
+	addDoH("Mullvad", "https://base.dns.mullvad.net/dns-query", "194.242.2.4", "2a07:e340::4")
 	// Mullvad (extended)
-	addDoH("194.242.2.5", "https://extended.dns.mullvad.net/dns-query")
-	addDoH("2a07:e340::5", "https://extended.dns.mullvad.net/dns-query")
+	addDoH("Mullvad", "https://extended.dns.mullvad.net/dns-query", "194.242.2.5", "2a07:e340::5")
 	// Mullvad (family)
-	addDoH("194.242.2.6", "https://family.dns.mullvad.net/dns-query")
-	addDoH("2a07:e340::6", "https://family.dns.mullvad.net/dns-query")
+	addDoH("Mullvad", "https://family.dns.mullvad.net/dns-query", "194.242.2.6", "2a07:e340::6")
 	// Mullvad (all)
-	addDoH("194.242.2.9", "https://all.dns.mullvad.net/dns-query")
-	addDoH("2a07:e340::9", "https://all.dns.mullvad.net/dns-query")
+	addDoH("Mullvad", "https://all.dns.mullvad.net/dns-query", "194.242.2.9", "2a07:e340::9")
 
 	// Wikimedia
-	addDoH(wikimediaDNSv4, "https://wikimedia-dns.org/dns-query")
-	addDoH(wikimediaDNSv6, "https://wikimedia-dns.org/dns-query")
+	addDoH("Wikimedia", "https://wikimedia-dns.org/dns-query", wikimediaDNSv4, wikimediaDNSv6)
+
+	// AdGuard DNS
+	// https://adguard-dns.io/kb/general/dns-providers/
+	// AdGuard (default: -Ads -Trackers)
+	addDoH("AdGuard", "https://dns.adguard-dns.com/dns-query",
+		"94.140.14.14", "94.140.15.15", "2a10:50c0::ad1:ff", "2a10:50c0::ad2:ff")
+	// AdGuard (family: -Ads -Trackers -Adult)
+	addDoH("AdGuard", "https://family.adguard-dns.com/dns-query",
+		"94.140.14.15", "94.140.15.16", "2a10:50c0::bad1:ff", "2a10:50c0::bad2:ff")
+	// AdGuard (unfiltered)
+	addDoH("AdGuard", "https://unfiltered.adguard-dns.com/dns-query",
+		"94.140.14.140", "94.140.14.141", "2a10:50c0::1:ff", "2a10:50c0::2:ff")
 
 	// Control D
-	addDoH("76.76.2.0", "https://freedns.controld.com/p0")
-	addDoH("76.76.10.0", "https://freedns.controld.com/p0")
-	addDoH("2606:1a40::", "https://freedns.controld.com/p0")
-	addDoH("2606:1a40:1::", "https://freedns.controld.com/p0")
+	addDoH("Control D", "https://freedns.controld.com/p0",
+		"76.76.2.0", "76.76.10.0", "2606:1a40::", "2606:1a40:1::")
 
 	// Control D -Malware
-	addDoH("76.76.2.1", "https://freedns.controld.com/p1")
-	addDoH("76.76.10.1", "https://freedns.controld.com/p1")
-	addDoH("2606:1a40::1", "https://freedns.controld.com/p1")
-	addDoH("2606:1a40:1::1", "https://freedns.controld.com/p1")
+	addDoH("Control D", "https://freedns.controld.com/p1",
+		"76.76.2.1", "76.76.10.1", "2606:1a40::1", "2606:1a40:1::1")
 
 	// Control D -Malware + Ads
-	addDoH("76.76.2.2", "https://freedns.controld.com/p2")
-	addDoH("76.76.10.2", "https://freedns.controld.com/p2")
-	addDoH("2606:1a40::2", "https://freedns.controld.com/p2")
-	addDoH("2606:1a40:1::2", "https://freedns.controld.com/p2")
+	addDoH("Control D", "https://freedns.controld.com/p2",
+		"76.76.2.2", "76.76.10.2", "2606:1a40::2", "2606:1a40:1::2")
 
 	// Control D -Malware + Ads + Social
-	addDoH("76.76.2.3", "https://freedns.controld.com/p3")
-	addDoH("76.76.10.3", "https://freedns.controld.com/p3")
-	addDoH("2606:1a40::3", "https://freedns.controld.com/p3")
-	addDoH("2606:1a40:1::3", "https://freedns.controld.com/p3")
+	addDoH("Control D", "https://freedns.controld.com/p3",
+		"76.76.2.3", "76.76.10.3", "2606:1a40::3", "2606:1a40:1::3")
 
 	// Control D -Malware + Ads + Adult
-	addDoH("76.76.2.4", "https://freedns.controld.com/family")
-	addDoH("76.76.10.4", "https://freedns.controld.com/family")
-	addDoH("2606:1a40::4", "https://freedns.controld.com/family")
-	addDoH("2606:1a40:1::4", "https://freedns.controld.com/family")
+	addDoH("Control D", "https://freedns.controld.com/family",
+		"76.76.2.4", "76.76.10.4", "2606:1a40::4", "2606:1a40:1::4")
 }
 
 var (
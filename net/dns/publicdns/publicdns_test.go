@@ -142,3 +142,34 @@ func TestDoHIPsOfBase(t *testing.T) {
 		}
 	}
 }
+
+func TestProviderOfIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		name    string
+		id      string
+		wantURL string
+	}{
+		{"9.9.9.9", "Quad9", "", "https://dns.quad9.net/dns-query"},
+		{"2606:4700:4700::1111", "Cloudflare", "", "https://cloudflare-dns.com/dns-query"},
+		{"94.140.14.14", "AdGuard", "", "https://dns.adguard-dns.com/dns-query"},
+		{"2a07:a8c0::c3:a884", "NextDNS", "c3a884", "https://dns.nextdns.io/c3a884"},
+		{"2606:1a40:0:6:7b5b:5949:35ad:0", "Control D", "hyq3ipr2ct", "https://dns.controld.com/hyq3ipr2ct"},
+		{"192.0.2.1", "", "", ""},
+	}
+	for _, tt := range tests {
+		p, id, ok := ProviderOfIP(netip.MustParseAddr(tt.ip))
+		if ok != (tt.name != "") || p.Name != tt.name || id != tt.id {
+			t.Errorf("ProviderOfIP(%s) = %q, %q, %v; want %q, %q", tt.ip, p.Name, id, ok, tt.name, tt.id)
+			continue
+		}
+		if ok {
+			if got := p.DoHURL(id); got != tt.wantURL {
+				t.Errorf("DoHURL for %s = %q; want %q", tt.ip, got, tt.wantURL)
+			}
+			if got, want := p.HasID(), tt.id != ""; got != want {
+				t.Errorf("HasID for %s = %v; want %v", tt.ip, got, want)
+			}
+		}
+	}
+}
@@ -236,7 +236,10 @@ func (f *forwarder) Close() error {
 // resolversWithDelays maps from a set of DNS server names to a slice of a type
 // that included a startDelay, upgrading any well-known DoH (DNS-over-HTTP)
 // servers in the process, insert a DoH lookup first before UDP fallbacks.
-func resolversWithDelays(resolvers []*dnstype.Resolver) []resolverAndDelay {
+//
+// If !upgradeDoH, only servers that don't speak plain DNS at all are
+// replaced by DoH, and the rest are queried over UDP without delay.
+func resolversWithDelays(resolvers []*dnstype.Resolver, upgradeDoH bool) []resolverAndDelay {
 	rr := make([]resolverAndDelay, 0, len(resolvers)+2)
 
 	type dohState uint8
@@ -251,7 +254,7 @@ func resolversWithDelays(resolvers []*dnstype.Resolver) []resolverAndDelay {
 			continue
 		}
 		dohBase, dohOnly, ok := publicdns.DoHEndpointFromIP(ipp.Addr())
-		if !ok || didDoH[dohBase] != 0 {
+		if !ok || didDoH[dohBase] != 0 || !upgradeDoH && !dohOnly {
 			continue
 		}
 		if dohOnly {
@@ -277,7 +280,7 @@ type hostAndFam struct {
 		}
 		ip := ipp.Addr()
 		var startDelay time.Duration
-		if host, _, ok := publicdns.DoHEndpointFromIP(ip); ok {
+		if host, _, ok := publicdns.DoHEndpointFromIP(ip); ok && didDoH[host] != 0 {
 			if didDoH[host] == addedDoHAndDontAddUDP {
 				continue
 			}
@@ -307,17 +310,18 @@ func cloudResolvers() []resolverAndDelay {
 	cloudResolversOnce.Do(func() {
 		if ip := cloudenv.Get().ResolverIP(); ip != "" {
 			cloudResolver := []*dnstype.Resolver{{Addr: ip}}
-			cloudResolversLazy = resolversWithDelays(cloudResolver)
+			cloudResolversLazy = resolversWithDelays(cloudResolver, true)
 		}
 	})
 	return cloudResolversLazy
 }
 
 // setRoutes sets the routes to use for DNS forwarding. It's called by
-// Resolver.SetConfig on reconfig.
+// Resolver.SetConfig on reconfig. Well-known public resolvers are queried
+// over DoH first unless noDoHUpgrade; see resolversWithDelays.
 //
 // The memory referenced by routesBySuffix should not be modified.
-func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolver) {
+func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolver, noDoHUpgrade bool) {
 	routes := make([]route, 0, len(routesBySuffix))
 
 	cloudHostFallback := cloudResolvers()
@@ -330,7 +334,7 @@ func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolve
 		} else {
 			routes = append(routes, route{
 				Suffix:    suffix,
-				Resolvers: resolversWithDelays(rs),
+				Resolvers: resolversWithDelays(rs, !noDoHUpgrade),
 			})
 		}
 	}
@@ -62,9 +62,10 @@ func TestResolversWithDelays(t *testing.T) {
 	}
 
 	tests := []struct {
-		name string
-		in   []*dnstype.Resolver
-		want []resolverAndDelay
+		name      string
+		in        []*dnstype.Resolver
+		noUpgrade bool
+		want      []resolverAndDelay
 	}{
 		{
 			name: "unknown-no-delays",
@@ -111,11 +112,23 @@ func TestResolversWithDelays(t *testing.T) {
 			in:   q("https://dns.controld.com/hyq3ipr2ct"),
 			want: o("https://dns.controld.com/hyq3ipr2ct"),
 		},
+		{
+			name:      "google-no-upgrade",
+			in:        q("8.8.8.8", "2001:4860:4860::8888"),
+			noUpgrade: true,
+			want:      o("8.8.8.8", "2001:4860:4860::8888"),
+		},
+		{
+			name:      "nextdns-no-upgrade-still-doh-only",
+			in:        q("2a07:a8c0::c3:a884", "9.9.9.9"),
+			noUpgrade: true,
+			want:      o("https://dns.nextdns.io/c3a884", "9.9.9.9"),
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := resolversWithDelays(tt.in)
+			got := resolversWithDelays(tt.in, !tt.noUpgrade)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("got %v; want %v", got, tt.want)
 			}
@@ -76,6 +76,9 @@ type Config struct {
 	// LocalDomains is a list of DNS name suffixes that should not be
 	// routed to upstream resolvers.
 	LocalDomains []dnsname.FQDN
+	// NoDoHUpgrade, if true, queries well-known public resolvers that
+	// speak plain DNS over it, rather than upgrading them to DoH.
+	NoDoHUpgrade bool
 }
 
 // WriteToBufioWriter write a debug version of c for logs to w, omitting
@@ -101,6 +104,9 @@ func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 	if arpa > 0 {
 		fmt.Fprintf(w, "+%darpa", arpa)
 	}
+	if c.NoDoHUpgrade {
+		w.WriteString(" NoDoHUpgrade")
+	}
 	if c := cloudenv.Get(); c != "" {
 		fmt.Fprintf(w, ", cloud=%q", string(c))
 	}
@@ -240,7 +246,7 @@ func (r *Resolver) SetConfig(cfg Config) error {
 		}
 	}
 
-	r.forwarder.setRoutes(cfg.Routes)
+	r.forwarder.setRoutes(cfg.Routes, cfg.NoDoHUpgrade)
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -72,7 +72,9 @@ type Resolver struct {
 	TTL time.Duration
 
 	// UseLastGood controls whether a cached entry older than TTL is used
-	// if a refresh fails.
+	// if a refresh fails. It also enables remembering which IPs
+	// connections were last established to, across restarts if
+	// SetCachePath was called, and using those when DNS fails.
 	UseLastGood bool
 
 	// SingleHostStaticResult, if non-nil, is the static result of IPs that is returned
@@ -91,6 +93,8 @@ type Resolver struct {
 
 	sf singleflight.Group[string, ipRes]
 
+	lastGood *lastGood // or nil for globalLastGood
+
 	mu      sync.Mutex
 	ipCache map[string]ipCacheEntry
 }
@@ -108,6 +112,13 @@ type ipCacheEntry struct {
 	expires time.Time
 }
 
+func (r *Resolver) lg() *lastGood {
+	if r.lastGood != nil {
+		return r.lastGood
+	}
+	return globalLastGood
+}
+
 func (r *Resolver) fwd() *net.Resolver {
 	if r.Forward != nil {
 		return r.Forward
@@ -228,6 +239,11 @@ func (r *Resolver) LookupIP(ctx context.Context, host string) (ip, v6 netip.Addr
 					r.dlogf("%q using %v after error", host, ip)
 					return ip, ip6, allIPs, nil
 				}
+				if allIPs := r.lg().ips(host); len(allIPs) > 0 {
+					ip, ip6 := primaryIPs(allIPs)
+					r.dlogf("%q using last connected %v after error", host, ip)
+					return ip, ip6, allIPs, nil
+				}
 			}
 			r.dlogf("error resolving %q: %v", host, res.Err)
 			return zaddr, zaddr, nil, res.Err
@@ -260,7 +276,17 @@ func (r *Resolver) lookupIPCacheExpired(host string) (ip, ip6 netip.Addr, allIPs
 
 func (r *Resolver) lookupTimeoutForHost(host string) time.Duration {
 	if r.UseLastGood {
-		if _, _, _, ok := r.lookupIPCacheExpired(host); ok {
+		_, _, _, ok := r.lookupIPCacheExpired(host)
+		if !ok {
+			ok = len(r.lg().ips(host)) > 0
+		}
+		if ok {
+			if r.lg().dnsFailing(host, time.Now()) {
+				// DNS for this host was just failing, so it
+				// probably still is. Don't make every dial wait
+				// for it.
+				return time.Second
+			}
 			// If we have some previous good value for this host,
 			// don't give this DNS lookup much time. If we're in a
 			// situation where the user's DNS server is unreachable
@@ -300,6 +326,13 @@ func (r *Resolver) lookupIP(host string) (ip, ip6 netip.Addr, allIPs []netip.Add
 		}
 		ips, err = r.LookupIPFallback(ctx, host)
 	}
+	if r.UseLastGood {
+		lookupErr := err
+		if lookupErr == nil && len(ips) == 0 {
+			lookupErr = errNoIPs
+		}
+		r.lg().noteLookup(host, lookupErr, time.Now())
+	}
 	if err != nil {
 		return netip.Addr{}, netip.Addr{}, nil, err
 	}
@@ -312,6 +345,16 @@ func (r *Resolver) lookupIP(host string) (ip, ip6 netip.Addr, allIPs []netip.Add
 		ips[i] = ips[i].Unmap()
 	}
 
+	ip, ip6 = primaryIPs(ips)
+	r.addIPCache(host, ip, ip6, ips, r.ttl())
+	return ip, ip6, ips, nil
+}
+
+var errNoIPs = errors.New("no IPs")
+
+// primaryIPs returns the IP to use from ips, preferring IPv4, and an
+// IPv6 address, if ips contains both IPv4 and IPv6 addresses.
+func primaryIPs(ips []netip.Addr) (ip, ip6 netip.Addr) {
 	have4 := false
 	for _, ipa := range ips {
 		if ipa.Is4() {
@@ -328,8 +371,7 @@ func (r *Resolver) lookupIP(host string) (ip, ip6 netip.Addr, allIPs []netip.Add
 			}
 		}
 	}
-	r.addIPCache(host, ip, ip6, ips, r.ttl())
-	return ip, ip6, ips, nil
+	return ip, ip6
 }
 
 func (r *Resolver) addIPCache(host string, ip, ip6 netip.Addr, allIPs []netip.Addr, d time.Duration) {
@@ -391,8 +433,22 @@ func (d *dialer) DialContext(ctx context.Context, network, address string) (retC
 		port:    port,
 	}
 	defer func() {
-		// On failure, consider that our DNS might be wrong and ask the DNS fallback mechanism for
-		// some other IPs to try.
+		// On failure, consider that our DNS might be wrong and try the
+		// IPs we last connected to, if any, and then ask the DNS
+		// fallback mechanism for some other IPs to try.
+		if ret == nil || ctx.Err() != nil || dc.dnsWasTrustworthy() {
+			return
+		}
+		if d.dnsCache.UseLastGood {
+			if ips := d.dnsCache.lg().ips(host); len(ips) > 0 {
+				d.dnsCache.dlogf("dialing %s using last connected IPs %v", address, ips)
+				if c, err := dc.raceDial(ctx, ips); err == nil {
+					retConn = c
+					ret = nil
+					return
+				}
+			}
+		}
 		if !d.shouldTryBootstrap(ctx, ret, dc) {
 			return
 		}
@@ -408,23 +464,17 @@ func (d *dialer) DialContext(ctx context.Context, network, address string) (retC
 		}
 	}()
 
-	ip, ip6, allIPs, err := d.dnsCache.LookupIP(ctx, host)
+	ip, _, allIPs, err := d.dnsCache.LookupIP(ctx, host)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve %q: %w", host, err)
 	}
-	i4s := v4addrs(allIPs)
-	if len(i4s) < 2 {
+	ipsToTry := append(v4addrs(allIPs), v6addrs(allIPs)...)
+	if len(ipsToTry) < 2 {
 		d.dnsCache.dlogf("dialing %s, %s for %s", network, ip, address)
-		c, err := dc.dialOne(ctx, ip.Unmap())
-		if err == nil || ctx.Err() != nil {
-			return c, err
-		}
-		// Fall back to trying IPv6, if any.
-		return dc.dialOne(ctx, ip6)
+		return dc.dialOne(ctx, ip.Unmap())
 	}
 
-	// Multiple IPv4 candidates, and 0+ IPv6.
-	ipsToTry := append(i4s, v6addrs(allIPs)...)
+	// Multiple candidates; race them per RFC 8305 (Happy Eyeballs v2).
 	return dc.raceDial(ctx, ipsToTry)
 }
 
@@ -492,6 +542,16 @@ func (dc *dialCall) dnsWasTrustworthy() bool {
 func (dc *dialCall) dialOne(ctx context.Context, ip netip.Addr) (net.Conn, error) {
 	c, err := dc.d.fwd(ctx, dc.network, net.JoinHostPort(ip.String(), dc.port))
 	dc.noteDialResult(ip, err)
+	if r := dc.d.dnsCache; r.UseLastGood {
+		switch {
+		case err == nil:
+			r.lg().noteConnected(dc.host, ip, time.Now())
+		case ctx.Err() == nil:
+			// Only count failures that weren't caused by the
+			// caller or by another dial in a race winning.
+			r.lg().noteDialFailed(dc.host, ip, time.Now())
+		}
+	}
 	return c, err
 }
 
@@ -556,7 +616,7 @@ type res struct {
 	// the same results)
 	ips = dc.uniqueIPs(ips)
 	if len(ips) == 0 {
-		return nil, errors.New("no IPs")
+		return nil, errNoIPs
 	}
 
 	// Partition candidate list and then merge such that an IPv6 address is
@@ -574,6 +634,13 @@ type res struct {
 	}
 	ips = slicesx.Interleave(iv6, iv4)
 
+	// Per RFC 8305 section 4, let past connections reorder that: start
+	// with the addresses that recently worked and leave the ones that
+	// recently failed for last.
+	if r := dc.d.dnsCache; r.UseLastGood {
+		r.lg().sortByHistory(dc.host, ips, time.Now())
+	}
+
 	go func() {
 		for i, ip := range ips {
 			if i != 0 {
@@ -0,0 +1,216 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dnscache
+
+import (
+	"encoding/json"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/types/logger"
+)
+
+const (
+	// maxLastGoodIPs is the number of IPs remembered per host.
+	maxLastGoodIPs = 4
+
+	// dialFailMemory is how long a failed dial to an IP counts against it
+	// when ordering candidates.
+	dialFailMemory = time.Minute
+
+	// dnsFailMemory is how long a failed DNS lookup for a host makes us
+	// consider its DNS unhealthy.
+	dnsFailMemory = time.Minute
+)
+
+// lastGood records, per hostname, the IPs that connections were recently
+// established to, along with the health of DNS and of individual IPs for
+// that host. It's used by Resolvers with UseLastGood set to keep
+// connecting to hosts while DNS is broken, including right after startup
+// if a cache path was set with SetCachePath.
+type lastGood struct {
+	mu    sync.Mutex
+	path  string      // or empty to not persist
+	logf  logger.Logf // or nil
+	hosts map[string]*hostHealth
+}
+
+// hostHealth is what lastGood knows about one hostname.
+type hostHealth struct {
+	IPs         []netip.Addr // connected to in the past, most recent first
+	LastConnect time.Time    // time of the most recent connection
+
+	// The following aren't persisted.
+	dnsFails   int       // consecutive failed lookups
+	lastDNSErr time.Time // time of the last failed lookup
+	ipFails    map[netip.Addr]time.Time
+}
+
+// globalLastGood is the lastGood used by Resolvers that don't specify
+// their own.
+var globalLastGood = new(lastGood)
+
+// SetCachePath sets the path of a file in which to persist the IPs that
+// connections were last established to for each host dialed with a
+// Resolver that has UseLastGood set. If the file exists, its contents are
+// loaded so that those IPs can be used if DNS doesn't work.
+func SetCachePath(path string, logf logger.Logf) {
+	globalLastGood.setPath(path, logf)
+}
+
+func (lg *lastGood) setPath(path string, logf logger.Logf) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	lg.path = path
+	lg.logf = logf
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logf("[v1] dnscache: SetCachePath error reading %q: %v", path, err)
+		}
+		return
+	}
+	var hosts map[string]*hostHealth
+	if err := json.Unmarshal(b, &hosts); err != nil {
+		logf("[v1] dnscache: SetCachePath error decoding %q: %v", path, err)
+		return
+	}
+	if lg.hosts == nil {
+		lg.hosts = map[string]*hostHealth{}
+	}
+	for host, h := range hosts {
+		if _, ok := lg.hosts[host]; !ok && len(h.IPs) > 0 {
+			lg.hosts[host] = h
+		}
+	}
+	logf("[v2] dnscache: SetCachePath loaded %d hosts", len(hosts))
+}
+
+func (lg *lastGood) hostLocked(host string) *hostHealth {
+	h, ok := lg.hosts[host]
+	if !ok {
+		if lg.hosts == nil {
+			lg.hosts = map[string]*hostHealth{}
+		}
+		h = &hostHealth{}
+		lg.hosts[host] = h
+	}
+	return h
+}
+
+// ips returns the IPs last connected to for host, most recent first.
+func (lg *lastGood) ips(host string) []netip.Addr {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	if h, ok := lg.hosts[host]; ok {
+		return slices.Clone(h.IPs)
+	}
+	return nil
+}
+
+// noteConnected records that a connection to host was established at ip.
+func (lg *lastGood) noteConnected(host string, ip netip.Addr, now time.Time) {
+	if !ip.IsValid() || ip.IsPrivate() || ip.IsLoopback() {
+		// Like addIPCache, don't remember what may be a captive
+		// portal's answer.
+		return
+	}
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	h := lg.hostLocked(host)
+	delete(h.ipFails, ip)
+	h.LastConnect = now
+	if len(h.IPs) > 0 && h.IPs[0] == ip {
+		return
+	}
+	h.IPs = slices.DeleteFunc(h.IPs, func(a netip.Addr) bool { return a == ip })
+	h.IPs = slices.Insert(h.IPs, 0, ip)
+	if len(h.IPs) > maxLastGoodIPs {
+		h.IPs = h.IPs[:maxLastGoodIPs]
+	}
+	lg.saveLocked()
+}
+
+// noteDialFailed records that dialing host at ip failed.
+func (lg *lastGood) noteDialFailed(host string, ip netip.Addr, now time.Time) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	h := lg.hostLocked(host)
+	if h.ipFails == nil {
+		h.ipFails = map[netip.Addr]time.Time{}
+	}
+	h.ipFails[ip] = now
+}
+
+// noteLookup records the result of a DNS lookup for host.
+func (lg *lastGood) noteLookup(host string, err error, now time.Time) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	if err == nil {
+		if h, ok := lg.hosts[host]; ok {
+			h.dnsFails = 0
+		}
+		return
+	}
+	h := lg.hostLocked(host)
+	h.dnsFails++
+	h.lastDNSErr = now
+}
+
+// dnsFailing reports whether DNS lookups for host have been failing
+// recently.
+func (lg *lastGood) dnsFailing(host string, now time.Time) bool {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	h, ok := lg.hosts[host]
+	return ok && h.dnsFails > 0 && now.Sub(h.lastDNSErr) < dnsFailMemory
+}
+
+// sortByHistory reorders ips in place, keeping the relative order of IPs
+// with the same history: IPs that host was recently connected to come
+// first, most recent first, and IPs that recently failed to dial come last.
+func (lg *lastGood) sortByHistory(host string, ips []netip.Addr, now time.Time) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	h, ok := lg.hosts[host]
+	if !ok {
+		return
+	}
+	rank := func(ip netip.Addr) int {
+		if t, ok := h.ipFails[ip]; ok && now.Sub(t) < dialFailMemory {
+			return len(h.IPs) + 1
+		}
+		if i := slices.Index(h.IPs, ip); i >= 0 {
+			return i
+		}
+		return len(h.IPs)
+	}
+	slices.SortStableFunc(ips, func(a, b netip.Addr) int {
+		return rank(a) - rank(b)
+	})
+}
+
+func (lg *lastGood) saveLocked() {
+	if lg.path == "" {
+		return
+	}
+	hosts := map[string]*hostHealth{}
+	for host, h := range lg.hosts {
+		if len(h.IPs) > 0 {
+			hosts[host] = h
+		}
+	}
+	b, err := json.Marshal(hosts)
+	if err != nil {
+		return
+	}
+	if err := atomicfile.WriteFile(lg.path, b, 0600); err != nil && lg.logf != nil {
+		lg.logf("[v1] dnscache: error writing %q: %v", lg.path, err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLastGoodPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnscache.json")
+	now := time.Now()
+
+	lg := new(lastGood)
+	lg.setPath(path, t.Logf)
+	lg.noteConnected("controlplane.tailscale.com", netip.MustParseAddr("1.2.3.4"), now)
+	lg.noteConnected("controlplane.tailscale.com", netip.MustParseAddr("2001:db8::1"), now)
+	lg.noteConnected("controlplane.tailscale.com", netip.MustParseAddr("1.2.3.4"), now)
+	lg.noteConnected("captive.example.com", netip.MustParseAddr("192.168.1.1"), now)
+
+	lg2 := new(lastGood)
+	lg2.setPath(path, t.Logf)
+	want := []netip.Addr{netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("2001:db8::1")}
+	if got := lg2.ips("controlplane.tailscale.com"); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded IPs = %v; want %v", got, want)
+	}
+	if got := lg2.ips("captive.example.com"); got != nil {
+		t.Errorf("private IP was remembered: %v", got)
+	}
+}
+
+func TestLastGoodSortByHistory(t *testing.T) {
+	mustIP := netip.MustParseAddr
+	now := time.Now()
+	lg := new(lastGood)
+	lg.noteConnected("host", mustIP("1.0.0.2"), now)
+	lg.noteDialFailed("host", mustIP("2001:db8::1"), now)
+
+	ips := []netip.Addr{mustIP("2001:db8::1"), mustIP("1.0.0.1"), mustIP("2001:db8::2"), mustIP("1.0.0.2")}
+	lg.sortByHistory("host", ips, now)
+	want := []netip.Addr{mustIP("1.0.0.2"), mustIP("1.0.0.1"), mustIP("2001:db8::2"), mustIP("2001:db8::1")}
+	if !reflect.DeepEqual(ips, want) {
+		t.Errorf("got %v; want %v", ips, want)
+	}
+
+	// Failures are forgotten after a while.
+	lg.sortByHistory("host", ips, now.Add(2*dialFailMemory))
+	want = []netip.Addr{mustIP("1.0.0.2"), mustIP("1.0.0.1"), mustIP("2001:db8::2"), mustIP("2001:db8::1")}
+	if !reflect.DeepEqual(ips, want) {
+		t.Errorf("after failure expiry: got %v; want %v", ips, want)
+	}
+}
+
+func TestLookupIPUsesLastGood(t *testing.T) {
+	lg := new(lastGood)
+	lg.noteConnected("controlplane.tailscale.com", netip.MustParseAddr("2001:db8::1"), time.Now())
+	lg.noteConnected("controlplane.tailscale.com", netip.MustParseAddr("1.2.3.4"), time.Now())
+
+	errDNS := errors.New("DNS is broken")
+	r := &Resolver{
+		Logf:        t.Logf,
+		UseLastGood: true,
+		lastGood:    lg,
+		Forward: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, errDNS
+			},
+		},
+	}
+	ip, ip6, _, err := r.LookupIP(context.Background(), "controlplane.tailscale.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != netip.MustParseAddr("1.2.3.4") || ip6 != netip.MustParseAddr("2001:db8::1") {
+		t.Errorf("got %v, %v; want 1.2.3.4, 2001:db8::1", ip, ip6)
+	}
+	if !lg.dnsFailing("controlplane.tailscale.com", time.Now()) {
+		t.Error("DNS failure not recorded")
+	}
+	if got := r.lookupTimeoutForHost("controlplane.tailscale.com"); got != time.Second {
+		t.Errorf("lookup timeout = %v; want 1s", got)
+	}
+
+	// Without UseLastGood, the error is returned.
+	r.UseLastGood = false
+	if _, _, _, err := r.LookupIP(context.Background(), "controlplane.tailscale.com"); err == nil {
+		t.Error("got no error without UseLastGood")
+	}
+}
+
+func TestDialerHappyEyeballs(t *testing.T) {
+	lg := new(lastGood)
+	r := &Resolver{
+		Logf:                   t.Logf,
+		UseLastGood:            true,
+		lastGood:               lg,
+		SingleHost:             "controlplane.tailscale.com",
+		SingleHostStaticResult: []netip.Addr{netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("2001:db8::1")},
+	}
+	var (
+		mu     sync.Mutex
+		dialed []string
+	)
+	fwd := func(ctx context.Context, network, address string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, address)
+		mu.Unlock()
+		if address == "[2001:db8::1]:443" {
+			// A blackholed IPv6 path; this would hang until the
+			// context was canceled if the dials weren't raced.
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := Dialer(fwd, r)(ctx, "tcp", "controlplane.tailscale.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if got, want := lg.ips("controlplane.tailscale.com"), []netip.Addr{netip.MustParseAddr("1.2.3.4")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("last good = %v; want %v", got, want)
+	}
+
+	// The next dial starts with the address that worked.
+	mu.Lock()
+	dialed = nil
+	mu.Unlock()
+	c, err = Dialer(fwd, r)(ctx, "tcp", "controlplane.tailscale.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) != 1 || dialed[0] != "1.2.3.4:443" {
+		t.Errorf("dialed %q; want just 1.2.3.4:443", dialed)
+	}
+}
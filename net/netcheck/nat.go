@@ -0,0 +1,195 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/portmapper"
+	"tailscale.com/types/opt"
+)
+
+// NATMapping is how a NAT maps a node's outbound IPv4 UDP traffic to
+// external addresses, as seen by STUN.
+type NATMapping string
+
+const (
+	// NATMappingUnknown means there wasn't enough information to tell,
+	// such as when only one STUN server answered.
+	NATMappingUnknown NATMapping = ""
+
+	// NATMappingUDPBlocked means no STUN server answered over UDP.
+	NATMappingUDPBlocked NATMapping = "udp-blocked"
+
+	// NATMappingNone means the node's own address is public, so
+	// there's no NAT.
+	NATMappingNone NATMapping = "none"
+
+	// NATMappingEndpointIndependent means the node's traffic to
+	// every destination leaves from the same external address and
+	// port (an "easy" NAT), so peers can reach it at that address once
+	// it has sent to them.
+	NATMappingEndpointIndependent NATMapping = "endpoint-independent"
+
+	// NATMappingEndpointDependent means the node's traffic to each
+	// destination leaves from a different external port (a "hard" NAT),
+	// so the address that STUN sees is no use to peers.
+	NATMappingEndpointDependent NATMapping = "endpoint-dependent"
+)
+
+// NATInfo classifies the NAT between a node and the internet, combining
+// what STUN saw with what the LAN's port mapping services reported.
+type NATInfo struct {
+	// Mapping is how the NAT maps the node's traffic.
+	Mapping NATMapping `json:",omitempty"`
+
+	// CGNAT is whether the ISP runs carrier-grade NAT beyond the LAN's
+	// gateway, as the gateway's external address is in 100.64.0.0/10.
+	// It implies DoubleNAT.
+	CGNAT bool `json:",omitempty"`
+
+	// DoubleNAT is whether there's another NAT beyond the LAN's
+	// gateway, as its external address is private, so port mappings on
+	// it don't make the node reachable from the internet.
+	DoubleNAT bool `json:",omitempty"`
+
+	// PortMapping is the best port mapping service on the LAN: "pcp",
+	// "pmp" or "upnp", or empty if there's none.
+	PortMapping string `json:",omitempty"`
+
+	// PortMapStable is whether the gateway has kept the same external
+	// port for the node's mappings. It's empty if there's been no
+	// mapping.
+	PortMapStable opt.Bool `json:",omitempty"`
+}
+
+// String returns a short form of n for logs, like
+// "endpoint-dependent+cgnat+pcp".
+func (n NATInfo) String() string {
+	parts := []string{string(n.Mapping)}
+	if n.Mapping == NATMappingUnknown {
+		parts[0] = "unknown"
+	}
+	if n.CGNAT {
+		parts = append(parts, "cgnat")
+	} else if n.DoubleNAT {
+		parts = append(parts, "doublenat")
+	}
+	if n.PortMapping != "" {
+		parts = append(parts, n.PortMapping)
+	}
+	if n.PortMapStable.EqualBool(false) {
+		parts = append(parts, "unstable-portmap")
+	}
+	return strings.Join(parts, "+")
+}
+
+// Explain returns a few sentences for users about what n means for
+// direct connections to and from the node.
+func (n NATInfo) Explain() string {
+	var sb strings.Builder
+	switch n.Mapping {
+	case NATMappingUDPBlocked:
+		sb.WriteString("UDP appears to be blocked, so all traffic is relayed through DERP.")
+		return sb.String()
+	case NATMappingNone:
+		sb.WriteString("This device has a public IP address with no NAT, so peers can usually connect to it directly.")
+	case NATMappingEndpointIndependent:
+		sb.WriteString(`This device is behind an endpoint-independent ("easy") NAT, so direct connections to most peers should work.`)
+	case NATMappingEndpointDependent:
+		sb.WriteString(`This device is behind an endpoint-dependent ("hard") NAT, which uses a different port for each destination, so direct connections to peers that are also behind hard NATs will usually be relayed through DERP.`)
+	default:
+		sb.WriteString("Not enough STUN servers answered to tell what kind of NAT this device is behind.")
+	}
+	portMapUseful := n.PortMapping != "" && !n.DoubleNAT && n.Mapping != NATMappingNone
+	switch {
+	case n.CGNAT:
+		sb.WriteString(" Your ISP uses carrier-grade NAT, so port mappings on your router can't make this device reachable from the internet.")
+	case n.DoubleNAT:
+		sb.WriteString(" There's a second NAT beyond your router, so port mappings on it can't make this device reachable from the internet.")
+	case portMapUseful:
+		fmt.Fprintf(&sb, " Your router supports port mapping (%s), which lets peers connect to this device directly.", portMappingName(n.PortMapping))
+	case n.Mapping == NATMappingEndpointDependent:
+		sb.WriteString(" Enabling UPnP, NAT-PMP or PCP on your router would let peers connect to this device directly.")
+	}
+	if portMapUseful && n.PortMapStable.EqualBool(false) {
+		sb.WriteString(" Your router changed the mapped port on renewal, which can briefly interrupt direct connections.")
+	}
+	return sb.String()
+}
+
+// portMappingName returns the user-facing name of the port mapping
+// service typ.
+func portMappingName(typ string) string {
+	switch typ {
+	case "pcp":
+		return "PCP"
+	case "pmp":
+		return "NAT-PMP"
+	case "upnp":
+		return "UPnP"
+	}
+	return typ
+}
+
+// classifyNAT returns the NATInfo for report r, given the result of
+// probing for port mapping services, if any, and a func that reports
+// whether an IP address belongs to this machine.
+func classifyNAT(r *Report, pm *portmapper.ProbeResult, isLocal func(netip.Addr) bool) NATInfo {
+	var n NATInfo
+	switch {
+	case !r.UDP:
+		n.Mapping = NATMappingUDPBlocked
+	case r.GlobalV4 != "" && isLocal != nil && isLocal(globalV4Addr(r)):
+		n.Mapping = NATMappingNone
+	case r.MappingVariesByDestIP.EqualBool(true):
+		n.Mapping = NATMappingEndpointDependent
+	case r.MappingVariesByDestIP.EqualBool(false):
+		n.Mapping = NATMappingEndpointIndependent
+	}
+	switch {
+	case r.PCP.EqualBool(true):
+		n.PortMapping = "pcp"
+	case r.PMP.EqualBool(true):
+		n.PortMapping = "pmp"
+	case r.UPnP.EqualBool(true):
+		n.PortMapping = "upnp"
+	}
+	n.DoubleNAT = r.DoubleNAT
+	if pm != nil {
+		n.CGNAT = pm.CGNAT
+		n.PortMapStable = pm.ExternalPortStable
+	}
+	return n
+}
+
+// hasExactIP reports whether ip is one of the addresses of the
+// interfaces in st, rather than only in one of their subnets, as
+// netmon.State.HasIP checks.
+func hasExactIP(st *netmon.State, ip netip.Addr) bool {
+	if st == nil || !ip.IsValid() {
+		return false
+	}
+	for _, pfxs := range st.InterfaceIPs {
+		for _, pfx := range pfxs {
+			if pfx.Addr() == ip {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globalV4Addr returns the address part of r.GlobalV4, or the zero value
+// if it's missing or invalid.
+func globalV4Addr(r *Report) netip.Addr {
+	ap, err := netip.ParseAddrPort(r.GlobalV4)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr()
+}
@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/portmapper"
+)
+
+func TestClassifyNAT(t *testing.T) {
+	local := netip.MustParseAddr("203.0.113.5")
+	isLocal := func(ip netip.Addr) bool { return ip == local }
+
+	tests := []struct {
+		name   string
+		report Report
+		pm     *portmapper.ProbeResult
+		want   NATInfo
+		str    string
+	}{
+		{
+			name:   "udp-blocked",
+			report: Report{},
+			want:   NATInfo{Mapping: NATMappingUDPBlocked},
+			str:    "udp-blocked",
+		},
+		{
+			name:   "public-ip",
+			report: Report{UDP: true, GlobalV4: "203.0.113.5:41641", MappingVariesByDestIP: "false"},
+			want:   NATInfo{Mapping: NATMappingNone},
+			str:    "none",
+		},
+		{
+			name:   "easy",
+			report: Report{UDP: true, GlobalV4: "198.51.100.1:41641", MappingVariesByDestIP: "false"},
+			want:   NATInfo{Mapping: NATMappingEndpointIndependent},
+			str:    "endpoint-independent",
+		},
+		{
+			name:   "one-stun-server",
+			report: Report{UDP: true, GlobalV4: "198.51.100.1:41641"},
+			want:   NATInfo{},
+			str:    "unknown",
+		},
+		{
+			name: "hard-with-pcp",
+			report: Report{
+				UDP:                   true,
+				GlobalV4:              "198.51.100.1:1024",
+				MappingVariesByDestIP: "true",
+				UPnP:                  "true",
+				PCP:                   "true",
+			},
+			pm:   &portmapper.ProbeResult{PCP: true, UPnP: true, ExternalPortStable: "false"},
+			want: NATInfo{Mapping: NATMappingEndpointDependent, PortMapping: "pcp", PortMapStable: "false"},
+			str:  "endpoint-dependent+pcp+unstable-portmap",
+		},
+		{
+			name: "cgnat",
+			report: Report{
+				UDP:                   true,
+				GlobalV4:              "198.51.100.1:1024",
+				MappingVariesByDestIP: "true",
+				PMP:                   "true",
+				DoubleNAT:             true,
+			},
+			pm:   &portmapper.ProbeResult{PMP: true, DoubleNAT: true, CGNAT: true},
+			want: NATInfo{Mapping: NATMappingEndpointDependent, PortMapping: "pmp", DoubleNAT: true, CGNAT: true},
+			str:  "endpoint-dependent+cgnat+pmp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyNAT(&tt.report, tt.pm, isLocal)
+			if got != tt.want {
+				t.Errorf("classifyNAT = %+v; want %+v", got, tt.want)
+			}
+			if s := got.String(); s != tt.str {
+				t.Errorf("String = %q; want %q", s, tt.str)
+			}
+			if got.Explain() == "" {
+				t.Error("empty explanation")
+			}
+		})
+	}
+}
+
+func TestNATInfoExplain(t *testing.T) {
+	tests := []struct {
+		n        NATInfo
+		contains []string
+		excludes []string
+	}{
+		{
+			n:        NATInfo{Mapping: NATMappingUDPBlocked, PortMapping: "pcp"},
+			contains: []string{"UDP appears to be blocked"},
+			excludes: []string{"port mapping"},
+		},
+		{
+			n:        NATInfo{Mapping: NATMappingEndpointDependent},
+			contains: []string{`"hard"`, "Enabling UPnP"},
+		},
+		{
+			n:        NATInfo{Mapping: NATMappingEndpointDependent, PortMapping: "upnp", PortMapStable: "false"},
+			contains: []string{"supports port mapping (UPnP)", "changed the mapped port"},
+		},
+		{
+			n:        NATInfo{Mapping: NATMappingEndpointIndependent, PortMapping: "pcp", DoubleNAT: true, CGNAT: true},
+			contains: []string{`"easy"`, "carrier-grade NAT"},
+			excludes: []string{"supports port mapping"},
+		},
+		{
+			n:        NATInfo{Mapping: NATMappingNone, PortMapping: "pmp"},
+			contains: []string{"public IP address"},
+			excludes: []string{"supports port mapping"},
+		},
+	}
+	for _, tt := range tests {
+		got := tt.n.Explain()
+		for _, s := range tt.contains {
+			if !strings.Contains(got, s) {
+				t.Errorf("%v: Explain = %q; want it to contain %q", tt.n, got, s)
+			}
+		}
+		for _, s := range tt.excludes {
+			if strings.Contains(got, s) {
+				t.Errorf("%v: Explain = %q; want it not to contain %q", tt.n, got, s)
+			}
+		}
+	}
+}
+
+func TestHasExactIP(t *testing.T) {
+	st := &netmon.State{
+		InterfaceIPs: map[string][]netip.Prefix{
+			"eth0": {netip.MustParsePrefix("203.0.113.5/24")},
+		},
+	}
+	if !hasExactIP(st, netip.MustParseAddr("203.0.113.5")) {
+		t.Error("interface's own address not found")
+	}
+	if hasExactIP(st, netip.MustParseAddr("203.0.113.6")) {
+		t.Error("other address in the interface's subnet found")
+	}
+	if hasExactIP(nil, netip.MustParseAddr("203.0.113.5")) {
+		t.Error("found address in nil state")
+	}
+}
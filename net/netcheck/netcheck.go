@@ -110,6 +110,16 @@ type Report struct {
 	// Empty means not checked.
 	PCP opt.Bool
 
+	// DoubleNAT is whether a port mapping service reported an external
+	// address that is itself private (RFC 1918 or CGNAT), meaning there's
+	// another NAT beyond the LAN's gateway and port mappings won't make
+	// this node reachable from the internet.
+	DoubleNAT bool
+
+	// NAT classifies the NAT that this node is behind, combining the
+	// STUN results above with what the port mapping services reported.
+	NAT NATInfo
+
 	PreferredDERP   int                   // or 0 for unknown
 	RegionLatency   map[int]time.Duration // keyed by DERP Region ID
 	RegionV4Latency map[int]time.Duration // keyed by DERP Region ID
@@ -535,6 +545,7 @@ type reportState struct {
 	inFlight      map[stun.TxID]func(netip.AddrPort) // called without c.mu held
 	gotEP4        string
 	timers        []*time.Timer
+	portMapProbe  *portmapper.ProbeResult // or nil if not probed
 }
 
 func (rs *reportState) anyUDP() bool {
@@ -724,6 +735,12 @@ func (rs *reportState) probePortMapServices() {
 	rs.setOptBool(&rs.report.UPnP, res.UPnP)
 	rs.setOptBool(&rs.report.PMP, res.PMP)
 	rs.setOptBool(&rs.report.PCP, res.PCP)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if res.DoubleNAT {
+		rs.report.DoubleNAT = true
+	}
+	rs.portMapProbe = &res
 }
 
 func newReport() *Report {
@@ -1033,8 +1050,15 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 func (c *Client) finishAndStoreReport(rs *reportState, dm *tailcfg.DERPMap) *Report {
 	rs.mu.Lock()
 	report := rs.report.Clone()
+	pm := rs.portMapProbe
 	rs.mu.Unlock()
 
+	var st *netmon.State
+	if c.NetMon != nil {
+		st = c.NetMon.InterfaceState()
+	}
+	report.NAT = classifyNAT(report, pm, func(ip netip.Addr) bool { return hasExactIP(st, ip) })
+
 	c.addReportHistoryAndSetPreferredDERP(rs, report, dm.View())
 	c.logConciseReport(report, dm)
 
@@ -1331,6 +1355,10 @@ func (c *Client) logConciseReport(r *Report, dm *tailcfg.DERPMap) {
 		fmt.Fprintf(w, " hair=%v", r.HairPinning)
 		if r.AnyPortMappingChecked() {
 			fmt.Fprintf(w, " portmap=%v%v%v", conciseOptBool(r.UPnP, "U"), conciseOptBool(r.PMP, "M"), conciseOptBool(r.PCP, "C"))
+			if r.DoubleNAT {
+				fmt.Fprintf(w, " doublenat=true")
+			}
+			fmt.Fprintf(w, " nat=%v", r.NAT)
 		} else {
 			fmt.Fprintf(w, " portmap=?")
 		}
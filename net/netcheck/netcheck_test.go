@@ -235,6 +235,7 @@ func TestWorksWhenUDPBlocked(t *testing.T) {
 	// Captive portal test is irrelevant; accept what the current report
 	// has.
 	want.CaptivePortal = r.CaptivePortal
+	want.NAT = NATInfo{Mapping: NATMappingUDPBlocked}
 
 	if !reflect.DeepEqual(r, want) {
 		t.Errorf("mismatch\n got: %+v\nwant: %+v\n", r, want)
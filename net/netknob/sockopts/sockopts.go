@@ -0,0 +1,239 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package sockopts applies Tailscale's socket option tuning (buffer sizes,
+// DSCP marking and busy polling) consistently to the sockets that carry
+// tunnel traffic: magicsock's UDP sockets and DERP's TCP connections.
+//
+// The options are process-wide. tailscaled sets them from its flags with
+// Set at startup, before any sockets are created.
+package sockopts
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"tailscale.com/types/logger"
+	"tailscale.com/types/nettype"
+)
+
+// DefaultBufferSize is the default size of the send and receive buffers
+// of UDP sockets, in bytes. The value of 7MB is chosen as it is the max
+// supported by a default configuration of macOS. Some platforms will
+// silently clamp the value.
+const DefaultBufferSize = 7 << 20
+
+// Options are socket options.
+type Options struct {
+	// RecvBuffer and SendBuffer are the sizes of the SO_RCVBUF and
+	// SO_SNDBUF socket buffers of UDP sockets, in bytes. Zero leaves the
+	// OS default. On Linux, they're forced past net.core.{r,w}mem_max if
+	// the process has CAP_NET_ADMIN.
+	//
+	// They aren't applied to TCP connections, so as not to defeat the
+	// kernel's automatic tuning of TCP buffers.
+	RecvBuffer int
+	SendBuffer int
+
+	// DSCP is the Differentiated Services codepoint to mark sent packets
+	// with. The zero value, CS0, leaves packets unmarked. DSCP marking
+	// isn't supported on Windows.
+	DSCP DSCP
+
+	// BusyPoll, if non-zero, is how long a blocking read on a socket
+	// busy-polls the network device for packets before sleeping
+	// (SO_BUSY_POLL). It trades CPU time for latency. It's only
+	// supported on Linux.
+	BusyPoll time.Duration
+}
+
+// DefaultOptions returns the options used if Set is never called.
+func DefaultOptions() Options {
+	return Options{
+		RecvBuffer: DefaultBufferSize,
+		SendBuffer: DefaultBufferSize,
+	}
+}
+
+var (
+	mu      sync.Mutex
+	current = DefaultOptions()
+)
+
+// Set sets the options applied to sockets created after it returns.
+func Set(o Options) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = o
+}
+
+// Get returns the current options.
+func Get() Options {
+	mu.Lock()
+	defer mu.Unlock()
+	return current
+}
+
+// ApplyPacketConn applies the current options to pconn, a UDP socket for
+// tunnel traffic. It does nothing if pconn isn't a *net.UDPConn. The
+// options only affect performance, so failures are logged to logf but
+// otherwise ignored.
+func ApplyPacketConn(pconn nettype.PacketConn, logf logger.Logf) {
+	c, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return
+	}
+	o := Get()
+	if o.RecvBuffer > 0 || o.SendBuffer > 0 {
+		setBuffers(c, o, logf)
+	}
+	applySysOpts(c, isIPv6(c.LocalAddr()), o, logf)
+}
+
+// ApplyConn is like ApplyPacketConn, but for a TCP connection, such as
+// one to a DERP server. It does nothing if conn isn't a *net.TCPConn.
+// The buffer sizes aren't applied.
+func ApplyConn(conn net.Conn, logf logger.Logf) {
+	c, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	applySysOpts(c, isIPv6(c.LocalAddr()), Get(), logf)
+}
+
+// setBuffers sets the socket buffer sizes of c from o, forcing them where
+// possible.
+func setBuffers(c *net.UDPConn, o Options, logf logger.Logf) {
+	if forceBuffers(c, o, logf) {
+		return
+	}
+	if o.RecvBuffer > 0 {
+		if err := c.SetReadBuffer(o.RecvBuffer); err != nil {
+			logf("sockopts: failed to set UDP read buffer size to %d: %v", o.RecvBuffer, err)
+		}
+	}
+	if o.SendBuffer > 0 {
+		if err := c.SetWriteBuffer(o.SendBuffer); err != nil {
+			logf("sockopts: failed to set UDP write buffer size to %d: %v", o.SendBuffer, err)
+		}
+	}
+}
+
+// applySysOpts applies the options of o that don't have a portable API to
+// c, whose local address is IPv6 if is6.
+func applySysOpts(c syscall.Conn, is6 bool, o Options, logf logger.Logf) {
+	if o.DSCP == 0 && o.BusyPoll == 0 {
+		return
+	}
+	rc, err := c.SyscallConn()
+	if err != nil {
+		logf("sockopts: %v", err)
+		return
+	}
+	if o.DSCP != 0 {
+		if err := setDSCP(rc, is6, o.DSCP); err != nil {
+			logf("sockopts: failed to set DSCP to %v: %v", o.DSCP, err)
+		}
+	}
+	if o.BusyPoll != 0 {
+		if err := setBusyPoll(rc, o.BusyPoll); err != nil {
+			logf("sockopts: failed to set busy poll to %v: %v", o.BusyPoll, err)
+		}
+	}
+}
+
+func isIPv6(a net.Addr) bool {
+	switch a := a.(type) {
+	case *net.UDPAddr:
+		return a.IP.To4() == nil
+	case *net.TCPAddr:
+		return a.IP.To4() == nil
+	}
+	return false
+}
+
+// DSCP is a Differentiated Services codepoint (RFC 2474), the upper six
+// bits of the IPv4 TOS or IPv6 Traffic Class field.
+type DSCP uint8
+
+// Commonly used DSCP values. See RFC 4594 for what they're meant for.
+const (
+	CS0  DSCP = 0 // default, unmarked
+	CS1  DSCP = 8 // lower effort
+	CS2  DSCP = 16
+	CS3  DSCP = 24
+	CS4  DSCP = 32
+	CS5  DSCP = 40
+	CS6  DSCP = 48
+	CS7  DSCP = 56
+	AF11 DSCP = 10
+	AF12 DSCP = 12
+	AF13 DSCP = 14
+	AF21 DSCP = 18
+	AF22 DSCP = 20
+	AF23 DSCP = 22
+	AF31 DSCP = 26
+	AF32 DSCP = 28
+	AF33 DSCP = 30
+	AF41 DSCP = 34 // multimedia conferencing
+	AF42 DSCP = 36
+	AF43 DSCP = 38
+	EF   DSCP = 46 // expedited forwarding
+)
+
+var dscpNames = map[DSCP]string{
+	CS0: "cs0", CS1: "cs1", CS2: "cs2", CS3: "cs3", CS4: "cs4", CS5: "cs5", CS6: "cs6", CS7: "cs7",
+	AF11: "af11", AF12: "af12", AF13: "af13",
+	AF21: "af21", AF22: "af22", AF23: "af23",
+	AF31: "af31", AF32: "af32", AF33: "af33",
+	AF41: "af41", AF42: "af42", AF43: "af43",
+	EF: "ef",
+}
+
+// ParseDSCP parses s, either the name of a DSCP class such as "cs1",
+// "af41" or "ef" (in any case) or a number from 0 to 63. The empty string
+// and "none" mean CS0.
+func ParseDSCP(s string) (DSCP, error) {
+	s = strings.ToLower(s)
+	if s == "" || s == "none" {
+		return CS0, nil
+	}
+	for d, name := range dscpNames {
+		if name == s {
+			return d, nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 0, 8)
+	if err != nil || v > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q; want a class name like cs1, af41 or ef, or a number from 0 to 63", s)
+	}
+	return DSCP(v), nil
+}
+
+func (d DSCP) String() string {
+	if name, ok := dscpNames[d]; ok {
+		return name
+	}
+	return strconv.Itoa(int(d))
+}
+
+// Set implements flag.Value.
+func (d *DSCP) Set(s string) error {
+	v, err := ParseDSCP(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// TOS returns the value of the IPv4 TOS or IPv6 Traffic Class byte that
+// marks packets with d, with the ECN bits clear.
+func (d DSCP) TOS() int {
+	return int(d) << 2
+}
@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sockopts
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"tailscale.com/types/logger"
+)
+
+// forceBuffers attempts to set SO_RCVBUFFORCE and SO_SNDBUFFORCE, which
+// can overcome the limit of net.core.{r,w}mem_max, but require
+// CAP_NET_ADMIN. It reports whether both succeeded; if not, the caller
+// falls back to the portable implementation, which may be silently capped
+// to net.core.{r,w}mem_max.
+func forceBuffers(c *net.UDPConn, o Options, logf logger.Logf) bool {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var errRcv, errSnd error
+	err = rc.Control(func(fd uintptr) {
+		if o.RecvBuffer > 0 {
+			errRcv = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, o.RecvBuffer)
+			if errRcv != nil {
+				logf("sockopts: [warning] failed to force-set UDP read buffer size to %d: %v; using kernel default values (impacts throughput only)", o.RecvBuffer, errRcv)
+			}
+		}
+		if o.SendBuffer > 0 {
+			errSnd = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, o.SendBuffer)
+			if errSnd != nil {
+				logf("sockopts: [warning] failed to force-set UDP write buffer size to %d: %v; using kernel default values (impacts throughput only)", o.SendBuffer, errSnd)
+			}
+		}
+	})
+	return err == nil && errRcv == nil && errSnd == nil
+}
+
+func setBusyPoll(rc syscall.RawConn, d time.Duration) error {
+	var setErr error
+	err := rc.Control(func(fd uintptr) {
+		setErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BUSY_POLL, int(d.Microseconds()))
+	})
+	if err != nil {
+		return err
+	}
+	return setErr
+}
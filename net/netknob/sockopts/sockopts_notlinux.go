@@ -0,0 +1,23 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package sockopts
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+func forceBuffers(c *net.UDPConn, o Options, logf logger.Logf) bool {
+	return false
+}
+
+func setBusyPoll(rc syscall.RawConn, d time.Duration) error {
+	return errors.ErrUnsupported
+}
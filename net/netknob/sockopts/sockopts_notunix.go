@@ -0,0 +1,15 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !unix
+
+package sockopts
+
+import (
+	"errors"
+	"syscall"
+)
+
+func setDSCP(rc syscall.RawConn, is6 bool, d DSCP) error {
+	return errors.ErrUnsupported
+}
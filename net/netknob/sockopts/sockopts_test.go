@@ -0,0 +1,35 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sockopts
+
+import "testing"
+
+func TestParseDSCP(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    DSCP
+		wantErr bool
+	}{
+		{"", CS0, false},
+		{"none", CS0, false},
+		{"cs1", CS1, false},
+		{"AF41", AF41, false},
+		{"ef", EF, false},
+		{"46", EF, false},
+		{"63", 63, false},
+		{"64", 0, true},
+		{"af99", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDSCP(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseDSCP(%q) = %v, %v; want %v, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+	for _, d := range []DSCP{CS0, CS1, AF41, EF, 63} {
+		if got, err := ParseDSCP(d.String()); err != nil || got != d {
+			t.Errorf("ParseDSCP(%q) = %v, %v; want %v", d.String(), got, err, d)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package sockopts
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func setDSCP(rc syscall.RawConn, is6 bool, d DSCP) error {
+	var setErr error
+	err := rc.Control(func(fd uintptr) {
+		if is6 {
+			setErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, d.TOS())
+			return
+		}
+		setErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, d.TOS())
+	})
+	if err != nil {
+		return err
+	}
+	return setErr
+}
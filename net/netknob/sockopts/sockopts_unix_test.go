@@ -3,18 +3,26 @@
 
 //go:build unix
 
-package magicsock
+package sockopts
 
 import (
 	"net"
 	"syscall"
 	"testing"
 
+	"golang.org/x/sys/unix"
+	"tailscale.com/tstest"
 	"tailscale.com/types/nettype"
 )
 
-func TestTrySetSocketBuffer(t *testing.T) {
-	c, err := net.ListenPacket("udp", ":0")
+func TestApplyPacketConn(t *testing.T) {
+	tstest.Replace(t, &current, Options{
+		RecvBuffer: DefaultBufferSize,
+		SendBuffer: DefaultBufferSize,
+		DSCP:       AF41,
+	})
+
+	c, err := net.ListenPacket("udp4", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -25,8 +33,7 @@ func TestTrySetSocketBuffer(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	getBufs := func() (int, int) {
-		var rcv, snd int
+	getOpts := func() (rcv, snd, tos int) {
 		rc.Control(func(fd uintptr) {
 			rcv, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
 			if err != nil {
@@ -36,15 +43,19 @@ func TestTrySetSocketBuffer(t *testing.T) {
 			if err != nil {
 				t.Errorf("getsockopt(SO_SNDBUF): %v", err)
 			}
+			tos, err = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS)
+			if err != nil {
+				t.Errorf("getsockopt(IP_TOS): %v", err)
+			}
 		})
-		return rcv, snd
+		return rcv, snd, tos
 	}
 
-	curRcv, curSnd := getBufs()
+	curRcv, curSnd, _ := getOpts()
 
-	trySetSocketBuffer(c.(nettype.PacketConn), t.Logf)
+	ApplyPacketConn(c.(nettype.PacketConn), t.Logf)
 
-	newRcv, newSnd := getBufs()
+	newRcv, newSnd, tos := getOpts()
 
 	if curRcv > newRcv {
 		t.Errorf("SO_RCVBUF decreased: %v -> %v", curRcv, newRcv)
@@ -52,9 +63,12 @@ func TestTrySetSocketBuffer(t *testing.T) {
 	if curSnd > newSnd {
 		t.Errorf("SO_SNDBUF decreased: %v -> %v", curSnd, newSnd)
 	}
+	if want := AF41.TOS(); tos != want {
+		t.Errorf("IP_TOS = %#x; want %#x", tos, want)
+	}
 
 	// On many systems we may not increase the value, particularly running as a
 	// regular user, so log the information for manual verification.
 	t.Logf("SO_RCVBUF: %v -> %v", curRcv, newRcv)
-	t.Logf("SO_SNDBUF: %v -> %v", curRcv, newRcv)
+	t.Logf("SO_SNDBUF: %v -> %v", curSnd, newSnd)
 }
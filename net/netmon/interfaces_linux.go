@@ -8,6 +8,7 @@
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"errors"
 	"fmt"
 	"io"
@@ -17,6 +18,7 @@
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
 	"sync/atomic"
 
@@ -30,6 +32,8 @@
 
 func init() {
 	likelyHomeRouterIP = likelyHomeRouterIPLinux
+	likelyHomeRouterIPs = likelyHomeRouterIPsLinux
+	gatewayMAC = gatewayMACLinux
 }
 
 var procNetRouteErr atomic.Bool
@@ -137,6 +141,97 @@ func likelyHomeRouterIPLinux() (ret netip.Addr, myIP netip.Addr, ok bool) {
 	return netip.Addr{}, netip.Addr{}, false
 }
 
+// likelyHomeRouterIPsLinux returns the private gateways of all the routes
+// in /proc/net/route, with those of default routes first and each group
+// ordered by route metric.
+func likelyHomeRouterIPsLinux() []HomeRouter {
+	if procNetRouteErr.Load() {
+		return nil
+	}
+	type route struct {
+		hr     HomeRouter
+		iface  string
+		isDef  bool
+		metric uint64
+	}
+	var routes []route
+	lineNum := 0
+	var f []mem.RO
+	err := lineread.File(procNetRoutePath, func(line []byte) error {
+		lineNum++
+		if lineNum == 1 {
+			// Skip header line.
+			return nil
+		}
+		if lineNum > maxProcNetRouteRead {
+			return errStopReading
+		}
+		f = mem.AppendFields(f[:0], mem.B(line))
+		if len(f) < 8 {
+			return nil
+		}
+		flags, err := mem.ParseUint(f[3], 16, 16)
+		if err != nil || flags&(unix.RTF_UP|unix.RTF_GATEWAY) != unix.RTF_UP|unix.RTF_GATEWAY {
+			return nil
+		}
+		ipu32, err := mem.ParseUint(f[2], 16, 32)
+		if err != nil {
+			return nil
+		}
+		ip := netaddr.IPv4(byte(ipu32), byte(ipu32>>8), byte(ipu32>>16), byte(ipu32>>24))
+		if !ip.IsPrivate() {
+			return nil
+		}
+		metric, err := mem.ParseUint(f[6], 10, 32)
+		if err != nil {
+			return nil
+		}
+		routes = append(routes, route{
+			hr:     HomeRouter{Gateway: ip},
+			iface:  f[0].StringCopy(),
+			isDef:  f[1].EqualString("00000000") && f[7].EqualString("00000000"),
+			metric: metric,
+		})
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopReading) {
+		return nil
+	}
+	slices.SortStableFunc(routes, func(a, b route) int {
+		if a.isDef != b.isDef {
+			if a.isDef {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.metric, b.metric)
+	})
+
+	// Find the local IP on each route's interface, the same way
+	// likelyHomeRouterIPLinux does. It isn't fatal if this fails.
+	var ifPrefixes map[string][]netip.Prefix
+	if len(routes) > 0 && !disableLikelyHomeRouterIPSelf() {
+		ifPrefixes = make(map[string][]netip.Prefix)
+		ForeachInterface(func(ni Interface, pfxs []netip.Prefix) {
+			ifPrefixes[ni.Name] = pfxs
+		})
+	}
+	ret := make([]HomeRouter, 0, len(routes))
+	for _, r := range routes {
+		if slices.ContainsFunc(ret, func(o HomeRouter) bool { return o.Gateway == r.hr.Gateway }) {
+			continue
+		}
+		for _, pfx := range ifPrefixes[r.iface] {
+			if pfx.Addr().Is4() && pfx.Contains(r.hr.Gateway) {
+				r.hr.Self = pfx.Addr()
+				break
+			}
+		}
+		ret = append(ret, r.hr)
+	}
+	return ret
+}
+
 // Android apps don't have permission to read /proc/net/route, at
 // least on Google devices and the Android emulator.
 func likelyHomeRouterIPAndroid() (ret netip.Addr, _ netip.Addr, ok bool) {
@@ -236,6 +331,49 @@ func defaultRouteFromNetlink() (d DefaultRouteDetails, err error) {
 	return d, errNoDefaultRoute
 }
 
+var procNetARPPath = "/proc/net/arp"
+
+/*
+Parse aa:bb:cc:dd:ee:ff for 192.168.1.1 out of:
+
+$ cat /proc/net/arp
+IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0
+*/
+func gatewayMACLinux(gw netip.Addr) (mac net.HardwareAddr, ok bool) {
+	if !gw.Is4() {
+		return nil, false
+	}
+	want := gw.String()
+	lineNum := 0
+	var f []mem.RO
+	err := lineread.File(procNetARPPath, func(line []byte) error {
+		lineNum++
+		if lineNum == 1 {
+			// Skip header line.
+			return nil
+		}
+		f = mem.AppendFields(f[:0], mem.B(line))
+		if len(f) < 4 || !f[0].EqualString(want) {
+			return nil
+		}
+		const atfCom = 0x2 // ATF_COM: entry is complete
+		flags, err := mem.ParseUint(f[2], 0, 16)
+		if err != nil || flags&atfCom == 0 {
+			return nil
+		}
+		if m, err := net.ParseMAC(f[3].StringCopy()); err == nil {
+			mac, ok = m, true
+			return errStopReading
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopReading) {
+		return nil, false
+	}
+	return mac, ok
+}
+
 var zeroRouteBytes = []byte("00000000")
 var procNetRoutePath = "/proc/net/route"
 
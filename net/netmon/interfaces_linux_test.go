@@ -7,8 +7,10 @@
 	"errors"
 	"fmt"
 	"io/fs"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"tailscale.com/tstest"
@@ -114,3 +116,54 @@ func TestRouteLinuxNetlink(t *testing.T) {
 	}
 	t.Logf("Got: %+v", d)
 }
+
+func TestGatewayMACLinux(t *testing.T) {
+	dir := t.TempDir()
+	tstest.Replace(t, &procNetARPPath, filepath.Join(dir, "arp"))
+	buf := []byte("IP address       HW type     Flags       HW address            Mask     Device\n" +
+		"192.168.1.7      0x1         0x0         00:00:00:00:00:00     *        eth0\n" +
+		"192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0\n")
+	if err := os.WriteFile(procNetARPPath, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		gw     string
+		want   string
+		wantOK bool
+	}{
+		{"192.168.1.1", "aa:bb:cc:dd:ee:ff", true},
+		{"192.168.1.7", "", false}, // incomplete entry
+		{"192.168.1.2", "", false},
+		{"fe80::1", "", false},
+	}
+	for _, tt := range tests {
+		mac, ok := gatewayMACLinux(netip.MustParseAddr(tt.gw))
+		if ok != tt.wantOK || (ok && mac.String() != tt.want) {
+			t.Errorf("gatewayMACLinux(%s) = %v, %v; want %v, %v", tt.gw, mac, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestLikelyHomeRouterIPsLinux(t *testing.T) {
+	dir := t.TempDir()
+	tstest.Replace(t, &procNetRoutePath, filepath.Join(dir, "route"))
+	// A VM bridge route, the LAN's default route, a higher-metric
+	// default route via a second LAN, and a public gateway.
+	buf := []byte("Iface\tDestination\tGateway\tFlags\tRefCnt\tUse\tMetric\tMask\tMTU\tWindow\tIRTT\n" +
+		"virbr0\t007AA8C0\t017AA8C0\t0003\t0\t0\t0\t00FFFFFF\t0\t0\t0\n" +
+		"wlan0\t00000000\t0101A8C0\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" +
+		"eth0\t00000000\t0100000A\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" +
+		"eth0\t0000000A\t00000000\t0001\t0\t0\t100\t0000FFFF\t0\t0\t0\n" +
+		"eth1\t00000000\t01020304\t0003\t0\t0\t50\t00000000\t0\t0\t0\n")
+	if err := os.WriteFile(procNetRoutePath, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, hr := range likelyHomeRouterIPsLinux() {
+		got = append(got, hr.Gateway.String())
+	}
+	want := []string{"10.0.0.1", "192.168.1.1", "192.168.122.1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -7,6 +7,7 @@
 	"encoding/json"
 	"net"
 	"net/netip"
+	"runtime"
 	"testing"
 
 	"tailscale.com/tstest"
@@ -225,6 +226,33 @@ func TestIsUsableV6(t *testing.T) {
 	}
 }
 
+func TestIsVirtualInterface(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interface names differ on Windows")
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"eth0", false},
+		{"wlan0", false},
+		{"enp3s0", false},
+		{"docker0", true},
+		{"br-4f2a8c1e9b3d", true},
+		{"br-lan", false},
+		{"veth1a2b3c4", true},
+		{"virbr0", true},
+		{"vmnet8", true},
+		{"vboxnet0", true},
+		{"cni0", true},
+	}
+	for _, tt := range tests {
+		if got := isVirtualInterface(&net.Interface{Name: tt.name}); got != tt.want {
+			t.Errorf("isVirtualInterface(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
 func TestStateString(t *testing.T) {
 	tests := []struct {
 		name string
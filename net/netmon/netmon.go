@@ -201,6 +201,15 @@ func (m *Monitor) GatewayAndSelfIP() (gw, myIP netip.Addr, ok bool) {
 	return gw, myIP, ok
 }
 
+// HomeRouters returns the candidate home routers of the current network,
+// starting with the default gateway. See LikelyHomeRouterIPs.
+func (m *Monitor) HomeRouters() []HomeRouter {
+	if m.static {
+		return nil
+	}
+	return LikelyHomeRouterIPs()
+}
+
 // RegisterChangeCallback adds callback to the set of parties to be
 // notified (in their own goroutine) when the network state changes.
 // To remove this callback, call unregister (or close the monitor).
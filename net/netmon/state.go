@@ -41,10 +41,66 @@ func isProblematicInterface(nif *net.Interface) bool {
 	return false
 }
 
+// virtualInterfacePrefixes are the name prefixes of interfaces that are local
+// to this machine: bridges for containers and VMs running on it, and their
+// virtual ethernet pairs.
+var virtualInterfacePrefixes = []string{
+	"docker",  // Docker default bridge
+	"veth",    // container veth pairs
+	"virbr",   // libvirt
+	"vmnet",   // VMware
+	"vboxnet", // VirtualBox host-only
+	"cni",     // Kubernetes CNI bridges
+	"podman",  // Podman
+	"lxdbr",   // LXD
+}
+
+// isVirtualInterface reports whether nif looks like a bridge or virtual
+// ethernet interface for containers or VMs on this machine. Addresses on such
+// interfaces are generally not reachable from other machines, so there's no
+// point in advertising them to peers as endpoint candidates.
+func isVirtualInterface(nif *net.Interface) bool {
+	name := nif.Name
+	if runtime.GOOS == "windows" {
+		// Hyper-V and WSL virtual switches, e.g. "vEthernet (WSL)".
+		return strings.HasPrefix(name, "vEthernet")
+	}
+	for _, prefix := range virtualInterfacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return isDockerNetworkBridge(name)
+}
+
+// isDockerNetworkBridge reports whether name is that of a bridge for a
+// user-defined Docker network: "br-" followed by the first 12 hex digits of
+// the network ID. Other bridges named "br-*", like OpenWrt's br-lan, are
+// commonly real LANs and must not match.
+func isDockerNetworkBridge(name string) bool {
+	id, ok := strings.CutPrefix(name, "br-")
+	if !ok || len(id) != 12 {
+		return false
+	}
+	for _, c := range id {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// includeVirtualInterfaceAddrs, if set, makes LocalAddresses return addresses
+// on interfaces for which isVirtualInterface reports true.
+var includeVirtualInterfaceAddrs = envknob.RegisterBool("TS_INCLUDE_VIRTUAL_INTERFACE_ADDRS")
+
 // LocalAddresses returns the machine's IP addresses, separated by
 // whether they're loopback addresses. If there are no regular addresses
 // it will return any IPv4 linklocal or IPv6 unique local addresses because we
 // know of environments where these are used with NAT to provide connectivity.
+//
+// Addresses on container and VM bridge interfaces are omitted unless the
+// TS_INCLUDE_VIRTUAL_INTERFACE_ADDRS envknob is set.
 func LocalAddresses() (regular, loopback []netip.Addr, err error) {
 	// TODO(crawshaw): don't serve interface addresses that we are routing
 	ifaces, err := netInterfaces()
@@ -60,6 +116,9 @@ func LocalAddresses() (regular, loopback []netip.Addr, err error) {
 			// send Tailscale traffic over.
 			continue
 		}
+		if isVirtualInterface(stdIf) && !includeVirtualInterfaceAddrs() {
+			continue
+		}
 		ifcIsLoopback := isLoopback(stdIf)
 
 		addrs, err := iface.Addrs()
@@ -136,6 +195,10 @@ type Interface struct {
 
 func (i Interface) IsLoopback() bool { return isLoopback(i.Interface) }
 func (i Interface) IsUp() bool       { return isUp(i.Interface) }
+
+// IsVirtual reports whether i looks like a bridge or virtual ethernet
+// interface for containers or VMs running on this machine.
+func (i Interface) IsVirtual() bool { return isVirtualInterface(i.Interface) }
 func (i Interface) Addrs() ([]net.Addr, error) {
 	if i.AltAddrs != nil {
 		return i.AltAddrs, nil
@@ -579,6 +642,13 @@ func LikelyHomeRouterIP() (gateway, myIP netip.Addr, ok bool) {
 
 	// The platform-specific implementation didn't return a valid myIP;
 	// iterate through all interfaces and try to find the correct one.
+	myIP = selfIPForGateway(gateway)
+	return gateway, myIP, myIP.IsValid()
+}
+
+// selfIPForGateway returns the machine's private IPv4 address on the LAN
+// of the private gateway IP gw, or the zero value if it has none.
+func selfIPForGateway(gateway netip.Addr) (myIP netip.Addr) {
 	ForeachInterfaceAddress(func(i Interface, pfx netip.Prefix) {
 		if !i.IsUp() {
 			// Skip interfaces that aren't up.
@@ -605,11 +675,66 @@ func LikelyHomeRouterIP() (gateway, myIP netip.Addr, ok bool) {
 
 		if gateway.IsPrivate() && ip.IsPrivate() {
 			myIP = ip
-			ok = true
-			return
 		}
 	})
-	return gateway, myIP, myIP.IsValid()
+	return myIP
+}
+
+// HomeRouter is a candidate residential router, as returned by
+// LikelyHomeRouterIPs.
+type HomeRouter struct {
+	Gateway netip.Addr // the router's private IPv4 address
+	Self    netip.Addr // the machine's IP address on the router's LAN
+}
+
+// likelyHomeRouterIPs, if non-nil, is the platform-specific part of
+// LikelyHomeRouterIPs. It returns the private gateways of all of the
+// system's routes, those on a default route first. The Self field may be
+// left zero, in which case it's found from the system's interfaces.
+var likelyHomeRouterIPs func() []HomeRouter
+
+// LikelyHomeRouterIPs returns all the routers that might be the
+// residential router, for machines with more than one gateway (such as
+// a VPN or VM bridge alongside the LAN). The first one is the router
+// that LikelyHomeRouterIP returns; the rest, if any, are in order of
+// preference. Only routers for which the machine's own LAN IP is known
+// are returned.
+func LikelyHomeRouterIPs() []HomeRouter {
+	var ret []HomeRouter
+	if gw, myIP, ok := LikelyHomeRouterIP(); ok {
+		ret = append(ret, HomeRouter{Gateway: gw, Self: myIP})
+	}
+	if likelyHomeRouterIPs == nil {
+		return ret
+	}
+	for _, hr := range likelyHomeRouterIPs() {
+		if slices.ContainsFunc(ret, func(o HomeRouter) bool { return o.Gateway == hr.Gateway }) {
+			continue
+		}
+		if !hr.Self.IsValid() || disableLikelyHomeRouterIPSelf() {
+			hr.Self = selfIPForGateway(hr.Gateway)
+		}
+		if hr.Self.IsValid() {
+			ret = append(ret, hr)
+		}
+	}
+	return ret
+}
+
+// gatewayMAC, if non-nil, is the platform-specific implementation of
+// GatewayMAC.
+var gatewayMAC func(gw netip.Addr) (net.HardwareAddr, bool)
+
+// GatewayMAC returns the hardware address of the LAN gateway gw (as
+// returned by LikelyHomeRouterIP), if the OS's neighbor table knows it.
+//
+// It's used to tell apart networks that reuse the same private gateway
+// IP, as most home routers do.
+func GatewayMAC(gw netip.Addr) (mac net.HardwareAddr, ok bool) {
+	if gatewayMAC == nil || !gw.IsValid() {
+		return nil, false
+	}
+	return gatewayMAC(gw)
 }
 
 // isUsableV4 reports whether ip is a usable IPv4 address which could
@@ -6,7 +6,11 @@
 import (
 	"io"
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"tailscale.com/net/netmon"
@@ -82,3 +86,89 @@ func TestCheckReversePathFiltering(t *testing.T) {
 	t.Logf("err: %v", err)
 	t.Logf("warnings: %v", warn)
 }
+
+func TestForwardingSysctlFixes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	netMon, err := netmon.New(t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netMon.Close()
+
+	fixes, err := ForwardingSysctlFixes([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, netMon.InterfaceState())
+	t.Logf("err: %v", err)
+	t.Logf("fixes: %v", fixes)
+	for _, f := range fixes {
+		if strings.HasPrefix(f.Key, "net.ipv6.") {
+			t.Errorf("fix %v for IPv4-only routes", f)
+		}
+	}
+}
+
+func TestSysctlPath(t *testing.T) {
+	tests := []struct{ key, want string }{
+		{"net.ipv4.ip_forward", "net/ipv4/ip_forward"},
+		{"net.ipv4.conf.eth0/100.rp_filter", "net/ipv4/conf/eth0.100/rp_filter"},
+	}
+	for _, tt := range tests {
+		if got := sysctlPath(tt.key); got != tt.want {
+			t.Errorf("sysctlPath(%q) = %q; want %q", tt.key, got, tt.want)
+		}
+	}
+	if got, want := sysctlPath(rpFilterSysctlKey(dotFormat, "eth0.100")), rpFilterSysctlKey(slashFormat, "eth0.100"); got != want {
+		t.Errorf("sysctlPath of rp_filter key = %q; want %q", got, want)
+	}
+}
+
+func TestMergeSysctlConf(t *testing.T) {
+	fixes := []SysctlFix{
+		{Key: "net.ipv4.ip_forward", Value: 1},
+		{Key: "net.ipv4.conf.all.rp_filter", Value: 2, Old: 1},
+	}
+	got := string(mergeSysctlConf(nil, fixes))
+	want := "# Set by 'tailscale up --fix-sysctls' for subnet routing and exit nodes.\n" +
+		"# See https://tailscale.com/s/ip-forwarding\n" +
+		"net.ipv4.ip_forward = 1\n" +
+		"net.ipv4.conf.all.rp_filter = 2\n"
+	if got != want {
+		t.Errorf("new file:\n%s\nwant:\n%s", got, want)
+	}
+
+	old := "# mine\nnet.ipv4.ip_forward=0\nvm.swappiness = 10\n"
+	got = string(mergeSysctlConf([]byte(old), fixes))
+	want = "# mine\nnet.ipv4.ip_forward = 1\nvm.swappiness = 10\nnet.ipv4.conf.all.rp_filter = 2\n"
+	if got != want {
+		t.Errorf("existing file:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestApplySysctlFixes(t *testing.T) {
+	dir := t.TempDir()
+	procSys := filepath.Join(dir, "proc")
+	if err := os.MkdirAll(filepath.Join(procSys, "net/ipv4"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	confPath := filepath.Join(dir, "etc/sysctl.d/99-tailscale.conf")
+	fixes := []SysctlFix{{Key: "net.ipv4.ip_forward", Value: 1}}
+	if err := applySysctlFixes(procSys, confPath, fixes); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(procSys, "net/ipv4/ip_forward")); err != nil || string(b) != "1\n" {
+		t.Errorf("ip_forward = %q, %v; want 1", b, err)
+	}
+	if b, err := os.ReadFile(confPath); err != nil || !strings.Contains(string(b), "net.ipv4.ip_forward = 1\n") {
+		t.Errorf("conf = %q, %v; want ip_forward set", b, err)
+	}
+
+	// Unsettable sysctls aren't persisted.
+	os.Remove(confPath)
+	bad := []SysctlFix{{Key: "net.ipv6.conf.all.forwarding", Value: 1}}
+	if err := applySysctlFixes(procSys, confPath, bad); err == nil {
+		t.Error("setting missing sysctl succeeded")
+	}
+	if _, err := os.Stat(confPath); !os.IsNotExist(err) {
+		t.Errorf("conf written after failure: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netutil
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/net/netmon"
+)
+
+// SysctlConfPath is the file in which ApplySysctlFixes persists the sysctls
+// that it sets, so that they survive a reboot.
+const SysctlConfPath = "/etc/sysctl.d/99-tailscale.conf"
+
+// SysctlFix is a change to a Linux sysctl that subnet routing or exit
+// nodes need.
+type SysctlFix struct {
+	Key   string // in dot format, like "net.ipv4.ip_forward"
+	Value int    // the value needed
+	Old   int    // the current value
+}
+
+func (f SysctlFix) String() string {
+	return fmt.Sprintf("%s = %d (was %d)", f.Key, f.Value, f.Old)
+}
+
+// ForwardingSysctlFixes returns the sysctl changes needed to forward
+// traffic for routes, which should only be advertised routes: the ones that
+// enable the IP forwarding that CheckIPForwarding looks for, and that
+// relax the strict reverse path filtering that CheckReversePathFiltering
+// warns about to loose mode. It returns none on platforms other than
+// Linux. The state param must not be nil.
+func ForwardingSysctlFixes(routes []netip.Prefix, state *netmon.State) ([]SysctlFix, error) {
+	if runtime.GOOS != "linux" {
+		return nil, nil
+	}
+	if state == nil {
+		return nil, errors.New("no link state")
+	}
+	wantV4, wantV6 := protocolsRequiredForForwarding(routes, state)
+	if !wantV4 && !wantV6 {
+		return nil, nil
+	}
+
+	var fixes []SysctlFix
+	// Setting the global IPv4 key also turns on forwarding on every
+	// interface; the global IPv6 key is the one that matters. See
+	// CheckIPForwarding.
+	for _, p := range []protocol{ipv4, ipv6} {
+		if p == ipv4 && !wantV4 || p == ipv6 && !wantV6 {
+			continue
+		}
+		on, err := ipForwardingEnabledLinux(p, "")
+		if err != nil {
+			return nil, err
+		}
+		if p == ipv6 && !on && !ipv6Present() {
+			// IPv6 is disabled, so there's nothing to forward.
+			continue
+		}
+		if !on {
+			fixes = append(fixes, SysctlFix{Key: ipForwardSysctlKey(dotFormat, p, ""), Value: 1, Old: 0})
+		}
+	}
+
+	// The kernel uses the maximum of the 'all' and per-interface
+	// rp_filter values, so strict mode (1) can come from either.
+	const (
+		filtStrict = 1
+		filtLoose  = 2
+	)
+	all, err := reversePathFilterValueLinux("all")
+	if err != nil {
+		return nil, err
+	}
+	if all == filtStrict {
+		fixes = append(fixes, SysctlFix{Key: rpFilterSysctlKey(dotFormat, "all"), Value: filtLoose, Old: all})
+	} else if all < filtStrict {
+		for _, iface := range state.Interface {
+			if iface.IsLoopback() {
+				continue
+			}
+			v, err := reversePathFilterValueLinux(iface.Name)
+			if err != nil {
+				return nil, err
+			}
+			if v == filtStrict {
+				fixes = append(fixes, SysctlFix{Key: rpFilterSysctlKey(dotFormat, iface.Name), Value: filtLoose, Old: v})
+			}
+		}
+	}
+	return fixes, nil
+}
+
+// ipv6Present reports whether the kernel has IPv6 enabled, as the IPv6
+// forwarding sysctl doesn't exist otherwise.
+func ipv6Present() bool {
+	_, err := os.Stat("/proc/sys/net/ipv6")
+	return err == nil
+}
+
+// ApplySysctlFixes sets the sysctls in fixes and persists them in
+// SysctlConfPath, keeping any other settings there. It stops at the
+// first sysctl that it can't set, without persisting any.
+func ApplySysctlFixes(fixes []SysctlFix) error {
+	if len(fixes) == 0 {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("setting sysctls is not supported on %v", runtime.GOOS)
+	}
+	return applySysctlFixes("/proc/sys", SysctlConfPath, fixes)
+}
+
+func applySysctlFixes(procSys, confPath string, fixes []SysctlFix) error {
+	for _, f := range fixes {
+		p := filepath.Join(procSys, sysctlPath(f.Key))
+		if err := os.WriteFile(p, []byte(strconv.Itoa(f.Value)+"\n"), 0644); err != nil {
+			return fmt.Errorf("setting %s: %w", f.Key, err)
+		}
+	}
+	old, err := os.ReadFile(confPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("persisting sysctls: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(confPath), 0755); err != nil {
+		return fmt.Errorf("persisting sysctls: %w", err)
+	}
+	if err := atomicfile.WriteFile(confPath, mergeSysctlConf(old, fixes), 0644); err != nil {
+		return fmt.Errorf("persisting sysctls: %w", err)
+	}
+	return nil
+}
+
+// sysctlPath returns the path under /proc/sys of the sysctl with the dot
+// format key k. Dots in interface names are slashes in dot format keys,
+// and vice versa.
+func sysctlPath(k string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.':
+			return '/'
+		case '/':
+			return '.'
+		}
+		return r
+	}, k)
+}
+
+// mergeSysctlConf returns the sysctl.d(5) file old with the settings in
+// fixes, replacing any earlier settings of the same keys in place and
+// appending the others.
+func mergeSysctlConf(old []byte, fixes []SysctlFix) []byte {
+	pending := make(map[string]int, len(fixes))
+	for _, f := range fixes {
+		pending[f.Key] = f.Value
+	}
+	var out bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(old))
+	for sc.Scan() {
+		line := sc.Text()
+		k, _, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if v, found := pending[k]; ok && found {
+			fmt.Fprintf(&out, "%s = %d\n", k, v)
+			delete(pending, k)
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if len(old) == 0 {
+		out.WriteString("# Set by 'tailscale up --fix-sysctls' for subnet routing and exit nodes.\n")
+		out.WriteString("# See https://tailscale.com/s/ip-forwarding\n")
+	}
+	for _, f := range fixes {
+		if v, ok := pending[f.Key]; ok {
+			fmt.Fprintf(&out, "%s = %d\n", f.Key, v)
+			delete(pending, f.Key)
+		}
+	}
+	return out.Bytes()
+}
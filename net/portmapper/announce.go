@@ -0,0 +1,226 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// pxpAnnouncePort is the port that NAT-PMP and PCP gateways multicast
+// announcements to, on the all-hosts group, when they reboot or their
+// external address changes (RFC 6886 § 3.2.1, RFC 6887 § 14.1).
+const pxpAnnouncePort = 5350
+
+var pxpAnnounceGroup = netip.AddrFrom4([4]byte{224, 0, 0, 1})
+
+// announceRenewMaxDelay is the most that we wait before renewing mappings
+// after an announcement. RFC 6887 § 14.1.1 asks for a random delay so that
+// every host on the network doesn't hit the gateway at once. It's a var
+// for tests.
+var announceRenewMaxDelay = 5 * time.Second
+
+var (
+	// metricPMPAnnounce counts the number of NAT-PMP announcements
+	// received from the gateway.
+	metricPMPAnnounce = clientmetric.NewCounter("portmap_pmp_announce")
+
+	// metricPCPAnnounce counts the number of PCP announcements received
+	// from the gateway.
+	metricPCPAnnounce = clientmetric.NewCounter("portmap_pcp_announce")
+)
+
+// pxpAnnouncement is a parsed NAT-PMP or PCP announcement.
+type pxpAnnouncement struct {
+	mappingType string     // "pmp" or "pcp"
+	epoch       uint32     // the gateway's seconds since its epoch
+	pubIP       netip.Addr // for "pmp", the gateway's external IP
+}
+
+// parsePxPAnnouncement parses pkt as a NAT-PMP or PCP announcement.
+func parsePxPAnnouncement(pkt []byte) (a pxpAnnouncement, ok bool) {
+	if len(pkt) == 0 {
+		return a, false
+	}
+	switch pkt[0] {
+	case pmpVersion:
+		res, ok := parsePMPResponse(pkt)
+		if !ok || res.OpCode != pmpOpReply|pmpOpMapPublicAddr || res.ResultCode != pmpCodeOK {
+			return a, false
+		}
+		return pxpAnnouncement{mappingType: "pmp", epoch: res.SecondsSinceEpoch, pubIP: res.PublicAddr}, true
+	case pcpVersion:
+		res, ok := parsePCPResponse(pkt)
+		if !ok || res.OpCode != pcpOpReply|pcpOpAnnounce || res.ResultCode != pcpCodeOK {
+			return a, false
+		}
+		return pxpAnnouncement{mappingType: "pcp", epoch: res.Epoch}, true
+	}
+	return a, false
+}
+
+// maybeStartAnnounceListenerLocked starts listening for announcements from
+// the gateway, if c has a NAT-PMP or PCP mapping and isn't already
+// listening. NewPortMapping children rely on their parent's listener.
+//
+// c.mu must be held.
+func (c *Client) maybeStartAnnounceListenerLocked() {
+	if c.closed || c.mapping == nil || c.announceConn != nil || c.announceListenFailed {
+		return
+	}
+	if typ := c.mapping.MappingType(); typ != "pmp" && typ != "pcp" {
+		return
+	}
+	if c.parent != nil {
+		// Lock order is parent before child, so do this separately.
+		go func() {
+			c.parent.mu.Lock()
+			defer c.parent.mu.Unlock()
+			c.parent.startAnnounceListenerLocked()
+		}()
+		return
+	}
+	c.startAnnounceListenerLocked()
+}
+
+// startAnnounceListenerLocked starts listening for announcements from the
+// gateway, if c isn't already.
+//
+// c.mu must be held.
+func (c *Client) startAnnounceListenerLocked() {
+	if c.closed || c.announceConn != nil || c.announceListenFailed {
+		return
+	}
+	if c.testPxPPort != 0 {
+		// Test gateways don't send from the real NAT-PMP port, so
+		// there's nothing to hear, and tests shouldn't depend on
+		// what else is multicasting on the machine's network.
+		return
+	}
+	ifc := interfaceWithIP(c.lastMyIP)
+	uc, err := net.ListenMulticastUDP("udp4", ifc, net.UDPAddrFromAddrPort(netip.AddrPortFrom(pxpAnnounceGroup, pxpAnnouncePort)))
+	if err != nil {
+		// Some other program may have the port without
+		// SO_REUSEADDR; don't keep trying.
+		c.announceListenFailed = true
+		c.logf("[v1] not listening for NAT-PMP/PCP announcements: %v", err)
+		return
+	}
+	c.announceConn = uc
+	go c.readAnnouncements(uc)
+}
+
+// stopAnnounceListenerLocked stops listening for announcements, if c is,
+// and lets it try again later.
+//
+// c.mu must be held.
+func (c *Client) stopAnnounceListenerLocked() {
+	if c.announceConn != nil {
+		c.announceConn.Close()
+		c.announceConn = nil
+	}
+	c.announceListenFailed = false
+}
+
+// readAnnouncements reads and handles announcements from uc until it's
+// closed.
+func (c *Client) readAnnouncements(uc *net.UDPConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := uc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		c.handleAnnouncement(netip.AddrPortFrom(src.Addr().Unmap(), src.Port()), buf[:n])
+	}
+}
+
+// handleAnnouncement handles pkt, a possible NAT-PMP or PCP announcement
+// from src, by renewing c's mappings soon if it's from the gateway.
+func (c *Client) handleAnnouncement(src netip.AddrPort, pkt []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || src != netip.AddrPortFrom(c.lastGW, c.pxpPort()) {
+		return
+	}
+	a, ok := parsePxPAnnouncement(pkt)
+	if !ok {
+		return
+	}
+	if a.mappingType == "pmp" {
+		metricPMPAnnounce.Add(1)
+	} else {
+		metricPCPAnnounce.Add(1)
+	}
+	c.logf("got %s announcement from gateway %v (epoch %d); renewing mappings", a.mappingType, src.Addr(), a.epoch)
+	c.noteEventLocked(Event{What: "announcement", Type: a.mappingType, Detail: fmt.Sprintf("epoch %d", a.epoch)})
+	c.noteAnnouncementLocked(a)
+	for _, pm := range c.portMappings {
+		pm.c.mu.Lock()
+		pm.c.noteAnnouncementLocked(a)
+		pm.c.mu.Unlock()
+	}
+}
+
+// noteAnnouncementLocked records what a says about the gateway and, if c
+// has a NAT-PMP or PCP mapping, schedules its renewal after a short random
+// delay.
+//
+// c.mu must be held.
+func (c *Client) noteAnnouncementLocked(a pxpAnnouncement) {
+	now := time.Now()
+	switch a.mappingType {
+	case "pmp":
+		c.pmpPubIP = a.pubIP
+		c.pmpPubIPTime = now
+		c.pmpLastEpoch = a.epoch
+		c.noteExternalIPLocked(a.pubIP)
+	case "pcp":
+		c.pcpSawTime = now
+		c.pcpLastEpoch = a.epoch
+	}
+	if c.closed || c.mapping == nil {
+		return
+	}
+	if typ := c.mapping.MappingType(); typ != "pmp" && typ != "pcp" {
+		return
+	}
+	c.renewNow = true
+	if c.renewTimer != nil {
+		c.renewTimer.Stop()
+	}
+	delay := time.Duration(rand.Int63n(int64(announceRenewMaxDelay)))
+	c.renewTimer = time.AfterFunc(delay, c.renew)
+}
+
+// interfaceWithIP returns the network interface with the address ip, or
+// nil (meaning the system's choice) if there's none.
+func interfaceWithIP(ip netip.Addr) *net.Interface {
+	if !ip.IsValid() {
+		return nil
+	}
+	ifcs, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for i := range ifcs {
+		addrs, err := ifcs[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok {
+				if aip, ok := netip.AddrFromSlice(ipn.IP); ok && aip.Unmap() == ip {
+					return &ifcs[i]
+				}
+			}
+		}
+	}
+	return nil
+}
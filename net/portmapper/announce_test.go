@@ -0,0 +1,98 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func pmpAnnouncementPacket(epoch uint32, pubIP netip.Addr) []byte {
+	pkt := make([]byte, 12)
+	pkt[0] = pmpVersion
+	pkt[1] = pmpOpReply | pmpOpMapPublicAddr
+	binary.BigEndian.PutUint32(pkt[4:], epoch)
+	ip4 := pubIP.As4()
+	copy(pkt[8:], ip4[:])
+	return pkt
+}
+
+func pcpAnnouncementPacket(epoch uint32) []byte {
+	pkt := make([]byte, 24)
+	pkt[0] = pcpVersion
+	pkt[1] = pcpOpReply | pcpOpAnnounce
+	binary.BigEndian.PutUint32(pkt[8:], epoch)
+	return pkt
+}
+
+func TestParsePxPAnnouncement(t *testing.T) {
+	pubIP := netip.MustParseAddr("1.2.3.4")
+	failed := pcpAnnouncementPacket(7)
+	failed[3] = byte(pcpCodeNotAuthorized)
+	tests := []struct {
+		name   string
+		pkt    []byte
+		want   pxpAnnouncement
+		wantOK bool
+	}{
+		{"pmp", pmpAnnouncementPacket(7, pubIP), pxpAnnouncement{mappingType: "pmp", epoch: 7, pubIP: pubIP}, true},
+		{"pcp", pcpAnnouncementPacket(7), pxpAnnouncement{mappingType: "pcp", epoch: 7}, true},
+		{"pcp_error", failed, pxpAnnouncement{}, false},
+		{"pmp_map_response", buildPMPRequestMappingPacket(UDP, 1, 2, 3), pxpAnnouncement{}, false},
+		{"empty", nil, pxpAnnouncement{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parsePxPAnnouncement(tt.pkt)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("got %+v, %v; want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAnnouncementRenewsMapping(t *testing.T) {
+	old := announceRenewMaxDelay
+	announceRenewMaxDelay = time.Millisecond
+	t.Cleanup(func() { announceRenewMaxDelay = old })
+
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+	c := newTestClient(t, igd)
+	defer c.Close()
+
+	ctx := context.Background()
+	if _, err := c.Probe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.createOrGetMapping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	maps := igd.stats().numPCPMapRecv
+	gw, _, _ := c.gatewayAndSelfIP()
+
+	// Announcements not from the gateway's NAT-PMP/PCP port are ignored.
+	c.handleAnnouncement(netip.AddrPortFrom(gw, 1234), pcpAnnouncementPacket(1))
+	c.mu.Lock()
+	renewNow := c.renewNow
+	c.mu.Unlock()
+	if renewNow {
+		t.Fatal("renewing after announcement from wrong port")
+	}
+
+	c.handleAnnouncement(netip.AddrPortFrom(gw, c.pxpPort()), pcpAnnouncementPacket(1))
+	deadline := time.Now().Add(5 * time.Second)
+	for igd.stats().numPCPMapRecv == maps {
+		if time.Now().After(deadline) {
+			t.Fatal("mapping not renewed after announcement")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Report is the result of Client.Diagnose.
+type Report struct {
+	// Gateway and Self are the gateway probed and this machine's IP
+	// address on its network. They're invalid if no gateway was found.
+	Gateway netip.Addr
+	Self    netip.Addr
+
+	// Probe is the result of probing for port mapping services, and
+	// ProbeError is why probing failed, if it did.
+	Probe      ProbeResult
+	ProbeError string
+
+	// Services has the result of trying to map a port with each
+	// service in turn: PCP, NAT-PMP and UPnP.
+	Services []ServiceReport
+}
+
+// ServiceReport is the result of trying to map a port with a single port
+// mapping service.
+type ServiceReport struct {
+	// Type is the service: "pcp", "pmp" or "upnp".
+	Type string
+
+	// Detected is whether the service answered the probe.
+	Detected bool
+
+	// External is the external address of the mapping obtained, if
+	// any, and Latency is how long obtaining it took.
+	External netip.AddrPort
+	Latency  time.Duration
+
+	// Error is why no mapping was obtained, if none was.
+	Error string
+}
+
+// Diagnose probes for port mapping services and then tries to map
+// localPort with each one that's available, one at a time, so that a
+// failure of one service doesn't hide whether the others work. Each
+// mapping is released before Diagnose returns.
+//
+// It's meant for debugging; it doesn't affect c's own mapping.
+func (c *Client) Diagnose(ctx context.Context, localPort uint16) *Report {
+	rep := &Report{}
+	gw, self, ok := c.gatewayAndSelfIP()
+	if !ok {
+		rep.ProbeError = ErrGatewayRange.Error()
+		return rep
+	}
+	rep.Gateway, rep.Self = gw, self
+
+	probe, err := c.Probe(ctx)
+	if err != nil {
+		rep.ProbeError = err.Error()
+	}
+	rep.Probe = probe
+
+	disabled := c.disabledServices()
+	for _, typ := range []string{"pcp", "pmp", "upnp"} {
+		sr := ServiceReport{Type: typ}
+		switch typ {
+		case "pcp":
+			sr.Detected = probe.PCP
+		case "pmp":
+			sr.Detected = probe.PMP
+		case "upnp":
+			sr.Detected = probe.UPnP
+		}
+		switch {
+		case disabled.has(typ):
+			sr.Error = "disabled"
+		case !sr.Detected:
+			sr.Error = "no response to probe"
+		default:
+			sr.External, sr.Latency, err = c.diagnoseService(ctx, typ, localPort)
+			if err != nil {
+				sr.Error = err.Error()
+			}
+		}
+		rep.Services = append(rep.Services, sr)
+	}
+	return rep
+}
+
+// diagnoseService maps localPort using only the service typ, with a
+// throwaway Client that's closed, releasing the mapping, on return.
+func (c *Client) diagnoseService(ctx context.Context, typ string, localPort uint16) (external netip.AddrPort, latency time.Duration, err error) {
+	only := &Client{
+		logf:         c.logf,
+		netMon:       c.netMon,
+		controlKnobs: c.controlKnobs,
+		ipAndGateway: c.ipAndGateway,
+		protocol:     c.protocol,
+		debug:        c.debug,
+		testPxPPort:  c.testPxPPort,
+		testUPnPPort: c.testUPnPPort,
+		localPort:    localPort,
+	}
+	only.disabled.Store(Services{
+		PCP:  typ != "pcp",
+		PMP:  typ != "pmp",
+		UPnP: typ != "upnp",
+	})
+	defer only.Close()
+
+	if _, err := only.Probe(ctx); err != nil {
+		return netip.AddrPort{}, 0, fmt.Errorf("probe: %w", err)
+	}
+	start := time.Now()
+	external, err = only.createOrGetMapping(ctx)
+	if err != nil {
+		return netip.AddrPort{}, 0, err
+	}
+	return external, time.Since(start), nil
+}
+
+// Write writes a human-readable form of rep to w.
+func (rep *Report) Write(w io.Writer) {
+	if !rep.Gateway.IsValid() {
+		fmt.Fprintf(w, "gateway: none found (%s)\n", rep.ProbeError)
+		return
+	}
+	fmt.Fprintf(w, "gateway: %v\n", rep.Gateway)
+	fmt.Fprintf(w, "self:    %v\n", rep.Self)
+	if rep.ProbeError != "" {
+		fmt.Fprintf(w, "probe:   error: %s\n", rep.ProbeError)
+	}
+	var detected []string
+	for _, sr := range rep.Services {
+		if sr.Detected {
+			detected = append(detected, sr.Type)
+		}
+	}
+	if len(detected) == 0 {
+		detected = append(detected, "none")
+	}
+	fmt.Fprintf(w, "found:   %s\n", strings.Join(detected, ", "))
+	for _, sr := range rep.Services {
+		fmt.Fprintf(w, "%-5s    ", sr.Type+":")
+		switch {
+		case sr.External.IsValid():
+			fmt.Fprintf(w, "ok, external %v (in %v)\n", sr.External, sr.Latency.Round(time.Millisecond))
+		case sr.Detected:
+			fmt.Fprintf(w, "detected, but mapping failed: %s\n", sr.Error)
+		default:
+			fmt.Fprintf(w, "%s\n", sr.Error)
+		}
+	}
+	if t := rep.Probe.UPnPMappingVerified; !t.IsZero() {
+		fmt.Fprintf(w, "upnp mapping last verified at %v\n", t.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiagnose(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.SetDisabledServices(Services{UPnP: true})
+
+	rep := c.Diagnose(context.Background(), 1234)
+	if !rep.Gateway.IsValid() || rep.ProbeError != "" {
+		t.Fatalf("gateway %v, probe error %q", rep.Gateway, rep.ProbeError)
+	}
+	if len(rep.Services) != 3 {
+		t.Fatalf("got %d service reports; want 3", len(rep.Services))
+	}
+	pcp, pmp, upnp := rep.Services[0], rep.Services[1], rep.Services[2]
+	if !pcp.Detected || !pcp.External.IsValid() || pcp.Error != "" {
+		t.Errorf("pcp = %+v; want a mapping", pcp)
+	}
+	if pmp.Detected || pmp.External.IsValid() || pmp.Error != "no response to probe" {
+		t.Errorf("pmp = %+v; want no response", pmp)
+	}
+	if upnp.External.IsValid() || upnp.Error != "disabled" {
+		t.Errorf("upnp = %+v; want disabled", upnp)
+	}
+
+	// The throwaway mapping is released, and c doesn't keep one.
+	deadline := time.Now().Add(5 * time.Second)
+	for igd.stats().numPCPDeleteRecv == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := igd.stats().numPCPDeleteRecv; got != 1 {
+		t.Errorf("got %d PCP deletions; want 1", got)
+	}
+	if c.HaveMapping() {
+		t.Error("Diagnose left c with a mapping")
+	}
+
+	var sb strings.Builder
+	rep.Write(&sb)
+	t.Logf("report:\n%s", sb.String())
+	for _, want := range []string{"gateway: ", "pcp:     ok, external ", "pmp:     no response to probe", "upnp:    disabled"} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+}
@@ -8,10 +8,13 @@
 import (
 	"context"
 	"net/netip"
+	"time"
 )
 
 type upnpClient any
 
+func upnpVerifiedAt(mapping) time.Time { return time.Time{} }
+
 type uPnPDiscoResponse struct{}
 
 func parseUPnPDiscoResponse([]byte) (uPnPDiscoResponse, error) {
@@ -22,11 +25,35 @@ func processUPnPResponses(metas []uPnPDiscoResponse) []uPnPDiscoResponse {
 	return metas
 }
 
-func (c *Client) getUPnPPortMapping(
-	ctx context.Context,
-	gw netip.Addr,
-	internal netip.AddrPort,
-	prevPort uint16,
-) (external netip.AddrPort, ok bool) {
-	return netip.AddrPort{}, false
+func (c *Client) upnpMappingAttempt(gw netip.Addr, internal netip.AddrPort, prevPort uint16) mappingAttempt {
+	return mappingAttempt{
+		name: "upnp",
+		create: func(context.Context) (mapping, error) {
+			return nil, NoMappingError{ErrNoPortMappingServices}
+		},
+	}
+}
+
+type ssdp6Discovery struct{}
+
+func (c *Client) startSSDP6Discovery(context.Context, netip.Addr, time.Duration) *ssdp6Discovery {
+	return nil
 }
+
+func (d *ssdp6Discovery) finish() []uPnPDiscoResponse { return nil }
+
+type upnpPinhole struct{}
+
+func (p *upnpPinhole) Release(context.Context) {}
+
+type upnpCache struct{}
+
+type upnpBackoff struct{}
+
+func (c *Client) SetLocalPort6(localPort uint16) {}
+
+func (c *Client) HavePinhole() bool { return false }
+
+func (c *Client) invalidatePinholeLocked(releaseOld bool) {}
+
+func (c *Client) maybeStartPinholeLocked(now time.Time) {}
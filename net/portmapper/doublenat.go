@@ -0,0 +1,70 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"fmt"
+	"net/netip"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/util/clientmetric"
+)
+
+// metricDoubleNAT counts the number of times that we've found that the
+// gateway's external address is private.
+var metricDoubleNAT = clientmetric.NewCounter("portmap_double_nat")
+
+// isDoubleNATAddr reports whether ip, an external address reported by the
+// gateway, is private (RFC 1918) or in the CGNAT range (RFC 6598), meaning
+// that there's another NAT beyond the gateway.
+func isDoubleNATAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.Is4() && (ip.IsPrivate() || tsaddr.CGNATRange().Contains(ip))
+}
+
+// externalIPLocked returns the gateway's external IP address as we last
+// learned it, from the current mapping or from NAT-PMP, or the zero value
+// if we don't know it.
+//
+// c.mu must be held.
+func (c *Client) externalIPLocked() netip.Addr {
+	if c.mapping != nil {
+		if ip := c.mapping.External().Addr(); ip.IsValid() && !ip.IsUnspecified() {
+			return ip
+		}
+	}
+	return c.pmpPubIP
+}
+
+// DoubleNAT reports whether the gateway's external IP address is private,
+// meaning that there's another NAT beyond it and mappings from it won't
+// make this machine reachable from the internet. If so, it also returns
+// that address.
+//
+// It only knows once the gateway has told us its external address, as
+// NAT-PMP does when probed, or when we have a mapping.
+func (c *Client) DoubleNAT() (external netip.Addr, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ip := c.externalIPLocked()
+	if !isDoubleNATAddr(ip) {
+		return netip.Addr{}, false
+	}
+	return ip, true
+}
+
+// noteExternalIPLocked logs and counts when the gateway's external IP
+// address, as reported by a newly learned ip, is newly found to be
+// private.
+//
+// c.mu must be held.
+func (c *Client) noteExternalIPLocked(ip netip.Addr) {
+	if !isDoubleNATAddr(ip) || ip == c.lastDoubleNAT {
+		return
+	}
+	c.lastDoubleNAT = ip
+	metricDoubleNAT.Add(1)
+	c.logf("double NAT detected: gateway %v reports private external address %v; port mappings won't make us reachable from the internet", c.lastGW, ip)
+	c.noteEventLocked(Event{What: "double-nat", Detail: fmt.Sprintf("gateway reports private external address %v", ip)})
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/net/netmon"
+)
+
+func TestIsDoubleNATAddr(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"100.64.0.1", true},
+		{"100.127.255.254", true},
+		{"::ffff:192.168.1.1", true},
+		{"1.2.3.4", false},
+		{"100.128.0.1", false},
+		{"127.0.0.1", false},
+		{"fd7a:115c:a1e0::1", false},
+	}
+	for _, tt := range tests {
+		if got := isDoubleNATAddr(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("isDoubleNATAddr(%v) = %v; want %v", tt.ip, got, tt.want)
+		}
+	}
+	if isDoubleNATAddr(netip.Addr{}) {
+		t.Error("isDoubleNATAddr(zero) = true")
+	}
+}
+
+func TestDoubleNAT(t *testing.T) {
+	c := NewClient(t.Logf, netmon.NewStatic(), nil, new(controlknobs.Knobs), nil)
+	defer c.Close()
+
+	if ip, ok := c.DoubleNAT(); ok {
+		t.Fatalf("DoubleNAT = %v, true with no external address", ip)
+	}
+
+	c.mu.Lock()
+	c.pmpPubIP = netip.MustParseAddr("1.2.3.4")
+	c.mu.Unlock()
+	if ip, ok := c.DoubleNAT(); ok {
+		t.Fatalf("DoubleNAT = %v, true with public external address", ip)
+	}
+
+	want := netip.MustParseAddr("100.70.1.2")
+	c.mu.Lock()
+	c.pmpPubIP = want
+	c.noteExternalIPLocked(want)
+	c.mu.Unlock()
+	if ip, ok := c.DoubleNAT(); !ok || ip != want {
+		t.Fatalf("DoubleNAT = %v, %v; want %v, true", ip, ok, want)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// maxEvents is how many events a Client keeps, shared with the clients of
+// its NewPortMappings.
+const maxEvents = 100
+
+// Event is something the Client did or learned about the gateway, as
+// returned by DebugEvents. This is not a stable interface and could change
+// at any time.
+type Event struct {
+	When time.Time // when it happened
+	What string    // what happened, like "probe" or "map-failed"
+
+	Port     uint16         `json:",omitempty"` // local port of the mapping concerned
+	Gateway  netip.Addr     // gateway concerned, if known
+	Type     string         `json:",omitempty"` // mapping service concerned: "pmp", "pcp" or "upnp"
+	External netip.AddrPort // external address of the mapping concerned, if any
+	Detail   string         `json:",omitempty"` // more about what happened, like why it failed
+}
+
+func (e Event) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s", e.When.Format("15:04:05.000"), e.What)
+	if e.Type != "" {
+		fmt.Fprintf(&sb, " %s", e.Type)
+	}
+	if e.Port != 0 {
+		fmt.Fprintf(&sb, " port=%d", e.Port)
+	}
+	if e.Gateway.IsValid() {
+		fmt.Fprintf(&sb, " gw=%v", e.Gateway)
+	}
+	if e.External.IsValid() {
+		fmt.Fprintf(&sb, " external=%v", e.External)
+	}
+	if e.Detail != "" {
+		fmt.Fprintf(&sb, ": %s", e.Detail)
+	}
+	return sb.String()
+}
+
+// DebugEvents returns the Client's most recent events, oldest first,
+// including those of the mappings created with NewPortMapping. Repeats of
+// the same event in a row are only kept once. Bug reports include them, as
+// most of what the Client does is otherwise only logged with VerboseLogs.
+func (c *Client) DebugEvents() []Event {
+	return c.events.GetAll()
+}
+
+// noteEvent records e, filling in its time, local port and gateway if
+// they're unset.
+func (c *Client) noteEvent(e Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.noteEventLocked(e)
+}
+
+// noteEventLocked is noteEvent with c.mu held.
+func (c *Client) noteEventLocked(e Event) {
+	if e.Port == 0 {
+		e.Port = c.localPort
+	}
+	if !e.Gateway.IsValid() {
+		e.Gateway = c.lastGW
+	}
+	if e == c.lastEvent {
+		return
+	}
+	c.lastEvent = e
+	if e.When.IsZero() {
+		e.When = time.Now()
+	}
+	c.events.Add(e)
+}
+
+// noteInvalidatedLocked records that c's mapping, if it has one, is being
+// dropped, and why.
+//
+// c.mu must be held.
+func (c *Client) noteInvalidatedLocked(why string) {
+	if m := c.mapping; m != nil {
+		c.noteEventLocked(Event{What: "invalidated", Type: m.MappingType(), External: m.External(), Detail: why})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/net/netmon"
+)
+
+func TestDebugEvents(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.SetLocalPort(1234)
+	for range 3 {
+		if _, err := c.Probe(context.Background()); err != nil {
+			t.Fatalf("probe failed: %v", err)
+		}
+	}
+	c.createMapping()
+	c.SetLocalPort(1235)
+
+	var got []string
+	for _, e := range c.DebugEvents() {
+		t.Logf("%v", e)
+		if e.What == "attempt-failed" {
+			// UPnP's, if it failed before PCP's succeeded.
+			continue
+		}
+		got = append(got, e.What+" "+e.Type+" "+e.Detail)
+	}
+	want := []string{
+		// The repeated probes with the same result are only
+		// recorded once.
+		"probe  pcp=true pmp=false upnp=false double-nat=false",
+		"mapped pcp in",
+		"invalidated pcp local port changed to 1235",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got events %q; want %q", got, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("event %d = %q; want prefix %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDebugEventsBounded(t *testing.T) {
+	c := NewClient(t.Logf, netmon.NewStatic(), nil, new(controlknobs.Knobs), nil)
+	pm, err := c.NewPortMapping(1234, UDP, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range maxEvents + 10 {
+		pm.c.noteEvent(Event{What: "test", Detail: fmt.Sprint(i)})
+	}
+	evs := c.DebugEvents()
+	if len(evs) != maxEvents {
+		t.Fatalf("got %d events; want %d", len(evs), maxEvents)
+	}
+	if got, want := evs[len(evs)-1].Detail, fmt.Sprint(maxEvents+9); got != want {
+		t.Errorf("last event = %q; want %q", got, want)
+	}
+	if evs[0].Port != 1234 {
+		t.Errorf("event port = %d; want the NewPortMapping's, 1234", evs[0].Port)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"tailscale.com/util/mak"
+)
+
+// forwardKey identifies the local service that a Forward is for.
+type forwardKey struct {
+	proto Protocol
+	port  uint16
+}
+
+// sharedForward is the mapping shared by the open Forwards for a
+// forwardKey.
+type sharedForward struct {
+	pm   *PortMapping
+	refs int // number of open Forwards
+}
+
+// A Forward is a request, made with ForwardPort, for a local port to be
+// reachable from outside the gateway.
+type Forward struct {
+	c   *Client
+	key forwardKey
+	pm  *PortMapping // shared with other Forwards for key
+
+	mu         sync.Mutex
+	closed     bool
+	unregister []func() // from RegisterChangeCallback
+}
+
+// ForwardPort requests that internalPort over proto be reachable from
+// outside the gateway, until the returned Forward is closed. The mapping
+// is created in the background; use External to get it, and
+// RegisterChangeCallback to learn when it changes.
+//
+// Overlapping requests for the same port and protocol share a single
+// mapping, which is released once all of their Forwards are closed.
+//
+// It returns an error if internalPort and proto are c's own mapping, or
+// were mapped with NewPortMapping.
+func (c *Client) ForwardPort(proto Protocol, internalPort uint16) (*Forward, error) {
+	k := forwardKey{proto, internalPort}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, fmt.Errorf("portmapper: client closed")
+	}
+	sf := c.forwards[k]
+	if sf == nil {
+		pm, err := c.newPortMappingLocked(internalPort, proto, nil)
+		if err != nil {
+			return nil, err
+		}
+		sf = &sharedForward{pm: pm}
+		mak.Set(&c.forwards, k, sf)
+	}
+	sf.refs++
+	return &Forward{c: c, key: k, pm: sf.pm}, nil
+}
+
+// LocalPort returns the local port that f forwards.
+func (f *Forward) LocalPort() uint16 { return f.key.port }
+
+// Protocol returns the protocol that f forwards.
+func (f *Forward) Protocol() Protocol { return f.key.proto }
+
+// External returns the external address that the port is reachable at, if
+// there currently is one. If not, one is created in the background.
+func (f *Forward) External() (external netip.AddrPort, ok bool) {
+	f.mu.Lock()
+	closed := f.closed
+	f.mu.Unlock()
+	if closed {
+		return netip.AddrPort{}, false
+	}
+	return f.pm.External()
+}
+
+// RegisterChangeCallback adds cb to the set of funcs called, each in its
+// own goroutine, when the port's external address changes, as with
+// Client.RegisterChangeCallback. Callbacks are removed when f is closed,
+// or by calling unregister.
+func (f *Forward) RegisterChangeCallback(cb func(MappingChange)) (unregister func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return func() {}
+	}
+	unregister = f.pm.c.RegisterChangeCallback(cb)
+	f.unregister = append(f.unregister, unregister)
+	return unregister
+}
+
+// Close withdraws f's request. Once all of the Forwards for its port and
+// protocol are closed, the mapping is released.
+func (f *Forward) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	unregister := f.unregister
+	f.unregister = nil
+	f.mu.Unlock()
+	for _, u := range unregister {
+		u()
+	}
+
+	c := f.c
+	c.mu.Lock()
+	sf := c.forwards[f.key]
+	if sf == nil || sf.pm != f.pm {
+		c.mu.Unlock()
+		return nil
+	}
+	sf.refs--
+	last := sf.refs == 0
+	if last {
+		delete(c.forwards, f.key)
+	}
+	c.mu.Unlock()
+
+	if last {
+		return f.pm.Close()
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestForwardPort(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.SetLocalPort(41641)
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	if _, err := c.ForwardPort(UDP, 41641); err == nil {
+		t.Error("ForwardPort of the client's own port succeeded")
+	}
+
+	// Two subsystems ask for the same port, and share a mapping.
+	f1, err := c.ForwardPort(TCP, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, err := c.ForwardPort(TCP, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f1.pm != f2.pm {
+		t.Fatal("overlapping Forwards don't share a mapping")
+	}
+	if got := len(c.PortMappings()); got != 1 {
+		t.Errorf("PortMappings has %d entries; want 1", got)
+	}
+	changes := make(chan MappingChange, 1)
+	f2.RegisterChangeCallback(func(mc MappingChange) { changes <- mc })
+
+	f1.pm.c.createMapping()
+	ext1, ok1 := f1.External()
+	ext2, ok2 := f2.External()
+	if !ok1 || !ok2 || ext1 != ext2 {
+		t.Errorf("External = %v, %v and %v, %v; want the same address", ext1, ok1, ext2, ok2)
+	}
+	if mc := <-changes; !mc.Acquired() || mc.New != ext2 {
+		t.Errorf("change = %+v; want acquired %v", mc, ext2)
+	}
+	if got := igd.stats().numPCPMapRecv; got != 1 {
+		t.Errorf("gateway got %d PCP map requests; want 1", got)
+	}
+
+	// Closing one of them keeps the mapping for the other.
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f1.External(); ok {
+		t.Error("closed Forward still has an external address")
+	}
+	if _, ok := f2.External(); !ok {
+		t.Error("mapping released while another Forward is open")
+	}
+	if got := igd.stats().numPCPDeleteRecv; got != 0 {
+		t.Errorf("gateway got %d PCP deletions with a Forward open; want 0", got)
+	}
+
+	// Closing the last one releases it.
+	if err := f2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(c.PortMappings()); got != 0 {
+		t.Errorf("PortMappings has %d entries after closing all Forwards; want 0", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for igd.stats().numPCPDeleteRecv < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := igd.stats().numPCPDeleteRecv; got != 1 {
+		t.Errorf("gateway got %d PCP deletions; want 1", got)
+	}
+	if len(f2.pm.c.changeCallbacks) != 0 {
+		t.Error("change callback not removed by Close")
+	}
+
+	// A new request gets a new mapping.
+	f3, err := c.ForwardPort(TCP, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f3.Close()
+	if f3.pm == f1.pm {
+		t.Error("Forward after all were closed reused the released mapping")
+	}
+}
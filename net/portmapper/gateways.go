@@ -0,0 +1,123 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/net/netmon"
+	"tailscale.com/util/clientmetric"
+)
+
+// gatewayMaxMisses is the number of consecutive probes that the default
+// gateway can leave unanswered before the Client fails over to another
+// candidate gateway that is answering.
+const gatewayMaxMisses = 3
+
+// gatewayState is what a Client knows about one candidate gateway.
+type gatewayState struct {
+	self      netip.Addr // our IP on the gateway's LAN
+	lastHeard time.Time  // when it last answered a probe; zero if never
+	misses    int        // number of consecutive probes it didn't answer
+}
+
+// answering reports whether the gateway answered the most recent probe.
+func (st *gatewayState) answering() bool {
+	return st.misses == 0 && !st.lastHeard.IsZero()
+}
+
+// SetGatewayCandidatesFunc sets the func that returns the candidate gateways
+// of the machine besides the default one, for networks that have more than
+// one (a VPN or VM bridge alongside the LAN, for instance). They are probed
+// along with the default gateway, and used instead of it if it stops
+// answering. It must be called before the client is used.
+//
+// The default gateway is always the one returned by the func passed to
+// SetGatewayLookupFunc; it's skipped if f returns it too. NewClient uses
+// netmon.LikelyHomeRouterIPs; SetGatewayLookupFunc resets it to nil, which
+// means the client only uses the default gateway.
+func (c *Client) SetGatewayCandidatesFunc(f func() []netmon.HomeRouter) {
+	c.gatewayCandidates = f
+}
+
+// selectGatewayLocked updates c.gateways for the current default gateway gw
+// (with our IP myIP on its LAN) and the other candidates, and returns the
+// gateway to use and our IP on its LAN. That's gw, unless it has stopped
+// answering probes and another candidate hasn't.
+//
+// c.mu must be held.
+func (c *Client) selectGatewayLocked(gw, myIP netip.Addr, candidates []netmon.HomeRouter) (netip.Addr, netip.Addr) {
+	old := c.gateways
+	c.gateways = make(map[netip.Addr]*gatewayState, len(candidates)+1)
+	add := func(gw, self netip.Addr) {
+		if _, ok := c.gateways[gw]; ok || !gw.Is4() || !self.IsValid() {
+			return
+		}
+		st := old[gw]
+		if st == nil {
+			st = new(gatewayState)
+		}
+		st.self = self
+		c.gateways[gw] = st
+	}
+	add(gw, myIP)
+	for _, hr := range candidates {
+		add(hr.Gateway, hr.Self)
+	}
+
+	if st := c.gateways[gw]; st == nil || st.misses < gatewayMaxMisses {
+		return gw, myIP
+	}
+	for _, hr := range candidates {
+		if st := c.gateways[hr.Gateway]; st != nil && hr.Gateway != gw && st.answering() {
+			if hr.Gateway != c.lastGW {
+				metricGatewayFailover.Add(1)
+				c.logf("default gateway %v stopped answering; using %v", gw, hr.Gateway)
+			}
+			return hr.Gateway, st.self
+		}
+	}
+	return gw, myIP
+}
+
+// otherGatewaysLocked returns the candidate gateways other than gw, with
+// our IP on each one's LAN.
+//
+// c.mu must be held.
+func (c *Client) otherGatewaysLocked(gw netip.Addr) map[netip.Addr]netip.Addr {
+	var ret map[netip.Addr]netip.Addr
+	for other, st := range c.gateways {
+		if other == gw {
+			continue
+		}
+		if ret == nil {
+			ret = make(map[netip.Addr]netip.Addr)
+		}
+		ret[other] = st.self
+	}
+	return ret
+}
+
+// noteGatewaysProbedLocked records which of the candidate gateways answered
+// a probe that finished at now.
+//
+// c.mu must be held.
+func (c *Client) noteGatewaysProbedLocked(now time.Time, heard map[netip.Addr]bool) {
+	for gw, st := range c.gateways {
+		if heard[gw] {
+			st.lastHeard = now
+			st.misses = 0
+		} else {
+			st.misses++
+		}
+	}
+}
+
+var (
+	// metricGatewayFailover counts the times that a Client switched from the
+	// default gateway to another candidate because the default one stopped
+	// answering probes.
+	metricGatewayFailover = clientmetric.NewCounter("portmap_gateway_failover")
+)
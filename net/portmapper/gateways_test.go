@@ -0,0 +1,98 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/net/netmon"
+)
+
+func TestGatewayFailover(t *testing.T) {
+	var (
+		gw1   = netip.MustParseAddr("192.168.1.1")
+		self1 = netip.MustParseAddr("192.168.1.2")
+		gw2   = netip.MustParseAddr("10.0.0.1")
+		self2 = netip.MustParseAddr("10.0.0.2")
+	)
+	c := NewClient(t.Logf, netmon.NewStatic(), nil, nil, nil)
+	c.SetGatewayLookupFunc(func() (gw, myIP netip.Addr, ok bool) {
+		return gw1, self1, true
+	})
+	c.SetGatewayCandidatesFunc(func() []netmon.HomeRouter {
+		return []netmon.HomeRouter{
+			{Gateway: gw1, Self: self1},
+			{Gateway: gw2, Self: self2},
+		}
+	})
+
+	checkGateway := func(wantGW, wantSelf netip.Addr) {
+		t.Helper()
+		gw, self, ok := c.gatewayAndSelfIP()
+		if !ok || gw != wantGW || self != wantSelf {
+			t.Fatalf("gatewayAndSelfIP = %v, %v, %v; want %v, %v, true", gw, self, ok, wantGW, wantSelf)
+		}
+	}
+	probe := func(heard ...netip.Addr) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		m := make(map[netip.Addr]bool)
+		for _, gw := range heard {
+			m[gw] = true
+		}
+		c.noteGatewaysProbedLocked(time.Now(), m)
+	}
+
+	checkGateway(gw1, self1)
+	probe(gw1, gw2)
+
+	// The default gateway is kept until it has missed gatewayMaxMisses
+	// probes in a row.
+	for range gatewayMaxMisses - 1 {
+		probe(gw2)
+		checkGateway(gw1, self1)
+	}
+	probe(gw2)
+	checkGateway(gw2, self2)
+
+	// And it's used again once it answers.
+	probe(gw1, gw2)
+	checkGateway(gw1, self1)
+
+	// If no other gateway is answering, there's nothing to fail over to.
+	for range gatewayMaxMisses {
+		probe()
+	}
+	checkGateway(gw1, self1)
+}
+
+func TestProbeOtherGateways(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PMP: true, PCP: true, UPnP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	// Nothing listens on the second candidate.
+	other := netip.MustParseAddr("127.0.0.2")
+	c := newTestClient(t, igd)
+	c.SetGatewayCandidatesFunc(func() []netmon.HomeRouter {
+		return []netmon.HomeRouter{{Gateway: other, Self: netip.MustParseAddr("1.2.3.4")}}
+	})
+
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st := c.gateways[c.lastGW]; st == nil || !st.answering() {
+		t.Errorf("default gateway state = %+v; want answering", st)
+	}
+	if st := c.gateways[other]; st == nil || st.misses != 1 || !st.lastHeard.IsZero() {
+		t.Errorf("other gateway state = %+v; want one miss", st)
+	}
+}
@@ -5,14 +5,23 @@
 
 import (
 	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"tailscale.com/control/controlknobs"
 	"tailscale.com/net/netaddr"
@@ -23,6 +32,11 @@
 
 // TestIGD is an IGD (Internet Gateway Device) for testing. It supports fake
 // implementations of NAT-PMP, PCP, and/or UPnP to test clients against.
+//
+// Its NAT-PMP and PCP servers keep a table of port mappings, shared with
+// the UPnP service returned by upnpService, so that tests can exercise a
+// mapping's whole lifecycle: creating it, conflicting with another
+// client's, renewing it and deleting it.
 type TestIGD struct {
 	upnpConn net.PacketConn // for UPnP discovery
 	pxpConn  net.PacketConn // for NAT-PMP and/or PCP
@@ -39,6 +53,28 @@ type TestIGD struct {
 
 	mu       sync.Mutex // guards below
 	counters igdCounters
+	mappings map[igdMappingKey]igdMapping
+}
+
+// testIGDExternalIP is the external IP address of a TestIGD.
+var testIGDExternalIP = netaddr.IPv4(127, 0, 0, 1)
+
+// testIGDFirstPort is the first external port that a TestIGD assigns
+// when the one requested isn't available.
+const testIGDFirstPort = 4242
+
+// igdMapping is a port mapping held by a TestIGD.
+type igdMapping struct {
+	via      string // "pmp", "pcp", "pcp-peer" or "upnp"
+	proto    Protocol
+	external uint16
+	internal netip.AddrPort
+	expires  time.Time // or zero, if permanent
+}
+
+type igdMappingKey struct {
+	proto    Protocol
+	external uint16
 }
 
 // TestIGDOptions are options
@@ -55,9 +91,16 @@ type igdCounters struct {
 	numPCPRecv           int32
 	numPCPDiscoRecv      int32
 	numPCPMapRecv        int32
+	numPCPDeleteRecv     int32
+	numPCPPeerRecv       int32
+	numPCPPeerDeleteRecv int32
 	numPCPOtherRecv      int32
 	numPMPPublicAddrRecv int32
 	numPMPBogusRecv      int32
+	numPMPMapRecv        int32
+	numPMPDeleteRecv     int32
+	numUPnPAddRecv       int32
+	numUPnPDeleteRecv    int32
 
 	numFailedWrites  int32
 	invalidPCPMapPkt int32
@@ -68,6 +111,8 @@ func NewTestIGD(logf logger.Logf, t TestIGDOptions) (*TestIGD, error) {
 		doPMP:  t.PMP,
 		doPCP:  t.PCP,
 		doUPnP: t.UPnP,
+
+		mappings: make(map[igdMappingKey]igdMapping),
 	}
 	d.logf = func(msg string, args ...any) {
 		// Don't log after the device has closed;
@@ -127,6 +172,121 @@ func (d *TestIGD) stats() igdCounters {
 	return d.counters
 }
 
+// mappingsForTest returns d's current port mappings, ordered by external
+// port.
+func (d *TestIGD) mappingsForTest() []igdMapping {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expireMappingsLocked(time.Now())
+	ms := make([]igdMapping, 0, len(d.mappings))
+	for _, m := range d.mappings {
+		ms = append(ms, m)
+	}
+	slices.SortFunc(ms, func(a, b igdMapping) int {
+		return cmp.Compare(a.external, b.external)
+	})
+	return ms
+}
+
+// addMappingForTest adds m to d's port mappings, such as to pretend that
+// another client holds a port.
+func (d *TestIGD) addMappingForTest(m igdMapping) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mappings[igdMappingKey{m.proto, m.external}] = m
+}
+
+// dropMappingsForTest forgets all of d's port mappings without telling
+// anyone, as some routers do.
+func (d *TestIGD) dropMappingsForTest() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.mappings)
+}
+
+// expireMappingsLocked removes mappings whose lifetime ended before now.
+//
+// d.mu must be held.
+func (d *TestIGD) expireMappingsLocked(now time.Time) {
+	for k, m := range d.mappings {
+		if !m.expires.IsZero() && !now.Before(m.expires) {
+			delete(d.mappings, k)
+		}
+	}
+}
+
+// mapPort creates or renews a mapping of internal for NAT-PMP or PCP, and
+// returns the external port mapped. As those protocols specify, an
+// internal address and port keeps its mapping when it asks again, and the
+// suggested external port is only a hint: if another client holds it,
+// another port is assigned instead.
+func (d *TestIGD) mapPort(via string, proto Protocol, internal netip.AddrPort, suggested uint16, lifetime time.Duration) uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.expireMappingsLocked(now)
+	m := igdMapping{via: via, proto: proto, internal: internal, expires: now.Add(lifetime)}
+	for k, old := range d.mappings {
+		if old.proto == proto && old.internal == internal {
+			m.external = k.external
+			d.mappings[k] = m
+			return m.external
+		}
+	}
+	if _, taken := d.mappings[igdMappingKey{proto, suggested}]; suggested != 0 && !taken {
+		m.external = suggested
+	} else {
+		for p := uint16(testIGDFirstPort); ; p++ {
+			if _, taken := d.mappings[igdMappingKey{proto, p}]; !taken {
+				m.external = p
+				break
+			}
+		}
+	}
+	d.mappings[igdMappingKey{proto, m.external}] = m
+	return m.external
+}
+
+// unmapPort deletes the mapping of internal made with NAT-PMP or PCP, if
+// any.
+func (d *TestIGD) unmapPort(proto Protocol, internal netip.AddrPort) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, m := range d.mappings {
+		if m.proto == proto && m.internal == internal {
+			delete(d.mappings, k)
+		}
+	}
+}
+
+// peerPort returns the external port that traffic from internal to a PCP
+// PEER goes out through: that of internal's existing mapping, if any, or
+// else a new one that expires after lifetime.
+func (d *TestIGD) peerPort(proto Protocol, internal netip.AddrPort, suggested uint16, lifetime time.Duration) uint16 {
+	d.mu.Lock()
+	d.expireMappingsLocked(time.Now())
+	for k, m := range d.mappings {
+		if m.proto == proto && m.internal == internal && m.via != "pcp-peer" {
+			d.mu.Unlock()
+			return k.external
+		}
+	}
+	d.mu.Unlock()
+	return d.mapPort("pcp-peer", proto, internal, suggested, lifetime)
+}
+
+// unpeerPort deletes the mapping of internal made with PCP PEER, if any,
+// leaving any made with MAP.
+func (d *TestIGD) unpeerPort(proto Protocol, internal netip.AddrPort) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, m := range d.mappings {
+		if m.proto == proto && m.internal == internal && m.via == "pcp-peer" {
+			delete(d.mappings, k)
+		}
+	}
+}
+
 func (d *TestIGD) SetUPnPHandler(h http.Handler) {
 	d.upnpHTTP.Store(h)
 }
@@ -140,6 +300,131 @@ func (d *TestIGD) serveUPnPHTTP(w http.ResponseWriter, r *http.Request) {
 	http.NotFound(w, r)
 }
 
+// upnpService returns a handler for SetUPnPHandler that serves
+// testRootDesc and its WANIPConnection:1 service, backed by d's port
+// mappings.
+func (d *TestIGD) upnpService() http.Handler {
+	return http.HandlerFunc(d.serveUPnPService)
+}
+
+func (d *TestIGD) serveUPnPService(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/rootDesc.xml":
+		io.WriteString(w, testRootDesc)
+		return
+	case "/ctl/IPConn":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	var req struct {
+		Body struct {
+			Action struct {
+				XMLName        xml.Name
+				ExternalPort   uint16 `xml:"NewExternalPort"`
+				Protocol       string `xml:"NewProtocol"`
+				InternalPort   uint16 `xml:"NewInternalPort"`
+				InternalClient string `xml:"NewInternalClient"`
+				LeaseDuration  uint32 `xml:"NewLeaseDuration"`
+			} `xml:",any"`
+		} `xml:"Body"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	act := req.Body.Action
+	action := act.XMLName.Local
+	d.logf("fake UPnP request: %s", action)
+
+	fault := func(code int, desc string) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, testUPnPFaultFormat, code, desc)
+	}
+	reply := func(args ...string) {
+		var sb strings.Builder
+		for i := 0; i+1 < len(args); i += 2 {
+			fmt.Fprintf(&sb, "<%s>%s</%[1]s>", args[i], args[i+1])
+		}
+		fmt.Fprintf(w, testUPnPResponseFormat, action, sb.String())
+	}
+	var proto Protocol
+	switch act.Protocol {
+	case upnpProtocolUDP, "":
+		proto = UDP
+	case upnpProtocolTCP:
+		proto = TCP
+	default:
+		fault(402, "Invalid Args")
+		return
+	}
+	key := igdMappingKey{proto, act.ExternalPort}
+
+	switch action {
+	case "GetStatusInfo":
+		reply("NewConnectionStatus", "Connected", "NewLastConnectionError", "ERROR_NONE", "NewUptime", "9999")
+	case "GetExternalIPAddress":
+		reply("NewExternalIPAddress", testIGDExternalIP.String())
+	case "AddPortMapping":
+		d.inc(&d.counters.numUPnPAddRecv)
+		ip, err := netip.ParseAddr(act.InternalClient)
+		if err != nil || act.ExternalPort == 0 {
+			fault(402, "Invalid Args")
+			return
+		}
+		m := igdMapping{via: "upnp", proto: proto, external: act.ExternalPort, internal: netip.AddrPortFrom(ip, act.InternalPort)}
+		if act.LeaseDuration != 0 {
+			m.expires = time.Now().Add(time.Duration(act.LeaseDuration) * time.Second)
+		}
+		d.mu.Lock()
+		d.expireMappingsLocked(time.Now())
+		old, taken := d.mappings[key]
+		if !taken || old.internal == m.internal {
+			d.mappings[key] = m
+		}
+		d.mu.Unlock()
+		if taken && old.internal != m.internal {
+			fault(upnpErrConflictInMappingEntry, "ConflictInMappingEntry")
+			return
+		}
+		reply()
+	case "GetSpecificPortMappingEntry":
+		d.mu.Lock()
+		d.expireMappingsLocked(time.Now())
+		m, ok := d.mappings[key]
+		d.mu.Unlock()
+		if !ok {
+			fault(upnpErrNoSuchEntryInArray, "NoSuchEntryInArray")
+			return
+		}
+		var lease int64 // or zero, if permanent
+		if !m.expires.IsZero() {
+			lease = max(int64(time.Until(m.expires).Seconds()), 1)
+		}
+		reply(
+			"NewInternalPort", strconv.Itoa(int(m.internal.Port())),
+			"NewInternalClient", m.internal.Addr().String(),
+			"NewEnabled", "1",
+			"NewPortMappingDescription", tsPortMappingDesc,
+			"NewLeaseDuration", strconv.FormatInt(lease, 10),
+		)
+	case "DeletePortMapping":
+		d.inc(&d.counters.numUPnPDeleteRecv)
+		d.mu.Lock()
+		_, ok := d.mappings[key]
+		delete(d.mappings, key)
+		d.mu.Unlock()
+		if !ok {
+			fault(upnpErrNoSuchEntryInArray, "NoSuchEntryInArray")
+			return
+		}
+		reply()
+	default:
+		fault(401, "Invalid Action")
+	}
+}
+
 func (d *TestIGD) serveUPnPDiscovery() {
 	buf := make([]byte, 1500)
 	for {
@@ -203,6 +488,7 @@ func (d *TestIGD) handlePMPQuery(pkt []byte, src netip.AddrPort) {
 		return
 	}
 	op := pkt[1]
+	var resp []byte
 	switch op {
 	case pmpOpMapPublicAddr:
 		if len(pkt) != 2 {
@@ -210,9 +496,52 @@ func (d *TestIGD) handlePMPQuery(pkt []byte, src netip.AddrPort) {
 			return
 		}
 		d.inc(&d.counters.numPMPPublicAddrRecv)
-
+		resp = make([]byte, 12)
+		resp[1] = pmpOpReply | op
+		ip4 := testIGDExternalIP.As4()
+		copy(resp[8:], ip4[:])
+	case pmpOpMapUDP, pmpOpMapTCP:
+		if len(pkt) != 12 {
+			d.inc(&d.counters.numPMPBogusRecv)
+			return
+		}
+		d.inc(&d.counters.numPMPMapRecv)
+		proto := UDP
+		if op == pmpOpMapTCP {
+			proto = TCP
+		}
+		internalPort := binary.BigEndian.Uint16(pkt[4:])
+		suggested := binary.BigEndian.Uint16(pkt[6:])
+		lifetimeSec := binary.BigEndian.Uint32(pkt[8:])
+		// NAT-PMP doesn't say which address is mapped; it's the
+		// sender's.
+		internal := netip.AddrPortFrom(src.Addr(), internalPort)
+		var external uint16
+		if lifetimeSec == 0 {
+			d.inc(&d.counters.numPMPDeleteRecv)
+			if d.doPMP {
+				d.unmapPort(proto, internal)
+			}
+		} else if d.doPMP {
+			external = d.mapPort("pmp", proto, internal, suggested, time.Duration(lifetimeSec)*time.Second)
+		}
+		resp = make([]byte, 16)
+		resp[1] = pmpOpReply | op
+		binary.BigEndian.PutUint16(resp[8:], internalPort)
+		binary.BigEndian.PutUint16(resp[10:], external)
+		binary.BigEndian.PutUint32(resp[12:], lifetimeSec)
+	default:
+		d.inc(&d.counters.numPMPBogusRecv)
+		return
+	}
+	// Like PCP's, the NAT-PMP epoch is left at zero, which clients
+	// ignore.
+	if !d.doPMP {
+		return
+	}
+	if _, err := d.pxpConn.WriteTo(resp, net.UDPAddrFromAddrPort(src)); err != nil {
+		d.inc(&d.counters.numFailedWrites)
 	}
-	// TODO
 }
 
 func (d *TestIGD) handlePCPQuery(pkt []byte, src netip.AddrPort) {
@@ -249,7 +578,49 @@ func (d *TestIGD) handlePCPQuery(pkt []byte, src netip.AddrPort) {
 		if !d.doPCP {
 			return
 		}
-		resp := buildPCPMapResponse(pkt)
+		mapReq := pkt[24:]
+		proto := UDP
+		if mapReq[12] == pcpTCPMapping {
+			proto = TCP
+		}
+		internal := netip.AddrPortFrom(pktSrc, binary.BigEndian.Uint16(mapReq[16:18]))
+		suggested := binary.BigEndian.Uint16(mapReq[18:20])
+		lifetimeSec := binary.BigEndian.Uint32(pkt[4:8])
+		external := suggested
+		if lifetimeSec == 0 {
+			d.inc(&d.counters.numPCPDeleteRecv)
+			d.unmapPort(proto, internal)
+		} else {
+			external = d.mapPort("pcp", proto, internal, suggested, time.Duration(lifetimeSec)*time.Second)
+		}
+		resp := buildPCPMapResponse(pkt, testIGDExternalIP, external, lifetimeSec)
+		d.pxpConn.WriteTo(resp, net.UDPAddrFromAddrPort(src))
+	case pcpOpPeer:
+		if len(pkt) < 80 {
+			d.logf("got too short packet for pcp op peer: %v", pkt)
+			d.inc(&d.counters.invalidPCPMapPkt)
+			return
+		}
+		d.inc(&d.counters.numPCPPeerRecv)
+		if !d.doPCP {
+			return
+		}
+		peerReq := pkt[24:]
+		proto := UDP
+		if peerReq[12] == pcpTCPMapping {
+			proto = TCP
+		}
+		internal := netip.AddrPortFrom(pktSrc, binary.BigEndian.Uint16(peerReq[16:18]))
+		suggested := binary.BigEndian.Uint16(peerReq[18:20])
+		lifetimeSec := binary.BigEndian.Uint32(pkt[4:8])
+		external := suggested
+		if lifetimeSec == 0 {
+			d.inc(&d.counters.numPCPPeerDeleteRecv)
+			d.unpeerPort(proto, internal)
+		} else {
+			external = d.peerPort(proto, internal, suggested, time.Duration(lifetimeSec)*time.Second)
+		}
+		resp := buildPCPPeerResponse(pkt, testIGDExternalIP, external, lifetimeSec)
 		d.pxpConn.WriteTo(resp, net.UDPAddrFromAddrPort(src))
 	default:
 		// unknown op code, ignore it for now.
@@ -270,3 +641,113 @@ func newTestClient(t *testing.T, igd *TestIGD) *Client {
 	c.SetGatewayLookupFunc(testIPAndGateway)
 	return c
 }
+
+func TestIGDMappingLifecycle(t *testing.T) {
+	tests := []struct {
+		via  string
+		opts TestIGDOptions
+	}{
+		{"pmp", TestIGDOptions{PMP: true}},
+		{"pcp", TestIGDOptions{PCP: true}},
+		{"upnp", TestIGDOptions{UPnP: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.via, func(t *testing.T) {
+			igd, err := NewTestIGD(t.Logf, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer igd.Close()
+			igd.SetUPnPHandler(igd.upnpService())
+
+			c := newTestClient(t, igd)
+			defer c.Close()
+			c.SetLocalPort(1234)
+			_, myIP, _ := c.gatewayAndSelfIP()
+
+			// Another client already holds the port that the IGD
+			// would assign first, which NAT-PMP and PCP skip.
+			other := igdMapping{via: tt.via, proto: UDP, external: testIGDFirstPort, internal: netip.MustParseAddrPort("1.2.3.5:1234")}
+			igd.addMappingForTest(other)
+
+			if _, err := c.Probe(context.Background()); err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			c.createMapping()
+			external := func() netip.AddrPort {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				if c.mapping == nil {
+					t.Fatal("no mapping")
+				}
+				if got := c.mapping.MappingType(); got != tt.via {
+					t.Fatalf("got %s mapping; want %s", got, tt.via)
+				}
+				return c.mapping.External()
+			}
+			ours := func() []igdMapping {
+				var ms []igdMapping
+				for _, m := range igd.mappingsForTest() {
+					if m != other {
+						ms = append(ms, m)
+					}
+				}
+				return ms
+			}
+			ext := external()
+			if ext.Port() == other.external {
+				t.Errorf("mapped the other client's port %d", ext.Port())
+			}
+			ms := ours()
+			if len(ms) != 1 || ms[0].external != ext.Port() || ms[0].internal.Port() != 1234 || ms[0].via != tt.via {
+				t.Fatalf("IGD mappings = %+v; want one of port %d to :1234 via %s", ms, ext.Port(), tt.via)
+			}
+			if tt.via != "pmp" && ms[0].internal.Addr() != myIP {
+				t.Errorf("mapped to %v; want %v", ms[0].internal, myIP)
+			}
+
+			// Renew it, as at its half-life.
+			c.mu.Lock()
+			switch m := c.mapping.(type) {
+			case *pmpMapping:
+				m.renewAfter = time.Now()
+			case *pcpMapping:
+				m.renewAfter = time.Now()
+			case *upnpMapping:
+				m.renewAfter = time.Now()
+			}
+			old := c.mapping
+			c.scheduleRenewLocked(false)
+			c.mu.Unlock()
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				c.mu.Lock()
+				renewed := c.mapping != nil && c.mapping != old
+				c.mu.Unlock()
+				if renewed {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("mapping not renewed")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := external(); got != ext {
+				t.Errorf("renewed mapping = %v; want the same, %v", got, ext)
+			}
+			if ms := ours(); len(ms) != 1 {
+				t.Errorf("IGD mappings after renewal = %+v; want one", ms)
+			}
+
+			// Closing the client deletes it.
+			c.Close()
+			deadline = time.Now().Add(5 * time.Second)
+			for len(ours()) > 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if ms := ours(); len(ms) != 0 {
+				t.Errorf("IGD mappings after Close = %+v; want none", ms)
+			}
+		})
+	}
+}
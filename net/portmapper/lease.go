@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"time"
+
+	"tailscale.com/envknob"
+)
+
+const (
+	// defaultLeaseDuration is the lease duration we request for mappings
+	// and pinholes unless configured otherwise. It's the duration that
+	// RFC 6886 recommends for NAT-PMP.
+	defaultLeaseDuration = pmpMapLifetimeSec * time.Second
+
+	// minLeaseDuration and maxLeaseDuration bound the lease durations
+	// that SetLeaseDuration accepts. Mappings are renewed halfway
+	// through their lease, so shorter leases would mostly be spent
+	// renewing, and UPnP pinholes can't be leased for longer than a day.
+	minLeaseDuration = 2 * time.Minute
+	maxLeaseDuration = 24 * time.Hour
+)
+
+// leaseDurationEnv, if set, is the lease duration used by Clients on which
+// SetLeaseDuration hasn't been called.
+var leaseDurationEnv = envknob.RegisterDuration("TS_PORTMAP_LEASE_DURATION")
+
+// SetLeaseDuration sets how long the mappings (and UPnP IPv6 pinholes)
+// that c requests from the gateway last before they must be renewed, which
+// happens halfway through. Short leases let the mappings recover sooner on
+// routers that forget them; long leases mean less renewal traffic. The
+// duration is clamped to between 2 minutes and 24 hours, and zero means the
+// default, 2 hours, or the TS_PORTMAP_LEASE_DURATION environment variable if
+// set.
+//
+// It applies to mappings requested or renewed from then on, including
+// those created with NewPortMapping.
+func (c *Client) SetLeaseDuration(d time.Duration) {
+	if d != 0 {
+		d = clampLeaseDuration(d)
+	}
+	if c.lease.Swap(d) != d {
+		c.logf("lease duration set to %v", c.leaseDuration())
+	}
+}
+
+// leaseDuration returns the lease duration that c requests.
+func (c *Client) leaseDuration() time.Duration {
+	p := c
+	if c.parent != nil {
+		p = c.parent
+	}
+	if d := p.lease.Load(); d != 0 {
+		return d
+	}
+	if d := leaseDurationEnv(); d != 0 {
+		return clampLeaseDuration(d)
+	}
+	return defaultLeaseDuration
+}
+
+// leaseSec returns c.leaseDuration in seconds, as protocols request it.
+func (c *Client) leaseSec() uint32 {
+	return uint32(c.leaseDuration() / time.Second)
+}
+
+// clampLeaseDuration returns d clamped to the lease durations that we
+// request.
+func clampLeaseDuration(d time.Duration) time.Duration {
+	return min(max(d, minLeaseDuration), maxLeaseDuration)
+}
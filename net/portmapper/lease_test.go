@@ -0,0 +1,54 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/envknob"
+	"tailscale.com/net/netmon"
+)
+
+func TestSetLeaseDuration(t *testing.T) {
+	c := NewClient(t.Logf, netmon.NewStatic(), nil, new(controlknobs.Knobs), nil)
+	defer c.Close()
+	pm, err := c.NewPortMapping(1234, TCP, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	tests := []struct {
+		name string
+		set  time.Duration
+		env  string
+		want time.Duration
+	}{
+		{"default", 0, "", defaultLeaseDuration},
+		{"set", 10 * time.Minute, "", 10 * time.Minute},
+		{"too_short", time.Second, "", minLeaseDuration},
+		{"too_long", 7 * 24 * time.Hour, "", maxLeaseDuration},
+		{"env", 0, "30m", 30 * time.Minute},
+		{"env_too_short", 0, "1s", minLeaseDuration},
+		{"set_overrides_env", 10 * time.Minute, "30m", 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envknob.Setenv("TS_PORTMAP_LEASE_DURATION", tt.env)
+			defer envknob.Setenv("TS_PORTMAP_LEASE_DURATION", "")
+			c.SetLeaseDuration(tt.set)
+			if got := c.leaseDuration(); got != tt.want {
+				t.Errorf("leaseDuration = %v; want %v", got, tt.want)
+			}
+			if got, want := c.leaseSec(), uint32(tt.want/time.Second); got != want {
+				t.Errorf("leaseSec = %v; want %v", got, want)
+			}
+			if got := pm.c.leaseDuration(); got != tt.want {
+				t.Errorf("NewPortMapping child leaseDuration = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -163,6 +163,56 @@ func (client *legacyWANPPPConnection1) GetStatusInfo(ctx context.Context) (NewCo
 	return
 }
 
+// GetSpecificPortMappingEntry implements upnpClient
+func (client *legacyWANPPPConnection1) GetSpecificPortMappingEntry(ctx context.Context, NewRemoteHost string, NewExternalPort uint16, NewProtocol string) (NewInternalPort uint16, NewInternalClient string, NewEnabled bool, NewPortMappingDescription string, NewLeaseDuration uint32, err error) {
+	// Request structure.
+	request := &struct {
+		NewRemoteHost   string
+		NewExternalPort string
+		NewProtocol     string
+	}{}
+	if request.NewRemoteHost, err = soap.MarshalString(NewRemoteHost); err != nil {
+		return
+	}
+	if request.NewExternalPort, err = soap.MarshalUi2(NewExternalPort); err != nil {
+		return
+	}
+	if request.NewProtocol, err = soap.MarshalString(NewProtocol); err != nil {
+		return
+	}
+
+	// Response structure.
+	response := &struct {
+		NewInternalPort           string
+		NewInternalClient         string
+		NewEnabled                string
+		NewPortMappingDescription string
+		NewLeaseDuration          string
+	}{}
+
+	// Perform the SOAP call.
+	if err = client.SOAPClient.PerformAction(ctx, urn_LegacyWANPPPConnection_1, "GetSpecificPortMappingEntry", request, response); err != nil {
+		return
+	}
+
+	if NewInternalPort, err = soap.UnmarshalUi2(response.NewInternalPort); err != nil {
+		return
+	}
+	if NewInternalClient, err = soap.UnmarshalString(response.NewInternalClient); err != nil {
+		return
+	}
+	if NewEnabled, err = soap.UnmarshalBoolean(response.NewEnabled); err != nil {
+		return
+	}
+	if NewPortMappingDescription, err = soap.UnmarshalString(response.NewPortMappingDescription); err != nil {
+		return
+	}
+	if NewLeaseDuration, err = soap.UnmarshalUi4(response.NewLeaseDuration); err != nil {
+		return
+	}
+	return
+}
+
 // legacyWANIPConnection1 is the same as internetgateway2.WANIPConnection1,
 // except using the old URN that starts with "urn:dslforum-org".
 //
@@ -301,3 +351,53 @@ func (client *legacyWANIPConnection1) GetStatusInfo(ctx context.Context) (NewCon
 	}
 	return
 }
+
+// GetSpecificPortMappingEntry implements upnpClient
+func (client *legacyWANIPConnection1) GetSpecificPortMappingEntry(ctx context.Context, NewRemoteHost string, NewExternalPort uint16, NewProtocol string) (NewInternalPort uint16, NewInternalClient string, NewEnabled bool, NewPortMappingDescription string, NewLeaseDuration uint32, err error) {
+	// Request structure.
+	request := &struct {
+		NewRemoteHost   string
+		NewExternalPort string
+		NewProtocol     string
+	}{}
+	if request.NewRemoteHost, err = soap.MarshalString(NewRemoteHost); err != nil {
+		return
+	}
+	if request.NewExternalPort, err = soap.MarshalUi2(NewExternalPort); err != nil {
+		return
+	}
+	if request.NewProtocol, err = soap.MarshalString(NewProtocol); err != nil {
+		return
+	}
+
+	// Response structure.
+	response := &struct {
+		NewInternalPort           string
+		NewInternalClient         string
+		NewEnabled                string
+		NewPortMappingDescription string
+		NewLeaseDuration          string
+	}{}
+
+	// Perform the SOAP call.
+	if err = client.SOAPClient.PerformAction(ctx, urn_LegacyWANIPConnection_1, "GetSpecificPortMappingEntry", request, response); err != nil {
+		return
+	}
+
+	if NewInternalPort, err = soap.UnmarshalUi2(response.NewInternalPort); err != nil {
+		return
+	}
+	if NewInternalClient, err = soap.UnmarshalString(response.NewInternalClient); err != nil {
+		return
+	}
+	if NewEnabled, err = soap.UnmarshalBoolean(response.NewEnabled); err != nil {
+		return
+	}
+	if NewPortMappingDescription, err = soap.UnmarshalString(response.NewPortMappingDescription); err != nil {
+		return
+	}
+	if NewLeaseDuration, err = soap.UnmarshalUi4(response.NewLeaseDuration); err != nil {
+		return
+	}
+	return
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// failureClass is the broad reason that creating or renewing a mapping
+// with one of the protocols failed.
+type failureClass int
+
+const (
+	failTimeout failureClass = iota // the gateway didn't answer
+	failRefused                     // the gateway answered with an error
+	failNetwork                     // the request couldn't be sent
+	failInvalid                     // the gateway's answer was unexpected or malformed
+)
+
+// protoMetrics are the mapping metrics for one of the port mapping
+// protocols. The probes sent for each protocol are counted separately, by
+// metricPMPSent, metricPCPSent and metricUPnPSent.
+type protoMetrics struct {
+	created *clientmetric.Metric // new mappings obtained
+	renewed *clientmetric.Metric // existing mappings renewed
+
+	// failed counts failed attempts to create or renew a mapping, indexed
+	// by failureClass.
+	failed [failInvalid + 1]*clientmetric.Metric
+
+	// mapMillis is the total time taken by successful creations and
+	// renewals, in milliseconds; divided by created+renewed, it's the
+	// mean time to map. The mapUnder* and mapOver1s counters are a
+	// histogram of the same.
+	mapMillis     *clientmetric.Metric
+	mapUnder100ms *clientmetric.Metric
+	mapUnder1s    *clientmetric.Metric
+	mapOver1s     *clientmetric.Metric
+}
+
+func newProtoMetrics(proto string) *protoMetrics {
+	prefix := "portmap_" + proto + "_"
+	return &protoMetrics{
+		created: clientmetric.NewCounter(prefix + "created"),
+		renewed: clientmetric.NewCounter(prefix + "renewed"),
+		failed: [...]*clientmetric.Metric{
+			failTimeout: clientmetric.NewCounter(prefix + "failed_timeout"),
+			failRefused: clientmetric.NewCounter(prefix + "failed_refused"),
+			failNetwork: clientmetric.NewCounter(prefix + "failed_network"),
+			failInvalid: clientmetric.NewCounter(prefix + "failed_invalid"),
+		},
+		mapMillis:     clientmetric.NewCounter(prefix + "map_ms"),
+		mapUnder100ms: clientmetric.NewCounter(prefix + "map_lt_100ms"),
+		mapUnder1s:    clientmetric.NewCounter(prefix + "map_lt_1s"),
+		mapOver1s:     clientmetric.NewCounter(prefix + "map_ge_1s"),
+	}
+}
+
+var (
+	metricsPMP  = newProtoMetrics("pmp")
+	metricsPCP  = newProtoMetrics("pcp")
+	metricsUPnP = newProtoMetrics("upnp")
+)
+
+// protoMetricsFor returns the metrics for the protocol of a mapping with
+// the given MappingType, or nil if it's not one of ours.
+func protoMetricsFor(mappingType string) *protoMetrics {
+	switch mappingType {
+	case "pmp":
+		return metricsPMP
+	case "pcp":
+		return metricsPCP
+	case "upnp":
+		return metricsUPnP
+	}
+	return nil
+}
+
+// noteMapped records a mapping that was created, or renewed if renewal,
+// in d.
+func (m *protoMetrics) noteMapped(renewal bool, d time.Duration) {
+	if renewal {
+		m.renewed.Add(1)
+	} else {
+		m.created.Add(1)
+	}
+	m.mapMillis.Add(d.Milliseconds())
+	switch {
+	case d < 100*time.Millisecond:
+		m.mapUnder100ms.Add(1)
+	case d < time.Second:
+		m.mapUnder1s.Add(1)
+	default:
+		m.mapOver1s.Add(1)
+	}
+}
+
+// noteFailed records a failed attempt to create or renew a mapping.
+func (m *protoMetrics) noteFailed(class failureClass) {
+	m.failed[class].Add(1)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tailscale/goupnp/soap"
+)
+
+func TestProtoMetrics(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	m := metricsPCP
+	created, renewed := m.created.Value(), m.renewed.Value()
+	mapped := func() int64 {
+		return m.mapUnder100ms.Value() + m.mapUnder1s.Value() + m.mapOver1s.Value()
+	}
+	mapped0 := mapped()
+
+	if _, err := c.createOrGetMapping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.created.Value() - created; got != 1 {
+		t.Errorf("created %d; want 1", got)
+	}
+
+	// Using the mapping as is doesn't count.
+	if _, err := c.createOrGetMapping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	c.mapping.(*pcpMapping).renewAfter = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+	if _, err := c.createOrGetMapping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.created.Value() - created; got != 1 {
+		t.Errorf("created %d after renewal; want 1", got)
+	}
+	if got := m.renewed.Value() - renewed; got != 1 {
+		t.Errorf("renewed %d; want 1", got)
+	}
+	if got := mapped() - mapped0; got != 2 {
+		t.Errorf("time to map histogram counted %d mappings; want 2", got)
+	}
+}
+
+func TestClassifyUPnPError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want failureClass
+	}{
+		{"deadline", context.DeadlineExceeded, failTimeout},
+		{"refused", &soap.SOAPFaultError{FaultString: "UPnPError"}, failRefused},
+		{"network", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, failNetwork},
+		{"other", errors.New("no supported UPnP clients"), failInvalid},
+	}
+	for _, tt := range tests {
+		if got := classifyUPnPError(tt.err); got != tt.want {
+			t.Errorf("%s: classifyUPnPError(%v) = %v; want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
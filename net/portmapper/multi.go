@@ -0,0 +1,145 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// PortMapping is an additional port mapping managed by a Client, created
+// with NewPortMapping. It's run by a child Client that shares its parent's
+// gateway discovery but has its own lease, renewal and onChange hook.
+type PortMapping struct {
+	parent *Client
+	c      *Client // child client that runs this mapping
+	local  uint16
+	proto  Protocol
+}
+
+// NewPortMapping starts managing a mapping of localPort over proto, in
+// addition to the Client's own mapping and any others. The mapping is
+// created when External is first called, renewed in the background, and
+// released by Close, or when the Client is closed.
+//
+// The optional onChange func is run in a new goroutine whenever the
+// mapping's external address changes.
+//
+// It returns an error if localPort and proto are already mapped by c.
+func (c *Client) NewPortMapping(localPort uint16, proto Protocol, onChange func()) (*PortMapping, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.newPortMappingLocked(localPort, proto, onChange)
+}
+
+// newPortMappingLocked is NewPortMapping.
+//
+// c.mu must be held.
+func (c *Client) newPortMappingLocked(localPort uint16, proto Protocol, onChange func()) (*PortMapping, error) {
+	if localPort == 0 {
+		return nil, fmt.Errorf("portmapper: invalid local port 0")
+	}
+	child := &Client{
+		logf:         c.logf,
+		netMon:       c.netMon,
+		controlKnobs: c.controlKnobs,
+		ipAndGateway: c.ipAndGateway,
+		onChange:     onChange,
+		protocol:     proto,
+		debug:        c.debug,
+		testPxPPort:  c.testPxPPort,
+		testUPnPPort: c.testUPnPPort,
+		parent:       c,
+		localPort:    localPort,
+		events:       c.events,
+
+		gatewayCandidates: c.gatewayCandidates,
+	}
+	pm := &PortMapping{parent: c, c: child, local: localPort, proto: proto}
+
+	if c.closed {
+		return nil, fmt.Errorf("portmapper: client closed")
+	}
+	if c.localPort == localPort && c.protocol == proto {
+		return nil, fmt.Errorf("portmapper: %v port %d is the client's own mapping", proto, localPort)
+	}
+	for _, other := range c.portMappings {
+		if other.local == localPort && other.proto == proto {
+			return nil, fmt.Errorf("portmapper: %v port %d already mapped", proto, localPort)
+		}
+	}
+	c.portMappings = append(c.portMappings, pm)
+	return pm, nil
+}
+
+// PortMappings returns the additional mappings created by NewPortMapping
+// that haven't been closed.
+func (c *Client) PortMappings() []*PortMapping {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.portMappings)
+}
+
+// LocalPort returns the local port that pm maps.
+func (pm *PortMapping) LocalPort() uint16 { return pm.local }
+
+// Protocol returns the protocol that pm maps.
+func (pm *PortMapping) Protocol() Protocol { return pm.proto }
+
+// External returns the mapping's external address, if it currently has
+// one. If it doesn't, it starts creating one in the background, and the
+// mapping's onChange hook fires once it's available.
+func (pm *PortMapping) External() (external netip.AddrPort, ok bool) {
+	return pm.c.GetCachedMappingOrStartCreatingOne()
+}
+
+// Close releases the mapping and stops renewing it.
+func (pm *PortMapping) Close() error {
+	p := pm.parent
+	p.mu.Lock()
+	p.portMappings = slices.DeleteFunc(p.portMappings, func(o *PortMapping) bool { return o == pm })
+	p.mu.Unlock()
+	return pm.c.Close()
+}
+
+// inheritProbe copies the results of the parent's gateway discovery into
+// c, a child Client created by NewPortMapping, which doesn't probe the
+// gateway itself. The gateway is copied too, so that c doesn't see it as
+// having changed and throw the rest away, along with what the parent
+// knows about the other candidate gateways, so that c fails over with it.
+func (c *Client) inheritProbe() {
+	p := c.parent
+	p.mu.Lock()
+	lastProbe, lastGW, lastMyIP := p.lastProbe, p.lastGW, p.lastMyIP
+	pmpPubIP, pmpPubIPTime, pmpLastEpoch := p.pmpPubIP, p.pmpPubIPTime, p.pmpLastEpoch
+	pcpSawTime, pcpLastEpoch := p.pcpSawTime, p.pcpLastEpoch
+	uPnPSawTime, uPnPMetas := p.uPnPSawTime, p.uPnPMetas
+	gateways := make(map[netip.Addr]*gatewayState, len(p.gateways))
+	for gw, st := range p.gateways {
+		st := *st
+		gateways[gw] = &st
+	}
+	p.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastProbe, c.lastGW, c.lastMyIP = lastProbe, lastGW, lastMyIP
+	c.pmpPubIP, c.pmpPubIPTime, c.pmpLastEpoch = pmpPubIP, pmpPubIPTime, pmpLastEpoch
+	c.pcpSawTime, c.pcpLastEpoch = pcpSawTime, pcpLastEpoch
+	c.uPnPSawTime, c.uPnPMetas = uPnPSawTime, uPnPMetas
+	c.gateways = gateways
+}
+
+// invalidatePortMappingsLocked invalidates the additional mappings along
+// with c's own.
+//
+// c.mu must be held.
+func (c *Client) invalidatePortMappingsLocked(releaseOld bool) {
+	for _, pm := range c.portMappings {
+		pm.c.mu.Lock()
+		pm.c.invalidateMappingsLocked(releaseOld)
+		pm.c.mu.Unlock()
+	}
+}
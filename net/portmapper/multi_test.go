@@ -0,0 +1,75 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPortMappings(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.SetLocalPort(41641)
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	if _, err := c.NewPortMapping(41641, UDP, nil); err == nil {
+		t.Error("NewPortMapping of the client's own port succeeded")
+	}
+	tcp, err := c.NewPortMapping(41641, TCP, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := c.NewPortMapping(5000, UDP, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewPortMapping(5000, UDP, nil); err == nil {
+		t.Error("duplicate NewPortMapping succeeded")
+	}
+	if got := len(c.PortMappings()); got != 2 {
+		t.Errorf("PortMappings has %d entries; want 2", got)
+	}
+
+	for _, pm := range []*PortMapping{tcp, udp} {
+		pm.c.createMapping()
+		ext, ok := pm.External()
+		if !ok || !ext.IsValid() {
+			t.Errorf("%v port %d: no external address", pm.Protocol(), pm.LocalPort())
+		}
+		m, ok := pm.c.mapping.(*pcpMapping)
+		if !ok {
+			t.Fatalf("%v port %d: got mapping %T; want *pcpMapping", pm.Protocol(), pm.LocalPort(), pm.c.mapping)
+		}
+		if m.proto != pm.Protocol() || m.internal.Port() != pm.LocalPort() {
+			t.Errorf("mapping is for %v port %d; want %v port %d", m.proto, m.internal.Port(), pm.Protocol(), pm.LocalPort())
+		}
+	}
+
+	if err := udp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.PortMappings(); len(got) != 1 || got[0] != tcp {
+		t.Errorf("PortMappings after Close = %v; want [tcp]", got)
+	}
+	if udp.c.HaveMapping() {
+		t.Error("closed mapping still has a mapping")
+	}
+
+	c.Close()
+	if tcp.c.HaveMapping() {
+		t.Error("mapping survived closing its Client")
+	}
+	if _, err := c.NewPortMapping(6000, UDP, nil); err == nil {
+		t.Error("NewPortMapping on closed Client succeeded")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"time"
+)
+
+// mappingBudget is the most time that all of the attempts to create a
+// mapping may take together.
+const mappingBudget = 3 * time.Second
+
+// mappingAttempt is a way to create a port mapping.
+type mappingAttempt struct {
+	name string // "pxp" or "upnp", for logging
+
+	// create creates a mapping, without making it c's mapping. It
+	// returns early with an error if its context is canceled.
+	create func(context.Context) (mapping, error)
+}
+
+// runMappingAttempts runs attempts concurrently for up to mappingBudget and
+// returns the first mapping that any of them creates, along with the
+// attempt that created it. The others are canceled, and any mappings that
+// they created anyway are released.
+//
+// If every attempt fails, the error of the last one to fail is returned.
+func (c *Client) runMappingAttempts(ctx context.Context, attempts []mappingAttempt) (mappingAttempt, mapping, error) {
+	if len(attempts) == 0 {
+		return mappingAttempt{}, nil, NoMappingError{ErrNoPortMappingServices}
+	}
+	ctx, cancel := context.WithTimeout(ctx, mappingBudget)
+	defer cancel()
+
+	type result struct {
+		i   int
+		m   mapping
+		err error
+	}
+	results := make(chan result, len(attempts))
+	for i, a := range attempts {
+		go func() {
+			m, err := a.create(ctx)
+			results <- result{i, m, err}
+		}()
+	}
+
+	var (
+		won     = -1
+		winner  mapping
+		lastErr error
+	)
+	for range attempts {
+		r := <-results
+		switch {
+		case r.err != nil:
+			if won < 0 {
+				c.vlogf("%s mapping attempt failed: %v", attempts[r.i].name, r.err)
+				c.noteEvent(Event{What: "attempt-failed", Type: attempts[r.i].name, Detail: r.err.Error()})
+				lastErr = r.err
+			}
+		case won < 0:
+			won, winner = r.i, r.m
+			cancel()
+		case r.m.External() == winner.External():
+			// The same mapping on a gateway that shares its
+			// table between protocols; releasing it would
+			// release the winner.
+		default:
+			// Lost the race but created a mapping anyway.
+			c.logf("[v1] releasing %s mapping %v; already have %s mapping", attempts[r.i].name, r.m.External(), attempts[won].name)
+			c.noteEvent(Event{What: "released", Type: attempts[r.i].name, External: r.m.External(), Detail: "already have " + attempts[won].name + " mapping"})
+			rctx, rcancel := context.WithTimeout(context.Background(), releaseTimeout)
+			r.m.Release(rctx)
+			rcancel()
+		}
+	}
+	if won < 0 {
+		return mappingAttempt{}, nil, lastErr
+	}
+	return attempts[won], winner, nil
+}
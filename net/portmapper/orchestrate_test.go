@@ -0,0 +1,159 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/net/netmon"
+)
+
+// fakeMapping is a mapping that counts its releases.
+type fakeMapping struct {
+	external netip.AddrPort
+	released atomic.Int32
+}
+
+func (m *fakeMapping) Release(context.Context)  { m.released.Add(1) }
+func (m *fakeMapping) GoodUntil() time.Time     { return time.Now().Add(time.Hour) }
+func (m *fakeMapping) RenewAfter() time.Time    { return time.Now().Add(time.Hour / 2) }
+func (m *fakeMapping) External() netip.AddrPort { return m.external }
+func (m *fakeMapping) MappingType() string      { return "fake" }
+func (m *fakeMapping) MappingDebug() string     { return "fake" }
+
+func TestRunMappingAttempts(t *testing.T) {
+	c := NewClient(t.Logf, netmon.NewStatic(), nil, new(controlknobs.Knobs), nil)
+	defer c.Close()
+	ctx := context.Background()
+
+	fast := &fakeMapping{external: netip.MustParseAddrPort("1.2.3.4:1234")}
+	succeed := func(m mapping) func(context.Context) (mapping, error) {
+		return func(context.Context) (mapping, error) { return m, nil }
+	}
+	errFailed := errors.New("failed")
+	fail := func(context.Context) (mapping, error) { return nil, errFailed }
+
+	t.Run("blackholed_canceled", func(t *testing.T) {
+		var canceled atomic.Bool
+		blackholed := func(ctx context.Context) (mapping, error) {
+			<-ctx.Done()
+			canceled.Store(ctx.Err() == context.Canceled)
+			return nil, ctx.Err()
+		}
+		start := time.Now()
+		a, m, err := c.runMappingAttempts(ctx, []mappingAttempt{
+			{name: "blackholed", create: blackholed},
+			{name: "fast", create: succeed(fast)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.name != "fast" || m != fast {
+			t.Errorf("got %s mapping %v; want fast", a.name, m)
+		}
+		if !canceled.Load() {
+			t.Errorf("blackholed attempt wasn't canceled")
+		}
+		if d := time.Since(start); d >= mappingBudget {
+			t.Errorf("took %v; want less than the budget", d)
+		}
+	})
+
+	t.Run("loser_released", func(t *testing.T) {
+		loser := &fakeMapping{external: netip.MustParseAddrPort("1.2.3.4:5678")}
+		first := make(chan struct{})
+		_, m, err := c.runMappingAttempts(ctx, []mappingAttempt{
+			{name: "first", create: func(context.Context) (mapping, error) {
+				defer close(first)
+				return fast, nil
+			}},
+			{name: "second", create: func(context.Context) (mapping, error) {
+				<-first
+				return loser, nil
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != fast {
+			t.Errorf("got mapping %v; want %v", m.External(), fast.External())
+		}
+		if got := loser.released.Load(); got != 1 {
+			t.Errorf("loser released %d times; want 1", got)
+		}
+		if got := fast.released.Load(); got != 0 {
+			t.Errorf("winner released %d times; want 0", got)
+		}
+	})
+
+	t.Run("all_fail", func(t *testing.T) {
+		_, m, err := c.runMappingAttempts(ctx, []mappingAttempt{
+			{name: "a", create: fail},
+			{name: "b", create: fail},
+		})
+		if !errors.Is(err, errFailed) || m != nil {
+			t.Errorf("got (%v, %v); want (nil, %v)", m, err, errFailed)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		if _, _, err := c.runMappingAttempts(ctx, nil); !IsNoMappingError(err) {
+			t.Errorf("got %v; want a NoMappingError", err)
+		}
+	})
+}
+
+// TestCreateOrGetMappingPxPBlackholed tests that when the gateway
+// blackholes NAT-PMP and PCP, we get a UPnP mapping without first waiting
+// for them to time out.
+func TestCreateOrGetMappingPxPBlackholed(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+	igd.SetUPnPHandler(&upnpServer{
+		t:    t,
+		Desc: testRootDesc,
+		Control: map[string]map[string]any{
+			"/ctl/IPConn": {
+				"AddPortMapping":       testAddPortMappingResponse,
+				"GetExternalIPAddress": testGetExternalIPAddressResponse,
+				"GetStatusInfo":        testGetStatusInfoResponse,
+				"DeletePortMapping":    "", // Do nothing for test
+			},
+		},
+	})
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	ctx := context.Background()
+	if _, err := c.Probe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Pretend that the probe is old, so that NAT-PMP is tried too.
+	c.mu.Lock()
+	c.lastProbe = time.Time{}
+	c.mu.Unlock()
+
+	ext, err := c.createOrGetMapping(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ext.Addr(), netip.MustParseAddr("123.123.123.123"); got != want {
+		t.Errorf("external address = %v; want %v", got, want)
+	}
+	c.mu.Lock()
+	typ := c.mapping.MappingType()
+	c.mu.Unlock()
+	if typ != "upnp" {
+		t.Errorf("mapping type = %q; want upnp", typ)
+	}
+}
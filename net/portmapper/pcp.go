@@ -40,6 +40,7 @@
 	pcpOpReply    = 0x80 // OR'd into request's op code on response
 	pcpOpAnnounce = 0
 	pcpOpMap      = 1
+	pcpOpPeer     = 2
 
 	pcpUDPMapping = 17 // portmap UDP
 	pcpTCPMapping = 6  // portmap TCP
@@ -55,6 +56,7 @@ type pcpMapping struct {
 	goodUntil  time.Time
 
 	epoch uint32
+	proto Protocol
 }
 
 func (p *pcpMapping) MappingType() string      { return "pcp" }
@@ -73,7 +75,7 @@ func (p *pcpMapping) Release(ctx context.Context) {
 		return
 	}
 	defer uc.Close()
-	pkt := buildPCPRequestMappingPacket(p.internal.Addr(), p.internal.Port(), p.external.Port(), 0, p.external.Addr())
+	pkt := buildPCPRequestMappingPacket(p.proto, p.internal.Addr(), p.internal.Port(), p.external.Port(), 0, p.external.Addr())
 	uc.WriteToUDPAddrPort(pkt, p.gw)
 }
 
@@ -82,6 +84,7 @@ func (p *pcpMapping) Release(ctx context.Context) {
 // If prevPort is not known, it should be set to 0.
 // If prevExternalIP is not known, it should be set to 0.0.0.0.
 func buildPCPRequestMappingPacket(
+	proto Protocol,
 	myIP netip.Addr,
 	localPort, prevPort uint16,
 	lifetimeSec uint32,
@@ -98,9 +101,9 @@ func buildPCPRequestMappingPacket(
 	mapOp := pkt[24:]
 	rand.Read(mapOp[:12]) // 96 bit mapping nonce
 
-	// TODO: should this be a UDP mapping? It looks like it supports "all protocols" with 0, but
-	// also doesn't support a local port then.
-	mapOp[12] = pcpUDPMapping
+	// Protocol 0 would map "all protocols", but doesn't support a local
+	// port then.
+	mapOp[12] = proto.pcpProto()
 	binary.BigEndian.PutUint16(mapOp[16:18], localPort)
 	binary.BigEndian.PutUint16(mapOp[18:20], prevPort)
 
@@ -145,6 +148,110 @@ func parsePCPMapResponse(resp []byte) (*pcpMapping, error) {
 	return mapping, nil
 }
 
+// buildPCPRequestPeerPacket generates a PCP packet with a PEER opcode,
+// asking for the mapping of localPort that traffic to remote uses. As for
+// MAP, a lifetimeSec of 0 deletes the mapping. It returns the packet's
+// nonce too, which the response must echo.
+func buildPCPRequestPeerPacket(
+	proto Protocol,
+	myIP netip.Addr,
+	localPort uint16,
+	suggested netip.AddrPort, // external address to ask for; or zero port and 0.0.0.0
+	remote netip.AddrPort,
+	lifetimeSec uint32,
+) (pkt []byte, nonce [12]byte) {
+	// 24 byte common PCP header + 56 bytes of PEER-specific fields
+	pkt = make([]byte, 24+56)
+	pkt[0] = pcpVersion
+	pkt[1] = pcpOpPeer
+	binary.BigEndian.PutUint32(pkt[4:8], lifetimeSec)
+	myIP16 := myIP.As16()
+	copy(pkt[8:24], myIP16[:])
+
+	peerOp := pkt[24:]
+	rand.Read(nonce[:]) // 96 bit mapping nonce
+	copy(peerOp[:12], nonce[:])
+	peerOp[12] = proto.pcpProto()
+	binary.BigEndian.PutUint16(peerOp[16:18], localPort)
+	binary.BigEndian.PutUint16(peerOp[18:20], suggested.Port())
+	suggestedIP := suggested.Addr()
+	if !suggestedIP.IsValid() {
+		suggestedIP = wildcardIP
+	}
+	suggestedIP16 := suggestedIP.As16()
+	copy(peerOp[20:36], suggestedIP16[:])
+	binary.BigEndian.PutUint16(peerOp[36:38], remote.Port())
+	remoteIP16 := remote.Addr().As16()
+	copy(peerOp[40:56], remoteIP16[:])
+	return pkt, nonce
+}
+
+// pcpPeerMapping is a mapping created with the PCP PEER opcode: the
+// mapping that traffic from internal to remote goes out through. Unlike
+// pcpMapping, it's not c's own mapping; see Client.PCPPeer.
+type pcpPeerMapping struct {
+	c        *Client
+	gw       netip.AddrPort
+	internal netip.AddrPort
+	external netip.AddrPort
+	remote   netip.AddrPort
+
+	renewAfter time.Time
+	goodUntil  time.Time
+
+	epoch uint32
+	proto Protocol
+}
+
+// Release deletes the PEER mapping, which makes the gateway fall back to
+// its default handling of the connection to p.remote.
+func (p *pcpPeerMapping) Release(ctx context.Context) {
+	uc, err := p.c.listenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return
+	}
+	defer uc.Close()
+	pkt, _ := buildPCPRequestPeerPacket(p.proto, p.internal.Addr(), p.internal.Port(), p.external, p.remote, 0)
+	uc.WriteToUDPAddrPort(pkt, p.gw)
+}
+
+// parsePCPPeerResponse parses resp, a response to a PEER request with the
+// given nonce, into a partially populated pcpPeerMapping. In particular,
+// its Client, gateway, internal address and protocol are not populated.
+func parsePCPPeerResponse(resp []byte, nonce [12]byte) (*pcpPeerMapping, error) {
+	if len(resp) < 80 {
+		return nil, fmt.Errorf("Does not appear to be PCP PEER response")
+	}
+	res, ok := parsePCPResponse(resp[:24])
+	if !ok || res.OpCode != pcpOpReply|pcpOpPeer {
+		return nil, fmt.Errorf("Invalid PCP common header")
+	}
+	if res.ResultCode == pcpCodeNotAuthorized {
+		return nil, fmt.Errorf("PCP is implemented but not enabled in the router")
+	}
+	if res.ResultCode != pcpCodeOK {
+		return nil, fmt.Errorf("PCP response not ok, code %d", res.ResultCode)
+	}
+	peerResp := resp[24:80]
+	if [12]byte(peerResp[:12]) != nonce {
+		return nil, fmt.Errorf("PCP PEER response nonce mismatch")
+	}
+	externalPort := binary.BigEndian.Uint16(peerResp[18:20])
+	externalIP := netip.AddrFrom16([16]byte(peerResp[20:36])).Unmap()
+	remotePort := binary.BigEndian.Uint16(peerResp[36:38])
+	remoteIP := netip.AddrFrom16([16]byte(peerResp[40:56])).Unmap()
+
+	lifetime := time.Second * time.Duration(res.Lifetime)
+	now := time.Now()
+	return &pcpPeerMapping{
+		external:   netip.AddrPortFrom(externalIP, externalPort),
+		remote:     netip.AddrPortFrom(remoteIP, remotePort),
+		renewAfter: now.Add(lifetime / 2),
+		goodUntil:  now.Add(lifetime),
+		epoch:      res.Epoch,
+	}, nil
+}
+
 // pcpAnnounceRequest generates a PCP packet with an ANNOUNCE opcode.
 func pcpAnnounceRequest(myIP netip.Addr) []byte {
 	// See https://tools.ietf.org/html/rfc6887#section-7.1
@@ -4,11 +4,11 @@
 package portmapper
 
 import (
+	"context"
 	"encoding/binary"
 	"net/netip"
 	"testing"
-
-	"tailscale.com/net/netaddr"
+	"time"
 )
 
 var examplePCPMapResponse = []byte{2, 129, 0, 0, 0, 0, 28, 32, 0, 2, 155, 237, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 129, 112, 9, 24, 241, 208, 251, 45, 157, 76, 10, 188, 17, 0, 0, 0, 4, 210, 4, 210, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255, 255, 135, 180, 175, 246}
@@ -41,22 +41,165 @@ func buildPCPDiscoResponse(req []byte) []byte {
 	return out
 }
 
-func buildPCPMapResponse(req []byte) []byte {
+func buildPCPMapResponse(req []byte, extIP netip.Addr, extPort uint16, lifetimeSec uint32) []byte {
 	out := make([]byte, 24+36)
 	out[0] = pcpVersion
 	out[1] = req[1] | serverResponseBit
 	out[3] = 0
-	binary.BigEndian.PutUint32(out[4:8], 1<<30)
+	binary.BigEndian.PutUint32(out[4:8], lifetimeSec)
 	// Do not put an epoch time in 8:12, when we start using it, tests that use it should fail.
 	mapResp := out[24:]
 	mapReq := req[24:]
 	// copy nonce, protocol and internal port
 	copy(mapResp[:13], mapReq[:13])
 	copy(mapResp[16:18], mapReq[16:18])
-	// assign external port
-	binary.BigEndian.PutUint16(mapResp[18:20], 4242)
-	assignedIP := netaddr.IPv4(127, 0, 0, 1)
-	assignedIP16 := assignedIP.As16()
-	copy(mapResp[20:36], assignedIP16[:])
+	binary.BigEndian.PutUint16(mapResp[18:20], extPort)
+	extIP16 := extIP.As16()
+	copy(mapResp[20:36], extIP16[:])
 	return out
 }
+
+func buildPCPPeerResponse(req []byte, extIP netip.Addr, extPort uint16, lifetimeSec uint32) []byte {
+	out := make([]byte, 24+56)
+	out[0] = pcpVersion
+	out[1] = req[1] | serverResponseBit
+	out[3] = 0
+	binary.BigEndian.PutUint32(out[4:8], lifetimeSec)
+	peerResp := out[24:]
+	peerReq := req[24:]
+	// copy nonce, protocol and internal port
+	copy(peerResp[:13], peerReq[:13])
+	copy(peerResp[16:18], peerReq[16:18])
+	binary.BigEndian.PutUint16(peerResp[18:20], extPort)
+	extIP16 := extIP.As16()
+	copy(peerResp[20:36], extIP16[:])
+	// copy remote peer port and address
+	copy(peerResp[36:56], peerReq[36:56])
+	return out
+}
+
+func TestParsePCPPeerResponse(t *testing.T) {
+	myIP := netip.MustParseAddr("192.168.1.2")
+	remote := netip.MustParseAddrPort("203.0.113.7:41641")
+	req, nonce := buildPCPRequestPeerPacket(UDP, myIP, 1234, netip.AddrPort{}, remote, 7200)
+	resp := buildPCPPeerResponse(req, netip.MustParseAddr("198.51.100.1"), 4321, 7200)
+
+	m, err := parsePCPPeerResponse(resp, nonce)
+	if err != nil {
+		t.Fatalf("failed to parse PCP PEER response: %v", err)
+	}
+	if want := netip.MustParseAddrPort("198.51.100.1:4321"); m.external != want {
+		t.Errorf("external = %v; want %v", m.external, want)
+	}
+	if m.remote != remote {
+		t.Errorf("remote = %v; want %v", m.remote, remote)
+	}
+
+	var otherNonce [12]byte
+	if _, err := parsePCPPeerResponse(resp, otherNonce); err == nil {
+		t.Errorf("parsed response with mismatched nonce")
+	}
+	if _, err := parsePCPPeerResponse(examplePCPMapResponse, nonce); err == nil {
+		t.Errorf("parsed MAP response as PEER response")
+	}
+}
+
+func TestPCPPeer(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.SetLocalPort(1234)
+	ctx := context.Background()
+	remote := netip.MustParseAddrPort("203.0.113.7:41641")
+
+	// waitMappings waits for the IGD to have want mappings, as releases
+	// aren't acknowledged, and returns them.
+	waitMappings := func(want int) []igdMapping {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			ms := igd.mappingsForTest()
+			if len(ms) == want || time.Now().After(deadline) {
+				return ms
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Without a MAP mapping, PEER creates one of its own.
+	ext, err := c.PCPPeer(ctx, remote)
+	if err != nil {
+		t.Fatalf("PCPPeer: %v", err)
+	}
+	if ms := igd.mappingsForTest(); len(ms) != 1 || ms[0].via != "pcp-peer" || ms[0].external != ext.Port() {
+		t.Fatalf("IGD mappings = %+v; want one PEER mapping of port %d", ms, ext.Port())
+	}
+	c.ReleasePCPPeer(ctx, remote)
+	if ms := waitMappings(0); len(ms) != 0 {
+		t.Fatalf("IGD mappings after ReleasePCPPeer = %+v; want none", ms)
+	}
+
+	// With one, PEER uses the same external address.
+	if _, err := c.createOrGetMapping(ctx); err != nil {
+		t.Fatalf("createOrGetMapping: %v", err)
+	}
+	c.mu.Lock()
+	mapped := c.mapping.External()
+	c.mu.Unlock()
+	if ext, err = c.PCPPeer(ctx, remote); err != nil {
+		t.Fatalf("PCPPeer: %v", err)
+	}
+	if ext != mapped {
+		t.Errorf("PEER external = %v; want MAP external %v", ext, mapped)
+	}
+
+	// Until it's due for renewal, it's not refreshed.
+	before := igd.stats().numPCPPeerRecv
+	if _, err := c.PCPPeer(ctx, remote); err != nil {
+		t.Fatalf("PCPPeer: %v", err)
+	}
+	if got := igd.stats().numPCPPeerRecv - before; got != 0 {
+		t.Errorf("sent %d PEER requests before renewal was due; want 0", got)
+	}
+	c.mu.Lock()
+	c.pcpPeers[remote].renewAfter = time.Now()
+	c.mu.Unlock()
+	if got, err := c.PCPPeer(ctx, remote); err != nil || got != ext {
+		t.Fatalf("PCPPeer renewal = %v, %v; want %v", got, err, ext)
+	}
+	if got := igd.stats().numPCPPeerRecv - before; got != 1 {
+		t.Errorf("sent %d PEER requests when renewal was due; want 1", got)
+	}
+
+	// Closing the client releases it, along with the MAP mapping.
+	c.Close()
+	if ms := waitMappings(0); len(ms) != 0 {
+		t.Errorf("IGD mappings after Close = %+v; want none", ms)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for igd.stats().numPCPPeerDeleteRecv < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := igd.stats().numPCPPeerDeleteRecv; got != 2 {
+		t.Errorf("got %d PEER deletions; want 2", got)
+	}
+}
+
+func TestPCPPeerNoPCP(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PMP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.SetLocalPort(1234)
+	if _, err := c.PCPPeer(context.Background(), netip.MustParseAddrPort("203.0.113.7:41641")); !IsNoMappingError(err) {
+		t.Errorf("PCPPeer = %v; want NoMappingError", err)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"tailscale.com/net/netaddr"
+	"tailscale.com/util/clientmetric"
+)
+
+// maxPCPPeers is the most PEER mappings that a Client keeps. When it would
+// have more, the one that expires first is released.
+const maxPCPPeers = 64
+
+// PCPPeer creates, or refreshes if it's due, a PCP PEER mapping for the
+// Client's local port toward remote, and returns the external address that
+// the gateway uses for traffic to remote. It asks for the external address
+// of the Client's own mapping, if it has one, so that every peer sees the
+// same endpoint. The mapping is kept until
+// ReleasePCPPeer is called, the gateway changes, or the Client is closed;
+// to keep it, call PCPPeer again before it expires, such as whenever
+// sending to remote.
+//
+// If the gateway doesn't speak PCP, the error will be of type
+// NoMappingError; see IsNoMappingError.
+func (c *Client) PCPPeer(ctx context.Context, remote netip.AddrPort) (external netip.AddrPort, err error) {
+	remote = netaddr.Unmap(remote)
+	if !remote.Addr().Is4() || remote.Port() == 0 {
+		return netip.AddrPort{}, fmt.Errorf("portmapper: invalid PCP peer %v", remote)
+	}
+	if c.debug.disableAll() {
+		return netip.AddrPort{}, NoMappingError{ErrPortMappingDisabled}
+	}
+	if c.disabledServices().PCP {
+		return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
+	}
+	gw, myIP, ok := c.gatewayAndSelfIP()
+	if !ok {
+		return netip.AddrPort{}, NoMappingError{ErrGatewayRange}
+	}
+	if gw.Is6() {
+		return netip.AddrPort{}, NoMappingError{ErrGatewayIPv6}
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return netip.AddrPort{}, errors.New("portmapper: client closed")
+	}
+	localPort := c.localPort
+	old := c.pcpPeers[remote]
+	if old != nil && old.gw.Addr() == gw && old.internal.Port() == localPort && time.Now().Before(old.renewAfter) {
+		c.mu.Unlock()
+		return old.external, nil
+	}
+	var suggested netip.AddrPort
+	if old != nil && old.internal.Port() == localPort {
+		suggested = old.external
+	} else if m, ok := c.mapping.(*pcpMapping); ok {
+		suggested = m.external
+	}
+	havePCP := c.sawPCPRecentlyLocked()
+	c.mu.Unlock()
+
+	if !havePCP {
+		res, err := c.Probe(ctx)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		if !res.PCP {
+			return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
+		}
+	}
+
+	m, err := c.requestPCPPeer(ctx, netip.AddrPortFrom(gw, c.pxpPort()), netip.AddrPortFrom(myIP, localPort), suggested, remote)
+	if err != nil {
+		metricPCPPeerFailed.Add(1)
+		c.mu.Lock()
+		c.noteEventLocked(Event{What: "peer-failed", Type: "pcp", Gateway: gw, Detail: fmt.Sprintf("remote=%v: %v", remote, err)})
+		c.mu.Unlock()
+		return netip.AddrPort{}, err
+	}
+	metricPCPPeerOK.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+		m.Release(ctx)
+		return netip.AddrPort{}, errors.New("portmapper: client closed")
+	}
+	if old == nil && len(c.pcpPeers) >= maxPCPPeers {
+		c.releaseSoonestPCPPeerLocked()
+	}
+	if c.pcpPeers == nil {
+		c.pcpPeers = make(map[netip.AddrPort]*pcpPeerMapping)
+	}
+	c.pcpPeers[remote] = m
+	if old == nil || old.external != m.external {
+		c.noteEventLocked(Event{What: "peer", Type: "pcp", Gateway: gw, External: m.external, Detail: fmt.Sprintf("remote=%v", remote)})
+	}
+	return m.external, nil
+}
+
+// ReleasePCPPeer deletes the PCP PEER mapping toward remote created by
+// PCPPeer, if any. It waits for the deletion request to be sent.
+func (c *Client) ReleasePCPPeer(ctx context.Context, remote netip.AddrPort) {
+	remote = netaddr.Unmap(remote)
+	c.mu.Lock()
+	m := c.pcpPeers[remote]
+	delete(c.pcpPeers, remote)
+	c.mu.Unlock()
+	if m != nil {
+		m.Release(ctx)
+	}
+}
+
+// requestPCPPeer sends a PEER request to gw for the mapping of internal
+// toward remote, asking for the external address suggested, if valid, and
+// waits for the answer.
+func (c *Client) requestPCPPeer(ctx context.Context, gw, internal, suggested, remote netip.AddrPort) (*pcpPeerMapping, error) {
+	uc, err := c.listenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+	uc.SetReadDeadline(time.Now().Add(portMapServiceTimeout))
+	defer closeCloserOnContextDone(ctx, uc)()
+
+	pkt, nonce := buildPCPRequestPeerPacket(c.protocol, internal.Addr(), internal.Port(), suggested, remote, c.leaseSec())
+	metricPCPSent.Add(1)
+	if _, err := uc.WriteToUDPAddrPort(pkt, gw); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := uc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, NoMappingError{ErrNoPortMappingServices}
+		}
+		if netaddr.Unmap(src) != gw {
+			continue
+		}
+		if pres, ok := parsePCPResponse(buf[:n]); !ok || pres.OpCode != pcpOpReply|pcpOpPeer {
+			continue // such as a late reply to an ANNOUNCE
+		}
+		m, err := parsePCPPeerResponse(buf[:n], nonce)
+		if err != nil {
+			return nil, NoMappingError{err}
+		}
+		if m.remote != remote {
+			return nil, NoMappingError{fmt.Errorf("PCP PEER response for remote %v, not %v", m.remote, remote)}
+		}
+		m.c = c
+		m.gw = gw
+		m.internal = internal
+		m.proto = c.protocol
+		return m, nil
+	}
+}
+
+// releaseSoonestPCPPeerLocked releases, in the background, the PEER
+// mapping that expires first, to make room for another.
+//
+// c.mu must be held.
+func (c *Client) releaseSoonestPCPPeerLocked() {
+	var soonest *pcpPeerMapping
+	for _, m := range c.pcpPeers {
+		if soonest == nil || m.goodUntil.Before(soonest.goodUntil) {
+			soonest = m
+		}
+	}
+	if soonest == nil {
+		return
+	}
+	delete(c.pcpPeers, soonest.remote)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+		soonest.Release(ctx)
+	}()
+}
+
+// invalidatePCPPeersLocked forgets c's PEER mappings, first releasing
+// them if releaseOld.
+//
+// c.mu must be held.
+func (c *Client) invalidatePCPPeersLocked(releaseOld bool) {
+	if releaseOld {
+		for _, m := range c.pcpPeers {
+			m.Release(context.Background())
+		}
+	}
+	c.pcpPeers = nil
+}
+
+var (
+	// metricPCPPeerOK counts the number of PCP PEER mappings created or
+	// refreshed.
+	metricPCPPeerOK = clientmetric.NewCounter("portmap_pcp_peer_ok")
+
+	// metricPCPPeerFailed counts the number of PCP PEER requests that
+	// failed.
+	metricPCPPeerFailed = clientmetric.NewCounter("portmap_pcp_peer_failed")
+)
@@ -0,0 +1,360 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+package portmapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/tailscale/goupnp"
+	"github.com/tailscale/goupnp/soap"
+	"tailscale.com/net/tsaddr"
+)
+
+// References:
+//
+// WANIPv6FirewallControl v1: http://upnp.org/specs/gw/UPnP-gw-WANIPv6FirewallControl-v1-Service.pdf
+
+// urnWANIPv6FirewallControl1 is the IGDv2 service that lets us open a
+// "pinhole" for our IPv6 UDP port in the router's stateful firewall, which
+// otherwise drops unsolicited inbound IPv6 traffic even though there's no
+// NAT to map through.
+const urnWANIPv6FirewallControl1 = "urn:schemas-upnp-org:service:WANIPv6FirewallControl:1"
+
+const (
+	// pinholeRetryInterval is how long we wait after failing to open a
+	// pinhole before trying again.
+	pinholeRetryInterval = 5 * time.Minute
+)
+
+// upnpFirewallClient is the subset of the WANIPv6FirewallControl service
+// that we use.
+type upnpFirewallClient interface {
+	GetFirewallStatus(ctx context.Context) (firewallEnabled, inboundPinholeAllowed bool, err error)
+
+	AddPinhole(
+		ctx context.Context,
+
+		// remoteHost and remotePort restrict the pinhole to traffic
+		// from the given remote address. The empty string and 0 mean
+		// any host and any port.
+		remoteHost string,
+		remotePort uint16,
+
+		// internalClient is the IPv6 address that traffic is let
+		// through to, and internalPort the port on it.
+		internalClient string,
+		internalPort uint16,
+
+		// protocol is the IANA protocol number, like 17 for UDP.
+		protocol uint16,
+
+		// leaseTime is the lifetime of the pinhole, in seconds.
+		leaseTime uint32,
+	) (uniqueID uint16, err error)
+
+	UpdatePinhole(ctx context.Context, uniqueID uint16, leaseTime uint32) error
+	DeletePinhole(ctx context.Context, uniqueID uint16) error
+}
+
+// wanIPv6FirewallControl1 is a client for the WANIPv6FirewallControl:1
+// service. goupnp doesn't generate one for it.
+type wanIPv6FirewallControl1 struct {
+	goupnp.ServiceClient
+}
+
+func (c *wanIPv6FirewallControl1) GetFirewallStatus(ctx context.Context) (firewallEnabled, inboundPinholeAllowed bool, err error) {
+	response := &struct {
+		FirewallEnabled       string
+		InboundPinholeAllowed string
+	}{}
+	if err := c.SOAPClient.PerformAction(ctx, urnWANIPv6FirewallControl1, "GetFirewallStatus", nil, response); err != nil {
+		return false, false, err
+	}
+	if firewallEnabled, err = soap.UnmarshalBoolean(response.FirewallEnabled); err != nil {
+		return false, false, err
+	}
+	if inboundPinholeAllowed, err = soap.UnmarshalBoolean(response.InboundPinholeAllowed); err != nil {
+		return false, false, err
+	}
+	return firewallEnabled, inboundPinholeAllowed, nil
+}
+
+func (c *wanIPv6FirewallControl1) AddPinhole(ctx context.Context, remoteHost string, remotePort uint16, internalClient string, internalPort uint16, protocol uint16, leaseTime uint32) (uniqueID uint16, err error) {
+	request := &struct {
+		RemoteHost     string
+		RemotePort     string
+		InternalClient string
+		InternalPort   string
+		Protocol       string
+		LeaseTime      string
+	}{
+		RemoteHost:     remoteHost,
+		InternalClient: internalClient,
+	}
+	request.RemotePort, _ = soap.MarshalUi2(remotePort)
+	request.InternalPort, _ = soap.MarshalUi2(internalPort)
+	request.Protocol, _ = soap.MarshalUi2(protocol)
+	request.LeaseTime, _ = soap.MarshalUi4(leaseTime)
+	response := &struct {
+		UniqueID string
+	}{}
+	if err := c.SOAPClient.PerformAction(ctx, urnWANIPv6FirewallControl1, "AddPinhole", request, response); err != nil {
+		return 0, err
+	}
+	return soap.UnmarshalUi2(response.UniqueID)
+}
+
+func (c *wanIPv6FirewallControl1) UpdatePinhole(ctx context.Context, uniqueID uint16, leaseTime uint32) error {
+	request := &struct {
+		UniqueID     string
+		NewLeaseTime string
+	}{}
+	request.UniqueID, _ = soap.MarshalUi2(uniqueID)
+	request.NewLeaseTime, _ = soap.MarshalUi4(leaseTime)
+	return c.SOAPClient.PerformAction(ctx, urnWANIPv6FirewallControl1, "UpdatePinhole", request, nil)
+}
+
+func (c *wanIPv6FirewallControl1) DeletePinhole(ctx context.Context, uniqueID uint16) error {
+	request := &struct {
+		UniqueID string
+	}{}
+	request.UniqueID, _ = soap.MarshalUi2(uniqueID)
+	return c.SOAPClient.PerformAction(ctx, urnWANIPv6FirewallControl1, "DeletePinhole", request, nil)
+}
+
+// upnpPinhole is an IPv6 firewall pinhole opened over UPnP.
+//
+// All fields are immutable once created.
+type upnpPinhole struct {
+	client     upnpFirewallClient
+	id         uint16
+	internal   netip.AddrPort
+	renewAfter time.Time
+	goodUntil  time.Time
+}
+
+// Release does a best effort release of the pinhole.
+func (p *upnpPinhole) Release(ctx context.Context) {
+	p.client.DeletePinhole(ctx, p.id)
+}
+
+// SetLocalPort6 updates the local IPv6 port number for which we want to
+// open a firewall pinhole. Zero means not to open one.
+//
+// Pinholes are opened and renewed in the background, as a side effect of
+// GetCachedMappingOrStartCreatingOne, on routers that offer the UPnP
+// WANIPv6FirewallControl service.
+func (c *Client) SetLocalPort6(localPort uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.localPort6 == localPort {
+		return
+	}
+	c.localPort6 = localPort
+	c.invalidatePinholeLocked(true)
+}
+
+// HavePinhole reports whether we have a current IPv6 firewall pinhole.
+func (c *Client) HavePinhole() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pinhole != nil && c.pinhole.goodUntil.After(time.Now())
+}
+
+// invalidatePinholeLocked forgets the current pinhole, deleting it from
+// the router first if releaseOld is true.
+//
+// c.mu must be held.
+func (c *Client) invalidatePinholeLocked(releaseOld bool) {
+	if c.pinhole == nil {
+		return
+	}
+	if releaseOld {
+		p := c.pinhole
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			p.Release(ctx)
+		}()
+	}
+	c.pinhole = nil
+}
+
+// maybeStartPinholeLocked starts a createPinhole goroutine if we want a
+// pinhole and don't have a fresh one.
+//
+// c.mu must be held.
+func (c *Client) maybeStartPinholeLocked(now time.Time) {
+	if c.localPort6 == 0 || c.runningPinhole || c.closed || len(c.uPnPMetas) == 0 {
+		return
+	}
+	if c.disabledServices().UPnP {
+		return
+	}
+	if p := c.pinhole; p != nil && now.Before(p.renewAfter) {
+		return
+	}
+	if now.Sub(c.lastPinholeFail) < pinholeRetryInterval {
+		return
+	}
+	c.runningPinhole = true
+	go c.createPinhole()
+}
+
+var errNoPinholeService = errors.New("no UPnP IPv6 firewall service allowing pinholes")
+
+func (c *Client) createPinhole() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p, err := c.createOrRenewPinhole(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runningPinhole = false
+	if err != nil {
+		c.lastPinholeFail = time.Now()
+		if !errors.Is(err, errNoPinholeService) {
+			metricUPnPPinholeFailed.Add(1)
+			c.logf("UPnP pinhole: %v", err)
+		}
+		return
+	}
+	if c.closed || p.internal.Port() != c.localPort6 {
+		// Closed or port changed while we were working; don't keep it.
+		go p.Release(context.Background())
+		return
+	}
+	if old := c.pinhole; old != nil && (old.client != p.client || old.id != p.id) {
+		go old.Release(context.Background())
+	}
+	c.pinhole = p
+}
+
+// createOrRenewPinhole renews the current pinhole, or failing that, opens
+// a new one on the first router that allows it.
+func (c *Client) createOrRenewPinhole(ctx context.Context) (*upnpPinhole, error) {
+	self, ok := c.pinholeAddr()
+	if !ok {
+		return nil, errNoPinholeService
+	}
+	gw, _, ok := c.gatewayAndSelfIP()
+	if !ok {
+		return nil, errNoPinholeService
+	}
+
+	c.mu.Lock()
+	internal := netip.AddrPortFrom(self, c.localPort6)
+	old := c.pinhole
+	metas := c.uPnPMetas
+	ctx = goupnp.WithHTTPClient(ctx, c.upnpHTTPClientLocked())
+	c.mu.Unlock()
+
+	now := time.Now()
+	// Pinholes use the same lease duration as mappings, which is no more
+	// than the 86400 seconds that the spec allows.
+	d := c.leaseDuration()
+	leaseSec := c.leaseSec()
+	if old != nil && old.internal == internal {
+		err := old.client.UpdatePinhole(ctx, old.id, leaseSec)
+		if err == nil {
+			c.vlogf("UPnP pinhole %d for %v renewed", old.id, internal)
+			return &upnpPinhole{
+				client:     old.client,
+				id:         old.id,
+				internal:   internal,
+				renewAfter: now.Add(d / 2),
+				goodUntil:  now.Add(d),
+			}, nil
+		}
+		c.logf("UPnP pinhole %d for %v: renewing: %v", old.id, internal, err)
+	}
+
+	var errs []error
+	for _, meta := range metas {
+		rootDev, loc, err := c.getUPnPRootDeviceCached(ctx, gw, meta)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if rootDev == nil {
+			continue
+		}
+		clients, err := goupnp.NewServiceClientsFromRootDevice(ctx, rootDev, loc, urnWANIPv6FirewallControl1)
+		if err != nil {
+			// Not an IGDv2 device, or one without IPv6 support.
+			continue
+		}
+		for _, sc := range clients {
+			fc := &wanIPv6FirewallControl1{sc}
+			enabled, allowed, err := fc.GetFirewallStatus(ctx)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !enabled {
+				c.vlogf("UPnP IPv6 firewall at %v is disabled; no pinhole needed", loc)
+				continue
+			}
+			if !allowed {
+				c.vlogf("UPnP IPv6 firewall at %v does not allow inbound pinholes", loc)
+				continue
+			}
+			id, err := fc.AddPinhole(ctx, "", 0, self.String(), internal.Port(), uint16(c.protocol.pcpProto()), leaseSec)
+			if err != nil {
+				if code, ok := getUPnPErrorCode(err); ok {
+					getUPnPErrorsMetric(code).Add(1)
+				}
+				errs = append(errs, err)
+				continue
+			}
+			metricUPnPPinholeOK.Add(1)
+			c.logf("UPnP pinhole %d opened for %v", id, internal)
+			return &upnpPinhole{
+				client:     fc,
+				id:         id,
+				internal:   internal,
+				renewAfter: now.Add(d / 2),
+				goodUntil:  now.Add(d),
+			}, nil
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("opening pinhole for %v: %w", internal, errors.Join(errs...))
+	}
+	return nil, errNoPinholeService
+}
+
+// pinholeAddr returns the IPv6 address to open a pinhole for: the lowest
+// global unicast address on the default route interface. Peers only need
+// one of our IPv6 endpoints to work, and picking the same one each time
+// lets us renew the pinhole rather than open a new one.
+func (c *Client) pinholeAddr() (netip.Addr, bool) {
+	if c.testSelf6.IsValid() {
+		return c.testSelf6, true
+	}
+	st := c.netMon.InterfaceState()
+	if st == nil || st.DefaultRouteInterface == "" {
+		return netip.Addr{}, false
+	}
+	var addrs []netip.Addr
+	for _, pfx := range st.InterfaceIPs[st.DefaultRouteInterface] {
+		ip := pfx.Addr()
+		if ip.Is6() && ip.IsGlobalUnicast() && !ip.IsPrivate() && !tsaddr.IsTailscaleIP(ip) {
+			addrs = append(addrs, ip)
+		}
+	}
+	if len(addrs) == 0 {
+		return netip.Addr{}, false
+	}
+	slices.SortFunc(addrs, netip.Addr.Compare)
+	return addrs[0], true
+}
@@ -0,0 +1,190 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUPnPPinhole(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	var (
+		added   atomic.Int32
+		updated atomic.Int32
+		deleted = make(chan string, 1)
+	)
+	igd.SetUPnPHandler(&upnpServer{
+		t:    t,
+		Desc: testPinholeRootDesc,
+		Control: map[string]map[string]any{
+			"/ctl/IP6FCtl": {
+				"GetFirewallStatus": testGetFirewallStatusResponse,
+				"AddPinhole": func(body []byte) (int, string) {
+					var req struct {
+						RemoteHost     string
+						RemotePort     string
+						InternalClient string
+						InternalPort   string
+						Protocol       string
+						LeaseTime      string
+					}
+					if err := xml.Unmarshal(body, &req); err != nil {
+						t.Errorf("bad request: %v", err)
+						return http.StatusBadRequest, "bad request"
+					}
+					if req.RemoteHost != "" || req.RemotePort != "0" || req.InternalClient != "2001:db8::1" ||
+						req.InternalPort != "41641" || req.Protocol != "17" || req.LeaseTime != "7200" {
+						t.Errorf("unexpected AddPinhole request %+v", req)
+					}
+					added.Add(1)
+					return http.StatusOK, testAddPinholeResponse
+				},
+				"UpdatePinhole": func(body []byte) (int, string) {
+					updated.Add(1)
+					return http.StatusOK, testUpdatePinholeResponse
+				},
+				"DeletePinhole": func(body string) string {
+					deleted <- body
+					return testDeletePinholeResponse
+				},
+			},
+		},
+	})
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.testSelf6 = netip.MustParseAddr("2001:db8::1")
+
+	ctx := context.Background()
+	res, err := c.Probe(ctx)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if !res.UPnP {
+		t.Fatal("didn't detect UPnP")
+	}
+	c.SetLocalPort6(41641)
+
+	p, err := c.createOrRenewPinhole(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.id != 42 || p.internal != netip.MustParseAddrPort("[2001:db8::1]:41641") {
+		t.Errorf("got pinhole %d for %v; want 42 for [2001:db8::1]:41641", p.id, p.internal)
+	}
+	c.mu.Lock()
+	c.pinhole = p
+	c.mu.Unlock()
+	if !c.HavePinhole() {
+		t.Error("HavePinhole = false")
+	}
+
+	// A second call renews the pinhole rather than opening another.
+	if _, err := c.createOrRenewPinhole(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := added.Load(), int32(1); got != want {
+		t.Errorf("AddPinhole calls = %d; want %d", got, want)
+	}
+	if got, want := updated.Load(), int32(1); got != want {
+		t.Errorf("UpdatePinhole calls = %d; want %d", got, want)
+	}
+
+	// Changing the port deletes the pinhole.
+	c.SetLocalPort6(0)
+	if c.HavePinhole() {
+		t.Error("HavePinhole = true after SetLocalPort6(0)")
+	}
+	select {
+	case body := <-deleted:
+		t.Logf("DeletePinhole: %s", body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for DeletePinhole")
+	}
+}
+
+const testPinholeRootDesc = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion>
+    <major>1</major>
+    <minor>1</minor>
+  </specVersion>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:2</deviceType>
+    <friendlyName>Tailscale Test Router</friendlyName>
+    <UDN>uuid:4b1e3c8e-79a4-4f8b-a37c-0c2b76a4c1c4</UDN>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:2</deviceType>
+        <friendlyName>WANDevice</friendlyName>
+        <UDN>uuid:4b1e3c8e-79a4-4f8b-a37c-0c2b76a4c1c5</UDN>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:2</deviceType>
+            <friendlyName>WANConnectionDevice</friendlyName>
+            <UDN>uuid:4b1e3c8e-79a4-4f8b-a37c-0c2b76a4c1c6</UDN>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPv6FirewallControl:1</serviceType>
+                <serviceId>urn:upnp-org:serviceId:WANIPv6Firewall1</serviceId>
+                <SCPDURL>/WANIP6FC.xml</SCPDURL>
+                <controlURL>/ctl/IP6FCtl</controlURL>
+                <eventSubURL>/evt/IP6FCtl</eventSubURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>
+`
+
+const testGetFirewallStatusResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:GetFirewallStatusResponse xmlns:u="urn:schemas-upnp-org:service:WANIPv6FirewallControl:1">
+      <FirewallEnabled>1</FirewallEnabled>
+      <InboundPinholeAllowed>1</InboundPinholeAllowed>
+    </u:GetFirewallStatusResponse>
+  </s:Body>
+</s:Envelope>
+`
+
+const testAddPinholeResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:AddPinholeResponse xmlns:u="urn:schemas-upnp-org:service:WANIPv6FirewallControl:1">
+      <UniqueID>42</UniqueID>
+    </u:AddPinholeResponse>
+  </s:Body>
+</s:Envelope>
+`
+
+const testUpdatePinholeResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:UpdatePinholeResponse xmlns:u="urn:schemas-upnp-org:service:WANIPv6FirewallControl:1"/>
+  </s:Body>
+</s:Envelope>
+`
+
+const testDeletePinholeResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:DeletePinholeResponse xmlns:u="urn:schemas-upnp-org:service:WANIPv6FirewallControl:1"/>
+  </s:Body>
+</s:Envelope>
+`
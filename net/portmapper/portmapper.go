@@ -27,10 +27,15 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/sockstats"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/syncs"
+	"tailscale.com/tstime/rate"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/nettype"
+	"tailscale.com/types/opt"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/ringbuffer"
+	"tailscale.com/util/set"
 )
 
 var disablePortMapperEnv = envknob.RegisterBool("TS_DISABLE_PORTMAPPER")
@@ -55,6 +60,11 @@ type DebugKnobs struct {
 	// DisableAll, if non-nil, is a func that reports whether all port
 	// mapping attempts should be disabled.
 	DisableAll func() bool
+
+	// UPnPCacheTTL, if non-zero, is how long UPnP root device
+	// descriptions and external IP addresses are cached. Negative
+	// disables caching.
+	UPnPCacheTTL time.Duration
 }
 
 func (k *DebugKnobs) disableAll() bool {
@@ -82,6 +92,40 @@ func (k *DebugKnobs) disableAll() bool {
 // mapping service is available.
 const trustServiceStillAvailableDuration = 10 * time.Minute
 
+// Protocol is the transport protocol of a port mapping.
+type Protocol uint8
+
+const (
+	UDP Protocol = iota // the default
+	TCP
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case UDP:
+		return "udp"
+	case TCP:
+		return "tcp"
+	}
+	return fmt.Sprintf("Protocol(%d)", uint8(p))
+}
+
+// pmpOp returns the NAT-PMP opcode for mapping p.
+func (p Protocol) pmpOp() uint8 {
+	if p == TCP {
+		return pmpOpMapTCP
+	}
+	return pmpOpMapUDP
+}
+
+// pcpProto returns the IANA protocol number for p, for PCP MAP requests.
+func (p Protocol) pcpProto() uint8 {
+	if p == TCP {
+		return pcpTCPMapping
+	}
+	return pcpUDPMapping
+}
+
 // Client is a port mapping client.
 type Client struct {
 	logf         logger.Logf
@@ -89,9 +133,31 @@ type Client struct {
 	controlKnobs *controlknobs.Knobs
 	ipAndGateway func() (gw, ip netip.Addr, ok bool)
 	onChange     func() // or nil
+
+	gatewayCandidates func() []netmon.HomeRouter // or nil; see SetGatewayCandidatesFunc
+
+	protocol     Protocol
 	debug        DebugKnobs
-	testPxPPort  uint16 // if non-zero, pxpPort to use for tests
-	testUPnPPort uint16 // if non-zero, uPnPPort to use for tests
+	testPxPPort  uint16     // if non-zero, pxpPort to use for tests
+	testUPnPPort uint16     // if non-zero, uPnPPort to use for tests
+	testSelf6    netip.Addr // if valid, IPv6 address to open pinholes for in tests
+	parent       *Client    // if non-nil, the Client whose NewPortMapping created this one
+
+	// disabled are the services disabled by SetDisabledServices.
+	// It's unused by NewPortMapping children, which use their parent's.
+	disabled syncs.AtomicValue[Services]
+
+	// lease is the lease duration set by SetLeaseDuration, or 0 for the
+	// default. Like disabled, children use their parent's.
+	lease syncs.AtomicValue[time.Duration]
+
+	// events are the most recent events; see DebugEvents. Children
+	// share their parent's.
+	events *ringbuffer.RingBuffer[Event]
+
+	// ssdpLimiter limits how often Probe sends multicast SSDP queries;
+	// see ssdplimit.go. It's nil for Clients that aren't limited.
+	ssdpLimiter *rate.Limiter
 
 	mu sync.Mutex // guards following, and all fields thereof
 
@@ -104,6 +170,10 @@ type Client struct {
 	lastGW   netip.Addr
 	closed   bool
 
+	// gateways is the state of each candidate gateway, including
+	// lastGW, as of the last gateway lookup.
+	gateways map[netip.Addr]*gatewayState
+
 	lastProbe time.Time
 
 	// The following PMP fields are populated during Probe
@@ -111,17 +181,77 @@ type Client struct {
 	pmpPubIPTime time.Time  // time pmpPubIP last verified
 	pmpLastEpoch uint32
 
+	// lastDoubleNAT is the private external address that the gateway
+	// last reported, if any, so that we only log it once. See
+	// doublenat.go.
+	lastDoubleNAT netip.Addr
+
 	// The following PCP fields are populated during Probe
 	pcpSawTime   time.Time // time we last saw PCP was available
 	pcpLastEpoch uint32
+	pcpPeers     map[netip.AddrPort]*pcpPeerMapping // PEER mappings by remote; see pcppeer.go
 
 	uPnPSawTime    time.Time           // time we last saw UPnP was available
 	uPnPMetas      []uPnPDiscoResponse // UPnP UDP discovery responses
 	uPnPHTTPClient *http.Client        // netns-configured HTTP client for UPnP; nil until needed
+	uPnPCache      *upnpCache          // cached UPnP lookups for lastGW; see upnpcache.go
+	upnpBackoff    upnpBackoff         // UPnP devices that keep failing; see upnpbackoff.go
 
 	localPort uint16
 
 	mapping mapping // non-nil if we have a mapping
+
+	// saved is the mapping obtained most recently, possibly by an
+	// earlier run, and saveMapping is called when it changes. See
+	// SetSavedMapping and SetSaveMappingFunc.
+	saved       SavedMapping
+	saveMapping func(SavedMapping)
+
+	// verify, if non-nil, checks that a newly created mapping passes
+	// traffic. See SetMappingVerifier.
+	verify func(ctx context.Context, external netip.AddrPort) error
+
+	// verifiedExternal and failedExternal are the external addresses of
+	// the most recent mappings that passed and failed verify. A new
+	// mapping is handed out while it's verified, and withdrawn if it
+	// fails, until it passes a later verification. verifying is the
+	// external address being verified, if any.
+	verifiedExternal netip.AddrPort
+	failedExternal   netip.AddrPort
+	verifying        netip.AddrPort
+
+	// The following fields are for renewing the mapping in the
+	// background; see renew.go.
+	renewTimer    *time.Timer    // non-nil if a renewal is scheduled
+	renewFailures int            // consecutive failed renewals
+	renewNow      bool           // renew the mapping even if it's not yet RenewAfter
+	lastExternal  netip.AddrPort // usable external address last reported to onChange
+
+	// firstExternalPort is the external port of the first usable mapping
+	// since the mappings were last invalidated, and externalPortStable
+	// is whether every usable mapping since has kept it. See
+	// ProbeResult.ExternalPortStable.
+	firstExternalPort  uint16
+	externalPortStable opt.Bool
+
+	// The following fields are for listening for NAT-PMP and PCP
+	// announcements from the gateway; see announce.go.
+	announceConn         *net.UDPConn // non-nil if listening
+	announceListenFailed bool         // whether listening failed for lastGW
+
+	changeCallbacks set.HandleSet[func(MappingChange)] // see RegisterChangeCallback
+
+	// The following fields are for the IPv6 firewall pinhole; see
+	// SetLocalPort6.
+	localPort6      uint16
+	pinhole         *upnpPinhole // non-nil if we have a pinhole
+	runningPinhole  bool         // whether a createPinhole goroutine is running
+	lastPinholeFail time.Time
+
+	portMappings []*PortMapping                // additional mappings; see NewPortMapping
+	forwards     map[forwardKey]*sharedForward // see ForwardPort
+
+	lastEvent Event // last event recorded, without its time; see noteEventLocked
 }
 
 func (c *Client) vlogf(format string, args ...any) {
@@ -157,7 +287,15 @@ type mapping interface {
 func (c *Client) HaveMapping() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.mapping != nil && c.mapping.GoodUntil().After(time.Now())
+	return c.mapping != nil && c.mapping.GoodUntil().After(time.Now()) && c.usableLocked(c.mapping)
+}
+
+// usableLocked reports whether m may be handed out to callers: either no
+// verifier is set, or m hasn't failed verification.
+//
+// c.mu must be held.
+func (c *Client) usableLocked(m mapping) bool {
+	return c.verify == nil || m.External() != c.failedExternal
 }
 
 // pmpMapping is an already-created PMP mapping.
@@ -171,6 +309,7 @@ type pmpMapping struct {
 	renewAfter time.Time // the time at which we want to renew the mapping
 	goodUntil  time.Time // the mapping's total lifetime
 	epoch      uint32
+	proto      Protocol
 }
 
 // externalValid reports whether m.external is valid, with both its IP and Port populated.
@@ -197,7 +336,7 @@ func (m *pmpMapping) Release(ctx context.Context) {
 		return
 	}
 	defer uc.Close()
-	pkt := buildPMPRequestMappingPacket(m.internal.Port(), m.external.Port(), pmpMapLifetimeDelete)
+	pkt := buildPMPRequestMappingPacket(m.proto, m.internal.Port(), m.external.Port(), pmpMapLifetimeDelete)
 	uc.WriteToUDPAddrPort(pkt, m.gw)
 }
 
@@ -212,8 +351,9 @@ func (m *pmpMapping) Release(ctx context.Context) {
 // plane that might disable portmapping.
 //
 // The optional onChange argument specifies a func to run in a new goroutine
-// whenever the port mapping status has changed. If nil, it doesn't make a
-// callback.
+// whenever the external address of the port mapping has changed. If nil, it
+// doesn't make a callback. Use RegisterChangeCallback to learn what the
+// change was, or to add more callbacks later.
 func NewClient(logf logger.Logf, netMon *netmon.Monitor, debug *DebugKnobs, controlKnobs *controlknobs.Knobs, onChange func()) *Client {
 	if netMon == nil {
 		panic("nil netMon")
@@ -224,6 +364,10 @@ func NewClient(logf logger.Logf, netMon *netmon.Monitor, debug *DebugKnobs, cont
 		ipAndGateway: netmon.LikelyHomeRouterIP, // TODO(bradfitz): move this to method on netMon
 		onChange:     onChange,
 		controlKnobs: controlKnobs,
+
+		gatewayCandidates: netmon.LikelyHomeRouterIPs,
+		events:            ringbuffer.New[Event](maxEvents),
+		ssdpLimiter:       newSSDPLimiter(),
 	}
 	if debug != nil {
 		ret.debug = *debug
@@ -234,8 +378,34 @@ func NewClient(logf logger.Logf, netMon *netmon.Monitor, debug *DebugKnobs, cont
 // SetGatewayLookupFunc set the func that returns the machine's default gateway IP, and
 // the primary IP address for that gateway. It must be called before the client is used.
 // If not called, interfaces.LikelyHomeRouterIP is used.
+//
+// It also clears any other candidate gateways; see SetGatewayCandidatesFunc.
 func (c *Client) SetGatewayLookupFunc(f func() (gw, myIP netip.Addr, ok bool)) {
 	c.ipAndGateway = f
+	c.gatewayCandidates = nil
+}
+
+// SetMappingVerifier sets a func that checks whether a newly created
+// mapping actually passes traffic from its external address to the local
+// port, for instance by sending a packet to external from the local port
+// and waiting for the router to hairpin it back. It must be called before
+// the client is used.
+//
+// If set, f is run in the background on each new mapping, which is
+// returned by GetCachedMappingOrStartCreatingOne meanwhile. If f returns an
+// error, the mapping is withdrawn, as if it had been lost, so that endpoints
+// that don't work aren't advertised to peers. It's re-checked when it's
+// renewed, and handed out again once f returns nil for it.
+func (c *Client) SetMappingVerifier(f func(ctx context.Context, external netip.AddrPort) error) {
+	c.verify = f
+}
+
+// SetProtocol sets the transport protocol of the mappings the client
+// creates. It must be called before the client is used. If not called,
+// the client maps UDP. A Client maps a single local port over a single
+// protocol; callers that need both UDP and TCP mappings use two Clients.
+func (c *Client) SetProtocol(p Protocol) {
+	c.protocol = p
 }
 
 // NoteNetworkDown should be called when the network has transitioned to a down state.
@@ -245,47 +415,122 @@ func (c *Client) SetGatewayLookupFunc(f func() (gw, myIP netip.Addr, ok bool)) {
 func (c *Client) NoteNetworkDown() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.noteInvalidatedLocked("network down")
 	c.invalidateMappingsLocked(false)
+	c.uPnPCache = nil
 }
 
+// Close deletes the client's mappings from the router, including those
+// created with NewPortMapping and any IPv6 firewall pinhole, so that they
+// don't linger pointing at a port that may be reused by something else. It
+// waits up to releaseTimeout for the deletion requests to be sent.
 func (c *Client) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	c.closeAndRelease(ctx, &wg)
+	wg.Wait()
+	return nil
+}
+
+// releaseTimeout is how long Close waits for the client's mappings to be
+// released, bounding how long it can delay a shutdown when the router
+// doesn't answer.
+const releaseTimeout = 2 * time.Second
+
+// closeAndRelease marks c and its NewPortMapping children closed and starts
+// releasing their mappings in goroutines tracked by wg. The releases happen
+// outside c.mu so that a slow router doesn't block other users of c.
+func (c *Client) closeAndRelease(ctx context.Context, wg *sync.WaitGroup) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.closed {
-		return nil
+		return
 	}
 	c.closed = true
-	c.invalidateMappingsLocked(true)
-	// TODO: close some future ever-listening UDP socket(s),
-	// waiting for multicast announcements from router.
-	return nil
+	release := func(m interface{ Release(context.Context) }) {
+		metricReleasedOnClose.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Release(ctx)
+		}()
+	}
+	if c.mapping != nil {
+		release(c.mapping)
+	}
+	if c.pinhole != nil {
+		release(c.pinhole)
+	}
+	for _, m := range c.pcpPeers {
+		release(m)
+	}
+	c.pcpPeers = nil
+	for _, pm := range c.portMappings {
+		pm.c.closeAndRelease(ctx, wg)
+	}
+	c.portMappings = nil
+	c.invalidateMappingsLocked(false)
+}
+
+// setMappingLocked makes m the client's current mapping. If the client was
+// closed while m was being created, m is released instead, as nothing else
+// would ever release it.
+//
+// c.mu must be held.
+func (c *Client) setMappingLocked(m mapping) {
+	if c.closed {
+		ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+		metricReleasedOnClose.Add(1)
+		m.Release(ctx)
+		return
+	}
+	c.mapping = m
+	c.noteExternalIPLocked(m.External().Addr())
+	c.maybeStartAnnounceListenerLocked()
 }
 
 // SetLocalPort updates the local port number to which we want to port
-// map UDP traffic.
+// map traffic of the client's protocol.
 func (c *Client) SetLocalPort(localPort uint16) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.localPort == localPort {
 		return
 	}
+	c.noteInvalidatedLocked(fmt.Sprintf("local port changed to %d", localPort))
 	c.localPort = localPort
 	c.invalidateMappingsLocked(true)
 }
 
 func (c *Client) gatewayAndSelfIP() (gw, myIP netip.Addr, ok bool) {
 	gw, myIP, ok = c.ipAndGateway()
+	var candidates []netmon.HomeRouter
 	if !ok {
 		gw = netip.Addr{}
 		myIP = netip.Addr{}
+	} else if c.gatewayCandidates != nil {
+		candidates = c.gatewayCandidates()
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if ok {
+		gw, myIP = c.selectGatewayLocked(gw, myIP, candidates)
+	} else {
+		c.gateways = nil
+	}
 	if gw != c.lastGW || myIP != c.lastMyIP || !ok {
+		if !ok {
+			c.noteInvalidatedLocked("no gateway")
+		} else {
+			c.noteInvalidatedLocked(fmt.Sprintf("gateway changed to %v (self %v)", gw, myIP))
+		}
 		c.lastMyIP = myIP
 		c.lastGW = gw
 		c.invalidateMappingsLocked(true)
+		c.uPnPCache = nil
 	}
 	return
 }
@@ -345,16 +590,29 @@ func (c *Client) invalidateMappingsLocked(releaseOld bool) {
 		}
 		c.mapping = nil
 	}
+	c.verifiedExternal = netip.AddrPort{}
+	c.failedExternal = netip.AddrPort{}
+	c.noteExternalLocked(netip.AddrPort{})
+	c.firstExternalPort = 0
+	c.externalPortStable = ""
+	c.scheduleRenewLocked(false)
+	c.renewNow = false
 
 	c.pmpPubIP = netip.Addr{}
 	c.pmpPubIPTime = time.Time{}
 	c.pmpLastEpoch = 0
+	c.lastDoubleNAT = netip.Addr{}
 
 	c.pcpSawTime = time.Time{}
 	c.pcpLastEpoch = 0
+	c.invalidatePCPPeersLocked(releaseOld)
 
 	c.uPnPSawTime = time.Time{}
 	c.uPnPMetas = nil
+
+	c.stopAnnounceListenerLocked()
+	c.invalidatePinholeLocked(releaseOld)
+	c.invalidatePortMappingsLocked(releaseOld)
 }
 
 func (c *Client) sawPMPRecently() bool {
@@ -426,19 +684,27 @@ func IsNoMappingError(err error) bool {
 
 // GetCachedMappingOrStartCreatingOne quickly returns with our current cached portmapping, if any.
 // If there's not one, it starts up a background goroutine to create one.
-// If the background goroutine ends up creating one, the onChange hook registered with the
-// NewClient constructor (if any) will fire.
+// Once created, the mapping is renewed in the background before it expires.
+// Whenever the usable external address changes, including when a mapping is
+// first created or expires without being renewed, the onChange hook
+// registered with the NewClient constructor (if any) will fire.
 func (c *Client) GetCachedMappingOrStartCreatingOne() (external netip.AddrPort, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	// Do we have an existing mapping that's valid?
 	now := time.Now()
+	c.maybeStartPinholeLocked(now)
 	if m := c.mapping; m != nil {
 		if now.Before(m.GoodUntil()) {
 			if now.After(m.RenewAfter()) {
 				c.maybeStartMappingLocked()
 			}
+			if !c.usableLocked(m) {
+				// Failed verification, and waiting to pass it
+				// when renewed.
+				return netip.AddrPort{}, false
+			}
 			return m.External(), true
 		}
 	}
@@ -467,10 +733,72 @@ func (c *Client) createMapping() {
 		c.runningCreate = false
 	}()
 
-	if _, err := c.createOrGetMapping(ctx); err == nil && c.onChange != nil {
-		go c.onChange()
-	} else if err != nil && !IsNoMappingError(err) {
-		c.logf("createOrGetMapping: %v", err)
+	if c.parent != nil {
+		c.inheritProbe()
+	}
+	external, err := c.createOrGetMapping(ctx)
+	if err != nil {
+		if !IsNoMappingError(err) {
+			c.logf("createOrGetMapping: %v", err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if m := c.mapping; m != nil {
+			c.noteEventLocked(Event{What: "renew-failed", Type: m.MappingType(), External: m.External(), Detail: err.Error()})
+		} else {
+			c.noteEventLocked(Event{What: "map-failed", Detail: err.Error()})
+		}
+		c.scheduleRenewLocked(true)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scheduleRenewLocked(false)
+	if c.verify != nil && external != c.verifiedExternal && external != c.verifying {
+		c.verifying = external
+		go c.verifyMapping(external)
+	}
+	if c.verify != nil && external == c.failedExternal {
+		// Still withdrawn until it passes verification.
+		external = netip.AddrPort{}
+	}
+	c.noteExternalLocked(external)
+}
+
+// verifyTimeout is how long a mapping verifier may take.
+const verifyTimeout = 5 * time.Second
+
+// verifyMapping runs c.verify on the mapping with the given external
+// address, withdrawing the mapping if it fails, or handing it out again if
+// it passes after failing before.
+func (c *Client) verifyMapping(external netip.AddrPort) {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+	defer cancel()
+	err := c.verify(ctx, external)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.verifying == external {
+		c.verifying = netip.AddrPort{}
+	}
+	if c.closed || c.mapping == nil || c.mapping.External() != external {
+		// Replaced or invalidated while we were verifying it.
+		return
+	}
+	if err != nil {
+		metricVerifyFailed.Add(1)
+		c.logf("mapping %v (%s) does not pass traffic; withdrawing it: %v", external, c.mapping.MappingType(), err)
+		c.noteEventLocked(Event{What: "verify-failed", Type: c.mapping.MappingType(), External: external, Detail: err.Error()})
+		c.failedExternal = external
+		c.noteExternalLocked(netip.AddrPort{})
+		return
+	}
+	metricVerifyOK.Add(1)
+	c.verifiedExternal = external
+	if c.failedExternal == external {
+		c.failedExternal = netip.AddrPort{}
+		c.noteExternalLocked(external)
 	}
 }
 
@@ -486,7 +814,8 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	if c.debug.disableAll() {
 		return netip.AddrPort{}, NoMappingError{ErrPortMappingDisabled}
 	}
-	if c.debug.DisableUPnP && c.debug.DisablePCP && c.debug.DisablePMP {
+	disabled := c.disabledServices()
+	if disabled.all() {
 		return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
 	}
 	gw, myIP, ok := c.gatewayAndSelfIP()
@@ -501,6 +830,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 
 	// Log what kind of portmap we obtained
 	reusedExisting := false
+	renewing := false // whether we're renewing an existing mapping
 	defer func() {
 		if err != nil {
 			return
@@ -509,6 +839,24 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 		c.mu.Lock()
 		defer c.mu.Unlock()
 
+		if c.mapping != nil && !reusedExisting {
+			if pm := protoMetricsFor(c.mapping.MappingType()); pm != nil {
+				pm.noteMapped(renewing, time.Since(now))
+			}
+			c.noteMappedLocked(gw, myIP, external)
+			what := "mapped"
+			if renewing {
+				what = "renewed"
+			}
+			c.noteEventLocked(Event{
+				What:     what,
+				Gateway:  gw,
+				Type:     c.mapping.MappingType(),
+				External: external,
+				Detail:   fmt.Sprintf("in %v, good until %v", time.Since(now).Round(time.Millisecond), c.mapping.GoodUntil().Format(time.TimeOnly)),
+			})
+		}
+
 		portmapType := "none"
 		if c.mapping != nil {
 			portmapType = c.mapping.MappingType()
@@ -539,36 +887,83 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	localPort := c.localPort
 	internalAddr := netip.AddrPortFrom(myIP, localPort)
 
-	// prevPort is the port we had most previously, if any. We try
-	// to ask for the same port. 0 means to give us any port.
-	var prevPort uint16
+	// prevPort is the port we had most previously, if any, including
+	// in an earlier run. We try to ask for the same port. 0 means to
+	// give us any port.
+	prevPort := c.savedPortLocked(gw, myIP)
 
 	// Do we have an existing mapping that's valid?
 	if m := c.mapping; m != nil {
-		if now.Before(m.RenewAfter()) {
+		if now.Before(m.RenewAfter()) && !c.renewNow {
 			defer c.mu.Unlock()
 			reusedExisting = true
 			return m.External(), nil
 		}
 		// The mapping might still be valid, so just try to renew it.
 		prevPort = m.External().Port()
-	}
-
-	if c.debug.DisablePCP && c.debug.DisablePMP {
-		c.mu.Unlock()
-		if external, ok := c.getUPnPPortMapping(ctx, gw, internalAddr, prevPort); ok {
-			return external, nil
-		}
-		c.vlogf("fallback to UPnP due to PCP and PMP being disabled failed")
-		return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
+		renewing = true
+		c.renewNow = false
 	}
 
 	// If we just did a Probe (e.g. via netchecker) but didn't
-	// find a PMP service, bail out early rather than probing
-	// again. Cuts down latency for most clients.
+	// find a PMP service, don't try it again. Cuts down latency
+	// for most clients.
 	haveRecentPMP := c.sawPMPRecentlyLocked()
 	haveRecentPCP := c.sawPCPRecentlyLocked()
 
+	var attempts []mappingAttempt
+	if !(disabled.PCP && disabled.PMP) && !(c.lastProbe.After(now.Add(-5*time.Second)) && !haveRecentPMP && !haveRecentPCP) {
+		req := pxpMappingRequest{
+			gw:        gw,
+			myIP:      myIP,
+			localPort: localPort,
+			prevPort:  prevPort,
+			preferPCP: !disabled.PCP && (disabled.PMP || (!haveRecentPMP && haveRecentPCP)),
+		}
+		if haveRecentPMP {
+			req.pmpPubIP = c.pmpPubIP
+		}
+		attempts = append(attempts, mappingAttempt{
+			name: "pxp",
+			create: func(ctx context.Context) (mapping, error) {
+				return c.createPxPMapping(ctx, req)
+			},
+		})
+	}
+	if !disabled.UPnP {
+		attempts = append(attempts, c.upnpMappingAttempt(gw, internalAddr, prevPort))
+	}
+	c.mu.Unlock()
+
+	_, m, err := c.runMappingAttempts(ctx, attempts)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setMappingLocked(m)
+	return m.External(), nil
+}
+
+// pxpMappingRequest is what createPxPMapping needs to ask the gateway for
+// a mapping.
+type pxpMappingRequest struct {
+	gw        netip.Addr
+	myIP      netip.Addr
+	localPort uint16
+	prevPort  uint16     // the external port to ask for, or 0 for any
+	preferPCP bool       // whether to use PCP rather than NAT-PMP
+	pmpPubIP  netip.Addr // the gateway's external IP, if recently learned over NAT-PMP
+}
+
+// createPxPMapping creates a port mapping over NAT-PMP or PCP, as req
+// says, without making it c's mapping.
+//
+// If the gateway doesn't answer, the error will be of type NoMappingError;
+// see IsNoMappingError.
+func (c *Client) createPxPMapping(ctx context.Context, req pxpMappingRequest) (mapping, error) {
+	internalAddr := netip.AddrPortFrom(req.myIP, req.localPort)
+
 	// Since PMP mapping may require multiple calls, and it's not clear from the outset
 	// whether we're doing a PCP or PMP call, initialize the PMP mapping here,
 	// and only return it once completed.
@@ -577,64 +972,61 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	// construct it upon receiving that packet.
 	m := &pmpMapping{
 		c:        c,
-		gw:       netip.AddrPortFrom(gw, c.pxpPort()),
+		gw:       netip.AddrPortFrom(req.gw, c.pxpPort()),
 		internal: internalAddr,
+		proto:    c.protocol,
 	}
-	if haveRecentPMP {
-		m.external = netip.AddrPortFrom(c.pmpPubIP, m.external.Port())
+	if req.pmpPubIP.IsValid() {
+		m.external = netip.AddrPortFrom(req.pmpPubIP, m.external.Port())
 	}
-	if c.lastProbe.After(now.Add(-5*time.Second)) && !haveRecentPMP && !haveRecentPCP {
-		c.mu.Unlock()
-		// fallback to UPnP portmapping
-		if external, ok := c.getUPnPPortMapping(ctx, gw, internalAddr, prevPort); ok {
-			return external, nil
-		}
-		c.vlogf("fallback to UPnP due to no PCP and PMP failed")
-		return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
-	}
-	c.mu.Unlock()
 
 	uc, err := c.listenPacket(ctx, "udp4", ":0")
 	if err != nil {
-		return netip.AddrPort{}, err
+		return nil, err
 	}
 	defer uc.Close()
 
 	uc.SetReadDeadline(time.Now().Add(portMapServiceTimeout))
 	defer closeCloserOnContextDone(ctx, uc)()
 
-	pxpAddr := netip.AddrPortFrom(gw, c.pxpPort())
+	pxpAddr := netip.AddrPortFrom(req.gw, c.pxpPort())
 
-	preferPCP := !c.debug.DisablePCP && (c.debug.DisablePMP || (!haveRecentPMP && haveRecentPCP))
+	pxpMetrics := metricsPMP
+	if req.preferPCP {
+		pxpMetrics = metricsPCP
+	}
 
 	// Create a mapping, defaulting to PMP unless only PCP was seen recently.
-	if preferPCP {
+	if req.preferPCP {
 		// TODO replace wildcardIP here with previous external if known.
 		// Only do PCP mapping in the case when PMP did not appear to be available recently.
-		pkt := buildPCPRequestMappingPacket(myIP, localPort, prevPort, pcpMapLifetimeSec, wildcardIP)
+		pkt := buildPCPRequestMappingPacket(c.protocol, req.myIP, req.localPort, req.prevPort, c.leaseSec(), wildcardIP)
 		if _, err := uc.WriteToUDPAddrPort(pkt, pxpAddr); err != nil {
+			pxpMetrics.noteFailed(failNetwork)
 			if neterror.TreatAsLostUDP(err) {
 				err = NoMappingError{ErrNoPortMappingServices}
 			}
-			return netip.AddrPort{}, err
+			return nil, err
 		}
 	} else {
 		// Ask for our external address if needed.
 		if !m.external.Addr().IsValid() {
 			if _, err := uc.WriteToUDPAddrPort(pmpReqExternalAddrPacket, pxpAddr); err != nil {
+				pxpMetrics.noteFailed(failNetwork)
 				if neterror.TreatAsLostUDP(err) {
 					err = NoMappingError{ErrNoPortMappingServices}
 				}
-				return netip.AddrPort{}, err
+				return nil, err
 			}
 		}
 
-		pkt := buildPMPRequestMappingPacket(localPort, prevPort, pmpMapLifetimeSec)
+		pkt := buildPMPRequestMappingPacket(c.protocol, req.localPort, req.prevPort, c.leaseSec())
 		if _, err := uc.WriteToUDPAddrPort(pkt, pxpAddr); err != nil {
+			pxpMetrics.noteFailed(failNetwork)
 			if neterror.TreatAsLostUDP(err) {
 				err = NoMappingError{ErrNoPortMappingServices}
 			}
-			return netip.AddrPort{}, err
+			return nil, err
 		}
 	}
 
@@ -643,13 +1035,10 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 		n, src, err := uc.ReadFromUDPAddrPort(res)
 		if err != nil {
 			if ctx.Err() == context.Canceled {
-				return netip.AddrPort{}, err
-			}
-			// fallback to UPnP portmapping
-			if mapping, ok := c.getUPnPPortMapping(ctx, gw, internalAddr, prevPort); ok {
-				return mapping, nil
+				return nil, err
 			}
-			return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
+			pxpMetrics.noteFailed(failTimeout)
+			return nil, NoMappingError{ErrNoPortMappingServices}
 		}
 		src = netaddr.Unmap(src)
 		if !src.IsValid() {
@@ -665,12 +1054,13 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 					continue
 				}
 				if pres.ResultCode != 0 {
-					return netip.AddrPort{}, NoMappingError{fmt.Errorf("PMP response Op=0x%x,Res=0x%x", pres.OpCode, pres.ResultCode)}
+					metricsPMP.noteFailed(failRefused)
+					return nil, NoMappingError{fmt.Errorf("PMP response Op=0x%x,Res=0x%x", pres.OpCode, pres.ResultCode)}
 				}
 				if pres.OpCode == pmpOpReply|pmpOpMapPublicAddr {
 					m.external = netip.AddrPortFrom(pres.PublicAddr, m.external.Port())
 				}
-				if pres.OpCode == pmpOpReply|pmpOpMapUDP {
+				if pres.OpCode == pmpOpReply|c.protocol.pmpOp() {
 					m.external = netip.AddrPortFrom(m.external.Addr(), pres.ExternalPort)
 					d := time.Duration(pres.MappingValidSeconds) * time.Second
 					now := time.Now()
@@ -682,27 +1072,28 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 				pcpMapping, err := parsePCPMapResponse(res[:n])
 				if err != nil {
 					c.logf("failed to get PCP mapping: %v", err)
+					if pres, ok := parsePCPResponse(res[:n]); ok && pres.ResultCode != pcpCodeOK {
+						metricsPCP.noteFailed(failRefused)
+					} else {
+						metricsPCP.noteFailed(failInvalid)
+					}
 					// PCP should only have a single packet response
-					return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
+					return nil, NoMappingError{ErrNoPortMappingServices}
 				}
 				pcpMapping.c = c
+				pcpMapping.proto = c.protocol
 				pcpMapping.internal = m.internal
-				pcpMapping.gw = netip.AddrPortFrom(gw, c.pxpPort())
-				c.mu.Lock()
-				defer c.mu.Unlock()
-				c.mapping = pcpMapping
-				return pcpMapping.external, nil
+				pcpMapping.gw = pxpAddr
+				return pcpMapping, nil
 			default:
 				c.logf("unknown PMP/PCP version number: %d %v", version, res[:n])
-				return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
+				pxpMetrics.noteFailed(failInvalid)
+				return nil, NoMappingError{ErrNoPortMappingServices}
 			}
 		}
 
 		if m.externalValid() {
-			c.mu.Lock()
-			defer c.mu.Unlock()
-			c.mapping = m
-			return m.external, nil
+			return m, nil
 		}
 	}
 }
@@ -720,6 +1111,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	pmpVersion         = 0
 	pmpOpMapPublicAddr = 0
 	pmpOpMapUDP        = 1
+	pmpOpMapTCP        = 2
 	pmpOpReply         = 0x80 // OR'd into request's op code on response
 
 	pmpCodeOK                 pmpResultCode = 0
@@ -730,10 +1122,10 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	pmpCodeUnsupportedOpcode  pmpResultCode = 5
 )
 
-func buildPMPRequestMappingPacket(localPort, prevPort uint16, lifetimeSec uint32) (pkt []byte) {
+func buildPMPRequestMappingPacket(proto Protocol, localPort, prevPort uint16, lifetimeSec uint32) (pkt []byte) {
 	pkt = make([]byte, 12)
 
-	pkt[1] = pmpOpMapUDP
+	pkt[1] = proto.pmpOp()
 	binary.BigEndian.PutUint16(pkt[4:], localPort)
 	binary.BigEndian.PutUint16(pkt[6:], prevPort)
 	binary.BigEndian.PutUint32(pkt[8:], lifetimeSec)
@@ -767,7 +1159,7 @@ func parsePMPResponse(pkt []byte) (res pmpResponse, ok bool) {
 	res.ResultCode = pmpResultCode(binary.BigEndian.Uint16(pkt[2:]))
 	res.SecondsSinceEpoch = binary.BigEndian.Uint32(pkt[4:])
 
-	if res.OpCode == pmpOpReply|pmpOpMapUDP {
+	if res.OpCode == pmpOpReply|pmpOpMapUDP || res.OpCode == pmpOpReply|pmpOpMapTCP {
 		if len(pkt) != 16 {
 			return res, false
 		}
@@ -790,6 +1182,27 @@ type ProbeResult struct {
 	PCP  bool
 	PMP  bool
 	UPnP bool
+
+	// DoubleNAT is whether the gateway's external IP address is
+	// private, meaning mappings won't make this machine reachable from
+	// the internet. See Client.DoubleNAT.
+	DoubleNAT bool
+
+	// CGNAT is whether the gateway's external IP address is in the
+	// carrier-grade NAT range (RFC 6598), meaning the other NAT is the
+	// ISP's. It implies DoubleNAT.
+	CGNAT bool
+
+	// ExternalPortStable is whether the Client's own mappings have all
+	// had the same external port, through renewals and re-creations,
+	// since the network last changed. It's empty if there's been no
+	// mapping.
+	ExternalPortStable opt.Bool
+
+	// UPnPMappingVerified is when the gateway last confirmed that the
+	// current UPnP mapping, if any, still pointed at this machine, as
+	// checked before re-using or renewing it. It's zero if it hasn't.
+	UPnPMappingVerified time.Time
 }
 
 // Probe returns a summary of which port mapping services are
@@ -802,16 +1215,48 @@ func (c *Client) Probe(ctx context.Context) (res ProbeResult, err error) {
 	if c.debug.disableAll() {
 		return res, ErrPortMappingDisabled
 	}
+	// Disabled services aren't probed at all, not even to see whether
+	// they're there.
+	disabled := c.disabledServices()
+	if disabled.all() {
+		return res, ErrPortMappingDisabled
+	}
 	gw, myIP, ok := c.gatewayAndSelfIP()
 	if !ok {
 		return res, ErrGatewayRange
 	}
+	c.mu.Lock()
+	others := c.otherGatewaysLocked(gw)
+	c.mu.Unlock()
+
+	// heard is the set of candidate gateways that answered this probe.
+	// sentToGW is whether we sent gw any queries; if we didn't, it's
+	// because it answered them recently.
+	heard := make(map[netip.Addr]bool)
+	sentToGW := false
+	var upnp6 *ssdp6Discovery
 	defer func() {
-		if err == nil {
-			c.mu.Lock()
-			defer c.mu.Unlock()
-			c.lastProbe = time.Now()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err != nil {
+			c.noteEventLocked(Event{What: "probe-failed", Gateway: gw, Detail: err.Error()})
+			return
+		}
+		c.lastProbe = time.Now()
+		if !sentToGW || res.PCP || res.PMP || res.UPnP {
+			heard[gw] = true
+		}
+		c.noteGatewaysProbedLocked(c.lastProbe, heard)
+		ext := c.externalIPLocked()
+		res.DoubleNAT = isDoubleNATAddr(ext)
+		res.CGNAT = res.DoubleNAT && tsaddr.CGNATRange().Contains(ext)
+		res.ExternalPortStable = c.externalPortStable
+		res.UPnPMappingVerified = upnpVerifiedAt(c.mapping)
+		detail := fmt.Sprintf("pcp=%v pmp=%v upnp=%v double-nat=%v", res.PCP, res.PMP, res.UPnP, res.DoubleNAT)
+		if !sentToGW {
+			detail += " (cached)"
 		}
+		c.noteEventLocked(Event{What: "probe", Gateway: gw, Detail: detail})
 	}()
 
 	uc, err := c.listenPacket(context.Background(), "udp4", ":0")
@@ -831,21 +1276,29 @@ func (c *Client) Probe(ctx context.Context) (res ProbeResult, err error) {
 	// Don't send probes to services that we recently learned (for
 	// the same gw/myIP) are available. See
 	// https://github.com/tailscale/tailscale/issues/1001
-	if c.sawPMPRecently() {
+	if disabled.PMP {
+		// Not probed.
+	} else if c.sawPMPRecently() {
 		res.PMP = true
-	} else if !c.debug.DisablePMP {
+	} else {
 		metricPMPSent.Add(1)
+		sentToGW = true
 		uc.WriteToUDPAddrPort(pmpReqExternalAddrPacket, pxpAddr)
 	}
-	if c.sawPCPRecently() {
+	if disabled.PCP {
+		// Not probed.
+	} else if c.sawPCPRecently() {
 		res.PCP = true
-	} else if !c.debug.DisablePCP {
+	} else {
 		metricPCPSent.Add(1)
+		sentToGW = true
 		uc.WriteToUDPAddrPort(pcpAnnounceRequest(myIP), pxpAddr)
 	}
-	if c.sawUPnPRecently() {
+	if disabled.UPnP {
+		// Not probed.
+	} else if c.sawUPnPRecently() {
 		res.UPnP = true
-	} else if !c.debug.DisableUPnP {
+	} else {
 		// Strictly speaking, you discover UPnP services by sending an
 		// SSDP query (which uPnPPacket is) to udp/1900 on the SSDP
 		// multicast address, and then get a flood of responses back
@@ -891,10 +1344,38 @@ func (c *Client) Probe(ctx context.Context) (res ProbeResult, err error) {
 		// just ssdp:all, because there appear to be devices which only send
 		// their first descriptor (like urn:schemas-wifialliance-org:device:WFADevice:1)
 		// in response to ssdp:all. https://github.com/tailscale/tailscale/issues/3557
+		//
+		// The multicast queries are rate limited and jittered so that
+		// many nodes on one LAN don't flood it; see ssdplimit.go.
 		metricUPnPSent.Add(1)
+		sentToGW = true
 		uc.WriteToUDPAddrPort(uPnPPacket, upnpAddr)
-		uc.WriteToUDPAddrPort(uPnPPacket, upnpMulticastAddr)
-		uc.WriteToUDPAddrPort(uPnPIGDPacket, upnpMulticastAddr)
+		if c.allowSSDPMulticast() {
+			jitter := ssdpJitter()
+			t := time.AfterFunc(jitter, func() {
+				uc.WriteToUDPAddrPort(uPnPPacket, upnpMulticastAddr)
+				uc.WriteToUDPAddrPort(uPnPIGDPacket, upnpMulticastAddr)
+			})
+			defer t.Stop()
+
+			// And look for devices that only answer over IPv6.
+			upnp6 = c.startSSDP6Discovery(ctx, myIP, jitter)
+		}
+	}
+
+	// Also probe the other candidate gateways, if any, so that we know
+	// which of them we can fail over to if gw stops answering. Their
+	// replies only count towards their gatewayState, not res.
+	for other, self := range others {
+		if !disabled.PMP {
+			uc.WriteToUDPAddrPort(pmpReqExternalAddrPacket, netip.AddrPortFrom(other, c.pxpPort()))
+		}
+		if !disabled.PCP {
+			uc.WriteToUDPAddrPort(pcpAnnounceRequest(self), netip.AddrPortFrom(other, c.pxpPort()))
+		}
+		if !disabled.UPnP {
+			uc.WriteToUDPAddrPort(uPnPPacket, netip.AddrPortFrom(other, c.upnpPort()))
+		}
 	}
 
 	// We can see multiple UPnP responses from LANs with multiple
@@ -945,6 +1426,13 @@ func (c *Client) Probe(ctx context.Context) (res ProbeResult, err error) {
 		}
 		c.mu.Unlock()
 	}()
+	defer func() {
+		// Runs before the above, to add its responses to upnpResponses.
+		if res6 := upnp6.finish(); len(res6) > 0 {
+			res.UPnP = true
+			upnpResponses = append(upnpResponses, res6...)
+		}
+	}()
 
 	// This is the main loop that receives UDP packets and parses them into
 	// PCP, PMP, or UPnP responses, updates our ProbeResult, and stores
@@ -974,6 +1462,13 @@ func (c *Client) Probe(ctx context.Context) (res ProbeResult, err error) {
 		}
 
 		ip := src.Addr().Unmap()
+		if _, ok := others[ip]; ok {
+			heard[ip] = true
+			continue
+		}
+		if ip == gw {
+			heard[gw] = true
+		}
 
 		handleUPnPResponse := func() {
 			metricUPnPResponse.Add(1)
@@ -1065,6 +1560,7 @@ func (c *Client) Probe(ctx context.Context) (res ProbeResult, err error) {
 					c.pmpPubIP = pres.PublicAddr
 					c.pmpPubIPTime = time.Now()
 					c.pmpLastEpoch = pres.SecondsSinceEpoch
+					c.noteExternalIPLocked(pres.PublicAddr)
 					c.mu.Unlock()
 					continue
 				case pmpCodeNotAuthorized:
@@ -1107,14 +1603,28 @@ func (c *Client) maybeInvalidatePMPMappingLocked(epoch uint32) {
 
 	// Epoch decreased, so invalidate the mapping and clear PMP fields.
 	c.logf("invalidating PMP mappings since returned epoch %d < stored epoch %d", epoch, m.epoch)
+	c.noteInvalidatedLocked(fmt.Sprintf("gateway restarted: PMP epoch %d < %d", epoch, m.epoch))
 	c.mapping = nil
+	c.verifiedExternal = netip.AddrPort{}
+	c.failedExternal = netip.AddrPort{}
 	c.pmpPubIP = netip.Addr{}
 	c.pmpPubIPTime = time.Time{}
 	c.pmpLastEpoch = 0
 }
 
 func (c *Client) maybeInvalidatePCPMappingLocked(epoch uint32) {
-	if epoch == 0 || c.mapping == nil {
+	if epoch == 0 {
+		return
+	}
+	for _, m := range c.pcpPeers {
+		if epoch < m.epoch {
+			// The gateway restarted, and lost our PEER mappings.
+			c.logf("forgetting PCP PEER mappings since returned epoch %d < stored epoch %d", epoch, m.epoch)
+			c.invalidatePCPPeersLocked(false)
+			break
+		}
+	}
+	if c.mapping == nil {
 		return
 	}
 	m, ok := c.mapping.(*pcpMapping)
@@ -1133,7 +1643,10 @@ func (c *Client) maybeInvalidatePCPMappingLocked(epoch uint32) {
 
 	// Epoch decreased, so invalidate the mapping and clear PCP fields.
 	c.logf("invalidating PCP mappings since returned epoch %d < stored epoch %d", epoch, m.epoch)
+	c.noteInvalidatedLocked(fmt.Sprintf("gateway restarted: PCP epoch %d < %d", epoch, m.epoch))
 	c.mapping = nil
+	c.verifiedExternal = netip.AddrPort{}
+	c.failedExternal = netip.AddrPort{}
 	c.pcpSawTime = time.Time{}
 	c.pcpLastEpoch = 0
 }
@@ -1212,6 +1725,17 @@ func (c *Client) maybeInvalidatePCPMappingLocked(epoch uint32) {
 	metricPMPNotAuthorized = clientmetric.NewCounter("portmap_pmp_not_authorized")
 )
 
+// Mapping verification metrics
+var (
+	// metricVerifyOK counts the number of new mappings that were verified
+	// to pass traffic.
+	metricVerifyOK = clientmetric.NewCounter("portmap_verify_ok")
+
+	// metricVerifyFailed counts the number of times that a mapping failed
+	// verification and was withdrawn, or kept withdrawn.
+	metricVerifyFailed = clientmetric.NewCounter("portmap_verify_failed")
+)
+
 // UPnP metrics
 var (
 	// metricUPnPSent counts the number of times we sent a UPnP request.
@@ -1252,15 +1776,54 @@ func (c *Client) maybeInvalidatePCPMappingLocked(epoch uint32) {
 	// picked a UPnP device that is not up.
 	metricUPnPSelectNone = clientmetric.NewCounter("portmap_upnp_select_none")
 
+	// metricUPnPConflictRetries counts the number of times that
+	// AddPortMapping was retried with another external port after the
+	// one requested was already mapped.
+	metricUPnPConflictRetries = clientmetric.NewCounter("portmap_upnp_conflict_retries")
+
+	// metricUPnPCacheRootHit counts the number of times a cached UPnP
+	// root device description was used instead of fetching it.
+	metricUPnPCacheRootHit = clientmetric.NewCounter("portmap_upnp_cache_root_hit")
+
+	// metricUPnPCacheExtIPHit counts the number of times a cached UPnP
+	// external IP address was used instead of asking the router.
+	metricUPnPCacheExtIPHit = clientmetric.NewCounter("portmap_upnp_cache_extip_hit")
+
 	// metricUPnPParseErr counts the number of times we failed to parse a UPnP response.
 	metricUPnPParseErr = clientmetric.NewCounter("portmap_upnp_parse_err")
 
 	// metricUPnPOK counts the number of times we received a usable UPnP response.
 	metricUPnPOK = clientmetric.NewCounter("portmap_upnp_ok")
 
+	// metricUPnPPinholeOK counts the number of IPv6 firewall pinholes
+	// opened over UPnP
+	metricUPnPPinholeOK = clientmetric.NewCounter("portmap_upnp_pinhole_ok")
+
+	// metricUPnPPinholeFailed counts the number of times we found a UPnP
+	// IPv6 firewall service but failed to open a pinhole with it
+	metricUPnPPinholeFailed = clientmetric.NewCounter("portmap_upnp_pinhole_failed")
+
 	// metricUPnPUpdatedMeta counts the number of times
 	// we received a UPnP response with a new meta.
 	metricUPnPUpdatedMeta = clientmetric.NewCounter("portmap_upnp_updated_meta")
+
+	// metricUPnPStaleClient counts the number of times that the UPnP
+	// service of our existing mapping stopped answering, such as after
+	// the router rebooted, and we rediscovered UPnP devices.
+	metricUPnPStaleClient = clientmetric.NewCounter("portmap_upnp_stale_client")
+
+	// metricUPnPEntryMissing counts the number of times that a UPnP
+	// mapping we were about to re-use had disappeared from the gateway.
+	metricUPnPEntryMissing = clientmetric.NewCounter("portmap_upnp_entry_missing")
+
+	// metricUPnPEntryTaken counts the number of times that the external
+	// port of a UPnP mapping we were about to re-use had been mapped to
+	// another client.
+	metricUPnPEntryTaken = clientmetric.NewCounter("portmap_upnp_entry_taken")
+
+	// metricReleasedOnClose counts the number of mappings and pinholes
+	// deleted from the router because their Client was closed.
+	metricReleasedOnClose = clientmetric.NewCounter("portmap_released_on_close")
 )
 
 // UPnP error metric that's keyed by code; lazily registered on first read
@@ -5,6 +5,9 @@
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/netip"
 	"os"
 	"reflect"
 	"strconv"
@@ -126,6 +129,123 @@ func TestPCPIntegration(t *testing.T) {
 	}
 }
 
+func TestMappingVerifier(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	for _, pass := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pass=%v", pass), func(t *testing.T) {
+			c := newTestClient(t, igd)
+			defer c.Close()
+			verified := make(chan netip.AddrPort, 1)
+			release := make(chan error)
+			c.SetMappingVerifier(func(ctx context.Context, external netip.AddrPort) error {
+				verified <- external
+				return <-release
+			})
+			if _, err := c.Probe(context.Background()); err != nil {
+				t.Fatalf("probe failed: %v", err)
+			}
+			waitVerified := func() {
+				t.Helper()
+				for range 1000 {
+					c.mu.Lock()
+					busy := c.verifying.IsValid()
+					c.mu.Unlock()
+					if !busy {
+						return
+					}
+					time.Sleep(time.Millisecond)
+				}
+				t.Fatal("verification didn't finish")
+			}
+
+			// The mapping is handed out while it's verified.
+			c.createMapping()
+			external := <-verified
+			if ext, ok := c.GetCachedMappingOrStartCreatingOne(); !ok || ext != external {
+				t.Errorf("while verifying, GetCachedMappingOrStartCreatingOne = %v, %v; want %v, true", ext, ok, external)
+			}
+			var err error
+			if !pass {
+				err = errors.New("no hairpin")
+			}
+			release <- err
+			waitVerified()
+			if got := c.HaveMapping(); got != pass {
+				t.Errorf("HaveMapping = %v; want %v", got, pass)
+			}
+			ext, ok := c.GetCachedMappingOrStartCreatingOne()
+			if ok != pass {
+				t.Errorf("GetCachedMappingOrStartCreatingOne ok = %v; want %v", ok, pass)
+			}
+			if pass && ext != external {
+				t.Errorf("got external %v; want %v", ext, external)
+			}
+			if pass {
+				return
+			}
+
+			// A withdrawn mapping stays withdrawn when it's renewed,
+			// until it passes verification.
+			c.createMapping()
+			<-verified
+			if c.HaveMapping() {
+				t.Error("failed mapping handed out again while re-verifying")
+			}
+			release <- nil
+			waitVerified()
+			if ext, ok := c.GetCachedMappingOrStartCreatingOne(); !ok || ext != external {
+				t.Errorf("after passing, GetCachedMappingOrStartCreatingOne = %v, %v; want %v, true", ext, ok, external)
+			}
+		})
+	}
+}
+
+func TestMappingProtocol(t *testing.T) {
+	tests := []struct {
+		proto    Protocol
+		wantPMP  uint8
+		wantPCP  uint8
+		wantUPnP string
+	}{
+		{UDP, 1, 17, "UDP"},
+		{TCP, 2, 6, "TCP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.proto.String(), func(t *testing.T) {
+			pkt := buildPMPRequestMappingPacket(tt.proto, 1234, 0, pmpMapLifetimeSec)
+			if got := pkt[1]; got != tt.wantPMP {
+				t.Errorf("PMP opcode = %d; want %d", got, tt.wantPMP)
+			}
+			pkt = buildPCPRequestMappingPacket(tt.proto, netip.MustParseAddr("192.168.1.2"), 1234, 0, pcpMapLifetimeSec, wildcardIP)
+			if got := pkt[24+12]; got != tt.wantPCP {
+				t.Errorf("PCP protocol = %d; want %d", got, tt.wantPCP)
+			}
+			if got := tt.proto.upnpName(); got != tt.wantUPnP {
+				t.Errorf("UPnP protocol = %q; want %q", got, tt.wantUPnP)
+			}
+
+			resp := []byte{
+				pmpVersion, pmpOpReply | tt.wantPMP, 0, 0, // version, op, result
+				0, 0, 0, 1, // seconds since epoch
+				0x04, 0xd2, 0x10, 0xe1, // internal port 1234, external port 4321
+				0, 0, 0x1c, 0x20, // lifetime 7200
+			}
+			res, ok := parsePMPResponse(resp)
+			if !ok {
+				t.Fatal("parsePMPResponse failed")
+			}
+			if res.InternalPort != 1234 || res.ExternalPort != 4321 || res.MappingValidSeconds != 7200 {
+				t.Errorf("parsePMPResponse = %+v", res)
+			}
+		})
+	}
+}
+
 // Test to ensure that metric names generated by this function do not contain
 // invalid characters.
 //
@@ -136,3 +256,47 @@ func TestGetUPnPErrorsMetric(t *testing.T) {
 	getUPnPErrorsMetric(0)
 	getUPnPErrorsMetric(-100)
 }
+
+func TestCloseReleasesMapping(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.createOrGetMapping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	waitDeletes := func(want int32) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for igd.stats().numPCPDeleteRecv < want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := igd.stats().numPCPDeleteRecv; got != want {
+			t.Fatalf("got %d PCP deletions; want %d", got, want)
+		}
+	}
+
+	c.Close()
+	waitDeletes(1)
+
+	// A mapping that completes after Close is released rather than kept.
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.createOrGetMapping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitDeletes(2)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mapping != nil {
+		t.Errorf("mapping kept after Close: %v", c.mapping.MappingDebug())
+	}
+}
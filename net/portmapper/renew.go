@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+const (
+	// renewRetryMin is how long we wait before retrying a failed
+	// renewal. It doubles with each consecutive failure.
+	renewRetryMin = 5 * time.Second
+
+	// renewRetryMax is the longest we wait between retries of a failed
+	// renewal.
+	renewRetryMax = 2 * time.Minute
+)
+
+var (
+	// metricRenewFailed counts the number of times a background
+	// renewal of a mapping failed.
+	metricRenewFailed = clientmetric.NewCounter("portmap_renew_failed")
+
+	// metricRenewExpired counts the number of times a mapping expired
+	// because it couldn't be renewed in time.
+	metricRenewExpired = clientmetric.NewCounter("portmap_renew_expired")
+)
+
+// renewBackoff returns how long to wait before retrying a renewal after
+// the given number of consecutive failures.
+func renewBackoff(failures int) time.Duration {
+	d := renewRetryMin
+	for i := 1; i < failures && d < renewRetryMax; i++ {
+		d *= 2
+	}
+	return min(d, renewRetryMax)
+}
+
+// scheduleRenewLocked arranges for the current mapping, if any, to be
+// renewed in the background. If failed, the last attempt to create or
+// renew it failed and the renewal is retried with backoff, but no later
+// than the mapping's expiry.
+//
+// c.mu must be held.
+func (c *Client) scheduleRenewLocked(failed bool) {
+	if c.renewTimer != nil {
+		c.renewTimer.Stop()
+		c.renewTimer = nil
+	}
+	if c.closed || c.mapping == nil {
+		c.renewFailures = 0
+		return
+	}
+	at := c.mapping.RenewAfter()
+	if failed {
+		metricRenewFailed.Add(1)
+		c.renewFailures++
+		at = time.Now().Add(renewBackoff(c.renewFailures))
+		if goodUntil := c.mapping.GoodUntil(); at.After(goodUntil) {
+			at = goodUntil
+		}
+	} else {
+		c.renewFailures = 0
+	}
+	c.renewTimer = time.AfterFunc(time.Until(at), c.renew)
+}
+
+// renew is called by c.renewTimer to renew the current mapping, or to
+// drop it if it expired before it could be renewed.
+func (c *Client) renew() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.renewTimer = nil
+	m := c.mapping
+	if c.closed || m == nil {
+		return
+	}
+	if !time.Now().Before(m.GoodUntil()) {
+		metricRenewExpired.Add(1)
+		c.logf("mapping %v (%s) expired after %d failed renewals", m.External(), m.MappingType(), c.renewFailures)
+		c.noteEventLocked(Event{What: "expired", Type: m.MappingType(), External: m.External(), Detail: fmt.Sprintf("after %d failed renewals", c.renewFailures)})
+		c.mapping = nil
+		c.verifiedExternal = netip.AddrPort{}
+		c.failedExternal = netip.AddrPort{}
+		c.renewFailures = 0
+		c.noteExternalLocked(netip.AddrPort{})
+		return
+	}
+	c.maybeStartMappingLocked()
+}
@@ -0,0 +1,114 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/net/netmon"
+)
+
+func TestRenewBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{5, 80 * time.Second},
+		{6, renewRetryMax},
+		{100, renewRetryMax},
+	}
+	for _, tt := range tests {
+		if got := renewBackoff(tt.failures); got != tt.want {
+			t.Errorf("renewBackoff(%d) = %v; want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRenewInBackground(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	c.createMapping()
+
+	c.mu.Lock()
+	m, ok := c.mapping.(*pcpMapping)
+	if !ok {
+		c.mu.Unlock()
+		t.Fatalf("got mapping %T; want *pcpMapping", c.mapping)
+	}
+	if c.renewTimer == nil {
+		t.Error("no renewal scheduled after creating mapping")
+	}
+	// Pretend the mapping is at its half-life.
+	m.renewAfter = time.Now()
+	c.scheduleRenewLocked(false)
+	c.mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		renewed := c.mapping != nil && c.mapping != mapping(m)
+		c.mu.Unlock()
+		if renewed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("mapping not renewed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := c.GetCachedMappingOrStartCreatingOne(); !ok {
+		t.Error("no usable mapping after renewal")
+	}
+}
+
+func TestRenewExpired(t *testing.T) {
+	changed := make(chan bool, 1)
+	c := NewClient(t.Logf, netmon.NewStatic(), nil, new(controlknobs.Knobs), func() {
+		changed <- true
+	})
+	defer c.Close()
+
+	external := netip.MustParseAddrPort("1.2.3.4:5678")
+	c.mu.Lock()
+	c.mapping = &pmpMapping{
+		external:   external,
+		renewAfter: time.Now().Add(-2 * time.Second),
+		goodUntil:  time.Now().Add(-time.Second),
+	}
+	c.lastExternal = external
+	c.renewFailures = 3
+	c.mu.Unlock()
+
+	c.renew()
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange not called for expired mapping")
+	}
+	if c.HaveMapping() {
+		t.Error("HaveMapping = true after expiry")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastExternal.IsValid() || c.renewFailures != 0 {
+		t.Errorf("lastExternal = %v, renewFailures = %d; want zero", c.lastExternal, c.renewFailures)
+	}
+}
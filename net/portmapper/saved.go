@@ -0,0 +1,74 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import "net/netip"
+
+// SavedMapping is a port mapping obtained from a gateway, saved so that a
+// later Client (such as after a restart) can ask the gateway for the same
+// external port again. Keeping the same external port keeps the endpoint
+// that peers have cached for this node valid.
+type SavedMapping struct {
+	// Gateway and Self identify the network the mapping was made on:
+	// the gateway's IP and this machine's IP on the gateway's network.
+	// The saved port is only requested from the same gateway when
+	// this machine has the same IP.
+	Gateway netip.Addr
+	Self    netip.Addr
+
+	// External is the external address the gateway mapped.
+	External netip.AddrPort
+}
+
+// IsValid reports whether sm is a mapping, rather than the zero value.
+func (sm SavedMapping) IsValid() bool {
+	return sm.Gateway.IsValid() && sm.External.IsValid()
+}
+
+// SetSavedMapping sets the mapping that c obtained most recently, such as
+// one saved by a previous run's SetSaveMappingFunc. When c has no mapping
+// to renew, it asks the gateway for the saved external port, if the saved
+// mapping was made on the same network.
+func (c *Client) SetSavedMapping(sm SavedMapping) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saved = sm
+}
+
+// SetSaveMappingFunc sets a func that's called, in a new goroutine, with
+// each mapping c obtains whose gateway or external address differs from
+// the previous one, so that it can be saved and restored with
+// SetSavedMapping after a restart.
+func (c *Client) SetSaveMappingFunc(save func(SavedMapping)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saveMapping = save
+}
+
+// savedPortLocked returns the external port to ask gw for when there's no
+// mapping to renew: the port of the saved mapping if it was made with gw
+// and myIP, or else 0 for any port.
+//
+// c.mu must be held.
+func (c *Client) savedPortLocked(gw, myIP netip.Addr) uint16 {
+	if c.saved.IsValid() && c.saved.Gateway == gw && c.saved.Self == myIP {
+		return c.saved.External.Port()
+	}
+	return 0
+}
+
+// noteMappedLocked records that a mapping with the given external address
+// was obtained from gw, calling the save func if it's a new one.
+//
+// c.mu must be held.
+func (c *Client) noteMappedLocked(gw, myIP netip.Addr, external netip.AddrPort) {
+	sm := SavedMapping{Gateway: gw, Self: myIP, External: external}
+	if !sm.IsValid() || sm == c.saved {
+		return
+	}
+	c.saved = sm
+	if c.saveMapping != nil && !c.closed {
+		go c.saveMapping(sm)
+	}
+}
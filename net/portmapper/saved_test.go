@@ -0,0 +1,67 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestSavedMapping(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	gw, self, _ := testIPAndGateway()
+	mapWith := func(saved SavedMapping) (netip.AddrPort, chan SavedMapping) {
+		t.Helper()
+		c := newTestClient(t, igd)
+		defer c.Close()
+		saves := make(chan SavedMapping, 1)
+		c.SetSavedMapping(saved)
+		c.SetSaveMappingFunc(func(sm SavedMapping) { saves <- sm })
+		if _, err := c.Probe(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		ext, err := c.createOrGetMapping(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ext, saves
+	}
+
+	// A first mapping gets any port, and is saved.
+	ext, saves := mapWith(SavedMapping{})
+	select {
+	case sm := <-saves:
+		want := SavedMapping{Gateway: gw, Self: self, External: ext}
+		if sm != want {
+			t.Errorf("saved %+v; want %+v", sm, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mapping not saved")
+	}
+
+	// A saved mapping from the same network has its port requested
+	// again.
+	saved := SavedMapping{Gateway: gw, Self: self, External: netip.AddrPortFrom(ext.Addr(), 5555)}
+	if ext, saves = mapWith(saved); ext.Port() != 5555 {
+		t.Errorf("got port %d; want saved port 5555", ext.Port())
+	}
+	select {
+	case sm := <-saves:
+		t.Errorf("unchanged mapping saved again: %+v", sm)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// One from another network isn't.
+	saved.Gateway = netip.MustParseAddr("192.168.1.1")
+	if ext, _ = mapWith(saved); ext.Port() == 5555 {
+		t.Errorf("got saved port from another gateway's mapping")
+	}
+}
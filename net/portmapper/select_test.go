@@ -39,6 +39,7 @@ func TestSelectBestService(t *testing.T) {
 		rootDesc string
 		control  map[string]map[string]any
 		want     string // controlURL field
+		wantRank upnpServiceRank
 	}{
 		{
 			name:     "single_device",
@@ -50,7 +51,8 @@ func TestSelectBestService(t *testing.T) {
 					"GetStatusInfo":        testGetStatusInfoResponse,
 				},
 			},
-			want: "/ctl/IPConn",
+			want:     "/ctl/IPConn",
+			wantRank: upnpRankPublic,
 		},
 		{
 			name:     "first_device_disconnected",
@@ -72,7 +74,8 @@ func TestSelectBestService(t *testing.T) {
 					"GetStatusInfo":        testGetStatusInfoResponse,
 				},
 			},
-			want: "/upnp/control/xstnsgeuyh/wanipconn-7",
+			want:     "/upnp/control/xstnsgeuyh/wanipconn-7",
+			wantRank: upnpRankPublic,
 		},
 		{
 			name:     "prefer_public_external_IP",
@@ -89,7 +92,8 @@ func TestSelectBestService(t *testing.T) {
 					"GetStatusInfo":        testGetStatusInfoResponse,
 				},
 			},
-			want: "/upnp/control/xstnsgeuyh/wanipconn-7",
+			want:     "/upnp/control/xstnsgeuyh/wanipconn-7",
+			wantRank: upnpRankPublic,
 		},
 		{
 			name:     "all_private_external_IPs",
@@ -104,7 +108,8 @@ func TestSelectBestService(t *testing.T) {
 					"GetExternalIPAddress": testGetExternalIPAddressResponsePrivate,
 				},
 			},
-			want: "/upnp/control/yomkmsnooi/wanipconn-1", // since this is first in the XML
+			want:     "/upnp/control/yomkmsnooi/wanipconn-1", // since this is first in the XML
+			wantRank: upnpRankPrivate,
 		},
 		{
 			name:     "nothing_connected",
@@ -117,7 +122,8 @@ func TestSelectBestService(t *testing.T) {
 					"GetStatusInfo": testGetStatusInfoResponseDisconnected,
 				},
 			},
-			want: "/upnp/control/yomkmsnooi/wanipconn-1", // since this is first in the XML
+			want:     "/upnp/control/yomkmsnooi/wanipconn-1", // since this is first in the XML
+			wantRank: upnpRankDown,
 		},
 		{
 			name:     "GetStatusInfo_errors",
@@ -134,7 +140,8 @@ func TestSelectBestService(t *testing.T) {
 					},
 				},
 			},
-			want: "/upnp/control/yomkmsnooi/wanipconn-1", // since this is first in the XML
+			want:     "/upnp/control/yomkmsnooi/wanipconn-1", // since this is first in the XML
+			wantRank: upnpRankDown,
 		},
 		{
 			name:     "GetExternalIPAddress_bad_ip",
@@ -149,7 +156,42 @@ func TestSelectBestService(t *testing.T) {
 					"GetExternalIPAddress": testGetExternalIPAddressResponse,
 				},
 			},
-			want: "/upnp/control/xstnsgeuyh/wanipconn-7",
+			want:     "/upnp/control/xstnsgeuyh/wanipconn-7",
+			wantRank: upnpRankPublic,
+		},
+		{
+			name:     "zero_external_IP_demoted",
+			rootDesc: testSelectRootDesc,
+			control: map[string]map[string]any{
+				// Service that's up but has no real external IP,
+				// which is less useful than a private one.
+				"/upnp/control/yomkmsnooi/wanipconn-1": {
+					"GetStatusInfo":        testGetStatusInfoResponse,
+					"GetExternalIPAddress": testGetExternalIPAddressResponseZero,
+				},
+				"/upnp/control/xstnsgeuyh/wanipconn-7": {
+					"GetStatusInfo":        testGetStatusInfoResponse,
+					"GetExternalIPAddress": testGetExternalIPAddressResponsePrivate,
+				},
+			},
+			want:     "/upnp/control/xstnsgeuyh/wanipconn-7",
+			wantRank: upnpRankPrivate,
+		},
+		{
+			name:     "CGNAT_external_IP_not_public",
+			rootDesc: testSelectRootDesc,
+			control: map[string]map[string]any{
+				"/upnp/control/yomkmsnooi/wanipconn-1": {
+					"GetStatusInfo":        testGetStatusInfoResponse,
+					"GetExternalIPAddress": testGetExternalIPAddressResponseCGNAT,
+				},
+				"/upnp/control/xstnsgeuyh/wanipconn-7": {
+					"GetStatusInfo":        testGetStatusInfoResponse,
+					"GetExternalIPAddress": testGetExternalIPAddressResponse,
+				},
+			},
+			want:     "/upnp/control/xstnsgeuyh/wanipconn-7",
+			wantRank: upnpRankPublic,
 		},
 	}
 
@@ -174,7 +216,7 @@ func TestSelectBestService(t *testing.T) {
 			loc := mustParseURL(igd.ts.URL)
 			rootDev := mustParseRootDev(t, rootDesc, loc)
 
-			svc, err := selectBestService(ctx, t.Logf, rootDev, loc)
+			svc, rank, err := selectBestService(ctx, t.Logf, rootDev, loc)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -194,6 +236,9 @@ func TestSelectBestService(t *testing.T) {
 			if controlURL != tt.want {
 				t.Errorf("mismatched controlURL: got=%q want=%q", controlURL, tt.want)
 			}
+			if rank != tt.wantRank {
+				t.Errorf("rank = %v; want %v", rank, tt.wantRank)
+			}
 		})
 	}
 }
@@ -358,3 +403,23 @@ func mustParseRootDev(t *testing.T, devXML string, loc *url.URL) *goupnp.RootDev
   </s:Body>
 </s:Envelope>
 `
+
+const testGetExternalIPAddressResponseZero = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+      <NewExternalIPAddress>0.0.0.0</NewExternalIPAddress>
+    </u:GetExternalIPAddressResponse>
+  </s:Body>
+</s:Envelope>
+`
+
+const testGetExternalIPAddressResponseCGNAT = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+      <NewExternalIPAddress>100.64.1.2</NewExternalIPAddress>
+    </u:GetExternalIPAddressResponse>
+  </s:Body>
+</s:Envelope>
+`
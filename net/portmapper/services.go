@@ -0,0 +1,156 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"tailscale.com/envknob"
+)
+
+var (
+	disableUPnpEnv = envknob.RegisterBool("TS_DISABLE_UPNP")
+	disablePMPEnv  = envknob.RegisterBool("TS_DISABLE_PMP")
+	disablePCPEnv  = envknob.RegisterBool("TS_DISABLE_PCP")
+)
+
+// Services is a set of port mapping services.
+type Services struct {
+	UPnP bool
+	PMP  bool // NAT-PMP
+	PCP  bool
+}
+
+// ParseServices parses a comma-separated list of port mapping services:
+// "upnp", "pmp" (or "nat-pmp") and "pcp". The empty string is the empty
+// set.
+func ParseServices(str string) (Services, error) {
+	var s Services
+	if str == "" {
+		return s, nil
+	}
+	for _, name := range strings.Split(str, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "upnp":
+			s.UPnP = true
+		case "pmp", "nat-pmp", "natpmp":
+			s.PMP = true
+		case "pcp":
+			s.PCP = true
+		default:
+			return Services{}, fmt.Errorf("unknown port mapping service %q; want upnp, pmp or pcp", name)
+		}
+	}
+	return s, nil
+}
+
+// String returns s in the form accepted by ParseServices.
+func (s Services) String() string {
+	var names []string
+	if s.UPnP {
+		names = append(names, "upnp")
+	}
+	if s.PMP {
+		names = append(names, "pmp")
+	}
+	if s.PCP {
+		names = append(names, "pcp")
+	}
+	return strings.Join(names, ",")
+}
+
+// Set implements flag.Value.
+func (s *Services) Set(str string) error {
+	v, err := ParseServices(str)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// all reports whether s contains all the services.
+func (s Services) all() bool {
+	return s.UPnP && s.PMP && s.PCP
+}
+
+// has reports whether s contains the service of mappings with the given
+// MappingType.
+func (s Services) has(mappingType string) bool {
+	switch mappingType {
+	case "upnp":
+		return s.UPnP
+	case "pmp":
+		return s.PMP
+	case "pcp":
+		return s.PCP
+	}
+	return false
+}
+
+// SetDisabledServices sets the port mapping services that c must not use,
+// such as UPnP on networks that forbid SSDP traffic. Disabled services
+// aren't probed for, so no packets are sent to them, and any existing
+// mapping made with one is released. It applies to the mappings created
+// with NewPortMapping too.
+//
+// Services can also be disabled by DebugKnobs, the TS_DISABLE_UPNP,
+// TS_DISABLE_PMP and TS_DISABLE_PCP environment variables and, for UPnP,
+// the control plane; SetDisabledServices can't re-enable those.
+func (c *Client) SetDisabledServices(s Services) {
+	if c.disabled.Swap(s) == s {
+		return
+	}
+	c.logf("disabled services: %q", s)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropDisabledLocked()
+	for _, pm := range c.portMappings {
+		pm.c.mu.Lock()
+		pm.c.dropDisabledLocked()
+		pm.c.mu.Unlock()
+	}
+}
+
+// disabledServices returns the port mapping services that c must not use.
+func (c *Client) disabledServices() Services {
+	d := c.disabled.Load()
+	if c.parent != nil {
+		d = c.parent.disabled.Load()
+	}
+	d.UPnP = d.UPnP || c.debug.DisableUPnP || disableUPnpEnv() ||
+		(c.controlKnobs != nil && c.controlKnobs.DisableUPnP.Load())
+	d.PMP = d.PMP || c.debug.DisablePMP || disablePMPEnv()
+	d.PCP = d.PCP || c.debug.DisablePCP || disablePCPEnv()
+	return d
+}
+
+// dropDisabledLocked releases c's mapping if it was made with a service
+// that's now disabled, and forgets that disabled services were seen so
+// that they're no longer reported by Probe.
+//
+// c.mu must be held.
+func (c *Client) dropDisabledLocked() {
+	d := c.disabledServices()
+	if c.mapping != nil && d.has(c.mapping.MappingType()) {
+		c.noteInvalidatedLocked("service disabled")
+		c.invalidateMappingsLocked(true)
+	}
+	if d.PMP {
+		c.pmpPubIP = netip.Addr{}
+		c.pmpPubIPTime = time.Time{}
+	}
+	if d.PCP {
+		c.pcpSawTime = time.Time{}
+	}
+	if d.UPnP {
+		c.uPnPSawTime = time.Time{}
+		c.uPnPMetas = nil
+		c.invalidatePinholeLocked(true)
+	}
+}
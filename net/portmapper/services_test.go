@@ -0,0 +1,108 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseServices(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Services
+		wantErr bool
+	}{
+		{in: "", want: Services{}},
+		{in: "upnp", want: Services{UPnP: true}},
+		{in: "UPnP, nat-pmp", want: Services{UPnP: true, PMP: true}},
+		{in: "pcp,pmp,upnp", want: Services{UPnP: true, PMP: true, PCP: true}},
+		{in: "ssdp", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseServices(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseServices(%q) error = %v; wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseServices(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+		if err == nil {
+			if back, _ := ParseServices(got.String()); back != got {
+				t.Errorf("ParseServices(%q).String() = %q; doesn't round trip", tt.in, got.String())
+			}
+		}
+	}
+}
+
+func TestDisabledServicesNotProbed(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PMP: true, PCP: true, UPnP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.SetDisabledServices(Services{UPnP: true, PCP: true})
+
+	res, err := c.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if res.UPnP || res.PCP {
+		t.Errorf("Probe = %+v; want neither UPnP nor PCP", res)
+	}
+	st := igd.stats()
+	want := igdCounters{
+		numPMPRecv:           1,
+		numPMPPublicAddrRecv: 1,
+	}
+	if !reflect.DeepEqual(st, want) {
+		t.Errorf("unexpected stats:\n got: %+v\nwant: %+v", st, want)
+	}
+
+	c.SetDisabledServices(Services{UPnP: true, PMP: true, PCP: true})
+	if _, err := c.Probe(context.Background()); err != ErrPortMappingDisabled {
+		t.Errorf("Probe with all services disabled: err = %v; want ErrPortMappingDisabled", err)
+	}
+}
+
+func TestDisablingServiceReleasesMapping(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.createOrGetMapping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetDisabledServices(Services{PCP: true})
+	c.mu.Lock()
+	m := c.mapping
+	c.mu.Unlock()
+	if m != nil {
+		t.Errorf("mapping kept after disabling PCP: %v", m.MappingDebug())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for igd.stats().numPCPDeleteRecv == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := igd.stats().numPCPDeleteRecv; got != 1 {
+		t.Errorf("got %d PCP deletions; want 1", got)
+	}
+	if _, err := c.createOrGetMapping(context.Background()); err == nil {
+		t.Error("created a PCP mapping with PCP disabled")
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+package portmapper
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"go4.org/mem"
+	"tailscale.com/types/nettype"
+	"tailscale.com/util/clientmetric"
+)
+
+// SSDP's IPv6 multicast groups (UPnP Device Architecture 2.0 § 1.1.2).
+var (
+	ssdpGroup6LinkLocal = netip.MustParseAddr("ff02::c")
+	ssdpGroup6SiteLocal = netip.MustParseAddr("ff05::c")
+)
+
+// metricUPnP6OK counts the number of usable UPnP discovery responses that
+// we received over IPv6.
+var metricUPnP6OK = clientmetric.NewCounter("portmap_upnp6_ok")
+
+// ssdpSearchPacket returns an SSDP search query for st, addressed to host
+// (the multicast group and port, as it goes in the HOST header).
+func ssdpSearchPacket(host, st string) []byte {
+	return []byte("M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + host + "\r\n" +
+		"ST: " + st + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n")
+}
+
+// ssdp6Discovery is an in-progress SSDP discovery over IPv6.
+type ssdp6Discovery struct {
+	pc   nettype.PacketConn
+	send *time.Timer         // sends the queries
+	done chan struct{}       // closed when read returns
+	res  []uPnPDiscoResponse // owned by read until done is closed
+}
+
+// startSSDP6Discovery sends SSDP queries to SSDP's IPv6 multicast groups on
+// the interface with the address myIP after the given delay, and starts
+// collecting the answers until finish is called. It returns nil if there's
+// no IPv6.
+func (c *Client) startSSDP6Discovery(ctx context.Context, myIP netip.Addr, delay time.Duration) *ssdp6Discovery {
+	dsts := c.ssdp6Targets(myIP)
+	if len(dsts) == 0 {
+		return nil
+	}
+	pc, err := c.listenPacket(ctx, "udp6", ":0")
+	if err != nil {
+		c.vlogf("not probing for UPnP over IPv6: %v", err)
+		return nil
+	}
+	d := &ssdp6Discovery{pc: pc, done: make(chan struct{})}
+	d.send = time.AfterFunc(delay, func() {
+		for _, dst := range dsts {
+			host := netip.AddrPortFrom(dst.Addr().WithZone(""), upnpDefaultPort).String()
+			pc.WriteToUDPAddrPort(ssdpSearchPacket(host, "ssdp:all"), dst)
+			pc.WriteToUDPAddrPort(ssdpSearchPacket(host, "urn:schemas-upnp-org:device:InternetGatewayDevice:1"), dst)
+		}
+	})
+	go d.read(c)
+	return d
+}
+
+// ssdp6Targets returns where to send SSDP queries over IPv6 from the
+// interface with the address myIP.
+func (c *Client) ssdp6Targets(myIP netip.Addr) []netip.AddrPort {
+	if c.testUPnPPort != 0 {
+		return []netip.AddrPort{netip.AddrPortFrom(netip.IPv6Loopback(), c.upnpPort())}
+	}
+	ifc := interfaceWithIP(myIP)
+	if ifc == nil {
+		return nil
+	}
+	return []netip.AddrPort{
+		netip.AddrPortFrom(ssdpGroup6LinkLocal.WithZone(ifc.Name), c.upnpPort()),
+		netip.AddrPortFrom(ssdpGroup6SiteLocal.WithZone(ifc.Name), c.upnpPort()),
+	}
+}
+
+// read collects the Internet Gateway Device discovery responses to d's
+// queries until d.pc is closed.
+func (d *ssdp6Discovery) read(c *Client) {
+	defer close(d.done)
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := d.pc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		if !mem.Contains(mem.B(buf[:n]), mem.S(":InternetGatewayDevice:")) {
+			continue
+		}
+		meta, err := parseUPnPDiscoResponse(buf[:n])
+		if err == nil {
+			meta, err = ssdp6ResponseLocation(meta, src)
+		}
+		if err != nil {
+			metricUPnPParseErr.Add(1)
+			c.logf("unrecognized UPnP discovery response over IPv6; ignoring: %v", err)
+			continue
+		}
+		metricUPnP6OK.Add(1)
+		c.logf("[v1] UPnP reply over IPv6 %+v", meta)
+		if len(d.res) > 10 {
+			c.logf("too many UPnP responses over IPv6: skipping")
+			continue
+		}
+		d.res = append(d.res, meta)
+	}
+}
+
+// finish stops collecting responses and returns those received. It's a
+// no-op on a nil d.
+func (d *ssdp6Discovery) finish() []uPnPDiscoResponse {
+	if d == nil {
+		return nil
+	}
+	d.send.Stop()
+	d.pc.Close()
+	<-d.done
+	return d.res
+}
+
+// ssdp6ResponseLocation checks that meta, a discovery response received
+// over IPv6 from src, has a Location on src, and returns it with src's
+// zone added to the Location, which a link-local address needs to be
+// usable but that the device can't know.
+func ssdp6ResponseLocation(meta uPnPDiscoResponse, src netip.AddrPort) (uPnPDiscoResponse, error) {
+	u, ipp, err := parseUPnPLocation(meta.Location)
+	if err != nil {
+		return uPnPDiscoResponse{}, err
+	}
+	if ipp.Addr().WithZone("") != src.Addr().WithZone("") {
+		return uPnPDiscoResponse{}, fmt.Errorf("UPnP location %q isn't on the responding device %v", meta.Location, src.Addr())
+	}
+	if ipp.Addr().Zone() == "" && src.Addr().Zone() != "" {
+		u.Host = netip.AddrPortFrom(ipp.Addr().WithZone(src.Addr().Zone()), ipp.Port()).String()
+		meta.Location = u.String()
+	}
+	return meta, nil
+}
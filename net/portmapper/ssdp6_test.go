@@ -0,0 +1,148 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestSSDP6ResponseLocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		loc     string
+		src     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "link_local_adds_zone",
+			loc:  "http://[fe80::1]:5000/rootDesc.xml",
+			src:  "[fe80::1%eth0]:1900",
+			want: "http://[fe80::1%25eth0]:5000/rootDesc.xml",
+		},
+		{
+			name: "global",
+			loc:  "http://[2001:db8::1]:5000/rootDesc.xml",
+			src:  "[2001:db8::1]:1900",
+			want: "http://[2001:db8::1]:5000/rootDesc.xml",
+		},
+		{
+			name:    "other_host",
+			loc:     "http://[2001:db8::2]:5000/rootDesc.xml",
+			src:     "[2001:db8::1]:1900",
+			wantErr: true,
+		},
+		{
+			name:    "ipv4_location",
+			loc:     "http://192.168.1.1:5000/rootDesc.xml",
+			src:     "[fe80::1%eth0]:1900",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ssdp6ResponseLocation(uPnPDiscoResponse{Location: tt.loc}, netip.MustParseAddrPort(tt.src))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Location != tt.want {
+				t.Errorf("Location = %q; want %q", got.Location, tt.want)
+			}
+			if _, ipp, err := parseUPnPLocation(got.Location); err != nil {
+				t.Errorf("parseUPnPLocation: %v", err)
+			} else if ipp.Addr().Zone() != netip.MustParseAddrPort(tt.src).Addr().Zone() {
+				t.Errorf("zone = %q; want %q", ipp.Addr().Zone(), netip.MustParseAddrPort(tt.src).Addr().Zone())
+			}
+		})
+	}
+}
+
+// TestProbeUPnP6 tests that a UPnP device that only answers discovery over
+// IPv6 is found and used for mappings.
+func TestProbeUPnP6(t *testing.T) {
+	// The IPv4 device doesn't answer UPnP discovery.
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(&upnpServer{
+		t:    t,
+		Desc: testRootDesc,
+		Control: map[string]map[string]any{
+			"/ctl/IPConn": {
+				"AddPortMapping":       testAddPortMappingResponse,
+				"GetExternalIPAddress": testGetExternalIPAddressResponse,
+				"GetStatusInfo":        testGetStatusInfoResponse,
+				"DeletePortMapping":    "", // Do nothing for test
+			},
+		},
+	})
+	ts.Listener.Close()
+	ts.Listener = ln
+	ts.Start()
+	defer ts.Close()
+
+	ssdp, err := net.ListenPacket("udp6", fmt.Sprintf("[::1]:%d", igd.TestUPnPPort()))
+	if err != nil {
+		t.Skipf("can't listen for SSDP on IPv6 loopback: %v", err)
+	}
+	defer ssdp.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, src, err := ssdp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if !bytes.Contains(buf[:n], []byte("ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1")) {
+				continue
+			}
+			res := fmt.Sprintf("HTTP/1.1 200 OK\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nUSN: uuid:test::urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nLOCATION: %s/rootDesc.xml\r\n\r\n", ts.URL)
+			ssdp.WriteTo([]byte(res), src)
+		}
+	}()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.debug.VerboseLogs = true
+
+	ctx := context.Background()
+	res, err := c.Probe(ctx)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if !res.UPnP {
+		t.Fatalf("didn't detect UPnP over IPv6")
+	}
+
+	gw, myIP, ok := c.gatewayAndSelfIP()
+	if !ok {
+		t.Fatalf("could not get gateway and self IP")
+	}
+	ext, err := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
+	if err != nil {
+		t.Fatalf("getUPnPPortMapping: %v", err)
+	}
+	if got, want := ext.Addr(), netip.MustParseAddr("123.123.123.123"); got != want {
+		t.Errorf("external address = %v; want %v", got, want)
+	}
+	if got := igd.stats().numUPnPDiscoRecv; got == 0 {
+		t.Errorf("IPv4 device got no discovery queries")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"math/rand"
+	"time"
+
+	"tailscale.com/tstime/rate"
+	"tailscale.com/util/clientmetric"
+)
+
+// Limits on multicast SSDP queries, which every device on the LAN answers.
+// The unicast query to the gateway isn't limited.
+const (
+	// ssdpMulticastInterval and ssdpMulticastBurst are the token bucket
+	// rate and size for multicast SSDP queries: after a burst of probes
+	// that send them, Probe sends them at most once per interval.
+	ssdpMulticastInterval = 10 * time.Second
+	ssdpMulticastBurst    = 3
+
+	// ssdpMaxJitter is the most that multicast SSDP queries are delayed
+	// by. It's well within Probe's 250ms timeout, so that answers to
+	// delayed queries still arrive in time.
+	ssdpMaxJitter = 50 * time.Millisecond
+)
+
+// metricUPnPMulticastLimited counts the number of times that Probe didn't
+// send multicast SSDP queries because it had sent too many recently.
+var metricUPnPMulticastLimited = clientmetric.NewCounter("portmap_upnp_multicast_limited")
+
+// newSSDPLimiter returns the rate limiter for a Client's multicast SSDP
+// queries.
+func newSSDPLimiter() *rate.Limiter {
+	return rate.NewLimiter(rate.Every(ssdpMulticastInterval), ssdpMulticastBurst)
+}
+
+// allowSSDPMulticast reports whether Probe may send SSDP queries to
+// multicast groups now, taking a token from c's limiter if so. Clients
+// without a limiter, like those that Diagnose uses, are never limited.
+func (c *Client) allowSSDPMulticast() bool {
+	if c.ssdpLimiter == nil || c.ssdpLimiter.Allow() {
+		return true
+	}
+	metricUPnPMulticastLimited.Add(1)
+	return false
+}
+
+// ssdpJitter returns a random delay in [0, ssdpMaxJitter) for multicast
+// SSDP queries.
+func ssdpJitter() time.Duration {
+	return time.Duration(rand.Int63n(int64(ssdpMaxJitter)))
+}
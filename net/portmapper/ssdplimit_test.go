@@ -0,0 +1,51 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSSDPMulticastLimit(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+
+	for i := range ssdpMulticastBurst {
+		if !c.allowSSDPMulticast() {
+			t.Fatalf("multicast %d of burst not allowed", i)
+		}
+	}
+	limited := metricUPnPMulticastLimited.Value()
+	if c.allowSSDPMulticast() {
+		t.Fatal("multicast allowed after burst")
+	}
+	if got := metricUPnPMulticastLimited.Value() - limited; got != 1 {
+		t.Errorf("limited metric grew by %d; want 1", got)
+	}
+
+	// The unicast query to the gateway still finds UPnP.
+	res, err := c.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.UPnP {
+		t.Errorf("UPnP not found while multicast limited; got %+v", res)
+	}
+
+	if !(&Client{}).allowSSDPMulticast() {
+		t.Error("Client without limiter was limited")
+	}
+	for range 100 {
+		if d := ssdpJitter(); d < 0 || d >= ssdpMaxJitter {
+			t.Fatalf("ssdpJitter = %v; want in [0, %v)", d, ssdpMaxJitter)
+		}
+	}
+}
@@ -13,6 +13,7 @@
 	"cmp"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -28,10 +29,8 @@
 	"github.com/tailscale/goupnp"
 	"github.com/tailscale/goupnp/dcps/internetgateway2"
 	"github.com/tailscale/goupnp/soap"
-	"tailscale.com/envknob"
 	"tailscale.com/net/netns"
 	"tailscale.com/types/logger"
-	"tailscale.com/util/mak"
 )
 
 // References:
@@ -46,6 +45,7 @@ type upnpMapping struct {
 	internal   netip.AddrPort
 	goodUntil  time.Time
 	renewAfter time.Time
+	proto      Protocol
 
 	// rootDev is the UPnP root device, and may be reused across different
 	// UPnP mappings.
@@ -56,17 +56,33 @@ type upnpMapping struct {
 	// to release an existing mapping; new mappings should be selected from
 	// the rootDev on each attempt.
 	client upnpClient
+	// verified is when the gateway last confirmed, with
+	// GetSpecificPortMappingEntry, that the previous mapping this one
+	// replaced still pointed at us. It's zero if it hasn't.
+	verified time.Time
 }
 
-// upnpProtocolUDP represents the protocol name for UDP, to be used in the UPnP
-// <AddPortMapping> message in the <NewProtocol> field.
+// upnpProtocolUDP and upnpProtocolTCP represent the protocol names for UDP
+// and TCP, to be used in the UPnP <AddPortMapping> message in the
+// <NewProtocol> field.
 //
-// NOTE: this must be an upper-case string, or certain routers will reject the
+// NOTE: these must be upper-case strings, or certain routers will reject the
 // mapping request. Other implementations like miniupnp send an upper-case
 // protocol as well. See:
 //
 //	https://github.com/tailscale/tailscale/issues/7377
-const upnpProtocolUDP = "UDP"
+const (
+	upnpProtocolUDP = "UDP"
+	upnpProtocolTCP = "TCP"
+)
+
+// upnpName returns the UPnP NewProtocol value for p.
+func (p Protocol) upnpName() string {
+	if p == TCP {
+		return upnpProtocolTCP
+	}
+	return upnpProtocolUDP
+}
 
 func (u *upnpMapping) MappingType() string      { return "upnp" }
 func (u *upnpMapping) GoodUntil() time.Time     { return u.goodUntil }
@@ -79,7 +95,7 @@ func (u *upnpMapping) MappingDebug() string {
 		u.loc)
 }
 func (u *upnpMapping) Release(ctx context.Context) {
-	u.client.DeletePortMapping(ctx, "", u.external.Port(), upnpProtocolUDP)
+	u.client.DeletePortMapping(ctx, "", u.external.Port(), u.proto.upnpName())
 }
 
 // upnpClient is an interface over the multiple different clients exported by goupnp,
@@ -122,8 +138,68 @@ type upnpClient interface {
 	DeletePortMapping(ctx context.Context, remoteHost string, externalPort uint16, protocol string) error
 	GetExternalIPAddress(ctx context.Context) (externalIPAddress string, err error)
 	GetStatusInfo(ctx context.Context) (status string, lastConnError string, uptime uint32, err error)
+
+	// GetSpecificPortMappingEntry returns where the gateway forwards
+	// externalPort to, or an error with code 714 (NoSuchEntryInArray) if
+	// it has no such mapping. leaseDurationSec is the time remaining on
+	// the mapping, or 0 if it's permanent.
+	GetSpecificPortMappingEntry(ctx context.Context, remoteHost string, externalPort uint16, protocol string) (internalPort uint16, internalClient string, enabled bool, description string, leaseDurationSec uint32, err error)
+}
+
+// UPnP error codes, from the WANIPConnection:2 spec:
+// http://upnp.org/specs/gw/UPnP-gw-WANIPConnection-v2-Service.pdf
+const (
+	upnpErrNoSuchEntryInArray           = 714
+	upnpErrConflictInMappingEntry       = 718
+	upnpErrOnlyPermanentLeasesSupported = 725
+)
+
+// UPnPError is an error response from a UPnP gateway, such as a refusal to
+// add a port mapping.
+type UPnPError struct {
+	// Code is the UPnP error code, such as 718 (ConflictInMappingEntry)
+	// or 725 (OnlyPermanentLeasesSupported).
+	Code int
+	// Description is the gateway's description of the error, if any.
+	Description string
+
+	err *soap.SOAPFaultError
 }
 
+func (e *UPnPError) Unwrap() error { return e.err }
+
+func (e *UPnPError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("UPnP error %d", e.Code)
+	}
+	return fmt.Sprintf("UPnP error %d: %s", e.Code, e.Description)
+}
+
+// parseUPnPError returns err as a *UPnPError if it's a SOAP fault with
+// UPnP error details, and otherwise returns err unchanged.
+func parseUPnPError(err error) error {
+	var (
+		upnpErr *UPnPError
+		soapErr *soap.SOAPFaultError
+	)
+	if errors.As(err, &upnpErr) || !errors.As(err, &soapErr) {
+		return err
+	}
+	var detail struct {
+		XMLName     xml.Name
+		Code        int    `xml:"errorCode"`
+		Description string `xml:"errorDescription"`
+	}
+	if xml.Unmarshal([]byte(soapErr.Detail.Raw), &detail) != nil || detail.XMLName.Local != "UPnPError" {
+		return err
+	}
+	return &UPnPError{Code: detail.Code, Description: detail.Description, err: soapErr}
+}
+
+// maxUPnPConflictRetries is how many other external ports addAnyPortMapping
+// tries with AddPortMapping if the one it asked for is already mapped.
+const maxUPnPConflictRetries = 3
+
 // tsPortMappingDesc gets sent to UPnP clients as a human-readable label for the portmapping.
 // It is not used for anything other than labelling.
 const tsPortMappingDesc = "tailscale-portmap"
@@ -131,15 +207,18 @@ type upnpClient interface {
 // addAnyPortMapping abstracts over different UPnP client connections, calling
 // the available AddAnyPortMapping call if available for WAN IP connection v2,
 // otherwise picking either the previous port (if one is present) or a random
-// port and trying to obtain a mapping using AddPortMapping.
+// port and trying to obtain a mapping using AddPortMapping. If AddPortMapping
+// reports that the port is already mapped (to another client or protocol),
+// it tries up to maxUPnPConflictRetries other random ports.
 //
 // It returns the new external port (which may not be identical to the external
-// port specified), or an error.
+// port specified), or an error, which is a *UPnPError if the gateway refused.
 //
 // TODO(bradfitz): also returned the actual lease duration obtained. and check it regularly.
 func addAnyPortMapping(
 	ctx context.Context,
 	upnp upnpClient,
+	proto Protocol,
 	externalPort uint16,
 	internalPort uint16,
 	internalClient string,
@@ -160,44 +239,61 @@ func addAnyPortMapping(
 	// We obviously do not want to open all ports on the user's device to
 	// the internet, so we want to do this prior to calling either
 	// AddAnyPortMapping or AddPortMapping.
-	//
-	// Pick an external port that's greater than 1024 by getting a random
-	// number in [0, 65535 - 1024] and then adding 1024 to it, shifting the
-	// range to [1024, 65535].
 	if externalPort < 1024 {
-		externalPort = uint16(rand.Intn(65535-1024) + 1024)
+		externalPort = randomUPnPExternalPort()
 	}
 
 	// First off, try using AddAnyPortMapping; if there's a conflict, the
 	// router will pick another port and return it.
 	if upnp, ok := upnp.(*internetgateway2.WANIPConnection2); ok {
-		return upnp.AddAnyPortMapping(
+		newPort, err = upnp.AddAnyPortMapping(
 			ctx,
 			"",
 			externalPort,
-			upnpProtocolUDP,
+			proto.upnpName(),
 			internalPort,
 			internalClient,
 			true,
 			tsPortMappingDesc,
 			uint32(leaseDuration.Seconds()),
 		)
+		return newPort, parseUPnPError(err)
 	}
 
 	// Fall back to using AddPortMapping, which requests a mapping to/from
 	// a specific external port.
-	err = upnp.AddPortMapping(
-		ctx,
-		"",
-		externalPort,
-		upnpProtocolUDP,
-		internalPort,
-		internalClient,
-		true,
-		tsPortMappingDesc,
-		uint32(leaseDuration.Seconds()),
-	)
-	return externalPort, err
+	for attempt := 0; ; attempt++ {
+		err = upnp.AddPortMapping(
+			ctx,
+			"",
+			externalPort,
+			proto.upnpName(),
+			internalPort,
+			internalClient,
+			true,
+			tsPortMappingDesc,
+			uint32(leaseDuration.Seconds()),
+		)
+		err = parseUPnPError(err)
+		code, ok := getUPnPErrorCode(err)
+		if !ok || code != upnpErrConflictInMappingEntry || attempt == maxUPnPConflictRetries || ctx.Err() != nil {
+			return externalPort, err
+		}
+		// Someone else has this port; unlike AddAnyPortMapping, the
+		// router won't pick another for us, so pick one ourselves.
+		getUPnPErrorsMetric(code).Add(1)
+		metricUPnPConflictRetries.Add(1)
+		externalPort = randomUPnPExternalPort()
+	}
+}
+
+// randomUPnPExternalPort returns a random unprivileged port to request as
+// the external port of a UPnP mapping.
+func randomUPnPExternalPort() uint16 {
+	// Pick an external port that's greater than 1024 by getting a random
+	// number in [0, 65535 - 1024] and then adding 1024 to it, shifting the
+	// range to [1024, 65535].
+	return uint16(rand.Intn(65535-1024) + 1024)
 }
 
 // getUPnPRootDevice fetches the UPnP root device given the discovery response,
@@ -220,16 +316,11 @@ func getUPnPRootDevice(ctx context.Context, logf logger.Logf, debug DebugKnobs,
 	if debug.VerboseLogs {
 		logf("fetching %v", meta.Location)
 	}
-	u, err := url.Parse(meta.Location)
+	u, ipp, err := parseUPnPLocation(meta.Location)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	ipp, err := netip.ParseAddrPort(u.Host)
-	if err != nil {
-		return nil, nil, fmt.Errorf("unexpected host %q in %q", u.Host, meta.Location)
-	}
-	if ipp.Addr() != gw {
+	if ipp.Addr() != gw && ipp.Addr().Is4() {
 		// https://github.com/tailscale/tailscale/issues/5502
 		logf("UPnP discovered root %q does not match gateway IP %v; repointing at gateway which is assumed to be floating",
 			meta.Location, gw)
@@ -248,18 +339,77 @@ func getUPnPRootDevice(ctx context.Context, logf logger.Logf, debug DebugKnobs,
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := checkUPnPRootDevice(root, ipp.Addr(), gw); err != nil {
+		return nil, nil, err
+	}
 	return root, u, nil
 }
 
+// upnpServiceRank is how useful a UPnP service looks for creating port
+// mappings; higher is better.
+type upnpServiceRank int
+
+const (
+	// upnpRankDown means the service isn't connected, or didn't say.
+	upnpRankDown upnpServiceRank = iota
+	// upnpRankUp means the service is connected but didn't give a
+	// usable external IP address, e.g. it said 0.0.0.0.
+	upnpRankUp
+	// upnpRankPrivate means the service is connected but its external
+	// IP address is private or CGNAT, so there's another NAT beyond it.
+	upnpRankPrivate
+	// upnpRankPublic means the service is connected and has a public
+	// external IP address.
+	upnpRankPublic
+)
+
+func (r upnpServiceRank) String() string {
+	switch r {
+	case upnpRankDown:
+		return "down"
+	case upnpRankUp:
+		return "up"
+	case upnpRankPrivate:
+		return "private"
+	case upnpRankPublic:
+		return "public"
+	}
+	return fmt.Sprintf("upnpServiceRank(%d)", int(r))
+}
+
+// rankUPnPService returns how useful svc looks for creating port mappings.
+// It makes network requests.
+func rankUPnPService(ctx context.Context, logf logger.Logf, svc upnpClient) upnpServiceRank {
+	// Don't bother checking for an external IP if the device isn't
+	// connected; technically this could happen with a misbehaving
+	// device, but that seems unlikely.
+	if !serviceIsConnected(ctx, logf, svc) {
+		return upnpRankDown
+	}
+	extIP, err := svc.GetExternalIPAddress(ctx)
+	if err != nil {
+		return upnpRankUp
+	}
+	ip, err := netip.ParseAddr(extIP)
+	if err != nil || !ip.IsGlobalUnicast() {
+		return upnpRankUp
+	}
+	if ip.IsPrivate() || isDoubleNATAddr(ip) {
+		return upnpRankPrivate
+	}
+	return upnpRankPublic
+}
+
 // selectBestService picks the "best" service from the given UPnP root device
-// to use to create a port mapping. It may return (nil, nil) if no supported
-// service was found in the provided *goupnp.RootDevice.
+// to use to create a port mapping, and returns how useful it looks. It may
+// return a nil client if no supported service was found in the provided
+// *goupnp.RootDevice.
 //
 // loc is the parsed location that was used to fetch the given RootDevice.
 //
 // The provided ctx is not retained in the returned upnpClient, but
 // its associated HTTP client is (if set via goupnp.WithHTTPClient).
-func selectBestService(ctx context.Context, logf logger.Logf, root *goupnp.RootDevice, loc *url.URL) (client upnpClient, err error) {
+func selectBestService(ctx context.Context, logf logger.Logf, root *goupnp.RootDevice, loc *url.URL) (client upnpClient, rank upnpServiceRank, err error) {
 	method := "none"
 	defer func() {
 		if client == nil {
@@ -303,97 +453,53 @@ func selectBestService(ctx context.Context, logf logger.Logf, root *goupnp.RootD
 	}
 
 	// If we have no clients, then return right now; if we only have one,
-	// just select and return it.
+	// just select it.
 	if len(clients) == 0 {
-		return nil, nil
+		return nil, upnpRankDown, nil
 	}
 	if len(clients) == 1 {
 		method = "single"
 		metricUPnPSelectSingle.Add(1)
-		return clients[0], nil
+		return clients[0], rankUPnPService(ctx, logf, clients[0]), nil
 	}
 
 	metricUPnPSelectMultiple.Add(1)
 
 	// In order to maximize the chances that we find a valid UPnP device
-	// that can give us a port mapping, we check a few properties:
-	//	1. Whether the device is "online", as defined by GetStatusInfo
-	//	2. Whether the device has an external IP address, as defined by
-	//	   GetExternalIPAddress
-	//	3. Whether the device's external IP address is a public address
-	//	   or a private one.
-	//
-	// We prefer a device where all of the above is true, and fall back if
-	// none are found.
+	// that can give us a port mapping, we rank each service by whether
+	// it's "online", as defined by GetStatusInfo, and whether it has a
+	// valid external IP address, as defined by GetExternalIPAddress, that
+	// is public rather than private.
 	//
-	// In order to save on network requests, iterate through all devices
-	// and determine how many "points" they have based on the above
-	// criteria, but return immediately if we find one that meets all
-	// three.
-	var (
-		connected   = make(map[upnpClient]bool)
-		externalIPs map[upnpClient]netip.Addr
-	)
+	// We pick the first service with the best rank, so ties go to the
+	// order above, and in order to save on network requests we stop at
+	// the first one that has a public external IP.
+	best, bestRank := clients[0], upnpRankDown
 	for _, svc := range clients {
-		isConnected := serviceIsConnected(ctx, logf, svc)
-		connected[svc] = isConnected
-
-		// Don't bother checking for an external IP if the device isn't
-		// connected; technically this could happen with a misbehaving
-		// device, but that seems unlikely.
-		if !isConnected {
-			continue
+		rank := rankUPnPService(ctx, logf, svc)
+		if rank > bestRank {
+			best, bestRank = svc, rank
 		}
-
-		// Check if the device has an external IP address.
-		extIP, err := svc.GetExternalIPAddress(ctx)
-		if err != nil {
-			continue
-		}
-		externalIP, err := netip.ParseAddr(extIP)
-		if err != nil {
-			continue
-		}
-		mak.Set(&externalIPs, svc, externalIP)
-
-		// If we get here, this device has a non-private external IP
-		// and is up, so we can just return it.
-		if !externalIP.IsPrivate() {
-			method = "ext-public"
-			metricUPnPSelectExternalPublic.Add(1)
-			return svc, nil
+		if rank == upnpRankPublic {
+			break
 		}
 	}
-
-	// Okay, we have no devices that meet all the available options. Fall
-	// back to first checking for devices that are up and have a private
-	// external IP (order matters), and then devices that are up, and then
-	// just anything at all.
-	//
-	//	try=0	Up + private external IP
-	//	try=1	Up
-	for try := 0; try <= 1; try++ {
-		for _, svc := range clients {
-			if !connected[svc] {
-				continue
-			}
-			_, hasExtIP := externalIPs[svc]
-			if hasExtIP {
-				method = "ext-private"
-				metricUPnPSelectExternalPrivate.Add(1)
-				return svc, nil
-			} else if try == 1 {
-				method = "up"
-				metricUPnPSelectUp.Add(1)
-				return svc, nil
-			}
-		}
+	switch bestRank {
+	case upnpRankPublic:
+		method = "ext-public"
+		metricUPnPSelectExternalPublic.Add(1)
+	case upnpRankPrivate:
+		method = "ext-private"
+		metricUPnPSelectExternalPrivate.Add(1)
+	case upnpRankUp:
+		method = "up"
+		metricUPnPSelectUp.Add(1)
+	default:
+		// Nothing is up, but we have something; just return the
+		// first one.
+		metricUPnPSelectNone.Add(1)
 	}
-
-	// Nothing is up, but we have something (length of clients checked
-	// above); just return the first one.
-	metricUPnPSelectNone.Add(1)
-	return clients[0], nil
+	return best, bestRank, nil
 }
 
 // serviceIsConnected returns whether a given UPnP service is connected, based
@@ -406,13 +512,56 @@ func serviceIsConnected(ctx context.Context, logf logger.Logf, svc upnpClient) b
 	return status == "Connected" || status == "Up"
 }
 
+// upnpLivenessTimeout is how long checkUPnPClientAlive waits for a UPnP
+// service to answer.
+const upnpLivenessTimeout = time.Second
+
+// checkUPnPClientAlive checks that svc, a service that we used before,
+// still answers requests at its control URL. A router that rebooted might
+// have moved it, and then every request to it fails or times out.
+//
+// Any SOAP response, including a fault, means that the service is there.
+func checkUPnPClientAlive(ctx context.Context, svc upnpClient) error {
+	ctx, cancel := context.WithTimeout(ctx, upnpLivenessTimeout)
+	defer cancel()
+	_, _, _, err := svc.GetStatusInfo(ctx)
+	var se *soap.SOAPFaultError
+	if err != nil && !errors.As(err, &se) {
+		return err
+	}
+	return nil
+}
+
+// rediscoverUPnP forgets everything that we know about UPnP devices and
+// probes for them again, returning the new discovery responses.
+func (c *Client) rediscoverUPnP(ctx context.Context) []uPnPDiscoResponse {
+	c.mu.Lock()
+	c.uPnPCache = nil
+	c.uPnPSawTime = time.Time{}
+	c.uPnPMetas = nil
+	c.mu.Unlock()
+
+	if _, err := c.Probe(ctx); err != nil {
+		c.vlogf("UPnP rediscovery probe: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.uPnPMetas
+}
+
 func (c *Client) upnpHTTPClientLocked() *http.Client {
 	if c.uPnPHTTPClient == nil {
 		c.uPnPHTTPClient = &http.Client{
-			Transport: &http.Transport{
-				DialContext:     netns.NewDialer(c.logf, c.netMon).DialContext,
-				IdleConnTimeout: 2 * time.Second, // LAN is cheap
+			Transport: limitedBodyTransport{
+				rt: &http.Transport{
+					DialContext:            netns.NewDialer(c.logf, c.netMon).DialContext,
+					IdleConnTimeout:        2 * time.Second, // LAN is cheap
+					MaxResponseHeaderBytes: maxUPnPResponseHeaderSize,
+				},
+				max: maxUPnPResponseSize,
 			},
+			Timeout: upnpHTTPTimeout,
 		}
 		if c.debug.LogHTTP {
 			c.uPnPHTTPClient = requestLogger(c.logf, c.uPnPHTTPClient)
@@ -421,10 +570,6 @@ func (c *Client) upnpHTTPClientLocked() *http.Client {
 	return c.uPnPHTTPClient
 }
 
-var (
-	disableUPnpEnv = envknob.RegisterBool("TS_DISABLE_UPNP")
-)
-
 // getUPnPPortMapping attempts to create a port-mapping over the UPnP protocol. On success,
 // it will return the externally exposed IP and port. Otherwise, it will return a zeroed IP and
 // port and an error.
@@ -433,15 +578,48 @@ func (c *Client) getUPnPPortMapping(
 	gw netip.Addr,
 	internal netip.AddrPort,
 	prevPort uint16,
-) (external netip.AddrPort, ok bool) {
-	if disableUPnpEnv() || c.debug.DisableUPnP || (c.controlKnobs != nil && c.controlKnobs.DisableUPnP.Load()) {
-		return netip.AddrPort{}, false
+) (external netip.AddrPort, err error) {
+	if c.disabledServices().UPnP {
+		return netip.AddrPort{}, ErrNoPortMappingServices
+	}
+	upnp, err := c.createUPnPMapping(ctx, gw, internal, prevPort)
+	if err != nil {
+		return netip.AddrPort{}, err
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setMappingLocked(upnp)
+	return upnp.external, nil
+}
 
+// upnpMappingAttempt returns a mappingAttempt that creates a mapping with
+// createUPnPMapping.
+func (c *Client) upnpMappingAttempt(gw netip.Addr, internal netip.AddrPort, prevPort uint16) mappingAttempt {
+	return mappingAttempt{
+		name: "upnp",
+		create: func(ctx context.Context) (mapping, error) {
+			m, err := c.createUPnPMapping(ctx, gw, internal, prevPort)
+			if err != nil {
+				return nil, NoMappingError{err}
+			}
+			return m, nil
+		},
+	}
+}
+
+// createUPnPMapping creates a port mapping over UPnP, without making it
+// c's mapping.
+func (c *Client) createUPnPMapping(
+	ctx context.Context,
+	gw netip.Addr,
+	internal netip.AddrPort,
+	prevPort uint16,
+) (_ *upnpMapping, err error) {
 	now := time.Now()
 	upnp := &upnpMapping{
 		gw:       gw,
 		internal: internal,
+		proto:    c.protocol,
 	}
 
 	// We can have multiple UPnP "meta" values (which correspond to the
@@ -457,31 +635,70 @@ func (c *Client) getUPnPPortMapping(
 	ctx = goupnp.WithHTTPClient(ctx, c.upnpHTTPClientLocked())
 	c.mu.Unlock()
 
+	// If the existing mapping's service no longer answers, the router
+	// probably rebooted; its root device and the discovery responses that
+	// led to it may be stale too. Rather than spend the rest of this
+	// attempt timing out on them, find the devices again.
+	haveOldMapping := ok && oldMapping != nil
+	if haveOldMapping && oldMapping.client != nil {
+		if err := checkUPnPClientAlive(ctx, oldMapping.client); err != nil {
+			c.logf("UPnP service at %v stopped answering (%v); rediscovering", oldMapping.loc, err)
+			metricUPnPStaleClient.Add(1)
+			haveOldMapping = false
+			metas = c.rediscoverUPnP(ctx)
+		}
+	}
+
 	// Wrapper for a uPnPDiscoResponse with an optional existing root
 	// device + URL (if we've got a previous cached mapping).
 	type step struct {
 		rootDev *goupnp.RootDevice // if nil, use 'meta'
 		loc     *url.URL           // non-nil if rootDev is non-nil
 		meta    uPnPDiscoResponse
+		key     upnpDeviceKey // of meta's device; see upnpbackoff.go
 	}
 	var steps []step
 
 	// Now, if we have an existing mapping, swap that mapping's entry to
 	// the first entry in our "metas" list so we try it first.
-	haveOldMapping := ok && oldMapping != nil
 	if haveOldMapping && oldMapping.rootDev != nil {
 		steps = append(steps, step{rootDev: oldMapping.rootDev, loc: oldMapping.loc})
 	}
 	// Note: this includes the meta for a previously-cached mapping, in
 	// case the rootDev changes.
 	for _, meta := range metas {
-		steps = append(steps, step{meta: meta})
+		key := upnpDeviceKeyFor(gw, meta)
+		if retryAt, ok := c.upnpRetryAt(key, now); ok {
+			metricUPnPBackoffSkipped.Add(1)
+			c.vlogf("skipping UPnP device %v, which keeps failing, until %v", key, retryAt.Format(time.RFC3339))
+			continue
+		}
+		steps = append(steps, step{meta: meta, key: key})
+	}
+	// failed records that the device of s failed with err, unless it
+	// was cut short.
+	failed := func(s step, err error) {
+		if s.rootDev == nil && ctx.Err() == nil {
+			c.noteUPnPDeviceFailed(s.key, err)
+		}
 	}
 
-	// Now, iterate through every meta that we have trying to get an
-	// external IP address. If we succeed, we'll return; if we fail, we
-	// continue this loop.
-	var errs []error
+	// Now, fetch the root device for every step and pick the best service
+	// from each, so that we can try the most useful services first rather
+	// than whichever device answered discovery first; a device that's
+	// connected to another internal network can give us a mapping that
+	// doesn't help.
+	type candidate struct {
+		step    step
+		rootDev *goupnp.RootDevice
+		loc     *url.URL
+		client  upnpClient
+		rank    upnpServiceRank
+	}
+	var (
+		cands []candidate
+		errs  []error
+	)
 	for _, step := range steps {
 		var (
 			rootDev *goupnp.RootDevice
@@ -492,9 +709,10 @@ type step struct {
 			rootDev = step.rootDev
 			loc = step.loc
 		} else {
-			rootDev, loc, err = getUPnPRootDevice(ctx, c.logf, c.debug, gw, step.meta)
+			rootDev, loc, err = c.getUPnPRootDeviceCached(ctx, gw, step.meta)
 			c.vlogf("getUPnPRootDevice: loc=%q err=%v", loc, err)
 			if err != nil {
+				failed(step, err)
 				errs = append(errs, err)
 				continue
 			}
@@ -503,99 +721,158 @@ type step struct {
 			continue
 		}
 
-		// This actually performs the port mapping operation using this
-		// root device.
-		//
-		// TODO(andrew-d): this can successfully perform a portmap and
-		// return an externalAddrPort that refers to a non-public IP
-		// address if the first selected RootDevice is a device that is
-		// connected to another internal network. This is still better
-		// than randomly flapping between multiple devices, but we
-		// should probably split this up further to try the best
-		// service (one with an external IP) first, instead of
-		// iterating by device.
-		//
-		// This is probably sufficiently unlikely that I'm leaving that
-		// as a follow-up task if it's necessary.
-		externalAddrPort, client, err := c.tryUPnPPortmapWithDevice(ctx, internal, prevPort, rootDev, loc)
+		// Select the best mapping service from the given root device.
+		// This makes network requests, and can vary from mapping to
+		// mapping if the upstream device's connection status changes.
+		client, rank, err := selectBestService(ctx, c.logf, rootDev, loc)
+		if err == nil && client == nil {
+			// We got a valid UPnP response that doesn't contain
+			// any of the service types that we know how to use.
+			// For debugging, print all available services that we
+			// aren't using because they're not supported; use
+			// c.vlogf so we don't spam the logs unless verbose
+			// debugging is turned on.
+			rootDev.Device.VisitServices(func(s *goupnp.Service) {
+				c.vlogf("unsupported UPnP service: Type=%q ID=%q ControlURL=%q", s.ServiceType, s.ServiceId, s.ControlURL.Str)
+			})
+			err = errors.New("no supported UPnP clients")
+		}
+		if err != nil {
+			if step.rootDev == nil {
+				c.forgetUPnPRootDevice(gw, step.meta.Location)
+			}
+			failed(step, err)
+			errs = append(errs, err)
+			continue
+		}
+		cands = append(cands, candidate{step, rootDev, loc, client, rank})
+	}
+
+	// Try the best ranked services first. The sort is stable, so between
+	// equally good services we still prefer the existing mapping's device
+	// and then discovery order.
+	slices.SortStableFunc(cands, func(a, b candidate) int {
+		return cmp.Compare(b.rank, a.rank)
+	})
+	if len(cands) > 1 {
+		c.vlogf("UPnP: %d candidate services; best at %v is %v", len(cands), cands[0].loc, cands[0].rank)
+	}
+
+	for _, cand := range cands {
+		// This actually performs the port mapping operation using
+		// this service.
+		externalAddrPort, verified, err := c.tryUPnPPortmapWithClient(ctx, gw, internal, prevPort, cand.client)
 		if err != nil {
+			if cand.step.rootDev == nil {
+				c.forgetUPnPRootDevice(gw, cand.step.meta.Location)
+			}
+			failed(cand.step, err)
 			errs = append(errs, err)
 			continue
 		}
+		if cand.step.rootDev == nil {
+			c.noteUPnPDeviceWorked(cand.step.key)
+		}
 
-		// If we get here, we're successful; we can cache this mapping,
-		// update our local port, and then return.
+		// If we get here, we're successful.
 		//
 		// NOTE: this time might not technically be accurate if we created a
 		// permanent lease above, but we should still re-check the presence of
 		// the lease on a regular basis so we use it anyway.
-		d := time.Duration(pmpMapLifetimeSec) * time.Second
+		d := c.leaseDuration()
 		upnp.goodUntil = now.Add(d)
 		upnp.renewAfter = now.Add(d / 2)
 		upnp.external = externalAddrPort
-		upnp.rootDev = rootDev
-		upnp.loc = loc
-		upnp.client = client
-
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		c.mapping = upnp
-		c.localPort = externalAddrPort.Port()
-		return upnp.external, true
+		upnp.rootDev = cand.rootDev
+		upnp.loc = cand.loc
+		upnp.client = cand.client
+		upnp.verified = verified
+		return upnp, nil
 	}
 
 	// If we get here, we didn't get anything.
-	// TODO(andrew-d): use or log errs?
-	_ = errs
-	return netip.AddrPort{}, false
+	if len(errs) == 0 {
+		return nil, ErrNoPortMappingServices
+	}
+	err = parseUPnPError(errs[len(errs)-1])
+	if ctx.Err() != context.Canceled {
+		metricsUPnP.noteFailed(classifyUPnPError(err))
+	}
+	return nil, err
 }
 
-// tryUPnPPortmapWithDevice attempts to perform a port forward from the given
-// UPnP device to the 'internal' address. It tries to re-use the previous port,
-// if a non-zero value is provided, and handles retries and errors about
-// unsupported features.
+// classifyUPnPError returns the failureClass of err, an error from
+// obtaining a UPnP mapping.
+func classifyUPnPError(err error) failureClass {
+	var se *soap.SOAPFaultError
+	if errors.As(err, &se) {
+		return failRefused
+	}
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return failTimeout
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return failNetwork
+	}
+	return failInvalid
+}
+
+// tryUPnPPortmapWithClient attempts to perform a port forward using the
+// given UPnP service, as picked by selectBestService, to the 'internal'
+// address. It tries to re-use the previous port, if a non-zero value is
+// provided, and handles retries and errors about unsupported features.
+//
+// Before re-using the previous port, it asks the gateway whether that port
+// is still mapped to us, since some routers silently drop mappings: a
+// permanent mapping that still is is re-used as-is, and if another client
+// has taken the port, we ask for a new one rather than take it back.
 //
 // It returns the external address and port that was mapped (i.e. the
 // address+port that another Tailscale node can use to make a connection to
-// this one) and the UPnP client that was used to obtain that mapping.
-func (c *Client) tryUPnPPortmapWithDevice(
+// this one), and when the gateway confirmed the previous mapping, if it
+// did.
+func (c *Client) tryUPnPPortmapWithClient(
 	ctx context.Context,
+	gw netip.Addr,
 	internal netip.AddrPort,
 	prevPort uint16,
-	rootDev *goupnp.RootDevice,
-	loc *url.URL,
-) (netip.AddrPort, upnpClient, error) {
-	// Select the best mapping service from the given root device. This
-	// makes network requests, and can vary from mapping to mapping if the
-	// upstream device's connection status changes.
-	client, err := selectBestService(ctx, c.logf, rootDev, loc)
-	if err != nil {
-		return netip.AddrPort{}, nil, err
-	}
-
-	// If we have no client, we cannot continue; this can happen if we get
-	// a valid UPnP response that does not contain any of the service types
-	// that we know how to use.
-	if client == nil {
-		// For debugging, print all available services that we aren't
-		// using because they're not supported; use c.vlogf so we don't
-		// spam the logs unless verbose debugging is turned on.
-		rootDev.Device.VisitServices(func(s *goupnp.Service) {
-			c.vlogf("unsupported UPnP service: Type=%q ID=%q ControlURL=%q", s.ServiceType, s.ServiceId, s.ControlURL.Str)
-		})
-
-		return netip.AddrPort{}, nil, fmt.Errorf("no supported UPnP clients")
+	client upnpClient,
+) (_ netip.AddrPort, verified time.Time, _ error) {
+	if prevPort != 0 {
+		switch st := c.checkUPnPEntry(ctx, client, prevPort, internal); st {
+		case upnpEntryPermanent:
+			verified = time.Now()
+			externalIP, err := c.upnpExternalIP(ctx, gw, client)
+			if err != nil {
+				return netip.AddrPort{}, time.Time{}, err
+			}
+			return netip.AddrPortFrom(externalIP, prevPort), verified, nil
+		case upnpEntryLeased:
+			// Add it again to extend the lease.
+			verified = time.Now()
+		case upnpEntryMissing:
+			metricUPnPEntryMissing.Add(1)
+			c.logf("UPnP mapping of port %d disappeared from gateway %v; recreating it", prevPort, gw)
+			c.noteEvent(Event{What: "entry-missing", Gateway: gw, Type: "upnp", Detail: fmt.Sprintf("external port %d", prevPort)})
+		case upnpEntryTaken:
+			metricUPnPEntryTaken.Add(1)
+			c.logf("UPnP port %d on gateway %v is now mapped to another client; requesting another port", prevPort, gw)
+			c.noteEvent(Event{What: "entry-taken", Gateway: gw, Type: "upnp", Detail: fmt.Sprintf("external port %d", prevPort)})
+			prevPort = 0
+		}
 	}
 
 	// Start by trying to make a temporary lease with a duration.
-	var newPort uint16
-	newPort, err = addAnyPortMapping(
+	newPort, err := addAnyPortMapping(
 		ctx,
 		client,
+		c.protocol,
 		prevPort,
 		internal.Port(),
 		internal.Addr().String(),
-		pmpMapLifetimeSec*time.Second,
+		c.leaseDuration(),
 	)
 	c.vlogf("addAnyPortMapping: %v, err=%q", newPort, err)
 
@@ -609,12 +886,11 @@ func (c *Client) tryUPnPPortmapWithDevice(
 			getUPnPErrorsMetric(code).Add(1)
 		}
 
-		// From the UPnP spec: http://upnp.org/specs/gw/UPnP-gw-WANIPConnection-v2-Service.pdf
-		//     725: OnlyPermanentLeasesSupported
-		if ok && code == 725 {
+		if ok && code == upnpErrOnlyPermanentLeasesSupported {
 			newPort, err = addAnyPortMapping(
 				ctx,
 				client,
+				c.protocol,
 				prevPort,
 				internal.Port(),
 				internal.Addr().String(),
@@ -624,21 +900,62 @@ func (c *Client) tryUPnPPortmapWithDevice(
 		}
 	}
 	if err != nil {
-		return netip.AddrPort{}, nil, err
+		return netip.AddrPort{}, time.Time{}, err
 	}
 
-	// TODO cache this ip somewhere?
-	extIP, err := client.GetExternalIPAddress(ctx)
-	c.vlogf("client.GetExternalIPAddress: %v, %v", extIP, err)
+	externalIP, err := c.upnpExternalIP(ctx, gw, client)
 	if err != nil {
-		return netip.AddrPort{}, nil, err
+		return netip.AddrPort{}, time.Time{}, err
 	}
-	externalIP, err := netip.ParseAddr(extIP)
+
+	return netip.AddrPortFrom(externalIP, newPort), verified, nil
+}
+
+// upnpEntryState is what a gateway says about a UPnP mapping we made
+// earlier, as returned by checkUPnPEntry.
+type upnpEntryState int
+
+const (
+	upnpEntryUnknown   upnpEntryState = iota // the gateway couldn't tell us
+	upnpEntryPermanent                       // mapped to us, permanently
+	upnpEntryLeased                          // mapped to us, with a lease
+	upnpEntryMissing                         // not mapped, or disabled
+	upnpEntryTaken                           // mapped to another client or port
+)
+
+// checkUPnPEntry asks client whether externalPort is still mapped to
+// internal.
+func (c *Client) checkUPnPEntry(ctx context.Context, client upnpClient, externalPort uint16, internal netip.AddrPort) upnpEntryState {
+	port, host, enabled, _, lease, err := client.GetSpecificPortMappingEntry(ctx, "", externalPort, c.protocol.upnpName())
+	c.vlogf("GetSpecificPortMappingEntry(%d): %v, %q, enabled=%v, lease=%v, err=%v", externalPort, port, host, enabled, lease, err)
 	if err != nil {
-		return netip.AddrPort{}, nil, err
+		if code, ok := getUPnPErrorCode(err); ok && code == upnpErrNoSuchEntryInArray {
+			return upnpEntryMissing
+		}
+		// Not all gateways implement GetSpecificPortMappingEntry; go
+		// on as if we hadn't asked.
+		return upnpEntryUnknown
 	}
+	ip, err := netip.ParseAddr(strings.TrimSpace(host))
+	if err != nil || ip != internal.Addr() || port != internal.Port() {
+		return upnpEntryTaken
+	}
+	if !enabled {
+		return upnpEntryMissing
+	}
+	if lease == 0 {
+		return upnpEntryPermanent
+	}
+	return upnpEntryLeased
+}
 
-	return netip.AddrPortFrom(externalIP, newPort), client, nil
+// upnpVerifiedAt returns when the gateway last confirmed that m, if it's
+// a UPnP mapping, still pointed at us.
+func upnpVerifiedAt(m mapping) time.Time {
+	if u, ok := m.(*upnpMapping); ok {
+		return u.verified
+	}
+	return time.Time{}
 }
 
 // processUPnPResponses sorts and deduplicates a list of UPnP discovery
@@ -680,23 +997,11 @@ func processUPnPResponses(metas []uPnPDiscoResponse) []uPnPDiscoResponse {
 }
 
 // getUPnPErrorCode returns the UPnP error code from the given response, if the
-// error is a SOAP error in the proper format, and a boolean indicating whether
-// the provided error was actually a UPnP error.
+// error is a *UPnPError or a SOAP error in the proper format, and a boolean
+// indicating whether the provided error was actually a UPnP error.
 func getUPnPErrorCode(err error) (int, bool) {
-	soapErr, ok := err.(*soap.SOAPFaultError)
-	if !ok {
-		return 0, false
-	}
-
-	var upnpErr struct {
-		XMLName     xml.Name
-		Code        int    `xml:"errorCode"`
-		Description string `xml:"errorDescription"`
-	}
-	if err := xml.Unmarshal([]byte(soapErr.Detail.Raw), &upnpErr); err != nil {
-		return 0, false
-	}
-	if upnpErr.XMLName.Local != "UPnPError" {
+	var upnpErr *UPnPError
+	if !errors.As(parseUPnPError(err), &upnpErr) {
 		return 0, false
 	}
 	return upnpErr.Code, true
@@ -721,6 +1026,11 @@ func parseUPnPDiscoResponse(body []byte) (uPnPDiscoResponse, error) {
 	r.Location = res.Header.Get("Location")
 	r.Server = res.Header.Get("Server")
 	r.USN = res.Header.Get("Usn")
+	if r.Location != "" {
+		if _, _, err := parseUPnPLocation(r.Location); err != nil {
+			return uPnPDiscoResponse{}, err
+		}
+	}
 	return r, nil
 }
 
@@ -6,6 +6,7 @@
 import (
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -15,10 +16,14 @@
 	"reflect"
 	"regexp"
 	"slices"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"testing"
 
+	"github.com/tailscale/goupnp/soap"
 	"tailscale.com/tstest"
+	"tailscale.com/util/clientmetric"
 )
 
 // Google Wifi
@@ -516,7 +521,7 @@ func TestGetUPnPClient(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			c, err := selectBestService(ctx, logBuf.Logf, dev, loc)
+			c, _, err := selectBestService(ctx, logBuf.Logf, dev, loc)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -568,9 +573,10 @@ func TestGetUPnPPortMapping(t *testing.T) {
 			// Success!
 			return http.StatusOK, testAddPortMappingResponse
 		},
-		"GetExternalIPAddress": testGetExternalIPAddressResponse,
-		"GetStatusInfo":        testGetStatusInfoResponse,
-		"DeletePortMapping":    "", // Do nothing for test
+		"GetExternalIPAddress":        testGetExternalIPAddressResponse,
+		"GetStatusInfo":               testGetStatusInfoResponse,
+		"DeletePortMapping":           "", // Do nothing for test
+		"GetSpecificPortMappingEntry": testGetSpecificPortMappingEntryNoSuchEntry,
 	}
 
 	ctx := context.Background()
@@ -613,9 +619,9 @@ func TestGetUPnPPortMapping(t *testing.T) {
 			}
 			t.Logf("gw=%v myIP=%v", gw, myIP)
 
-			ext, ok := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), prevPort)
-			if !ok {
-				t.Fatal("could not get UPnP port mapping")
+			ext, err := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), prevPort)
+			if err != nil {
+				t.Fatalf("could not get UPnP port mapping: %v", err)
 			}
 			if got, want := ext.Addr(), netip.MustParseAddr("123.123.123.123"); got != want {
 				t.Errorf("bad external address; got %v want %v", got, want)
@@ -670,12 +676,140 @@ func TestGetUPnPPortMapping_NoValidServices(t *testing.T) {
 	}
 
 	// This shouldn't panic
-	_, ok = c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
-	if ok {
+	if _, err := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0); err == nil {
 		t.Fatal("did not expect to get UPnP port mapping")
 	}
 }
 
+// TestGetUPnPPortMapping_PrefersPublicDevice tests that when multiple UPnP
+// devices respond, we map with the one that has a public external IP
+// rather than whichever responded first.
+func TestGetUPnPPortMapping_PrefersPublicDevice(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	newDevice := func(extIPResponse string, adds *atomic.Int32) *httptest.Server {
+		ts := httptest.NewServer(&upnpServer{
+			t:    t,
+			Desc: testRootDesc,
+			Control: map[string]map[string]any{
+				"/ctl/IPConn": {
+					"AddPortMapping": func(body []byte) (int, string) {
+						adds.Add(1)
+						return http.StatusOK, testAddPortMappingResponse
+					},
+					"GetExternalIPAddress": extIPResponse,
+					"GetStatusInfo":        testGetStatusInfoResponse,
+					"DeletePortMapping":    "", // Do nothing for test
+				},
+			},
+		})
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	var privateAdds, publicAdds atomic.Int32
+	private := newDevice(testGetExternalIPAddressResponsePrivate, &privateAdds)
+	public := newDevice(testGetExternalIPAddressResponse, &publicAdds)
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.debug.VerboseLogs = true
+
+	gw, myIP, ok := c.gatewayAndSelfIP()
+	if !ok {
+		t.Fatalf("could not get gateway and self IP")
+	}
+	c.mu.Lock()
+	c.uPnPMetas = []uPnPDiscoResponse{
+		{Location: private.URL + "/rootDesc.xml"},
+		{Location: public.URL + "/rootDesc.xml"},
+	}
+	c.mu.Unlock()
+
+	ext, err := c.getUPnPPortMapping(context.Background(), gw, netip.AddrPortFrom(myIP, 12345), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ext.Addr(), netip.MustParseAddr("123.123.123.123"); got != want {
+		t.Errorf("external address = %v; want %v", got, want)
+	}
+	if n := privateAdds.Load(); n != 0 {
+		t.Errorf("made %d mappings with the device with a private external IP; want 0", n)
+	}
+	if n := publicAdds.Load(); n != 1 {
+		t.Errorf("made %d mappings with the device with a public external IP; want 1", n)
+	}
+}
+
+// TestGetUPnPPortMapping_StaleClient tests that when the router moves its
+// UPnP service, as after a reboot, renewing the mapping rediscovers the
+// service rather than failing on the old one.
+func TestGetUPnPPortMapping_StaleClient(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	handlers := map[string]any{
+		"AddPortMapping":              testAddPortMappingResponse,
+		"GetExternalIPAddress":        testGetExternalIPAddressResponse,
+		"GetStatusInfo":               testGetStatusInfoResponse,
+		"DeletePortMapping":           "", // Do nothing for test
+		"GetSpecificPortMappingEntry": testGetSpecificPortMappingEntryNoSuchEntry,
+	}
+	igd.SetUPnPHandler(&upnpServer{
+		t:       t,
+		Desc:    testRootDesc,
+		Control: map[string]map[string]any{"/ctl/IPConn": handlers},
+	})
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.debug.VerboseLogs = true
+
+	ctx := context.Background()
+	if _, err := c.Probe(ctx); err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	gw, myIP, ok := c.gatewayAndSelfIP()
+	if !ok {
+		t.Fatalf("could not get gateway and self IP")
+	}
+	ext, err := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
+	if err != nil {
+		t.Fatalf("first mapping: %v", err)
+	}
+
+	// "Reboot" the router, moving its service to another control URL.
+	igd.SetUPnPHandler(&upnpServer{
+		t:       t,
+		Desc:    mikrotikRootDescXML,
+		Control: map[string]map[string]any{"/upnp/control/yomkmsnooi/wanipconn-1": handlers},
+	})
+
+	stale := metricUPnPStaleClient.Value()
+	ext2, err := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), ext.Port())
+	if err != nil {
+		t.Fatalf("mapping after reboot: %v", err)
+	}
+	if ext2 != ext {
+		t.Errorf("mapping after reboot = %v; want %v", ext2, ext)
+	}
+	if got := metricUPnPStaleClient.Value() - stale; got != 1 {
+		t.Errorf("stale client metric increased by %d; want 1", got)
+	}
+	c.mu.Lock()
+	m := c.mapping.(*upnpMapping)
+	c.mu.Unlock()
+	if err := checkUPnPClientAlive(ctx, m.client); err != nil {
+		t.Errorf("new mapping's client isn't alive: %v", err)
+	}
+}
+
 // Tests the legacy behaviour with the pre-UPnP standard portmapping service.
 func TestGetUPnPPortMapping_Legacy(t *testing.T) {
 	igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
@@ -718,15 +852,279 @@ func TestGetUPnPPortMapping_Legacy(t *testing.T) {
 		t.Fatalf("could not get gateway and self IP")
 	}
 
-	ext, ok := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
-	if !ok {
-		t.Fatal("could not get UPnP port mapping")
+	ext, err := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
+	if err != nil {
+		t.Fatalf("could not get UPnP port mapping: %v", err)
 	}
 	if got, want := ext.Addr(), netip.MustParseAddr("123.123.123.123"); got != want {
 		t.Errorf("bad external address; got %v want %v", got, want)
 	}
 }
 
+func TestGetUPnPPortMapping_Conflict(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflicts int // number of AddPortMapping requests that conflict
+		wantOK    bool
+	}{
+		{"one", 1, true},
+		{"max", maxUPnPConflictRetries, true},
+		{"too_many", maxUPnPConflictRetries + 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer igd.Close()
+
+			var (
+				mu    sync.Mutex
+				ports []string // external ports requested
+			)
+			handlers := map[string]any{
+				"AddPortMapping": func(body []byte) (int, string) {
+					var req struct {
+						ExternalPort string `xml:"NewExternalPort"`
+					}
+					if err := xml.Unmarshal(body, &req); err != nil {
+						t.Errorf("bad request: %v", err)
+						return http.StatusBadRequest, "bad request"
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					ports = append(ports, req.ExternalPort)
+					if len(ports) <= tt.conflicts {
+						return http.StatusOK, testAddPortMappingConflict
+					}
+					return http.StatusOK, testAddPortMappingResponse
+				},
+				"GetExternalIPAddress":        testGetExternalIPAddressResponse,
+				"GetStatusInfo":               testGetStatusInfoResponse,
+				"DeletePortMapping":           "", // Do nothing for test
+				"GetSpecificPortMappingEntry": testGetSpecificPortMappingEntryNoSuchEntry,
+			}
+			igd.SetUPnPHandler(&upnpServer{
+				t:    t,
+				Desc: testRootDesc,
+				Control: map[string]map[string]any{
+					"/ctl/IPConn": handlers,
+				},
+			})
+
+			c := newTestClient(t, igd)
+			defer c.Close()
+			c.debug.VerboseLogs = true
+
+			ctx := context.Background()
+			if _, err := c.Probe(ctx); err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			gw, myIP, ok := c.gatewayAndSelfIP()
+			if !ok {
+				t.Fatalf("could not get gateway and self IP")
+			}
+			ext, err := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 5000)
+			if ok := err == nil; ok != tt.wantOK {
+				t.Fatalf("getUPnPPortMapping err = %v; want ok %v", err, tt.wantOK)
+			}
+			var upnpErr *UPnPError
+			if !tt.wantOK && (!errors.As(err, &upnpErr) || upnpErr.Code != upnpErrConflictInMappingEntry) {
+				t.Errorf("getUPnPPortMapping err = %v; want UPnP error %d", err, upnpErrConflictInMappingEntry)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if got, want := len(ports), min(tt.conflicts+1, maxUPnPConflictRetries+1); got != want {
+				t.Errorf("got %d AddPortMapping requests (%q); want %d", got, ports, want)
+			}
+			if ports[0] != "5000" {
+				t.Errorf("first requested port = %s; want the previous port, 5000", ports[0])
+			}
+			if err == nil {
+				if got, want := strconv.Itoa(int(ext.Port())), ports[len(ports)-1]; got != want {
+					t.Errorf("mapped port = %s; want the last one requested, %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetUPnPPortMapping_VerifyEntry(t *testing.T) {
+	const prevPort = 5000
+	entry := func(client string, enabled bool, lease int) func(string) (int, string) {
+		return func(string) (int, string) {
+			return http.StatusOK, fmt.Sprintf(testGetSpecificPortMappingEntryResponse, client, enabled, lease)
+		}
+	}
+	tests := []struct {
+		name string
+		// entry returns the GetSpecificPortMappingEntry response, given
+		// our IP address.
+		entry        func(self netip.Addr) any
+		wantAdd      bool // whether AddPortMapping is called
+		wantPrevPort bool // whether the mapping keeps prevPort
+		wantVerified bool
+		wantMetric   *clientmetric.Metric
+	}{
+		{
+			name:         "permanent",
+			entry:        func(self netip.Addr) any { return entry(self.String(), true, 0) },
+			wantPrevPort: true,
+			wantVerified: true,
+		},
+		{
+			name:         "leased",
+			entry:        func(self netip.Addr) any { return entry(self.String(), true, 600) },
+			wantAdd:      true,
+			wantPrevPort: true,
+			wantVerified: true,
+		},
+		{
+			name:         "missing",
+			entry:        func(netip.Addr) any { return testGetSpecificPortMappingEntryNoSuchEntry },
+			wantAdd:      true,
+			wantPrevPort: true,
+			wantMetric:   metricUPnPEntryMissing,
+		},
+		{
+			name:         "disabled",
+			entry:        func(self netip.Addr) any { return entry(self.String(), false, 0) },
+			wantAdd:      true,
+			wantPrevPort: true,
+		},
+		{
+			name:       "taken",
+			entry:      func(netip.Addr) any { return entry("192.168.1.99", true, 0) },
+			wantAdd:    true,
+			wantMetric: metricUPnPEntryTaken,
+		},
+		{
+			name:         "unsupported",
+			entry:        func(netip.Addr) any { return testUPnPInvalidAction },
+			wantAdd:      true,
+			wantPrevPort: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer igd.Close()
+
+			c := newTestClient(t, igd)
+			defer c.Close()
+			c.debug.VerboseLogs = true
+			gw, myIP, ok := c.gatewayAndSelfIP()
+			if !ok {
+				t.Fatalf("could not get gateway and self IP")
+			}
+
+			var sawAdd atomic.Bool
+			handlers := map[string]any{
+				"AddPortMapping": func(string) (int, string) {
+					sawAdd.Store(true)
+					return http.StatusOK, testAddPortMappingResponse
+				},
+				"GetExternalIPAddress":        testGetExternalIPAddressResponse,
+				"GetStatusInfo":               testGetStatusInfoResponse,
+				"DeletePortMapping":           "", // Do nothing for test
+				"GetSpecificPortMappingEntry": tt.entry(myIP),
+			}
+			igd.SetUPnPHandler(&upnpServer{
+				t:       t,
+				Desc:    testRootDesc,
+				Control: map[string]map[string]any{"/ctl/IPConn": handlers},
+			})
+
+			ctx := context.Background()
+			if _, err := c.Probe(ctx); err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			var metricBefore int64
+			if tt.wantMetric != nil {
+				metricBefore = tt.wantMetric.Value()
+			}
+			ext, err := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), prevPort)
+			if err != nil {
+				t.Fatalf("getUPnPPortMapping: %v", err)
+			}
+			if got := sawAdd.Load(); got != tt.wantAdd {
+				t.Errorf("AddPortMapping called = %v; want %v", got, tt.wantAdd)
+			}
+			if got := ext.Port() == prevPort; got != tt.wantPrevPort {
+				t.Errorf("mapped port %d; want previous port %v", ext.Port(), tt.wantPrevPort)
+			}
+			if tt.wantMetric != nil {
+				if got := tt.wantMetric.Value() - metricBefore; got != 1 {
+					t.Errorf("metric %s increased by %d; want 1", tt.wantMetric.Name(), got)
+				}
+			}
+
+			res, err := c.Probe(ctx)
+			if err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			if got := !res.UPnPMappingVerified.IsZero(); got != tt.wantVerified {
+				t.Errorf("UPnPMappingVerified = %v; want set %v", res.UPnPMappingVerified, tt.wantVerified)
+			}
+		})
+	}
+}
+
+func TestParseUPnPError(t *testing.T) {
+	fault := func(detail string) *soap.SOAPFaultError {
+		se := &soap.SOAPFaultError{FaultCode: "s:Client", FaultString: "UPnPError"}
+		se.Detail.Raw = []byte(detail)
+		return se
+	}
+	upnpDetail := `<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>725</errorCode><errorDescription>OnlyPermanentLeasesSupported</errorDescription></UPnPError>`
+	otherErr := errors.New("some error")
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int // or 0 if not a *UPnPError
+	}{
+		{"upnp", fault(upnpDetail), upnpErrOnlyPermanentLeasesSupported},
+		{"wrapped", fmt.Errorf("adding mapping: %w", fault(upnpDetail)), upnpErrOnlyPermanentLeasesSupported},
+		{"other_detail", fault("<Other/>"), 0},
+		{"not_soap", otherErr, 0},
+		{"nil", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseUPnPError(tt.err)
+			var upnpErr *UPnPError
+			if !errors.As(err, &upnpErr) {
+				if tt.wantCode != 0 {
+					t.Fatalf("parseUPnPError = %v; want UPnP error %d", err, tt.wantCode)
+				}
+				if err != tt.err {
+					t.Fatalf("parseUPnPError = %v; want unchanged %v", err, tt.err)
+				}
+				return
+			}
+			if upnpErr.Code != tt.wantCode {
+				t.Fatalf("Code = %d; want %d", upnpErr.Code, tt.wantCode)
+			}
+			if got, want := upnpErr.Error(), "UPnP error 725: OnlyPermanentLeasesSupported"; got != want {
+				t.Errorf("Error = %q; want %q", got, want)
+			}
+			var se *soap.SOAPFaultError
+			if !errors.As(err, &se) {
+				t.Error("UPnPError doesn't unwrap to the SOAP fault")
+			}
+			if code, ok := getUPnPErrorCode(err); !ok || code != tt.wantCode {
+				t.Errorf("getUPnPErrorCode = %d, %v; want %d, true", code, ok, tt.wantCode)
+			}
+		})
+	}
+}
+
 func TestGetUPnPPortMappingNoResponses(t *testing.T) {
 	igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
 	if err != nil {
@@ -753,8 +1151,7 @@ func TestGetUPnPPortMappingNoResponses(t *testing.T) {
 		}}
 		c.mu.Unlock()
 
-		_, ok := c.getUPnPPortMapping(context.Background(), gw, netip.AddrPortFrom(myIP, 12345), 0)
-		if ok {
+		if _, err := c.getUPnPPortMapping(context.Background(), gw, netip.AddrPortFrom(myIP, 12345), 0); err == nil {
 			t.Errorf("expected no mapping when there are no responses")
 		}
 	})
@@ -999,6 +1396,102 @@ func (u *upnpServer) handleControl(w http.ResponseWriter, r *http.Request, handl
 </s:Envelope>
 `
 
+const testAddPortMappingConflict = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <s:Fault>
+      <faultCode>s:Client</faultCode>
+      <faultString>UPnPError</faultString>
+      <detail>
+        <UPnPError xmlns="urn:schemas-upnp-org:control-1-0">
+          <errorCode>718</errorCode>
+          <errorDescription>ConflictInMappingEntry</errorDescription>
+        </UPnPError>
+      </detail>
+    </s:Fault>
+  </s:Body>
+</s:Envelope>
+`
+
+const testGetSpecificPortMappingEntryNoSuchEntry = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <s:Fault>
+      <faultCode>s:Client</faultCode>
+      <faultString>UPnPError</faultString>
+      <detail>
+        <UPnPError xmlns="urn:schemas-upnp-org:control-1-0">
+          <errorCode>714</errorCode>
+          <errorDescription>NoSuchEntryInArray</errorDescription>
+        </UPnPError>
+      </detail>
+    </s:Fault>
+  </s:Body>
+</s:Envelope>
+`
+
+const testUPnPInvalidAction = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <s:Fault>
+      <faultCode>s:Client</faultCode>
+      <faultString>UPnPError</faultString>
+      <detail>
+        <UPnPError xmlns="urn:schemas-upnp-org:control-1-0">
+          <errorCode>401</errorCode>
+          <errorDescription>Invalid Action</errorDescription>
+        </UPnPError>
+      </detail>
+    </s:Fault>
+  </s:Body>
+</s:Envelope>
+`
+
+// testGetSpecificPortMappingEntryResponse is a format string taking the
+// internal client, whether the mapping is enabled, and its remaining lease.
+const testGetSpecificPortMappingEntryResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:GetSpecificPortMappingEntryResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+      <NewInternalPort>12345</NewInternalPort>
+      <NewInternalClient>%s</NewInternalClient>
+      <NewEnabled>%v</NewEnabled>
+      <NewPortMappingDescription>tailscale</NewPortMappingDescription>
+      <NewLeaseDuration>%d</NewLeaseDuration>
+    </u:GetSpecificPortMappingEntryResponse>
+  </s:Body>
+</s:Envelope>
+`
+
+// testUPnPResponseFormat is a format string taking a WANIPConnection:1
+// action and the XML of its response's arguments.
+const testUPnPResponseFormat = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:%sResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">%s</u:%[1]sResponse>
+  </s:Body>
+</s:Envelope>
+`
+
+// testUPnPFaultFormat is a format string taking a UPnP error code and
+// its description.
+const testUPnPFaultFormat = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <s:Fault>
+      <faultCode>s:Client</faultCode>
+      <faultString>UPnPError</faultString>
+      <detail>
+        <UPnPError xmlns="urn:schemas-upnp-org:control-1-0">
+          <errorCode>%d</errorCode>
+          <errorDescription>%s</errorDescription>
+        </UPnPError>
+      </detail>
+    </s:Fault>
+  </s:Body>
+</s:Envelope>
+`
+
 const testAddPortMappingResponse = `<?xml version="1.0"?>
 <s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
   <s:Body>
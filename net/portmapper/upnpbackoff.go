@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+package portmapper
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+const (
+	// upnpBackoffAfter is how many times in a row a UPnP device must fail
+	// before we back off from it.
+	upnpBackoffAfter = 2
+
+	// upnpBackoffMin and upnpBackoffMax bound how long we skip a UPnP
+	// device that keeps failing. The backoff doubles with each failure.
+	upnpBackoffMin = 30 * time.Second
+	upnpBackoffMax = 30 * time.Minute
+
+	// maxUPnPBackoffDevices is the most UPnP devices whose failures we
+	// remember.
+	maxUPnPBackoffDevices = 16
+)
+
+// upnpBackoff is the failures of UPnP devices that haven't worked since,
+// so that we can skip devices that keep failing. Unlike upnpCache, it
+// survives link changes; a device is forgotten once it works.
+type upnpBackoff map[upnpDeviceKey]*upnpDeviceFailures
+
+// upnpDeviceKey identifies a UPnP device, by the address of its root
+// device description and the Server header of its discovery response.
+type upnpDeviceKey struct {
+	addr   netip.Addr
+	server string
+}
+
+func (k upnpDeviceKey) String() string { return fmt.Sprintf("%v (%q)", k.addr, k.server) }
+
+// upnpDeviceFailures is how a UPnP device has been failing.
+type upnpDeviceFailures struct {
+	failures int       // consecutive failures
+	last     time.Time // time of the last failure
+	retryAt  time.Time // when to try it again; zero if not backing off
+}
+
+// upnpDeviceKeyFor returns the key of the device that sent meta, in
+// response to discovery on gw.
+func upnpDeviceKeyFor(gw netip.Addr, meta uPnPDiscoResponse) upnpDeviceKey {
+	k := upnpDeviceKey{addr: gw, server: meta.Server}
+	if _, ap, err := parseUPnPLocation(meta.Location); err == nil {
+		k.addr = ap.Addr()
+	}
+	return k
+}
+
+// upnpBackoffDuration returns how long to skip a UPnP device after the
+// given number of consecutive failures, or zero if it shouldn't be.
+func upnpBackoffDuration(failures int) time.Duration {
+	if failures < upnpBackoffAfter {
+		return 0
+	}
+	d := upnpBackoffMin
+	for i := upnpBackoffAfter; i < failures && d < upnpBackoffMax; i++ {
+		d *= 2
+	}
+	return min(d, upnpBackoffMax)
+}
+
+// upnpRetryAt returns when the device k may be tried again, if we're
+// backing off from it as of now.
+func (c *Client) upnpRetryAt(k upnpDeviceKey, now time.Time) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := c.upnpBackoff[k]
+	if f == nil || !now.Before(f.retryAt) {
+		return time.Time{}, false
+	}
+	return f.retryAt, true
+}
+
+// noteUPnPDeviceFailed records that the device k failed with err, and
+// starts or extends backing off from it if it keeps failing.
+func (c *Client) noteUPnPDeviceFailed(k upnpDeviceKey, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	f := c.upnpBackoff[k]
+	if f == nil {
+		if c.upnpBackoff == nil {
+			c.upnpBackoff = make(upnpBackoff)
+		}
+		if len(c.upnpBackoff) >= maxUPnPBackoffDevices {
+			c.forgetOldestUPnPFailureLocked()
+		}
+		f = new(upnpDeviceFailures)
+		c.upnpBackoff[k] = f
+	}
+	f.failures++
+	f.last = now
+	if d := upnpBackoffDuration(f.failures); d > 0 {
+		f.retryAt = now.Add(d)
+		metricUPnPBackoff.Add(1)
+		c.logf("UPnP device %v failed %d times in a row (%v); not trying it again for %v", k, f.failures, err, d)
+		c.noteEventLocked(Event{What: "upnp-backoff", Type: "upnp", Detail: fmt.Sprintf("%v: %d failures, retry in %v", k, f.failures, d)})
+	}
+}
+
+// noteUPnPDeviceWorked forgets any failures of the device k.
+func (c *Client) noteUPnPDeviceWorked(k upnpDeviceKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.upnpBackoff, k)
+}
+
+// forgetOldestUPnPFailureLocked makes room in c.upnpBackoff by forgetting
+// the device that failed least recently.
+//
+// c.mu must be held.
+func (c *Client) forgetOldestUPnPFailureLocked() {
+	var (
+		oldest upnpDeviceKey
+		at     time.Time
+	)
+	for k, f := range c.upnpBackoff {
+		if at.IsZero() || f.last.Before(at) {
+			oldest, at = k, f.last
+		}
+	}
+	delete(c.upnpBackoff, oldest)
+}
+
+var (
+	// metricUPnPBackoff counts the number of times that we started or
+	// extended backing off from a UPnP device that kept failing.
+	metricUPnPBackoff = clientmetric.NewCounter("portmap_upnp_backoff")
+
+	// metricUPnPBackoffSkipped counts the number of times that we skipped
+	// a UPnP device because we were backing off from it.
+	metricUPnPBackoffSkipped = clientmetric.NewCounter("portmap_upnp_backoff_skipped")
+)
@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUPnPBackoffDuration(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 30 * time.Second},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{8, 30 * time.Minute},
+		{100, 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := upnpBackoffDuration(tt.failures); got != tt.want {
+			t.Errorf("upnpBackoffDuration(%d) = %v; want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// TestGetUPnPPortMapping_Backoff tests that a UPnP device that keeps
+// failing is skipped until its backoff expires, and forgotten once it
+// works.
+func TestGetUPnPPortMapping_Backoff(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	var fetches atomic.Int32
+	var h atomic.Pointer[http.Handler]
+	setHandler := func(handler http.Handler) { h.Store(&handler) }
+	setHandler(&upnpServer{t: t, Desc: noSupportedServicesRootDesc})
+	igd.SetUPnPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			fetches.Add(1)
+		}
+		(*h.Load()).ServeHTTP(w, r)
+	}))
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.debug.VerboseLogs = true
+
+	ctx := context.Background()
+	res, err := c.Probe(ctx)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if !res.UPnP {
+		t.Fatalf("didn't detect UPnP")
+	}
+	gw, myIP, ok := c.gatewayAndSelfIP()
+	if !ok {
+		t.Fatalf("could not get gateway and self IP")
+	}
+	c.mu.Lock()
+	key := upnpDeviceKeyFor(gw, c.uPnPMetas[0])
+	c.mu.Unlock()
+	failures := func() int {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if f := c.upnpBackoff[key]; f != nil {
+			return f.failures
+		}
+		return 0
+	}
+
+	tryMapping := func() error {
+		_, err := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
+		return err
+	}
+
+	for i := range upnpBackoffAfter {
+		if err := tryMapping(); err == nil {
+			t.Fatal("did not expect to get UPnP port mapping")
+		}
+		if got, want := failures(), i+1; got != want {
+			t.Fatalf("after attempt %d: %d failures; want %d", i+1, got, want)
+		}
+	}
+
+	skipped := metricUPnPBackoffSkipped.Value()
+	before := fetches.Load()
+	if err := tryMapping(); err == nil {
+		t.Fatal("did not expect to get UPnP port mapping")
+	}
+	if got := fetches.Load(); got != before {
+		t.Errorf("fetched root device %d times while backing off; want 0", got-before)
+	}
+	if got := metricUPnPBackoffSkipped.Value() - skipped; got != 1 {
+		t.Errorf("skipped device %d times; want 1", got)
+	}
+
+	// The backoff survives a link change.
+	c.NoteNetworkDown()
+	if _, ok := c.upnpRetryAt(key, time.Now()); !ok {
+		t.Errorf("backoff forgotten on link change")
+	}
+
+	// Once the backoff expires, the device is tried again, and forgotten
+	// once it works.
+	c.mu.Lock()
+	c.upnpBackoff[key].retryAt = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+	setHandler(igd.upnpService())
+	if _, err := c.Probe(ctx); err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	before = fetches.Load()
+	if err := tryMapping(); err != nil {
+		t.Fatalf("getUPnPPortMapping: %v", err)
+	}
+	if fetches.Load() == before {
+		t.Errorf("didn't fetch root device after backoff expired")
+	}
+	if got := failures(); got != 0 {
+		t.Errorf("%d failures after success; want 0", got)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+package portmapper
+
+import (
+	"context"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/tailscale/goupnp"
+)
+
+// defaultUPnPCacheTTL is how long UPnP root device descriptions and
+// external IP addresses are cached, unless DebugKnobs.UPnPCacheTTL says
+// otherwise.
+const defaultUPnPCacheTTL = 2 * time.Minute
+
+// upnpCache caches UPnP lookups for a single gateway: its root device
+// descriptions and external IP addresses, which rarely change but take
+// several round trips to fetch. It's dropped when the gateway changes or
+// the network goes down.
+type upnpCache struct {
+	gw     netip.Addr
+	roots  map[string]upnpCachedRoot  // keyed by discovery response Location
+	extIPs map[string]upnpCachedExtIP // keyed by upnpServiceKey
+}
+
+type upnpCachedRoot struct {
+	rootDev *goupnp.RootDevice
+	loc     *url.URL
+	at      time.Time
+}
+
+type upnpCachedExtIP struct {
+	ip netip.Addr
+	at time.Time
+}
+
+// upnpCacheTTL returns how long UPnP lookups are cached, or zero if they
+// aren't.
+func (c *Client) upnpCacheTTL() time.Duration {
+	if ttl := c.debug.UPnPCacheTTL; ttl != 0 {
+		return max(ttl, 0)
+	}
+	return defaultUPnPCacheTTL
+}
+
+// upnpCacheLocked returns the cache for gw, replacing any cache for a
+// different gateway.
+//
+// c.mu must be held.
+func (c *Client) upnpCacheLocked(gw netip.Addr) *upnpCache {
+	if c.uPnPCache == nil || c.uPnPCache.gw != gw {
+		c.uPnPCache = &upnpCache{
+			gw:     gw,
+			roots:  make(map[string]upnpCachedRoot),
+			extIPs: make(map[string]upnpCachedExtIP),
+		}
+	}
+	return c.uPnPCache
+}
+
+// forgetUPnPRootDevice drops the cached root device for gw and loc (a
+// discovery response Location), after it failed to create a mapping, in
+// case it's stale.
+func (c *Client) forgetUPnPRootDevice(gw netip.Addr, loc string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.upnpCacheLocked(gw).roots, loc)
+}
+
+// getUPnPRootDeviceCached is like getUPnPRootDevice, but returns a cached
+// root device for gw and meta if it's fresh enough.
+func (c *Client) getUPnPRootDeviceCached(ctx context.Context, gw netip.Addr, meta uPnPDiscoResponse) (*goupnp.RootDevice, *url.URL, error) {
+	ttl := c.upnpCacheTTL()
+	if ttl > 0 && meta.Location != "" {
+		c.mu.Lock()
+		e, ok := c.upnpCacheLocked(gw).roots[meta.Location]
+		c.mu.Unlock()
+		if ok && time.Since(e.at) < ttl {
+			metricUPnPCacheRootHit.Add(1)
+			return e.rootDev, e.loc, nil
+		}
+	}
+	rootDev, loc, err := getUPnPRootDevice(ctx, c.logf, c.debug, gw, meta)
+	if err != nil || rootDev == nil || ttl <= 0 {
+		return rootDev, loc, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.upnpCacheLocked(gw).roots[meta.Location] = upnpCachedRoot{rootDev: rootDev, loc: loc, at: time.Now()}
+	return rootDev, loc, nil
+}
+
+// upnpExternalIP returns the external IP address of the UPnP service
+// client on gw, from the cache if it's fresh enough.
+func (c *Client) upnpExternalIP(ctx context.Context, gw netip.Addr, client upnpClient) (netip.Addr, error) {
+	ttl := c.upnpCacheTTL()
+	key := upnpServiceKey(client)
+	if ttl > 0 && key != "" {
+		c.mu.Lock()
+		e, ok := c.upnpCacheLocked(gw).extIPs[key]
+		c.mu.Unlock()
+		if ok && time.Since(e.at) < ttl {
+			metricUPnPCacheExtIPHit.Add(1)
+			return e.ip, nil
+		}
+	}
+	extIP, err := client.GetExternalIPAddress(ctx)
+	c.vlogf("client.GetExternalIPAddress: %v, %v", extIP, err)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	ip, err := netip.ParseAddr(extIP)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if ttl > 0 && key != "" {
+		c.mu.Lock()
+		c.upnpCacheLocked(gw).extIPs[key] = upnpCachedExtIP{ip: ip, at: time.Now()}
+		c.mu.Unlock()
+	}
+	return ip, nil
+}
+
+// upnpServiceKey returns a key identifying the UPnP service that client
+// talks to, or the empty string if it can't be identified.
+func upnpServiceKey(client upnpClient) string {
+	sc, ok := client.(interface {
+		GetServiceClient() *goupnp.ServiceClient
+	})
+	if !ok {
+		return ""
+	}
+	s := sc.GetServiceClient()
+	if s == nil || s.Location == nil || s.Service == nil {
+		return ""
+	}
+	return s.Location.String() + " " + s.Service.ServiceType + " " + s.Service.ControlURL.Str
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingUPnPServer wraps a upnpServer, counting root description
+// fetches.
+type countingUPnPServer struct {
+	*upnpServer
+	rootFetches atomic.Int32
+}
+
+func (s *countingUPnPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/rootDesc.xml" {
+		s.rootFetches.Add(1)
+	}
+	s.upnpServer.ServeHTTP(w, r)
+}
+
+func TestUPnPCache(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{UPnP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	var extIPCalls atomic.Int32
+	srv := &countingUPnPServer{upnpServer: &upnpServer{
+		t:    t,
+		Desc: testRootDesc,
+		Control: map[string]map[string]any{
+			"/ctl/IPConn": {
+				"AddPortMapping": testAddPortMappingResponse,
+				"GetExternalIPAddress": func(body []byte) (int, string) {
+					extIPCalls.Add(1)
+					return http.StatusOK, testGetExternalIPAddressResponse
+				},
+				"GetStatusInfo":     testGetStatusInfoResponse,
+				"DeletePortMapping": "",
+			},
+		},
+	}}
+	igd.SetUPnPHandler(srv)
+
+	for _, tt := range []struct {
+		name   string
+		ttl    time.Duration // DebugKnobs.UPnPCacheTTL
+		cached bool
+	}{
+		{name: "default", cached: true},
+		{name: "disabled", ttl: -1, cached: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, igd)
+			defer c.Close()
+			c.debug.UPnPCacheTTL = tt.ttl
+
+			ctx := context.Background()
+			if _, err := c.Probe(ctx); err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			gw, myIP, ok := c.gatewayAndSelfIP()
+			if !ok {
+				t.Fatal("could not get gateway and self IP")
+			}
+
+			// mapOnce creates a new mapping from scratch, as after a
+			// re-probe, and returns how many root description fetches
+			// and external IP lookups it made.
+			mapOnce := func() (roots, extIPs int32) {
+				t.Helper()
+				c.mu.Lock()
+				c.mapping = nil
+				c.mu.Unlock()
+				r0, e0 := srv.rootFetches.Load(), extIPCalls.Load()
+				ext, err := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
+				if err != nil {
+					t.Fatalf("could not get UPnP port mapping: %v", err)
+				}
+				if got, want := ext.Addr(), netip.MustParseAddr("123.123.123.123"); got != want {
+					t.Errorf("bad external address; got %v want %v", got, want)
+				}
+				return srv.rootFetches.Load() - r0, extIPCalls.Load() - e0
+			}
+
+			roots1, extIPs1 := mapOnce()
+			if roots1 != 1 {
+				t.Errorf("first mapping fetched root description %d times; want 1", roots1)
+			}
+			roots2, extIPs2 := mapOnce()
+			if tt.cached {
+				if roots2 != 0 || extIPs2 != extIPs1-1 {
+					t.Errorf("second mapping made %d root fetches and %d external IP lookups; want 0 and %d", roots2, extIPs2, extIPs1-1)
+				}
+			} else if roots2 != roots1 || extIPs2 != extIPs1 {
+				t.Errorf("second mapping made %d root fetches and %d external IP lookups; want %d and %d", roots2, extIPs2, roots1, extIPs1)
+			}
+
+			// A link change drops the cache.
+			c.NoteNetworkDown()
+			if _, err := c.Probe(ctx); err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			if roots3, _ := mapOnce(); roots3 != 1 {
+				t.Errorf("mapping after network down fetched root description %d times; want 1", roots3)
+			}
+		})
+	}
+}
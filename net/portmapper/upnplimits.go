@@ -0,0 +1,134 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+package portmapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/tailscale/goupnp"
+)
+
+// Limits on what we accept from UPnP devices, which are untrusted: any
+// device on the LAN can answer discovery.
+const (
+	// maxUPnPLocationLen is the longest root device description location
+	// that we accept in a discovery response.
+	maxUPnPLocationLen = 1024
+
+	// maxUPnPResponseSize is the most that we read of the body of an
+	// HTTP response from a UPnP device, either a root device description
+	// or a SOAP response. Real ones are at most tens of kilobytes.
+	maxUPnPResponseSize = 256 << 10
+
+	// maxUPnPResponseHeaderSize is the most that we read of the headers
+	// of an HTTP response from a UPnP device.
+	maxUPnPResponseHeaderSize = 16 << 10
+
+	// upnpHTTPTimeout is the longest that any one HTTP request to a UPnP
+	// device may take, including reading the response body. Devices are
+	// on the LAN, so this is generous.
+	upnpHTTPTimeout = 5 * time.Second
+)
+
+// errUPnPResponseTooLarge is returned when reading a response from a UPnP
+// device that's larger than maxUPnPResponseSize.
+var errUPnPResponseTooLarge = errors.New("UPnP response too large")
+
+// parseUPnPLocation parses loc, the location of a root device description
+// from a UPnP discovery response. It must be a plain HTTP URL for an IP
+// address and port, without credentials.
+func parseUPnPLocation(loc string) (*url.URL, netip.AddrPort, error) {
+	if len(loc) > maxUPnPLocationLen {
+		return nil, netip.AddrPort{}, fmt.Errorf("UPnP location too long (%d bytes)", len(loc))
+	}
+	u, err := url.Parse(loc)
+	if err != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	if u.Scheme != "http" {
+		return nil, netip.AddrPort{}, fmt.Errorf("unsupported scheme in UPnP location %q", loc)
+	}
+	if u.User != nil {
+		return nil, netip.AddrPort{}, fmt.Errorf("unexpected credentials in UPnP location %q", loc)
+	}
+	ipp, err := netip.ParseAddrPort(u.Host)
+	if err != nil {
+		return nil, netip.AddrPort{}, fmt.Errorf("unexpected host %q in %q", u.Host, loc)
+	}
+	return u, ipp, nil
+}
+
+// checkUPnPRootDevice returns an error if any service in root has a
+// control URL, where we'd send SOAP requests, that isn't a plain HTTP URL
+// for one of the allowed addresses. Device descriptions can set an
+// absolute URLBase or control URLs, which would otherwise let anything on
+// the LAN have us send requests to any host.
+func checkUPnPRootDevice(root *goupnp.RootDevice, allowed ...netip.Addr) error {
+	var err error
+	root.Device.VisitServices(func(s *goupnp.Service) {
+		if err != nil || !s.ControlURL.Ok {
+			return
+		}
+		u := &s.ControlURL.URL
+		ip, perr := netip.ParseAddr(u.Hostname())
+		if u.Scheme != "http" || u.User != nil || perr != nil || !slices.Contains(allowed, ip) {
+			err = fmt.Errorf("UPnP service %q has unexpected control URL %q", s.ServiceType, u)
+		}
+	})
+	return err
+}
+
+// limitedBodyTransport is an http.RoundTripper that fails reads of
+// response bodies larger than max bytes.
+type limitedBodyTransport struct {
+	rt  http.RoundTripper
+	max int64
+}
+
+func (t limitedBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.ContentLength > t.max {
+		res.Body.Close()
+		return nil, errUPnPResponseTooLarge
+	}
+	res.Body = &limitedBody{
+		r:      io.LimitReader(res.Body, t.max+1),
+		closer: res.Body,
+		max:    t.max,
+	}
+	return res, nil
+}
+
+// limitedBody is a response body that fails reads after max bytes.
+type limitedBody struct {
+	r      io.Reader
+	closer io.Closer
+	max    int64
+	read   int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.read > b.max {
+		return n, errUPnPResponseTooLarge
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.closer.Close()
+}
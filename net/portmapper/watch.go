@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import "net/netip"
+
+// MappingChange describes a change in the external address of a Client's
+// port mapping.
+type MappingChange struct {
+	// Old is the external address before the change, or the zero value
+	// if there was no usable mapping.
+	Old netip.AddrPort
+
+	// New is the external address after the change, or the zero value
+	// if the mapping was lost.
+	New netip.AddrPort
+}
+
+// Acquired reports whether the change is from having no mapping to having
+// one.
+func (mc MappingChange) Acquired() bool { return !mc.Old.IsValid() && mc.New.IsValid() }
+
+// Lost reports whether the change is from having a mapping to having none,
+// such as when it expired without being renewed or the gateway went away.
+func (mc MappingChange) Lost() bool { return mc.Old.IsValid() && !mc.New.IsValid() }
+
+// RegisterChangeCallback adds cb to the set of funcs called, each in its
+// own goroutine, when the external address of c's port mapping changes:
+// when a mapping is acquired, renewed with a different external IP or
+// port, or lost. Renewals that keep the same external address don't
+// call it. To remove the callback, call unregister.
+func (c *Client) RegisterChangeCallback(cb func(MappingChange)) (unregister func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	handle := c.changeCallbacks.Add(cb)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.changeCallbacks, handle)
+	}
+}
+
+// noteExternalLocked records that the usable external address is now
+// external (the zero value if none) and, if that differs from what was
+// last reported, calls the onChange hook and change callbacks. Nothing is
+// called once c is closed.
+//
+// c.mu must be held.
+func (c *Client) noteExternalLocked(external netip.AddrPort) {
+	if external == c.lastExternal {
+		return
+	}
+	if external.IsValid() {
+		if c.firstExternalPort == 0 {
+			c.firstExternalPort = external.Port()
+			c.externalPortStable.Set(true)
+		} else if external.Port() != c.firstExternalPort {
+			c.externalPortStable.Set(false)
+		}
+	}
+	change := MappingChange{Old: c.lastExternal, New: external}
+	c.lastExternal = external
+	if c.closed {
+		return
+	}
+	if c.onChange != nil {
+		go c.onChange()
+	}
+	for _, cb := range c.changeCallbacks {
+		go cb(change)
+	}
+}
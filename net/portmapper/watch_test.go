@@ -0,0 +1,165 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRegisterChangeCallback(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	changes := make(chan MappingChange, 10)
+	unregister := c.RegisterChangeCallback(func(mc MappingChange) { changes <- mc })
+	next := func() MappingChange {
+		t.Helper()
+		select {
+		case mc := <-changes:
+			return mc
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for change callback")
+			return MappingChange{}
+		}
+	}
+
+	c.createMapping()
+	mc := next()
+	if !mc.Acquired() || mc.Lost() {
+		t.Errorf("after createMapping got %+v; want acquired", mc)
+	}
+	ext, ok := c.GetCachedMappingOrStartCreatingOne()
+	if !ok || mc.New != ext {
+		t.Errorf("change.New = %v; want %v", mc.New, ext)
+	}
+
+	// Same external address as before: no callback.
+	c.mu.Lock()
+	c.noteExternalLocked(ext)
+	c.mu.Unlock()
+
+	// The router reports a different external port on renewal.
+	moved := netip.AddrPortFrom(ext.Addr(), ext.Port()+1)
+	c.mu.Lock()
+	c.noteExternalLocked(moved)
+	c.mu.Unlock()
+	if mc := next(); mc.Old != ext || mc.New != moved || mc.Acquired() || mc.Lost() {
+		t.Errorf("after move got %+v; want %v -> %v", mc, ext, moved)
+	}
+
+	c.mu.Lock()
+	c.invalidateMappingsLocked(false)
+	c.mu.Unlock()
+	if mc := next(); !mc.Lost() || mc.Old != moved {
+		t.Errorf("after invalidate got %+v; want lost %v", mc, moved)
+	}
+
+	unregister()
+	c.mu.Lock()
+	c.noteExternalLocked(ext)
+	c.mu.Unlock()
+	select {
+	case mc := <-changes:
+		t.Errorf("callback called after unregister: %+v", mc)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestProbeExternalPortStable(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	probe := func() ProbeResult {
+		t.Helper()
+		res, err := c.Probe(context.Background())
+		if err != nil {
+			t.Fatalf("probe failed: %v", err)
+		}
+		return res
+	}
+
+	if got := probe().ExternalPortStable; got != "" {
+		t.Errorf("before any mapping, ExternalPortStable = %q; want empty", got)
+	}
+
+	c.createMapping()
+	ext, ok := c.GetCachedMappingOrStartCreatingOne()
+	if !ok {
+		t.Fatal("no mapping")
+	}
+	if got := probe().ExternalPortStable; got != "true" {
+		t.Errorf("after mapping, ExternalPortStable = %q; want true", got)
+	}
+
+	// Losing the mapping and getting the same port back is still stable.
+	c.mu.Lock()
+	c.noteExternalLocked(netip.AddrPort{})
+	c.noteExternalLocked(ext)
+	c.mu.Unlock()
+	if got := probe().ExternalPortStable; got != "true" {
+		t.Errorf("after same port again, ExternalPortStable = %q; want true", got)
+	}
+
+	c.mu.Lock()
+	c.noteExternalLocked(netip.AddrPortFrom(ext.Addr(), ext.Port()+1))
+	c.mu.Unlock()
+	if got := probe().ExternalPortStable; got != "false" {
+		t.Errorf("after port moved, ExternalPortStable = %q; want false", got)
+	}
+
+	// A network change starts over.
+	c.mu.Lock()
+	c.invalidateMappingsLocked(false)
+	c.mu.Unlock()
+	if got := probe().ExternalPortStable; got != "" {
+		t.Errorf("after invalidation, ExternalPortStable = %q; want empty", got)
+	}
+}
+
+func TestProbeCGNAT(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	for _, tt := range []struct {
+		ext              string
+		doubleNAT, cgnat bool
+	}{
+		{"1.2.3.4", false, false},
+		{"192.168.100.2", true, false},
+		{"100.70.1.2", true, true},
+	} {
+		c.mu.Lock()
+		c.pmpPubIP = netip.MustParseAddr(tt.ext)
+		c.mu.Unlock()
+		res, err := c.Probe(context.Background())
+		if err != nil {
+			t.Fatalf("probe failed: %v", err)
+		}
+		if res.DoubleNAT != tt.doubleNAT || res.CGNAT != tt.cgnat {
+			t.Errorf("external %v: DoubleNAT, CGNAT = %v, %v; want %v, %v", tt.ext, res.DoubleNAT, res.CGNAT, tt.doubleNAT, tt.cgnat)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux && !android && (amd64 || arm64)
+
+package sockbypass
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Registers.
+const (
+	r0 = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7
+	r8
+	r9
+	r10 // read-only frame pointer
+)
+
+// Helper function IDs, from enum bpf_func_id.
+const (
+	fnMapLookupElem     = 1
+	fnMapUpdateElem     = 2
+	fnMapDeleteElem     = 3
+	fnSockOpsCbFlagsSet = 59
+	fnSockHashUpdate    = 70
+	fnMsgRedirectHash   = 71
+)
+
+// Constants from linux/bpf.h that x/sys/unix lacks.
+const (
+	bpfEnd                = 0xd0 // BPF_END
+	bpfToBE               = 0x08 // BPF_TO_BE
+	bpfFIngress           = 1    // BPF_F_INGRESS
+	bpfSockOpsStateCBFlag = 1 << 2
+)
+
+type insn struct {
+	op   uint8
+	regs uint8 // src<<4 | dst
+	off  int16
+	imm  int32
+
+	label string // if non-empty, jump target to resolve into off
+}
+
+// asm is a minimal eBPF assembler, covering just the instructions that
+// sockbypass's two small programs use.
+type asm struct {
+	insns  []insn
+	labels map[string]int
+}
+
+func (a *asm) emit(op uint8, dst, src uint8, off int16, imm int32) {
+	a.insns = append(a.insns, insn{op: op, regs: src<<4 | dst, off: off, imm: imm})
+}
+
+// Label defines name as the address of the next instruction.
+func (a *asm) Label(name string) {
+	if a.labels == nil {
+		a.labels = map[string]int{}
+	}
+	a.labels[name] = len(a.insns)
+}
+
+func (a *asm) Mov64Reg(dst, src uint8) {
+	a.emit(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_X, dst, src, 0, 0)
+}
+func (a *asm) Mov64Imm(dst uint8, imm int32) {
+	a.emit(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_K, dst, 0, 0, imm)
+}
+func (a *asm) Add64Imm(dst uint8, imm int32) {
+	a.emit(unix.BPF_ALU64|unix.BPF_ADD|unix.BPF_K, dst, 0, 0, imm)
+}
+func (a *asm) And32Imm(dst uint8, imm int32) {
+	a.emit(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, dst, 0, 0, imm)
+}
+func (a *asm) Rsh32Imm(dst uint8, imm int32) {
+	a.emit(unix.BPF_ALU|unix.BPF_RSH|unix.BPF_K, dst, 0, 0, imm)
+}
+
+// Be16 converts the low 16 bits of dst to big endian. On the little
+// endian machines this package is built for, that swaps them.
+func (a *asm) Be16(dst uint8) { a.emit(unix.BPF_ALU|bpfEnd|bpfToBE, dst, 0, 0, 16) }
+
+// LoadW loads the 32-bit word at src+off into dst.
+func (a *asm) LoadW(dst, src uint8, off int16) {
+	a.emit(unix.BPF_LDX|unix.BPF_MEM|unix.BPF_W, dst, src, off, 0)
+}
+
+// StoreW stores the low 32 bits of src at dst+off.
+func (a *asm) StoreW(dst uint8, off int16, src uint8) {
+	a.emit(unix.BPF_STX|unix.BPF_MEM|unix.BPF_W, dst, src, off, 0)
+}
+
+// StoreWImm stores imm at dst+off.
+func (a *asm) StoreWImm(dst uint8, off int16, imm int32) {
+	a.emit(unix.BPF_ST|unix.BPF_MEM|unix.BPF_W, dst, 0, off, imm)
+}
+
+// AtomicAdd64 atomically adds src to the 64-bit word at dst+off.
+func (a *asm) AtomicAdd64(dst uint8, off int16, src uint8) {
+	a.emit(unix.BPF_STX|unix.BPF_ATOMIC|unix.BPF_DW, dst, src, off, unix.BPF_ADD)
+}
+
+// LoadMapFD loads a pointer to the map with file descriptor fd into dst.
+func (a *asm) LoadMapFD(dst uint8, fd int) {
+	a.emit(unix.BPF_LD|unix.BPF_DW|unix.BPF_IMM, dst, unix.BPF_PSEUDO_MAP_FD, 0, int32(fd))
+	a.emit(0, 0, 0, 0, 0)
+}
+
+// JEqImm jumps to label if dst == imm.
+func (a *asm) JEqImm(dst uint8, imm int32, label string) { a.jmp(unix.BPF_JEQ, dst, imm, label) }
+
+// JNeImm jumps to label if dst != imm.
+func (a *asm) JNeImm(dst uint8, imm int32, label string) { a.jmp(unix.BPF_JNE, dst, imm, label) }
+
+// J32NeImm jumps to label if the low 32 bits of dst != imm.
+func (a *asm) J32NeImm(dst uint8, imm int32, label string) {
+	a.emit(unix.BPF_JMP32|unix.BPF_JNE|unix.BPF_K, dst, 0, 0, imm)
+	a.insns[len(a.insns)-1].label = label
+}
+
+// Ja jumps to label.
+func (a *asm) Ja(label string) { a.jmp(unix.BPF_JA, 0, 0, label) }
+
+func (a *asm) jmp(op uint8, dst uint8, imm int32, label string) {
+	a.emit(unix.BPF_JMP|op|unix.BPF_K, dst, 0, 0, imm)
+	a.insns[len(a.insns)-1].label = label
+}
+
+func (a *asm) Call(fn int32) { a.emit(unix.BPF_JMP|unix.BPF_CALL, 0, 0, 0, fn) }
+func (a *asm) Exit()         { a.emit(unix.BPF_JMP|unix.BPF_EXIT, 0, 0, 0, 0) }
+
+// Assemble returns the encoded program.
+func (a *asm) Assemble() ([]byte, error) {
+	var buf bytes.Buffer
+	for pc, in := range a.insns {
+		if in.label != "" {
+			target, ok := a.labels[in.label]
+			if !ok {
+				return nil, fmt.Errorf("undefined label %q", in.label)
+			}
+			in.off = int16(target - pc - 1)
+		}
+		buf.WriteByte(in.op)
+		buf.WriteByte(in.regs)
+		binary.Write(&buf, binary.LittleEndian, in.off)
+		binary.Write(&buf, binary.LittleEndian, in.imm)
+	}
+	return buf.Bytes(), nil
+}
+
+// bpf invokes the bpf(2) syscall. It and the wrappers below cover only the
+// commands sockbypass needs.
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+// createMap creates a map and returns its file descriptor.
+func createMap(typ, keySize, valueSize, maxEntries uint32) (int, error) {
+	attr := struct {
+		mapType, keySize, valueSize, maxEntries, mapFlags uint32
+	}{typ, keySize, valueSize, maxEntries, 0}
+	fd, err := bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return 0, fmt.Errorf("creating map of type %d: %w", typ, err)
+	}
+	return fd, nil
+}
+
+// lookupElem copies the value for key in the map fd into value.
+func lookupElem(fd int, key, value []byte) error {
+	attr := struct {
+		mapFD uint32
+		_     uint32
+		key   uint64
+		value uint64
+		flags uint64
+	}{
+		mapFD: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		value: uint64(uintptr(unsafe.Pointer(&value[0]))),
+	}
+	_, err := bpf(unix.BPF_MAP_LOOKUP_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+	return err
+}
+
+// loadProg loads a program of the given type and returns its file
+// descriptor. If the verifier rejects it, the error includes the
+// verifier's log.
+func loadProg(typ uint32, name string, a *asm) (int, error) {
+	code, err := a.Assemble()
+	if err != nil {
+		return 0, err
+	}
+	license := []byte("BSD\x00")
+	type progLoadAttr struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+		progName    [16]byte
+	}
+	attr := progLoadAttr{
+		progType: typ,
+		insnCnt:  uint32(len(code) / 8),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	copy(attr.progName[:15], name)
+	fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err == unix.EACCES || err == unix.EINVAL {
+		// Load again to get the verifier's explanation.
+		log := make([]byte, 64<<10)
+		attr.logLevel = 1
+		attr.logSize = uint32(len(log))
+		attr.logBuf = uint64(uintptr(unsafe.Pointer(&log[0])))
+		if fd2, err2 := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err2 == nil {
+			fd, err = fd2, nil
+		} else if n := bytes.IndexByte(log, 0); n > 0 {
+			err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(log[:n]))
+		}
+		runtime.KeepAlive(log)
+	}
+	runtime.KeepAlive(code)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return 0, fmt.Errorf("loading %s: %w", name, err)
+	}
+	return fd, nil
+}
+
+type progAttachAttr struct {
+	targetFD, attachBPFFD, attachType, attachFlags uint32
+}
+
+func attachProg(targetFD, progFD int, attachType, flags uint32) error {
+	attr := progAttachAttr{uint32(targetFD), uint32(progFD), attachType, flags}
+	_, err := bpf(unix.BPF_PROG_ATTACH, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// linkProg attaches the program progFD to targetFD with a BPF link and
+// returns the link's file descriptor. Unlike attachProg, the attachment
+// goes away when the link is closed, including when the process exits.
+func linkProg(targetFD, progFD int, attachType uint32) (int, error) {
+	attr := struct {
+		progFD, targetFD, attachType, flags uint32
+	}{uint32(progFD), uint32(targetFD), attachType, 0}
+	return bpf(unix.BPF_LINK_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
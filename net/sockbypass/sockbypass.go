@@ -0,0 +1,86 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package sockbypass implements an experimental fast path for TCP traffic
+// between two tailnet nodes running on the same host, such as two
+// containers on one machine that each run tailscaled.
+//
+// Such traffic would otherwise be encrypted by one WireGuard instance,
+// sent over the host's loopback or bridge, and decrypted by the other. On
+// Linux, sockbypass attaches eBPF programs to the root cgroup that record
+// established TCP sockets whose local and remote addresses are both
+// Tailscale IPs, and move data written to such a socket directly into
+// the receive queue of its peer socket when that peer is on the same host.
+// TCP handshakes still go through WireGuard; only the payload is bypassed.
+//
+// Bypassed data is queued on the receiving socket where read(2) and
+// recvmsg(2) find it but splice(2) does not. Programs that read from a
+// socket with splice, such as Go programs using io.Copy between two TCP
+// connections, stall on bypassed connections. That, and the programs
+// applying to every process in the cgroup, is why this is opt-in.
+package sockbypass
+
+import (
+	"errors"
+	"net/netip"
+	"sync/atomic"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// ErrUnsupported is returned by New on platforms without eBPF sockmap
+// support.
+var ErrUnsupported = errors.New("sockbypass: not supported on this platform")
+
+// Stats are the counters maintained by a Bypass.
+type Stats struct {
+	Msgs  uint64 // sendmsg calls whose data was bypassed
+	Bytes uint64 // bytes bypassed
+}
+
+// cur is the most recently started Bypass, for metrics.
+var cur atomic.Pointer[Bypass]
+
+func init() {
+	clientmetric.NewCounterFunc("sockbypass_msgs", func() int64 {
+		return int64(curStats().Msgs)
+	})
+	clientmetric.NewCounterFunc("sockbypass_bytes", func() int64 {
+		return int64(curStats().Bytes)
+	})
+}
+
+func curStats() Stats {
+	if b := cur.Load(); b != nil {
+		return b.Stats()
+	}
+	return Stats{}
+}
+
+// New loads and attaches the bypass programs, applying them to TCP
+// connections whose endpoints are both within pfx, which must be an IPv4
+// prefix (typically tsaddr.CGNATRange()). The programs stay attached
+// until the returned Bypass is closed.
+func New(pfx netip.Prefix) (*Bypass, error) {
+	if !pfx.Addr().Is4() {
+		return nil, errors.New("sockbypass: prefix must be IPv4")
+	}
+	b, err := newBypass(pfx.Masked())
+	if err != nil {
+		return nil, err
+	}
+	cur.Store(b)
+	return b, nil
+}
+
+// Close detaches the bypass programs. The programs are also detached when
+// the process exits.
+func (b *Bypass) Close() error {
+	cur.CompareAndSwap(b, nil)
+	return b.close()
+}
+
+// Stats returns the bypass counters.
+func (b *Bypass) Stats() Stats {
+	return b.stats()
+}
@@ -0,0 +1,344 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux && !android && (amd64 || arm64)
+
+package sockbypass
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"tailscale.com/util/multierr"
+)
+
+const (
+	// keySize is the size of the socket keys in the maps: local IPv4,
+	// remote IPv4, local port, remote port; all 32 bits, ports in host
+	// byte order.
+	keySize = 16
+
+	// maxSockets bounds the number of sockets tracked.
+	maxSockets = 65535
+
+	// decisionBypass and decisionNormal are the values of the decided
+	// map.
+	decisionBypass = 1
+	decisionNormal = 2
+)
+
+// Values from linux/bpf.h.
+const (
+	sockOpsActiveEstablishedCB  = 4  // BPF_SOCK_OPS_ACTIVE_ESTABLISHED_CB
+	sockOpsPassiveEstablishedCB = 5  // BPF_SOCK_OPS_PASSIVE_ESTABLISHED_CB
+	sockOpsStateCB              = 10 // BPF_SOCK_OPS_STATE_CB
+	tcpClose                    = 7  // BPF_TCP_CLOSE
+	skPass                      = 1  // SK_PASS
+)
+
+// Offsets of the fields of struct bpf_sock_ops that the programs read.
+const (
+	sockOpsOp         = 0
+	sockOpsArg1       = 8 // args[1]
+	sockOpsFamily     = 20
+	sockOpsRemoteIP4  = 24
+	sockOpsLocalIP4   = 28
+	sockOpsRemotePort = 64
+	sockOpsLocalPort  = 68
+)
+
+// Offsets of the fields of struct sk_msg_md that the programs read.
+const (
+	msgRemoteIP4  = 20
+	msgLocalIP4   = 24
+	msgRemotePort = 60
+	msgLocalPort  = 64
+	msgSize       = 68
+)
+
+// Bypass is an attached set of bypass programs.
+type Bypass struct {
+	sockHash    int // BPF_MAP_TYPE_SOCKHASH of tracked sockets
+	established int // LRU hash of the keys of tracked sockets, for lookups
+	decided     int // LRU hash of socket key to decisionBypass or decisionNormal
+	statsMap    int // array of one Stats, updated atomically
+
+	sockOps int // BPF_PROG_TYPE_SOCK_OPS program, attached to cgroup
+	skMsg   int // BPF_PROG_TYPE_SK_MSG program, attached to sockHash
+	link    int // link attaching sockOps to the cgroup
+}
+
+func newBypass(pfx netip.Prefix) (*Bypass, error) {
+	root, err := cgroup2Root()
+	if err != nil {
+		return nil, err
+	}
+	return newBypassAt(pfx, root)
+}
+
+// newBypassAt is like newBypass but attaches to the cgroup at dir.
+func newBypassAt(pfx netip.Prefix, dir string) (_ *Bypass, retErr error) {
+	b := &Bypass{sockHash: -1, established: -1, decided: -1, statsMap: -1, sockOps: -1, skMsg: -1, link: -1}
+	defer func() {
+		if retErr != nil {
+			b.close()
+		}
+	}()
+	cgroup, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer cgroup.Close()
+	if b.sockHash, err = createMap(unix.BPF_MAP_TYPE_SOCKHASH, keySize, 4, maxSockets); err != nil {
+		return nil, err
+	}
+	if b.established, err = createMap(unix.BPF_MAP_TYPE_LRU_HASH, keySize, 4, maxSockets); err != nil {
+		return nil, err
+	}
+	if b.decided, err = createMap(unix.BPF_MAP_TYPE_LRU_HASH, keySize, 4, maxSockets); err != nil {
+		return nil, err
+	}
+	if b.statsMap, err = createMap(unix.BPF_MAP_TYPE_ARRAY, 4, 16, 1); err != nil {
+		return nil, err
+	}
+	if b.sockOps, err = loadProg(unix.BPF_PROG_TYPE_SOCK_OPS, "ts_bypass_ops", b.sockOpsProg(pfx)); err != nil {
+		return nil, err
+	}
+	if b.skMsg, err = loadProg(unix.BPF_PROG_TYPE_SK_MSG, "ts_bypass_msg", b.skMsgProg()); err != nil {
+		return nil, err
+	}
+	if err := attachProg(b.sockHash, b.skMsg, unix.BPF_SK_MSG_VERDICT, 0); err != nil {
+		return nil, fmt.Errorf("attaching sk_msg program: %w", err)
+	}
+	if b.link, err = linkProg(int(cgroup.Fd()), b.sockOps, unix.BPF_CGROUP_SOCK_OPS); err != nil {
+		return nil, fmt.Errorf("attaching sock_ops program to %s: %w", dir, err)
+	}
+	return b, nil
+}
+
+func (b *Bypass) close() error {
+	var errs []error
+	for _, fd := range []*int{&b.link, &b.skMsg, &b.sockOps, &b.statsMap, &b.decided, &b.established, &b.sockHash} {
+		if *fd >= 0 {
+			if err := unix.Close(*fd); err != nil {
+				errs = append(errs, err)
+			}
+			*fd = -1
+		}
+	}
+	return multierr.New(errs...)
+}
+
+func (b *Bypass) stats() Stats {
+	var val [16]byte
+	if b.statsMap < 0 || lookupElem(b.statsMap, make([]byte, 4), val[:]) != nil {
+		return Stats{}
+	}
+	return Stats{
+		Msgs:  binary.LittleEndian.Uint64(val[0:]),
+		Bytes: binary.LittleEndian.Uint64(val[8:]),
+	}
+}
+
+// loadKey emits code that stores the key of the socket described by ctx
+// in r6 at r10+off, using the given offsets into ctx. It leaves the
+// local and remote IPs in r7 and r8.
+func loadKey(a *asm, off int16, localIP, remoteIP, localPort, remotePort int16) {
+	a.LoadW(r7, r6, localIP)
+	a.LoadW(r8, r6, remoteIP)
+	a.StoreW(r10, off, r7)
+	a.StoreW(r10, off+4, r8)
+	a.LoadW(r3, r6, localPort)
+	a.StoreW(r10, off+8, r3)
+	// The remote port is in network byte order in the upper 16 bits.
+	a.LoadW(r3, r6, remotePort)
+	a.Rsh32Imm(r3, 16)
+	a.Be16(r3)
+	a.StoreW(r10, off+12, r3)
+}
+
+// sockOpsProg returns the program run on TCP socket events in the cgroup.
+// When a connection between two addresses in pfx is established, it adds
+// the socket to sockHash, which runs skMsg for data sent on it, and notes
+// the socket's key in established. When the socket closes, it removes the
+// key again.
+func (b *Bypass) sockOpsProg(pfx netip.Prefix) *asm {
+	ip4 := pfx.Addr().As4()
+	var mask [4]byte
+	for i := range pfx.Bits() {
+		mask[i/8] |= 0x80 >> (i % 8)
+	}
+	// The IPs in the context are in network byte order, so compare them
+	// as little endian words.
+	maskW := int32(binary.LittleEndian.Uint32(mask[:]))
+	wantW := int32(binary.LittleEndian.Uint32(ip4[:]))
+
+	a := new(asm)
+	a.Mov64Reg(r6, r1)
+	a.LoadW(r2, r6, sockOpsFamily)
+	a.JNeImm(r2, unix.AF_INET, "out")
+	a.LoadW(r2, r6, sockOpsOp)
+	a.JEqImm(r2, sockOpsStateCB, "state")
+	a.JEqImm(r2, sockOpsActiveEstablishedCB, "established")
+	a.JNeImm(r2, sockOpsPassiveEstablishedCB, "out")
+
+	a.Label("established")
+	loadKey(a, -16, sockOpsLocalIP4, sockOpsRemoteIP4, sockOpsLocalPort, sockOpsRemotePort)
+	a.Mov64Reg(r3, r7)
+	a.And32Imm(r3, maskW)
+	a.J32NeImm(r3, wantW, "out")
+	a.Mov64Reg(r3, r8)
+	a.And32Imm(r3, maskW)
+	a.J32NeImm(r3, wantW, "out")
+	// Ask for STATE_CB so the key can be removed on close.
+	a.Mov64Reg(r1, r6)
+	a.Mov64Imm(r2, bpfSockOpsStateCBFlag)
+	a.Call(fnSockOpsCbFlagsSet)
+	// sock_hash_update(ctx, sockHash, &key, BPF_ANY)
+	a.Mov64Reg(r1, r6)
+	a.LoadMapFD(r2, b.sockHash)
+	a.Mov64Reg(r3, r10)
+	a.Add64Imm(r3, -16)
+	a.Mov64Imm(r4, 0)
+	a.Call(fnSockHashUpdate)
+	// map_update_elem(established, &key, &1, BPF_ANY)
+	a.StoreWImm(r10, -20, 1)
+	a.LoadMapFD(r1, b.established)
+	a.Mov64Reg(r2, r10)
+	a.Add64Imm(r2, -16)
+	a.Mov64Reg(r3, r10)
+	a.Add64Imm(r3, -20)
+	a.Mov64Imm(r4, 0)
+	a.Call(fnMapUpdateElem)
+	a.Ja("out")
+
+	a.Label("state")
+	a.LoadW(r2, r6, sockOpsArg1)
+	a.JNeImm(r2, tcpClose, "out")
+	loadKey(a, -16, sockOpsLocalIP4, sockOpsRemoteIP4, sockOpsLocalPort, sockOpsRemotePort)
+	a.LoadMapFD(r1, b.established)
+	a.Mov64Reg(r2, r10)
+	a.Add64Imm(r2, -16)
+	a.Call(fnMapDeleteElem)
+	a.LoadMapFD(r1, b.decided)
+	a.Mov64Reg(r2, r10)
+	a.Add64Imm(r2, -16)
+	a.Call(fnMapDeleteElem)
+
+	a.Label("out")
+	a.Mov64Imm(r0, 1)
+	a.Exit()
+	return a
+}
+
+// skMsgProg returns the program run on each sendmsg to a socket in
+// sockHash. On a socket's first send, it decides whether to bypass its
+// data: only if its peer socket is also established on this host.
+// Deciding once keeps the peer from receiving data out of order, some
+// through the network and some directly. Bypassed data is redirected to
+// the peer socket's receive queue.
+func (b *Bypass) skMsgProg() *asm {
+	a := new(asm)
+	a.Mov64Reg(r6, r1)
+	// The socket's own key at -16, and its peer's at -32.
+	loadKey(a, -16, msgLocalIP4, msgRemoteIP4, msgLocalPort, msgRemotePort)
+	a.StoreW(r10, -32, r8)
+	a.StoreW(r10, -28, r7)
+	a.LoadW(r3, r10, -4)
+	a.StoreW(r10, -24, r3)
+	a.LoadW(r3, r10, -8)
+	a.StoreW(r10, -20, r3)
+
+	a.LoadMapFD(r1, b.decided)
+	a.Mov64Reg(r2, r10)
+	a.Add64Imm(r2, -16)
+	a.Call(fnMapLookupElem)
+	a.JNeImm(r0, 0, "decided")
+
+	// First send; decide.
+	a.LoadMapFD(r1, b.established)
+	a.Mov64Reg(r2, r10)
+	a.Add64Imm(r2, -32)
+	a.Call(fnMapLookupElem)
+	a.Mov64Imm(r9, decisionNormal)
+	a.JEqImm(r0, 0, "store")
+	a.Mov64Imm(r9, decisionBypass)
+	a.Label("store")
+	a.StoreW(r10, -36, r9)
+	a.LoadMapFD(r1, b.decided)
+	a.Mov64Reg(r2, r10)
+	a.Add64Imm(r2, -16)
+	a.Mov64Reg(r3, r10)
+	a.Add64Imm(r3, -36)
+	a.Mov64Imm(r4, 0)
+	a.Call(fnMapUpdateElem)
+	a.JNeImm(r9, decisionBypass, "pass")
+	a.Ja("redirect")
+
+	a.Label("decided")
+	a.LoadW(r2, r0, 0)
+	a.JNeImm(r2, decisionBypass, "pass")
+
+	a.Label("redirect")
+	// msg_redirect_hash(ctx, sockHash, &peerKey, BPF_F_INGRESS)
+	a.Mov64Reg(r1, r6)
+	a.LoadMapFD(r2, b.sockHash)
+	a.Mov64Reg(r3, r10)
+	a.Add64Imm(r3, -32)
+	a.Mov64Imm(r4, bpfFIngress)
+	a.Call(fnMsgRedirectHash)
+	a.Mov64Reg(r9, r0)
+	a.JNeImm(r9, skPass, "done")
+	// Count it.
+	a.StoreWImm(r10, -40, 0)
+	a.LoadMapFD(r1, b.statsMap)
+	a.Mov64Reg(r2, r10)
+	a.Add64Imm(r2, -40)
+	a.Call(fnMapLookupElem)
+	a.JEqImm(r0, 0, "done")
+	a.Mov64Imm(r1, 1)
+	a.AtomicAdd64(r0, 0, r1)
+	a.LoadW(r1, r6, msgSize)
+	a.AtomicAdd64(r0, 8, r1)
+	a.Label("done")
+	a.Mov64Reg(r0, r9)
+	a.Exit()
+
+	a.Label("pass")
+	a.Mov64Imm(r0, skPass)
+	a.Exit()
+	return a
+}
+
+// cgroup2Root returns the mount point of the cgroup v2 hierarchy.
+func cgroup2Root() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// Fields: id parent major:minor root mountpoint options
+		// [optional fields...] - fstype source superoptions
+		fields := strings.Fields(s.Text())
+		for i, f := range fields {
+			if f == "-" && i+1 < len(fields) && len(fields) > 4 {
+				if fields[i+1] == "cgroup2" {
+					return fields[4], nil
+				}
+				break
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("sockbypass: no cgroup2 mount found")
+}
@@ -0,0 +1,17 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux || android || !(amd64 || arm64)
+
+package sockbypass
+
+import "net/netip"
+
+// Bypass is an attached set of bypass programs.
+type Bypass struct{}
+
+func newBypass(netip.Prefix) (*Bypass, error) { return nil, ErrUnsupported }
+
+func (b *Bypass) close() error { return nil }
+
+func (b *Bypass) stats() Stats { return Stats{} }
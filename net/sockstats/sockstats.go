@@ -27,6 +27,13 @@ type SockStats struct {
 type SockStat struct {
 	TxBytes uint64
 	RxBytes uint64
+
+	// TxCalls and RxCalls are the number of non-empty writes and reads,
+	// respectively. Each one likely corresponds to the process (and on
+	// mobile, the radio) waking up, so they are a better indicator of a
+	// chatty subsystem than byte counts alone.
+	TxCalls uint64
+	RxCalls uint64
 }
 
 // Label is an identifier for a socket that stats are collected for. A finite
@@ -108,6 +115,27 @@ func GetValidation() *ValidationSockStats {
 	return getValidation()
 }
 
+// Report is a snapshot of all of the statistics collected by this package,
+// as served by the LocalAPI.
+type Report struct {
+	Stats      *SockStats           `json:",omitempty"`
+	Interfaces *InterfaceSockStats  `json:",omitempty"`
+	Validation *ValidationSockStats `json:",omitempty"`
+	DebugInfo  string               `json:",omitempty"`
+}
+
+// GetReport returns a Report containing the current values of Get,
+// GetInterfaces, GetValidation and DebugInfo. Like GetValidation, it is
+// relatively expensive and should be used in debug interfaces only.
+func GetReport() *Report {
+	return &Report{
+		Stats:      Get(),
+		Interfaces: GetInterfaces(),
+		Validation: GetValidation(),
+		DebugInfo:  DebugInfo(),
+	}
+}
+
 // SetNetMon configures the sockstats package to monitor the active
 // interface, so that per-interface stats can be collected.
 func SetNetMon(netMon *netmon.Monitor) {
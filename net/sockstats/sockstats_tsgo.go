@@ -24,6 +24,7 @@
 
 type sockStatCounters struct {
 	txBytes, rxBytes                       atomic.Uint64
+	txCalls, rxCalls                       atomic.Uint64
 	rxBytesByInterface, txBytesByInterface map[int]*atomic.Uint64
 
 	txBytesMetric, rxBytesMetric, txBytesCellularMetric, rxBytesCellularMetric *clientmetric.Metric
@@ -122,6 +123,9 @@ func withSockStats(ctx context.Context, label Label, logf logger.Logf) context.C
 	}
 
 	didRead := func(n int) {
+		if n > 0 {
+			counters.rxCalls.Add(1)
+		}
 		counters.rxBytes.Add(uint64(n))
 		counters.rxBytesMetric.Add(int64(n))
 		sockStats.rxBytesMetric.Add(int64(n))
@@ -139,6 +143,9 @@ func withSockStats(ctx context.Context, label Label, logf logger.Logf) context.C
 		}
 	}
 	didWrite := func(n int) {
+		if n > 0 {
+			counters.txCalls.Add(1)
+		}
 		counters.txBytes.Add(uint64(n))
 		counters.txBytesMetric.Add(int64(n))
 		sockStats.txBytesMetric.Add(int64(n))
@@ -186,6 +193,8 @@ func get() *SockStats {
 		r.Stats[label] = SockStat{
 			TxBytes: counters.txBytes.Load(),
 			RxBytes: counters.rxBytes.Load(),
+			TxCalls: counters.txCalls.Load(),
+			RxCalls: counters.rxCalls.Load(),
 		}
 	}
 
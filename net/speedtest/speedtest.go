@@ -6,6 +6,8 @@
 package speedtest
 
 import (
+	"math"
+	"slices"
 	"time"
 )
 
@@ -14,10 +16,18 @@
 	MinDuration     = 5 * time.Second       // minimum duration for a test
 	DefaultDuration = MinDuration           // default duration for a test
 	MaxDuration     = 30 * time.Second      // maximum duration for a test
+	DefaultWarmup   = time.Second           // default warmup period before a test
+	MaxWarmup       = 10 * time.Second      // maximum warmup period before a test
 	version         = 2                     // value used when comparing client and server versions
 	increment       = time.Second           // increment to display results for, in seconds
 	minInterval     = 10 * time.Millisecond // minimum interval length for a result to be included
 	DefaultPort     = 20333
+
+	// ALPN is the TLS application-layer protocol that speedtest clients and
+	// servers negotiate when running over TLS.
+	ALPN = "ts-speedtest"
+
+	handshakeTimeout = 10 * time.Second // maximum time allowed for a TLS handshake
 )
 
 // config is the initial message sent to the server, that contains information on how to
@@ -26,12 +36,20 @@ type config struct {
 	Version      int           `json:"version"`
 	TestDuration time.Duration `json:"time"`
 	Direction    Direction     `json:"direction"`
+
+	// Warmup is how long data is sent before the test proper starts,
+	// to let TCP get past slow start. Its bytes are excluded from the
+	// interval and total results. The client only uses the warmup
+	// period if the server echoes it back in its configResponse; older
+	// servers don't know about it.
+	Warmup time.Duration `json:"warmup,omitempty"`
 }
 
 // configResponse is the response to the testConfig message. If the server has an
 // error with the config, the Error variable will hold that error value.
 type configResponse struct {
-	Error string `json:"error,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	Warmup time.Duration `json:"warmup,omitempty"` // the warmup period the server agreed to
 }
 
 // This represents the Result of a speedtest within a specific interval
@@ -40,6 +58,7 @@ type Result struct {
 	IntervalStart time.Time // start of the interval
 	IntervalEnd   time.Time // end of the interval
 	Total         bool      // if true, this result struct represents the entire test, rather than a segment of the test
+	Warmup        bool      // if true, this result struct represents the warmup period, which the other results exclude
 }
 
 func (r Result) MBitsPerSecond() float64 {
@@ -58,6 +77,63 @@ func (r Result) Interval() time.Duration {
 	return r.IntervalEnd.Sub(r.IntervalStart)
 }
 
+// Summary holds statistics over the per-interval throughput of a test, in
+// megabits per second. A mean alone hides periodic stalls; the minimum and
+// low percentiles show them.
+type Summary struct {
+	Intervals int // number of non-total intervals summarized
+
+	Mean   float64
+	Min    float64
+	Max    float64
+	StdDev float64 // population standard deviation
+	P50    float64
+	P90    float64
+	P99    float64
+}
+
+// Summarize computes a Summary over the non-total, non-warmup results. It
+// returns the zero Summary if there are none.
+func Summarize(results []Result) Summary {
+	var rates []float64
+	for _, r := range results {
+		if !r.Total && !r.Warmup && r.Interval() > 0 {
+			rates = append(rates, r.MBitsPerSecond())
+		}
+	}
+	if len(rates) == 0 {
+		return Summary{}
+	}
+	slices.Sort(rates)
+
+	var sum float64
+	for _, v := range rates {
+		sum += v
+	}
+	mean := sum / float64(len(rates))
+	var sq float64
+	for _, v := range rates {
+		sq += (v - mean) * (v - mean)
+	}
+	return Summary{
+		Intervals: len(rates),
+		Mean:      mean,
+		Min:       rates[0],
+		Max:       rates[len(rates)-1],
+		StdDev:    math.Sqrt(sq / float64(len(rates))),
+		P50:       percentile(rates, 50),
+		P90:       percentile(rates, 90),
+		P99:       percentile(rates, 99),
+	}
+}
+
+// percentile returns the p-th percentile of sorted, which must be sorted
+// and non-empty, using the nearest-rank method.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
 type Direction int
 
 const (
@@ -4,6 +4,7 @@
 package speedtest
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"net"
@@ -13,29 +14,65 @@
 // RunClient dials the given address and starts a speedtest.
 // It returns any errors that come up in the tests.
 // If there are no errors in the test, it returns a slice of results.
-func RunClient(direction Direction, duration time.Duration, host string) ([]Result, error) {
+//
+// The test is preceded by a warmup period of the given length, if the
+// server supports it, whose bytes are only reported in a separate Result
+// with Warmup set.
+func RunClient(direction Direction, duration, warmup time.Duration, host string) ([]Result, error) {
 	conn, err := net.Dial("tcp", host)
 	if err != nil {
 		return nil, err
 	}
+	return runClient(conn, direction, duration, warmup)
+}
+
+// RunClientTLS is like RunClient, but connects to a server started with
+// ServeTLS. The provided configuration is used to verify the server's
+// certificate; if its ServerName is empty, the host part of host is used.
+func RunClientTLS(direction Direction, duration, warmup time.Duration, host string, tlsConf *tls.Config) ([]Result, error) {
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{ALPN}
+	if tlsConf.ServerName == "" {
+		hostname, _, err := net.SplitHostPort(host)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.ServerName = hostname
+	}
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	tc := tls.Client(conn, tlsConf)
+	if err := handshake(tc); err != nil {
+		tc.Close()
+		return nil, err
+	}
+	return runClient(tc, direction, duration, warmup)
+}
 
-	conf := config{TestDuration: duration, Version: version, Direction: direction}
+// runClient starts a speedtest on the already-established conn, closing it
+// when done.
+func runClient(conn net.Conn, direction Direction, duration, warmup time.Duration) ([]Result, error) {
+	conf := config{TestDuration: duration, Version: version, Direction: direction, Warmup: warmup}
 
 	defer conn.Close()
 	encoder := json.NewEncoder(conn)
 
-	if err = encoder.Encode(conf); err != nil {
+	if err := encoder.Encode(conf); err != nil {
 		return nil, err
 	}
 
 	var response configResponse
 	decoder := json.NewDecoder(conn)
-	if err = decoder.Decode(&response); err != nil {
+	if err := decoder.Decode(&response); err != nil {
 		return nil, err
 	}
 	if response.Error != "" {
 		return nil, errors.New(response.Error)
 	}
+	// Only warm up if the server agreed to.
+	conf.Warmup = response.Warmup
 
-	return doTest(conn, conf)
+	return doTest(conn, conf, false)
 }
@@ -5,6 +5,7 @@
 
 import (
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,6 +19,25 @@
 // this function only returns if any of the speedtests return with errors, or if the
 // listener is closed.
 func Serve(l net.Listener) error {
+	var s Server
+	return s.Serve(l)
+}
+
+// Server is a speedtest server with non-default options. The zero value
+// is a server like the one that Serve runs.
+type Server struct {
+	// ZeroCopy is whether to send test data with sendfile(2) from an
+	// in-memory file prefilled with random data, rather than writing it
+	// from a buffer, so that sending doesn't copy it through user space.
+	// On fast links, the copies can limit the measured throughput.
+	//
+	// It's only supported on Linux and for plain TCP connections; the
+	// server falls back to the usual writes otherwise.
+	ZeroCopy bool
+}
+
+// Serve is like the package-level Serve, using s's options.
+func (s *Server) Serve(l net.Listener) error {
 	for {
 		conn, err := l.Accept()
 		if errors.Is(err, net.ErrClosed) {
@@ -26,20 +46,35 @@ func Serve(l net.Listener) error {
 		if err != nil {
 			return err
 		}
-		err = handleConnection(conn)
+		err = s.handleConnection(conn)
 		if err != nil {
 			return err
 		}
 	}
 }
 
+// ServeTLS is like Serve, but speaks TLS to clients using the provided
+// configuration, which must contain a certificate (for example, the node's
+// certificate from tailscale.GetCertificate). Clients are required to
+// negotiate the ALPN protocol.
+func ServeTLS(l net.Listener, conf *tls.Config) error {
+	conf = conf.Clone()
+	conf.NextProtos = []string{ALPN}
+	return Serve(tls.NewListener(l, conf))
+}
+
 // handleConnection handles the initial exchange between the server and the client.
 // It reads the testconfig message into a config struct. If any errors occur with
 // the testconfig (specifically, if there is a version mismatch), it will return those
 // errors to the client with a configResponse. After the exchange, it will start
 // the speed test.
-func handleConnection(conn net.Conn) error {
+func (s *Server) handleConnection(conn net.Conn) error {
 	defer conn.Close()
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := handshake(tc); err != nil {
+			return err
+		}
+	}
 	var conf config
 
 	decoder := json.NewDecoder(conn)
@@ -60,34 +95,65 @@ func handleConnection(conn net.Conn) error {
 		encoder.Encode(configResponse{Error: err.Error()})
 		return err
 	}
+	if conf.Warmup < 0 || conf.Warmup > MaxWarmup {
+		err = fmt.Errorf("warmup %v not within 0 and %v", conf.Warmup, MaxWarmup)
+		encoder.Encode(configResponse{Error: err.Error()})
+		return err
+	}
 
 	// Start the test
-	encoder.Encode(configResponse{})
-	_, err = doTest(conn, conf)
+	encoder.Encode(configResponse{Warmup: conf.Warmup})
+	_, err = doTest(conn, conf, s.ZeroCopy)
 	return err
 }
 
+// handshake completes the TLS handshake on conn and verifies that the peer
+// negotiated the speedtest ALPN protocol.
+func handshake(conn *tls.Conn) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake: %w", err)
+	}
+	if p := conn.ConnectionState().NegotiatedProtocol; p != ALPN {
+		return fmt.Errorf("TLS peer negotiated protocol %q, want %q", p, ALPN)
+	}
+	return nil
+}
+
 // TODO include code to detect whether the code is direct vs DERP
 
 // doTest contains the code to run both the upload and download speedtest.
 // the direction value in the config parameter determines which test to run.
-func doTest(conn net.Conn, conf config) ([]Result, error) {
+// If the config has a warmup period, the first result covers it, and the
+// others start once it's over. If zeroCopy is set, uploads use a
+// zeroCopySender if possible.
+func doTest(conn net.Conn, conf config, zeroCopy bool) ([]Result, error) {
 	bufferData := make([]byte, blockSize)
+	send := func() (int, error) { return conn.Write(bufferData) }
 
 	intervalBytes := 0
 	totalBytes := 0
+	warmupBytes := 0
+	warmingUp := conf.Warmup > 0
 
 	var currentTime time.Time
 	var results []Result
 
 	if conf.Direction == Download {
-		conn.SetReadDeadline(time.Now().Add(conf.TestDuration).Add(5 * time.Second))
+		conn.SetReadDeadline(time.Now().Add(conf.Warmup + conf.TestDuration).Add(5 * time.Second))
 	} else {
-		_, err := rand.Read(bufferData)
-		if err != nil {
+		var zs *zeroCopySender
+		if zeroCopy {
+			// On error, fall back to writing from bufferData.
+			zs, _ = newZeroCopySender(conn, blockSize)
+		}
+		if zs != nil {
+			defer zs.Close()
+			send = zs.send
+		} else if _, err := rand.Read(bufferData); err != nil {
 			return nil, err
 		}
-
 	}
 
 	startTime := time.Now()
@@ -109,15 +175,27 @@ func doTest(conn net.Conn, conf config) ([]Result, error) {
 				return nil, fmt.Errorf("unexpected error has occurred: %w", err)
 			}
 		} else {
-			n, err = conn.Write(bufferData)
+			n, err = send()
 			if err != nil {
 				// If the write failed, there is most likely something wrong with the connection.
 				return nil, fmt.Errorf("upload failed: %w", err)
 			}
 		}
+		currentTime = time.Now()
+		if warmingUp {
+			warmupBytes += n
+			if currentTime.Sub(startTime) < conf.Warmup {
+				continue
+			}
+			// The warmup is over; the test proper starts now.
+			results = append(results, Result{Bytes: warmupBytes, IntervalStart: startTime, IntervalEnd: currentTime, Warmup: true})
+			warmingUp = false
+			startTime = currentTime
+			lastCalculated = currentTime
+			continue
+		}
 		intervalBytes += n
 
-		currentTime = time.Now()
 		// checks if the current time is more or equal to the lastCalculated time plus the increment
 		if currentTime.Sub(lastCalculated) >= increment {
 			results = append(results, Result{Bytes: intervalBytes, IntervalStart: lastCalculated, IntervalEnd: currentTime, Total: false})
@@ -131,6 +209,14 @@ func doTest(conn net.Conn, conf config) ([]Result, error) {
 		}
 	}
 
+	if warmingUp {
+		// The peer stopped before the warmup was over.
+		if currentTime.Sub(startTime) > minInterval {
+			results = append(results, Result{Bytes: warmupBytes, IntervalStart: startTime, IntervalEnd: currentTime, Warmup: true})
+		}
+		return results, nil
+	}
+
 	// get last segment
 	if currentTime.Sub(lastCalculated) > minInterval {
 		results = append(results, Result{Bytes: intervalBytes, IntervalStart: lastCalculated, IntervalEnd: currentTime, Total: false})
@@ -4,6 +4,14 @@
 package speedtest
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math"
+	"math/big"
 	"net"
 	"testing"
 	"time"
@@ -39,7 +47,7 @@ type state struct {
 
 	t.Run("download test", func(t *testing.T) {
 		// conduct a download test
-		results, err := RunClient(Download, DefaultDuration, serverIP)
+		results, err := RunClient(Download, DefaultDuration, 0, serverIP)
 
 		if err != nil {
 			t.Fatal("download test failed:", err)
@@ -57,7 +65,7 @@ type state struct {
 
 	t.Run("upload test", func(t *testing.T) {
 		// conduct an upload test
-		results, err := RunClient(Upload, DefaultDuration, serverIP)
+		results, err := RunClient(Upload, DefaultDuration, 0, serverIP)
 
 		if err != nil {
 			t.Fatal("upload test failed:", err)
@@ -81,3 +89,135 @@ type state struct {
 		t.Error("server error:", err)
 	}
 }
+
+func TestDownloadTLS(t *testing.T) {
+	cert, roots := newTestCert(t, "speedtest.test")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- ServeTLS(l, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}()
+
+	results, err := RunClientTLS(Download, MinDuration, 0, l.Addr().String(), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "speedtest.test",
+	})
+	if err != nil {
+		t.Fatal("download test failed:", err)
+	}
+	if want := int(MinDuration.Seconds()) + 1; len(results) < want {
+		t.Fatalf("download results: expected length: %d, actual length: %d", want, len(results))
+	}
+
+	l.Close()
+	if err := <-serverErr; err != nil {
+		t.Error("server error:", err)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go Serve(l)
+
+	for _, dir := range []Direction{Download, Upload} {
+		t.Run(dir.String(), func(t *testing.T) {
+			results, err := RunClient(dir, MinDuration, DefaultWarmup, l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) < 3 {
+				t.Fatalf("got %d results; want at least 3", len(results))
+			}
+			warmup, total := results[0], results[len(results)-1]
+			if !warmup.Warmup || warmup.Total {
+				t.Fatalf("first result = %+v; want warmup", warmup)
+			}
+			if warmup.Interval() < DefaultWarmup {
+				t.Errorf("warmup lasted %v; want at least %v", warmup.Interval(), DefaultWarmup)
+			}
+			if !total.Total || total.Warmup {
+				t.Fatalf("last result = %+v; want total", total)
+			}
+			// The test proper starts where the warmup ends.
+			if !total.IntervalStart.Equal(warmup.IntervalEnd) || !results[1].IntervalStart.Equal(warmup.IntervalEnd) {
+				t.Errorf("test starts at %v and %v; want warmup end %v", total.IntervalStart, results[1].IntervalStart, warmup.IntervalEnd)
+			}
+			if got, want := Summarize(results).Intervals, len(results)-2; got != want {
+				t.Errorf("Summarize counted %d intervals; want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	start := time.Unix(0, 0)
+	var results []Result
+	// 1 second intervals of 1..10 Mbit/s, then a total.
+	for i := range 10 {
+		results = append(results, Result{
+			Bytes:         (i + 1) * 125000,
+			IntervalStart: start.Add(time.Duration(i) * time.Second),
+			IntervalEnd:   start.Add(time.Duration(i+1) * time.Second),
+		})
+	}
+	results = append(results, Result{Bytes: 55 * 125000, IntervalStart: start, IntervalEnd: start.Add(10 * time.Second), Total: true})
+
+	got := Summarize(results)
+	want := Summary{
+		Intervals: 10,
+		Mean:      5.5,
+		Min:       1,
+		Max:       10,
+		StdDev:    math.Sqrt(8.25),
+		P50:       5,
+		P90:       9,
+		P99:       10,
+	}
+	if got != want {
+		t.Errorf("Summarize = %+v; want %+v", got, want)
+	}
+
+	if got := Summarize(nil); got != (Summary{}) {
+		t.Errorf("Summarize(nil) = %+v; want zero", got)
+	}
+}
+
+// newTestCert returns a self-signed certificate for hostname and a pool
+// containing it.
+func newTestCert(t *testing.T, hostname string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+}
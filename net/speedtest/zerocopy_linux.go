@@ -0,0 +1,58 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package speedtest
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// zeroCopySender sends blocks of random data to a TCP connection with
+// sendfile(2), from an in-memory file prefilled with the data, so that the
+// kernel sends it without copying it through user space.
+type zeroCopySender struct {
+	conn *net.TCPConn
+	f    *os.File
+	size int64
+}
+
+// newZeroCopySender returns a zeroCopySender that sends size bytes to conn
+// at a time. It fails if conn isn't a TCP connection.
+func newZeroCopySender(conn net.Conn, size int) (*zeroCopySender, error) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("zero-copy sends need a TCP connection")
+	}
+	fd, err := unix.MemfdCreate("speedtest", unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "speedtest")
+	if _, err := io.CopyN(f, rand.Reader, int64(size)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &zeroCopySender{conn: tc, f: f, size: int64(size)}, nil
+}
+
+// send sends one block of data, returning the number of bytes sent.
+func (s *zeroCopySender) send() (int, error) {
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	// TCPConn.ReadFrom uses sendfile(2) when reading from a file, or from
+	// an io.LimitedReader of one.
+	n, err := s.conn.ReadFrom(&io.LimitedReader{R: s.f, N: s.size})
+	return int(n), err
+}
+
+// Close releases the file that s sends from.
+func (s *zeroCopySender) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package speedtest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestZeroCopySender(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if _, err := newZeroCopySender(&net.UnixConn{}, 1); err == nil {
+		t.Error("newZeroCopySender succeeded for a non-TCP connection")
+	}
+
+	const size = 64 << 10
+	zs, err := newZeroCopySender(server, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zs.Close()
+	want := make([]byte, size)
+	if _, err := zs.f.ReadAt(want, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		for range 2 {
+			n, err := zs.send()
+			if err == nil && n != size {
+				t.Errorf("send = %d bytes; want %d", n, size)
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- nil
+	}()
+	got := make([]byte, 2*size)
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:size], want) || !bytes.Equal(got[size:], want) {
+		t.Error("received data doesn't match the file's")
+	}
+}
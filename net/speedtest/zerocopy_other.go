@@ -0,0 +1,24 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package speedtest
+
+import (
+	"errors"
+	"net"
+	"runtime"
+)
+
+type zeroCopySender struct{}
+
+func newZeroCopySender(net.Conn, int) (*zeroCopySender, error) {
+	return nil, errors.New("zero-copy sends not supported on " + runtime.GOOS)
+}
+
+func (*zeroCopySender) send() (int, error) {
+	return 0, errors.New("zero-copy sends not supported on " + runtime.GOOS)
+}
+
+func (*zeroCopySender) Close() error { return nil }
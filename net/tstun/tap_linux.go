@@ -110,11 +110,9 @@ func (t *Wrapper) handleTAPFrame(ethBuf []byte) bool {
 		}
 		return consumePacket // filter out packet we should ignore
 	case etherTypeIPv6:
-		// TODO: support DHCPv6/ND/etc later. For now pass all to WireGuard.
-		if tapDebug {
-			t.logf("tap: ignoring IPv6 %v", et)
-		}
-		return passOnPacket
+		// TODO: support DHCPv6/SLAAC later. For now answer neighbor
+		// discovery and pass everything else to WireGuard.
+		return t.handleNDP(ethBuf)
 	case etherTypeIPv4:
 		if len(ethBuf) < ethernetFrameSize+ipv4HeaderLen {
 			// Bogus IPv4. Eat.
@@ -165,7 +163,7 @@ func (t *Wrapper) handleTAPFrame(ethBuf []byte) bool {
 			copy(res.ProtocolAddressTarget(), req.ProtocolAddressSender())
 
 			// TODO(raggi): reduce allocs!
-			n, err := t.tdev.Write([][]byte{buf}, 0)
+			n, err := t.device().Write([][]byte{buf}, 0)
 			if tapDebug {
 				t.logf("tap: wrote ARP reply %v, %v", n, err)
 			}
@@ -175,6 +173,106 @@ func (t *Wrapper) handleTAPFrame(ethBuf []byte) bool {
 	}
 }
 
+// handleNDP handles ethBuf, an IPv6 ethernet frame, if it's an IPv6
+// Neighbor Discovery (RFC 4861) message, and reports whether it's been
+// handled.
+//
+// Like with ARP, we answer every neighbor solicitation with our own MAC,
+// as we're the way to every address in the tailnet, and remember the
+// sender's MAC as where to send packets from the tailnet.
+func (t *Wrapper) handleNDP(ethBuf []byte) bool {
+	ip := header.IPv6(ethBuf[ethernetFrameSize:])
+	if !ip.IsValid(len(ip)) {
+		// Bogus IPv6. Eat.
+		if tapDebug {
+			t.logf("tap: short ipv6")
+		}
+		return consumePacket
+	}
+	if ip.TransportProtocol() != header.ICMPv6ProtocolNumber || ip.HopLimit() != header.NDPHopLimit {
+		// Not NDP, which is always ICMPv6 without extension
+		// headers, with a hop limit of 255.
+		return passOnPacket
+	}
+	icmp := header.ICMPv6(ip.Payload())
+	if len(icmp) < header.ICMPv6HeaderSize {
+		return consumePacket
+	}
+	switch icmp.Type() {
+	default:
+		return passOnPacket
+	case header.ICMPv6RouterSolicit, header.ICMPv6RouterAdvert, header.ICMPv6NeighborAdvert, header.ICMPv6RedirectMsg:
+		// Nothing for us to do; don't send it over WireGuard.
+		return consumePacket
+	case header.ICMPv6NeighborSolicit:
+	}
+	if len(icmp) < header.ICMPv6NeighborSolicitMinimumSize {
+		return consumePacket
+	}
+
+	var srcMAC [6]byte
+	copy(srcMAC[:], ethBuf[6:12])
+	res := neighborAdvertFor(srcMAC, ip)
+	if res == nil {
+		return consumePacket
+	}
+	if old := t.destMAC(); old != srcMAC {
+		t.destMACAtomic.Store(srcMAC)
+	}
+	n, err := t.device().Write([][]byte{res}, 0)
+	if tapDebug {
+		t.logf("tap: wrote NDP neighbor advertisement %v, %v", n, err)
+	}
+	return consumePacket
+}
+
+// neighborAdvertFor returns the ethernet frame of the neighbor
+// advertisement that answers ip, a valid neighbor solicitation sent by
+// srcMAC, saying that the solicited address is at ourMAC. It returns nil
+// if ip shouldn't be answered.
+func neighborAdvertFor(srcMAC [6]byte, ip header.IPv6) []byte {
+	ns := header.NDPNeighborSolicit(header.ICMPv6(ip.Payload()).MessageBody())
+	src, target := ip.SourceAddress(), ns.TargetAddress()
+	if src.Unspecified() || target == src || header.IsV6MulticastAddress(target) {
+		// Duplicate address detection, or the sender asking about
+		// itself, or invalid. None are ours to answer.
+		return nil
+	}
+
+	opts := header.NDPOptionsSerializer{
+		header.NDPTargetLinkLayerAddressOption(tcpip.LinkAddress(ourMAC[:])),
+	}
+	icmpLen := header.ICMPv6NeighborAdvertMinimumSize + opts.Length()
+	buf := make([]byte, header.EthernetMinimumSize+header.IPv6MinimumSize+icmpLen)
+	eth := header.Ethernet(buf)
+	eth.Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress(ourMAC[:]),
+		DstAddr: tcpip.LinkAddress(srcMAC[:]),
+		Type:    header.IPv6ProtocolNumber,
+	})
+	res := header.IPv6(buf[header.EthernetMinimumSize:])
+	res.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(icmpLen),
+		TransportProtocol: header.ICMPv6ProtocolNumber,
+		HopLimit:          header.NDPHopLimit,
+		SrcAddr:           target,
+		DstAddr:           src,
+	})
+	icmp := header.ICMPv6(res.Payload())
+	icmp.SetType(header.ICMPv6NeighborAdvert)
+	na := header.NDPNeighborAdvert(icmp.MessageBody())
+	na.SetSolicitedFlag(true)
+	na.SetOverrideFlag(true)
+	na.SetTargetAddress(target)
+	na.Options().Serialize(opts)
+	icmp.SetChecksum(header.ICMPv6Checksum(header.ICMPv6ChecksumParams{
+		Header: icmp,
+		Src:    target,
+		Dst:    src,
+	}))
+	return buf
+}
+
 // TODO(bradfitz): remove these hard-coded values and move from a /24 to a /10 CGNAT as the range.
 const theClientIP = "100.70.145.3" // TODO: make dynamic from netmap
 const routerIP = "100.70.145.1"    // must be in same netmask (currently hack at /24) as theClientIP
@@ -251,7 +349,7 @@ func (t *Wrapper) handleDHCPRequest(ethBuf []byte) bool {
 		)
 
 		// TODO(raggi): reduce allocs!
-		n, err := t.tdev.Write([][]byte{pkt}, 0)
+		n, err := t.device().Write([][]byte{pkt}, 0)
 		if tapDebug {
 			t.logf("tap: wrote DHCP OFFER %v, %v", n, err)
 		}
@@ -279,7 +377,7 @@ func (t *Wrapper) handleDHCPRequest(ethBuf []byte) bool {
 			netip.AddrPortFrom(netaddr.IPv4(255, 255, 255, 255), 68), // dst
 		)
 		// TODO(raggi): reduce allocs!
-		n, err := t.tdev.Write([][]byte{pkt}, 0)
+		n, err := t.device().Write([][]byte{pkt}, 0)
 		if tapDebug {
 			t.logf("tap: wrote DHCP ACK %v, %v", n, err)
 		}
@@ -0,0 +1,93 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_tap
+
+package tstun
+
+import (
+	"net/netip"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestNeighborAdvertFor(t *testing.T) {
+	clientMAC := [6]byte{0x02, 0, 0, 0, 0, 1}
+	clientIP := tcpip.AddrFrom16(netip.MustParseAddr("fd7a:115c:a1e0::1").As16())
+	peerIP := tcpip.AddrFrom16(netip.MustParseAddr("fd7a:115c:a1e0::2").As16())
+
+	solicit := func(src, target tcpip.Address) header.IPv6 {
+		buf := make([]byte, header.IPv6MinimumSize+header.ICMPv6NeighborSolicitMinimumSize)
+		ip := header.IPv6(buf)
+		ip.Encode(&header.IPv6Fields{
+			PayloadLength:     header.ICMPv6NeighborSolicitMinimumSize,
+			TransportProtocol: header.ICMPv6ProtocolNumber,
+			HopLimit:          header.NDPHopLimit,
+			SrcAddr:           src,
+			DstAddr:           header.SolicitedNodeAddr(target),
+		})
+		icmp := header.ICMPv6(ip.Payload())
+		icmp.SetType(header.ICMPv6NeighborSolicit)
+		ns := header.NDPNeighborSolicit(icmp.MessageBody())
+		ns.SetTargetAddress(target)
+		return ip
+	}
+
+	if got := neighborAdvertFor(clientMAC, solicit(header.IPv6Any, peerIP)); got != nil {
+		t.Errorf("answered duplicate address detection")
+	}
+	if got := neighborAdvertFor(clientMAC, solicit(clientIP, clientIP)); got != nil {
+		t.Errorf("answered solicitation for the sender's own address")
+	}
+
+	frame := neighborAdvertFor(clientMAC, solicit(clientIP, peerIP))
+	if frame == nil {
+		t.Fatal("no neighbor advertisement")
+	}
+	eth := header.Ethernet(frame)
+	if got, want := eth.DestinationAddress(), tcpip.LinkAddress(clientMAC[:]); got != want {
+		t.Errorf("ethernet dst = %v; want %v", got, want)
+	}
+	if got, want := eth.SourceAddress(), tcpip.LinkAddress(ourMAC); got != want {
+		t.Errorf("ethernet src = %v; want %v", got, want)
+	}
+	if got := eth.Type(); got != header.IPv6ProtocolNumber {
+		t.Errorf("ethernet type = %v; want IPv6", got)
+	}
+
+	ip := header.IPv6(frame[header.EthernetMinimumSize:])
+	if !ip.IsValid(len(ip)) {
+		t.Fatal("invalid IPv6 packet")
+	}
+	if ip.SourceAddress() != peerIP || ip.DestinationAddress() != clientIP {
+		t.Errorf("IPv6 %v -> %v; want %v -> %v", ip.SourceAddress(), ip.DestinationAddress(), peerIP, clientIP)
+	}
+	if ip.HopLimit() != header.NDPHopLimit {
+		t.Errorf("hop limit = %d; want %d", ip.HopLimit(), header.NDPHopLimit)
+	}
+	icmp := header.ICMPv6(ip.Payload())
+	if icmp.Type() != header.ICMPv6NeighborAdvert {
+		t.Fatalf("ICMPv6 type = %v; want neighbor advertisement", icmp.Type())
+	}
+	if got, want := icmp.Checksum(), header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: icmp, Src: peerIP, Dst: clientIP}); got != want {
+		t.Errorf("checksum = %#x; want %#x", got, want)
+	}
+	na := header.NDPNeighborAdvert(icmp.MessageBody())
+	if na.TargetAddress() != peerIP || !na.SolicitedFlag() || !na.OverrideFlag() || na.RouterFlag() {
+		t.Errorf("bad advertisement: target=%v solicited=%v override=%v router=%v", na.TargetAddress(), na.SolicitedFlag(), na.OverrideFlag(), na.RouterFlag())
+	}
+	it, err := na.Options().Iter(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt, done, err := it.Next()
+	if err != nil || done {
+		t.Fatalf("reading option: done=%v, err=%v", done, err)
+	}
+	tlla, ok := opt.(header.NDPTargetLinkLayerAddressOption)
+	if !ok || tlla.EthernetAddress() != tcpip.LinkAddress(ourMAC) {
+		t.Errorf("option = %v; want target link-layer address %v", opt, ourMAC)
+	}
+}
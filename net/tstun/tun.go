@@ -14,12 +14,18 @@
 	"time"
 
 	"github.com/tailscale/wireguard-go/tun"
+	"tailscale.com/envknob"
 	"tailscale.com/types/logger"
 )
 
 // createTAP is non-nil on Linux.
 var createTAP func(tapName, bridgeName string) (tun.Device, error)
 
+// experimentalTAP reports whether TAP devices, for bridging tailnet traffic
+// into a layer 2 network such as a VM or container bridge, may be used.
+// TAP support is experimental, so it's opt-in.
+var experimentalTAP = envknob.RegisterBool("TS_EXPERIMENTAL_TAP")
+
 // New returns a tun.Device for the requested device name, along with
 // the OS-dependent name that was allocated to the device.
 func New(logf logger.Logf, tunName string) (tun.Device, string, error) {
@@ -32,6 +38,9 @@ func New(logf logger.Logf, tunName string) (tun.Device, string, error) {
 		if createTAP == nil { // if the ts_omit_tap tag is used
 			return nil, "", errors.New("tap is not supported in this build")
 		}
+		if !experimentalTAP() {
+			return nil, "", errors.New("tap is experimental; set TS_EXPERIMENTAL_TAP=true to use it")
+		}
 		f := strings.Split(tunName, ":")
 		var tapName, bridgeName string
 		switch len(f) {
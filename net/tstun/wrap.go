@@ -81,10 +81,20 @@
 type Wrapper struct {
 	logf        logger.Logf
 	limitedLogf logger.Logf // aggressively rate-limited logf used for potentially high volume errors
-	// tdev is the underlying Wrapper device.
-	tdev  tun.Device
+	// tdev is the underlying Wrapper device. It only changes in
+	// ReplaceDevice.
+	tdev  syncs.AtomicValue[tun.Device]
 	isTAP bool // whether tdev is a TAP device
 
+	// tdevMu protects tdevReplacing and tdevReplaced.
+	tdevMu sync.Mutex
+	// tdevReplacing is whether ReplaceDevice has closed the old device
+	// but not yet installed its replacement.
+	tdevReplacing bool
+	// tdevReplaced is closed, and then replaced with a new channel, each
+	// time ReplaceDevice finishes.
+	tdevReplaced chan struct{}
+
 	started atomic.Bool   // whether Start has been called
 	startCh chan struct{} // closed in Start
 
@@ -250,7 +260,6 @@ func wrap(logf logger.Logf, tdev tun.Device, isTAP bool) *Wrapper {
 		logf:        logf,
 		limitedLogf: logger.RateLimitedFn(logf, 1*time.Minute, 2, 10),
 		isTAP:       isTAP,
-		tdev:        tdev,
 		// bufferConsumed is conceptually a condition variable:
 		// a goroutine should not block when setting it, even with no listeners.
 		bufferConsumed: make(chan struct{}, 1),
@@ -262,7 +271,10 @@ func wrap(logf logger.Logf, tdev tun.Device, isTAP bool) *Wrapper {
 		// TODO(dmytro): (highly rate-limited) hexdumps should happen on unknown packets.
 		filterFlags: filter.LogAccepts | filter.LogDrops,
 		startCh:     make(chan struct{}),
+
+		tdevReplaced: make(chan struct{}),
 	}
+	w.tdev.Store(tdev)
 
 	w.vectorBuffer = make([][]byte, tdev.BatchSize())
 	for i := range w.vectorBuffer {
@@ -275,13 +287,70 @@ func wrap(logf logger.Logf, tdev tun.Device, isTAP bool) *Wrapper {
 	w.bufferConsumed <- struct{}{}
 	w.noteActivity()
 
-	if sw, ok := w.tdev.(setWrapperer); ok {
+	if sw, ok := tdev.(setWrapperer); ok {
 		sw.setWrapper(w)
 	}
 
 	return w
 }
 
+// device returns the current underlying device.
+func (t *Wrapper) device() tun.Device {
+	return t.tdev.Load()
+}
+
+// deviceAndWatch returns the current underlying device and a channel that is
+// closed when ReplaceDevice next finishes.
+func (t *Wrapper) deviceAndWatch() (tun.Device, chan struct{}) {
+	t.tdevMu.Lock()
+	defer t.tdevMu.Unlock()
+	return t.tdev.Load(), t.tdevReplaced
+}
+
+// deviceReplaced reports whether dev is being or has been replaced by
+// ReplaceDevice, in which case errors from it are expected.
+func (t *Wrapper) deviceReplaced(dev tun.Device) bool {
+	t.tdevMu.Lock()
+	defer t.tdevMu.Unlock()
+	return t.tdevReplacing || t.tdev.Load() != dev
+}
+
+// ReplaceDevice closes the underlying device and replaces it with the one
+// returned by open, which is called after the old device is closed so that
+// it can re-create an interface of the same name. The Wrapper, its filters
+// and hooks, and the wireguard-go device reading from it are kept; reads
+// that fail because the old device was closed are retried on the new one.
+//
+// The new device must be of the same kind (TUN or TAP) as the old one. If
+// open fails, the Wrapper is left with the closed old device.
+func (t *Wrapper) ReplaceDevice(open func() (tun.Device, error)) error {
+	if t.isClosed() {
+		return errors.New("tstun: Wrapper closed")
+	}
+	t.tdevMu.Lock()
+	t.tdevReplacing = true
+	old := t.tdev.Load()
+	t.tdevMu.Unlock()
+
+	if err := old.Close(); err != nil {
+		t.logf("ReplaceDevice: closing old device: %v", err)
+	}
+	dev, err := open()
+
+	t.tdevMu.Lock()
+	defer t.tdevMu.Unlock()
+	t.tdevReplacing = false
+	if err == nil {
+		t.tdev.Store(dev)
+		if sw, ok := dev.(setWrapperer); ok {
+			sw.setWrapper(t)
+		}
+	}
+	close(t.tdevReplaced)
+	t.tdevReplaced = make(chan struct{})
+	return err
+}
+
 // now returns the current time, either by calling t.timeNow if set or time.Now
 // if not.
 func (t *Wrapper) now() time.Time {
@@ -339,7 +408,7 @@ func (t *Wrapper) Close() error {
 		t.outboundClosed = true
 		close(t.vectorOutbound)
 		t.outboundMu.Unlock()
-		err = t.tdev.Close()
+		err = t.device().Close()
 	})
 	return err
 }
@@ -355,12 +424,14 @@ func (t *Wrapper) isClosed() bool {
 }
 
 // pumpEvents copies events from t.tdev to t.eventsUpDown and t.eventsOther.
-// pumpEvents exits when t.tdev.events or t.closed is closed.
+// pumpEvents exits when t.tdev.events or t.closed is closed, unless t.tdev
+// was closed by ReplaceDevice, in which case it follows the new device.
 // pumpEvents closes t.eventsUpDown and t.eventsOther when it exits.
 func (t *Wrapper) pumpEvents() {
 	defer close(t.eventsUpDown)
 	defer close(t.eventsOther)
-	src := t.tdev.Events()
+	dev, replaced := t.deviceAndWatch()
+	src := dev.Events()
 	for {
 		// Retrieve an event from the TUN device.
 		var event tun.Event
@@ -368,9 +439,19 @@ func (t *Wrapper) pumpEvents() {
 		select {
 		case <-t.closed:
 			return
+		case <-replaced:
+			dev, replaced = t.deviceAndWatch()
+			src = dev.Events()
+			// Have wireguard-go pick up the new device's MTU.
+			event = tun.EventMTUUpdate
 		case event, ok = <-src:
 			if !ok {
-				return
+				if !t.deviceReplaced(dev) {
+					return
+				}
+				// Wait for the replacement device.
+				src = nil
+				continue
 			}
 		}
 
@@ -401,15 +482,15 @@ func (t *Wrapper) Events() <-chan tun.Event {
 }
 
 func (t *Wrapper) File() *os.File {
-	return t.tdev.File()
+	return t.device().File()
 }
 
 func (t *Wrapper) MTU() (int, error) {
-	return t.tdev.MTU()
+	return t.device().MTU()
 }
 
 func (t *Wrapper) Name() (string, error) {
-	return t.tdev.Name()
+	return t.device().Name()
 }
 
 const ethernetFrameSize = 14 // 2 six byte MACs, 2 bytes ethertype
@@ -436,7 +517,18 @@ func (t *Wrapper) pollVector() {
 			if t.isClosed() {
 				return
 			}
-			n, err = t.tdev.Read(t.vectorBuffer[:], sizes, readOffset)
+			dev, replaced := t.deviceAndWatch()
+			n, err = dev.Read(t.vectorBuffer[:], sizes, readOffset)
+			if err != nil && t.deviceReplaced(dev) {
+				// ReplaceDevice closed dev; retry on its replacement.
+				select {
+				case <-t.closed:
+					return
+				case <-replaced:
+				}
+				n, err = 0, nil
+				continue
+			}
 			if t.isTAP && tapDebug {
 				s := fmt.Sprintf("% x", t.vectorBuffer[0][:])
 				for strings.HasSuffix(s, " 00") {
@@ -1131,7 +1223,7 @@ func (t *Wrapper) tdevWrite(buffs [][]byte, offset int) (int, error) {
 			stats.UpdateRxVirtual((buffs)[i][offset:])
 		}
 	}
-	return t.tdev.Write(buffs, offset)
+	return t.device().Write(buffs, offset)
 }
 
 func (t *Wrapper) GetFilter() *filter.Filter {
@@ -1288,12 +1380,12 @@ func (t *Wrapper) InjectOutboundPacketBuffer(pkt *stack.PacketBuffer) error {
 }
 
 func (t *Wrapper) BatchSize() int {
-	return t.tdev.BatchSize()
+	return t.device().BatchSize()
 }
 
 // Unwrap returns the underlying tun.Device.
 func (t *Wrapper) Unwrap() tun.Device {
-	return t.tdev
+	return t.device()
 }
 
 // SetStatistics specifies a per-connection statistics aggregator.
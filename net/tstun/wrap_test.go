@@ -20,6 +20,7 @@
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tailscale/wireguard-go/tun"
 	"github.com/tailscale/wireguard-go/tun/tuntest"
 	"go4.org/mem"
 	"go4.org/netipx"
@@ -464,6 +465,66 @@ func TestClose(t *testing.T) {
 	}
 }
 
+func TestReplaceDevice(t *testing.T) {
+	chtun1, w := newChannelTUN(t.Logf, false)
+	defer w.Close()
+	// Wait for the first device's EventUp so the events pump is running
+	// on it before it's replaced.
+	if ev := <-w.EventsUpDown(); ev != tun.EventUp {
+		t.Fatalf("first event = %v; want EventUp", ev)
+	}
+	go func() {
+		for range w.EventsUpDown() {
+		}
+	}()
+
+	read := func(want string) {
+		t.Helper()
+		buffs := [][]byte{make([]byte, MaxPacketSize)}
+		sizes := make([]int, 1)
+		if _, err := w.Read(buffs, sizes, 0); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if got := string(buffs[0][:sizes[0]]); got != want {
+			t.Fatalf("read %q; want %q", got, want)
+		}
+	}
+	go func() { chtun1.Outbound <- []byte("old") }()
+	read("old")
+
+	chtun2 := tuntest.NewChannelTUN()
+	if err := w.ReplaceDevice(func() (tun.Device, error) {
+		return chtun2.TUN(), nil
+	}); err != nil {
+		t.Fatalf("ReplaceDevice: %v", err)
+	}
+	if got := w.Unwrap(); got != chtun2.TUN() {
+		t.Fatalf("Unwrap = %v; want new device", got)
+	}
+
+	// The read of the closed old device must not surface as an error.
+	go func() { chtun2.Outbound <- []byte("new") }()
+	read("new")
+
+	go func() {
+		if _, err := w.Write([][]byte{[]byte("in")}, 0); err != nil {
+			t.Errorf("write: %v", err)
+		}
+	}()
+	if got := string(<-chtun2.Inbound); got != "in" {
+		t.Errorf("new device got %q; want %q", got, "in")
+	}
+
+	select {
+	case ev := <-w.Events():
+		if ev != tun.EventMTUUpdate {
+			t.Errorf("event = %v; want EventMTUUpdate", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no MTU update event after ReplaceDevice")
+	}
+}
+
 func BenchmarkWrite(b *testing.B) {
 	b.ReportAllocs()
 	ftun, tun := newFakeTUN(b.Logf, true)
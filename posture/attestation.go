@@ -0,0 +1,132 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package posture
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"tailscale.com/hostinfo"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/opt"
+)
+
+// Attributes are the device posture attributes that we collect.
+type Attributes struct {
+	// SerialNumbers are the machine's serial numbers, as from
+	// GetSerialNumbers.
+	SerialNumbers []string `json:",omitempty"`
+
+	// OSVersion is the version of the operating system.
+	OSVersion string `json:",omitempty"`
+
+	// DiskEncrypted is whether the disk holding the root filesystem is
+	// encrypted, or empty if unknown.
+	DiskEncrypted opt.Bool `json:",omitempty"`
+}
+
+// Equal reports whether a and b are the same attributes.
+func (a Attributes) Equal(b Attributes) bool {
+	return slices.Equal(a.SerialNumbers, b.SerialNumbers) &&
+		a.OSVersion == b.OSVersion &&
+		a.DiskEncrypted == b.DiskEncrypted
+}
+
+// Collect collects the device's posture attributes.
+//
+// If the serial numbers can't be read, the error is returned along with
+// the rest of the attributes.
+func Collect(logf logger.Logf) (Attributes, error) {
+	sns, err := GetSerialNumbers(logf)
+	sys := currentSystemState()
+	return Attributes{
+		SerialNumbers: sns,
+		OSVersion:     sys.osVersion,
+		DiskEncrypted: sys.diskEncrypted,
+	}, err
+}
+
+// systemState is the part of Attributes that's cheap enough to check
+// often, to notice system events like OS updates and changes to disk
+// encryption soon after they happen.
+type systemState struct {
+	osVersion     string
+	diskEncrypted opt.Bool
+}
+
+func currentSystemState() systemState {
+	return systemState{
+		osVersion:     hostinfo.GetOSVersion(),
+		diskEncrypted: diskEncrypted(),
+	}
+}
+
+func (a Attributes) systemState() systemState {
+	return systemState{
+		osVersion:     a.OSVersion,
+		diskEncrypted: a.DiskEncrypted,
+	}
+}
+
+// changeReason describes how s and s2 differ, for logging.
+func (s systemState) changeReason(s2 systemState) string {
+	switch {
+	case s.osVersion != s2.osVersion:
+		return "OS version changed"
+	case s.diskEncrypted != s2.diskEncrypted:
+		return "disk encryption changed"
+	}
+	return "unchanged"
+}
+
+// Attestation is a record of the posture attributes collected at a point
+// in time, signed so that a cached copy can be trusted.
+type Attestation struct {
+	Attributes
+	Collected time.Time
+
+	// Signature is an HMAC-SHA256 of the rest of the attestation, keyed
+	// with the signer's key.
+	Signature []byte `json:",omitempty"`
+}
+
+// IsZero reports whether a has never been collected.
+func (a Attestation) IsZero() bool {
+	return a.Collected.IsZero()
+}
+
+// Digest returns a hex digest of a's attributes, which changes when they
+// do and reveals nothing else about them.
+func (a Attestation) Digest() string {
+	if a.IsZero() {
+		return ""
+	}
+	j, _ := json.Marshal(a.Attributes)
+	sum := sha256.Sum256(j)
+	return hex.EncodeToString(sum[:])
+}
+
+// mac returns the HMAC-SHA256 of a, without its signature, keyed with key.
+func (a Attestation) mac(key []byte) []byte {
+	a.Signature = nil
+	j, _ := json.Marshal(a)
+	h := hmac.New(sha256.New, key)
+	h.Write(j)
+	return h.Sum(nil)
+}
+
+// Sign sets a's signature, keyed with key.
+func (a *Attestation) Sign(key []byte) {
+	a.Signature = a.mac(key)
+}
+
+// Verify reports whether a was signed with key and hasn't been modified
+// since.
+func (a Attestation) Verify(key []byte) bool {
+	return len(a.Signature) > 0 && hmac.Equal(a.Signature, a.mac(key))
+}
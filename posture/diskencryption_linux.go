@@ -0,0 +1,68 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package posture
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tailscale.com/types/opt"
+	"tailscale.com/util/lineread"
+)
+
+// diskEncrypted reports whether the root filesystem is on a dm-crypt
+// (LUKS) device, possibly under other device-mapper devices like LVM.
+func diskEncrypted() opt.Bool {
+	dev := rootDevice("/proc/mounts")
+	if !strings.HasPrefix(dev, "/dev/") {
+		// Not a block device; an overlay in a container, say.
+		return ""
+	}
+	if p, err := filepath.EvalSymlinks(dev); err == nil {
+		dev = p
+	}
+	return blockDeviceEncrypted("/sys/block", filepath.Base(dev))
+}
+
+// rootDevice returns the device mounted on / according to the mounts file
+// at path, or the empty string if unknown.
+func rootDevice(path string) (dev string) {
+	lineread.File(path, func(line []byte) error {
+		f := bytes.Fields(line)
+		if len(f) >= 2 && string(f[1]) == "/" {
+			// Later mounts hide earlier ones.
+			dev = string(f[0])
+		}
+		return nil
+	})
+	return dev
+}
+
+// blockDeviceEncrypted reports whether the block device named name, with
+// its device-mapper details in the sysfs directory sysBlock, is a dm-crypt
+// device or is built only from dm-crypt devices.
+func blockDeviceEncrypted(sysBlock, name string) opt.Bool {
+	if !strings.HasPrefix(name, "dm-") {
+		return opt.NewBool(false)
+	}
+	uuid, err := os.ReadFile(filepath.Join(sysBlock, name, "dm", "uuid"))
+	if err != nil {
+		return ""
+	}
+	if strings.HasPrefix(string(uuid), "CRYPT-") {
+		return opt.NewBool(true)
+	}
+	slaves, err := os.ReadDir(filepath.Join(sysBlock, name, "slaves"))
+	if err != nil || len(slaves) == 0 {
+		return opt.NewBool(false)
+	}
+	for _, s := range slaves {
+		if enc := blockDeviceEncrypted(sysBlock, s.Name()); !enc.EqualBool(true) {
+			return enc
+		}
+	}
+	return opt.NewBool(true)
+}
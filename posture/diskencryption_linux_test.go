@@ -0,0 +1,64 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package posture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tailscale.com/types/opt"
+)
+
+func TestRootDevice(t *testing.T) {
+	mounts := filepath.Join(t.TempDir(), "mounts")
+	if err := os.WriteFile(mounts, []byte(`rootfs / rootfs rw 0 0
+/dev/mapper/vg-root / ext4 rw,relatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/nvme0n1p1 /boot/efi vfat rw 0 0
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rootDevice(mounts), "/dev/mapper/vg-root"; got != want {
+		t.Errorf("rootDevice = %q; want %q", got, want)
+	}
+}
+
+func TestBlockDeviceEncrypted(t *testing.T) {
+	sysBlock := t.TempDir()
+	// dm-0: LVM on dm-1, which is LUKS on a partition.
+	// dm-2: LVM directly on a partition.
+	dm := func(name, uuid string, slaves ...string) {
+		if err := os.MkdirAll(filepath.Join(sysBlock, name, "dm"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(sysBlock, name, "dm", "uuid"), []byte(uuid+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range slaves {
+			if err := os.MkdirAll(filepath.Join(sysBlock, name, "slaves", s), 0700); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	dm("dm-0", "LVM-abc", "dm-1")
+	dm("dm-1", "CRYPT-LUKS2-abc-luks", "nvme0n1p3")
+	dm("dm-2", "LVM-def", "sda2")
+
+	tests := []struct {
+		name string
+		want opt.Bool
+	}{
+		{"dm-0", opt.NewBool(true)},
+		{"dm-1", opt.NewBool(true)},
+		{"dm-2", opt.NewBool(false)},
+		{"sda1", opt.NewBool(false)},
+		{"dm-9", ""},
+	}
+	for _, tt := range tests {
+		if got := blockDeviceEncrypted(sysBlock, tt.name); got != tt.want {
+			t.Errorf("blockDeviceEncrypted(%q) = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package posture
+
+import "tailscale.com/types/opt"
+
+// diskEncrypted returns the empty string: we don't yet know how to tell
+// whether the disk is encrypted on this platform.
+func diskEncrypted() opt.Bool {
+	return ""
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package posture
+
+import (
+	"sync"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+const (
+	// RecheckInterval is how often a Rechecker re-collects the posture
+	// attributes, and so the most that a cached Attestation can be
+	// trusted for.
+	RecheckInterval = time.Hour
+
+	// eventPollInterval is how often a Rechecker checks for system events
+	// that can change the posture attributes.
+	eventPollInterval = time.Minute
+)
+
+// A Rechecker keeps an Attestation of the device's posture attributes up
+// to date, re-collecting them every RecheckInterval, when system events
+// like OS updates and disk encryption changes are seen, and when asked
+// to with Trigger.
+type Rechecker struct {
+	logf      logger.Logf
+	onCollect func(a Attestation, changed bool)
+
+	// For tests:
+	collect           func(logger.Logf) (Attributes, error)
+	systemState       func() systemState
+	interval          time.Duration
+	eventPollInterval time.Duration
+
+	trigger chan string   // reasons to recheck
+	stop    chan struct{} // closed by Close
+	done    chan struct{} // closed when run returns
+
+	mu        sync.Mutex
+	last      Attestation
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// NewRechecker returns a new Rechecker that starts from last, an
+// attestation from a previous run that can be zero, and calls onCollect
+// with each new Attestation and whether its attributes differ from the
+// one before. onCollect is called from the Rechecker's goroutine. Call
+// Start to start it.
+func NewRechecker(logf logger.Logf, last Attestation, onCollect func(a Attestation, changed bool)) *Rechecker {
+	return &Rechecker{
+		logf:              logger.WithPrefix(logf, "posture: "),
+		onCollect:         onCollect,
+		collect:           Collect,
+		systemState:       currentSystemState,
+		interval:          RecheckInterval,
+		eventPollInterval: eventPollInterval,
+		trigger:           make(chan string, 1),
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+		last:              last,
+	}
+}
+
+// Start starts r. If r's last attestation was collected less than
+// RecheckInterval ago, it's trusted until then, rather than collecting the
+// attributes again right away.
+func (r *Rechecker) Start() {
+	r.startOnce.Do(func() { go r.run() })
+}
+
+// Close stops r and waits for it to finish.
+func (r *Rechecker) Close() {
+	r.closeOnce.Do(func() { close(r.stop) })
+	r.startOnce.Do(func() { close(r.done) }) // if never started
+	<-r.done
+}
+
+// Trigger makes r re-collect the attributes soon, for the given reason.
+func (r *Rechecker) Trigger(reason string) {
+	select {
+	case r.trigger <- reason:
+	default:
+		// A recheck is already pending.
+	}
+}
+
+// Last returns the latest attestation, which is zero if the attributes
+// haven't been collected yet.
+func (r *Rechecker) Last() Attestation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+func (r *Rechecker) run() {
+	defer close(r.done)
+
+	last := r.Last()
+	var next time.Duration
+	if !last.IsZero() {
+		next = max(0, r.interval-time.Since(last.Collected))
+	}
+	recheck := time.NewTimer(next)
+	defer recheck.Stop()
+	poll := time.NewTicker(r.eventPollInterval)
+	defer poll.Stop()
+
+	sys := last.systemState()
+	for {
+		var reason string
+		select {
+		case <-r.stop:
+			return
+		case reason = <-r.trigger:
+		case <-recheck.C:
+			reason = "scheduled"
+		case <-poll.C:
+			now := r.systemState()
+			if now == sys {
+				continue
+			}
+			reason = sys.changeReason(now)
+			sys = now
+		}
+		if a, ok := r.recheck(reason); ok {
+			sys = a.systemState()
+		}
+		if !recheck.Stop() {
+			select {
+			case <-recheck.C:
+			default:
+			}
+		}
+		recheck.Reset(r.interval)
+	}
+}
+
+// recheck collects the attributes and passes them to r.onCollect. It
+// reports whether they could be collected.
+func (r *Rechecker) recheck(reason string) (Attributes, bool) {
+	a, err := r.collect(r.logf)
+	if err != nil {
+		r.logf("recheck (%s): %v", reason, err)
+		return Attributes{}, false
+	}
+	att := Attestation{Attributes: a, Collected: time.Now()}
+
+	r.mu.Lock()
+	changed := r.last.IsZero() || !r.last.Attributes.Equal(a)
+	r.last = att
+	r.mu.Unlock()
+
+	if changed {
+		r.logf("recheck (%s): attributes changed", reason)
+	} else {
+		r.logf("[v1] recheck (%s): unchanged", reason)
+	}
+	r.onCollect(att, changed)
+	return a, true
+}
@@ -0,0 +1,161 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package posture
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/types/logger"
+	"tailscale.com/types/opt"
+)
+
+// fakeSystem is a system whose posture attributes tests can change.
+type fakeSystem struct {
+	mu       sync.Mutex
+	attrs    Attributes
+	collects int
+}
+
+func (s *fakeSystem) set(a Attributes) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = a
+}
+
+func (s *fakeSystem) collect(logger.Logf) (Attributes, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collects++
+	return s.attrs, nil
+}
+
+func (s *fakeSystem) systemState() systemState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attrs.systemState()
+}
+
+type collected struct {
+	a       Attestation
+	changed bool
+}
+
+func newTestRechecker(t *testing.T, sys *fakeSystem, last Attestation) (*Rechecker, chan collected) {
+	ch := make(chan collected, 10)
+	r := NewRechecker(t.Logf, last, func(a Attestation, changed bool) {
+		ch <- collected{a, changed}
+	})
+	r.collect = sys.collect
+	r.systemState = sys.systemState
+	r.interval = time.Hour
+	r.eventPollInterval = 10 * time.Millisecond
+	t.Cleanup(r.Close)
+	return r, ch
+}
+
+func waitCollected(t *testing.T, ch chan collected) collected {
+	t.Helper()
+	select {
+	case c := <-ch:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for collection")
+		panic("unreachable")
+	}
+}
+
+func TestRecheckerEvents(t *testing.T) {
+	sys := &fakeSystem{attrs: Attributes{
+		SerialNumbers: []string{"abc"},
+		OSVersion:     "1.0",
+		DiskEncrypted: opt.NewBool(false),
+	}}
+	r, ch := newTestRechecker(t, sys, Attestation{})
+	r.Start()
+
+	// With nothing cached, the attributes are collected right away.
+	if c := waitCollected(t, ch); !c.changed || c.a.OSVersion != "1.0" {
+		t.Fatalf("first collection = %+v; want changed, version 1.0", c)
+	}
+	first := r.Last().Digest()
+
+	// An OS update is noticed without waiting for the interval.
+	a := sys.attrs
+	a.OSVersion = "1.1"
+	sys.set(a)
+	if c := waitCollected(t, ch); !c.changed || c.a.OSVersion != "1.1" {
+		t.Fatalf("after OS update = %+v; want changed, version 1.1", c)
+	}
+	if r.Last().Digest() == first {
+		t.Errorf("digest didn't change")
+	}
+
+	// So is turning on disk encryption.
+	a.DiskEncrypted = opt.NewBool(true)
+	sys.set(a)
+	if c := waitCollected(t, ch); !c.changed || !c.a.DiskEncrypted.EqualBool(true) {
+		t.Fatalf("after disk encryption = %+v; want changed, encrypted", c)
+	}
+
+	// A trigger without a change re-collects but reports no change.
+	r.Trigger("test")
+	if c := waitCollected(t, ch); c.changed {
+		t.Fatalf("after trigger = %+v; want unchanged", c)
+	}
+}
+
+func TestRecheckerTrustsFreshCache(t *testing.T) {
+	attrs := Attributes{SerialNumbers: []string{"abc"}, OSVersion: "1.0"}
+	sys := &fakeSystem{attrs: attrs}
+	cached := Attestation{Attributes: attrs, Collected: time.Now().Add(-time.Minute)}
+	r, ch := newTestRechecker(t, sys, cached)
+	r.Start()
+
+	select {
+	case c := <-ch:
+		t.Fatalf("collected %+v despite a fresh cached attestation", c)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := r.Last().Digest(); got != cached.Digest() {
+		t.Errorf("digest = %q; want the cached %q", got, cached.Digest())
+	}
+
+	// A stale cache is collected again right away.
+	cached.Collected = time.Now().Add(-2 * time.Hour)
+	r2, ch2 := newTestRechecker(t, sys, cached)
+	r2.Start()
+	if c := waitCollected(t, ch2); c.changed {
+		t.Errorf("recollection of unchanged attributes reported a change")
+	}
+}
+
+func TestAttestationSignature(t *testing.T) {
+	key := []byte("key")
+	a := Attestation{
+		Attributes: Attributes{SerialNumbers: []string{"abc"}, OSVersion: "1.0"},
+		Collected:  time.Unix(1700000000, 0),
+	}
+	if a.Verify(key) {
+		t.Fatal("unsigned attestation verified")
+	}
+	a.Sign(key)
+	if !a.Verify(key) {
+		t.Fatal("signed attestation didn't verify")
+	}
+	if a.Verify([]byte("other key")) {
+		t.Error("verified with the wrong key")
+	}
+	b := a
+	b.SerialNumbers = []string{"xyz"}
+	if b.Verify(key) {
+		t.Error("modified attestation verified")
+	}
+	b = a
+	b.Collected = b.Collected.Add(time.Hour)
+	if b.Verify(key) {
+		t.Error("attestation with modified time verified")
+	}
+}
@@ -58,6 +58,15 @@ type DERPHomeParams struct {
 	// A nil map means no change from the previous value (if any); an empty
 	// non-nil map can be sent to reset all scores back to 1.0.
 	RegionScore map[int]float64 `json:",omitempty"`
+
+	// ClientLocation, if non-nil, is the coarse geographical location of
+	// the client's public IP address, as seen by the control server. Only
+	// its Latitude and Longitude are used: until its first netcheck
+	// completes, the client picks a provisional home region near them, so
+	// that it has DERP connectivity sooner on slow networks.
+	//
+	// A nil value means no change from the previous value (if any).
+	ClientLocation *Location `json:",omitempty"`
 }
 
 // DERPRegion is a geographic region running DERP relay node(s).
@@ -774,6 +774,17 @@ type Hostinfo struct {
 	UserspaceRouter opt.Bool       `json:",omitempty"` // if the client's subnet router is running in userspace (netstack) mode
 	AppConnector    opt.Bool       `json:",omitempty"` // if the client is running the app-connector service
 
+	// ExperimentalFeatures lists the experimental datapath features enabled
+	// on this node, like "socket-bypass", sorted. It's omitted when the
+	// user has set the HideExperimentalFeatures pref.
+	ExperimentalFeatures []string `json:",omitempty"`
+
+	// PostureDigest is a digest of the device posture attributes that
+	// the client most recently collected, if posture checking is on. It
+	// changes when they do, prompting control to fetch them again with
+	// the c2n "/posture/identity" request.
+	PostureDigest string `json:",omitempty"`
+
 	// Location represents geographical location data about a
 	// Tailscale host. Location is optional and only set if
 	// explicitly declared by a node.
@@ -136,6 +136,7 @@ func (src *Hostinfo) Clone() *Hostinfo {
 	dst.Services = append(src.Services[:0:0], src.Services...)
 	dst.NetInfo = src.NetInfo.Clone()
 	dst.SSH_HostKeys = append(src.SSH_HostKeys[:0:0], src.SSH_HostKeys...)
+	dst.ExperimentalFeatures = append(src.ExperimentalFeatures[:0:0], src.ExperimentalFeatures...)
 	if dst.Location != nil {
 		dst.Location = ptr.To(*src.Location)
 	}
@@ -144,42 +145,44 @@ func (src *Hostinfo) Clone() *Hostinfo {
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HostinfoCloneNeedsRegeneration = Hostinfo(struct {
-	IPNVersion      string
-	FrontendLogID   string
-	BackendLogID    string
-	OS              string
-	OSVersion       string
-	Container       opt.Bool
-	Env             string
-	Distro          string
-	DistroVersion   string
-	DistroCodeName  string
-	App             string
-	Desktop         opt.Bool
-	Package         string
-	DeviceModel     string
-	PushDeviceToken string
-	Hostname        string
-	ShieldsUp       bool
-	ShareeNode      bool
-	NoLogsNoSupport bool
-	WireIngress     bool
-	AllowsUpdate    bool
-	Machine         string
-	GoArch          string
-	GoArchVar       string
-	GoVersion       string
-	RoutableIPs     []netip.Prefix
-	RequestTags     []string
-	WoLMACs         []string
-	Services        []Service
-	NetInfo         *NetInfo
-	SSH_HostKeys    []string
-	Cloud           string
-	Userspace       opt.Bool
-	UserspaceRouter opt.Bool
-	AppConnector    opt.Bool
-	Location        *Location
+	IPNVersion           string
+	FrontendLogID        string
+	BackendLogID         string
+	OS                   string
+	OSVersion            string
+	Container            opt.Bool
+	Env                  string
+	Distro               string
+	DistroVersion        string
+	DistroCodeName       string
+	App                  string
+	Desktop              opt.Bool
+	Package              string
+	DeviceModel          string
+	PushDeviceToken      string
+	Hostname             string
+	ShieldsUp            bool
+	ShareeNode           bool
+	NoLogsNoSupport      bool
+	WireIngress          bool
+	AllowsUpdate         bool
+	Machine              string
+	GoArch               string
+	GoArchVar            string
+	GoVersion            string
+	RoutableIPs          []netip.Prefix
+	RequestTags          []string
+	WoLMACs              []string
+	Services             []Service
+	NetInfo              *NetInfo
+	SSH_HostKeys         []string
+	Cloud                string
+	Userspace            opt.Bool
+	UserspaceRouter      opt.Bool
+	AppConnector         opt.Bool
+	ExperimentalFeatures []string
+	PostureDigest        string
+	Location             *Location
 }{})
 
 // Clone makes a deep copy of NetInfo.
@@ -374,12 +377,16 @@ func (src *DERPHomeParams) Clone() *DERPHomeParams {
 	dst := new(DERPHomeParams)
 	*dst = *src
 	dst.RegionScore = maps.Clone(src.RegionScore)
+	if dst.ClientLocation != nil {
+		dst.ClientLocation = ptr.To(*src.ClientLocation)
+	}
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _DERPHomeParamsCloneNeedsRegeneration = DERPHomeParams(struct {
-	RegionScore map[int]float64
+	RegionScore    map[int]float64
+	ClientLocation *Location
 }{})
 
 // Clone makes a deep copy of DERPRegion.
@@ -66,6 +66,8 @@ func TestHostinfoEqual(t *testing.T) {
 		"Userspace",
 		"UserspaceRouter",
 		"AppConnector",
+		"ExperimentalFeatures",
+		"PostureDigest",
 		"Location",
 	}
 	if have := fieldsOf(reflect.TypeFor[Hostinfo]()); !reflect.DeepEqual(have, hiHandles) {
@@ -240,6 +242,21 @@ func TestHostinfoEqual(t *testing.T) {
 			&Hostinfo{AppConnector: opt.Bool("false")},
 			false,
 		},
+		{
+			&Hostinfo{ExperimentalFeatures: []string{"socket-bypass"}},
+			&Hostinfo{ExperimentalFeatures: []string{"socket-bypass"}},
+			true,
+		},
+		{
+			&Hostinfo{ExperimentalFeatures: []string{"socket-bypass"}},
+			&Hostinfo{},
+			false,
+		},
+		{
+			&Hostinfo{PostureDigest: "abc"},
+			&Hostinfo{PostureDigest: "def"},
+			false,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.a.Equal(tt.b)
@@ -320,6 +320,10 @@ func (v HostinfoView) Cloud() string                          { return v.ж.Clou
 func (v HostinfoView) Userspace() opt.Bool                    { return v.ж.Userspace }
 func (v HostinfoView) UserspaceRouter() opt.Bool              { return v.ж.UserspaceRouter }
 func (v HostinfoView) AppConnector() opt.Bool                 { return v.ж.AppConnector }
+func (v HostinfoView) ExperimentalFeatures() views.Slice[string] {
+	return views.SliceOf(v.ж.ExperimentalFeatures)
+}
+func (v HostinfoView) PostureDigest() string { return v.ж.PostureDigest }
 func (v HostinfoView) Location() *Location {
 	if v.ж.Location == nil {
 		return nil
@@ -332,42 +336,44 @@ func (v HostinfoView) Equal(v2 HostinfoView) bool { return v.ж.Equal(v2.ж) }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HostinfoViewNeedsRegeneration = Hostinfo(struct {
-	IPNVersion      string
-	FrontendLogID   string
-	BackendLogID    string
-	OS              string
-	OSVersion       string
-	Container       opt.Bool
-	Env             string
-	Distro          string
-	DistroVersion   string
-	DistroCodeName  string
-	App             string
-	Desktop         opt.Bool
-	Package         string
-	DeviceModel     string
-	PushDeviceToken string
-	Hostname        string
-	ShieldsUp       bool
-	ShareeNode      bool
-	NoLogsNoSupport bool
-	WireIngress     bool
-	AllowsUpdate    bool
-	Machine         string
-	GoArch          string
-	GoArchVar       string
-	GoVersion       string
-	RoutableIPs     []netip.Prefix
-	RequestTags     []string
-	WoLMACs         []string
-	Services        []Service
-	NetInfo         *NetInfo
-	SSH_HostKeys    []string
-	Cloud           string
-	Userspace       opt.Bool
-	UserspaceRouter opt.Bool
-	AppConnector    opt.Bool
-	Location        *Location
+	IPNVersion           string
+	FrontendLogID        string
+	BackendLogID         string
+	OS                   string
+	OSVersion            string
+	Container            opt.Bool
+	Env                  string
+	Distro               string
+	DistroVersion        string
+	DistroCodeName       string
+	App                  string
+	Desktop              opt.Bool
+	Package              string
+	DeviceModel          string
+	PushDeviceToken      string
+	Hostname             string
+	ShieldsUp            bool
+	ShareeNode           bool
+	NoLogsNoSupport      bool
+	WireIngress          bool
+	AllowsUpdate         bool
+	Machine              string
+	GoArch               string
+	GoArchVar            string
+	GoVersion            string
+	RoutableIPs          []netip.Prefix
+	RequestTags          []string
+	WoLMACs              []string
+	Services             []Service
+	NetInfo              *NetInfo
+	SSH_HostKeys         []string
+	Cloud                string
+	Userspace            opt.Bool
+	UserspaceRouter      opt.Bool
+	AppConnector         opt.Bool
+	ExperimentalFeatures []string
+	PostureDigest        string
+	Location             *Location
 }{})
 
 // View returns a readonly view of NetInfo.
@@ -862,10 +868,18 @@ func (v *DERPHomeParamsView) UnmarshalJSON(b []byte) error {
 func (v DERPHomeParamsView) RegionScore() views.Map[int, float64] {
 	return views.MapOf(v.ж.RegionScore)
 }
+func (v DERPHomeParamsView) ClientLocation() *Location {
+	if v.ж.ClientLocation == nil {
+		return nil
+	}
+	x := *v.ж.ClientLocation
+	return &x
+}
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _DERPHomeParamsViewNeedsRegeneration = DERPHomeParams(struct {
-	RegionScore map[int]float64
+	RegionScore    map[int]float64
+	ClientLocation *Location
 }{})
 
 // View returns a readonly view of DERPRegion.
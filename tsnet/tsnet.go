@@ -810,7 +810,23 @@ func (s *Server) getTCPHandlerForFunnelFlow(src netip.AddrPort, dstPort uint16)
 	if !ok {
 		return nil
 	}
-	return ln.handle
+	s.mu.Lock()
+	connFunc := ln.funnelConnFunc
+	s.mu.Unlock()
+	if connFunc == nil {
+		return ln.handle
+	}
+	return func(c net.Conn) {
+		fc, ok := c.(*ipn.FunnelConn)
+		if !ok {
+			fc = &ipn.FunnelConn{Conn: c, Src: src}
+		}
+		if err := connFunc(fc); err != nil {
+			c.Close()
+			return
+		}
+		ln.handle(c)
+	}
 }
 
 func (s *Server) getTCPHandlerForFlow(src, dst netip.AddrPort) (handler func(net.Conn), intercept bool) {
@@ -979,6 +995,34 @@ func (funnelOnly) funnelOption() {}
 // The local tailnet will not be able to connect to the listener.
 func FunnelOnly() FunnelOption { return funnelOnly(1) }
 
+type funnelCertFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+func (funnelCertFunc) funnelOption() {}
+
+// FunnelCertificate configures the listener to use fn to get the TLS
+// certificate for each connection, instead of the node's certificate for
+// its Tailscale domain. It can be used to serve a certificate obtained
+// elsewhere, or to observe the TLS ClientHelloInfo (such as the SNI server
+// name) of incoming connections before delegating to the default.
+func FunnelCertificate(fn func(*tls.ClientHelloInfo) (*tls.Certificate, error)) FunnelOption {
+	return funnelCertFunc(fn)
+}
+
+type funnelConnFunc func(*ipn.FunnelConn) error
+
+func (funnelConnFunc) funnelOption() {}
+
+// FunnelConnFunc configures the listener to call fn for each connection
+// that arrives over Tailscale Funnel, before it's returned from Accept.
+// The FunnelConn carries the connection's metadata: the public internet
+// address of the client and the host:port it connected to. If fn returns
+// an error, the connection is closed without being accepted.
+//
+// Connections from the local tailnet don't go through fn.
+func FunnelConnFunc(fn func(*ipn.FunnelConn) error) FunnelOption {
+	return funnelConnFunc(fn)
+}
+
 // ListenFunnel announces on the public internet using Tailscale Funnel.
 //
 // It also by default listens on your local tailnet, so connections can
@@ -993,6 +1037,10 @@ func FunnelOnly() FunnelOption { return funnelOnly(1) }
 //
 // and the only other supported addrs currently are ":8443" and ":10000".
 //
+// The FunnelCertificate and FunnelConnFunc options can be used to provide
+// the TLS certificate and to inspect or reject each incoming Funnel
+// connection, respectively.
+//
 // It will start the server if it has not been started yet.
 func (s *Server) ListenFunnel(network, addr string, opts ...FunnelOption) (net.Listener, error) {
 	if network != "tcp" {
@@ -1048,17 +1096,29 @@ func (s *Server) ListenFunnel(network, addr string, opts ...FunnelOption) (net.L
 
 	// Start a funnel listener.
 	lnOn := listenOnBoth
+	getCert := s.getCert
+	var connFunc func(*ipn.FunnelConn) error
 	for _, opt := range opts {
-		if _, ok := opt.(funnelOnly); ok {
+		switch opt := opt.(type) {
+		case funnelOnly:
 			lnOn = listenOnFunnel
+		case funnelCertFunc:
+			getCert = opt
+		case funnelConnFunc:
+			connFunc = opt
 		}
 	}
 	ln, err := s.listen(network, addr, lnOn)
 	if err != nil {
 		return nil, err
 	}
+	if connFunc != nil {
+		s.mu.Lock()
+		ln.(*listener).funnelConnFunc = connFunc
+		s.mu.Unlock()
+	}
 	return tls.NewListener(ln, &tls.Config{
-		GetCertificate: s.getCert,
+		GetCertificate: getCert,
 	}), nil
 }
 
@@ -1182,6 +1242,10 @@ type listener struct {
 	addr   string
 	conn   chan net.Conn
 	closed bool // guarded by s.mu
+
+	// funnelConnFunc, if non-nil, is called for each conn that arrives
+	// over Funnel. It's set by ListenFunnel's FunnelConnFunc option.
+	funnelConnFunc func(*ipn.FunnelConn) error // guarded by s.mu
 }
 
 func (ln *listener) Accept() (net.Conn, error) {
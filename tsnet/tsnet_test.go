@@ -25,6 +25,7 @@
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -603,6 +604,83 @@ func TestFunnel(t *testing.T) {
 	}
 }
 
+func TestFunnelOptions(t *testing.T) {
+	ctx, dialCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer dialCancel()
+
+	controlURL, _ := startControl(t)
+	s1, _, _ := startServer(t, ctx, controlURL, "s1")
+	s2, _, _ := startServer(t, ctx, controlURL, "s2")
+
+	var (
+		mu          sync.Mutex
+		serverNames []string
+		srcs        []netip.AddrPort
+		reject      bool
+	)
+	ln := must.Get(s1.ListenFunnel("tcp", ":443",
+		FunnelOnly(),
+		FunnelCertificate(func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			mu.Lock()
+			serverNames = append(serverNames, hi.ServerName)
+			mu.Unlock()
+			return testCertRoot.getCert(hi)
+		}),
+		FunnelConnFunc(func(fc *ipn.FunnelConn) error {
+			mu.Lock()
+			defer mu.Unlock()
+			srcs = append(srcs, fc.Src)
+			if reject {
+				return errors.New("rejected")
+			}
+			return nil
+		}),
+	))
+	defer ln.Close()
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "hello")
+		}),
+	}
+	go srv.Serve(ln)
+
+	c := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialIngressConn(s2, s1, addr)
+			},
+			TLSClientConfig: &tls.Config{
+				RootCAs: testCertRoot.Pool(),
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := c.Get("https://s1.tail-scale.ts.net:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("unexpected status code: %v", resp.StatusCode)
+	}
+
+	mu.Lock()
+	wantSrcs := []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:1234")}
+	if !slices.Equal(srcs, wantSrcs) {
+		t.Errorf("FunnelConnFunc saw %v; want %v", srcs, wantSrcs)
+	}
+	if want := []string{"s1.tail-scale.ts.net"}; !slices.Equal(serverNames, want) {
+		t.Errorf("FunnelCertificate saw %q; want %q", serverNames, want)
+	}
+	reject = true
+	mu.Unlock()
+
+	if resp, err := c.Get("https://s1.tail-scale.ts.net:443"); err == nil {
+		resp.Body.Close()
+		t.Error("request succeeded after FunnelConnFunc rejected the conn")
+	}
+}
+
 func dialIngressConn(from, to *Server, target string) (net.Conn, error) {
 	toLC := must.Get(to.LocalClient())
 	toStatus := must.Get(toLC.StatusWithoutPeers(context.Background()))
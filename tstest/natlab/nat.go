@@ -47,6 +47,18 @@ type mapping struct {
 	AddressAndPortDependentNAT
 )
 
+func (t NATType) String() string {
+	switch t {
+	case EndpointIndependentNAT:
+		return "EndpointIndependent"
+	case AddressDependentNAT:
+		return "AddressDependent"
+	case AddressAndPortDependentNAT:
+		return "AddressAndPortDependent"
+	}
+	return fmt.Sprintf("NATType(%d)", int(t))
+}
+
 // natKey is the lookup key for a NAT session. While it contains a
 // 4-tuple ({src,dst} {ip,port}), some NATTypes will zero out some
 // fields, so in practice the key is either a 2-tuple (src only),
@@ -0,0 +1,48 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package preftype
+
+import "fmt"
+
+// DNSOverride is a local override of the DNS settings that the tailnet
+// pushes to the node. It only applies when the node accepts tailnet DNS
+// settings at all (the CorpDNS pref).
+//
+// The values are persisted to disk in JSON files and thus can't be
+// changed.
+type DNSOverride string
+
+const (
+	// DNSOverrideNone uses the tailnet's DNS settings as is.
+	DNSOverrideNone DNSOverride = ""
+
+	// DNSOverrideLocalResolvers resolves MagicDNS names, but keeps
+	// using the OS's own resolvers for everything else, ignoring the
+	// tailnet's global and split DNS resolvers.
+	DNSOverrideLocalResolvers DNSOverride = "local-resolvers"
+
+	// DNSOverrideIgnore ignores the tailnet's DNS settings entirely. MagicDNS
+	// names can still be resolved by querying 100.100.100.100 directly,
+	// but the OS's DNS configuration isn't changed.
+	DNSOverrideIgnore DNSOverride = "ignore"
+)
+
+// ParseDNSOverride parses s as a DNSOverride. The empty string and "off"
+// mean DNSOverrideNone.
+func ParseDNSOverride(s string) (DNSOverride, error) {
+	switch s {
+	case "", "off":
+		return DNSOverrideNone, nil
+	case string(DNSOverrideLocalResolvers), string(DNSOverrideIgnore):
+		return DNSOverride(s), nil
+	}
+	return DNSOverrideNone, fmt.Errorf("unknown DNS override %q; want %q, %q or %q", s, "off", DNSOverrideLocalResolvers, DNSOverrideIgnore)
+}
+
+func (o DNSOverride) String() string {
+	if o == DNSOverrideNone {
+		return "off"
+	}
+	return string(o)
+}
@@ -0,0 +1,194 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package crashlog keeps the crash reports of a long-running process, such
+// as tailscaled, in a bounded directory on disk, so that crashes on user
+// machines can be retrieved and diagnosed later.
+//
+// Start makes the Go runtime write the panic message and the stacks of all
+// goroutines to a file in the directory if the process crashes. The next
+// time the process calls Start, that output is moved into the directory's
+// ring of timestamped crash reports, of which only the most recent
+// MaxCrashes are kept.
+//
+// Redirecting crash output needs runtime/debug.SetCrashOutput, which was
+// added in Go 1.23. Binaries built with an older Go, which go.mod still
+// allows, list and read existing reports but capture no new ones; see
+// CaptureSupported.
+package crashlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+const (
+	// MaxCrashes is the number of crash reports kept in a directory.
+	// When a new one is saved, the oldest are removed.
+	MaxCrashes = 10
+
+	// maxCrashSize is the largest crash report saved. Longer reports are
+	// truncated, keeping the start, where the panic message is.
+	maxCrashSize = 1 << 20
+
+	// outputName is the name of the file the runtime writes the current
+	// process's crash output to.
+	outputName = "crash-output.txt"
+
+	// timeFormat is the format of the timestamp in report file names.
+	timeFormat = "20060102T150405.000Z"
+)
+
+// Crash describes a saved crash report.
+type Crash struct {
+	Name string    // file name within the directory, for Read
+	Time time.Time // when the crash happened
+	Size int64     // size of the report in bytes
+}
+
+// Dir returns the directory in which crash reports are kept under the
+// given Tailscale state directory (see ipnlocal.LocalBackend.TailscaleVarRoot),
+// or the empty string if varRoot is empty.
+func Dir(varRoot string) string {
+	if varRoot == "" {
+		return ""
+	}
+	return filepath.Join(varRoot, "crashes")
+}
+
+// Start saves the crash output of the previous process that used dir, if
+// it crashed, and arranges for the current process's crash output to be
+// written to dir. It also raises the runtime's traceback level so that the
+// output includes all goroutines.
+//
+// If !CaptureSupported, previous output is still collected but nothing
+// new is written, and Start returns an error saying so.
+func Start(logf logger.Logf, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	out := filepath.Join(dir, outputName)
+	if c, ok, err := collect(dir, out); err != nil {
+		logf("crashlog: saving previous crash output: %v", err)
+	} else if ok {
+		logf("crashlog: previous run crashed at %v; saved report %s", c.Time.Format(time.RFC3339), c.Name)
+	}
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // the runtime keeps its own copy of the descriptor
+	debug.SetTraceback("all")
+	return setCrashOutput(f)
+}
+
+// collect moves the crash output in out, if it's not empty, into the ring
+// of reports in dir.
+func collect(dir, out string) (c Crash, ok bool, err error) {
+	fi, err := os.Stat(out)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && fi.Size() == 0) {
+		return Crash{}, false, nil
+	}
+	if err != nil {
+		return Crash{}, false, err
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return Crash{}, false, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxCrashSize))
+	f.Close()
+	if err != nil {
+		return Crash{}, false, err
+	}
+	if fi.Size() > maxCrashSize {
+		data = fmt.Appendf(data, "\n[crash report truncated from %d bytes]\n", fi.Size())
+	}
+	c, err = Save(dir, fi.ModTime(), data)
+	if err != nil {
+		return Crash{}, false, err
+	}
+	return c, true, os.Remove(out)
+}
+
+// Save adds a crash report with the given contents and time of crash to
+// dir, removing the oldest reports beyond MaxCrashes.
+func Save(dir string, t time.Time, data []byte) (Crash, error) {
+	name := "crash-" + t.UTC().Format(timeFormat) + ".txt"
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		return Crash{}, err
+	}
+	crashes, err := List(dir)
+	if err != nil {
+		return Crash{}, err
+	}
+	for len(crashes) > MaxCrashes {
+		if err := os.Remove(filepath.Join(dir, crashes[0].Name)); err != nil {
+			return Crash{}, err
+		}
+		crashes = crashes[1:]
+	}
+	return Crash{Name: name, Time: t, Size: int64(len(data))}, nil
+}
+
+// List returns the crash reports in dir, oldest first. It returns no
+// reports and no error if dir doesn't exist.
+func List(dir string) ([]Crash, error) {
+	des, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var crashes []Crash
+	for _, de := range des {
+		t, ok := parseName(de.Name())
+		if !ok || !de.Type().IsRegular() {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		crashes = append(crashes, Crash{Name: de.Name(), Time: t, Size: fi.Size()})
+	}
+	slices.SortFunc(crashes, func(a, b Crash) int { return a.Time.Compare(b.Time) })
+	return crashes, nil
+}
+
+// Read returns the contents of the crash report with the given name in dir.
+func Read(dir, name string) ([]byte, error) {
+	if _, ok := parseName(name); !ok {
+		return nil, fmt.Errorf("invalid crash report name %q", name)
+	}
+	return os.ReadFile(filepath.Join(dir, name))
+}
+
+// parseName returns the time of the crash report with the given file name,
+// and whether name is one.
+func parseName(name string) (time.Time, bool) {
+	ts, ok := strings.CutPrefix(name, "crash-")
+	if !ok {
+		return time.Time{}, false
+	}
+	ts, ok = strings.CutSuffix(ts, ".txt")
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(timeFormat, ts)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
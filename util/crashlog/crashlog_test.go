@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package crashlog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveRing(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := range MaxCrashes + 3 {
+		if _, err := Save(dir, start.Add(time.Duration(i)*time.Minute), []byte(fmt.Sprintf("panic %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crashes, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crashes) != MaxCrashes {
+		t.Fatalf("got %d crashes; want %d", len(crashes), MaxCrashes)
+	}
+	if got, want := crashes[0].Time, start.Add(3*time.Minute); !got.Equal(want) {
+		t.Errorf("oldest crash at %v; want %v", got, want)
+	}
+	last := crashes[len(crashes)-1]
+	data, err := Read(dir, last.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("panic %d", MaxCrashes+2); string(data) != want {
+		t.Errorf("newest crash = %q; want %q", data, want)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, outputName)
+
+	// No output, or empty output, from the previous run: nothing saved.
+	if _, ok, err := collect(dir, out); ok || err != nil {
+		t.Fatalf("collect with no output = %v, %v", ok, err)
+	}
+	if err := os.WriteFile(out, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := collect(dir, out); ok || err != nil {
+		t.Fatalf("collect with empty output = %v, %v", ok, err)
+	}
+
+	big := bytes.Repeat([]byte("x"), maxCrashSize+10)
+	copy(big, "panic: boom\n")
+	if err := os.WriteFile(out, big, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, ok, err := collect(dir, out)
+	if !ok || err != nil {
+		t.Fatalf("collect = %v, %v", ok, err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("crash output not removed after collecting: %v", err)
+	}
+	data, err := Read(dir, c.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "panic: boom\n") || !strings.Contains(string(data), "truncated") {
+		t.Errorf("saved report doesn't start with the panic or isn't marked truncated")
+	}
+	crashes, err := List(dir)
+	if err != nil || len(crashes) != 1 || crashes[0].Name != c.Name {
+		t.Errorf("List = %v, %v; want just %v", crashes, err, c.Name)
+	}
+}
+
+func TestReadName(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{outputName, "../crash-20240501T120000.000Z.txt", "tailscaled.state"} {
+		if _, err := Read(dir, name); err == nil {
+			t.Errorf("Read(%q) succeeded", name)
+		}
+	}
+	crashes, err := List(filepath.Join(dir, "missing"))
+	if err != nil || len(crashes) != 0 {
+		t.Errorf("List of missing dir = %v, %v", crashes, err)
+	}
+}
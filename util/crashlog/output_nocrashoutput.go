@@ -0,0 +1,19 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !go1.23
+
+package crashlog
+
+import (
+	"errors"
+	"os"
+)
+
+// CaptureSupported reports whether Start can capture the crash output of
+// the current process, which needs Go 1.23 or later.
+const CaptureSupported = false
+
+func setCrashOutput(f *os.File) error {
+	return errors.New("crash output redirection requires Go 1.23 or later")
+}
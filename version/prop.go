@@ -234,6 +234,14 @@ type Meta struct {
 	// incrementing integer that's incremented whenever a new capability is
 	// added.
 	Cap int `json:"cap"`
+
+	// GoVersion is the version of Go the binary was built with, as
+	// returned by runtime.Version.
+	GoVersion string `json:"goVersion,omitempty"`
+
+	// BuildTags are the build tags the binary was built with, such as
+	// "ts_omit_aws" or "ts_include_cli".
+	BuildTags []string `json:"buildTags,omitempty"`
 }
 
 var getMeta lazy.SyncValue[Meta]
@@ -251,6 +259,8 @@ func GetMeta() Meta {
 			IsDev:           isDev(),
 			UnstableBranch:  IsUnstableBuild(),
 			Cap:             int(tailcfg.CurrentCapabilityVersion),
+			GoVersion:       runtime.Version(),
+			BuildTags:       buildTags(),
 		}
 	})
 }
@@ -142,6 +142,20 @@ func (i embeddedInfo) commitAbbrev() string {
 	return ret
 })
 
+// buildTags returns the build tags recorded in the binary's build info.
+func buildTags() []string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	for _, s := range bi.Settings {
+		if s.Key == "-tags" && s.Value != "" {
+			return strings.Split(s.Value, ",")
+		}
+	}
+	return nil
+}
+
 func gitCommit() string {
 	if gitCommitStamp != "" {
 		return gitCommitStamp
@@ -0,0 +1,84 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"net/netip"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+// AlertRule describes inbound connection attempts that should never
+// happen, such as RDP from peers that have no business using it, and
+// that the user wants to hear about. Packets that match an AlertRule are
+// dropped even if the filter's matches would accept them, and reported
+// to the func passed to SetAlertRules.
+//
+// Only attempts to start a connection are checked: TCP SYNs, and UDP and
+// SCTP packets that aren't part of a flow the filter already knows about.
+type AlertRule struct {
+	Name    string  // identifies the rule in Alerts
+	Matches []Match // typically from MatchesFromFilterRules
+}
+
+// Alert is an inbound connection attempt that matched an AlertRule.
+type Alert struct {
+	Rule  string // the AlertRule's Name
+	Proto ipproto.Proto
+	Src   netip.AddrPort
+	Dst   netip.AddrPort
+}
+
+// alertRule is an AlertRule with only the matches for one address family.
+type alertRule struct {
+	name    string
+	matches matches
+}
+
+// SetAlertRules sets the rules for inbound connection attempts that f
+// drops and reports to onAlert. onAlert is called on the packet
+// processing path, so it must not block.
+//
+// It must be called before f is in use.
+func (f *Filter) SetAlertRules(rules []AlertRule, onAlert func(Alert)) {
+	f.alerts4 = nil
+	f.alerts6 = nil
+	for _, r := range rules {
+		if m := matchesFamily(r.Matches, netip.Addr.Is4); len(m) > 0 {
+			f.alerts4 = append(f.alerts4, alertRule{name: r.Name, matches: m})
+		}
+		if m := matchesFamily(r.Matches, netip.Addr.Is6); len(m) > 0 {
+			f.alerts6 = append(f.alerts6, alertRule{name: r.Name, matches: m})
+		}
+	}
+	f.onAlert = onAlert
+}
+
+// checkAlert reports whether q, an inbound attempt to start a connection,
+// matches one of f's alert rules, in which case it reports q to f's
+// onAlert and returns the name of the rule.
+func (f *Filter) checkAlert(q *packet.Parsed) (rule string, ok bool) {
+	var rules []alertRule
+	if q.IPVersion == 4 {
+		rules = f.alerts4
+	} else {
+		rules = f.alerts6
+	}
+	for _, r := range rules {
+		if !r.matches.match(q) {
+			continue
+		}
+		if f.onAlert != nil {
+			f.onAlert(Alert{
+				Rule:  r.name,
+				Proto: q.IPProto,
+				Src:   q.Src,
+				Dst:   q.Dst,
+			})
+		}
+		return r.name, true
+	}
+	return "", false
+}
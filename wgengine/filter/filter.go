@@ -55,6 +55,12 @@ type Filter struct {
 	// incoming packets don't get accepted by matches above.
 	state *filterState
 
+	// alerts4 and alerts6 are the rules for inbound connection
+	// attempts to drop and report to onAlert, by address family.
+	// See SetAlertRules.
+	alerts4, alerts6 []alertRule
+	onAlert          func(Alert)
+
 	shieldsUp bool
 }
 
@@ -447,6 +453,9 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		if !q.IsTCPSyn() {
 			return Accept, "tcp non-syn"
 		}
+		if rule, ok := f.checkAlert(q); ok {
+			return Drop, "alert: " + rule
+		}
 		if f.matches4.match(q) {
 			return Accept, "tcp ok"
 		}
@@ -460,6 +469,9 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		if ok {
 			return Accept, "cached"
 		}
+		if rule, ok := f.checkAlert(q); ok {
+			return Drop, "alert: " + rule
+		}
 		if f.matches4.match(q) {
 			return Accept, "ok"
 		}
@@ -507,6 +519,9 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		if q.IPProto == ipproto.TCP && !q.IsTCPSyn() {
 			return Accept, "tcp non-syn"
 		}
+		if rule, ok := f.checkAlert(q); ok {
+			return Drop, "alert: " + rule
+		}
 		if f.matches6.match(q) {
 			return Accept, "tcp ok"
 		}
@@ -520,6 +535,9 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		if ok {
 			return Accept, "cached"
 		}
+		if rule, ok := f.checkAlert(q); ok {
+			return Drop, "alert: " + rule
+		}
 		if f.matches6.match(q) {
 			return Accept, "ok"
 		}
@@ -954,3 +954,67 @@ func TestPeerCaps(t *testing.T) {
 		})
 	}
 }
+
+func TestAlertRules(t *testing.T) {
+	acl := newFilter(t.Logf)
+	var got []Alert
+	acl.SetAlertRules([]AlertRule{
+		{Name: "ssh", Matches: []Match{
+			m(nets("8.1.1.1", "::1"), netports("1.2.3.4:22", "2001::1:22")),
+		}},
+		{Name: "udp", Matches: []Match{
+			m(nets("0.0.0.0/0"), netports("102.102.102.102:4343"), ipproto.UDP),
+		}},
+	}, func(a Alert) {
+		got = append(got, a)
+	})
+	flags := LogDrops | LogAccepts
+
+	tests := []struct {
+		name      string
+		p         packet.Parsed
+		want      Response
+		wantAlert string
+	}{
+		{"alert_overrides_accept", parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22), Drop, "ssh"},
+		{"other_src_accepted", parsed(ipproto.TCP, "8.2.2.2", "1.2.3.4", 999, 22), Accept, ""},
+		{"other_port_accepted", parsed(ipproto.TCP, "8.1.1.1", "5.6.7.8", 999, 23), Accept, ""},
+		{"v6", parsed(ipproto.TCP, "::1", "2001::1", 999, 22), Drop, "ssh"},
+		{"proto_mismatch", parsed(ipproto.SCTP, "8.1.1.1", "1.2.3.4", 999, 22), Drop, ""},
+		{"udp", parsed(ipproto.UDP, "119.119.119.119", "102.102.102.102", 4242, 4343), Drop, "udp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			if r := acl.RunIn(&tt.p, flags); r != tt.want {
+				t.Errorf("RunIn = %v; want %v", r, tt.want)
+			}
+			switch {
+			case tt.wantAlert == "" && len(got) > 0:
+				t.Errorf("unexpected alerts: %+v", got)
+			case tt.wantAlert != "":
+				want := []Alert{{Rule: tt.wantAlert, Proto: tt.p.IPProto, Src: tt.p.Src, Dst: tt.p.Dst}}
+				if !slices.Equal(got, want) {
+					t.Errorf("alerts = %+v; want %+v", got, want)
+				}
+			}
+		})
+	}
+
+	// Packets on existing connections don't raise alerts.
+	got = nil
+	nonSyn := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22)
+	nonSyn.TCPFlags = packet.TCPAck
+	if r := acl.RunIn(&nonSyn, flags); r != Accept {
+		t.Errorf("TCP non-SYN: RunIn = %v; want Accept", r)
+	}
+	out := parsed(ipproto.UDP, "102.102.102.102", "119.119.119.119", 4343, 4242)
+	acl.RunOut(&out, flags)
+	in := parsed(ipproto.UDP, "119.119.119.119", "102.102.102.102", 4242, 4343)
+	if r := acl.RunIn(&in, flags); r != Accept {
+		t.Errorf("UDP response: RunIn = %v; want Accept", r)
+	}
+	if len(got) > 0 {
+		t.Errorf("unexpected alerts: %+v", got)
+	}
+}
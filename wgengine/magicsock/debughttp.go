@@ -38,6 +38,7 @@ type D struct {
 			regionID   int
 			lastWrite  time.Time
 			createTime time.Time
+			sendRate   uint64
 		}
 		ent := make([]D, 0, len(c.activeDerp))
 		for rid, ad := range c.activeDerp {
@@ -45,6 +46,7 @@ type D struct {
 				regionID:   rid,
 				lastWrite:  *ad.lastWrite,
 				createTime: ad.createTime,
+				sendRate:   c.derpSendRate[rid],
 			})
 		}
 		sort.Slice(ent, func(i, j int) bool {
@@ -59,10 +61,15 @@ type D struct {
 			if e.regionID == c.myDerp {
 				home = "🏠"
 			}
-			fmt.Fprintf(w, "<li>%s %d - %v: created %v ago, write %v ago</li>\n",
+			rate := ""
+			if e.sendRate != 0 {
+				rate = fmt.Sprintf(", can send to us at %d KB/s", e.sendRate/1000)
+			}
+			fmt.Fprintf(w, "<li>%s %d - %v: created %v ago, write %v ago%s</li>\n",
 				home, e.regionID, html.EscapeString(r.RegionCode),
 				now.Sub(e.createTime).Round(time.Second),
 				now.Sub(e.lastWrite).Round(time.Second),
+				rate,
 			)
 		}
 
@@ -144,6 +151,9 @@ func printEndpointHTML(w io.Writer, ep *endpoint) {
 	fmt.Fprintf(w, "<p>heartbeating: %v</p>\n", ep.heartBeatTimer != nil)
 	fmt.Fprintf(w, "<p>lastSend: %v ago</p>\n", fmtMono(ep.lastSendExt))
 	fmt.Fprintf(w, "<p>lastFullPing: %v ago</p>\n", fmtMono(ep.lastFullPing))
+	if trackRxOrder() {
+		fmt.Fprintf(w, "<p>DERP rx: jitter %v, reordered %d, held %d</p>\n", ep.rxOrder.DERPJitter().Round(time.Microsecond), ep.rxOrder.derpReordered.Load(), ep.rxOrder.derpHeld.Load())
+	}
 
 	eps := make([]netip.AddrPort, 0, len(ep.endpointState))
 	for ipp := range ep.endpointState {
@@ -60,6 +60,20 @@
 	//
 	//lint:ignore U1000 used on Linux/Darwin only
 	debugPMTUD = envknob.RegisterBool("TS_DEBUG_PMTUD")
+	// debugDERPReorderWindow, if positive, is how long the DERP receive
+	// path may hold a data packet that arrived ahead of earlier packets
+	// from a peer that's also sending directly, waiting for those to
+	// arrive first.
+	debugDERPReorderWindow = envknob.RegisterDuration("TS_DEBUG_DERP_REORDER_WINDOW")
+	// debugRxOrder tracks the order of received data packets for the
+	// reorder statistics, without the DERP reorder window.
+	debugRxOrder = envknob.RegisterBool("TS_DEBUG_RX_ORDER")
+	// debugDisableNetCache disables remembering the endpoints found on
+	// each network, and reporting them on rejoining it.
+	debugDisableNetCache = envknob.RegisterBool("TS_DEBUG_DISABLE_NET_CACHE")
+	// debugPathCutover selects how sends to a peer move to a faster
+	// path: "quiet", or by default "immediate"; see pathsched.go.
+	debugPathCutover = envknob.RegisterString("TS_DEBUG_PATH_CUTOVER")
 	// Hey you! Adding a new debugknob? Make sure to stub it out in the
 	// debugknobs_stubs.go file too.
 )
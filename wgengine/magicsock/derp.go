@@ -6,6 +6,7 @@
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"math/rand"
@@ -20,6 +21,7 @@
 	"github.com/tailscale/wireguard-go/conn"
 	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
+	"tailscale.com/derp/derpmap"
 	"tailscale.com/health"
 	"tailscale.com/logtail/backoff"
 	"tailscale.com/net/dnscache"
@@ -199,6 +201,11 @@ func (c *Conn) derpRegionCodeLocked(regionID int) string {
 func (c *Conn) setNearestDERP(derpNum int) (wantDERP bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.setNearestDERPLocked(derpNum)
+}
+
+// c.mu must be held.
+func (c *Conn) setNearestDERPLocked(derpNum int) (wantDERP bool) {
 	if !c.wantDerpLocked() {
 		c.myDerp = 0
 		c.health.SetMagicSockDERPHome(0, c.homeless)
@@ -540,6 +547,7 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 		msg, connGen, err := dc.RecvDetail()
 		if err != nil {
 			c.health.SetDERPRegionConnectedState(regionID, false)
+			c.setDERPSendRate(regionID, 0)
 			// Forget that all these peers have routes.
 			for peer := range peerPresent {
 				delete(peerPresent, peer)
@@ -626,6 +634,10 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 		case derp.HealthMessage:
 			c.health.SetDERPRegionHealth(regionID, m.Problem)
 			continue
+		case derp.BandwidthEstimateMessage:
+			c.dlogf("magicsock: derp-%d estimates it can send to us at %d bytes/s", regionID, m.BytesPerSecond)
+			c.setDERPSendRate(regionID, m.BytesPerSecond)
+			continue
 		case derp.PeerGoneMessage:
 			switch m.Reason {
 			case derp.PeerGoneReasonDisconnected:
@@ -673,6 +685,11 @@ func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan
 			if err != nil {
 				c.logf("magicsock: derp.Send(%v): %v", wr.addr, err)
 				metricSendDERPError.Add(1)
+				if errors.Is(err, derp.ErrWriteStalled) {
+					// dc has closed the stalled connection; the
+					// next send or receive reconnects.
+					metricSendDERPStall.Add(1)
+				}
 			} else {
 				metricSendDERP.Add(1)
 			}
@@ -686,7 +703,27 @@ func (c *connBind) receiveDERP(buffs [][]byte, sizes []int, eps []conn.Endpoint)
 		defer s.Exit()
 	}
 
-	for dm := range c.derpRecvCh {
+	held := &c.derpHeld
+	for {
+		var dm derpReadResult
+		if held.ep != nil {
+			now := mono.Now()
+			if held.ready(now) {
+				sizes[0], eps[0] = held.release(buffs[0])
+				return 1, nil
+			}
+			// Keep delivering other DERP packets while a packet is
+			// held, and check on it every so often.
+			t := time.NewTimer(held.pollInterval(now))
+			select {
+			case dm = <-c.derpRecvCh:
+				t.Stop()
+			case <-t.C:
+				continue
+			}
+		} else {
+			dm = <-c.derpRecvCh
+		}
 		if c.isClosed() {
 			break
 		}
@@ -696,6 +733,10 @@ func (c *connBind) receiveDERP(buffs [][]byte, sizes []int, eps []conn.Endpoint)
 			continue
 		}
 		metricRecvDataDERP.Add(1)
+		if trackRxOrder() && c.noteDERPDataPacket(ep, buffs[0][:n], held) {
+			// Delivered once ready, above.
+			continue
+		}
 		sizes[0] = n
 		eps[0] = ep
 		return 1, nil
@@ -722,16 +763,16 @@ func (c *Conn) processDERPReadResult(dm derpReadResult, b []byte) (n int, ep *en
 	}
 
 	var ok bool
-	c.mu.Lock()
-	ep, ok = c.peerMap.endpointForNodeKey(dm.src)
-	c.mu.Unlock()
+	ep, ok = c.peerMap.endpointForNodeKeyConcurrent(dm.src)
 	if !ok {
 		// We don't know anything about this node key, nothing to
 		// record or process.
 		return 0, nil
 	}
 
-	ep.noteRecvActivity(ipp, mono.Now())
+	now := mono.Now()
+	ep.lastRecvDERP.StoreAtomic(now)
+	ep.noteRecvActivity(ipp, now)
 	if stats := c.stats.Load(); stats != nil {
 		stats.UpdateRxPhysical(ep.nodeAddr, ipp, dm.n)
 	}
@@ -804,8 +845,39 @@ func (c *Conn) SetDERPMap(dm *tailcfg.DERPMap) {
 		}
 	}
 
+	c.maybeSetProvisionalDERPHomeLocked()
 	go c.ReSTUN("derp-map-update")
 }
+
+// maybeSetProvisionalDERPHomeLocked picks a home DERP region near the
+// client's location, as reported by control in the DERP map's home params,
+// if there's no home yet and no netcheck has completed to measure one.
+// That gets us relay connectivity without waiting for the first netcheck,
+// which can take seconds on slow networks; its result replaces this guess
+// as usual, and it's kept if netcheck can't find any region.
+//
+// c.mu must be held.
+func (c *Conn) maybeSetProvisionalDERPHomeLocked() {
+	if c.myDerp != 0 || c.lastNetCheckReport.Load() != nil {
+		return
+	}
+	hp := c.derpMap.HomeParams
+	if hp == nil || hp.ClientLocation == nil {
+		return
+	}
+	loc := hp.ClientLocation
+	if loc.Latitude == 0 && loc.Longitude == 0 {
+		return
+	}
+	rid := derpmap.ProvisionalHome(c.derpMap, loc.Latitude, loc.Longitude)
+	if rid == 0 {
+		return
+	}
+	c.logf("magicsock: picked provisional DERP home derp-%d from client location hint", rid)
+	if c.setNearestDERPLocked(rid) {
+		metricDERPHomeProvisional.Add(1)
+	}
+}
 func (c *Conn) wantDerpLocked() bool { return c.derpMap != nil }
 
 // c.mu must be held.
@@ -887,10 +959,23 @@ func (c *Conn) closeDerpLocked(regionID int, why string) {
 		go ad.c.Close()
 		ad.cancel()
 		delete(c.activeDerp, regionID)
+		delete(c.derpSendRate, regionID)
 		metricNumDERPConns.Set(int64(len(c.activeDerp)))
 	}
 }
 
+// setDERPSendRate records the DERP server in regionID's latest estimate
+// of its send rate to us, or forgets it if bytesPerSecond is 0.
+func (c *Conn) setDERPSendRate(regionID int, bytesPerSecond uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if bytesPerSecond == 0 {
+		delete(c.derpSendRate, regionID)
+		return
+	}
+	mak.Set(&c.derpSendRate, regionID, bytesPerSecond)
+}
+
 // c.mu must be held.
 func (c *Conn) logActiveDerpLocked() {
 	now := time.Now()
@@ -58,8 +58,10 @@ type endpoint struct {
 	// atomically accessed; declared first for alignment reasons
 	lastRecvWG            mono.Time // last time there were incoming packets from this peer destined for wireguard-go (e.g. not disco)
 	lastRecvUDPAny        mono.Time // last time there were incoming UDP packets from this peer of any kind
+	lastRecvDERP          mono.Time // last time there were incoming data packets from this peer via DERP
 	numStopAndResetAtomic int64
 	debugUpdates          *ringbuffer.RingBuffer[EndpointChange]
+	rxOrder               rxOrder // order and jitter of received data packets; see reorder.go
 
 	// These fields are initialized once and never modified.
 	c            *Conn
@@ -79,6 +81,7 @@ type endpoint struct {
 	lastSendAny    mono.Time      // last time there were outgoing packets sent this peer from any trigger, internal or external to magicsock
 	lastFullPing   mono.Time      // last time we pinged all disco or wireguard only endpoints
 	derpAddr       netip.AddrPort // fallback/bootstrap path, if non-zero (non-zero for well-behaved clients)
+	derpLatency    time.Duration  // latency of the most recent disco pong via derpAddr; zero if none
 
 	bestAddr           addrQuality // best non-DERP path; zero if none; mutate via setBestAddrLocked()
 	bestAddrAt         mono.Time   // time best address re-confirmed
@@ -86,6 +89,9 @@ type endpoint struct {
 	sentPing           map[stun.TxID]sentPing
 	endpointState      map[netip.AddrPort]*endpointState
 	isCallMeMaybeEP    map[netip.AddrPort]bool
+	txPath             sendPath       // path(s) data was last sent on; see pathsched.go
+	txPathAt           mono.Time      // time data was last sent on txPath
+	migration          *pathMigration // non-nil while sends are held on txPath
 
 	// The following fields are related to the new "silent disco"
 	// implementation that's a WIP as of 2022-10-20.
@@ -940,8 +946,11 @@ func (de *endpoint) send(buffs [][]byte) error {
 		if startWGPing {
 			de.sendWireGuardOnlyPingsLocked(now)
 		}
-	} else if !udpAddr.IsValid() || now.After(de.trustBestAddrUntil) {
-		de.sendDiscoPingsLocked(now, true)
+	} else {
+		if !udpAddr.IsValid() || now.After(de.trustBestAddrUntil) {
+			de.sendDiscoPingsLocked(now, true)
+		}
+		udpAddr, derpAddr = de.schedulePathLocked(currentPathScheduler(), now, udpAddr, derpAddr)
 	}
 	de.noteTxActivityExtTriggerLocked(now)
 	de.lastSendAny = now
@@ -1349,6 +1358,7 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 			})
 		}
 		de.derpAddr = netip.AddrPort{}
+		de.derpLatency = 0
 	} else {
 		newDerp, _ := netip.ParseAddrPort(n.DERP())
 		if de.derpAddr != newDerp {
@@ -1358,6 +1368,7 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 				From: de.derpAddr,
 				To:   newDerp,
 			})
+			de.derpLatency = 0
 		}
 		de.derpAddr = newDerp
 	}
@@ -1570,6 +1581,8 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 			from:    src,
 			pongSrc: m.Src,
 		})
+	} else if src == de.derpAddr {
+		de.derpLatency = latency
 	}
 
 	if sp.purpose != pingHeartbeat && sp.purpose != pingHeartbeatForUDPLifetime {
@@ -1781,6 +1794,19 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 
 	ps.Relay = de.c.derpRegionCodeOfIDLocked(int(de.derpAddr.Port()))
 
+	if t := de.lastRecvUDPAny.LoadAtomic(); t != 0 {
+		ps.LastDirect = t.WallTime()
+	}
+	if t := de.lastRecvDERP.LoadAtomic(); t != 0 {
+		ps.LastDERP = t.WallTime()
+	}
+
+	if de.bestAddr.IsValid() && !mono.Now().After(de.trustBestAddrUntil) {
+		ps.LatencySeconds = de.bestAddr.latency.Seconds()
+	} else if de.derpLatency > 0 {
+		ps.LatencySeconds = de.derpLatency.Seconds()
+	}
+
 	if de.lastSendExt.IsZero() {
 		return
 	}
@@ -1828,7 +1854,9 @@ func (de *endpoint) stopAndReset() {
 func (de *endpoint) resetLocked() {
 	de.lastSendExt = 0
 	de.lastFullPing = 0
+	de.derpLatency = 0
 	de.clearBestAddrLocked()
+	de.txPath, de.txPathAt, de.migration = sendPath{}, 0, nil
 	for _, es := range de.endpointState {
 		es.lastPing = 0
 	}
@@ -1847,5 +1875,8 @@ func (de *endpoint) numStopAndReset() int64 {
 func (de *endpoint) setDERPHome(regionID uint16) {
 	de.mu.Lock()
 	defer de.mu.Unlock()
-	de.derpAddr = netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(regionID))
+	if addr := netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(regionID)); addr != de.derpAddr {
+		de.derpAddr = addr
+		de.derpLatency = 0
+	}
 }
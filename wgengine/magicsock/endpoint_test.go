@@ -9,6 +9,8 @@
 	"time"
 
 	"github.com/dsnet/try"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 )
 
@@ -324,3 +326,36 @@ func(lifetime *probeUDPLifetime) time.Duration {
 		})
 	}
 }
+
+func TestEndpointPopulatePeerStatusLatency(t *testing.T) {
+	direct := addrQuality{AddrPort: netip.MustParseAddrPort("1.2.3.4:41641"), latency: 5 * time.Millisecond}
+	tests := []struct {
+		name        string
+		bestAddr    addrQuality
+		trusted     bool
+		derpLatency time.Duration
+		want        float64
+	}{
+		{name: "none"},
+		{name: "derp", derpLatency: 40 * time.Millisecond, want: 0.040},
+		{name: "direct", bestAddr: direct, trusted: true, derpLatency: 40 * time.Millisecond, want: 0.005},
+		{name: "direct_expired", bestAddr: direct, derpLatency: 40 * time.Millisecond, want: 0.040},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			de := &endpoint{
+				c:           &Conn{},
+				bestAddr:    tt.bestAddr,
+				derpLatency: tt.derpLatency,
+			}
+			if tt.trusted {
+				de.trustBestAddrUntil = mono.Now().Add(time.Minute)
+			}
+			var ps ipnstate.PeerStatus
+			de.populatePeerStatus(&ps)
+			if ps.LatencySeconds != tt.want {
+				t.Errorf("LatencySeconds = %v; want %v", ps.LatencySeconds, tt.want)
+			}
+		})
+	}
+}
@@ -35,6 +35,7 @@
 	"tailscale.com/net/connstats"
 	"tailscale.com/net/netcheck"
 	"tailscale.com/net/neterror"
+	"tailscale.com/net/netknob/sockopts"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/packet"
@@ -54,6 +55,7 @@
 	"tailscale.com/types/nettype"
 	"tailscale.com/types/views"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/lru"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/ringbuffer"
 	"tailscale.com/util/set"
@@ -71,11 +73,6 @@
 	// _linux variant.
 	discoMagic1 = 0x5453f09f
 	discoMagic2 = 0x92ac
-
-	// UDP socket read/write buffer size (7MB). The value of 7MB is chosen as it
-	// is the max supported by a default configuration of macOS. Some platforms
-	// will silently clamp the value.
-	socketBufferSize = 7 << 20
 )
 
 // A Conn routes UDP packets and actively manages a list of its endpoints.
@@ -235,6 +232,10 @@ type Conn struct {
 	// even if there was no change.
 	lastEndpointsTime time.Time
 
+	// netCache remembers the endpoints found on recently seen networks;
+	// see netcache.go.
+	netCache lru.Cache[networkFingerprint, netCacheEntry]
+
 	// onEndpointRefreshed are funcs to run (in their own goroutines)
 	// when endpoints are refreshed.
 	onEndpointRefreshed map[*endpoint]func()
@@ -283,6 +284,11 @@ type Conn struct {
 	activeDerp       map[int]activeDerp            // DERP regionID -> connection to a node in that region
 	prevDerp         map[int]*syncs.WaitGroupChan
 
+	// derpSendRate is, for each DERP region we're connected to that
+	// has sent one, its most recent estimate of the rate in bytes per
+	// second at which it can send to us. See derp.BandwidthEstimateMessage.
+	derpSendRate map[int]uint64
+
 	// derpRoute contains optional alternate routes to use as an
 	// optimization instead of contacting a peer via their home
 	// DERP connection.  If they sent us a message on a different
@@ -296,6 +302,10 @@ type Conn struct {
 	// peer. It's only used to quiet logging, so we only log on change.
 	peerLastDerp map[key.NodePublic]int
 
+	// portMapProbes are the outstanding STUN probes sent by
+	// verifyPortMapping, keyed by transaction ID.
+	portMapProbes map[stun.TxID]chan<- netip.AddrPort
+
 	// wgPinger is the WireGuard only pinger used for latency measurements.
 	wgPinger lazy.SyncValue[*ping.Pinger]
 
@@ -392,6 +402,10 @@ type Options struct {
 	// DisablePortMapper, if true, disables the portmapper.
 	// This is primarily useful in tests.
 	DisablePortMapper bool
+
+	// DisabledPortMapServices are the port mapping services that the
+	// portmapper must not use. See SetDisabledPortMapServices.
+	DisabledPortMapServices portmapper.Services
 }
 
 func (o *Options) logf() logger.Logf {
@@ -466,8 +480,12 @@ func NewConn(opts Options) (*Conn, error) {
 	portMapOpts := &portmapper.DebugKnobs{
 		DisableAll: func() bool { return opts.DisablePortMapper || c.onlyTCP443.Load() },
 	}
-	c.portMapper = portmapper.NewClient(logger.WithPrefix(c.logf, "portmapper: "), opts.NetMon, portMapOpts, opts.ControlKnobs, c.onPortMapChanged)
+	c.portMapper = portmapper.NewClient(logger.WithPrefix(c.logf, "portmapper: "), opts.NetMon, portMapOpts, opts.ControlKnobs, nil)
+	c.portMapper.RegisterChangeCallback(c.onPortMapChanged)
 	c.portMapper.SetGatewayLookupFunc(opts.NetMon.GatewayAndSelfIP)
+	c.portMapper.SetDisabledServices(opts.DisabledPortMapServices)
+	c.portMapper.SetGatewayCandidatesFunc(opts.NetMon.HomeRouters)
+	c.portMapper.SetMappingVerifier(c.verifyPortMapping)
 	c.netMon = opts.NetMon
 	c.health = opts.HealthTracker
 	c.onPortUpdate = opts.OnPortUpdate
@@ -506,6 +524,7 @@ func NewConn(opts Options) (*Conn, error) {
 	} else {
 		c.logf("[v1] couldn't create raw v6 disco listener, using regular listener instead: %v", err)
 	}
+	hostinfo.SetExperimentalFeature("derp-reorder-window", debugDERPReorderWindow() > 0)
 
 	c.logf("magicsock: disco key = %v", c.discoShort)
 	return c, nil
@@ -589,6 +608,9 @@ func (c *Conn) updateEndpoints(why string) {
 		return
 	}
 
+	if fp, ok := c.currentNetworkFingerprint(); ok && !debugDisableNetCache() {
+		c.rememberNetwork(fp, endpoints, c.lastNetCheckReport.Load())
+	}
 	if c.setEndpoints(endpoints) {
 		c.logEndpointChange(endpoints)
 		c.epFunc(endpoints)
@@ -1269,7 +1291,9 @@ func (c *Conn) mkReceiveFunc(ruc *RebindingUDPConn, healthItem *health.ReceiveFu
 // caller).
 func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, cache *ippEndpointCache) (ep *endpoint, ok bool) {
 	if stun.Is(b) {
-		c.netChecker.ReceiveSTUNPacket(b, ipp)
+		if !c.handlePortMapProbe(b, ipp) {
+			c.netChecker.ReceiveSTUNPacket(b, ipp)
+		}
 		return nil, false
 	}
 	if c.handleDiscoMessage(b, ipp, key.NodePublic{}, discoRXPathUDP) {
@@ -1284,9 +1308,7 @@ func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, cache *ippEndpointCache)
 	if cache.ipp == ipp && cache.de != nil && cache.gen == cache.de.numStopAndReset() {
 		ep = cache.de
 	} else {
-		c.mu.Lock()
-		de, ok := c.peerMap.endpointForIPPort(ipp)
-		c.mu.Unlock()
+		de, ok := c.peerMap.endpointForIPPortConcurrent(ipp)
 		if !ok {
 			return nil, false
 		}
@@ -1298,6 +1320,9 @@ func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, cache *ippEndpointCache)
 	now := mono.Now()
 	ep.lastRecvUDPAny.StoreAtomic(now)
 	ep.noteRecvActivity(ipp, now)
+	if trackRxOrder() {
+		noteDirectDataPacket(ep, b, now)
+	}
 	if stats := c.stats.Load(); stats != nil {
 		stats.UpdateRxPhysical(ep.nodeAddr, ipp, len(b))
 	}
@@ -1754,6 +1779,28 @@ func (c *Conn) SetNetworkUp(up bool) {
 	}
 }
 
+// SetDisabledPortMapServices sets the port mapping services that c's
+// portmapper must not probe for or use, and re-gathers endpoints so that
+// a port mapped with a newly disabled service is no longer advertised.
+func (c *Conn) SetDisabledPortMapServices(s portmapper.Services) {
+	c.portMapper.SetDisabledServices(s)
+	c.ReSTUN("portmap-services-changed")
+}
+
+// SetPortMappingStore sets the port mapping saved by a previous run, whose
+// external port the portmapper asks the gateway for again, and the func
+// that saves new mappings as they're obtained. See portmapper.SavedMapping.
+func (c *Conn) SetPortMappingStore(saved portmapper.SavedMapping, save func(portmapper.SavedMapping)) {
+	c.portMapper.SetSavedMapping(saved)
+	c.portMapper.SetSaveMappingFunc(save)
+}
+
+// PortMapperEvents returns the portmapper's most recent events, oldest
+// first, for debugging. See portmapper.Client.DebugEvents.
+func (c *Conn) PortMapperEvents() []portmapper.Event {
+	return c.portMapper.DebugEvents()
+}
+
 // SetPreferredPort sets the connection's preferred local port.
 func (c *Conn) SetPreferredPort(port uint16) {
 	if uint16(c.port.Load()) == port {
@@ -2164,6 +2211,10 @@ type connBind struct {
 	*Conn
 	mu     sync.Mutex
 	closed bool
+
+	// derpHeld is the DERP data packet that receiveDERP is holding back,
+	// if any; see reorder.go. It's only used by receiveDERP.
+	derpHeld heldDERPPacket
 }
 
 // This is a compile-time assertion that connBind implements the wireguard-go
@@ -2342,7 +2393,57 @@ func (c *Conn) shouldDoPeriodicReSTUNLocked() bool {
 	return true
 }
 
-func (c *Conn) onPortMapChanged() { c.ReSTUN("portmap-changed") }
+// onPortMapChanged is called by the portmapper, in its own goroutine,
+// when the external address of its mapping changes. The new address is
+// published right away in place of the old one, so that peers can try it
+// without waiting for the endpoint update that follows, which first runs
+// a netcheck.
+func (c *Conn) onPortMapChanged(mc portmapper.MappingChange) {
+	if eps, ok := c.replacePortmapEndpoint(mc.New); ok {
+		metricPortMapEndpointsPublished.Add(1)
+		c.logEndpointChange(eps)
+		c.epFunc(eps)
+	}
+	if mc.New.IsValid() {
+		c.setNetInfoHavePortMap()
+	}
+	c.ReSTUN("portmap-changed")
+}
+
+// replacePortmapEndpoint replaces the portmapped endpoint in the last
+// endpoints found with ext, or removes it if ext is the zero value. It
+// returns the new endpoints and true if they changed. It does nothing
+// before the first endpoint update, or once c is closed or stopped.
+func (c *Conn) replacePortmapEndpoint(ext netip.AddrPort) ([]tailcfg.Endpoint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.lastEndpoints == nil || c.privateKey.IsZero() && c.everHadKey {
+		return nil, false
+	}
+	eps := withPortmapEndpoint(c.lastEndpoints, ext)
+	if endpointSetsEqual(eps, c.lastEndpoints) {
+		return nil, false
+	}
+	c.lastEndpoints = eps
+	return eps, true
+}
+
+// withPortmapEndpoint returns a copy of eps with any portmapped endpoints
+// replaced by ext, first as determineEndpoints puts it, or removed if ext
+// is the zero value.
+func withPortmapEndpoint(eps []tailcfg.Endpoint, ext netip.AddrPort) []tailcfg.Endpoint {
+	ret := make([]tailcfg.Endpoint, 0, len(eps)+1)
+	if ext.IsValid() {
+		ret = append(ret, tailcfg.Endpoint{Addr: ext, Type: tailcfg.EndpointPortmapped})
+	}
+	for _, ep := range eps {
+		if ep.Type == tailcfg.EndpointPortmapped || ep.Addr == ext {
+			continue
+		}
+		ret = append(ret, ep)
+	}
+	return ret
+}
 
 // ReSTUN triggers an address discovery.
 // The provided why string is for debug logging only.
@@ -2470,7 +2571,7 @@ func (c *Conn) bindSocket(ruc *RebindingUDPConn, network string, curPortFate cur
 				}
 			}
 		}
-		trySetSocketBuffer(pconn, c.logf)
+		sockopts.ApplyPacketConn(pconn, c.logf)
 
 		// Success.
 		if debugBindSocket() {
@@ -2511,6 +2612,7 @@ func (c *Conn) rebind(curPortFate currentPortFate) error {
 		return fmt.Errorf("magicsock: Rebind IPv4 failed: %w", err)
 	}
 	c.portMapper.SetLocalPort(c.LocalPort())
+	c.portMapper.SetLocalPort6(c.pconn6.Port())
 	c.UpdatePMTUD()
 	return nil
 }
@@ -2534,6 +2636,7 @@ func (c *Conn) Rebind() {
 
 	c.maybeCloseDERPsOnRebind(ifIPs)
 	c.resetEndpointStates()
+	c.maybeUseCachedEndpoints()
 }
 
 // resetEndpointStates resets the preferred address for all peers.
@@ -2919,20 +3022,6 @@ func (c *Conn) DebugPickNewDERP() error {
 	return errors.New("too few regions")
 }
 
-// portableTrySetSocketBuffer sets SO_SNDBUF and SO_RECVBUF on pconn to socketBufferSize,
-// logging an error if it occurs.
-func portableTrySetSocketBuffer(pconn nettype.PacketConn, logf logger.Logf) {
-	if c, ok := pconn.(*net.UDPConn); ok {
-		// Attempt to increase the buffer size, and allow failures.
-		if err := c.SetReadBuffer(socketBufferSize); err != nil {
-			logf("magicsock: failed to set UDP read buffer size to %d: %v", socketBufferSize, err)
-		}
-		if err := c.SetWriteBuffer(socketBufferSize); err != nil {
-			logf("magicsock: failed to set UDP write buffer size to %d: %v", socketBufferSize, err)
-		}
-	}
-}
-
 // derpStr replaces DERP IPs in s with "derp-".
 func derpStr(s string) string { return strings.ReplaceAll(s, "127.3.3.40:", "derp-") }
 
@@ -2984,6 +3073,11 @@ type discoInfo struct {
 	metricReSTUNCalls     = clientmetric.NewCounter("magicsock_restun_calls")
 	metricUpdateEndpoints = clientmetric.NewCounter("magicsock_update_endpoints")
 
+	// metricPortMapEndpointsPublished counts the number of times that a
+	// changed port mapping was published before the endpoint update that
+	// it triggered. See Conn.onPortMapChanged.
+	metricPortMapEndpointsPublished = clientmetric.NewCounter("magicsock_portmap_endpoints_published")
+
 	// Sends (data or disco)
 	metricSendDERPQueued      = clientmetric.NewCounter("magicsock_send_derp_queued")
 	metricSendDERPErrorChan   = clientmetric.NewCounter("magicsock_send_derp_error_chan")
@@ -2993,6 +3087,7 @@ type discoInfo struct {
 	metricSendUDPError        = clientmetric.NewCounter("magicsock_send_udp_error")
 	metricSendDERP            = clientmetric.NewCounter("magicsock_send_derp")
 	metricSendDERPError       = clientmetric.NewCounter("magicsock_send_derp_error")
+	metricSendDERPStall       = clientmetric.NewCounter("magicsock_send_derp_stall")
 
 	// Data packets (non-disco)
 	metricSendData            = clientmetric.NewCounter("magicsock_send_data")
@@ -3001,6 +3096,28 @@ type discoInfo struct {
 	metricRecvDataIPv4        = clientmetric.NewCounter("magicsock_recv_data_ipv4")
 	metricRecvDataIPv6        = clientmetric.NewCounter("magicsock_recv_data_ipv6")
 
+	// DERP data packet ordering, relative to packets from the same peer
+	// on any path. See reorder.go. Like the migration reorder count
+	// below, they're only counted while trackRxOrder reports true.
+	metricRecvDataDERPReordered   = clientmetric.NewCounter("magicsock_recv_data_derp_reordered")
+	metricRecvDataDERPGap         = clientmetric.NewCounter("magicsock_recv_data_derp_gap")
+	metricRecvDataDERPHeld        = clientmetric.NewCounter("magicsock_recv_data_derp_held")
+	metricRecvDataDERPHeldTimeout = clientmetric.NewCounter("magicsock_recv_data_derp_held_timeout")
+
+	// Data packets received out of order while a peer was sending over
+	// both DERP and a direct path, such as while moving between them.
+	metricRecvDataMigrationReordered = clientmetric.NewCounter("magicsock_recv_data_migration_reordered")
+
+	// Moves of data sends to a peer from one path to another; see
+	// pathsched.go. Held are those that kept sending on the old path for
+	// a while; of all that moved to a faster path, quiet are those that
+	// cut over once the old path had drained and forced those that
+	// didn't, and could have reordered packets.
+	metricPathMigrations    = clientmetric.NewCounter("magicsock_path_migrations")
+	metricPathCutoverHeld   = clientmetric.NewCounter("magicsock_path_cutover_held")
+	metricPathCutoverQuiet  = clientmetric.NewCounter("magicsock_path_cutover_quiet")
+	metricPathCutoverForced = clientmetric.NewCounter("magicsock_path_cutover_forced")
+
 	// Disco packets
 	metricSendDiscoUDP               = clientmetric.NewCounter("magicsock_disco_send_udp")
 	metricSendDiscoDERP              = clientmetric.NewCounter("magicsock_disco_send_derp")
@@ -3027,6 +3144,10 @@ type discoInfo struct {
 	// metricDERPHomeChange is how many times our DERP home region DI has
 	// changed from non-zero to a different non-zero.
 	metricDERPHomeChange = clientmetric.NewCounter("derp_home_change")
+	// metricDERPHomeProvisional is how many times we picked a DERP home
+	// region from control's hint of our location, before netcheck
+	// measured any.
+	metricDERPHomeProvisional = clientmetric.NewCounter("derp_home_provisional")
 
 	// Disco packets received bpf read path
 	//lint:ignore U1000 used on Linux only
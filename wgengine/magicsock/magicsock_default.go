@@ -9,7 +9,6 @@
 	"errors"
 	"io"
 
-	"tailscale.com/types/logger"
 	"tailscale.com/types/nettype"
 )
 
@@ -17,10 +16,6 @@ func (c *Conn) listenRawDisco(family string) (io.Closer, error) {
 	return nil, errors.New("raw disco listening not supported on this OS")
 }
 
-func trySetSocketBuffer(pconn nettype.PacketConn, logf logger.Logf) {
-	portableTrySetSocketBuffer(pconn, logf)
-}
-
 func tryEnableUDPOffload(pconn nettype.PacketConn) (hasTX bool, hasRX bool) {
 	return false, false
 }
@@ -18,6 +18,7 @@
 	"net/http/httptest"
 	"net/netip"
 	"os"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
@@ -50,6 +51,8 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/packet"
 	"tailscale.com/net/ping"
+	"tailscale.com/net/portmapper"
+	"tailscale.com/net/stun"
 	"tailscale.com/net/stun/stuntest"
 	"tailscale.com/net/tstun"
 	"tailscale.com/tailcfg"
@@ -1686,6 +1689,99 @@ func TestEndpointSetsEqual(t *testing.T) {
 
 }
 
+func TestWithPortmapEndpoint(t *testing.T) {
+	ep := func(s string, typ tailcfg.EndpointType) tailcfg.Endpoint {
+		return tailcfg.Endpoint{Addr: netip.MustParseAddrPort(s), Type: typ}
+	}
+	stun := ep("1.2.3.4:41641", tailcfg.EndpointSTUN)
+	local := ep("192.168.1.2:41641", tailcfg.EndpointLocal)
+	oldPM := ep("1.2.3.4:5000", tailcfg.EndpointPortmapped)
+	newPM := ep("1.2.3.4:6000", tailcfg.EndpointPortmapped)
+
+	tests := []struct {
+		name string
+		eps  []tailcfg.Endpoint
+		ext  netip.AddrPort
+		want []tailcfg.Endpoint
+	}{
+		{
+			name: "acquired",
+			eps:  []tailcfg.Endpoint{stun, local},
+			ext:  newPM.Addr,
+			want: []tailcfg.Endpoint{newPM, stun, local},
+		},
+		{
+			name: "changed",
+			eps:  []tailcfg.Endpoint{oldPM, stun, local},
+			ext:  newPM.Addr,
+			want: []tailcfg.Endpoint{newPM, stun, local},
+		},
+		{
+			name: "lost",
+			eps:  []tailcfg.Endpoint{oldPM, stun, local},
+			want: []tailcfg.Endpoint{stun, local},
+		},
+		{
+			name: "same-as-stun",
+			eps:  []tailcfg.Endpoint{stun, local},
+			ext:  stun.Addr,
+			want: []tailcfg.Endpoint{ep(stun.Addr.String(), tailcfg.EndpointPortmapped), local},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := withPortmapEndpoint(tt.eps, tt.ext)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnPortMapChanged(t *testing.T) {
+	c := newConn()
+	c.logf = t.Logf
+	var published [][]tailcfg.Endpoint
+	c.epFunc = func(eps []tailcfg.Endpoint) { published = append(published, eps) }
+
+	stun := tailcfg.Endpoint{Addr: netip.MustParseAddrPort("1.2.3.4:41641"), Type: tailcfg.EndpointSTUN}
+	ext := netip.MustParseAddrPort("1.2.3.4:5000")
+
+	// Keep ReSTUN from starting an endpoint update, which needs a
+	// portmapper and netcheck client; it's recorded as wanted instead.
+	c.endpointsUpdateActive = true
+
+	// Before the first endpoint update, there's nothing to publish.
+	c.onPortMapChanged(portmapper.MappingChange{New: ext})
+	if len(published) != 0 {
+		t.Fatalf("published %v before first endpoint update", published)
+	}
+	if c.wantEndpointsUpdate != "portmap-changed" {
+		t.Errorf("wantEndpointsUpdate = %q; want portmap-changed", c.wantEndpointsUpdate)
+	}
+
+	c.lastEndpoints = []tailcfg.Endpoint{stun}
+	c.onPortMapChanged(portmapper.MappingChange{New: ext})
+	want := []tailcfg.Endpoint{{Addr: ext, Type: tailcfg.EndpointPortmapped}, stun}
+	if len(published) != 1 || !reflect.DeepEqual(published[0], want) {
+		t.Fatalf("published %v; want [%v]", published, want)
+	}
+	if !reflect.DeepEqual(c.lastEndpoints, want) {
+		t.Errorf("lastEndpoints = %v; want %v", c.lastEndpoints, want)
+	}
+
+	// A repeat of the same address publishes nothing new.
+	c.onPortMapChanged(portmapper.MappingChange{New: ext})
+	if len(published) != 1 {
+		t.Errorf("published %d times; want 1", len(published))
+	}
+
+	c.onPortMapChanged(portmapper.MappingChange{Old: ext})
+	if len(published) != 2 || !reflect.DeepEqual(published[1], []tailcfg.Endpoint{stun}) {
+		t.Errorf("after loss, published %v; want [%v]", published[1:], stun)
+	}
+}
+
 func TestBetterAddr(t *testing.T) {
 	const ms = time.Millisecond
 	al := func(ipps string, d time.Duration) addrQuality {
@@ -1937,6 +2033,23 @@ func (m *peerMap) validate() error {
 		}
 	}
 
+	if got, want := m.epByNodeKey.Len(), len(m.byNodeKey); got != want {
+		return fmt.Errorf("epByNodeKey has %d entries; byNodeKey has %d", got, want)
+	}
+	for pub, pi := range m.byNodeKey {
+		if ep, _ := m.endpointForNodeKeyConcurrent(pub); ep != pi.ep {
+			return fmt.Errorf("epByNodeKey[%v] = %p; want %p", pub, ep, pi.ep)
+		}
+	}
+	if got, want := m.epByIPPort.Len(), len(m.byIPPort); got != want {
+		return fmt.Errorf("epByIPPort has %d entries; byIPPort has %d", got, want)
+	}
+	for ipp, pi := range m.byIPPort {
+		if ep, _ := m.endpointForIPPortConcurrent(ipp); ep != pi.ep {
+			return fmt.Errorf("epByIPPort[%v] = %p; want %p", ipp, ep, pi.ep)
+		}
+	}
+
 	publicToDisco := make(map[key.NodePublic]key.DiscoPublic)
 	for disco, nodes := range m.nodesOfDisco {
 		for pub := range nodes {
@@ -3173,6 +3286,91 @@ func TestMaybeSetNearestDERP(t *testing.T) {
 	}
 }
 
+func TestProvisionalDERPHome(t *testing.T) {
+	region := func(id int, code string, lat, lon float64) *tailcfg.DERPRegion {
+		return &tailcfg.DERPRegion{
+			RegionID:   id,
+			RegionCode: code,
+			Latitude:   lat,
+			Longitude:  lon,
+			Nodes: []*tailcfg.DERPNode{{
+				Name:     code,
+				RegionID: id,
+				HostName: code + ".test-node.unused",
+				IPv4:     "127.0.0.1",
+				IPv6:     "none",
+			}},
+		}
+	}
+	newMap := func(loc *tailcfg.Location) *tailcfg.DERPMap {
+		return &tailcfg.DERPMap{
+			HomeParams: &tailcfg.DERPHomeParams{ClientLocation: loc},
+			Regions: map[int]*tailcfg.DERPRegion{
+				1: region(1, "nyc", 40.7128, -74.0060),
+				2: region(2, "fra", 50.1109, 8.6821),
+			},
+		}
+	}
+	paris := &tailcfg.Location{Latitude: 48.8566, Longitude: 2.3522}
+
+	tests := []struct {
+		name       string
+		dm         *tailcfg.DERPMap
+		old        int
+		haveReport bool
+		want       int
+	}{
+		{
+			name: "hint",
+			dm:   newMap(paris),
+			want: 2,
+		},
+		{
+			name: "no-hint",
+			dm:   newMap(nil),
+			want: 0,
+		},
+		{
+			name: "zero-hint",
+			dm:   newMap(&tailcfg.Location{Country: "France"}),
+			want: 0,
+		},
+		{
+			name: "already-have-home",
+			dm:   newMap(paris),
+			old:  1,
+			want: 1,
+		},
+		{
+			name:       "after-netcheck",
+			dm:         newMap(paris),
+			haveReport: true,
+			want:       0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConn()
+			c.logf = t.Logf
+			c.myDerp = tt.old
+			if tt.haveReport {
+				c.lastNetCheckReport.Store(&netcheck.Report{})
+			}
+			// Keep SetDERPMap's ReSTUN from starting an endpoint update,
+			// which needs a portmapper and netcheck client.
+			c.endpointsUpdateActive = true
+
+			c.SetDERPMap(tt.dm)
+			c.mu.Lock()
+			got := c.myDerp
+			c.mu.Unlock()
+			if got != tt.want {
+				t.Errorf("home = %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestMaybeRebindOnError(t *testing.T) {
 	tstest.PanicOnLog()
 	tstest.ResourceCheck(t)
@@ -3202,3 +3400,34 @@ func TestMaybeRebindOnError(t *testing.T) {
 		}
 	})
 }
+
+func TestHandlePortMapProbe(t *testing.T) {
+	c := newConn()
+	ext := netip.MustParseAddrPort("1.2.3.4:5678")
+	stunServer := netip.MustParseAddrPort("5.6.7.8:3478")
+
+	tx := stun.NewTxID()
+	ch := make(chan netip.AddrPort, 1)
+	c.portMapProbes = map[stun.TxID]chan<- netip.AddrPort{tx: ch}
+
+	// A hairpinned request delivers its source.
+	if !c.handlePortMapProbe(stun.Request(tx), ext) {
+		t.Fatal("hairpinned probe not handled")
+	}
+	if got := <-ch; got != ext {
+		t.Errorf("hairpin got %v; want %v", got, ext)
+	}
+
+	// A STUN response delivers the address the server saw.
+	if !c.handlePortMapProbe(stun.Response(tx, ext), stunServer) {
+		t.Fatal("STUN response not handled")
+	}
+	if got := <-ch; got != ext {
+		t.Errorf("STUN response got %v; want %v", got, ext)
+	}
+
+	// Other STUN packets are left for netcheck.
+	if c.handlePortMapProbe(stun.Request(stun.NewTxID()), ext) {
+		t.Error("unrelated STUN request handled")
+	}
+}
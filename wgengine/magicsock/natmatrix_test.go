@@ -0,0 +1,182 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tailscale/wireguard-go/tun/tuntest"
+	"tailscale.com/tstest/natlab"
+	"tailscale.com/types/logger"
+)
+
+var (
+	natMatrix        = flag.Bool("nat-matrix", false, "run TestNATMatrix, which tries NAT traversal between every pair of simulated NAT types")
+	natMatrixOut     = flag.String("nat-matrix-out", "", "if non-empty, file to write TestNATMatrix's results table to")
+	natMatrixTimeout = flag.Duration("nat-matrix-timeout", 30*time.Second, "how long TestNATMatrix waits for each pair to find a direct path")
+)
+
+// natKind is a type of network a node in TestNATMatrix sits behind.
+type natKind struct {
+	name string
+	nat  bool           // whether the node is behind a NAT; if false, it has a public IP
+	typ  natlab.NATType // if nat
+}
+
+var natKinds = []natKind{
+	{name: "public"},
+	{name: "eim", nat: true, typ: natlab.EndpointIndependentNAT},
+	{name: "adm", nat: true, typ: natlab.AddressDependentNAT},
+	{name: "apdm", nat: true, typ: natlab.AddressAndPortDependentNAT},
+}
+
+// natResult is the outcome of TestNATMatrix for one pair of natKinds.
+type natResult struct {
+	a, b     natKind
+	direct   bool          // whether a direct path was found
+	toDirect time.Duration // how long finding the direct path took
+	latency  time.Duration // one-way transit time of a ping once direct, or over DERP if not
+}
+
+// TestNATMatrix runs two magicsocks behind every pair of simulated NAT
+// types and reports, for each pair, whether they found a direct path,
+// how long that took and the resulting ping latency. Pairs that don't
+// find a direct path aren't failures, as some combinations (such as two
+// address-and-port dependent NATs) are expected to need DERP.
+//
+// It's slow, so it only runs with --nat-matrix. It's what "tailscale
+// debug nat-test" runs.
+func TestNATMatrix(t *testing.T) {
+	if !*natMatrix {
+		t.Skip("skipping without --nat-matrix")
+	}
+	var results []natResult
+	for i, a := range natKinds {
+		for _, b := range natKinds[i:] {
+			t.Run(a.name+"-"+b.name, func(t *testing.T) {
+				results = append(results, testNATPair(t, a, b))
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "A\tB\tDIRECT\tTIME TO DIRECT\tLATENCY\n")
+	for _, r := range results {
+		toDirect := "-"
+		if r.direct {
+			toDirect = r.toDirect.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\t%v\n", r.a.name, r.b.name, r.direct, toDirect, r.latency.Round(time.Microsecond))
+	}
+	tw.Flush()
+	t.Logf("results:\n%s", buf.Bytes())
+	if *natMatrixOut != "" {
+		if err := os.WriteFile(*natMatrixOut, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testNATPair builds a natlab network with one node behind each of a and
+// b, and measures NAT traversal between them.
+func testNATPair(t *testing.T, a, b natKind) natResult {
+	res := natResult{a: a, b: b}
+	inet := natlab.NewInternet()
+	mstun := &natlab.Machine{Name: "stun"}
+	sif := mstun.Attach("eth0", inet)
+
+	m1, m1IP := natMachine(inet, "m1", 0, a)
+	m2, m2IP := natMachine(inet, "m2", 1, b)
+	d := &devices{
+		m1:     m1,
+		m1IP:   m1IP,
+		m2:     m2,
+		m2IP:   m2IP,
+		stun:   mstun,
+		stunIP: sif.V4(),
+	}
+
+	logf, closeLogf := logger.LogfCloser(t.Logf)
+	defer closeLogf()
+
+	derpMap, cleanup := runDERPAndStun(t, logf, d.stun, d.stunIP)
+	defer cleanup()
+
+	ms1 := newMagicStack(t, logger.WithPrefix(logf, "conn1: "), d.m1, derpMap)
+	defer ms1.Close()
+	ms2 := newMagicStack(t, logger.WithPrefix(logf, "conn2: "), d.m2, derpMap)
+	defer ms2.Close()
+
+	cleanup = meshStacks(logf, nil, ms1, ms2)
+	defer cleanup()
+
+	start := time.Now()
+	deadline := start.Add(*natMatrixTimeout)
+	for time.Now().Before(deadline) {
+		// Keep traffic flowing so that magicsock keeps trying to find
+		// a better path.
+		pingNATPair(ms1, ms2)
+		if ms1.Status().Peer[ms2.Public()].CurAddr != "" &&
+			ms2.Status().Peer[ms1.Public()].CurAddr != "" {
+			res.direct = true
+			res.toDirect = time.Since(start)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	res.latency, _ = pingNATPair(ms1, ms2)
+	logf("%s-%s: direct=%v toDirect=%v latency=%v", a.name, b.name, res.direct, res.toDirect, res.latency)
+	return res
+}
+
+// natMachine returns a machine attached to inet, behind a NAT of the
+// given kind if kind.nat. The idx distinguishes the LANs of the two
+// machines in a pair.
+func natMachine(inet *natlab.Network, name string, idx int, kind natKind) (*natlab.Machine, netip.Addr) {
+	m := &natlab.Machine{Name: name}
+	if !kind.nat {
+		return m, m.Attach("eth0", inet).V4()
+	}
+	m.PacketHandler = &natlab.Firewall{}
+	nat := &natlab.Machine{Name: name + "-nat"}
+	lan := &natlab.Network{
+		Name:    name + "-lan",
+		Prefix4: netip.PrefixFrom(netip.AddrFrom4([4]byte{192, 168, byte(idx), 0}), 24),
+	}
+	natWAN := nat.Attach("wan", inet)
+	natLAN := nat.Attach("lan", lan)
+	mif := m.Attach("eth0", lan)
+	lan.SetDefaultGateway(natLAN)
+	nat.PacketHandler = &natlab.SNAT44{
+		Machine:           nat,
+		ExternalInterface: natWAN,
+		Type:              kind.typ,
+		Firewall: &natlab.Firewall{
+			TrustedInterface: natLAN,
+		},
+	}
+	return m, mif.V4()
+}
+
+// pingNATPair sends a ping from src to dst and reports how long it took
+// to arrive, and whether it did within a second.
+func pingNATPair(src, dst *magicStack) (time.Duration, bool) {
+	pkt := tuntest.Ping(dst.IP(), src.IP())
+	start := time.Now()
+	src.tun.Outbound <- pkt
+	select {
+	case <-dst.tun.Inbound:
+		return time.Since(start), true
+	case <-time.After(time.Second):
+		return 0, false
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"slices"
+	"time"
+
+	"tailscale.com/net/netcheck"
+	"tailscale.com/net/netmon"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/clientmetric"
+)
+
+const (
+	// netCacheSize is the number of networks whose endpoints we
+	// remember.
+	netCacheSize = 8
+
+	// netCacheMaxAge is how long remembered endpoints are used for.
+	// Beyond that, NAT mappings are unlikely to have survived.
+	netCacheMaxAge = 24 * time.Hour
+)
+
+var (
+	// metricNetCacheHit counts the number of times we rejoined a known
+	// network and reported its remembered endpoints.
+	metricNetCacheHit = clientmetric.NewCounter("magicsock_netcache_hit")
+
+	// metricNetCacheMiss counts the number of major link changes to a
+	// network we didn't have endpoints remembered for.
+	metricNetCacheMiss = clientmetric.NewCounter("magicsock_netcache_miss")
+)
+
+// networkFingerprint identifies a network we've been on.
+//
+// It doesn't include the Wi-Fi SSID, which isn't available on most
+// platforms, nor our external IP, which isn't known until STUN completes.
+// Instead the external IP is recorded alongside the cached endpoints, and
+// the entry is replaced if STUN finds a different one.
+type networkFingerprint struct {
+	iface string     // default route interface
+	gw    netip.Addr // LAN gateway
+	gwMAC string     // gateway's hardware address, if known
+}
+
+// netCacheEntry is what we remember about a network, so that on rejoining
+// it we can report its endpoints right away, while the normal endpoint
+// update runs and replaces them if anything changed.
+type netCacheEntry struct {
+	endpoints []tailcfg.Endpoint // endpoints reported on the network
+	globalV4  string             // netcheck's GlobalV4 when they were
+	saved     time.Time
+}
+
+// currentNetworkFingerprint returns the fingerprint of the network we're
+// on, and whether it has one. Networks without a LAN gateway, such as
+// cellular links, don't.
+func (c *Conn) currentNetworkFingerprint() (fp networkFingerprint, ok bool) {
+	if c.netMon == nil {
+		return fp, false
+	}
+	gw, _, ok := netmon.LikelyHomeRouterIP()
+	if !ok {
+		return fp, false
+	}
+	fp.gw = gw
+	if st := c.netMon.InterfaceState(); st != nil {
+		fp.iface = st.DefaultRouteInterface
+	}
+	if mac, ok := netmon.GatewayMAC(gw); ok {
+		fp.gwMAC = mac.String()
+	}
+	return fp, true
+}
+
+// rememberNetwork records that endpoints were found, with the given
+// netcheck report, on the network with fingerprint fp. Only endpoints
+// from a successful STUN are worth remembering.
+func (c *Conn) rememberNetwork(fp networkFingerprint, endpoints []tailcfg.Endpoint, report *netcheck.Report) {
+	if report == nil || report.GlobalV4 == "" || !slices.ContainsFunc(endpoints, func(ep tailcfg.Endpoint) bool {
+		return ep.Type == tailcfg.EndpointSTUN || ep.Type == tailcfg.EndpointPortmapped
+	}) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.netCache.PeekOk(fp); ok && old.globalV4 != report.GlobalV4 {
+		c.dlogf("[v1] magicsock: network %v now has external address %v, was %v", fp.gw, report.GlobalV4, old.globalV4)
+	}
+	c.netCache.MaxEntries = netCacheSize
+	c.netCache.Set(fp, netCacheEntry{
+		endpoints: slices.Clone(endpoints),
+		globalV4:  report.GlobalV4,
+		saved:     time.Now(),
+	})
+}
+
+// cachedEndpoints returns the remembered endpoints for the network with
+// fingerprint fp, if they're recent enough to use.
+func (c *Conn) cachedEndpoints(fp networkFingerprint) ([]tailcfg.Endpoint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.netCache.GetOk(fp)
+	if !ok {
+		return nil, false
+	}
+	if time.Since(e.saved) > netCacheMaxAge {
+		c.netCache.Delete(fp)
+		return nil, false
+	}
+	return slices.Clone(e.endpoints), true
+}
+
+// maybeUseCachedEndpoints reports the remembered endpoints of the network
+// we're now on, if we've been on it before. It's called after a major
+// link change, ahead of the endpoint update that finds the real ones.
+func (c *Conn) maybeUseCachedEndpoints() {
+	if debugDisableNetCache() {
+		return
+	}
+	fp, ok := c.currentNetworkFingerprint()
+	if !ok {
+		return
+	}
+	endpoints, ok := c.cachedEndpoints(fp)
+	if !ok {
+		metricNetCacheMiss.Add(1)
+		return
+	}
+	metricNetCacheHit.Add(1)
+	c.logf("magicsock: rejoined known network (gw %v on %q); using %d remembered endpoints until STUN completes", fp.gw, fp.iface, len(endpoints))
+	if c.setEndpoints(endpoints) {
+		c.logEndpointChange(endpoints)
+		c.epFunc(endpoints)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+)
+
+func TestNetCache(t *testing.T) {
+	c := newConn()
+	c.logf = t.Logf
+
+	home := networkFingerprint{iface: "wlan0", gw: netip.MustParseAddr("192.168.1.1"), gwMAC: "aa:bb:cc:dd:ee:ff"}
+	// Same gateway IP as home, as most home routers have, but a
+	// different router.
+	office := networkFingerprint{iface: "wlan0", gw: netip.MustParseAddr("192.168.1.1"), gwMAC: "11:22:33:44:55:66"}
+
+	homeEPs := []tailcfg.Endpoint{
+		{Addr: netip.MustParseAddrPort("1.2.3.4:41641"), Type: tailcfg.EndpointSTUN},
+		{Addr: netip.MustParseAddrPort("192.168.1.10:41641"), Type: tailcfg.EndpointLocal},
+	}
+	homeReport := &netcheck.Report{GlobalV4: "1.2.3.4:41641"}
+
+	// Endpoints without a successful STUN or port mapping aren't
+	// remembered.
+	c.rememberNetwork(home, homeEPs[1:], homeReport)
+	if _, ok := c.cachedEndpoints(home); ok {
+		t.Fatal("remembered local-only endpoints")
+	}
+	c.rememberNetwork(home, homeEPs, new(netcheck.Report))
+	if _, ok := c.cachedEndpoints(home); ok {
+		t.Fatal("remembered endpoints without a GlobalV4")
+	}
+
+	c.rememberNetwork(home, homeEPs, homeReport)
+	got, ok := c.cachedEndpoints(home)
+	if !ok || !reflect.DeepEqual(got, homeEPs) {
+		t.Fatalf("cachedEndpoints(home) = %v, %v; want %v", got, ok, homeEPs)
+	}
+	if _, ok := c.cachedEndpoints(office); ok {
+		t.Error("office network has home's endpoints")
+	}
+
+	// A new external address on the same network replaces the entry.
+	movedEPs := []tailcfg.Endpoint{{Addr: netip.MustParseAddrPort("5.6.7.8:41641"), Type: tailcfg.EndpointSTUN}}
+	c.rememberNetwork(home, movedEPs, &netcheck.Report{GlobalV4: "5.6.7.8:41641"})
+	if got, _ := c.cachedEndpoints(home); !reflect.DeepEqual(got, movedEPs) {
+		t.Errorf("after external address change, cachedEndpoints(home) = %v; want %v", got, movedEPs)
+	}
+
+	// Stale entries aren't used.
+	c.mu.Lock()
+	e, _ := c.netCache.PeekOk(home)
+	e.saved = time.Now().Add(-netCacheMaxAge - time.Minute)
+	c.netCache.Set(home, e)
+	c.mu.Unlock()
+	if _, ok := c.cachedEndpoints(home); ok {
+		t.Error("used endpoints older than netCacheMaxAge")
+	}
+
+	// Only the most recently used networks are kept.
+	for i := range netCacheSize + 2 {
+		fp := networkFingerprint{iface: "eth0", gw: netip.AddrFrom4([4]byte{10, 0, byte(i), 1})}
+		c.rememberNetwork(fp, homeEPs, homeReport)
+	}
+	c.mu.Lock()
+	n := c.netCache.Len()
+	c.mu.Unlock()
+	if n != netCacheSize {
+		t.Errorf("cache has %d networks; want %d", n, netCacheSize)
+	}
+	first := networkFingerprint{iface: "eth0", gw: netip.MustParseAddr("10.0.0.1")}
+	if _, ok := c.cachedEndpoints(first); ok {
+		t.Errorf("least recently used network %v not evicted", first.gw)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+// pathCutoverMaxHold is the longest that sends are kept on the old path
+// after a faster one is chosen, whatever the old path's RTT.
+const pathCutoverMaxHold = 500 * time.Millisecond
+
+// sendPath is the path(s) that a data packet is sent on: a UDP address,
+// a DERP address, or both.
+type sendPath struct {
+	udp, derp netip.AddrPort
+}
+
+func (p sendPath) isValid() bool { return p.udp.IsValid() || p.derp.IsValid() }
+
+// shares reports whether p and q have a path in common, so that moving
+// sends from one to the other doesn't stop using a path.
+func (p sendPath) shares(q sendPath) bool {
+	return p.udp.IsValid() && p.udp == q.udp || p.derp.IsValid() && p.derp == q.derp
+}
+
+// pathMigration is an endpoint's move of its sends from one path to a
+// faster one, while it's being held back on the old path.
+type pathMigration struct {
+	from, to sendPath
+	start    mono.Time     // when the faster path was chosen
+	lastSend mono.Time     // last send on from
+	quiet    time.Duration // how long sends on from must pause before cutting over
+	maxHold  time.Duration // how long after start to cut over anyway
+}
+
+// quietFor reports whether sends on m.from have paused long enough, as of
+// now, that cutting over won't reorder packets.
+func (m *pathMigration) quietFor(now mono.Time) bool {
+	return now.Sub(m.lastSend) >= m.quiet
+}
+
+// pathScheduler decides when an endpoint's sends move to a new path.
+type pathScheduler interface {
+	// cutover reports whether a send at now should go on m.to rather
+	// than m.from.
+	cutover(m *pathMigration, now mono.Time) bool
+}
+
+// immediateCutover is a pathScheduler that moves to the new path on the
+// next send. Packets sent there may overtake those still in flight on the
+// old path.
+type immediateCutover struct{}
+
+func (immediateCutover) cutover(*pathMigration, mono.Time) bool { return true }
+
+// quietCutover is a pathScheduler that moves to the new path once sends
+// on the old one have paused long enough for it to drain, so that packets
+// on the faster path don't overtake them and arrive reordered, or once it
+// has held them for m.maxHold.
+type quietCutover struct{}
+
+func (quietCutover) cutover(m *pathMigration, now mono.Time) bool {
+	return m.quietFor(now) || now.Sub(m.start) >= m.maxHold
+}
+
+// currentPathScheduler returns the pathScheduler selected by
+// TS_DEBUG_PATH_CUTOVER: "quiet", or by default "immediate".
+func currentPathScheduler() pathScheduler {
+	if debugPathCutover() == "quiet" {
+		return quietCutover{}
+	}
+	return immediateCutover{}
+}
+
+// pathLatencyLocked returns the round-trip latency of p, or of its faster
+// path if it has two, and whether it's known.
+//
+// de.mu must be held.
+func (de *endpoint) pathLatencyLocked(p sendPath) (lat time.Duration, ok bool) {
+	if p.udp.IsValid() {
+		if p.udp == de.bestAddr.AddrPort && de.bestAddr.latency > 0 {
+			lat, ok = de.bestAddr.latency, true
+		} else if st, found := de.endpointState[p.udp]; found {
+			lat, ok = st.latencyLocked()
+		}
+	}
+	if p.derp.IsValid() && p.derp == de.derpAddr && de.derpLatency > 0 && (!ok || de.derpLatency < lat) {
+		lat, ok = de.derpLatency, true
+	}
+	return lat, ok
+}
+
+// pathUsableLocked reports whether p is still one of de's paths.
+//
+// de.mu must be held.
+func (de *endpoint) pathUsableLocked(p sendPath) bool {
+	if p.udp.IsValid() {
+		if _, ok := de.endpointState[p.udp]; !ok {
+			return false
+		}
+	}
+	return !p.derp.IsValid() || p.derp == de.derpAddr
+}
+
+// schedulePathLocked returns the path that a data packet sent at now
+// should use, given that addrForSendLocked chose udpAddr and derpAddr. It
+// differs from their choice while sched holds sends on the path the
+// endpoint was using before.
+//
+// de.mu must be held.
+func (de *endpoint) schedulePathLocked(sched pathScheduler, now mono.Time, udpAddr, derpAddr netip.AddrPort) (netip.AddrPort, netip.AddrPort) {
+	to := sendPath{udpAddr, derpAddr}
+	if m := de.migration; m != nil {
+		if m.to == to && de.pathUsableLocked(m.from) {
+			if !sched.cutover(m, now) {
+				m.lastSend = now
+				return m.from.udp, m.from.derp
+			}
+			if m.quietFor(now) {
+				metricPathCutoverQuiet.Add(1)
+			} else {
+				metricPathCutoverForced.Add(1)
+			}
+			de.migration = nil
+			de.txPath, de.txPathAt = to, now
+			return udpAddr, derpAddr
+		}
+		// Otherwise, the choice of path changed again, or the old one
+		// went away; start over from the old one.
+		de.migration = nil
+		de.txPath, de.txPathAt = m.from, m.lastSend
+	}
+
+	from, lastSend := de.txPath, de.txPathAt
+	de.txPath, de.txPathAt = to, now
+	if !from.isValid() || !to.isValid() || from.shares(to) {
+		return udpAddr, derpAddr
+	}
+	metricPathMigrations.Add(1)
+	oldLat, ok := de.pathLatencyLocked(from)
+	if !ok || !de.pathUsableLocked(from) {
+		return udpAddr, derpAddr
+	}
+	newLat, ok := de.pathLatencyLocked(to)
+	if !ok || newLat >= oldLat {
+		// Packets on the new path won't overtake those on the old.
+		return udpAddr, derpAddr
+	}
+	m := &pathMigration{
+		from:     from,
+		to:       to,
+		start:    now,
+		lastSend: lastSend,
+		quiet:    (oldLat - newLat) / 2,
+		maxHold:  min(oldLat, pathCutoverMaxHold),
+	}
+	if m.quietFor(now) {
+		metricPathCutoverQuiet.Add(1)
+		return udpAddr, derpAddr
+	}
+	if sched.cutover(m, now) {
+		metricPathCutoverForced.Add(1)
+		return udpAddr, derpAddr
+	}
+	metricPathCutoverHeld.Add(1)
+	m.lastSend = now
+	de.migration = m
+	de.txPath, de.txPathAt = from, now
+	return from.udp, from.derp
+}
@@ -0,0 +1,190 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/mono"
+)
+
+func TestCurrentPathScheduler(t *testing.T) {
+	for _, tt := range []struct {
+		env  string
+		want pathScheduler
+	}{
+		{"", immediateCutover{}},
+		{"immediate", immediateCutover{}},
+		{"quiet", quietCutover{}},
+	} {
+		envknob.Setenv("TS_DEBUG_PATH_CUTOVER", tt.env)
+		if got := currentPathScheduler(); got != tt.want {
+			t.Errorf("TS_DEBUG_PATH_CUTOVER=%q: got %T; want %T", tt.env, got, tt.want)
+		}
+	}
+	envknob.Setenv("TS_DEBUG_PATH_CUTOVER", "")
+}
+
+func TestSchedulePath(t *testing.T) {
+	derp := netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 1)
+	udp := netip.MustParseAddrPort("1.2.3.4:41641")
+	udp2 := netip.MustParseAddrPort("5.6.7.8:41641")
+	newEndpoint := func(derpLatency, udpLatency time.Duration) *endpoint {
+		return &endpoint{
+			derpAddr:    derp,
+			derpLatency: derpLatency,
+			endpointState: map[netip.AddrPort]*endpointState{
+				udp:  {recentPongs: []pongReply{{latency: udpLatency}}},
+				udp2: {},
+			},
+		}
+	}
+	pathOf := func(udpAddr, derpAddr netip.AddrPort) sendPath { return sendPath{udpAddr, derpAddr} }
+	onDERP := sendPath{derp: derp}
+	onUDP := sendPath{udp: udp}
+
+	// step is a send at the given offset from the start, with
+	// addrForSendLocked choosing want, and the path it should use.
+	type step struct {
+		at   time.Duration
+		want sendPath
+		use  sendPath
+	}
+	tests := []struct {
+		name        string
+		sched       pathScheduler
+		derpLatency time.Duration
+		udpLatency  time.Duration
+		steps       []step
+		wantHeld    int64
+		wantQuiet   int64
+		wantForced  int64
+	}{
+		{
+			name:        "cutover-after-quiet",
+			sched:       quietCutover{},
+			derpLatency: 100 * time.Millisecond,
+			udpLatency:  10 * time.Millisecond,
+			steps: []step{
+				{0, onDERP, onDERP},
+				{time.Millisecond, onUDP, onDERP},
+				{2 * time.Millisecond, onUDP, onDERP},
+				// Quiet on DERP for 45ms, half the RTT difference.
+				{47 * time.Millisecond, onUDP, onUDP},
+				{48 * time.Millisecond, onUDP, onUDP},
+			},
+			wantHeld:  1,
+			wantQuiet: 1,
+		},
+		{
+			name:        "cutover-after-max-hold",
+			sched:       quietCutover{},
+			derpLatency: 100 * time.Millisecond,
+			udpLatency:  10 * time.Millisecond,
+			steps: []step{
+				{0, onDERP, onDERP},
+				{10 * time.Millisecond, onUDP, onDERP},
+				{40 * time.Millisecond, onUDP, onDERP},
+				{70 * time.Millisecond, onUDP, onDERP},
+				{100 * time.Millisecond, onUDP, onDERP},
+				// One DERP RTT after the switch.
+				{110 * time.Millisecond, onUDP, onUDP},
+			},
+			wantHeld:   1,
+			wantForced: 1,
+		},
+		{
+			name:        "already-quiet",
+			sched:       quietCutover{},
+			derpLatency: 100 * time.Millisecond,
+			udpLatency:  10 * time.Millisecond,
+			steps: []step{
+				{0, onDERP, onDERP},
+				{time.Second, onUDP, onUDP},
+			},
+			wantQuiet: 1,
+		},
+		{
+			name:        "immediate",
+			sched:       immediateCutover{},
+			derpLatency: 100 * time.Millisecond,
+			udpLatency:  10 * time.Millisecond,
+			steps: []step{
+				{0, onDERP, onDERP},
+				{time.Millisecond, onUDP, onUDP},
+			},
+			wantForced: 1,
+		},
+		{
+			name:        "new-path-slower",
+			sched:       quietCutover{},
+			derpLatency: 10 * time.Millisecond,
+			udpLatency:  100 * time.Millisecond,
+			steps: []step{
+				{0, onDERP, onDERP},
+				{time.Millisecond, onUDP, onUDP},
+			},
+		},
+		{
+			name:       "old-path-latency-unknown",
+			sched:      quietCutover{},
+			udpLatency: 10 * time.Millisecond,
+			steps: []step{
+				{0, onDERP, onDERP},
+				{time.Millisecond, onUDP, onUDP},
+			},
+		},
+		{
+			name:        "shared-path",
+			sched:       quietCutover{},
+			derpLatency: 100 * time.Millisecond,
+			udpLatency:  10 * time.Millisecond,
+			steps: []step{
+				{0, pathOf(udp, derp), pathOf(udp, derp)},
+				{time.Millisecond, onUDP, onUDP},
+			},
+		},
+		{
+			name:        "choice-changes-while-held",
+			sched:       quietCutover{},
+			derpLatency: 100 * time.Millisecond,
+			udpLatency:  10 * time.Millisecond,
+			steps: []step{
+				{0, onDERP, onDERP},
+				{time.Millisecond, onUDP, onDERP},
+				// Back to DERP before cutting over.
+				{2 * time.Millisecond, onDERP, onDERP},
+				// udp2's latency is unknown, so it's used at once.
+				{3 * time.Millisecond, sendPath{udp: udp2}, sendPath{udp: udp2}},
+			},
+			wantHeld: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			de := newEndpoint(tt.derpLatency, tt.udpLatency)
+			held, quiet, forced := metricPathCutoverHeld.Value(), metricPathCutoverQuiet.Value(), metricPathCutoverForced.Value()
+			start := mono.Now()
+			for i, s := range tt.steps {
+				u, d := de.schedulePathLocked(tt.sched, start.Add(s.at), s.want.udp, s.want.derp)
+				if got := pathOf(u, d); got != s.use {
+					t.Errorf("step %d at %v: used %v; want %v", i, s.at, got, s.use)
+				}
+			}
+			if got := metricPathCutoverHeld.Value() - held; got != tt.wantHeld {
+				t.Errorf("held %d migrations; want %d", got, tt.wantHeld)
+			}
+			if got := metricPathCutoverQuiet.Value() - quiet; got != tt.wantQuiet {
+				t.Errorf("%d quiet cutovers; want %d", got, tt.wantQuiet)
+			}
+			if got := metricPathCutoverForced.Value() - forced; got != tt.wantForced {
+				t.Errorf("%d forced cutovers; want %d", got, tt.wantForced)
+			}
+		})
+	}
+}
@@ -4,8 +4,10 @@
 package magicsock
 
 import (
+	"encoding/binary"
 	"net/netip"
 
+	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 	"tailscale.com/util/set"
@@ -29,10 +31,16 @@ func newPeerInfo(ep *endpoint) *peerInfo {
 	}
 }
 
+// peerMapShards is the number of shards in each of peerMap's concurrent
+// indexes.
+const peerMapShards = 32
+
 // peerMap is an index of peerInfos by node (WireGuard) key, disco
 // key, and discovered ip:port endpoints.
 //
-// It doesn't do any locking; all access must be done with Conn.mu held.
+// It doesn't do any locking of its own; all access must be done with
+// Conn.mu held, except for the methods documented as safe to call without
+// it.
 type peerMap struct {
 	byNodeKey map[key.NodePublic]*peerInfo
 	byIPPort  map[netip.AddrPort]*peerInfo
@@ -41,6 +49,15 @@ type peerMap struct {
 	// nodesOfDisco contains the set of nodes that are using a
 	// DiscoKey. Usually those sets will be just one node.
 	nodesOfDisco map[key.DiscoPublic]set.Set[key.NodePublic]
+
+	// epByNodeKey and epByIPPort mirror the endpoints in byNodeKey and
+	// byIPPort for the packet receive paths, which look them up without
+	// Conn.mu. On tailnets with thousands of peers, a netmap update holds
+	// Conn.mu for long enough that received packets would otherwise pile
+	// up behind it. They're only modified with Conn.mu held, alongside
+	// the maps that they mirror.
+	epByNodeKey *syncs.ShardedMap[key.NodePublic, *endpoint]
+	epByIPPort  *syncs.ShardedMap[netip.AddrPort, *endpoint]
 }
 
 func newPeerMap() peerMap {
@@ -49,9 +66,27 @@ func newPeerMap() peerMap {
 		byIPPort:     map[netip.AddrPort]*peerInfo{},
 		byNodeID:     map[tailcfg.NodeID]*peerInfo{},
 		nodesOfDisco: map[key.DiscoPublic]set.Set[key.NodePublic]{},
+		epByNodeKey:  syncs.NewShardedMap[key.NodePublic, *endpoint](peerMapShards, nodeKeyShard),
+		epByIPPort:   syncs.NewShardedMap[netip.AddrPort, *endpoint](peerMapShards, ipPortShard),
 	}
 }
 
+// nodeKeyShard returns the epByNodeKey shard for k. Node keys are
+// uniformly random, so any of their bytes will do.
+func nodeKeyShard(k key.NodePublic) int {
+	raw := k.Raw32()
+	return int(raw[0]) % peerMapShards
+}
+
+// ipPortShard returns the epByIPPort shard for ipp.
+func ipPortShard(ipp netip.AddrPort) int {
+	a := ipp.Addr().As16()
+	h := binary.BigEndian.Uint64(a[:8]) ^ binary.BigEndian.Uint64(a[8:]) ^ uint64(ipp.Port())
+	h ^= h >> 32
+	h ^= h >> 16
+	return int(h % peerMapShards)
+}
+
 // nodeCount returns the number of nodes currently in m.
 func (m *peerMap) nodeCount() int {
 	if len(m.byNodeKey) != len(m.byNodeID) {
@@ -97,6 +132,21 @@ func (m *peerMap) endpointForIPPort(ipp netip.AddrPort) (ep *endpoint, ok bool)
 	return nil, false
 }
 
+// endpointForNodeKeyConcurrent is like endpointForNodeKey, but is safe to
+// call without Conn.mu held.
+func (m *peerMap) endpointForNodeKeyConcurrent(nk key.NodePublic) (ep *endpoint, ok bool) {
+	if nk.IsZero() {
+		return nil, false
+	}
+	return m.epByNodeKey.GetOk(nk)
+}
+
+// endpointForIPPortConcurrent is like endpointForIPPort, but is safe to
+// call without Conn.mu held.
+func (m *peerMap) endpointForIPPortConcurrent(ipp netip.AddrPort) (ep *endpoint, ok bool) {
+	return m.epByIPPort.GetOk(ipp)
+}
+
 // forEachEndpoint invokes f on every endpoint in m.
 func (m *peerMap) forEachEndpoint(f func(ep *endpoint)) {
 	for _, pi := range m.byNodeKey {
@@ -135,6 +185,7 @@ func (m *peerMap) upsertEndpoint(ep *endpoint, oldDiscoKey key.DiscoPublic) {
 	if !ok {
 		pi = newPeerInfo(ep)
 		m.byNodeKey[ep.publicKey] = pi
+		m.epByNodeKey.Set(ep.publicKey, ep)
 	}
 	m.byNodeID[ep.nodeID] = pi
 
@@ -177,6 +228,9 @@ func (m *peerMap) setNodeKeyForIPPort(ipp netip.AddrPort, nk key.NodePublic) {
 	if pi, ok := m.byNodeKey[nk]; ok {
 		pi.ipPorts.Add(ipp)
 		m.byIPPort[ipp] = pi
+		m.epByIPPort.Set(ipp, pi.ep)
+	} else {
+		m.epByIPPort.Delete(ipp)
 	}
 }
 
@@ -195,6 +249,7 @@ func (m *peerMap) deleteEndpoint(ep *endpoint) {
 		delete(m.nodesOfDisco[epDisco.key], ep.publicKey)
 	}
 	delete(m.byNodeKey, ep.publicKey)
+	m.epByNodeKey.Delete(ep.publicKey)
 	if was, ok := m.byNodeID[ep.nodeID]; ok && was.ep == ep {
 		delete(m.byNodeID, ep.nodeID)
 	}
@@ -205,5 +260,6 @@ func (m *peerMap) deleteEndpoint(ep *endpoint) {
 	}
 	for ip := range pi.ipPorts {
 		delete(m.byIPPort, ip)
+		m.epByIPPort.Delete(ip)
 	}
 }
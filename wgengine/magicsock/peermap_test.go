@@ -0,0 +1,160 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+)
+
+// newTestPeerMapEndpoints returns n endpoints and an ip:port for each, for
+// populating a peerMap.
+func newTestPeerMapEndpoints(n int) ([]*endpoint, []netip.AddrPort) {
+	c := &Conn{logf: logger.Discard}
+	eps := make([]*endpoint, n)
+	ipps := make([]netip.AddrPort, n)
+	for i := range n {
+		ep := &endpoint{
+			c:         c,
+			nodeID:    tailcfg.NodeID(i + 1),
+			publicKey: randNodeKey(),
+		}
+		dk := randDiscoKey()
+		ep.disco.Store(&endpointDisco{key: dk, short: dk.ShortString()})
+		eps[i] = ep
+		ipps[i] = netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)}), 41641)
+	}
+	return eps, ipps
+}
+
+// updateTestPeerMap upserts eps into m and points ipps at them, as a netmap
+// update and the pongs that follow it would.
+func updateTestPeerMap(m *peerMap, eps []*endpoint, ipps []netip.AddrPort) {
+	for i, ep := range eps {
+		m.upsertEndpoint(ep, ep.disco.Load().key)
+		m.setNodeKeyForIPPort(ipps[i], ep.publicKey)
+	}
+}
+
+func TestPeerMapConcurrentLookups(t *testing.T) {
+	var mu sync.Mutex // stands in for Conn.mu
+	m := newPeerMap()
+	eps, ipps := newTestPeerMapEndpoints(100)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 1000 {
+			ep, ok := m.endpointForIPPortConcurrent(ipps[i%len(ipps)])
+			if ok && ep != eps[i%len(eps)] {
+				t.Errorf("endpointForIPPortConcurrent(%v) returned the wrong endpoint", ipps[i%len(ipps)])
+			}
+			if ep, ok := m.endpointForNodeKeyConcurrent(eps[i%len(eps)].publicKey); ok && ep != eps[i%len(eps)] {
+				t.Errorf("endpointForNodeKeyConcurrent returned the wrong endpoint")
+			}
+		}
+	}()
+	for range 10 {
+		mu.Lock()
+		updateTestPeerMap(&m, eps, ipps)
+		for _, ep := range eps[:len(eps)/2] {
+			m.deleteEndpoint(ep)
+		}
+		mu.Unlock()
+	}
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err := m.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, ep := range eps[:len(eps)/2] {
+		if _, ok := m.endpointForNodeKeyConcurrent(ep.publicKey); ok {
+			t.Errorf("deleted endpoint %v still found by node key", ep.publicKey.ShortString())
+		}
+	}
+}
+
+// BenchmarkPeerMapLookupDuringUpdate measures how long the packet receive
+// path takes to find a peer's endpoint while large netmap updates are
+// going on, with the lookup done under the same lock as the updates
+// ("locked", as it used to be) or without it ("concurrent").
+//
+// Packets arrive at a fixed interval and each one's latency is counted
+// from when it arrived, so that time spent waiting for an update to
+// finish is included.
+func BenchmarkPeerMapLookupDuringUpdate(b *testing.B) {
+	const (
+		numPeers       = 5000
+		packetInterval = 10 * time.Microsecond
+		betweenUpdates = time.Millisecond
+	)
+	for _, concurrent := range []bool{false, true} {
+		name := "locked"
+		if concurrent {
+			name = "concurrent"
+		}
+		b.Run(fmt.Sprintf("%s/peers=%d", name, numPeers), func(b *testing.B) {
+			var mu sync.Mutex // stands in for Conn.mu
+			m := newPeerMap()
+			eps, ipps := newTestPeerMapEndpoints(numPeers)
+			updateTestPeerMap(&m, eps, ipps)
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					mu.Lock()
+					updateTestPeerMap(&m, eps, ipps)
+					mu.Unlock()
+					select {
+					case <-stop:
+						return
+					case <-time.After(betweenUpdates):
+					}
+				}
+			}()
+
+			lat := make([]time.Duration, 0, b.N)
+			b.ResetTimer()
+			arrival := time.Now()
+			for i := range b.N {
+				for time.Now().Before(arrival) {
+					// Wait for the next packet.
+				}
+				ipp := ipps[i%numPeers]
+				var ok bool
+				if concurrent {
+					_, ok = m.endpointForIPPortConcurrent(ipp)
+				} else {
+					mu.Lock()
+					_, ok = m.endpointForIPPort(ipp)
+					mu.Unlock()
+				}
+				lat = append(lat, time.Since(arrival))
+				if !ok {
+					b.Fatalf("no endpoint for %v", ipp)
+				}
+				arrival = arrival.Add(packetInterval)
+			}
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+
+			slices.Sort(lat)
+			b.ReportMetric(float64(lat[len(lat)/2].Nanoseconds()), "p50-ns")
+			b.ReportMetric(float64(lat[len(lat)*99/100].Nanoseconds()), "p99-ns")
+		})
+	}
+}
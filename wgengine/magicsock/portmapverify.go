@@ -0,0 +1,178 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"tailscale.com/net/dnscache"
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// portMapHairpinTimeout is how long verifyPortMapping waits for a
+	// probe sent to our own external address to come back.
+	portMapHairpinTimeout = 500 * time.Millisecond
+
+	// portMapEchoTimeout is how long verifyPortMapping waits for a DERP
+	// STUN server to answer, when the router doesn't hairpin.
+	portMapEchoTimeout = 2 * time.Second
+)
+
+// verifyPortMapping checks that UDP traffic sent to a new port mapping's
+// external address reaches c.pconn4. It's registered with the portmapper
+// as its mapping verifier, and only returns an error when there's evidence
+// that the mapping doesn't work.
+//
+// It first sends a STUN request from pconn4 to external and waits for
+// the router to hairpin it back, which proves that the mapping works. Not
+// all routers hairpin, and nothing else we can do from the inside proves
+// that an inbound mapping works, so failing that it only checks that a
+// STUN server in our home DERP region sees us at external's IP address.
+// If it sees another one, there's another NAT beyond the gateway, and the
+// mapping can't be reached from the internet. The port that the server
+// sees is that of our outbound NAT state, not of the mapping, so it's not
+// compared.
+func (c *Conn) verifyPortMapping(ctx context.Context, external netip.AddrPort) error {
+	tx := stun.NewTxID()
+	got, err := c.sendPortMapProbe(ctx, tx, external, portMapHairpinTimeout)
+	if err != nil {
+		return err
+	}
+	if got.IsValid() {
+		c.dlogf("[v1] magicsock: port mapping %v verified by hairpin", external)
+		return nil
+	}
+
+	stunAddr, err := c.homeDERPSTUNAddr(ctx)
+	if err != nil {
+		return fmt.Errorf("no hairpin; %w", err)
+	}
+	tx = stun.NewTxID()
+	got, err = c.sendPortMapProbe(ctx, tx, stunAddr, portMapEchoTimeout)
+	if err != nil {
+		return err
+	}
+	if !got.IsValid() {
+		return fmt.Errorf("no hairpin and no STUN reply from %v", stunAddr)
+	}
+	if got.Addr() != external.Addr() {
+		return fmt.Errorf("no hairpin and STUN server %v saw us at %v, not at the mapping's IP", stunAddr, got.Addr())
+	}
+	c.dlogf("[v1] magicsock: port mapping %v not hairpinned; STUN server %v agrees on its IP", external, stunAddr)
+	return nil
+}
+
+// sendPortMapProbe sends a STUN request with transaction ID tx from
+// pconn4 to dst and waits up to timeout for it to be answered, as
+// reported by handlePortMapProbe. It returns the zero value if there was
+// no answer.
+func (c *Conn) sendPortMapProbe(ctx context.Context, tx stun.TxID, dst netip.AddrPort, timeout time.Duration) (netip.AddrPort, error) {
+	ch := make(chan netip.AddrPort, 1)
+	c.mu.Lock()
+	if c.portMapProbes == nil {
+		c.portMapProbes = map[stun.TxID]chan<- netip.AddrPort{}
+	}
+	c.portMapProbes[tx] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.portMapProbes, tx)
+		c.mu.Unlock()
+	}()
+
+	if _, err := c.pconn4.WriteToUDPAddrPort(stun.Request(tx), dst); err != nil {
+		return netip.AddrPort{}, err
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case got := <-ch:
+		return got, nil
+	case <-t.C:
+		return netip.AddrPort{}, nil
+	case <-ctx.Done():
+		return netip.AddrPort{}, ctx.Err()
+	}
+}
+
+// handlePortMapProbe reports whether the STUN packet b is the answer to
+// an outstanding sendPortMapProbe call, and if so, delivers it.
+//
+// A hairpinned probe arrives as our own binding request; its answer is
+// the address it was sent to, which the caller already knows, so src is
+// delivered. A STUN server's answer is its binding response, which
+// carries the address the server saw the request come from.
+func (c *Conn) handlePortMapProbe(b []byte, src netip.AddrPort) bool {
+	var tx stun.TxID
+	var addr netip.AddrPort
+	if t, err := stun.ParseBindingRequest(b); err == nil {
+		tx, addr = t, src
+	} else if t, a, err := stun.ParseResponse(b); err == nil {
+		tx, addr = t, a
+	} else {
+		return false
+	}
+
+	c.mu.Lock()
+	ch, ok := c.portMapProbes[tx]
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- addr:
+	default:
+	}
+	return true
+}
+
+var errNoDERPSTUN = errors.New("no STUN server in home DERP region")
+
+// homeDERPSTUNAddr returns the IPv4 STUN address of a node in our home
+// DERP region.
+func (c *Conn) homeDERPSTUNAddr(ctx context.Context) (netip.AddrPort, error) {
+	c.mu.Lock()
+	var reg *tailcfg.DERPRegion
+	if c.derpMap != nil {
+		reg = c.derpMap.Regions[c.myDerp]
+	}
+	c.mu.Unlock()
+	if reg == nil {
+		return netip.AddrPort{}, errNoDERPSTUN
+	}
+	for _, n := range reg.Nodes {
+		if n.STUNPort < 0 || n.IPv4 == "none" {
+			continue
+		}
+		port := uint16(3478)
+		if n.STUNPort != 0 {
+			port = uint16(n.STUNPort)
+		}
+		if ip, err := netip.ParseAddr(n.IPv4); err == nil && ip.Is4() {
+			return netip.AddrPortFrom(ip, port), nil
+		}
+		if n.IPv4 != "" {
+			continue
+		}
+		// Like the DERP client, resolve through the shared
+		// dnscache, which remembers the last good answer for when
+		// DNS isn't working.
+		_, _, ips, err := dnscache.Get().LookupIP(ctx, n.HostName)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Is4() {
+				return netip.AddrPortFrom(ip, port), nil
+			}
+		}
+	}
+	return netip.AddrPort{}, fmt.Errorf("%w %q", errNoDERPSTUN, reg.RegionCode)
+}
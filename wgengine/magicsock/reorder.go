@@ -0,0 +1,253 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+// derpReorderDirectRecency is how recently a peer must have sent us
+// something over UDP for the DERP receive path to hold back an early DERP
+// packet. Without recent direct traffic there's no second path that could
+// be delivering the missing packets, so a gap is plain loss and waiting
+// would only add latency.
+const derpReorderDirectRecency = 2 * time.Second
+
+// wgDataHeader returns the receiver index and counter of b if b is a
+// WireGuard transport data message. Those header fields are sent in the
+// clear.
+func wgDataHeader(b []byte) (receiver uint32, counter uint64, ok bool) {
+	const messageTransportType = 4
+	if len(b) < 16 || b[0] != messageTransportType {
+		return 0, 0, false
+	}
+	return binary.LittleEndian.Uint32(b[4:8]), binary.LittleEndian.Uint64(b[8:16]), true
+}
+
+// trackRxOrder reports whether to track the order of WireGuard data
+// packets received from peers, for the reorder statistics and the DERP
+// reorder window. It costs a lock per received packet, so it's only done
+// while TS_DEBUG_RX_ORDER or TS_DEBUG_DERP_REORDER_WINDOW is set.
+func trackRxOrder() bool {
+	return debugRxOrder() || debugDERPReorderWindow() > 0
+}
+
+// rxOrderSessions is how many WireGuard sessions per peer rxOrder tracks.
+// wireguard-go keeps up to three keypairs per peer (previous, current and
+// next), and packets sent under the previous one can still be arriving,
+// such as over the slower path, after a rekey.
+const rxOrderSessions = 3
+
+// rxOrder tracks the order of WireGuard data packets received from a peer
+// across all paths, per session (keypair, as identified by its receiver
+// index), and the arrival jitter on the DERP path.
+//
+// It's only used while trackRxOrder reports true.
+type rxOrder struct {
+	mu       sync.Mutex
+	sessions [rxOrderSessions]rxSession // guarded by mu
+	next     int                        // index in sessions to reuse next; guarded by mu
+
+	// The following are only written by the DERP receive goroutine.
+	derpLastRecv mono.Time
+	derpLastGap  time.Duration
+	derpJitter   atomic.Int64 // smoothed inter-arrival variation, in nanoseconds
+
+	derpReordered atomic.Int64 // DERP packets that arrived after a later packet
+	derpHeld      atomic.Int64 // DERP packets held waiting for earlier ones
+}
+
+// rxSession is the order of the data packets received in one WireGuard
+// session.
+type rxSession struct {
+	valid    bool
+	receiver uint32 // receiver index of the session
+	highest  uint64 // highest counter seen
+}
+
+// sessionLocked returns the session with the given receiver index, or nil
+// if it's not tracked.
+//
+// o.mu must be held.
+func (o *rxOrder) sessionLocked(receiver uint32) *rxSession {
+	for i := range o.sessions {
+		if s := &o.sessions[i]; s.valid && s.receiver == receiver {
+			return s
+		}
+	}
+	return nil
+}
+
+// peek returns b's counter and the highest counter seen so far in b's
+// session, and whether b is a data packet in a tracked session.
+func (o *rxOrder) peek(b []byte) (counter, highest uint64, ok bool) {
+	recv, counter, ok := wgDataHeader(b)
+	if !ok {
+		return 0, 0, false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s := o.sessionLocked(recv)
+	if s == nil {
+		return counter, 0, false
+	}
+	return counter, s.highest, true
+}
+
+// observe records b, if it's a WireGuard data packet, as received. The
+// first packet of a session that isn't tracked starts tracking it, in
+// place of the session that started longest ago.
+func (o *rxOrder) observe(b []byte) {
+	recv, counter, ok := wgDataHeader(b)
+	if !ok {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if s := o.sessionLocked(recv); s != nil {
+		s.highest = max(s.highest, counter)
+		return
+	}
+	o.sessions[o.next] = rxSession{valid: true, receiver: recv, highest: counter}
+	o.next = (o.next + 1) % len(o.sessions)
+}
+
+// seen reports whether a packet with at least the given counter has been
+// received in the session with the given receiver index, or the session is
+// no longer tracked.
+func (o *rxOrder) seen(receiver uint32, counter uint64) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s := o.sessionLocked(receiver)
+	return s == nil || s.highest >= counter
+}
+
+// noteDERPRecv updates the DERP jitter estimate with an arrival at now,
+// in the manner of RFC 3550's interarrival jitter but using successive
+// inter-arrival gaps, as there are no send timestamps to compare with.
+func (o *rxOrder) noteDERPRecv(now mono.Time) {
+	if o.derpLastRecv != 0 {
+		gap := now.Sub(o.derpLastRecv)
+		if o.derpLastGap != 0 {
+			d := gap - o.derpLastGap
+			if d < 0 {
+				d = -d
+			}
+			j := o.derpJitter.Load()
+			o.derpJitter.Store(j + (int64(d)-j)/16)
+		}
+		o.derpLastGap = gap
+	}
+	o.derpLastRecv = now
+}
+
+// DERPJitter returns the smoothed variation in packet inter-arrival
+// times on the DERP path.
+func (o *rxOrder) DERPJitter() time.Duration {
+	return time.Duration(o.derpJitter.Load())
+}
+
+// noteDERPDataPacket records the DERP data packet b from ep for the
+// reorder and jitter statistics. If TS_DEBUG_DERP_REORDER_WINDOW is set,
+// held holds nothing, and b arrived ahead of packets that are likely still
+// in flight on the direct path, it instead puts b in held and reports
+// true. The caller must then deliver b when held is ready, so that
+// wireguard-go and the TCP connections inside see fewer reorderings while
+// a peer moves between DERP and a direct path.
+//
+// It must only be called while trackRxOrder reports true.
+func (c *Conn) noteDERPDataPacket(ep *endpoint, b []byte, held *heldDERPPacket) (holding bool) {
+	o := &ep.rxOrder
+	now := mono.Now()
+	o.noteDERPRecv(now)
+	counter, highest, ok := o.peek(b)
+	if !ok {
+		o.observe(b)
+		return false
+	}
+	switch {
+	case counter < highest:
+		o.derpReordered.Add(1)
+		metricRecvDataDERPReordered.Add(1)
+		if now.Sub(ep.lastRecvUDPAny.LoadAtomic()) < derpReorderDirectRecency {
+			metricRecvDataMigrationReordered.Add(1)
+		}
+	case counter > highest+1:
+		metricRecvDataDERPGap.Add(1)
+		if w := debugDERPReorderWindow(); w > 0 && held.ep == nil && now.Sub(ep.lastRecvUDPAny.LoadAtomic()) < derpReorderDirectRecency {
+			o.derpHeld.Add(1)
+			metricRecvDataDERPHeld.Add(1)
+			held.hold(ep, b, now.Add(w))
+			return true
+		}
+	}
+	o.observe(b)
+	return false
+}
+
+// heldDERPPacket is a DERP data packet that receiveDERP is holding back
+// because it arrived ahead of earlier packets from the same peer. Other
+// DERP packets are delivered meanwhile. The zero value holds nothing.
+type heldDERPPacket struct {
+	buf      []byte    // the packet, copied out of wireguard-go's buffer
+	ep       *endpoint // the packet's sender; nil if nothing is held
+	receiver uint32    // receiver index of the packet
+	want     uint64    // counter to wait for, the one before the packet's
+	start    mono.Time // when the packet was held
+	deadline mono.Time // when to deliver the packet regardless
+}
+
+// hold holds b, a data packet from ep, until the packet before it arrives
+// or deadline passes.
+func (h *heldDERPPacket) hold(ep *endpoint, b []byte, deadline mono.Time) {
+	recv, counter, _ := wgDataHeader(b)
+	h.buf = append(h.buf[:0], b...)
+	h.ep = ep
+	h.receiver = recv
+	h.want = counter - 1
+	h.start = mono.Now()
+	h.deadline = deadline
+}
+
+// ready reports whether the held packet should be delivered as of now.
+func (h *heldDERPPacket) ready(now mono.Time) bool {
+	return h.ep.rxOrder.seen(h.receiver, h.want) || !now.Before(h.deadline)
+}
+
+// pollInterval returns how long to wait, as of now, before checking again
+// whether the held packet is ready.
+func (h *heldDERPPacket) pollInterval(now mono.Time) time.Duration {
+	step := max(h.deadline.Sub(h.start)/8, 50*time.Microsecond)
+	return min(step, h.deadline.Sub(now))
+}
+
+// release copies the held packet into b, records it as received, and
+// returns its length and sender. h then holds nothing.
+func (h *heldDERPPacket) release(b []byte) (int, *endpoint) {
+	ep := h.ep
+	if !ep.rxOrder.seen(h.receiver, h.want) {
+		metricRecvDataDERPHeldTimeout.Add(1)
+	}
+	n := copy(b, h.buf)
+	ep.rxOrder.observe(b[:n])
+	h.ep = nil
+	return n, ep
+}
+
+// noteDirectDataPacket records b, received from ep over UDP at now, for
+// the reorder statistics.
+//
+// It must only be called while trackRxOrder reports true.
+func noteDirectDataPacket(ep *endpoint, b []byte, now mono.Time) {
+	o := &ep.rxOrder
+	if counter, highest, ok := o.peek(b); ok && counter < highest && now.Sub(ep.lastRecvDERP.LoadAtomic()) < derpReorderDirectRecency {
+		metricRecvDataMigrationReordered.Add(1)
+	}
+	o.observe(b)
+}
@@ -0,0 +1,160 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+func wgDataPacket(receiver uint32, counter uint64) []byte {
+	b := make([]byte, 32)
+	b[0] = 4
+	binary.LittleEndian.PutUint32(b[4:8], receiver)
+	binary.LittleEndian.PutUint64(b[8:16], counter)
+	return b
+}
+
+func TestRxOrder(t *testing.T) {
+	var o rxOrder
+	if _, _, ok := o.peek(wgDataPacket(1, 0)); ok {
+		t.Fatal("peek before any packet in session reported ok")
+	}
+	o.observe(wgDataPacket(1, 0))
+	o.observe(wgDataPacket(1, 5))
+	o.observe(wgDataPacket(1, 3)) // late; mustn't lower highest
+
+	counter, highest, ok := o.peek(wgDataPacket(1, 4))
+	if !ok || counter != 4 || highest != 5 {
+		t.Errorf("peek = %v, %v, %v; want 4, 5, true", counter, highest, ok)
+	}
+
+	// A new session (rekey) starts over.
+	o.observe(wgDataPacket(2, 0))
+	if _, highest, ok := o.peek(wgDataPacket(2, 1)); !ok || highest != 0 {
+		t.Errorf("after rekey, peek = %v, %v; want 0, true", highest, ok)
+	}
+
+	// Packets still arriving in the old session are tracked in it,
+	// rather than restarting either session.
+	o.observe(wgDataPacket(1, 7))
+	o.observe(wgDataPacket(2, 2))
+	if _, highest, ok := o.peek(wgDataPacket(1, 6)); !ok || highest != 7 {
+		t.Errorf("old session: peek = %v, %v; want 7, true", highest, ok)
+	}
+	if _, highest, ok := o.peek(wgDataPacket(2, 1)); !ok || highest != 2 {
+		t.Errorf("new session: peek = %v, %v; want 2, true", highest, ok)
+	}
+
+	// Beyond rxOrderSessions, the session that started longest ago is
+	// forgotten.
+	o.observe(wgDataPacket(3, 0))
+	o.observe(wgDataPacket(4, 0))
+	if _, _, ok := o.peek(wgDataPacket(1, 8)); ok {
+		t.Error("peek in oldest session reported ok after it was replaced")
+	}
+	if !o.seen(1, 100) {
+		t.Error("seen in forgotten session = false; want true")
+	}
+
+	// Non-data packets are ignored.
+	o.observe([]byte{1, 0, 0, 0})
+	if _, _, ok := o.peek([]byte{1, 0, 0, 0}); ok {
+		t.Error("peek of handshake packet reported ok")
+	}
+}
+
+func TestHeldDERPPacket(t *testing.T) {
+	ep := &endpoint{}
+	ep.rxOrder.observe(wgDataPacket(1, 10))
+	now := mono.Now()
+
+	var h heldDERPPacket
+	pkt := wgDataPacket(1, 12)
+	h.hold(ep, pkt, now.Add(time.Second))
+	pkt[8] = 0 // h must have its own copy
+	if h.ready(now) {
+		t.Fatal("ready before the earlier packet arrived")
+	}
+
+	// The earlier packet arrives over the direct path.
+	ep.rxOrder.observe(wgDataPacket(1, 11))
+	if !h.ready(now) {
+		t.Fatal("not ready after the earlier packet arrived")
+	}
+	timeouts := metricRecvDataDERPHeldTimeout.Value()
+	buf := make([]byte, 64)
+	n, got := h.release(buf)
+	if got != ep || n != len(pkt) {
+		t.Fatalf("release = %v, %p; want %v, %p", n, got, len(pkt), ep)
+	}
+	if _, counter, _ := wgDataHeader(buf[:n]); counter != 12 {
+		t.Errorf("released packet has counter %v; want 12", counter)
+	}
+	if _, highest, _ := ep.rxOrder.peek(pkt); highest != 12 {
+		t.Errorf("after release, highest = %v; want 12", highest)
+	}
+	if h.ep != nil {
+		t.Error("still holding after release")
+	}
+
+	// Without the earlier packet, it's ready once the deadline passes.
+	h.hold(ep, wgDataPacket(1, 20), now.Add(time.Second))
+	if h.ready(now) {
+		t.Fatal("ready before the deadline")
+	}
+	if d := h.pollInterval(now); d <= 0 || d > time.Second/8 {
+		t.Errorf("pollInterval = %v; want (0, %v]", d, time.Second/8)
+	}
+	if !h.ready(now.Add(time.Second)) {
+		t.Fatal("not ready at the deadline")
+	}
+	h.release(buf)
+	if got := metricRecvDataDERPHeldTimeout.Value() - timeouts; got != 1 {
+		t.Errorf("counted %d held packet timeouts; want 1", got)
+	}
+}
+
+func TestDERPJitter(t *testing.T) {
+	var o rxOrder
+	now := mono.Now()
+	// Perfectly regular arrivals have no jitter.
+	for range 10 {
+		now = now.Add(10 * time.Millisecond)
+		o.noteDERPRecv(now)
+	}
+	if j := o.DERPJitter(); j != 0 {
+		t.Errorf("regular arrivals: jitter = %v; want 0", j)
+	}
+	// Alternating 5ms and 15ms gaps vary by 10ms each time.
+	for i := range 200 {
+		now = now.Add(time.Duration(5+10*(i%2)) * time.Millisecond)
+		o.noteDERPRecv(now)
+	}
+	if j := o.DERPJitter(); j < 9*time.Millisecond || j > 10*time.Millisecond {
+		t.Errorf("alternating arrivals: jitter = %v; want ~10ms", j)
+	}
+}
+
+func TestNoteDirectDataPacket(t *testing.T) {
+	ep := &endpoint{}
+	now := mono.Now()
+	reordered := metricRecvDataMigrationReordered.Value()
+	noteDirectDataPacket(ep, wgDataPacket(1, 5), now)
+	noteDirectDataPacket(ep, wgDataPacket(1, 4), now)
+	if got := metricRecvDataMigrationReordered.Value() - reordered; got != 0 {
+		t.Errorf("without DERP traffic, counted %d reordered packets; want 0", got)
+	}
+
+	// While the peer is also sending over DERP, it's migrating.
+	ep.lastRecvDERP.StoreAtomic(now)
+	noteDirectDataPacket(ep, wgDataPacket(1, 3), now)
+	noteDirectDataPacket(ep, wgDataPacket(1, 6), now)
+	if got := metricRecvDataMigrationReordered.Value() - reordered; got != 1 {
+		t.Errorf("counted %d reordered packets; want 1", got)
+	}
+}
@@ -54,6 +54,7 @@
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/nettype"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/set"
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine"
@@ -177,6 +178,20 @@ type Impl struct {
 	// It can only be set before calling Start.
 	ProcessSubnets bool
 
+	// UDPIdleTimeout is how long a forwarded UDP flow may go without
+	// traffic in either direction before it's closed. Flows to port 53
+	// use at most 30 seconds. If zero, a default of 2 minutes is used.
+	// It can only be set before calling Start.
+	UDPIdleTimeout time.Duration
+
+	// MaxUDPFlowsPerClient is the maximum number of forwarded UDP flows
+	// that a single client (Tailscale IP) may have open at once. When a
+	// client opens a flow beyond the limit, its flow that's been idle
+	// the longest is closed. If zero, a platform-dependent default is
+	// used; if negative, there's no limit.
+	// It can only be set before calling Start.
+	MaxUDPFlowsPerClient int
+
 	ipstack       *stack.Stack
 	linkEP        *channel.Endpoint
 	tundev        *tstun.Wrapper
@@ -212,6 +227,12 @@ type Impl struct {
 	// limit.
 	forwardInFlightPerClientDropped expvar.Int
 
+	// udpFlowsTimedOut and udpFlowsEvicted are metrics that track how
+	// many forwarded UDP flows were closed for being idle for
+	// UDPIdleTimeout, and for being over MaxUDPFlowsPerClient.
+	udpFlowsTimedOut expvar.Int
+	udpFlowsEvicted  expvar.Int
+
 	mu sync.Mutex
 	// connsOpenBySubnetIP keeps track of number of connections open
 	// for each subnet IP temporarily registered on netstack for active
@@ -237,6 +258,9 @@ type Impl struct {
 	// unfortunate that we have to track this all twice, but thankfully the
 	// map only holds pending (in-flight) packets, and it's reasonably cheap.
 	packetsInFlight map[stack.TransportEndpointID]struct{}
+	// udpFlowsByClient are the UDP flows being forwarded, by the
+	// client ("Tailscale") IP. See MaxUDPFlowsPerClient.
+	udpFlowsByClient map[netip.Addr]set.Set[*udpFlow]
 }
 
 const nicID = 1
@@ -329,6 +353,7 @@ func Create(logf logger.Logf, tundev *tstun.Wrapper, e wgengine.Engine, mc *magi
 		connsOpenBySubnetIP:   make(map[netip.Addr]int),
 		connsInFlightByClient: make(map[netip.Addr]int),
 		packetsInFlight:       make(map[stack.TransportEndpointID]struct{}),
+		udpFlowsByClient:      make(map[netip.Addr]set.Set[*udpFlow]),
 		dns:                   dns,
 		driveForLocal:         driveForLocal,
 	}
@@ -1516,34 +1541,43 @@ func (ns *Impl) forwardUDP(client *gonet.UDPConn, clientAddr, dstAddr netip.Addr
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 
-	idleTimeout := 2 * time.Minute
-	if port == 53 {
-		// Make DNS packet copies time out much sooner.
-		//
-		// TODO(bradfitz): make DNS queries over UDP forwarding even
-		// cheaper by adding an additional idleTimeout post-DNS-reply.
-		// For instance, after the DNS response goes back out, then only
-		// wait a few seconds (or zero, really)
-		idleTimeout = 30 * time.Second
-	}
-	timer := time.AfterFunc(idleTimeout, func() {
+	flow := newUDPFlow(clientAddr.Addr(), func() {
 		if isLocal {
 			ns.pm.UnregisterIPPortIdentity(backendLocalIPPort)
 		}
-		ns.logf("netstack: UDP session between %s and %s timed out", backendListenAddr, backendRemoteAddr)
 		cancel()
 		client.Close()
 		backendConn.Close()
 	})
+	idleTimeout := ns.udpIdleTimeout(port)
+	timer := time.AfterFunc(idleTimeout, func() {
+		ns.logf("netstack: UDP session between %s and %s timed out", backendListenAddr, backendRemoteAddr)
+		metricUDPFlowsTimedOut.Add(1)
+		ns.udpFlowsTimedOut.Add(1)
+		flow.close()
+	})
 	extend := func() {
 		timer.Reset(idleTimeout)
+		flow.noteActive()
+	}
+	if evicted := ns.trackUDPFlow(flow); evicted != nil {
+		ns.logf("[v1] netstack: closing least recently used UDP session from %v; over limit of %d sessions", evicted.client, ns.maxUDPFlowsPerClient())
+		metricUDPFlowsEvicted.Add(1)
+		ns.udpFlowsEvicted.Add(1)
+		evicted.close()
 	}
 	startPacketCopy(ctx, cancel, client, net.UDPAddrFromAddrPort(clientAddr), backendConn, ns.logf, extend)
 	startPacketCopy(ctx, cancel, backendConn, backendRemoteAddr, client, ns.logf, extend)
+
+	// Wait for the copies to be done, whether because the flow was
+	// closed or one of them failed, before untracking the flow and
+	// decrementing the subnet address count to potentially remove the
+	// route.
+	<-ctx.Done()
+	timer.Stop()
+	flow.close()
+	ns.untrackUDPFlow(flow)
 	if isLocal {
-		// Wait for the copies to be done before decrementing the
-		// subnet address count to potentially remove the route.
-		<-ctx.Done()
 		ns.removeSubnetAddress(dstAddr.Addr())
 	}
 }
@@ -1772,6 +1806,17 @@ func (ns *Impl) ExpVar() expvar.Var {
 
 	m.Set("counter_tcp_forward_max_in_flight_per_client_drop", &ns.forwardInFlightPerClientDropped)
 
+	// Export the current UDP forwarding flows, limit and how many
+	// flows were closed by it or for being idle.
+	m.Set("gauge_udp_forward_flows", expvar.Func(func() any {
+		return ns.numUDPFlows()
+	}))
+	m.Set("gauge_udp_forward_flows_per_client_limit", expvar.Func(func() any {
+		return ns.maxUDPFlowsPerClient()
+	}))
+	m.Set("counter_udp_forward_flows_timed_out", &ns.udpFlowsTimedOut)
+	m.Set("counter_udp_forward_flows_evicted", &ns.udpFlowsEvicted)
+
 	// This metric tracks how many (if any) of the per-client limit on
 	// in-flight TCP forwarding requests have been reached.
 	m.Set("gauge_tcp_forward_in_flight_per_client_limit_reached", expvar.Func(func() any {
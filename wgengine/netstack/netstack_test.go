@@ -26,8 +26,10 @@
 	"tailscale.com/net/tstun"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/tstime/mono"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/types/logid"
+	"tailscale.com/util/set"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/filter"
 )
@@ -797,3 +799,68 @@ func TestHandleLocalPackets(t *testing.T) {
 		}
 	})
 }
+
+func TestUDPFlowLimits(t *testing.T) {
+	ns := &Impl{
+		MaxUDPFlowsPerClient: 2,
+		udpFlowsByClient:     make(map[netip.Addr]set.Set[*udpFlow]),
+	}
+	a := netip.MustParseAddr("100.64.1.1")
+	b := netip.MustParseAddr("100.64.1.2")
+	newFlow := func(client netip.Addr, lastActive mono.Time) *udpFlow {
+		f := newUDPFlow(client, func() {})
+		f.lastActive.Store(int64(lastActive))
+		return f
+	}
+	now := mono.Now()
+
+	a1 := newFlow(a, now.Add(-time.Minute))
+	a2 := newFlow(a, now)
+	b1 := newFlow(b, now.Add(-time.Hour))
+	for _, f := range []*udpFlow{a1, a2, b1} {
+		if evicted := ns.trackUDPFlow(f); evicted != nil {
+			t.Fatalf("flow evicted under the limit")
+		}
+	}
+
+	// a is at its limit, so its least recently active flow is evicted,
+	// even though b's is older.
+	if evicted := ns.trackUDPFlow(newFlow(a, now)); evicted != a1 {
+		t.Errorf("evicted %p; want a's idlest flow %p", evicted, a1)
+	}
+	if got := ns.numUDPFlows(); got != 3 {
+		t.Errorf("numUDPFlows = %d; want 3", got)
+	}
+
+	ns.untrackUDPFlow(b1)
+	if _, ok := ns.udpFlowsByClient[b]; ok {
+		t.Errorf("client without flows still tracked")
+	}
+
+	ns.MaxUDPFlowsPerClient = -1
+	for range 5 {
+		if evicted := ns.trackUDPFlow(newFlow(a, now)); evicted != nil {
+			t.Fatalf("flow evicted with no limit")
+		}
+	}
+}
+
+func TestUDPIdleTimeout(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		port    uint16
+		want    time.Duration
+	}{
+		{0, 443, defaultUDPIdleTimeout},
+		{0, 53, dnsUDPIdleTimeout},
+		{time.Minute, 443, time.Minute},
+		{10 * time.Second, 53, 10 * time.Second},
+		{time.Hour, 53, dnsUDPIdleTimeout},
+	}
+	for _, tt := range tests {
+		ns := &Impl{UDPIdleTimeout: tt.timeout}
+		if got := ns.udpIdleTimeout(tt.port); got != tt.want {
+			t.Errorf("UDPIdleTimeout=%v: udpIdleTimeout(%d) = %v; want %v", tt.timeout, tt.port, got, tt.want)
+		}
+	}
+}
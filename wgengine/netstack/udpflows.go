@@ -0,0 +1,147 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netstack
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/tstime/mono"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/set"
+	"tailscale.com/version"
+)
+
+const (
+	// defaultUDPIdleTimeout is how long a forwarded UDP flow may go
+	// without traffic before it's closed, if Impl.UDPIdleTimeout isn't
+	// set.
+	defaultUDPIdleTimeout = 2 * time.Minute
+
+	// dnsUDPIdleTimeout is the upper bound on the idle timeout of
+	// forwarded UDP flows to port 53.
+	dnsUDPIdleTimeout = 30 * time.Second
+)
+
+// defaultMaxUDPFlowsPerClient returns the number of forwarded UDP flows
+// that a single client may have open at once, if
+// Impl.MaxUDPFlowsPerClient isn't set.
+//
+// Each flow holds a goroutine per direction, a host UDP socket and a
+// gVisor endpoint until it times out, so a client sending to many
+// addresses or ports (such as a port scan) would otherwise grow them
+// without bound.
+func defaultMaxUDPFlowsPerClient() int {
+	if version.IsMobile() {
+		return 256
+	}
+	if version.OS() == "linux" {
+		// Like maxInFlightConnectionAttempts, assume that most
+		// production subnet routers run Linux.
+		return 4096
+	}
+	return 1024
+}
+
+// udpIdleTimeout returns the idle timeout of forwarded UDP flows to port.
+func (ns *Impl) udpIdleTimeout(port uint16) time.Duration {
+	d := ns.UDPIdleTimeout
+	if d <= 0 {
+		d = defaultUDPIdleTimeout
+	}
+	if port == 53 {
+		// Make DNS packet copies time out much sooner.
+		//
+		// TODO(bradfitz): make DNS queries over UDP forwarding even
+		// cheaper by adding an additional idleTimeout post-DNS-reply.
+		// For instance, after the DNS response goes back out, then only
+		// wait a few seconds (or zero, really)
+		d = min(d, dnsUDPIdleTimeout)
+	}
+	return d
+}
+
+// maxUDPFlowsPerClient returns the limit on forwarded UDP flows per
+// client, or 0 for no limit.
+func (ns *Impl) maxUDPFlowsPerClient() int {
+	switch n := ns.MaxUDPFlowsPerClient; {
+	case n < 0:
+		return 0
+	case n > 0:
+		return n
+	}
+	return defaultMaxUDPFlowsPerClient()
+}
+
+// udpFlow is a UDP flow being forwarded by forwardUDP.
+type udpFlow struct {
+	client     netip.Addr
+	lastActive atomic.Int64 // mono.Time of the last packet in either direction
+	close      func()       // closes the flow; safe to call more than once
+}
+
+// noteActive records that a packet was forwarded on f.
+func (f *udpFlow) noteActive() {
+	f.lastActive.Store(int64(mono.Now()))
+}
+
+// trackUDPFlow starts tracking f against its client's flow limit. If the
+// client is over the limit, the flow that's been idle the longest is
+// evicted: it's stopped being tracked, and the caller must close it.
+func (ns *Impl) trackUDPFlow(f *udpFlow) (evicted *udpFlow) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	flows := ns.udpFlowsByClient[f.client]
+	if flows == nil {
+		flows = make(set.Set[*udpFlow])
+		ns.udpFlowsByClient[f.client] = flows
+	}
+	if limit := ns.maxUDPFlowsPerClient(); limit > 0 && len(flows) >= limit {
+		for o := range flows {
+			if evicted == nil || o.lastActive.Load() < evicted.lastActive.Load() {
+				evicted = o
+			}
+		}
+		flows.Delete(evicted)
+	}
+	flows.Add(f)
+	return evicted
+}
+
+// untrackUDPFlow stops tracking f, which has been closed.
+func (ns *Impl) untrackUDPFlow(f *udpFlow) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	flows := ns.udpFlowsByClient[f.client]
+	flows.Delete(f)
+	if len(flows) == 0 {
+		delete(ns.udpFlowsByClient, f.client)
+	}
+}
+
+// numUDPFlows returns the number of UDP flows being forwarded.
+func (ns *Impl) numUDPFlows() int64 {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	var n int64
+	for _, flows := range ns.udpFlowsByClient {
+		n += int64(len(flows))
+	}
+	return n
+}
+
+// newUDPFlow returns a udpFlow for client that runs closeFn when closed,
+// at most once.
+func newUDPFlow(client netip.Addr, closeFn func()) *udpFlow {
+	f := &udpFlow{client: client, close: sync.OnceFunc(closeFn)}
+	f.noteActive()
+	return f
+}
+
+var (
+	metricUDPFlowsTimedOut = clientmetric.NewCounter("netstack_udp_forward_flows_timed_out")
+	metricUDPFlowsEvicted  = clientmetric.NewCounter("netstack_udp_forward_flows_evicted")
+)
@@ -0,0 +1,169 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package wgengine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+// reconfigSlowThreshold is how long a section of Reconfig can take before
+// it's reported as slow.
+const reconfigSlowThreshold = 5 * time.Second
+
+// warnReconfig is unhealthy if the last Reconfig had a slow section or
+// failed to configure WireGuard. Router and DNS failures have their own
+// health subsystems.
+var warnReconfig = health.NewWarnable()
+
+// reconfigSection is a part of Reconfig that's timed separately.
+type reconfigSection int
+
+const (
+	reconfigWireGuard reconfigSection = iota // peers and their AllowedIPs
+	reconfigRouter                           // the OS's addresses and routes
+	reconfigDNS                              // the OS's DNS configuration
+
+	numReconfigSections
+)
+
+func (s reconfigSection) String() string {
+	switch s {
+	case reconfigWireGuard:
+		return "wireguard"
+	case reconfigRouter:
+		return "router"
+	case reconfigDNS:
+		return "dns"
+	}
+	return fmt.Sprintf("reconfigSection(%d)", int(s))
+}
+
+var (
+	// metricReconfigMillis is the total time spent in each section of
+	// Reconfig, in milliseconds.
+	metricReconfigMillis = [numReconfigSections]*clientmetric.Metric{
+		reconfigWireGuard: clientmetric.NewCounter("wgengine_reconfig_wireguard_ms"),
+		reconfigRouter:    clientmetric.NewCounter("wgengine_reconfig_router_ms"),
+		reconfigDNS:       clientmetric.NewCounter("wgengine_reconfig_dns_ms"),
+	}
+
+	metricReconfigPeersAdded        = clientmetric.NewCounter("wgengine_reconfig_peers_added")
+	metricReconfigPeersRemoved      = clientmetric.NewCounter("wgengine_reconfig_peers_removed")
+	metricReconfigAllowedIPsChanged = clientmetric.NewCounter("wgengine_reconfig_allowed_ips_changed")
+
+	// metricReconfigSlow counts Reconfig calls with a section that took
+	// longer than reconfigSlowThreshold.
+	metricReconfigSlow = clientmetric.NewCounter("wgengine_reconfig_slow")
+
+	// metricReconfigFailed counts Reconfig calls that failed part way.
+	metricReconfigFailed = clientmetric.NewCounter("wgengine_reconfig_failed")
+)
+
+// reconfigTiming records how long each section of a Reconfig call took,
+// and where it failed, if it did.
+type reconfigTiming struct {
+	start  time.Time
+	took   [numReconfigSections]time.Duration
+	ran    [numReconfigSections]bool
+	wg     wgcfg.ReconfigStats // changes made to the WireGuard device
+	failed reconfigSection     // the section that failed, if err != nil
+	err    error
+}
+
+// run runs f as section s, timing it and recording its error.
+func (rt *reconfigTiming) run(s reconfigSection, f func() error) error {
+	t0 := time.Now()
+	err := f()
+	rt.took[s] += time.Since(t0)
+	rt.ran[s] = true
+	if err != nil && rt.err == nil {
+		rt.failed, rt.err = s, err
+	}
+	return err
+}
+
+// slowest returns the section that took longest, and whether it took
+// longer than reconfigSlowThreshold.
+func (rt *reconfigTiming) slowest() (s reconfigSection, slow bool) {
+	for i := range numReconfigSections {
+		if rt.took[i] > rt.took[s] {
+			s = i
+		}
+	}
+	return s, rt.took[s] > reconfigSlowThreshold
+}
+
+// String returns the sections that ran, for logs, like
+// "wireguard=20ms(+3 -1 peers, 2 allowed-ips) router=1.2s dns=35ms".
+func (rt *reconfigTiming) String() string {
+	var sb strings.Builder
+	for s := range numReconfigSections {
+		if !rt.ran[s] {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%v=%v", s, rt.took[s].Round(time.Millisecond))
+		if s == reconfigWireGuard {
+			fmt.Fprintf(&sb, "(+%d -%d peers, %d allowed-ips)", rt.wg.PeersAdded, rt.wg.PeersRemoved, rt.wg.AllowedIPsChanged)
+		}
+	}
+	return sb.String()
+}
+
+// healthErr returns the error to report via warnReconfig, or nil if the
+// sections that ran were quick and WireGuard was configured.
+func (rt *reconfigTiming) healthErr() error {
+	if rt.err != nil && rt.failed == reconfigWireGuard {
+		return fmt.Errorf("Configuring WireGuard peers failed: %v", rt.err)
+	}
+	if s, slow := rt.slowest(); slow {
+		return fmt.Errorf("Applying the network configuration is slow: the %v step took %v (%v)", s, rt.took[s].Round(time.Second), rt)
+	}
+	return nil
+}
+
+// noteReconfigDone logs and records the metrics for a Reconfig call
+// timed by rt, and updates warnReconfig.
+func (e *userspaceEngine) noteReconfigDone(rt *reconfigTiming) {
+	total := time.Since(rt.start).Round(time.Millisecond)
+	for s := range numReconfigSections {
+		metricReconfigMillis[s].Add(rt.took[s].Milliseconds())
+	}
+	metricReconfigPeersAdded.Add(int64(rt.wg.PeersAdded))
+	metricReconfigPeersRemoved.Add(int64(rt.wg.PeersRemoved))
+	metricReconfigAllowedIPsChanged.Add(int64(rt.wg.AllowedIPsChanged))
+
+	_, slow := rt.slowest()
+	if slow {
+		metricReconfigSlow.Add(1)
+	}
+	switch {
+	case rt.err != nil:
+		metricReconfigFailed.Add(1)
+		var skipped []string
+		for s := rt.failed + 1; s < numReconfigSections; s++ {
+			if !rt.ran[s] {
+				skipped = append(skipped, s.String())
+			}
+		}
+		notApplied := ""
+		if len(skipped) > 0 {
+			notApplied = "; not reached: " + strings.Join(skipped, ",")
+		}
+		e.logf("wgengine: Reconfig failed at %v after %v (%v%s): %v", rt.failed, total, rt, notApplied, rt.err)
+	case slow:
+		e.logf("wgengine: Reconfig done in %v, slowly: %v", total, rt)
+	default:
+		e.logf("[v1] wgengine: Reconfig done in %v: %v", total, rt)
+	}
+	e.health.SetWarnable(warnReconfig, rt.healthErr())
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package wgengine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/wgengine/wgcfg"
+)
+
+func TestReconfigTiming(t *testing.T) {
+	var rt reconfigTiming
+	rt.run(reconfigWireGuard, func() error {
+		rt.wg = wgcfg.ReconfigStats{PeersAdded: 3, PeersRemoved: 1, AllowedIPsChanged: 2}
+		return nil
+	})
+	rt.took[reconfigWireGuard] = 20 * time.Millisecond
+	if err := rt.healthErr(); err != nil {
+		t.Errorf("healthErr = %v; want nil", err)
+	}
+	if got, want := rt.String(), "wireguard=20ms(+3 -1 peers, 2 allowed-ips)"; got != want {
+		t.Errorf("String = %q; want %q", got, want)
+	}
+
+	routerErr := errors.New("boom")
+	if err := rt.run(reconfigRouter, func() error { return routerErr }); err != routerErr {
+		t.Errorf("run = %v; want %v", err, routerErr)
+	}
+	if rt.failed != reconfigRouter || rt.err != routerErr {
+		t.Errorf("failed, err = %v, %v; want router, %v", rt.failed, rt.err, routerErr)
+	}
+	// Router failures are reported by the router health subsystem.
+	if err := rt.healthErr(); err != nil {
+		t.Errorf("healthErr after router failure = %v; want nil", err)
+	}
+
+	rt.took[reconfigRouter] = 12 * time.Second
+	if s, slow := rt.slowest(); s != reconfigRouter || !slow {
+		t.Errorf("slowest = %v, %v; want router, true", s, slow)
+	}
+	if err := rt.healthErr(); err == nil || !strings.Contains(err.Error(), "router step took 12s") {
+		t.Errorf("healthErr = %v; want slow router", err)
+	}
+
+	var wgFail reconfigTiming
+	wgFail.run(reconfigWireGuard, func() error { return errors.New("uapi: bad") })
+	if err := wgFail.healthErr(); err == nil || !strings.Contains(err.Error(), "WireGuard peers failed: uapi: bad") {
+		t.Errorf("healthErr = %v; want WireGuard failure", err)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tailscale/netlink"
+	"go4.org/netipx"
+	"golang.org/x/sys/unix"
+	"tailscale.com/envknob"
+	"tailscale.com/util/clientmetric"
+)
+
+// driftCheckInterval is how often the router checks that the addresses,
+// routes and ip rules it installed are still present. Zero means
+// defaultDriftCheckInterval; negative disables the check.
+var driftCheckInterval = envknob.RegisterDuration("TS_DEBUG_ROUTE_DRIFT_CHECK_INTERVAL")
+
+const defaultDriftCheckInterval = 30 * time.Second
+
+var (
+	metricDriftDetected = clientmetric.NewCounter("router_drift_detected")
+	metricDriftRepaired = clientmetric.NewCounter("router_drift_repaired")
+)
+
+// drift describes the parts of the router's configuration that are no
+// longer present in the kernel.
+type drift struct {
+	addrs       []netip.Prefix // addresses missing from the tunnel interface
+	routes      []netip.Prefix // routes missing from our route table
+	throwRoutes []netip.Prefix // throw routes missing from our route table
+	rules       []int          // priorities of missing ip rules
+}
+
+func (d drift) empty() bool {
+	return len(d.addrs) == 0 && len(d.routes) == 0 && len(d.throwRoutes) == 0 && len(d.rules) == 0
+}
+
+func (d drift) String() string {
+	var parts []string
+	add := func(what string, n int, list any) {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s %v", n, what, list))
+		}
+	}
+	add("addresses", len(d.addrs), d.addrs)
+	add("routes", len(d.routes), d.routes)
+	add("throw routes", len(d.throwRoutes), d.throwRoutes)
+	add("ip rules", len(d.rules), d.rules)
+	return "missing " + strings.Join(parts, ", ")
+}
+
+// missingPrefixes returns the prefixes in want that aren't in have, in
+// sorted order. want and have are compared after masking.
+func missingPrefixes(want map[netip.Prefix]bool, have []netip.Prefix) []netip.Prefix {
+	haveSet := make(map[netip.Prefix]bool, len(have))
+	for _, p := range have {
+		haveSet[p.Masked()] = true
+	}
+	var missing []netip.Prefix
+	for p := range want {
+		if !haveSet[p.Masked()] {
+			missing = append(missing, p)
+		}
+	}
+	slices.SortFunc(missing, netipx.ComparePrefix)
+	return missing
+}
+
+// runDriftChecker periodically checks for and repairs drift until done is
+// closed.
+func (r *linuxRouter) runDriftChecker(done <-chan struct{}) {
+	interval := driftCheckInterval()
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultDriftCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		r.checkDrift()
+	}
+}
+
+// checkDrift checks that the addresses, routes and ip rules we installed
+// are still present, and reinstalls any that something else on the system
+// (NetworkManager, dhclient, other VPN software...) removed. Repairs are
+// rate limited so that we don't fight another program forever.
+func (r *linuxRouter) checkDrift() {
+	if r.closed.Load() || r.useIPCommand() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, err := r.findDriftLocked()
+	if err != nil {
+		r.logf("route check: %v", err)
+		return
+	}
+	if d.empty() {
+		return
+	}
+	metricDriftDetected.Add(1)
+	if !r.driftFixLimiter.Allow() {
+		r.logf("route check: %v; not repairing, too many recent repairs", d)
+		return
+	}
+	r.logf("route check: %v; something else on the system removed them, restoring", d)
+	metricDriftRepaired.Add(1)
+
+	// Addresses first, as the kernel removes routes via the interface
+	// along with its last address.
+	for _, p := range d.addrs {
+		if err := r.addAddress(p); err != nil {
+			r.logf("route check: restoring address %v: %v", p, err)
+		}
+	}
+	for _, p := range d.routes {
+		if err := r.addRoute(p); err != nil {
+			r.logf("route check: restoring route %v: %v", p, err)
+		}
+	}
+	for _, p := range d.throwRoutes {
+		if err := r.addThrowRoute(p); err != nil {
+			r.logf("route check: restoring throw route %v: %v", p, err)
+		}
+	}
+	if len(d.rules) > 0 {
+		if err := r.justAddIPRules(); err != nil {
+			r.logf("route check: restoring ip rules: %v", err)
+		}
+	}
+}
+
+// findDriftLocked compares the router's configuration with the kernel's.
+//
+// r.mu must be held.
+func (r *linuxRouter) findDriftLocked() (drift, error) {
+	var d drift
+	link, err := r.link()
+	if err != nil {
+		return d, err
+	}
+
+	nlAddrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return d, fmt.Errorf("listing addresses: %w", err)
+	}
+	var have []netip.Prefix
+	for _, a := range nlAddrs {
+		if p, ok := netipx.FromStdIPNet(a.IPNet); ok {
+			have = append(have, p)
+		}
+	}
+	d.addrs = missingPrefixes(r.usableLocked(r.addrs), have)
+
+	table := r.routeTable()
+	if table == 0 {
+		table = unix.RT_TABLE_MAIN
+	}
+	nlRoutes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL,
+		&netlink.Route{Table: table, LinkIndex: link.Attrs().Index},
+		netlink.RT_FILTER_TABLE|netlink.RT_FILTER_OIF)
+	if err != nil {
+		return d, fmt.Errorf("listing routes: %w", err)
+	}
+	d.routes = missingPrefixes(r.usableLocked(r.routes), routeDsts(nlRoutes))
+
+	if r.ipRuleAvailable {
+		nlRoutes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL,
+			&netlink.Route{Table: tailscaleRouteTable.Num, Type: unix.RTN_THROW},
+			netlink.RT_FILTER_TABLE|netlink.RT_FILTER_TYPE)
+		if err != nil {
+			return d, fmt.Errorf("listing throw routes: %w", err)
+		}
+		d.throwRoutes = missingPrefixes(r.usableLocked(r.localRoutes), routeDsts(nlRoutes))
+
+		for _, family := range r.addrFamilies() {
+			rules, err := netlink.RuleList(family.netlinkInt())
+			if err != nil {
+				return d, fmt.Errorf("listing ip rules: %w", err)
+			}
+			for _, want := range ipRules {
+				prio := want.Priority + r.ipPolicyPrefBase
+				if !slices.ContainsFunc(rules, func(ru netlink.Rule) bool { return ru.Priority == prio }) &&
+					!slices.Contains(d.rules, prio) {
+					d.rules = append(d.rules, prio)
+				}
+			}
+		}
+	}
+	return d, nil
+}
+
+// usableLocked returns the prefixes in m that the router installs, which
+// excludes IPv6 prefixes when IPv6 is unavailable.
+//
+// r.mu must be held.
+func (r *linuxRouter) usableLocked(m map[netip.Prefix]bool) map[netip.Prefix]bool {
+	if r.getV6Available() {
+		return m
+	}
+	ret := make(map[netip.Prefix]bool, len(m))
+	for p := range m {
+		if p.Addr().Is4() {
+			ret[p] = true
+		}
+	}
+	return ret
+}
+
+// routeDsts returns the destinations of routes.
+func routeDsts(routes []netlink.Route) []netip.Prefix {
+	var ret []netip.Prefix
+	for _, rt := range routes {
+		if rt.Dst == nil {
+			// Default route.
+			if rt.Family == netlink.FAMILY_V6 {
+				ret = append(ret, netip.PrefixFrom(netip.IPv6Unspecified(), 0))
+			} else {
+				ret = append(ret, netip.PrefixFrom(netip.IPv4Unspecified(), 0))
+			}
+			continue
+		}
+		if p, ok := netipx.FromStdIPNet(rt.Dst); ok {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
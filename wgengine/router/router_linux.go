@@ -12,6 +12,7 @@
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -38,15 +39,20 @@
 )
 
 type linuxRouter struct {
-	closed            atomic.Bool
-	logf              func(fmt string, args ...any)
-	tunname           string
-	netMon            *netmon.Monitor
-	health            *health.Tracker
-	unregNetMon       func()
-	addrs             map[netip.Prefix]bool
-	routes            map[netip.Prefix]bool
-	localRoutes       map[netip.Prefix]bool
+	closed      atomic.Bool
+	logf        func(fmt string, args ...any)
+	tunname     string
+	netMon      *netmon.Monitor
+	health      *health.Tracker
+	unregNetMon func()
+
+	// mu guards addrs, routes and localRoutes, which the drift
+	// checker reads concurrently with Set.
+	mu          sync.Mutex
+	addrs       map[netip.Prefix]bool
+	routes      map[netip.Prefix]bool
+	localRoutes map[netip.Prefix]bool
+
 	snatSubnetRoutes  bool
 	statefulFiltering bool
 	netfilterMode     preftype.NetfilterMode
@@ -57,6 +63,11 @@ type linuxRouter struct {
 	ruleRestorePending atomic.Bool
 	ipRuleFixLimiter   *rate.Limiter
 
+	// driftDone is closed by Close to stop the drift checker started
+	// by Up. driftFixLimiter limits how often it repairs drift.
+	driftDone       chan struct{}
+	driftFixLimiter *rate.Limiter
+
 	// Various feature checks for the network stack.
 	ipRuleAvailable bool // whether kernel was built with IP_MULTIPLE_TABLES
 	v6Available     bool // whether the kernel supports IPv6
@@ -96,6 +107,8 @@ func newUserspaceRouterAdvanced(logf logger.Logf, tunname string, netMon *netmon
 		cmd: cmd,
 
 		ipRuleFixLimiter: rate.NewLimiter(rate.Every(5*time.Second), 10),
+		driftDone:        make(chan struct{}),
+		driftFixLimiter:  rate.NewLimiter(rate.Every(time.Minute), 5),
 		ipPolicyPrefBase: 5200,
 	}
 	if r.useIPCommand() {
@@ -308,12 +321,17 @@ func (r *linuxRouter) Up() error {
 	if err := r.upInterface(); err != nil {
 		return fmt.Errorf("bringing interface up: %w", err)
 	}
+	if !r.useIPCommand() {
+		go r.runDriftChecker(r.driftDone)
+	}
 
 	return nil
 }
 
 func (r *linuxRouter) Close() error {
-	r.closed.Store(true)
+	if !r.closed.Swap(true) {
+		close(r.driftDone)
+	}
 	if r.unregNetMon != nil {
 		r.unregNetMon()
 	}
@@ -326,6 +344,8 @@ func (r *linuxRouter) Close() error {
 	if err := r.setNetfilterMode(netfilterOff); err != nil {
 		return err
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if err := r.delRoutes(); err != nil {
 		return err
 	}
@@ -375,6 +395,9 @@ func (r *linuxRouter) Set(cfg *Config) error {
 		errs = append(errs, err)
 	}
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	newLocalRoutes, err := cidrDiff("localRoute", r.localRoutes, cfg.LocalRoutes, r.addThrowRoute, r.delThrowRoute, r.logf)
 	if err != nil {
 		errs = append(errs, err)
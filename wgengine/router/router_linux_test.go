@@ -1220,3 +1220,92 @@ func adjustFwmask(t *testing.T, s string) string {
 
 	return fwmaskAdjustRe.ReplaceAllString(s, "$1")
 }
+
+func TestMissingPrefixes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	want := map[netip.Prefix]bool{
+		pfx("100.64.1.2/32"): true,
+		pfx("10.0.0.0/8"):    true,
+		pfx("0.0.0.0/0"):     true,
+		pfx("fd7a::1/128"):   true,
+	}
+	have := []netip.Prefix{pfx("100.64.1.2/32"), pfx("10.1.2.3/8"), pfx("192.168.0.0/16")}
+	got := missingPrefixes(want, have)
+	wantMissing := []netip.Prefix{pfx("0.0.0.0/0"), pfx("fd7a::1/128")}
+	if !slices.Equal(got, wantMissing) {
+		t.Errorf("missingPrefixes = %v; want %v", got, wantMissing)
+	}
+
+	d := drift{routes: got, rules: []int{5270}}
+	if got, want := d.String(), "missing 2 routes [0.0.0.0/0 fd7a::1/128], 1 ip rules [5270]"; got != want {
+		t.Errorf("drift.String = %q; want %q", got, want)
+	}
+}
+
+func TestDriftRepair(t *testing.T) {
+	lt := newLinuxRootTest(t)
+	defer lt.Close()
+	r := lt.r
+	r.nfr = newIPTablesRunner(t)
+	defer r.Close()
+
+	route := netip.MustParsePrefix("192.0.2.0/24") // RFC 5737
+	if err := r.Set(&Config{
+		LocalAddrs: []netip.Prefix{netip.MustParsePrefix("100.101.102.103/32")},
+		Routes:     []netip.Prefix{route},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.addIPRules(); err != nil {
+		t.Fatal(err)
+	}
+
+	findDrift := func() drift {
+		t.Helper()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		d, err := r.findDriftLocked()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	if d := findDrift(); !d.empty() {
+		t.Fatalf("unexpected drift after Set: %v", d)
+	}
+
+	// Remove a route and a rule behind the router's back.
+	linkIndex, err := r.linkIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.RouteDel(&netlink.Route{
+		LinkIndex: linkIndex,
+		Dst:       netipx.PrefixIPNet(route),
+		Table:     r.routeTable(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rule := netlink.NewRule()
+	rule.Family = netlink.FAMILY_V4
+	rule.Priority = ipRules[len(ipRules)-1].Priority + r.ipPolicyPrefBase
+	rule.Table = tailscaleRouteTable.Num
+	if r.ipRuleAvailable {
+		if err := netlink.RuleDel(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := findDrift()
+	if !slices.Equal(d.routes, []netip.Prefix{route}) {
+		t.Errorf("drift routes = %v; want [%v]", d.routes, route)
+	}
+	if r.ipRuleAvailable && !slices.Equal(d.rules, []int{rule.Priority}) {
+		t.Errorf("drift rules = %v; want [%v]", d.rules, rule.Priority)
+	}
+
+	r.checkDrift()
+	if d := findDrift(); !d.empty() {
+		t.Errorf("drift after repair: %v", d)
+	}
+}
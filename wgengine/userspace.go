@@ -29,6 +29,7 @@
 	"tailscale.com/net/flowtrack"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/packet"
+	"tailscale.com/net/portmapper"
 	"tailscale.com/net/sockstats"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
@@ -94,8 +95,8 @@ type userspaceEngine struct {
 	timeNow          func() mono.Time
 	tundev           *tstun.Wrapper
 	wgdev            *device.Device
-	router           router.Router
-	confListenPort   uint16 // original conf.ListenPort
+	router           router.Router // written with both wgLock and mu held
+	confListenPort   uint16        // original conf.ListenPort
 	dns              *dns.Manager
 	magicConn        *magicsock.Conn
 	netMon           *netmon.Monitor
@@ -105,6 +106,10 @@ type userspaceEngine struct {
 	birdClient       BIRDClient          // or nil
 	controlKnobs     *controlknobs.Knobs // or nil
 
+	// reopenTUN, if non-nil, re-creates the TUN device and router in
+	// Restart. See Config.ReopenTUN.
+	reopenTUN func() (tun.Device, router.Router, error)
+
 	testMaybeReconfigHook func() // for tests; if non-nil, fires if maybeReconfigWireguardLocked called
 
 	// isLocalAddr reports the whether an IP is assigned to the local
@@ -175,6 +180,12 @@ type Config struct {
 	// If nil, a fake Router that does nothing is used.
 	Router router.Router
 
+	// ReopenTUN, if non-nil, re-creates Tun and a Router for it. It's
+	// called by Engine.Restart after the old Router and Tun are closed,
+	// so it picks up changed settings such as the TUN MTU.
+	// If nil, Restart keeps the existing Tun and Router.
+	ReopenTUN func() (tun.Device, router.Router, error)
+
 	// DNS interfaces the Engine to the OS DNS resolver configuration.
 	// If nil, a fake OSConfigurator that does nothing is used.
 	DNS dns.OSConfigurator
@@ -221,6 +232,11 @@ type Config struct {
 	// DriveForLocal, if populated, will cause the engine to expose a Taildrive
 	// listener at 100.100.100.100:8080.
 	DriveForLocal drive.FileSystemForLocal
+
+	// DisabledPortMapServices are the port mapping services (UPnP,
+	// NAT-PMP, PCP) that the engine must not probe for or use, such as on
+	// networks that forbid SSDP traffic.
+	DisabledPortMapServices portmapper.Services
 }
 
 // NewFakeUserspaceEngine returns a new userspace engine for testing.
@@ -293,22 +309,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 	}
 	closePool.add(tsTUNDev)
 
-	rtr := conf.Router
-	if version.IsMobile() {
-		// Android and iOS don't handle large numbers of routes well, so we
-		// wrap the Router with one that consolidates routes down to the
-		// smallest number possible.
-		//
-		// On Android, too many routes at VPN configuration time result in an
-		// android.os.TransactionTooLargeException because Android's VPNBuilder
-		// tries to send the entire set of routes to the VPNService as a single
-		// Bundle, which is typically limited to 1 MB. The number of routes
-		// that's too much seems to be very roughly around 4000.
-		//
-		// On iOS, the VPNExtension is limited to only 50 MB of memory, so
-		// keeping the number of routes down helps with memory consumption.
-		rtr = router.ConsolidatingRoutes(logf, rtr)
-	}
+	rtr := platformRouter(logf, conf.Router)
 
 	e := &userspaceEngine{
 		timeNow:        mono.Now,
@@ -317,6 +318,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		waitCh:         make(chan struct{}),
 		tundev:         tsTUNDev,
 		router:         rtr,
+		reopenTUN:      conf.ReopenTUN,
 		confListenPort: conf.ListenPort,
 		birdClient:     conf.BIRDClient,
 		controlKnobs:   conf.ControlKnobs,
@@ -372,7 +374,10 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 	onPortUpdate := func(port uint16, network string) {
 		e.logf("onPortUpdate(port=%v, network=%s)", port, network)
 
-		if err := e.router.UpdateMagicsockPort(port, network); err != nil {
+		e.mu.Lock()
+		rtr := e.router
+		e.mu.Unlock()
+		if err := rtr.UpdateMagicsockPort(port, network); err != nil {
 			e.logf("UpdateMagicsockPort(port=%v, network=%s) failed: %w", port, network, err)
 		}
 	}
@@ -388,6 +393,8 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		ControlKnobs:     conf.ControlKnobs,
 		OnPortUpdate:     onPortUpdate,
 		PeerByKeyFunc:    e.PeerByKey,
+
+		DisabledPortMapServices: conf.DisabledPortMapServices,
 	}
 
 	var err error
@@ -664,10 +671,10 @@ func (e *userspaceEngine) isActiveSinceLocked(nk key.NodePublic, ip netip.Addr,
 // If discoChanged is nil or empty, this extra removal step isn't done.
 //
 // e.wgLock must be held.
-func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.NodePublic]bool) error {
+func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.NodePublic]bool) (stats wgcfg.ReconfigStats, err error) {
 	if hook := e.testMaybeReconfigHook; hook != nil {
 		hook()
-		return nil
+		return stats, nil
 	}
 
 	full := e.lastCfgFull
@@ -735,7 +742,7 @@ func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.Node
 		TrackNodes   []key.NodePublic
 		TrackIPs     []netip.Addr
 	}{&min, e.trimmedNodes, trackNodes, trackIPs}); !changed {
-		return nil
+		return stats, nil
 	}
 
 	e.updateActivityMapsLocked(trackNodes, trackIPs)
@@ -753,19 +760,23 @@ func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.Node
 		}
 		if numRemove > 0 {
 			e.logf("wgengine: Reconfig: removing session keys for %d peers", numRemove)
-			if err := wgcfg.ReconfigDevice(e.wgdev, &minner, e.logf); err != nil {
+			st, err := wgcfg.ReconfigDeviceStats(e.wgdev, &minner, e.logf)
+			stats.Add(st)
+			if err != nil {
 				e.logf("wgdev.Reconfig: %v", err)
-				return err
+				return stats, err
 			}
 		}
 	}
 
 	e.logf("wgengine: Reconfig: configuring userspace WireGuard config (with %d/%d peers)", len(min.Peers), len(full.Peers))
-	if err := wgcfg.ReconfigDevice(e.wgdev, &min, e.logf); err != nil {
+	st, err := wgcfg.ReconfigDeviceStats(e.wgdev, &min, e.logf)
+	stats.Add(st)
+	if err != nil {
 		e.logf("wgdev.Reconfig: %v", err)
-		return err
+		return stats, err
 	}
-	return nil
+	return stats, nil
 }
 
 // updateActivityMapsLocked updates the data structures used for tracking the activity
@@ -897,6 +908,8 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	if !engineChanged && !routerChanged && !listenPortChanged && !isSubnetRouterChanged && !peerMTUChanged {
 		return ErrNoChanges
 	}
+	rt := &reconfigTiming{start: time.Now()}
+	defer e.noteReconfigDone(rt)
 	newLogIDs := cfg.NetworkLogging
 	oldLogIDs := e.lastCfgFull.NetworkLogging
 	netLogIDsNowValid := !newLogIDs.NodeID.IsZero() && !newLogIDs.DomainID.IsZero()
@@ -952,7 +965,10 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	e.magicConn.SetPreferredPort(listenPort)
 	e.magicConn.UpdatePMTUD()
 
-	if err := e.maybeReconfigWireguardLocked(discoChanged); err != nil {
+	if err := rt.run(reconfigWireGuard, func() (err error) {
+		rt.wg, err = e.maybeReconfigWireguardLocked(discoChanged)
+		return err
+	}); err != nil {
 		return err
 	}
 
@@ -983,7 +999,7 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	if routerChanged {
 		e.logf("wgengine: Reconfig: configuring router")
 		e.networkLogger.ReconfigRoutes(routerCfg)
-		err := e.router.Set(routerCfg)
+		err := rt.run(reconfigRouter, func() error { return e.router.Set(routerCfg) })
 		e.health.SetRouterHealth(err)
 		if err != nil {
 			return err
@@ -992,7 +1008,7 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 		// DNS managers refuse to apply settings if the device has no
 		// assigned address.
 		e.logf("wgengine: Reconfig: configuring DNS")
-		err = e.dns.Set(*dnsCfg)
+		err = rt.run(reconfigDNS, func() error { return e.dns.Set(*dnsCfg) })
 		e.health.SetDNSHealth(err)
 		if err != nil {
 			return err
@@ -1030,7 +1046,6 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 		}
 	}
 
-	e.logf("[v1] wgengine: Reconfig done")
 	return nil
 }
 
@@ -1173,7 +1188,10 @@ func (e *userspaceEngine) Close() {
 		e.netMon.Close()
 	}
 	e.dns.Down()
-	e.router.Close()
+	e.mu.Lock()
+	rtr := e.router
+	e.mu.Unlock()
+	rtr.Close()
 	e.wgdev.Close()
 	e.tundev.Close()
 	if e.birdClient != nil {
@@ -1189,6 +1207,96 @@ func (e *userspaceEngine) Close() {
 	}
 }
 
+// platformRouter returns rtr, wrapped as needed for the current platform.
+func platformRouter(logf logger.Logf, rtr router.Router) router.Router {
+	if version.IsMobile() {
+		// Android and iOS don't handle large numbers of routes well, so we
+		// wrap the Router with one that consolidates routes down to the
+		// smallest number possible.
+		//
+		// On Android, too many routes at VPN configuration time result in an
+		// android.os.TransactionTooLargeException because Android's VPNBuilder
+		// tries to send the entire set of routes to the VPNService as a single
+		// Bundle, which is typically limited to 1 MB. The number of routes
+		// that's too much seems to be very roughly around 4000.
+		//
+		// On iOS, the VPNExtension is limited to only 50 MB of memory, so
+		// keeping the number of routes down helps with memory consumption.
+		return router.ConsolidatingRoutes(logf, rtr)
+	}
+	return rtr
+}
+
+func (e *userspaceEngine) Restart() error {
+	e.mu.Lock()
+	closing := e.closing
+	e.mu.Unlock()
+	if closing {
+		return errors.New("engine closing")
+	}
+	e.logf("wgengine: Restart: restarting")
+	t0 := time.Now()
+
+	e.wgLock.Lock()
+	// Down closes the magicsock bind, which Up re-opens. The peer removal
+	// in between drops all sessions and keypairs.
+	downErr := e.wgdev.Down()
+	e.wgdev.RemoveAllPeers()
+	var tunErr error
+	if e.reopenTUN != nil {
+		tunErr = e.reopenTUNLocked()
+	}
+	e.lastCfgFull = wgcfg.Config{}
+	e.lastNMinPeers = 0
+	e.lastEngineSigFull = deephash.Sum{}
+	e.lastEngineSigTrim = deephash.Sum{}
+	e.lastRouterSig = deephash.Sum{}
+	upErr := e.wgdev.Up()
+	e.wgLock.Unlock()
+
+	// Closing the bind also closed the UDP sockets; rebind to get fresh
+	// ones. This must happen without wgLock held per the lock order.
+	e.magicConn.Rebind()
+	e.magicConn.ReSTUN("engine-restart")
+	e.logf("wgengine: Restart: done in %v", time.Since(t0).Round(time.Millisecond))
+	return errors.Join(downErr, tunErr, upErr)
+}
+
+// reopenTUNLocked closes the router and the TUN device and re-creates both
+// with e.reopenTUN. The tstun.Wrapper, and so everything hooked up to it,
+// is kept. The new router is brought up with no routes; the caller resets
+// lastRouterSig so that the next Reconfig configures it.
+//
+// e.wgLock must be held.
+func (e *userspaceEngine) reopenTUNLocked() error {
+	if err := e.router.Close(); err != nil {
+		e.logf("wgengine: Restart: closing router: %v", err)
+	}
+	var rtr router.Router
+	err := e.tundev.ReplaceDevice(func() (dev tun.Device, err error) {
+		dev, rtr, err = e.reopenTUN()
+		return dev, err
+	})
+	if err != nil {
+		// The old router is closed and there's no TUN device to
+		// route to; stop using either until tailscaled restarts.
+		rtr = router.NewFake(e.logf)
+		err = fmt.Errorf("reopening TUN: %w", err)
+	} else {
+		rtr = platformRouter(e.logf, rtr)
+	}
+	e.mu.Lock()
+	e.router = rtr
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := rtr.Up(); err != nil {
+		return fmt.Errorf("router.Up: %w", err)
+	}
+	return nil
+}
+
 func (e *userspaceEngine) Done() <-chan struct{} {
 	return e.waitCh
 }
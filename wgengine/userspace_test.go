@@ -11,6 +11,7 @@
 	"runtime"
 	"testing"
 
+	"github.com/tailscale/wireguard-go/tun"
 	"go4.org/mem"
 	"tailscale.com/cmd/testwrapper/flakytest"
 	"tailscale.com/control/controlknobs"
@@ -25,6 +26,7 @@
 	"tailscale.com/types/key"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/opt"
+	"tailscale.com/util/deephash"
 	"tailscale.com/wgengine/router"
 	"tailscale.com/wgengine/wgcfg"
 )
@@ -158,6 +160,93 @@ func TestUserspaceEngineReconfig(t *testing.T) {
 	}
 }
 
+// countingRouter is a router.Router that counts calls to Set.
+type countingRouter struct {
+	router.Router
+	sets int
+}
+
+func (r *countingRouter) Set(cfg *router.Config) error {
+	r.sets++
+	return r.Router.Set(cfg)
+}
+
+func TestUserspaceEngineRestart(t *testing.T) {
+	var reopened []tun.Device
+	var routers []*countingRouter
+	newRouter := func() *countingRouter {
+		r := &countingRouter{Router: router.NewFake(t.Logf)}
+		routers = append(routers, r)
+		return r
+	}
+	e, err := NewUserspaceEngine(t.Logf, Config{
+		Tun:           tstun.NewFake(),
+		Router:        newRouter(),
+		HealthTracker: new(health.Tracker),
+		ReopenTUN: func() (tun.Device, router.Router, error) {
+			dev := tstun.NewFake()
+			reopened = append(reopened, dev)
+			return dev, newRouter(), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	ue := e.(*userspaceEngine)
+
+	nodeHex := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	cfg := &wgcfg.Config{
+		Peers: []wgcfg.Peer{
+			{
+				PublicKey: nkFromHex(nodeHex),
+				AllowedIPs: []netip.Prefix{
+					netip.PrefixFrom(netaddr.IPv4(100, 100, 99, 1), 32),
+				},
+			},
+		},
+	}
+	routerCfg := &router.Config{
+		LocalAddrs: []netip.Prefix{netip.MustParsePrefix("100.100.99.2/32")},
+	}
+	if err := e.Reconfig(cfg, routerCfg, &dns.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Restart(); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	if len(reopened) != 1 {
+		t.Fatalf("ReopenTUN called %d times; want 1", len(reopened))
+	}
+	if got := ue.tundev.Unwrap(); got != reopened[0] {
+		t.Errorf("after Restart, tundev wraps %v; want the reopened device", got)
+	}
+	if ue.router != routers[1] {
+		t.Errorf("after Restart, router = %v; want the reopened router", ue.router)
+	}
+	if n := len(ue.lastCfgFull.Peers); n != 0 {
+		t.Fatalf("after Restart, lastCfgFull has %d peers; want 0", n)
+	}
+	if ue.lastRouterSig != (deephash.Sum{}) {
+		t.Fatal("after Restart, lastRouterSig not reset")
+	}
+	if err := e.Reconfig(cfg, routerCfg, &dns.Config{}); err != nil {
+		t.Fatalf("Reconfig after Restart: %v", err)
+	}
+	if n := len(ue.lastCfgFull.Peers); n != 1 {
+		t.Fatalf("after Reconfig, lastCfgFull has %d peers; want 1", n)
+	}
+	if routers[1].sets == 0 {
+		t.Error("Reconfig after Restart did not configure the new router")
+	}
+
+	e.Close()
+	if err := e.Restart(); err == nil {
+		t.Fatal("Restart after Close succeeded; want error")
+	}
+}
+
 func TestUserspaceEnginePortReconfig(t *testing.T) {
 	flakytest.Mark(t, "https://github.com/tailscale/tailscale/issues/2855")
 	const defaultPort = 49983
@@ -153,6 +153,9 @@ func (e *watchdogEngine) Ping(ip netip.Addr, pingType tailcfg.PingType, size int
 func (e *watchdogEngine) Close() {
 	e.watchdog("Close", e.wrap.Close)
 }
+func (e *watchdogEngine) Restart() error {
+	return e.watchdogErr("Restart", e.wrap.Restart)
+}
 func (e *watchdogEngine) PeerForIP(ip netip.Addr) (ret PeerForIP, ok bool) {
 	e.watchdog("PeerForIP", func() { ret, ok = e.wrap.PeerForIP(ip) })
 	return ret, ok
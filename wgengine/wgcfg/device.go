@@ -6,6 +6,7 @@
 import (
 	"io"
 	"sort"
+	"time"
 
 	"github.com/tailscale/wireguard-go/conn"
 	"github.com/tailscale/wireguard-go/device"
@@ -41,9 +42,36 @@ func DeviceConfig(d *device.Device) (*Config, error) {
 	return cfg, nil
 }
 
+// ReconfigStats describes the changes that ReconfigDeviceStats made to a
+// device.
+type ReconfigStats struct {
+	PeersAdded        int           // peers that weren't configured before
+	PeersRemoved      int           // peers that are no longer configured
+	AllowedIPsChanged int           // existing peers whose AllowedIPs were replaced
+	Duration          time.Duration // time spent reconfiguring the device
+}
+
+// Add adds the counts and duration of o to s.
+func (s *ReconfigStats) Add(o ReconfigStats) {
+	s.PeersAdded += o.PeersAdded
+	s.PeersRemoved += o.PeersRemoved
+	s.AllowedIPsChanged += o.AllowedIPsChanged
+	s.Duration += o.Duration
+}
+
 // ReconfigDevice replaces the existing device configuration with cfg.
-func ReconfigDevice(d *device.Device, cfg *Config, logf logger.Logf) (err error) {
+func ReconfigDevice(d *device.Device, cfg *Config, logf logger.Logf) error {
+	_, err := ReconfigDeviceStats(d, cfg, logf)
+	return err
+}
+
+// ReconfigDeviceStats is like ReconfigDevice, but also reports what it
+// changed and how long it took. The stats are valid even if it returns an
+// error, counting the changes that it had written before failing.
+func ReconfigDeviceStats(d *device.Device, cfg *Config, logf logger.Logf) (stats ReconfigStats, err error) {
+	start := time.Now()
 	defer func() {
+		stats.Duration = time.Since(start)
 		if err != nil {
 			logf("wgcfg.Reconfig failed: %v", err)
 		}
@@ -51,7 +79,7 @@ func ReconfigDevice(d *device.Device, cfg *Config, logf logger.Logf) (err error)
 
 	prev, err := DeviceConfig(d)
 	if err != nil {
-		return err
+		return stats, err
 	}
 
 	r, w := io.Pipe()
@@ -61,8 +89,8 @@ func ReconfigDevice(d *device.Device, cfg *Config, logf logger.Logf) (err error)
 		r.Close()
 	}()
 
-	toErr := cfg.ToUAPI(logf, w, prev)
+	toErr := cfg.toUAPI(logf, w, prev, &stats)
 	w.Close()
 	setErr := <-errc
-	return multierr.New(setErr, toErr)
+	return stats, multierr.New(setErr, toErr)
 }
@@ -152,10 +152,14 @@ func TestDeviceConfig(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if err := ReconfigDevice(device1, cfg1, t.Logf); err != nil {
+		stats, err := ReconfigDeviceStats(device1, cfg1, t.Logf)
+		if err != nil {
 			t.Fatal(err)
 		}
 		cmp(t, device1, cfg1)
+		if stats.PeersAdded != 1 || stats.PeersRemoved != 0 || stats.AllowedIPsChanged != 0 {
+			t.Errorf("stats = %+v; want 1 peer added", stats)
+		}
 
 		newCfg, err := DeviceConfig(device1)
 		if err != nil {
@@ -178,14 +182,33 @@ func TestDeviceConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("device1 change allowed ips", func(t *testing.T) {
+		cfg1.Peers[0].AllowedIPs = append(cfg1.Peers[0].AllowedIPs, netip.MustParsePrefix("10.1.0.0/16"))
+		stats, err := ReconfigDeviceStats(device1, cfg1, t.Logf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmp(t, device1, cfg1)
+		if stats.PeersAdded != 0 || stats.PeersRemoved != 0 || stats.AllowedIPsChanged != 1 {
+			t.Errorf("stats = %+v; want 1 peer's allowed IPs changed", stats)
+		}
+		if stats.Duration <= 0 {
+			t.Errorf("stats.Duration = %v; want > 0", stats.Duration)
+		}
+	})
+
 	t.Run("device1 remove peer", func(t *testing.T) {
 		removeKey := cfg1.Peers[len(cfg1.Peers)-1].PublicKey
 		cfg1.Peers = cfg1.Peers[:len(cfg1.Peers)-1]
 
-		if err := ReconfigDevice(device1, cfg1, t.Logf); err != nil {
+		stats, err := ReconfigDeviceStats(device1, cfg1, t.Logf)
+		if err != nil {
 			t.Fatal(err)
 		}
 		cmp(t, device1, cfg1)
+		if stats.PeersAdded != 0 || stats.PeersRemoved != 1 || stats.AllowedIPsChanged != 0 {
+			t.Errorf("stats = %+v; want 1 peer removed", stats)
+		}
 
 		newCfg, err := DeviceConfig(device1)
 		if err != nil {
@@ -21,6 +21,15 @@
 // about peers that have not changed since the previous time we wrote our
 // Config.
 func (cfg *Config) ToUAPI(logf logger.Logf, w io.Writer, prev *Config) error {
+	return cfg.toUAPI(logf, w, prev, nil)
+}
+
+// toUAPI implements ToUAPI, counting the peers that it adds and removes
+// and whose AllowedIPs it changes in stats, if non-nil.
+func (cfg *Config) toUAPI(logf logger.Logf, w io.Writer, prev *Config, stats *ReconfigStats) error {
+	if stats == nil {
+		stats = new(ReconfigStats)
+	}
 	var stickyErr error
 	set := func(key, value string) {
 		if stickyErr != nil {
@@ -73,6 +82,11 @@ func (cfg *Config) ToUAPI(logf logger.Logf, w io.Writer, prev *Config) error {
 
 		setPeer(p)
 		set("protocol_version", "1")
+		if !wasPresent {
+			stats.PeersAdded++
+		} else if willChangeIPs {
+			stats.AllowedIPsChanged++
+		}
 
 		// Avoid setting endpoints if the correct one is already known
 		// to WireGuard, because doing so generates a bit more work in
@@ -115,6 +129,7 @@ func (cfg *Config) ToUAPI(logf logger.Logf, w io.Writer, prev *Config) error {
 	for _, p := range old {
 		setPeer(p)
 		set("remove", "true")
+		stats.PeersRemoved++
 	}
 
 	if stickyErr != nil {
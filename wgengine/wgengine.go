@@ -97,6 +97,14 @@ type Engine interface {
 	// If the peer is not found, ok is false.
 	PeerByKey(key.NodePublic) (_ wgint.Peer, ok bool)
 
+	// Restart tears down the WireGuard device's peers and sessions,
+	// re-creates the TUN device and router if the Engine was configured
+	// with Config.ReopenTUN, rebinds the underlying UDP sockets and
+	// forgets the most recently applied configuration, so that the next
+	// Reconfig re-applies the peer, router and DNS config from scratch.
+	// Unlike Close, the Engine remains usable.
+	Restart() error
+
 	// Close shuts down this wireguard instance, remove any routes
 	// it added, etc. To bring it up again later, you'll need a
 	// new Engine.